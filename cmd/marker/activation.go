@@ -0,0 +1,83 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+const listenFdsStart = 3
+
+// activationListener returns the listener passed by systemd socket
+// activation, nil when the process was not socket activated
+func activationListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count == 0 {
+		return nil, nil
+	}
+	if count != 1 {
+		return nil, fmt.Errorf("expected one socket passed by systemd, got %d", count)
+	}
+	// don't pass the socket to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	syscall.CloseOnExec(listenFdsStart)
+
+	file := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_3")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %v", err)
+	}
+	return listener, nil
+}
+
+// dropPrivileges switches the process to the user and group given as
+// uid[:gid], the group is the uid when not given. Supplementary groups are
+// dropped.
+func dropPrivileges(spec string) error {
+	uidSpec, gidSpec, found := strings.Cut(spec, ":")
+	uid, err := strconv.Atoi(uidSpec)
+	if err != nil || uid < 0 {
+		return fmt.Errorf("invalid user %q", spec)
+	}
+	gid := uid
+	if found {
+		if gid, err = strconv.Atoi(gidSpec); err != nil || gid < 0 {
+			return fmt.Errorf("invalid group %q", spec)
+		}
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("failed to drop supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set group %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set user %d: %v", uid, err)
+	}
+	return nil
+}
@@ -18,16 +18,21 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"reflect"
 	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/wait"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
 	"github.com/golang/glog"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/cache"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/marker"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
 
 const (
@@ -52,12 +57,43 @@ func main() {
 	healthCheckInterval := flag.Int("healthcheck-interval", int(defaultHealthCheckInterval.Seconds()),
 		fmt.Sprintf("health check interval in seconds, %d by default", int(defaultHealthCheckInterval.Seconds())))
 
+	metricsAddress := flag.String("metrics-address", "", "address to serve port utilization and ovsdb metrics on, e.g. :9100, disabled by default")
+
+	ovsdbSlowThreshold := flag.Int("ovsdb-slow-threshold", int(ovsdb.SlowTransactionThreshold.Milliseconds()),
+		fmt.Sprintf("ovsdb transactions slower than this are logged, in milliseconds, %d by default, 0 disables it", ovsdb.SlowTransactionThreshold.Milliseconds()))
+
+	nodeResources := flag.Bool("node-resources", true, "expose bridges as extended resources of the node, enabled by default")
+
+	nodeState := flag.Bool("node-state", false, "publish inventory of OVS on the node in its OVSNodeState resource, disabled by default")
+
+	bridgeHealth := flag.Bool("bridge-health", false, "report zero capacity of bridges whose uplinks are all down, disabled by default")
+
+	devicePlugin := flag.Bool("device-plugin", false, "serve bridges as kubelet device plugin resources whose devices are unhealthy while all uplinks of the bridge are down instead of extended resources of the node, disabled by default")
+	devicePluginDir := flag.String("device-plugin-dir", pluginapi.DevicePluginPath, fmt.Sprintf("device plugin directory of kubelet, %s by default", pluginapi.DevicePluginPath))
+
+	kubeconfig := flag.String("kubeconfig", "", "kubeconfig of the API server, the in-cluster config is used by default")
+
+	runAs := flag.String("run-as", "", "uid[:gid] marker switches to once OVSDB is connected and the metrics socket is bound, e.g. when started by systemd")
+
+	nodeLabelsFile := flag.String("node-labels-file", "", fmt.Sprintf("file labels of the node are written to for bridge templates of the plugin, e.g. %s, disabled by default", config.DefaultNodeLabelsFile))
+
+	cacheDir := flag.String("cache-dir", "", fmt.Sprintf("cache directory of the plugin, e.g. %s mounted from the host, attachments in it are checked against ports in OVSDB and their cached CHECK results are dropped when their ports change, disabled by default", utils.DefaultCacheDir))
+
+	ovsdbKeyPrefix := flag.String("ovsdb-key-prefix", "", "ovsdb_key_prefix of the plugin, prepended to external_ids keys of its ports and bridges, none by default")
+	portStatistics := flag.Bool("port-statistics", false, "expose statistics of ports created by ovs-cni labeled with their pod on the metrics address, disabled by default")
+	repairOrphanPorts := flag.Bool("repair-orphan-ports", false, "remove ports found by the cache check which have no cached attachment and whose interface is gone, disabled by default")
+	backupFailover := flag.Bool("backup-failover", false, "fail active/backup attachments in cache-dir over to their backup bridge while all uplinks of their active bridge are down, disabled by default")
 	flag.Parse()
 
 	if *nodeName == "" {
 		glog.Fatal("node-name must be set")
 	}
 
+	// kubelet reports capacity of device plugin resources itself
+	if *devicePlugin {
+		*nodeResources = false
+	}
+
 	if *ovsSocket == "" {
 		glog.Fatal("ovs-socket must be set")
 	}
@@ -70,33 +106,125 @@ func main() {
 	}
 	endpoint := fmt.Sprintf("%s:%s", socketType, address)
 
-	markerApp, err := marker.NewMarker(*nodeName, endpoint)
+	ovsdb.SlowTransactionThreshold = time.Duration(*ovsdbSlowThreshold) * time.Millisecond
+
+	markerApp, err := marker.NewMarker(*nodeName, endpoint, *kubeconfig)
 	if err != nil {
 		glog.Fatalf("Failed to create a new marker object: %v", err)
 	}
+	markerApp.BridgeHealth = *bridgeHealth
+	markerApp.SetOvsdbKeyPrefix(*ovsdbKeyPrefix)
+
+	// a metrics socket passed by systemd takes precedence over metrics-address
+	metricsListener, err := activationListener()
+	if err != nil {
+		glog.Fatalf("Failed to use socket activation: %v", err)
+	}
+	if metricsListener == nil && *metricsAddress != "" {
+		if metricsListener, err = net.Listen("tcp", *metricsAddress); err != nil {
+			glog.Fatalf("failed to serve metrics on %s: %v", *metricsAddress, err)
+		}
+	}
+
+	if *runAs != "" {
+		if err := dropPrivileges(*runAs); err != nil {
+			glog.Fatalf("Failed to drop privileges: %v", err)
+		}
+		glog.Infof("Running as %s", *runAs)
+	}
 
 	go keepAlive(healthCheckFile, *healthCheckInterval)
 
+	if *cacheDir != "" {
+		if err := markerApp.WatchCheckCache(*cacheDir); err != nil {
+			glog.Errorf("WatchCheckCache failed: %v", err)
+		}
+	}
+	if *backupFailover {
+		if *cacheDir == "" {
+			glog.Fatal("backup-failover requires cache-dir")
+		}
+		if err := markerApp.WatchBackupFailover(*cacheDir); err != nil {
+			glog.Errorf("WatchBackupFailover failed: %v", err)
+		}
+	}
+
+	if *devicePlugin {
+		if err := markerApp.WatchDevicePlugins(); err != nil {
+			glog.Errorf("WatchDevicePlugins failed: %v", err)
+		}
+	}
+
+	if metricsListener != nil {
+		go serveMetrics(metricsListener, markerApp.MetricsHandler())
+	}
+
 	markerCache := cache.Cache{}
 	wait.JitterUntil(func() {
-		jitteredReconcileInterval := wait.Jitter(time.Duration(*reconcileInterval)*time.Minute, 1.2)
-		shouldReconcileNode := time.Since(markerCache.LastRefreshTime()) >= jitteredReconcileInterval
-		if shouldReconcileNode {
-			reportedBridges, err := markerApp.GetReportedResources()
+		if *nodeResources {
+			jitteredReconcileInterval := wait.Jitter(time.Duration(*reconcileInterval)*time.Minute, 1.2)
+			shouldReconcileNode := time.Since(markerCache.LastRefreshTime()) >= jitteredReconcileInterval
+			if shouldReconcileNode {
+				reportedBridges, err := markerApp.GetReportedResources()
+				if err != nil {
+					glog.Errorf("GetReportedResources failed: %v", err)
+				}
+
+				if !reflect.DeepEqual(markerCache.Bridges(), reportedBridges) {
+					glog.Warningf("cached bridges are different than the reported bridges on node %s", *nodeName)
+				}
+
+				markerCache.Refresh(reportedBridges)
+			}
+
+			err := markerApp.Update(&markerCache)
 			if err != nil {
-				glog.Errorf("GetReportedResources failed: %v", err)
+				glog.Fatalf("Update failed: %v", err)
+			}
+		}
+
+		if *devicePlugin {
+			if err := markerApp.UpdateDevicePlugins(*devicePluginDir); err != nil {
+				glog.Errorf("UpdateDevicePlugins failed: %v", err)
+			}
+		}
+
+		if err := markerApp.UpdatePortUtilization(); err != nil {
+			glog.Errorf("UpdatePortUtilization failed: %v", err)
+		}
+
+		if err := markerApp.UpdateBridgeVLANs(); err != nil {
+			glog.Errorf("UpdateBridgeVLANs failed: %v", err)
+		}
+
+		if *nodeState {
+			if err := markerApp.UpdateNodeState(); err != nil {
+				glog.Errorf("UpdateNodeState failed: %v", err)
+			}
+		}
+
+		if *nodeLabelsFile != "" {
+			if err := markerApp.WriteNodeLabels(*nodeLabelsFile); err != nil {
+				glog.Errorf("WriteNodeLabels failed: %v", err)
 			}
+		}
 
-			if !reflect.DeepEqual(markerCache.Bridges(), reportedBridges) {
-				glog.Warningf("cached bridges are different than the reported bridges on node %s", *nodeName)
+		if *cacheDir != "" {
+			if err := markerApp.CheckCacheConsistency(*cacheDir, *repairOrphanPorts); err != nil {
+				glog.Errorf("CheckCacheConsistency failed: %v", err)
 			}
+		}
 
-			markerCache.Refresh(reportedBridges)
+		if *backupFailover {
+			if err := markerApp.FailoverBackupAttachments(*cacheDir); err != nil {
+				glog.Errorf("FailoverBackupAttachments failed: %v", err)
+			}
 		}
 
-		err := markerApp.Update(&markerCache)
-		if err != nil {
-			glog.Fatalf("Update failed: %v", err)
+		if *portStatistics {
+			if err := markerApp.UpdatePortStatistics(); err != nil {
+				glog.Errorf("UpdatePortStatistics failed: %v", err)
+			}
 		}
 
 	}, time.Duration(*updateInterval)*time.Second, 1.2, true, wait.NeverStop)
@@ -123,6 +251,14 @@ func keepAlive(healthCheckFile string, healthCheckInterval int) {
 	}, time.Duration(healthCheckInterval)*time.Second)
 }
 
+func serveMetrics(listener net.Listener, handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	if err := http.Serve(listener, mux); err != nil {
+		glog.Fatalf("failed to serve metrics on %s: %v", listener.Addr(), err)
+	}
+}
+
 /*
 takes an OVS socket string and returns the socket
 type, address, and any parsing error.
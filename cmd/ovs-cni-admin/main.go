@@ -0,0 +1,177 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/plugin"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// command is a subcommand of ovs-cni-admin
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"set-vlan":            {"change VLAN ID and trunks of a running attachment", setVlan},
+	"migrate-from-bridge": {"move a running attachment of the Linux bridge plugin to OVS", migrateFromBridge},
+	"rebuild-cache":       {"recreate lost cache entries of attachments from their OVS ports", rebuildCache},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, found := commands[os.Args[1]]
+	if !found {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].description)
+	}
+}
+
+func setVlan(args []string) error {
+	flags := flag.NewFlagSet("set-vlan", flag.ExitOnError)
+	network := flags.String("network", "", "name of the network of the attachment")
+	containerID := flags.String("container-id", "", "ID of the container of the attachment")
+	ifName := flags.String("ifname", "", "name of the interface of the attachment in the container")
+	vlan := flags.Int("vlan", -1, "VLAN ID of the port, -1 for none")
+	trunk := flags.String("trunk", "", "comma separated VLAN IDs and ranges trunked by the port, e.g. 10,20-30")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *network == "" || *containerID == "" || *ifName == "" {
+		return fmt.Errorf("network, container-id and ifname must be set")
+	}
+
+	var vlanTag *uint
+	if *vlan >= 0 {
+		tag := uint(*vlan)
+		vlanTag = &tag
+	}
+	var trunks []*types.Trunk
+	if *trunk != "" {
+		var err error
+		if trunks, err = config.ParseTrunks(*trunk); err != nil {
+			return err
+		}
+	}
+	return plugin.UpdateAttachmentVlan(*network, *containerID, *ifName, vlanTag, trunks)
+}
+
+func migrateFromBridge(args []string) error {
+	flags := flag.NewFlagSet("migrate-from-bridge", flag.ExitOnError)
+	configPath := flags.String("config", "", "file of the ovs-cni netconf the attachment is migrated to")
+	bridgeConfigPath := flags.String("bridge-config", "", "file of the netconf of the bridge plugin, its IPAM releases addresses of the attachment on DEL")
+	containerID := flags.String("container-id", "", "ID of the container of the attachment")
+	ifName := flags.String("ifname", "", "name of the interface of the attachment in the container")
+	netnsPath := flags.String("netns", "", "path of the network namespace of the container")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" || *containerID == "" || *ifName == "" || *netnsPath == "" {
+		return fmt.Errorf("config, container-id, ifname and netns must be set")
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	netconf, err := config.LoadConf(data)
+	if err != nil {
+		return err
+	}
+	var bridgeData []byte
+	if *bridgeConfigPath != "" {
+		if bridgeData, err = os.ReadFile(*bridgeConfigPath); err != nil {
+			return err
+		}
+	}
+	return plugin.MigrateFromLinuxBridge(netconf, *containerID, *ifName, *netnsPath, bridgeData)
+}
+
+func rebuildCache(args []string) error {
+	flags := flag.NewFlagSet("rebuild-cache", flag.ExitOnError)
+	socketFile := flags.String("socket-file", "", "OVSDB socket, the default one of the plugin when empty")
+	configDir := flags.String("config-dir", "", "directory of files with ovs-cni netconfs of the networks of the attachments, they are cached as the netconfs of the attachments")
+	keyPrefix := flags.String("ovsdb-key-prefix", "", "ovsdb_key_prefix of the plugin, prepended to external_ids keys of its ports")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	netconfs := map[string]*types.NetConf{}
+	if *configDir != "" {
+		files, err := os.ReadDir(*configDir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(*configDir, file.Name()))
+			if err != nil {
+				return err
+			}
+			netconf, err := config.LoadConf(data)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ignoring %s: %v\n", file.Name(), err)
+				continue
+			}
+			netconfs[netconf.Name] = netconf
+		}
+	}
+
+	rebuilt, err := plugin.RebuildCache(*socketFile, *keyPrefix, netconfs)
+	if rebuilt != nil {
+		for _, key := range rebuilt.Restored {
+			fmt.Printf("restored %s\n", key)
+		}
+		ports := make([]string, 0, len(rebuilt.Skipped))
+		for port := range rebuilt.Skipped {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+		for _, port := range ports {
+			fmt.Printf("skipped port %s: %s\n", port, rebuilt.Skipped[port])
+		}
+	}
+	return err
+}
@@ -19,13 +19,16 @@ import (
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/utils/buildversion"
 
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/health"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/plugin"
 )
 
 func main() {
 	skel.PluginMainFuncs(skel.CNIFuncs{
-		Add:   plugin.CmdAdd,
-		Check: plugin.CmdCheck,
-		Del:   plugin.CmdDel,
+		Add:    health.Wrap("ADD", plugin.CmdAdd),
+		Check:  health.Wrap("CHECK", plugin.CmdCheck),
+		Del:    health.Wrap("DEL", plugin.CmdDel),
+		GC:     health.Wrap("GC", plugin.CmdGC),
+		Status: health.Wrap("STATUS", plugin.CmdStatus),
 	}, version.All, buildversion.BuildString("OVS bridge"))
 }
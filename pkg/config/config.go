@@ -20,10 +20,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 
 	"dario.cat/mergo"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
@@ -32,11 +35,100 @@ import (
 
 const (
 	linkstateCheckRetries  = 5
-	linkStateCheckInterval = 600 // in milliseconds
+	linkStateCheckInterval = 600  // in milliseconds
+	retainOnDeleteTimeout  = 3600 // in seconds
+	delBridgeRetryInterval = 1000 // in milliseconds
+	captureDuration        = 60   // in seconds
+	hookTimeout            = 10   // in seconds
+	rateLimitMinBurst      = 16   // in kilobits
+	probeCount             = 3
+	probeTimeout           = 1000 // in milliseconds
+	garpCount              = 3
+	garpInterval           = 200  // in milliseconds
+	ipConflictTimeout      = 1000 // in milliseconds
+	bondMiimon             = 100  // in milliseconds
+
+	// staticIPAMType is the IPAM plugin the inline static block is passed to
+	staticIPAMType = "static"
+
+	// DefaultVhostUserSocketDir is the parent of vhost-user socket directories
+	DefaultVhostUserSocketDir = "/var/run/ovs-cni/vhostuser"
+	// DefaultNodeLabelsFile holds labels of the node, as written by marker
+	DefaultNodeLabelsFile = "/var/run/ovs-cni/node-labels"
+)
+
+// HooksDir holds the hook executables, only hooks in it can be run
+var HooksDir = "/etc/cni/net.d/ovs.d/hooks"
+
+// Option changes how LoadConf and the cache loading functions load a
+// netconf, for projects embedding the configuration handling of ovs-cni.
+// Loading fails when an option doesn't apply to the function.
+type Option func(*loadOptions)
+
+type loadOptions struct {
+	defaults bool
+	strict   bool
+	cacheDir string
+	// names of the options given
+	given []string
+}
+
+const (
+	optionDefaults         = "WithDefaults"
+	optionStrictValidation = "WithStrictValidation"
+	optionCacheDir         = "WithCacheDir"
 )
 
-// LoadConf parses and validates stdin netconf and returns NetConf object
-func LoadConf(data []byte) (*types.NetConf, error) {
+// newLoadOptions applies opts given to the function fn, only the applicable
+// options are accepted
+func newLoadOptions(fn string, opts []Option, applicable ...string) (*loadOptions, error) {
+	options := &loadOptions{defaults: true}
+	for _, opt := range opts {
+		opt(options)
+	}
+	for _, name := range options.given {
+		if !slices.Contains(applicable, name) {
+			return nil, fmt.Errorf("option %s doesn't apply to %s", name, fn)
+		}
+	}
+	return options, nil
+}
+
+// WithDefaults sets whether unset fields get their default values, they do
+// unless it is disabled. It applies to LoadConf.
+func WithDefaults(enabled bool) Option {
+	return func(options *loadOptions) {
+		options.defaults = enabled
+		options.given = append(options.given, optionDefaults)
+	}
+}
+
+// WithStrictValidation makes LoadConf fail with ValidationErrors when the
+// loaded netconf is not valid. It applies to LoadConf.
+func WithStrictValidation() Option {
+	return func(options *loadOptions) {
+		options.strict = true
+		options.given = append(options.given, optionStrictValidation)
+	}
+}
+
+// WithCacheDir makes the cache loading functions use the cache in the
+// directory instead of the default cache directory of the plugin
+func WithCacheDir(dir string) Option {
+	return func(options *loadOptions) {
+		options.cacheDir = dir
+		options.given = append(options.given, optionCacheDir)
+	}
+}
+
+// LoadConf parses stdin netconf, merged with the flat configuration file of
+// the plugin, and returns NetConf object. It is validated only
+// WithStrictValidation, the plugin validates it after CNI_ARGS are applied.
+func LoadConf(data []byte, opts ...Option) (*types.NetConf, error) {
+	options, err := newLoadOptions("LoadConf", opts, optionDefaults, optionStrictValidation)
+	if err != nil {
+		return nil, err
+	}
 	netconf, err := loadNetConf(data)
 	if err != nil {
 		return nil, err
@@ -45,11 +137,27 @@ func LoadConf(data []byte) (*types.NetConf, error) {
 	if err != nil {
 		return nil, err
 	}
+	// mergo takes disabled gates for unset ones, gates of the netconf win
+	featureGates := maps.Clone(netconf.FeatureGates)
 	netconf, err = mergeConf(netconf, flatNetConf)
 	if err != nil {
 		return nil, err
 	}
+	maps.Copy(netconf.FeatureGates, featureGates)
+
+	if options.defaults {
+		ApplyDefaults(netconf)
+	}
+	if options.strict {
+		if err := Validate(netconf); err != nil {
+			return nil, err
+		}
+	}
+	return netconf, nil
+}
 
+// ApplyDefaults sets default values of unset fields of the netconf
+func ApplyDefaults(netconf *types.NetConf) {
 	if netconf.LinkStateCheckRetries == 0 {
 		netconf.LinkStateCheckRetries = linkstateCheckRetries
 	}
@@ -57,7 +165,117 @@ func LoadConf(data []byte) (*types.NetConf, error) {
 	if netconf.LinkStateCheckInterval == 0 {
 		netconf.LinkStateCheckInterval = linkStateCheckInterval
 	}
-	return netconf, nil
+
+	if netconf.RetainOnDeleteTimeout == 0 {
+		netconf.RetainOnDeleteTimeout = retainOnDeleteTimeout
+	}
+
+	if netconf.DelBridgeRetryInterval == 0 {
+		netconf.DelBridgeRetryInterval = delBridgeRetryInterval
+	}
+
+	if netconf.Capture != nil && netconf.Capture.Duration == 0 {
+		netconf.Capture.Duration = captureDuration
+	}
+
+	if netconf.Hooks != nil && netconf.Hooks.Timeout == 0 {
+		netconf.Hooks.Timeout = hookTimeout
+	}
+
+	if netconf.Probe != nil && netconf.Probe.Count == 0 {
+		netconf.Probe.Count = probeCount
+	}
+
+	if netconf.Probe != nil && netconf.Probe.Timeout == 0 {
+		netconf.Probe.Timeout = probeTimeout
+	}
+
+	if netconf.Garp != nil && netconf.Garp.Count == 0 {
+		netconf.Garp.Count = garpCount
+	}
+
+	if netconf.Garp != nil && netconf.Garp.Interval == 0 {
+		netconf.Garp.Interval = garpInterval
+	}
+
+	if netconf.IPConflict != nil && netconf.IPConflict.Timeout == 0 {
+		netconf.IPConflict.Timeout = ipConflictTimeout
+	}
+
+	if netconf.RateLimit != nil && netconf.RateLimit.Burst == 0 {
+		netconf.RateLimit.Burst = DefaultRateLimitBurst(netconf.RateLimit.Rate)
+	}
+
+	if netconf.IngressRateLimit != nil && netconf.IngressRateLimit.Burst == 0 {
+		netconf.IngressRateLimit.Burst = DefaultRateLimitBurst(netconf.IngressRateLimit.Rate)
+	}
+
+	// the first VF of a bonded attachment selects the bridge like deviceID
+	if len(netconf.DeviceIDs) > 0 && netconf.DeviceID == "" {
+		netconf.DeviceID = netconf.DeviceIDs[0]
+	}
+
+	if netconf.Bond != nil {
+		if netconf.Bond.Mode == "" {
+			netconf.Bond.Mode = BondModeActiveBackup
+		}
+		if netconf.Bond.Miimon == nil {
+			miimon := uint(bondMiimon)
+			netconf.Bond.Miimon = &miimon
+		}
+	}
+
+	if netconf.InterfaceType == VhostUserInterfaceType {
+		if netconf.VhostUser == nil {
+			netconf.VhostUser = &types.VhostUser{}
+		}
+		if netconf.VhostUser.SocketDir == "" {
+			netconf.VhostUser.SocketDir = DefaultVhostUserSocketDir
+		}
+	}
+}
+
+// DefaultRateLimitBurst returns the burst of a rate limit without one, in
+// kilobits for the rate in kbps
+func DefaultRateLimitBurst(rate uint) uint {
+	return max(rate/10, rateLimitMinBurst)
+}
+
+// ExpandStaticIPAM turns the inline static block of the netconf into the ipam
+// block of the static IPAM plugin, so IPAM plugin calls get the configuration
+// they expect. The netconf is returned unchanged without the static block.
+func ExpandStaticIPAM(data []byte) ([]byte, error) {
+	conf := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	rawStatic, ok := conf["static"]
+	if !ok {
+		return data, nil
+	}
+	if rawIPAM, ok := conf["ipam"]; ok {
+		ipam := cnitypes.IPAM{}
+		if err := json.Unmarshal(rawIPAM, &ipam); err != nil {
+			return nil, fmt.Errorf("failed to load ipam: %v", err)
+		}
+		if ipam.Type != "" {
+			return nil, fmt.Errorf("static can't be used with ipam of type %q", ipam.Type)
+		}
+	}
+
+	static := &types.Static{}
+	if err := json.Unmarshal(rawStatic, static); err != nil {
+		return nil, fmt.Errorf("failed to load static: %v", err)
+	}
+	ipam, err := json.Marshal(struct {
+		Type string `json:"type"`
+		*types.Static
+	}{Type: staticIPAMType, Static: static})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ipam: %v", err)
+	}
+	conf["ipam"] = ipam
+	return json.Marshal(conf)
 }
 
 // LoadMirrorConf parses and validates stdin netconf and returns MirrorNetConf object
@@ -78,40 +296,90 @@ func LoadMirrorConf(data []byte) (*types.MirrorNetConf, error) {
 }
 
 // LoadPrevResultConfFromCache retrieve preResult config from cache
-func LoadPrevResultConfFromCache(cRef string) (*types.CachedPrevResultNetConf, error) {
-	netCache := &types.CachedPrevResultNetConf{}
-	netConfBytes, err := utils.ReadCache(cRef)
+func LoadPrevResultConfFromCache(cRef string, opts ...Option) (*types.CachedPrevResultNetConf, error) {
+	options, err := newLoadOptions("LoadPrevResultConfFromCache", opts, optionCacheDir)
 	if err != nil {
-		return nil, fmt.Errorf("error reading cached prevResult conf with name %s: %v", cRef, err)
+		return nil, err
 	}
-
-	if err = json.Unmarshal(netConfBytes, netCache); err != nil {
-		return nil, fmt.Errorf("failed to parse prevResult conf: %v", err)
+	netCache, err := utils.NewStore[types.CachedPrevResultNetConf](options.cacheDir, "", 0).Load(cRef)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cached prevResult conf with name %s: %v", cRef, err)
 	}
-
 	return netCache, nil
 }
 
 // LoadConfFromCache retrieve net config from cache
-func LoadConfFromCache(cRef string) (*types.CachedNetConf, error) {
-	netCache := &types.CachedNetConf{}
-	netConfBytes, err := utils.ReadCache(cRef)
+func LoadConfFromCache(cRef string, opts ...Option) (*types.CachedNetConf, error) {
+	options, err := newLoadOptions("LoadConfFromCache", opts, optionCacheDir)
 	if err != nil {
-		return nil, fmt.Errorf("error reading cached NetConf with name %s: %v", cRef, err)
+		return nil, err
 	}
-
-	if err = json.Unmarshal(netConfBytes, netCache); err != nil {
-		return nil, fmt.Errorf("failed to parse NetConf: %v", err)
+	netCache, err := utils.NewStore[types.CachedNetConf](options.cacheDir, "", 0).Load(cRef)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cached NetConf with name %s: %v", cRef, err)
 	}
-
 	return netCache, nil
 }
 
+// CheckCacheKeyPrefix is prepended to cache keys of attachments which passed
+// CHECK, they are hidden like other cache entries which aren't attachments
+const CheckCacheKeyPrefix = ".check-"
+
 // GetCRef unique identifier for a container interface
 func GetCRef(cid, podIfName string) string {
 	return strings.Join([]string{cid, podIfName}, "-")
 }
 
+// GetNetworkCRef unique identifier for a container interface attached to the given network
+func GetNetworkCRef(netName, cid, podIfName string) string {
+	return strings.Join([]string{netName, cid, podIfName}, "-")
+}
+
+// LoadNetworkConfFromCache retrieves cached NetConf of a container interface
+// attached to the given network and returns it with its cache key. Entries
+// saved by older versions under the key without the network name are moved
+// to the new key.
+func LoadNetworkConfFromCache(netName, cid, podIfName string, opts ...Option) (*types.CachedNetConf, string, error) {
+	options, err := newLoadOptions("LoadNetworkConfFromCache", opts, optionCacheDir)
+	if err != nil {
+		return nil, "", err
+	}
+	store := utils.NewStore[types.CachedNetConf](options.cacheDir, "", 0)
+	cRef := GetNetworkCRef(netName, cid, podIfName)
+	netCache, err := store.Load(cRef)
+	if err == nil {
+		return netCache, cRef, nil
+	}
+	err = fmt.Errorf("error reading cached NetConf with name %s: %v", cRef, err)
+
+	legacyCRef := GetCRef(cid, podIfName)
+	netCache, legacyErr := store.Load(legacyCRef)
+	if legacyErr != nil {
+		return nil, "", err
+	}
+	if netCache.Netconf != nil && netCache.Netconf.Name != netName {
+		// the legacy entry belongs to an attachment of another network
+		return nil, "", err
+	}
+	if err := store.Save(cRef, netCache); err != nil {
+		return nil, "", fmt.Errorf("failed to migrate cached NetConf %s: %v", legacyCRef, err)
+	}
+	if err := store.Delete(legacyCRef); err != nil {
+		return nil, "", fmt.Errorf("failed to migrate cached NetConf %s: %v", legacyCRef, err)
+	}
+	return netCache, cRef, nil
+}
+
+// GetNetworkName returns name of the network from the configuration
+// passed on stdin, empty string when it can't be parsed
+func GetNetworkName(data []byte) string {
+	netconf := &cnitypes.NetConf{}
+	if err := json.Unmarshal(data, netconf); err != nil {
+		return ""
+	}
+	return netconf.Name
+}
+
 func loadNetConf(bytes []byte) (*types.NetConf, error) {
 	netconf := &types.NetConf{}
 	if err := json.Unmarshal(bytes, netconf); err != nil {
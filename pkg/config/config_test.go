@@ -0,0 +1,189 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadConf", func() {
+	conf := []byte(`{"name": "net1", "type": "ovs", "bridge": "br1", "vlan": 5000, "probe": {}, "garp": {}, "ip_conflict": {}}`)
+
+	It("should fill in defaults", func() {
+		netconf, err := LoadConf(conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netconf.LinkStateCheckRetries).To(Equal(linkstateCheckRetries))
+		Expect(netconf.Probe.Count).To(Equal(probeCount))
+		Expect(netconf.Garp.Count).To(Equal(garpCount))
+		Expect(netconf.Garp.Interval).To(Equal(garpInterval))
+		Expect(netconf.IPConflict.Timeout).To(Equal(ipConflictTimeout))
+	})
+	It("should leave unset fields without defaults", func() {
+		netconf, err := LoadConf(conf, WithDefaults(false))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netconf.LinkStateCheckRetries).To(BeZero())
+		Expect(netconf.Probe.Count).To(BeZero())
+	})
+	It("should validate the netconf when strict", func() {
+		_, err := LoadConf(conf, WithStrictValidation())
+		Expect(err).To(MatchError(ContainSubstring("$.vlan: must be in range 0 to 4095")))
+		Expect(err).To(BeAssignableToTypeOf(ValidationErrors{}))
+	})
+	It("should merge feature gates of the flat configuration file", func() {
+		flatConf := filepath.Join(GinkgoT().TempDir(), "ovs.conf")
+		Expect(os.WriteFile(flatConf, []byte(`{"featureGates": {"BondedVFs": true}}`), 0600)).To(Succeed())
+		netconf, err := LoadConf([]byte(`{"name": "net1", "type": "ovs", "bridge": "br1", "configuration_path": "` + flatConf + `"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(FeatureEnabled(netconf, FeatureBondedVFs)).To(BeTrue())
+		netconf, err = LoadConf([]byte(`{"name": "net1", "type": "ovs", "bridge": "br1", "configuration_path": "` + flatConf + `", "featureGates": {"BondedVFs": false}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(FeatureEnabled(netconf, FeatureBondedVFs)).To(BeFalse())
+	})
+	It("should read cached netconfs from the given directory", func() {
+		cacheDir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(cacheDir, "net1-c1-eth0"), []byte(`{"Netconf": {"name": "net1", "bridge": "br1"}, "IfName": "eth0"}`), 0600)).To(Succeed())
+		cache, err := LoadConfFromCache("net1-c1-eth0", WithCacheDir(cacheDir))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cache.Netconf.Name).To(Equal("net1"))
+		Expect(cache.Netconf.BrName).To(Equal("br1"))
+		Expect(cache.IfName).To(Equal("eth0"))
+		_, err = LoadConfFromCache("net1-c2-eth0", WithCacheDir(cacheDir))
+		Expect(err).To(MatchError(ContainSubstring("not found")))
+	})
+	It("should migrate legacy cached netconfs in the given directory", func() {
+		cacheDir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(cacheDir, "c1-eth0"), []byte(`{"Netconf": {"name": "net1", "bridge": "br1"}, "IfName": "eth0"}`), 0600)).To(Succeed())
+		_, _, err := LoadNetworkConfFromCache("net2", "c1", "eth0", WithCacheDir(cacheDir))
+		Expect(err).To(MatchError(ContainSubstring("not found")))
+
+		cache, cRef, err := LoadNetworkConfFromCache("net1", "c1", "eth0", WithCacheDir(cacheDir))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cRef).To(Equal("net1-c1-eth0"))
+		Expect(cache.Netconf.BrName).To(Equal("br1"))
+		Expect(filepath.Join(cacheDir, "net1-c1-eth0")).To(BeAnExistingFile())
+		Expect(filepath.Join(cacheDir, "c1-eth0")).NotTo(BeAnExistingFile())
+	})
+	It("should reject options which don't apply", func() {
+		_, err := LoadConf(conf, WithCacheDir(GinkgoT().TempDir()))
+		Expect(err).To(MatchError("option WithCacheDir doesn't apply to LoadConf"))
+		_, err = LoadConfFromCache("net1-c1-eth0", WithStrictValidation())
+		Expect(err).To(MatchError("option WithStrictValidation doesn't apply to LoadConfFromCache"))
+		_, err = LoadPrevResultConfFromCache("net1-c1-eth0", WithDefaults(false))
+		Expect(err).To(MatchError("option WithDefaults doesn't apply to LoadPrevResultConfFromCache"))
+		_, _, err = LoadNetworkConfFromCache("net1", "c1", "eth0", WithDefaults(true))
+		Expect(err).To(MatchError("option WithDefaults doesn't apply to LoadNetworkConfFromCache"))
+	})
+})
+
+var _ = Describe("ExpandStaticIPAM", func() {
+	It("should pass inline static addresses to the static IPAM plugin", func() {
+		data, err := ExpandStaticIPAM([]byte(`{"name": "net1", "type": "ovs", "bridge": "br1", "static": {"addresses": [{"address": "10.1.0.5/24", "gateway": "10.1.0.1"}], "routes": [{"dst": "0.0.0.0/0"}], "dns": {"nameservers": ["10.1.0.1"]}}}`))
+		Expect(err).NotTo(HaveOccurred())
+		netconf, err := LoadConf(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netconf.IPAM.Type).To(Equal("static"))
+		Expect(netconf.BrName).To(Equal("br1"))
+		Expect(data).To(MatchJSON(`{"name": "net1", "type": "ovs", "bridge": "br1",
+			"static": {"addresses": [{"address": "10.1.0.5/24", "gateway": "10.1.0.1"}], "routes": [{"dst": "0.0.0.0/0"}], "dns": {"nameservers": ["10.1.0.1"]}},
+			"ipam": {"type": "static", "addresses": [{"address": "10.1.0.5/24", "gateway": "10.1.0.1"}], "routes": [{"dst": "0.0.0.0/0"}], "dns": {"nameservers": ["10.1.0.1"]}}}`))
+	})
+	It("should leave netconfs without the static block unchanged", func() {
+		conf := []byte(`{"name": "net1", "type": "ovs", "ipam": {"type": "host-local"}}`)
+		Expect(ExpandStaticIPAM(conf)).To(Equal(conf))
+	})
+	It("should refuse static with another IPAM plugin", func() {
+		_, err := ExpandStaticIPAM([]byte(`{"name": "net1", "type": "ovs", "ipam": {"type": "host-local"}, "static": {"addresses": [{"address": "10.1.0.5/24"}]}}`))
+		Expect(err).To(MatchError(ContainSubstring("static can't be used with ipam of type \"host-local\"")))
+	})
+})
+
+var _ = Describe("ParseTrunks", func() {
+	It("should parse VLAN IDs and ranges", func() {
+		trunks, err := ParseTrunks("10,20-30")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trunks).To(HaveLen(2))
+		Expect(*trunks[0].ID).To(Equal(uint(10)))
+		Expect(trunks[0].MinID).To(BeNil())
+		Expect(*trunks[1].MinID).To(Equal(uint(20)))
+		Expect(*trunks[1].MaxID).To(Equal(uint(30)))
+	})
+	It("should refuse malformed entries", func() {
+		_, err := ParseTrunks("10,x-20")
+		Expect(err).To(MatchError(`invalid VLAN ID "x" in trunk "10,x-20"`))
+		_, err = ParseTrunks("30-20")
+		Expect(err).To(MatchError(`invalid VLAN range "30-20" in trunk "30-20"`))
+		_, err = ParseTrunks("4096")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ExpandPortNameTemplate", func() {
+	fields := map[string]string{
+		PortNameFieldPodName:      "frontend-7d9f8b6c5-x2x4z",
+		PortNameFieldPodNamespace: "default",
+		PortNameFieldIfName:       "net1",
+		PortNameFieldNetwork:      "ovs/vlan100",
+		PortNameFieldHash:         "0123456789",
+	}
+	It("should replace fields by their values", func() {
+		Expect(ExpandPortNameTemplate("veth{hash}", fields, MaxPortNameLength)).To(Equal("veth0123456789"))
+		Expect(ExpandPortNameTemplate("veth{network}", fields, MaxPortNameLength)).To(Equal("vethovs-vlan100"))
+	})
+	It("should truncate fields marked trunc so the name fits", func() {
+		Expect(ExpandPortNameTemplate("veth{podName trunc}-{ifname}", fields, MaxPortNameLength)).To(Equal("vethfronte-net1"))
+		Expect(ExpandPortNameTemplate("veth{podNamespace trunc}.{podName trunc}", fields, MaxPortNameLength)).To(Equal("vethdefau.front"))
+		Expect(ExpandPortNameTemplate("veth{podName trunc}-{ifname}", fields, MaxPortNameLength-1)).To(Equal("vethfront-net1"))
+	})
+	It("should refuse names which do not fit", func() {
+		_, err := ExpandPortNameTemplate("veth{podName}-{ifname}", fields, MaxPortNameLength)
+		Expect(err).To(MatchError(`port name of template "veth{podName}-{ifname}" exceeds 15 characters`))
+	})
+	It("should refuse fields without value", func() {
+		_, err := ExpandPortNameTemplate("veth{containerID trunc}", fields, MaxPortNameLength)
+		Expect(err).To(MatchError(`no value of {containerID} of port name template "veth{containerID trunc}"`))
+	})
+	It("should refuse invalid templates", func() {
+		_, err := ExpandPortNameTemplate("veth{pod}", fields, MaxPortNameLength)
+		Expect(err).To(MatchError(ContainSubstring("unknown placeholder {pod}")))
+		_, err = ExpandPortNameTemplate("vethp/{ifname}", fields, MaxPortNameLength)
+		Expect(err).To(MatchError(ContainSubstring("invalid characters")))
+		_, err = ExpandPortNameTemplate("veth{ifname", fields, MaxPortNameLength)
+		Expect(err).To(MatchError(ContainSubstring("unbalanced braces")))
+		_, err = ExpandPortNameTemplate("{podName trunc}-{ifname}", fields, MaxPortNameLength)
+		Expect(err).To(MatchError(`"{podName trunc}-{ifname}" doesn't start with "veth"`))
+	})
+})
+
+var _ = Describe("ExpandBridgeTemplate", func() {
+	labels := map[string]string{"topology.kubernetes.io/zone": "zone-a", "rack": "r1"}
+	It("should replace placeholders by labels of the node", func() {
+		Expect(ExpandBridgeTemplate("br-{label:topology.kubernetes.io/zone}-{label:rack}", labels)).To(Equal("br-zone-a-r1"))
+	})
+	It("should refuse labels missing on the node", func() {
+		_, err := ExpandBridgeTemplate("br-{label:pod}", labels)
+		Expect(err).To(MatchError(`node has no labels [pod] of bridge "br-{label:pod}"`))
+	})
+	It("should read labels written in the format of downward API volumes", func() {
+		data := FormatNodeLabels(labels)
+		Expect(string(data)).To(Equal("rack=\"r1\"\ntopology.kubernetes.io/zone=\"zone-a\"\n"))
+		Expect(ParseNodeLabels(data)).To(Equal(labels))
+		_, err := ParseNodeLabels([]byte("rack=r1\n"))
+		Expect(err).To(MatchError(ContainSubstring("malformed value of label rack")))
+	})
+})
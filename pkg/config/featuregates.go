@@ -0,0 +1,52 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"sort"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// Feature gates of the plugin
+const (
+	// FeatureBondedVFs allows deviceIDs, attachments bonding two VFs
+	FeatureBondedVFs = "BondedVFs"
+)
+
+// featureGateDefaults holds known feature gates and whether they are enabled
+// when featureGates doesn't set them. New subsystems ship disabled and are
+// enabled per cluster, e.g. in the flat configuration file.
+var featureGateDefaults = map[string]bool{
+	FeatureBondedVFs: false,
+}
+
+// FeatureEnabled returns true when the feature gate is enabled for the netconf
+func FeatureEnabled(netconf *types.NetConf, gate string) bool {
+	if enabled, found := netconf.FeatureGates[gate]; found {
+		return enabled
+	}
+	return featureGateDefaults[gate]
+}
+
+// knownFeatureGates returns names of known feature gates in alphabetical order
+func knownFeatureGates() []string {
+	gates := make([]string, 0, len(featureGateDefaults))
+	for gate := range featureGateDefaults {
+		gates = append(gates, gate)
+	}
+	sort.Strings(gates)
+	return gates
+}
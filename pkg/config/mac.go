@@ -0,0 +1,67 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseMACPrefix parses a MAC address prefix of 1 to 6 octets, e.g. 0a:58
+func ParseMACPrefix(prefix string) (net.HardwareAddr, error) {
+	octets := strings.Split(prefix, ":")
+	if prefix == "" || len(octets) > 6 {
+		return nil, fmt.Errorf("invalid MAC prefix %q", prefix)
+	}
+	// complete the prefix to a full address, so it can be parsed
+	padded := append(octets, make([]string, 6-len(octets))...)
+	for i := len(octets); i < 6; i++ {
+		padded[i] = "00"
+	}
+	mac, err := net.ParseMAC(strings.Join(padded, ":"))
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid MAC prefix %q", prefix)
+	}
+	return mac[:len(octets)], nil
+}
+
+// MACAllowed returns true when the MAC address starts with one of the prefixes,
+// or when no prefixes are given
+func MACAllowed(mac net.HardwareAddr, prefixes []net.HardwareAddr) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(mac, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedMACPrefixes returns parsed allowed_mac_prefixes of the netconf
+func AllowedMACPrefixes(prefixes []string) ([]net.HardwareAddr, error) {
+	parsed := make([]net.HardwareAddr, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		mac, err := ParseMACPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, mac)
+	}
+	return parsed, nil
+}
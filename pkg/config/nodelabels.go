@@ -0,0 +1,124 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// labelPlaceholderPrefix starts placeholders of the bridge replaced by a
+// label of the node, e.g. {label:topology.kubernetes.io/zone}
+const labelPlaceholderPrefix = "label:"
+
+// placeholderPattern matches placeholders of the bridge template
+var placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// IsBridgeTemplate returns true when the bridge contains placeholders
+func IsBridgeTemplate(bridge string) bool {
+	return strings.ContainsAny(bridge, "{}")
+}
+
+// ExpandBridgeTemplate replaces {label:<key>} placeholders of the bridge by
+// values of labels of the node, so one network maps to different bridges on
+// nodes of different failure domains
+func ExpandBridgeTemplate(template string, labels map[string]string) (string, error) {
+	if err := validateBridgeTemplate(template); err != nil {
+		return "", err
+	}
+	var missing []string
+	bridge := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		key := strings.TrimPrefix(placeholder[1:len(placeholder)-1], labelPlaceholderPrefix)
+		value, found := labels[key]
+		if !found {
+			missing = append(missing, key)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("node has no labels %v of bridge %q", missing, template)
+	}
+	return bridge, nil
+}
+
+// validateBridgeTemplate checks that all placeholders of the bridge name a
+// label and braces are balanced
+func validateBridgeTemplate(template string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !strings.HasPrefix(match[1], labelPlaceholderPrefix) || len(match[1]) == len(labelPlaceholderPrefix) {
+			return fmt.Errorf("placeholder {%s} must be {%s<key>}", match[1], labelPlaceholderPrefix)
+		}
+	}
+	if strings.ContainsAny(placeholderPattern.ReplaceAllString(template, ""), "{}") {
+		return fmt.Errorf("unbalanced braces in %q", template)
+	}
+	return nil
+}
+
+// LoadNodeLabels reads labels of the node from the file
+func LoadNodeLabels(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node labels: %v", err)
+	}
+	labels, err := ParseNodeLabels(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node labels %s: %v", path, err)
+	}
+	return labels, nil
+}
+
+// ParseNodeLabels parses labels in the format of downward API volumes, a
+// line key="value" per label with the value quoted like a Go string
+func ParseNodeLabels(data []byte) (map[string]string, error) {
+	labels := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, quoted, found := strings.Cut(line, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value of label %s: %v", key, err)
+		}
+		labels[key] = value
+	}
+	return labels, scanner.Err()
+}
+
+// FormatNodeLabels formats labels like downward API volumes, sorted by key
+func FormatNodeLabels(labels map[string]string) []byte {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s=%s\n", key, strconv.Quote(labels[key]))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Fields of the port name template
+const (
+	PortNameFieldPodName      = "podName"
+	PortNameFieldPodNamespace = "podNamespace"
+	PortNameFieldIfName       = "ifname"
+	PortNameFieldNetwork      = "network"
+	PortNameFieldContainerID  = "containerID"
+	PortNameFieldHash         = "hash"
+)
+
+const (
+	// MaxPortNameLength is the longest name of a network interface
+	MaxPortNameLength = 15
+	// PortNamePrefix starts all port name templates, NetworkManager ignores
+	// veths with it
+	PortNamePrefix = "veth"
+	// StablePortNameTemplate is the template of stable_port_names
+	StablePortNameTemplate = PortNamePrefix + "{" + PortNameFieldHash + "}"
+	// portNameTruncate marks fields of the template which are shortened so
+	// the name fits, e.g. {podName trunc}
+	portNameTruncate = " trunc"
+)
+
+var portNameFields = []string{
+	PortNameFieldPodName,
+	PortNameFieldPodNamespace,
+	PortNameFieldIfName,
+	PortNameFieldNetwork,
+	PortNameFieldContainerID,
+	PortNameFieldHash,
+}
+
+// portNameInvalidChars matches characters not kept in port names, the kernel
+// refuses some of them and others are hard to use in ovs-vsctl and ovs-ofctl
+var portNameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// ExpandPortNameTemplate replaces fields of the template by their values,
+// values of fields marked trunc are shortened so the name has at most
+// maxLength characters, shorter values leave more room for longer ones
+func ExpandPortNameTemplate(template string, fields map[string]string, maxLength int) (string, error) {
+	if err := validatePortNameTemplate(template); err != nil {
+		return "", err
+	}
+	values := map[string]string{}
+	for name, value := range fields {
+		values[name] = portNameInvalidChars.ReplaceAllString(value, "-")
+	}
+
+	matches := placeholderPattern.FindAllStringSubmatchIndex(template, -1)
+	length := len(template)
+	var truncated []int
+	for i, match := range matches {
+		placeholder := template[match[2]:match[3]]
+		field := strings.TrimSuffix(placeholder, portNameTruncate)
+		if values[field] == "" {
+			return "", fmt.Errorf("no value of {%s} of port name template %q", field, template)
+		}
+		length -= match[1] - match[0]
+		if field != placeholder {
+			truncated = append(truncated, i)
+			continue
+		}
+		length += len(values[field])
+	}
+	if length > maxLength {
+		return "", fmt.Errorf("port name of template %q exceeds %d characters", template, maxLength)
+	}
+
+	// the shortest values are kept whole first, so the room left is shared
+	// evenly by the longer ones
+	limits := map[int]int{}
+	fieldOf := func(i int) string {
+		return strings.TrimSuffix(template[matches[i][2]:matches[i][3]], portNameTruncate)
+	}
+	sort.SliceStable(truncated, func(a, b int) bool {
+		return len(values[fieldOf(truncated[a])]) < len(values[fieldOf(truncated[b])])
+	})
+	room := maxLength - length
+	for i, match := range truncated {
+		limits[match] = min(len(values[fieldOf(match)]), room/(len(truncated)-i))
+		room -= limits[match]
+	}
+
+	var name strings.Builder
+	last := 0
+	for i, match := range matches {
+		name.WriteString(template[last:match[0]])
+		value := values[fieldOf(i)]
+		if limit, found := limits[i]; found {
+			value = value[:limit]
+		}
+		name.WriteString(value)
+		last = match[1]
+	}
+	name.WriteString(template[last:])
+	return name.String(), nil
+}
+
+// validatePortNameTemplate checks that all placeholders of the template are
+// known fields, the rest is valid in port names and it keeps the veth prefix
+func validatePortNameTemplate(template string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(portNameFields, strings.TrimSuffix(match[1], portNameTruncate)) {
+			return fmt.Errorf("unknown placeholder {%s}, known are %v optionally followed by %q", match[1], portNameFields, portNameTruncate)
+		}
+	}
+	literal := placeholderPattern.ReplaceAllString(template, "")
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("unbalanced braces in %q", template)
+	}
+	if portNameInvalidChars.MatchString(literal) {
+		return fmt.Errorf("invalid characters in %q, only letters, digits, '.', '_' and '-' are allowed", template)
+	}
+	if !strings.HasPrefix(template, PortNamePrefix) {
+		return fmt.Errorf("%q doesn't start with %q", template, PortNamePrefix)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+type jsonSchema struct {
+	Properties map[string]*jsonSchema `json:"properties"`
+	Items      *jsonSchema            `json:"items"`
+}
+
+// jsonFields returns JSON names of fields of the struct type, including embedded ones
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && name == "" {
+			names = append(names, jsonFields(field.Type)...)
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+var _ = Describe("NetConfSchema", func() {
+	var schema jsonSchema
+	BeforeEach(func() {
+		Expect(json.Unmarshal(NetConfSchema(), &schema)).To(Succeed())
+	})
+	It("should describe every field of NetConf", func() {
+		for _, name := range jsonFields(reflect.TypeOf(types.NetConf{})) {
+			Expect(schema.Properties).To(HaveKey(name))
+		}
+	})
+	It("should describe every field of nested objects", func() {
+		for _, name := range jsonFields(reflect.TypeOf(types.Trunk{})) {
+			Expect(schema.Properties["trunk"].Items.Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Offload{})) {
+			Expect(schema.Properties["offload"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.VhostUser{})) {
+			Expect(schema.Properties["vhost_user"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Representor{})) {
+			Expect(schema.Properties["representor"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Capture{})) {
+			Expect(schema.Properties["capture"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Hooks{})) {
+			Expect(schema.Properties["hooks"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.RateLimit{})) {
+			Expect(schema.Properties["rate_limit"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.IngressRateLimit{})) {
+			Expect(schema.Properties["ingress_rate_limit"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.QoS{})) {
+			Expect(schema.Properties["qos"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.QinQ{})) {
+			Expect(schema.Properties["qinq"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.RuntimeConfig{})) {
+			Expect(schema.Properties["runtimeConfig"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.CNIArgs{})) {
+			Expect(schema.Properties["args"].Properties["cni"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Bandwidth{})) {
+			Expect(schema.Properties["runtimeConfig"].Properties["bandwidth"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.OvsUnavailable{})) {
+			Expect(schema.Properties["ovs_unavailable"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Backup{})) {
+			Expect(schema.Properties["backup"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.OfportRange{})) {
+			Expect(schema.Properties["ofport_range"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Garp{})) {
+			Expect(schema.Properties["garp"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.IPConflict{})) {
+			Expect(schema.Properties["ip_conflict"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Tap{})) {
+			Expect(schema.Properties["tap"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Bond{})) {
+			Expect(schema.Properties["bond"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.VFChannels{})) {
+			Expect(schema.Properties["vf_tuning"].Properties["channels"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.VFCoalesce{})) {
+			Expect(schema.Properties["vf_tuning"].Properties["coalesce"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Static{})) {
+			Expect(schema.Properties["static"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.StaticAddress{})) {
+			Expect(schema.Properties["static"].Properties["addresses"].Items.Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.Probe{})) {
+			Expect(schema.Properties["probe"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.NetworkStatus{})) {
+			Expect(schema.Properties["network_status"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.VhostUserQueues{})) {
+			Expect(schema.Properties["vhost_user"].Properties["queues"].Properties).To(HaveKey(name))
+			Expect(schema.Properties["runtimeConfig"].Properties["vhostUserQueues"].Properties).To(HaveKey(name))
+		}
+		for _, name := range jsonFields(reflect.TypeOf(types.VlanTranslation{})) {
+			Expect(schema.Properties["vlan_translation"].Items.Properties).To(HaveKey(name))
+		}
+	})
+	It("should return a copy of the schema", func() {
+		NetConfSchema()[0] = 'x'
+		Expect(json.Valid(NetConfSchema())).To(BeTrue())
+	})
+})
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// ParseTrunks parses a comma separated list of VLAN IDs and ranges of VLAN
+// IDs, e.g. 10,20-30, into trunk entries of the netconf
+func ParseTrunks(spec string) ([]*types.Trunk, error) {
+	var trunks []*types.Trunk
+	for _, item := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		ids := make([]uint, 0, len(bounds))
+		for _, bound := range bounds {
+			id, err := strconv.ParseUint(bound, 10, 12)
+			if err != nil {
+				return nil, fmt.Errorf("invalid VLAN ID %q in trunk %q", bound, spec)
+			}
+			ids = append(ids, uint(id))
+		}
+		if len(ids) == 1 {
+			trunks = append(trunks, &types.Trunk{ID: &ids[0]})
+			continue
+		}
+		if ids[0] > ids[1] {
+			return nil, fmt.Errorf("invalid VLAN range %q in trunk %q", item, spec)
+		}
+		trunks = append(trunks, &types.Trunk{MinID: &ids[0], MaxID: &ids[1]})
+	}
+	return trunks, nil
+}
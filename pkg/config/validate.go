@@ -0,0 +1,948 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/version"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+const (
+	maxVlanID        = 4095
+	minMTU           = 68
+	maxMTU           = 65535
+	maxOfportRequest = 65279
+	maxGarpCount     = 10
+	maxGarpInterval  = 5000 // in milliseconds
+	maxDADTimeout    = 30   // in seconds
+	maxProbeCount    = 10
+	maxProbeTimeout  = 5000 // in milliseconds
+
+	// maxAddWait is the longest ADD may wait for probes, announcements, DAD
+	// and IP conflict detection in total, so it stays below timeouts of
+	// container runtimes
+	maxAddWait = 60 // in seconds
+	// maxVerifyTraffic is the longest ADD of the mirror consumer watches
+	// for mirrored packets
+	maxVerifyTraffic = 60 // in seconds
+
+	maxIPConflictTimeout       = 5000 // in milliseconds
+	maxIPConflictReallocations = 5
+
+	maxCheckCacheTTL = 3600 // in seconds
+
+	maxVhostUserQueues    = 1024
+	maxVhostUserQueueSize = 4096
+
+	// VLAN IDs a tag can be rewritten to, or pushed as an S-VLAN
+	minTranslatedVlanID = 1
+	maxTranslatedVlanID = 4094
+)
+
+// Attachment modes
+const (
+	ModeBridged = "bridged"
+	ModeRouted  = "routed"
+)
+
+// Values of uplink_check
+const (
+	UplinkCheckWarn = "warn"
+	UplinkCheckFail = "fail"
+)
+
+// Values of mtu_check
+const (
+	MTUCheckWarn  = "warn"
+	MTUCheckClamp = "clamp"
+	MTUCheckOff   = "off"
+)
+
+// Values of link_state_policy
+const (
+	LinkStatePolicyFail  = "fail"
+	LinkStatePolicyWarn  = "warn"
+	LinkStatePolicyRetry = "retry"
+)
+
+// Values of qos.type
+const (
+	QoSTypeHTB  = "linux-htb"
+	QoSTypeHFSC = "linux-hfsc"
+)
+
+// Values of vlan_mode
+const (
+	VlanModeAccess         = "access"
+	VlanModeTrunk          = "trunk"
+	VlanModeNativeTagged   = "native-tagged"
+	VlanModeNativeUntagged = "native-untagged"
+	VlanModeDot1qTunnel    = "dot1q-tunnel"
+)
+
+// Values of qinq.ethtype
+const (
+	QinQEthType8021ad = "802.1ad"
+	QinQEthType8021q  = "802.1q"
+)
+
+// Values of rate_limit.method
+const (
+	RateLimitMethodOVS = "ovs"
+	RateLimitMethodTC  = "tc"
+)
+
+// Values of ovs_unavailable.action
+const (
+	OvsUnavailableFail     = "fail"
+	OvsUnavailableDelegate = "delegate"
+)
+
+// Values of mac_derivation
+const (
+	MACDerivationIPHash = "ip-hash"
+	MACDerivationEUI64  = "eui64"
+	MACDerivationPodUID = "pod-uid"
+)
+
+// Values of bond.mode
+const (
+	BondModeActiveBackup = "active-backup"
+	BondModeBalanceXor   = "balance-xor"
+)
+
+// Values of missing_prev_result
+const (
+	MissingPrevResultFail = "fail"
+	MissingPrevResultWarn = "warn"
+)
+
+// maxTapQueues is the most queues of a tap device the kernel allows
+const maxTapQueues = 256
+
+// bondedVFs is the number of VFs of a bonded attachment
+const bondedVFs = 2
+
+// maxIfNameLen is the longest name of a network interface
+const maxIfNameLen = 15
+
+// maxAltNameLen is the longest alternative name of a network interface
+const maxAltNameLen = 127
+
+// validateQoS checks the qos is one Linux can shape traffic of the port by,
+// the port has a single QoS
+func validateQoS(netconf *types.NetConf, errs *ValidationErrors) {
+	qos := netconf.QoS
+	switch qos.Type {
+	case "", QoSTypeHTB:
+	case QoSTypeHFSC:
+		if qos.Burst != 0 {
+			errs.add("$.qos.burst", "can't be used with type %q", QoSTypeHFSC)
+		}
+		if qos.Priority != nil {
+			errs.add("$.qos.priority", "can't be used with type %q", QoSTypeHFSC)
+		}
+	default:
+		errs.add("$.qos.type", "must be %q or %q, got %q", QoSTypeHTB, QoSTypeHFSC, qos.Type)
+	}
+	if qos.MinRate == 0 && qos.MaxRate == 0 {
+		errs.add("$.qos", "requires min_rate or max_rate")
+	}
+	if qos.MaxRate != 0 && qos.MinRate > qos.MaxRate {
+		errs.add("$.qos.min_rate", "must not exceed max_rate %d, got %d", qos.MaxRate, qos.MinRate)
+	}
+	if netconf.IngressRateLimit != nil {
+		errs.add("$.qos", "can't be used with ingress_rate_limit or ingress bandwidth")
+	}
+	if strings.HasPrefix(netconf.InterfaceType, "dpdk") {
+		errs.add("$.qos", "can't be used with interface_type %q", netconf.InterfaceType)
+	}
+}
+
+// validateAltNames checks altnames are valid and unique names of a network
+// interface, the kernel rejects the same names as for interface names
+func validateAltNames(netconf *types.NetConf, errs *ValidationErrors) {
+	if netconf.InterfaceType == VhostUserInterfaceType {
+		errs.add("$.altnames", "can't be used with interface_type %q", VhostUserInterfaceType)
+	}
+	seen := map[string]bool{}
+	for i, name := range netconf.AltNames {
+		path := fmt.Sprintf("$.altnames[%d]", i)
+		switch {
+		case name == "" || name == "." || name == "..":
+			errs.add(path, "must be a valid interface name, got %q", name)
+		case len(name) > maxAltNameLen:
+			errs.add(path, "must be at most %d characters, got %q", maxAltNameLen, name)
+		case strings.ContainsAny(name, "/: \t\n"):
+			errs.add(path, "must not contain '/', ':' or whitespace, got %q", name)
+		case seen[name]:
+			errs.add(path, "duplicates altname %q", name)
+		}
+		seen[name] = true
+	}
+}
+
+// maxTCPoliceRate is the highest rate and burst tc police can be configured
+// with, in kbps and kilobits, it takes bytes in 32 bits
+const maxTCPoliceRate = math.MaxUint32 / 125
+
+// VhostUserInterfaceType is the interface type of vhost-user attachments,
+// OVS connects as a client to the socket created in the pod
+const VhostUserInterfaceType = "dpdkvhostuserclient"
+
+// InternalInterfaceType is the interface type of attachments whose container
+// interface is an OVS internal port moved into the container netns
+const InternalInterfaceType = "internal"
+
+// interfaceTypes lists OVS interface types accepted in interface_type
+var interfaceTypes = map[string]bool{
+	"":                    true,
+	"system":              true,
+	"internal":            true,
+	"tap":                 true,
+	"dpdk":                true,
+	"dpdkvhostuser":       true,
+	"dpdkvhostuserclient": true,
+	"afxdp":               true,
+	"afxdp-nonpmd":        true,
+}
+
+// ValidationError is a problem of a single field of the configuration
+type ValidationError struct {
+	// Path is JSON path of the field, e.g. $.trunk[1].maxID
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors are all problems found in the configuration
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(msgs, "; "))
+}
+
+func (e *ValidationErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks all fields of the netconf and returns ValidationErrors
+// listing every problem found, or nil when the netconf is valid
+func Validate(netconf *types.NetConf) error {
+	var errs ValidationErrors
+
+	// results before 0.3.0 have no interfaces, only addresses of the IPAM
+	if netconf.CNIVersion != "" && netconf.IPAM.Type == "" {
+		if before030, err := version.GreaterThan("0.3.0", netconf.CNIVersion); err == nil && before030 {
+			errs.add("$.ipam", "must be set with cniVersion %s, its results carry only addresses", netconf.CNIVersion)
+		}
+	}
+
+	if netconf.VlanTag != nil {
+		if *netconf.VlanTag > maxVlanID {
+			errs.add("$.vlan", "must be in range 0 to %d, got %d", maxVlanID, *netconf.VlanTag)
+		}
+	}
+	for i, trunk := range netconf.Trunk {
+		path := fmt.Sprintf("$.trunk[%d]", i)
+		if trunk == nil {
+			errs.add(path, "must not be null")
+			continue
+		}
+		if trunk.ID == nil && trunk.MinID == nil && trunk.MaxID == nil {
+			errs.add(path, "either id or minID and maxID must be set")
+		}
+		if trunk.ID != nil && *trunk.ID > maxVlanID {
+			errs.add(path+".id", "must be in range 0 to %d, got %d", maxVlanID, *trunk.ID)
+		}
+		if trunk.MinID != nil && *trunk.MinID > maxVlanID {
+			errs.add(path+".minID", "must be in range 0 to %d, got %d", maxVlanID, *trunk.MinID)
+		}
+		if trunk.MaxID != nil && *trunk.MaxID > maxVlanID {
+			errs.add(path+".maxID", "must be in range 0 to %d, got %d", maxVlanID, *trunk.MaxID)
+		}
+		if (trunk.MinID == nil) != (trunk.MaxID == nil) {
+			errs.add(path, "minID and maxID must be set together")
+		} else if trunk.MinID != nil && *trunk.MinID > *trunk.MaxID {
+			errs.add(path, "minID %d is greater than maxID %d", *trunk.MinID, *trunk.MaxID)
+		}
+	}
+
+	if IsBridgeTemplate(netconf.BrName) {
+		if err := validateBridgeTemplate(netconf.BrName); err != nil {
+			errs.add("$.bridge", "%v", err)
+		}
+	}
+	if netconf.PortNameTemplate != "" {
+		if err := validatePortNameTemplate(netconf.PortNameTemplate); err != nil {
+			errs.add("$.port_name_template", "%v", err)
+		}
+		if netconf.StablePortNames {
+			errs.add("$.port_name_template", "is not supported with stable_port_names")
+		}
+	}
+	if netconf.NodeLabelsFile != "" && !filepath.IsAbs(netconf.NodeLabelsFile) {
+		errs.add("$.node_labels_file", "must be an absolute path, got %q", netconf.NodeLabelsFile)
+	}
+
+	if netconf.MTU != 0 && (netconf.MTU < minMTU || netconf.MTU > maxMTU) {
+		errs.add("$.mtu", "must be in range %d to %d, got %d", minMTU, maxMTU, netconf.MTU)
+	}
+	if netconf.OfportRequest > maxOfportRequest {
+		errs.add("$.ofport_request", "must be in range 1 to %d, got %d", maxOfportRequest, netconf.OfportRequest)
+	}
+	if ofportRange := netconf.OfportRange; ofportRange != nil {
+		if ofportRange.Min < 1 || ofportRange.Min > maxOfportRequest {
+			errs.add("$.ofport_range.min", "must be in range 1 to %d, got %d", maxOfportRequest, ofportRange.Min)
+		}
+		if ofportRange.Max < 1 || ofportRange.Max > maxOfportRequest {
+			errs.add("$.ofport_range.max", "must be in range 1 to %d, got %d", maxOfportRequest, ofportRange.Max)
+		}
+		if ofportRange.Min > ofportRange.Max {
+			errs.add("$.ofport_range", "min %d must not exceed max %d", ofportRange.Min, ofportRange.Max)
+		}
+	}
+	if !interfaceTypes[netconf.InterfaceType] {
+		errs.add("$.interface_type", "unsupported interface type %q", netconf.InterfaceType)
+	}
+	if netconf.InterfaceType == InternalInterfaceType && netconf.DeviceID != "" {
+		errs.add("$.interface_type", "%q can't be used with deviceID", InternalInterfaceType)
+	}
+	var allowedMACPrefixes []net.HardwareAddr
+	for i, prefix := range netconf.AllowedMACPrefixes {
+		mac, err := ParseMACPrefix(prefix)
+		if err != nil {
+			errs.add(fmt.Sprintf("$.allowed_mac_prefixes[%d]", i), "%v", err)
+			continue
+		}
+		allowedMACPrefixes = append(allowedMACPrefixes, mac)
+	}
+	if netconf.MACPrefix != "" {
+		prefix, err := ParseMACPrefix(netconf.MACPrefix)
+		switch {
+		case err != nil:
+			errs.add("$.mac_prefix", "%v", err)
+		case len(prefix) > 5:
+			errs.add("$.mac_prefix", "must be shorter than a MAC address")
+		case prefix[0]&0x01 != 0:
+			errs.add("$.mac_prefix", "must be a unicast prefix")
+		case prefix[0]&0x02 == 0:
+			errs.add("$.mac_prefix", "must be a locally administered prefix")
+		case !MACAllowed(prefix, allowedMACPrefixes):
+			errs.add("$.mac_prefix", "is not within allowed_mac_prefixes")
+		}
+	}
+	switch netconf.MACDerivation {
+	case "", MACDerivationIPHash, MACDerivationEUI64, MACDerivationPodUID:
+	default:
+		errs.add("$.mac_derivation", "must be %q, %q or %q, got %q", MACDerivationIPHash, MACDerivationEUI64, MACDerivationPodUID, netconf.MACDerivation)
+	}
+	switch netconf.MissingPrevResult {
+	case "", MissingPrevResultFail, MissingPrevResultWarn:
+	default:
+		errs.add("$.missing_prev_result", "must be %q or %q, got %q", MissingPrevResultFail, MissingPrevResultWarn, netconf.MissingPrevResult)
+	}
+	if netconf.LinkStateCheckRetries < 0 {
+		errs.add("$.link_state_check_retries", "must not be negative")
+	}
+	if netconf.LinkStateCheckInterval < 0 {
+		errs.add("$.link_state_check_interval", "must not be negative")
+	}
+	switch netconf.LinkStatePolicy {
+	case "", LinkStatePolicyFail, LinkStatePolicyWarn, LinkStatePolicyRetry:
+	default:
+		errs.add("$.link_state_policy", "must be %q, %q or %q, got %q",
+			LinkStatePolicyFail, LinkStatePolicyWarn, LinkStatePolicyRetry, netconf.LinkStatePolicy)
+	}
+	if netconf.DelBridgeRetries < 0 {
+		errs.add("$.del_bridge_retries", "must not be negative")
+	}
+	if netconf.DelBridgeRetryInterval < 0 {
+		errs.add("$.del_bridge_retry_interval", "must not be negative")
+	}
+	if netconf.RetainOnDeleteTimeout < 0 {
+		errs.add("$.retainOnDeleteTimeout", "must not be negative")
+	}
+	if netconf.RetainOnDelete {
+		// only veth pairs can be quarantined
+		if netconf.InterfaceType == InternalInterfaceType || netconf.InterfaceType == VhostUserInterfaceType {
+			errs.add("$.retainOnDelete", "can't be used with interface_type %q", netconf.InterfaceType)
+		}
+		if netconf.Tap != nil {
+			errs.add("$.retainOnDelete", "can't be used with tap")
+		}
+		if netconf.InfraNetns != "" {
+			errs.add("$.retainOnDelete", "can't be used with infra_netns")
+		}
+	}
+	switch netconf.Mode {
+	case "", ModeBridged:
+	case ModeRouted:
+		if netconf.IPAM.Type == "" {
+			errs.add("$.mode", "routed mode requires ipam")
+		}
+		if netconf.DeviceID != "" {
+			errs.add("$.mode", "routed mode can't be used with deviceID")
+		}
+		if netconf.InterfaceType == VhostUserInterfaceType {
+			errs.add("$.mode", "routed mode can't be used with interface_type %q", VhostUserInterfaceType)
+		}
+	default:
+		errs.add("$.mode", "must be %q or %q, got %q", ModeBridged, ModeRouted, netconf.Mode)
+	}
+	switch netconf.UplinkCheck {
+	case "", UplinkCheckWarn, UplinkCheckFail:
+	default:
+		errs.add("$.uplink_check", "must be %q or %q, got %q", UplinkCheckWarn, UplinkCheckFail, netconf.UplinkCheck)
+	}
+	switch netconf.MTUCheck {
+	case "", MTUCheckWarn, MTUCheckClamp, MTUCheckOff:
+	default:
+		errs.add("$.mtu_check", "must be %q, %q or %q, got %q", MTUCheckWarn, MTUCheckClamp, MTUCheckOff, netconf.MTUCheck)
+	}
+	if len(netconf.UplinkPorts) > 0 && netconf.UplinkCheck == "" {
+		errs.add("$.uplink_ports", "requires uplink_check")
+	}
+	if netconf.StatsFile != "" && !filepath.IsAbs(netconf.StatsFile) {
+		errs.add("$.stats_file", "must be an absolute path")
+	}
+	if netconf.CheckReportDir != "" && !filepath.IsAbs(netconf.CheckReportDir) {
+		errs.add("$.check_report_dir", "must be an absolute path")
+	}
+	if netconf.CheckCacheTTL < 0 || netconf.CheckCacheTTL > maxCheckCacheTTL {
+		errs.add("$.check_cache_ttl", "must be in range 0 to %d, got %d", maxCheckCacheTTL, netconf.CheckCacheTTL)
+	}
+	if netconf.InfraNetns != "" {
+		if !filepath.IsAbs(netconf.InfraNetns) {
+			errs.add("$.infra_netns", "must be an absolute path")
+		}
+		if netconf.DeviceID != "" {
+			errs.add("$.infra_netns", "can't be used with deviceID")
+		}
+		if netconf.InterfaceType == VhostUserInterfaceType || netconf.InterfaceType == InternalInterfaceType {
+			errs.add("$.infra_netns", "can't be used with interface_type %q", netconf.InterfaceType)
+		}
+		if netconf.Tap != nil {
+			errs.add("$.infra_netns", "can't be used with tap")
+		}
+		if netconf.Backup != nil {
+			errs.add("$.infra_netns", "can't be used with backup")
+		}
+		if netconf.Bond != nil {
+			errs.add("$.infra_netns", "can't be used with bond")
+		}
+	}
+	if tap := netconf.Tap; tap != nil {
+		if tap.UID != nil && *tap.UID < 0 {
+			errs.add("$.tap.uid", "must not be negative")
+		}
+		if tap.GID != nil && *tap.GID < 0 {
+			errs.add("$.tap.gid", "must not be negative")
+		}
+		if tap.Queues > maxTapQueues {
+			errs.add("$.tap.queues", "must be in range 0 to %d, got %d", maxTapQueues, tap.Queues)
+		}
+		if netconf.DeviceID != "" {
+			errs.add("$.tap", "can't be used with deviceID")
+		}
+		if netconf.InterfaceType == VhostUserInterfaceType || netconf.InterfaceType == InternalInterfaceType {
+			errs.add("$.tap", "can't be used with interface_type %q", netconf.InterfaceType)
+		}
+		if netconf.IPAM.Type != "" {
+			errs.add("$.tap", "can't be used with ipam, addresses are configured by the consumer of the tap device")
+		}
+		if netconf.Backup != nil {
+			errs.add("$.tap", "can't be used with backup")
+		}
+		if netconf.Bond != nil {
+			errs.add("$.tap", "can't be used with bond")
+		}
+	}
+	if vhostUser := netconf.VhostUser; vhostUser != nil {
+		if netconf.InterfaceType != VhostUserInterfaceType {
+			errs.add("$.vhost_user", "requires interface_type %q", VhostUserInterfaceType)
+		}
+		if vhostUser.SocketDir != "" && !filepath.IsAbs(vhostUser.SocketDir) {
+			errs.add("$.vhost_user.socket_dir", "must be an absolute path")
+		}
+		if vhostUser.UID != nil && *vhostUser.UID < 0 {
+			errs.add("$.vhost_user.uid", "must not be negative")
+		}
+		if vhostUser.GID != nil && *vhostUser.GID < 0 {
+			errs.add("$.vhost_user.gid", "must not be negative")
+		}
+		if vhostUser.SELinuxContext != "" && strings.Count(vhostUser.SELinuxContext, ":") < 3 {
+			errs.add("$.vhost_user.selinux_context", "must be in user:role:type:level format")
+		}
+		if queues := vhostUser.Queues; queues != nil {
+			if queues.NRxq > maxVhostUserQueues {
+				errs.add("$.vhost_user.queues.n_rxq", "must be in range 0 to %d, got %d", maxVhostUserQueues, queues.NRxq)
+			}
+			if queues.NTxq > maxVhostUserQueues {
+				errs.add("$.vhost_user.queues.n_txq", "must be in range 0 to %d, got %d", maxVhostUserQueues, queues.NTxq)
+			}
+			if !validQueueSize(queues.NRxqDesc) {
+				errs.add("$.vhost_user.queues.n_rxq_desc", "must be a power of 2 up to %d, got %d", maxVhostUserQueueSize, queues.NRxqDesc)
+			}
+			if !validQueueSize(queues.NTxqDesc) {
+				errs.add("$.vhost_user.queues.n_txq_desc", "must be a power of 2 up to %d, got %d", maxVhostUserQueueSize, queues.NTxqDesc)
+			}
+		}
+	}
+	if representor := netconf.Representor; representor != nil {
+		if representor.NameTemplate != "" && representor.PhysPortName != "" {
+			errs.add("$.representor", "name_template and phys_port_name are mutually exclusive")
+		}
+		if representor.NameTemplate != "" && !strings.Contains(representor.NameTemplate, "{vf}") {
+			errs.add("$.representor.name_template", "must contain {vf}")
+		}
+		if representor.PhysPortName != "" && !strings.Contains(representor.PhysPortName, "{vf}") {
+			errs.add("$.representor.phys_port_name", "must contain {vf}")
+		}
+		if representor.DPUName != "" && !strings.Contains(representor.DPUName, "{vf}") {
+			errs.add("$.representor.dpu_name", "must contain {vf}")
+		}
+	}
+	if capture := netconf.Capture; capture != nil {
+		if capture.Port == "" {
+			errs.add("$.capture.port", "must be set")
+		}
+		if capture.Duration < 0 {
+			errs.add("$.capture.duration", "must not be negative")
+		}
+		if netconf.OvsdbLeastPrivilege {
+			errs.add("$.capture", "can't be used with ovsdb_least_privilege")
+		}
+	}
+	if hooks := netconf.Hooks; hooks != nil {
+		for _, hook := range []struct{ path, name string }{{"$.hooks.post_add", hooks.PostAdd}, {"$.hooks.pre_del", hooks.PreDel}} {
+			if hook.name != "" && (filepath.Base(hook.name) != hook.name || hook.name == "." || hook.name == "..") {
+				errs.add(hook.path, "must be a file name in %s, got %q", HooksDir, hook.name)
+			}
+		}
+		if hooks.Timeout < 0 {
+			errs.add("$.hooks.timeout", "must not be negative")
+		}
+	}
+	if rateLimit := netconf.RateLimit; rateLimit != nil {
+		if rateLimit.Rate == 0 {
+			errs.add("$.rate_limit.rate", "must be set")
+		}
+		switch rateLimit.Method {
+		case "", RateLimitMethodOVS:
+		case RateLimitMethodTC:
+			if netconf.DeviceID == "" {
+				errs.add("$.rate_limit.method", "%q requires deviceID", RateLimitMethodTC)
+			}
+			if netconf.BridgeSocketFile != "" {
+				errs.add("$.rate_limit.method", "%q can't be used with bridge_socket_file, the representor is on the DPU", RateLimitMethodTC)
+			}
+			if rateLimit.Rate > maxTCPoliceRate {
+				errs.add("$.rate_limit.rate", "must be at most %d with %q, got %d", maxTCPoliceRate, RateLimitMethodTC, rateLimit.Rate)
+			}
+			if rateLimit.Burst > maxTCPoliceRate {
+				errs.add("$.rate_limit.burst", "must be at most %d with %q, got %d", maxTCPoliceRate, RateLimitMethodTC, rateLimit.Burst)
+			}
+		default:
+			errs.add("$.rate_limit.method", "must be %q or %q, got %q", RateLimitMethodOVS, RateLimitMethodTC, rateLimit.Method)
+		}
+	}
+	if rateLimit := netconf.IngressRateLimit; rateLimit != nil {
+		if rateLimit.Rate == 0 {
+			errs.add("$.ingress_rate_limit.rate", "must be set")
+		}
+	}
+	if qos := netconf.QoS; qos != nil {
+		validateQoS(netconf, &errs)
+	}
+	if netconf.QinQ != nil {
+		validateQinQ(netconf, &errs)
+	}
+	if netconf.VlanMode != "" {
+		validateVlanMode(netconf, &errs)
+	}
+	if unavailable := netconf.OvsUnavailable; unavailable != nil {
+		switch unavailable.Action {
+		case OvsUnavailableFail:
+			if unavailable.Plugin != nil {
+				errs.add("$.ovs_unavailable.plugin", "requires action %q", OvsUnavailableDelegate)
+			}
+		case OvsUnavailableDelegate:
+			if pluginType, _ := unavailable.Plugin["type"].(string); pluginType == "" {
+				errs.add("$.ovs_unavailable.plugin.type", "must be set")
+			}
+		default:
+			errs.add("$.ovs_unavailable.action", "must be %q or %q, got %q", OvsUnavailableFail, OvsUnavailableDelegate, unavailable.Action)
+		}
+	}
+	if backup := netconf.Backup; backup != nil {
+		if backup.Bridge == "" {
+			errs.add("$.backup.bridge", "must be set")
+		} else if backup.Bridge == netconf.BrName {
+			errs.add("$.backup.bridge", "must differ from bridge %q", netconf.BrName)
+		}
+		if len(backup.IfName) > maxIfNameLen {
+			errs.add("$.backup.interface", "must be at most %d characters, got %q", maxIfNameLen, backup.IfName)
+		}
+		if netconf.DeviceID != "" {
+			errs.add("$.backup", "can't be used with deviceID")
+		}
+		if netconf.InterfaceType == VhostUserInterfaceType {
+			errs.add("$.backup", "can't be used with interface_type %q", VhostUserInterfaceType)
+		}
+		if netconf.Mode == ModeRouted {
+			errs.add("$.backup", "can't be used with routed mode")
+		}
+		if netconf.InterfaceType == InternalInterfaceType {
+			errs.add("$.backup", "can't be used with interface_type %q", InternalInterfaceType)
+		}
+	}
+	if bond := netconf.Bond; bond != nil {
+		if bond.Name == "" {
+			errs.add("$.bond.name", "must be set")
+		} else if len(bond.Name) > maxIfNameLen {
+			errs.add("$.bond.name", "must be at most %d characters, got %q", maxIfNameLen, bond.Name)
+		}
+		switch bond.Mode {
+		case "", BondModeActiveBackup, BondModeBalanceXor:
+		default:
+			errs.add("$.bond.mode", "must be %q or %q, got %q", BondModeActiveBackup, BondModeBalanceXor, bond.Mode)
+		}
+		if netconf.InterfaceType == VhostUserInterfaceType {
+			errs.add("$.bond", "can't be used with interface_type %q", VhostUserInterfaceType)
+		}
+		if netconf.Mode == ModeRouted {
+			errs.add("$.bond", "can't be used with routed mode")
+		}
+		if netconf.Backup != nil {
+			errs.add("$.bond", "can't be used with backup")
+		}
+		if netconf.UserspaceIPAM {
+			errs.add("$.bond", "can't be used with userspace_ipam")
+		}
+	}
+	for gate := range netconf.FeatureGates {
+		if _, known := featureGateDefaults[gate]; !known {
+			errs.add("$.featureGates."+gate, "unknown feature gate, known are %v", knownFeatureGates())
+		}
+	}
+	if len(netconf.DeviceIDs) > 0 {
+		if !FeatureEnabled(netconf, FeatureBondedVFs) {
+			errs.add("$.deviceIDs", "requires feature gate %s", FeatureBondedVFs)
+		}
+		if len(netconf.DeviceIDs) != bondedVFs {
+			errs.add("$.deviceIDs", "must have %d VFs, got %d", bondedVFs, len(netconf.DeviceIDs))
+		} else if netconf.DeviceIDs[0] == netconf.DeviceIDs[1] {
+			errs.add("$.deviceIDs", "must have different VFs, got %q twice", netconf.DeviceIDs[0])
+		}
+		if netconf.DeviceID != "" && !slices.Contains(netconf.DeviceIDs, netconf.DeviceID) {
+			errs.add("$.deviceID", "must be one of deviceIDs, got %q", netconf.DeviceID)
+		}
+		if netconf.Bond != nil {
+			errs.add("$.deviceIDs", "can't be used with bond")
+		}
+		if netconf.UserspaceIPAM {
+			errs.add("$.deviceIDs", "can't be used with userspace_ipam")
+		}
+	}
+	if netconf.VFTuning != nil && netconf.DeviceID == "" {
+		errs.add("$.vf_tuning", "requires deviceID")
+	}
+	if netconf.UserspaceIPAM {
+		if netconf.DeviceID == "" {
+			errs.add("$.userspace_ipam", "requires deviceID")
+		}
+		if netconf.IPAM.Type == "" {
+			errs.add("$.userspace_ipam", "requires ipam")
+		}
+	}
+	if netconf.IPAMV6 != nil {
+		if netconf.IPAMV6.Type == "" {
+			errs.add("$.ipam_v6.type", "must be set")
+		}
+		if netconf.IPAM.Type == "" {
+			errs.add("$.ipam_v6", "requires ipam")
+		}
+	}
+	if probe := netconf.Probe; probe != nil {
+		if probe.Target != "" && net.ParseIP(probe.Target) == nil {
+			errs.add("$.probe.target", "must be an IP address, got %q", probe.Target)
+		}
+		if probe.Count < 0 || probe.Count > maxProbeCount {
+			errs.add("$.probe.count", "must be in range 1 to %d, got %d", maxProbeCount, probe.Count)
+		}
+		if probe.Timeout < 0 || probe.Timeout > maxProbeTimeout {
+			errs.add("$.probe.timeout", "must be in range 1 to %d, got %d", maxProbeTimeout, probe.Timeout)
+		}
+		if netconf.InterfaceType == VhostUserInterfaceType {
+			errs.add("$.probe", "can't be used with interface_type %q", VhostUserInterfaceType)
+		}
+	}
+	if garp := netconf.Garp; garp != nil {
+		// announcements are sent while ADD waits
+		if garp.Count < 0 || garp.Count > maxGarpCount {
+			errs.add("$.garp.count", "must be in range 1 to %d, got %d", maxGarpCount, garp.Count)
+		}
+		if garp.Interval < 0 || garp.Interval > maxGarpInterval {
+			errs.add("$.garp.interval", "must be in range 1 to %d, got %d", maxGarpInterval, garp.Interval)
+		}
+		if garp.DADTimeout < 0 || garp.DADTimeout > maxDADTimeout {
+			errs.add("$.garp.dad_timeout", "must be in range 0 to %d, got %d", maxDADTimeout, garp.DADTimeout)
+		}
+	}
+	if ipConflict := netconf.IPConflict; ipConflict != nil {
+		if netconf.IPAM.Type == "" {
+			errs.add("$.ip_conflict", "requires ipam")
+		}
+		if netconf.Mode == ModeRouted {
+			errs.add("$.ip_conflict", "can't be used with routed mode")
+		}
+		if ipConflict.Timeout < 0 || ipConflict.Timeout > maxIPConflictTimeout {
+			errs.add("$.ip_conflict.timeout", "must be in range 1 to %d, got %d", maxIPConflictTimeout, ipConflict.Timeout)
+		}
+		if ipConflict.Reallocations < 0 || ipConflict.Reallocations > maxIPConflictReallocations {
+			errs.add("$.ip_conflict.reallocations", "must be in range 0 to %d, got %d", maxIPConflictReallocations, ipConflict.Reallocations)
+		}
+	}
+	if static := netconf.Static; static != nil {
+		if len(static.Addresses) == 0 {
+			errs.add("$.static.addresses", "must not be empty")
+		}
+		for i, addr := range static.Addresses {
+			if _, _, err := net.ParseCIDR(addr.Address); err != nil {
+				errs.add(fmt.Sprintf("$.static.addresses[%d].address", i), "must be an address in CIDR notation, got %q", addr.Address)
+			}
+			if addr.Gateway != "" && net.ParseIP(addr.Gateway) == nil {
+				errs.add(fmt.Sprintf("$.static.addresses[%d].gateway", i), "must be an IP address, got %q", addr.Gateway)
+			}
+		}
+	}
+	if wait := addWait(netconf); wait > maxAddWait*time.Second {
+		errs.add("$", "probe, garp and ip_conflict make ADD wait up to %v, must be at most %v", wait, maxAddWait*time.Second)
+	}
+	if len(netconf.VlanTranslation) > 0 {
+		validateVlanTranslation(netconf, &errs)
+	}
+	if netconf.PreserveAddresses {
+		// only a veth pair is kept in the container netns to be reused
+		if netconf.InterfaceType == InternalInterfaceType || netconf.InterfaceType == VhostUserInterfaceType {
+			errs.add("$.preserve_addresses", "can't be used with interface_type %q", netconf.InterfaceType)
+		}
+		if netconf.Tap != nil {
+			errs.add("$.preserve_addresses", "can't be used with tap")
+		}
+		if netconf.InfraNetns != "" {
+			errs.add("$.preserve_addresses", "can't be used with infra_netns")
+		}
+		if netconf.DeviceID != "" || len(netconf.DeviceIDs) > 0 {
+			errs.add("$.preserve_addresses", "can't be used with SR-IOV VFs")
+		}
+		if netconf.Bond != nil {
+			errs.add("$.preserve_addresses", "can't be used with bond")
+		}
+	}
+	if len(netconf.AltNames) > 0 {
+		validateAltNames(netconf, &errs)
+	}
+	if netconf.InterfaceType == VhostUserInterfaceType {
+		if netconf.AcceptRA != nil {
+			errs.add("$.accept_ra", "can't be used with interface_type %q", VhostUserInterfaceType)
+		}
+		if netconf.IPv6Autoconf != nil {
+			errs.add("$.ipv6_autoconf", "can't be used with interface_type %q", VhostUserInterfaceType)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// addWait returns the longest time ADD waits for probes, announcements, DAD
+// and IP conflict detection of the netconf
+func addWait(netconf *types.NetConf) time.Duration {
+	var wait time.Duration
+	if probe := netconf.Probe; probe != nil {
+		wait += time.Duration(probe.Count) * time.Duration(probe.Timeout) * time.Millisecond
+	}
+	if garp := netconf.Garp; garp != nil && !garp.Disabled {
+		if garp.Count > 1 {
+			wait += time.Duration(garp.Count-1) * time.Duration(garp.Interval) * time.Millisecond
+		}
+		wait += time.Duration(garp.DADTimeout) * time.Second
+	}
+	if ipConflict := netconf.IPConflict; ipConflict != nil {
+		wait += time.Duration(ipConflict.Reallocations+1) * time.Duration(ipConflict.Timeout) * time.Millisecond
+	}
+	return wait
+}
+
+// ValidateMirror checks the netconf of the mirror consumer and returns
+// ValidationErrors listing every problem found, or nil when it is valid
+func ValidateMirror(netconf *types.MirrorNetConf) error {
+	var errs ValidationErrors
+	if netconf.VerifyTraffic < 0 || netconf.VerifyTraffic > maxVerifyTraffic {
+		errs.add("$.verify_traffic", "must be in range 0 to %d, got %d", maxVerifyTraffic, netconf.VerifyTraffic)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateQinQ checks the S-VLAN and that the port carries no other VLANs
+func validateQinQ(netconf *types.NetConf, errs *ValidationErrors) {
+	qinq := netconf.QinQ
+	if qinq.SVlan < minTranslatedVlanID || qinq.SVlan > maxTranslatedVlanID {
+		errs.add("$.qinq.s_vlan", "must be in range %d to %d, got %d", minTranslatedVlanID, maxTranslatedVlanID, qinq.SVlan)
+	}
+	switch qinq.EthType {
+	case "", QinQEthType8021ad, QinQEthType8021q:
+	default:
+		errs.add("$.qinq.ethtype", "must be %q or %q, got %q", QinQEthType8021ad, QinQEthType8021q, qinq.EthType)
+	}
+	if netconf.VlanTag != nil || len(netconf.Trunk) > 0 {
+		errs.add("$.qinq", "can't be used with vlan or trunk")
+	}
+	if len(netconf.VlanTranslation) > 0 {
+		errs.add("$.qinq", "can't be used with vlan_translation")
+	}
+	if netconf.Mode == ModeRouted {
+		errs.add("$.qinq", "can't be used with routed mode")
+	}
+}
+
+// validateVlanMode checks the explicit vlan_mode is consistent with VLANs of
+// the port, OVS ignores the tag or trunks not used by the mode
+func validateVlanMode(netconf *types.NetConf, errs *ValidationErrors) {
+	hasTag := netconf.VlanTag != nil
+	switch netconf.VlanMode {
+	case VlanModeAccess:
+		if !hasTag {
+			errs.add("$.vlan_mode", "%q requires vlan", netconf.VlanMode)
+		}
+		if len(netconf.Trunk) > 0 {
+			errs.add("$.vlan_mode", "%q can't be used with trunk", netconf.VlanMode)
+		}
+	case VlanModeTrunk:
+		if hasTag {
+			errs.add("$.vlan_mode", "%q can't be used with vlan", netconf.VlanMode)
+		}
+	case VlanModeNativeTagged, VlanModeNativeUntagged:
+		if !hasTag {
+			errs.add("$.vlan_mode", "%q requires vlan as the native VLAN", netconf.VlanMode)
+		}
+	case VlanModeDot1qTunnel:
+		if !hasTag && netconf.QinQ == nil {
+			errs.add("$.vlan_mode", "%q requires vlan or qinq", netconf.VlanMode)
+		}
+		if len(netconf.Trunk) > 0 {
+			errs.add("$.vlan_mode", "%q can't be used with trunk", netconf.VlanMode)
+		}
+	default:
+		errs.add("$.vlan_mode", "must be one of %q, %q, %q, %q or %q, got %q", VlanModeAccess, VlanModeTrunk,
+			VlanModeNativeTagged, VlanModeNativeUntagged, VlanModeDot1qTunnel, netconf.VlanMode)
+		return
+	}
+	if netconf.QinQ != nil && netconf.VlanMode != VlanModeDot1qTunnel {
+		errs.add("$.vlan_mode", "must be %q with qinq, got %q", VlanModeDot1qTunnel, netconf.VlanMode)
+	}
+	if len(netconf.VlanTranslation) > 0 && netconf.VlanMode != VlanModeTrunk {
+		errs.add("$.vlan_mode", "must be %q with vlan_translation, got %q", VlanModeTrunk, netconf.VlanMode)
+	}
+}
+
+// validateVlanTranslation checks the translations and that the attachment
+// carries only translated VLANs
+func validateVlanTranslation(netconf *types.NetConf, errs *ValidationErrors) {
+	containerVlans := map[uint]bool{}
+	bridgeVlans := map[uint]bool{}
+	for i, translation := range netconf.VlanTranslation {
+		path := fmt.Sprintf("$.vlan_translation[%d]", i)
+		if translation == nil {
+			errs.add(path, "must not be null")
+			continue
+		}
+		if translation.Container < minTranslatedVlanID || translation.Container > maxTranslatedVlanID {
+			errs.add(path+".container", "must be in range %d to %d, got %d", minTranslatedVlanID, maxTranslatedVlanID, translation.Container)
+		}
+		if translation.Bridge < minTranslatedVlanID || translation.Bridge > maxTranslatedVlanID {
+			errs.add(path+".bridge", "must be in range %d to %d, got %d", minTranslatedVlanID, maxTranslatedVlanID, translation.Bridge)
+		}
+		if containerVlans[translation.Container] {
+			errs.add(path+".container", "VLAN %d is translated more than once", translation.Container)
+		}
+		if bridgeVlans[translation.Bridge] {
+			errs.add(path+".bridge", "VLAN %d is a translation of more than one VLAN", translation.Bridge)
+		}
+		containerVlans[translation.Container] = true
+		bridgeVlans[translation.Bridge] = true
+	}
+	// a translated packet is matched again, it must not be translated twice
+	for vlan := range containerVlans {
+		if bridgeVlans[vlan] {
+			errs.add("$.vlan_translation", "VLAN %d must not be both a container and a bridge VLAN", vlan)
+		}
+	}
+	if netconf.VlanTag != nil || len(netconf.Trunk) > 0 {
+		errs.add("$.vlan_translation", "can't be used with vlan or trunk")
+	}
+	if netconf.InterfaceType == VhostUserInterfaceType {
+		errs.add("$.vlan_translation", "can't be used with interface_type %q", VhostUserInterfaceType)
+	}
+	if netconf.Mode == ModeRouted {
+		errs.add("$.vlan_translation", "can't be used with routed mode")
+	}
+	if netconf.Backup != nil {
+		errs.add("$.vlan_translation", "can't be used with backup")
+	}
+}
+
+// ValidateIfName checks the netconf against CNI_IFNAME of the attachment,
+// like Validate it returns ValidationErrors
+func ValidateIfName(netconf *types.NetConf, ifName string) error {
+	var errs ValidationErrors
+	// the backup interface is named after the attachment by default
+	if netconf.Backup != nil && netconf.Backup.IfName == "" && len(ifName)+1 > maxIfNameLen {
+		errs.add("$.backup.interface", "must be set when CNI_IFNAME %q has more than %d characters", ifName, maxIfNameLen-1)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validQueueSize returns true when the queue size is not set or a power of 2
+// OVS accepts
+func validQueueSize(size uint) bool {
+	return size <= maxVhostUserQueueSize && size&(size-1) == 0
+}
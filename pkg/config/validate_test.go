@@ -0,0 +1,376 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+func validate(conf string) error {
+	netconf := &types.NetConf{}
+	ExpectWithOffset(1, json.Unmarshal([]byte(conf), netconf)).To(Succeed())
+	return Validate(netconf)
+}
+
+var _ = Describe("Validate", func() {
+	It("should accept a valid configuration", func() {
+		Expect(validate(`{"bridge": "br1", "vlan": 100, "mtu": 9000, "interface_type": "system"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "trunk": [{"id": 42}, {"minID": 1000, "maxID": 1010}]}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "vlan": 100, "trunk": [{"id": 42}]}`)).To(Succeed())
+	})
+	It("should reject invalid trunks", func() {
+		Expect(validate(`{"bridge": "br1", "trunk": [{"id": 4096}]}`)).To(MatchError(ContainSubstring("$.trunk[0].id")))
+		Expect(validate(`{"bridge": "br1", "trunk": [{"minID": 10, "maxID": 12}, {"minID": 1, "maxID": 5000}]}`)).To(MatchError(ContainSubstring("$.trunk[1].maxID")))
+		Expect(validate(`{"bridge": "br1", "trunk": [{"minID": 10, "maxID": 12}, {"minID": 11, "maxID": 5}]}`)).To(MatchError(ContainSubstring("$.trunk[1]")))
+	})
+	It("should reject internal port with deviceID", func() {
+		Expect(validate(`{"bridge": "br1", "interface_type": "internal"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "interface_type": "internal", "deviceID": "0000:00:01.0"}`)).To(MatchError(ContainSubstring(`$.interface_type: "internal" can't be used with deviceID`)))
+	})
+	It("should report all problems with their JSON path", func() {
+		err := validate(`{
+			"bridge": "br1",
+			"vlan": 5000,
+			"trunk": [{"id": 42}, {"minID": 20, "maxID": 10}, {"minID": 1}, {}],
+			"mtu": 10,
+			"ofport_request": 65280,
+			"interface_type": "bogus"
+		}`)
+		Expect(err).To(HaveOccurred())
+		var errs ValidationErrors
+		Expect(err).To(BeAssignableToTypeOf(errs))
+		errs = err.(ValidationErrors)
+		paths := []string{}
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		Expect(paths).To(Equal([]string{
+			"$.vlan",
+			"$.trunk[1]",
+			"$.trunk[2]",
+			"$.trunk[3]",
+			"$.mtu",
+			"$.ofport_request",
+			"$.interface_type",
+		}))
+		Expect(err.Error()).To(ContainSubstring("$.vlan: must be in range 0 to 4095, got 5000"))
+	})
+	It("should validate MAC prefixes", func() {
+		Expect(validate(`{"bridge": "br1", "mac_prefix": "0e:42", "allowed_mac_prefixes": ["0e"]}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "mac_prefix": "0c:42"}`)).To(MatchError(ContainSubstring("$.mac_prefix: must be a locally administered prefix")))
+		Expect(validate(`{"bridge": "br1", "mac_prefix": "0f"}`)).To(MatchError(ContainSubstring("$.mac_prefix: must be a unicast prefix")))
+		Expect(validate(`{"bridge": "br1", "mac_prefix": "0e:42", "allowed_mac_prefixes": ["0a"]}`)).To(MatchError(ContainSubstring("$.mac_prefix: is not within allowed_mac_prefixes")))
+		Expect(validate(`{"bridge": "br1", "allowed_mac_prefixes": ["zz"]}`)).To(MatchError(ContainSubstring("$.allowed_mac_prefixes[0]")))
+		Expect(validate(`{"bridge": "br1", "mac_derivation": "eui64"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "mac_derivation": "random"}`)).To(MatchError(ContainSubstring("$.mac_derivation: must be")))
+	})
+	It("should validate the attachment mode", func() {
+		Expect(validate(`{"bridge": "br1", "mode": "routed", "ipam": {"type": "host-local"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "mode": "routed"}`)).To(MatchError(ContainSubstring("$.mode: routed mode requires ipam")))
+		Expect(validate(`{"bridge": "br1", "mode": "flat"}`)).To(MatchError(ContainSubstring(`$.mode: must be "bridged" or "routed"`)))
+	})
+	It("should validate the link state policy", func() {
+		Expect(validate(`{"bridge": "br1", "link_state_policy": "retry"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "link_state_policy": "ignore"}`)).To(MatchError(ContainSubstring("$.link_state_policy")))
+	})
+	It("should validate the uplink check", func() {
+		Expect(validate(`{"bridge": "br1", "uplink_check": "fail", "uplink_ports": ["bond0"]}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "uplink_check": "ignore"}`)).To(MatchError(ContainSubstring(`$.uplink_check: must be "warn" or "fail"`)))
+		Expect(validate(`{"bridge": "br1", "uplink_ports": ["bond0"]}`)).To(MatchError(ContainSubstring("$.uplink_ports: requires uplink_check")))
+	})
+	It("should require an absolute stats file path", func() {
+		Expect(validate(`{"bridge": "br1", "stats_file": "/var/log/ovs-cni/stats.json"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "stats_file": "stats.json"}`)).To(MatchError(ContainSubstring("$.stats_file: must be an absolute path")))
+	})
+	It("should validate the port name template", func() {
+		Expect(validate(`{"bridge": "br1", "port_name_template": "veth{podName trunc}-{ifname}"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "port_name_template": "veth{uid}"}`)).To(MatchError(ContainSubstring("$.port_name_template: unknown placeholder {uid}")))
+		Expect(validate(`{"bridge": "br1", "port_name_template": "{ifname}"}`)).To(MatchError(ContainSubstring(`$.port_name_template: "{ifname}" doesn't start with "veth"`)))
+		Expect(validate(`{"bridge": "br1", "port_name_template": "veth{hash}", "stable_port_names": true}`)).To(MatchError(ContainSubstring("$.port_name_template: is not supported with stable_port_names")))
+	})
+	It("should validate the ofport range", func() {
+		Expect(validate(`{"bridge": "br1", "ofport_range": {"min": 1000, "max": 2000}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ofport_range": {"min": 1000, "max": 1000}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ofport_range": {"max": 2000}}`)).To(MatchError(ContainSubstring("$.ofport_range.min: must be in range 1 to 65279, got 0")))
+		Expect(validate(`{"bridge": "br1", "ofport_range": {"min": 1000, "max": 70000}}`)).To(MatchError(ContainSubstring("$.ofport_range.max: must be in range 1 to 65279")))
+		Expect(validate(`{"bridge": "br1", "ofport_range": {"min": 2000, "max": 1000}}`)).To(MatchError(ContainSubstring("$.ofport_range: min 2000 must not exceed max 1000")))
+	})
+	It("should require an absolute CHECK report dir", func() {
+		Expect(validate(`{"bridge": "br1", "check_report_dir": "/var/run/ovs-cni/check"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "check_report_dir": "check"}`)).To(MatchError(ContainSubstring("$.check_report_dir: must be an absolute path")))
+		Expect(validate(`{"bridge": "br1", "check_cache_ttl": 60}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "check_cache_ttl": 3601}`)).To(MatchError(ContainSubstring("$.check_cache_ttl: must be in range 0 to 3600, got 3601")))
+	})
+	It("should validate retainOnDelete", func() {
+		Expect(validate(`{"bridge": "br1", "retainOnDelete": true, "retainOnDeleteTimeout": 60}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "retainOnDelete": true, "interface_type": "internal"}`)).To(MatchError(ContainSubstring(`$.retainOnDelete: can't be used with interface_type "internal"`)))
+		Expect(validate(`{"bridge": "br1", "retainOnDelete": true, "interface_type": "dpdkvhostuserclient"}`)).To(MatchError(ContainSubstring(`$.retainOnDelete: can't be used with interface_type "dpdkvhostuserclient"`)))
+		Expect(validate(`{"bridge": "br1", "retainOnDelete": true, "tap": {}}`)).To(MatchError(ContainSubstring("$.retainOnDelete: can't be used with tap")))
+		Expect(validate(`{"bridge": "br1", "retainOnDelete": true, "infra_netns": "/var/run/netns/infra"}`)).To(MatchError(ContainSubstring("$.retainOnDelete: can't be used with infra_netns")))
+	})
+	It("should validate preserve_addresses", func() {
+		Expect(validate(`{"bridge": "br1", "preserve_addresses": true}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "preserve_addresses": true, "interface_type": "internal"}`)).To(MatchError(ContainSubstring(`$.preserve_addresses: can't be used with interface_type "internal"`)))
+		Expect(validate(`{"bridge": "br1", "preserve_addresses": true, "tap": {}}`)).To(MatchError(ContainSubstring("$.preserve_addresses: can't be used with tap")))
+		Expect(validate(`{"bridge": "br1", "preserve_addresses": true, "deviceID": "0000:03:00.2"}`)).To(MatchError(ContainSubstring("$.preserve_addresses: can't be used with SR-IOV VFs")))
+	})
+	It("should validate representor lookup", func() {
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:03:00.2", "representor": {"name_template": "{uplink}_rep{vf}"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:03:00.2", "representor": {"phys_port_name": "pf0vf{vf}"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "representor": {"name_template": "{uplink}_rep{vf}", "phys_port_name": "pf0vf{vf}"}}`)).To(MatchError(ContainSubstring("$.representor: name_template and phys_port_name are mutually exclusive")))
+		Expect(validate(`{"bridge": "br1", "representor": {"name_template": "rep0"}}`)).To(MatchError(ContainSubstring("$.representor.name_template: must contain {vf}")))
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:03:00.2", "representor": {"dpu_name": "c1pf{pf}vf{vf}"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "representor": {"dpu_name": "pf0vf"}}`)).To(MatchError(ContainSubstring("$.representor.dpu_name: must contain {vf}")))
+	})
+	It("should validate capture settings", func() {
+		Expect(validate(`{"bridge": "br1", "capture": {"port": "capture0", "duration": 30}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "capture": {"duration": 30}}`)).To(MatchError(ContainSubstring("$.capture.port: must be set")))
+		Expect(validate(`{"bridge": "br1", "capture": {"port": "capture0"}, "ovsdb_least_privilege": true}`)).To(MatchError(ContainSubstring("$.capture: can't be used with ovsdb_least_privilege")))
+	})
+	It("should validate hooks", func() {
+		Expect(validate(`{"bridge": "br1", "hooks": {"post_add": "register", "pre_del": "unregister", "timeout": 5}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "hooks": {"post_add": "/usr/bin/register"}}`)).To(MatchError(ContainSubstring("$.hooks.post_add: must be a file name")))
+		Expect(validate(`{"bridge": "br1", "hooks": {"pre_del": ".."}}`)).To(MatchError(ContainSubstring("$.hooks.pre_del: must be a file name")))
+		Expect(validate(`{"bridge": "br1", "hooks": {"timeout": -1}}`)).To(MatchError(ContainSubstring("$.hooks.timeout: must not be negative")))
+	})
+	It("should validate rate limit settings", func() {
+		Expect(validate(`{"bridge": "br1", "rate_limit": {"rate": 100000}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "rate_limit": {"rate": 100000, "burst": 10000, "method": "tc"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "rate_limit": {"burst": 1000}}`)).To(MatchError(ContainSubstring("$.rate_limit.rate: must be set")))
+		Expect(validate(`{"bridge": "br1", "rate_limit": {"rate": 1000, "method": "tc"}}`)).To(MatchError(ContainSubstring(`$.rate_limit.method: "tc" requires deviceID`)))
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:03:00.2", "bridge_socket_file": "tcp:192.168.100.2:6640", "rate_limit": {"rate": 1000, "method": "tc"}}`)).To(MatchError(ContainSubstring(`$.rate_limit.method: "tc" can't be used with bridge_socket_file`)))
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "rate_limit": {"rate": 40000000, "method": "tc"}}`)).To(MatchError(ContainSubstring("$.rate_limit.rate: must be at most 34359738")))
+		Expect(validate(`{"bridge": "br1", "rate_limit": {"rate": 1000, "method": "qos"}}`)).To(MatchError(ContainSubstring("$.rate_limit.method: must be")))
+	})
+	It("should validate degraded mode", func() {
+		Expect(validate(`{"bridge": "br1", "ovs_unavailable": {"action": "fail"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ovs_unavailable": {"action": "delegate", "plugin": {"type": "macvlan", "master": "eth0"}}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ovs_unavailable": {"action": "delegate"}}`)).To(MatchError(ContainSubstring("$.ovs_unavailable.plugin.type: must be set")))
+		Expect(validate(`{"bridge": "br1", "ovs_unavailable": {"action": "fail", "plugin": {"type": "macvlan"}}}`)).To(MatchError(ContainSubstring(`$.ovs_unavailable.plugin: requires action "delegate"`)))
+		Expect(validate(`{"bridge": "br1", "ovs_unavailable": {"action": "ignore"}}`)).To(MatchError(ContainSubstring("$.ovs_unavailable.action: must be")))
+	})
+	It("should validate backup interface", func() {
+		Expect(validate(`{"bridge": "br1", "backup": {"bridge": "br2", "interface": "net1b"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "backup": {}}`)).To(MatchError(ContainSubstring("$.backup.bridge: must be set")))
+		Expect(validate(`{"bridge": "br1", "backup": {"bridge": "br1"}}`)).To(MatchError(ContainSubstring(`$.backup.bridge: must differ from bridge "br1"`)))
+		Expect(validate(`{"bridge": "br1", "backup": {"bridge": "br2", "interface": "averyverylongname"}}`)).To(MatchError(ContainSubstring("$.backup.interface: must be at most 15 characters")))
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "backup": {"bridge": "br2"}}`)).To(MatchError(ContainSubstring("$.backup: can't be used with deviceID")))
+		Expect(validate(`{"bridge": "br1", "interface_type": "internal", "backup": {"bridge": "br2"}}`)).To(MatchError(ContainSubstring(`$.backup: can't be used with interface_type "internal"`)))
+	})
+	It("should validate the default backup interface name", func() {
+		netconf := &types.NetConf{BrName: "br1", Backup: &types.Backup{Bridge: "br2"}}
+		Expect(ValidateIfName(netconf, "net1")).To(Succeed())
+		Expect(ValidateIfName(netconf, "averylongifnam")).To(Succeed())
+		Expect(ValidateIfName(netconf, "averylongifname")).To(MatchError(ContainSubstring(`$.backup.interface: must be set when CNI_IFNAME "averylongifname" has more than 14 characters`)))
+		netconf.Backup.IfName = "bk0"
+		Expect(ValidateIfName(netconf, "averylongifname")).To(Succeed())
+		Expect(ValidateIfName(&types.NetConf{BrName: "br1"}, "averylongifname")).To(Succeed())
+	})
+	It("should require ipam with spec versions before 0.3.0", func() {
+		Expect(validate(`{"cniVersion": "0.2.0", "bridge": "br1", "ipam": {"type": "host-local"}}`)).To(Succeed())
+		Expect(validate(`{"cniVersion": "0.3.0", "bridge": "br1"}`)).To(Succeed())
+		Expect(validate(`{"cniVersion": "0.1.0", "bridge": "br1"}`)).To(MatchError(ContainSubstring("$.ipam: must be set with cniVersion 0.1.0, its results carry only addresses")))
+		Expect(validate(`{"cniVersion": "0.2.0", "bridge": "br1"}`)).To(MatchError(ContainSubstring("$.ipam: must be set with cniVersion 0.2.0")))
+	})
+	It("should validate tap", func() {
+		Expect(validate(`{"bridge": "br1", "tap": {"uid": 107, "gid": 107, "queues": 4}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "tap": {"uid": -1}}`)).To(MatchError(ContainSubstring("$.tap.uid: must not be negative")))
+		Expect(validate(`{"bridge": "br1", "tap": {"queues": 1000}}`)).To(MatchError(ContainSubstring("$.tap.queues: must be in range 0 to 256")))
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "tap": {}}`)).To(MatchError(ContainSubstring("$.tap: can't be used with deviceID")))
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "host-local"}, "tap": {}}`)).To(MatchError(ContainSubstring("$.tap: can't be used with ipam")))
+	})
+	It("should validate bond", func() {
+		Expect(validate(`{"bridge": "br1", "bond": {"name": "bond0", "mode": "balance-xor", "miimon": 50}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "bond": {}}`)).To(MatchError(ContainSubstring("$.bond.name: must be set")))
+		Expect(validate(`{"bridge": "br1", "bond": {"name": "averyverylongname"}}`)).To(MatchError(ContainSubstring("$.bond.name: must be at most 15 characters")))
+		Expect(validate(`{"bridge": "br1", "bond": {"name": "bond0", "mode": "802.3ad"}}`)).To(MatchError(ContainSubstring("$.bond.mode: must be")))
+		Expect(validate(`{"bridge": "br1", "mode": "routed", "bond": {"name": "bond0"}}`)).To(MatchError(ContainSubstring("$.bond: can't be used with routed mode")))
+		Expect(validate(`{"bridge": "br1", "backup": {"bridge": "br2"}, "bond": {"name": "bond0"}}`)).To(MatchError(ContainSubstring("$.bond: can't be used with backup")))
+	})
+	It("should validate VFs of a bonded attachment", func() {
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "deviceIDs": ["0000:00:01.0", "0000:00:02.0"], "featureGates": {"BondedVFs": true}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "deviceIDs": ["0000:00:01.0", "0000:00:02.0"]}`)).To(MatchError(ContainSubstring("$.deviceIDs: requires feature gate BondedVFs")))
+		Expect(validate(`{"bridge": "br1", "deviceIDs": ["0000:00:01.0"]}`)).To(MatchError(ContainSubstring("$.deviceIDs: must have 2 VFs, got 1")))
+		Expect(validate(`{"bridge": "br1", "deviceIDs": ["0000:00:01.0", "0000:00:01.0"]}`)).To(MatchError(ContainSubstring("$.deviceIDs: must have different VFs")))
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:03.0", "deviceIDs": ["0000:00:01.0", "0000:00:02.0"]}`)).To(MatchError(ContainSubstring("$.deviceID: must be one of deviceIDs")))
+		Expect(validate(`{"bridge": "br1", "deviceIDs": ["0000:00:01.0", "0000:00:02.0"], "bond": {"name": "bond0"}}`)).To(MatchError(ContainSubstring("$.deviceIDs: can't be used with bond")))
+	})
+	It("should reject unknown feature gates", func() {
+		Expect(validate(`{"bridge": "br1", "featureGates": {"BondedVFs": false}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "featureGates": {"BondedVF": true}}`)).To(MatchError(ContainSubstring("$.featureGates.BondedVF: unknown feature gate, known are [BondedVFs]")))
+	})
+	It("should validate VF tuning", func() {
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "vf_tuning": {"channels": {"combined": 4}, "coalesce": {"rx_usecs": 50, "adaptive_rx": false}}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "vf_tuning": {"channels": {"combined": 4}}}`)).To(MatchError(ContainSubstring("$.vf_tuning: requires deviceID")))
+	})
+	It("should validate inline static addresses", func() {
+		Expect(validate(`{"bridge": "br1", "static": {"addresses": [{"address": "10.1.0.5/24", "gateway": "10.1.0.1"}]}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "static": {}}`)).To(MatchError(ContainSubstring("$.static.addresses: must not be empty")))
+		Expect(validate(`{"bridge": "br1", "static": {"addresses": [{"address": "10.1.0.5"}]}}`)).To(MatchError(ContainSubstring("$.static.addresses[0].address")))
+		Expect(validate(`{"bridge": "br1", "static": {"addresses": [{"address": "10.1.0.5/24", "gateway": "gw"}]}}`)).To(MatchError(ContainSubstring("$.static.addresses[0].gateway")))
+	})
+	It("should validate the IPv6 IPAM plugin", func() {
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "whereabouts"}, "ipam_v6": {"type": "static"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "whereabouts"}, "ipam_v6": {}}`)).To(MatchError(ContainSubstring("$.ipam_v6.type: must be set")))
+		Expect(validate(`{"bridge": "br1", "ipam_v6": {"type": "static"}}`)).To(MatchError(ContainSubstring("$.ipam_v6: requires ipam")))
+	})
+	It("should validate the infra netns", func() {
+		Expect(validate(`{"bridge": "br1", "infra_netns": "/var/run/netns/cni"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "infra_netns": "cni"}`)).To(MatchError(ContainSubstring("$.infra_netns: must be an absolute path")))
+		Expect(validate(`{"bridge": "br1", "infra_netns": "/var/run/netns/cni", "deviceID": "0000:00:00.1"}`)).To(MatchError(ContainSubstring("$.infra_netns: can't be used with deviceID")))
+		Expect(validate(`{"bridge": "br1", "infra_netns": "/var/run/netns/cni", "interface_type": "internal"}`)).To(MatchError(ContainSubstring(`$.infra_netns: can't be used with interface_type "internal"`)))
+	})
+	It("should validate gratuitous ARPs", func() {
+		Expect(validate(`{"bridge": "br1", "garp": {"count": 5, "interval": 500}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "garp": {"disabled": true}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "garp": {"count": 11}}`)).To(MatchError(ContainSubstring("$.garp.count: must be in range 1 to 10, got 11")))
+		Expect(validate(`{"bridge": "br1", "garp": {"interval": -1}}`)).To(MatchError(ContainSubstring("$.garp.interval: must be in range 1 to 5000, got -1")))
+		Expect(validate(`{"bridge": "br1", "garp": {"dad_timeout": 3}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "host-local"}, "ip_conflict": {"timeout": 500, "reallocations": 2}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ip_conflict": {}}`)).To(MatchError(ContainSubstring("$.ip_conflict: requires ipam")))
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "host-local"}, "mode": "routed", "ip_conflict": {}}`)).To(MatchError(ContainSubstring("$.ip_conflict: can't be used with routed mode")))
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "host-local"}, "ip_conflict": {"reallocations": 6}}`)).To(MatchError(ContainSubstring("$.ip_conflict.reallocations: must be in range 0 to 5, got 6")))
+		Expect(validate(`{"bridge": "br1", "garp": {"dad_timeout": 31}}`)).To(MatchError(ContainSubstring("$.garp.dad_timeout: must be in range 0 to 30, got 31")))
+	})
+	It("should validate the connectivity probe", func() {
+		Expect(validate(`{"bridge": "br1", "probe": {"target": "10.1.0.1", "count": 5}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "probe": {"target": "gateway"}}`)).To(MatchError(ContainSubstring("$.probe.target: must be an IP address")))
+		Expect(validate(`{"bridge": "br1", "probe": {"timeout": -1}}`)).To(MatchError(ContainSubstring("$.probe.timeout: must be in range 1 to 5000, got -1")))
+		Expect(validate(`{"bridge": "br1", "probe": {"count": 11}}`)).To(MatchError(ContainSubstring("$.probe.count: must be in range 1 to 10, got 11")))
+	})
+	It("should cap the total time ADD waits", func() {
+		Expect(validate(`{"bridge": "br1", "probe": {"count": 10, "timeout": 3000}, "garp": {"count": 10, "interval": 3000, "dad_timeout": 3}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "probe": {"count": 10, "timeout": 5000}, "garp": {"count": 10, "interval": 5000}}`)).To(MatchError(ContainSubstring("$: probe, garp and ip_conflict make ADD wait up to 1m35s, must be at most 1m0s")))
+		Expect(validate(`{"bridge": "br1", "garp": {"count": 10, "interval": 5000, "dad_timeout": 30}}`)).To(MatchError(ContainSubstring("make ADD wait up to 1m15s")))
+		Expect(validate(`{"bridge": "br1", "garp": {"disabled": true, "count": 10, "interval": 5000, "dad_timeout": 30}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "host-local"}, "ip_conflict": {"timeout": 5000, "reallocations": 5}, "garp": {"count": 2, "interval": 1, "dad_timeout": 30}}`)).To(MatchError(ContainSubstring("make ADD wait up to 1m0.001s")))
+	})
+	It("should validate IPAM of userspace VFs", func() {
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "ipam": {"type": "static"}, "userspace_ipam": true}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ipam": {"type": "static"}, "userspace_ipam": true}`)).To(MatchError(ContainSubstring("$.userspace_ipam: requires deviceID")))
+		Expect(validate(`{"bridge": "br1", "deviceID": "0000:00:01.0", "userspace_ipam": true}`)).To(MatchError(ContainSubstring("$.userspace_ipam: requires ipam")))
+	})
+	It("should validate handling of missing prevResult", func() {
+		Expect(validate(`{"bridge": "br1", "missing_prev_result": "warn"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "missing_prev_result": "ignore"}`)).To(MatchError(ContainSubstring("$.missing_prev_result: must be")))
+	})
+	It("should validate mtu_check", func() {
+		Expect(validate(`{"bridge": "br1", "mtu": 9000, "mtu_check": "clamp"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "mtu_check": "fail"}`)).To(MatchError(ContainSubstring(`$.mtu_check: must be "warn", "clamp" or "off", got "fail"`)))
+	})
+	It("should validate bridge templates", func() {
+		Expect(validate(`{"bridge": "br-{label:topology.kubernetes.io/zone}", "node_labels_file": "/run/labels"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br-{zone}"}`)).To(MatchError(ContainSubstring("$.bridge: placeholder {zone} must be {label:<key>}")))
+		Expect(validate(`{"bridge": "br-{label:zone"}`)).To(MatchError(ContainSubstring("$.bridge: unbalanced braces")))
+		Expect(validate(`{"bridge": "br1", "node_labels_file": "labels"}`)).To(MatchError(ContainSubstring("$.node_labels_file: must be an absolute path")))
+	})
+	It("should validate ingress_rate_limit", func() {
+		Expect(validate(`{"bridge": "br1", "ingress_rate_limit": {"rate": 10000}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "ingress_rate_limit": {"burst": 1000}}`)).To(MatchError(ContainSubstring("$.ingress_rate_limit.rate: must be set")))
+	})
+	It("should validate qos", func() {
+		Expect(validate(`{"bridge": "br1", "qos": {"min_rate": 1000, "max_rate": 10000, "burst": 100, "priority": 1}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "qos": {"type": "linux-hfsc", "min_rate": 1000}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "qos": {"type": "linux-hfsc", "max_rate": 1000, "burst": 100}}`)).To(MatchError(ContainSubstring(`$.qos.burst: can't be used with type "linux-hfsc"`)))
+		Expect(validate(`{"bridge": "br1", "qos": {"type": "linux-sfq", "max_rate": 1000}}`)).To(MatchError(ContainSubstring("$.qos.type")))
+		Expect(validate(`{"bridge": "br1", "qos": {}}`)).To(MatchError(ContainSubstring("$.qos: requires min_rate or max_rate")))
+		Expect(validate(`{"bridge": "br1", "qos": {"min_rate": 2000, "max_rate": 1000}}`)).To(MatchError(ContainSubstring("$.qos.min_rate: must not exceed max_rate 1000")))
+		Expect(validate(`{"bridge": "br1", "qos": {"max_rate": 1000}, "ingress_rate_limit": {"rate": 1000}}`)).To(MatchError(ContainSubstring("$.qos: can't be used with ingress_rate_limit")))
+	})
+	It("should validate qinq", func() {
+		Expect(validate(`{"bridge": "br1", "qinq": {"s_vlan": 100}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "qinq": {"s_vlan": 100, "ethtype": "802.1q"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "qinq": {"s_vlan": 0, "ethtype": "0x88a8"}}`)).To(MatchError(And(
+			ContainSubstring("$.qinq.s_vlan: must be in range 1 to 4094, got 0"),
+			ContainSubstring("$.qinq.ethtype"))))
+		Expect(validate(`{"bridge": "br1", "vlan": 10, "qinq": {"s_vlan": 100}}`)).To(MatchError(ContainSubstring("$.qinq: can't be used with vlan or trunk")))
+		Expect(validate(`{"bridge": "br1", "mode": "routed", "qinq": {"s_vlan": 100}}`)).To(MatchError(ContainSubstring("$.qinq: can't be used with routed mode")))
+	})
+	It("should validate vlan_mode", func() {
+		Expect(validate(`{"bridge": "br1", "vlan": 10, "vlan_mode": "native-untagged", "trunk": [{"id": 20}]}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "vlan": 10, "vlan_mode": "dot1q-tunnel"}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "vlan_mode": "trunk", "trunk": [{"id": 20}]}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "vlan_mode": "access", "trunk": [{"id": 20}]}`)).To(MatchError(And(
+			ContainSubstring(`$.vlan_mode: "access" requires vlan`),
+			ContainSubstring(`$.vlan_mode: "access" can't be used with trunk`))))
+		Expect(validate(`{"bridge": "br1", "vlan": 10, "vlan_mode": "trunk"}`)).To(MatchError(ContainSubstring(`$.vlan_mode: "trunk" can't be used with vlan`)))
+		Expect(validate(`{"bridge": "br1", "vlan_mode": "native-tagged"}`)).To(MatchError(ContainSubstring(`$.vlan_mode: "native-tagged" requires vlan as the native VLAN`)))
+		Expect(validate(`{"bridge": "br1", "vlan_mode": "native-untagged", "qinq": {"s_vlan": 100}}`)).To(MatchError(ContainSubstring(`$.vlan_mode: must be "dot1q-tunnel" with qinq`)))
+		Expect(validate(`{"bridge": "br1", "vlan_mode": "tagged"}`)).To(MatchError(ContainSubstring(`$.vlan_mode: must be one of`)))
+	})
+	It("should validate accept_ra and ipv6_autoconf", func() {
+		Expect(validate(`{"bridge": "br1", "accept_ra": false, "ipv6_autoconf": false}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "accept_ra": false, "ipv6_autoconf": true}`)).To(MatchError(And(
+			ContainSubstring("$.accept_ra: can't be used with interface_type"),
+			ContainSubstring("$.ipv6_autoconf: can't be used with interface_type"))))
+	})
+	It("should validate altnames", func() {
+		Expect(validate(`{"bridge": "br1", "altnames": ["net1-storage", "storage"]}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "altnames": ["net1/storage", ""]}`)).To(MatchError(And(
+			ContainSubstring("$.altnames[0]: must not contain '/', ':' or whitespace"),
+			ContainSubstring("$.altnames[1]: must be a valid interface name"))))
+		Expect(validate(`{"bridge": "br1", "altnames": ["storage", "storage"]}`)).To(MatchError(ContainSubstring("$.altnames[1]: duplicates altname \"storage\"")))
+		Expect(validate(fmt.Sprintf(`{"bridge": "br1", "altnames": ["%s"]}`, strings.Repeat("a", 128)))).To(MatchError(ContainSubstring("must be at most 127 characters")))
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "altnames": ["storage"]}`)).To(MatchError(ContainSubstring("$.altnames: can't be used with interface_type")))
+	})
+	It("should validate vlan_translation", func() {
+		Expect(validate(`{"bridge": "br1", "vlan_translation": [{"container": 100, "bridge": 1100}, {"container": 200, "bridge": 1200}]}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "vlan_translation": [{"container": 0, "bridge": 4095}]}`)).To(MatchError(And(
+			ContainSubstring("$.vlan_translation[0].container: must be in range 1 to 4094, got 0"),
+			ContainSubstring("$.vlan_translation[0].bridge: must be in range 1 to 4094, got 4095"))))
+		Expect(validate(`{"bridge": "br1", "vlan_translation": [{"container": 100, "bridge": 1100}, {"container": 100, "bridge": 1200}]}`)).To(MatchError(ContainSubstring("$.vlan_translation[1].container: VLAN 100 is translated more than once")))
+		Expect(validate(`{"bridge": "br1", "vlan_translation": [{"container": 100, "bridge": 200}, {"container": 200, "bridge": 300}]}`)).To(MatchError(ContainSubstring("VLAN 200 must not be both a container and a bridge VLAN")))
+		Expect(validate(`{"bridge": "br1", "vlan": 100, "vlan_translation": [{"container": 100, "bridge": 1100}]}`)).To(MatchError(ContainSubstring("$.vlan_translation: can't be used with vlan or trunk")))
+	})
+	It("should validate vhost-user queues", func() {
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "vhost_user": {"queues": {"n_rxq": 4, "n_txq": 4, "n_rxq_desc": 1024, "n_txq_desc": 2048}}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "vhost_user": {"queues": {"n_rxq": 2000}}}`)).To(MatchError(ContainSubstring("$.vhost_user.queues.n_rxq: must be in range 0 to 1024")))
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "vhost_user": {"queues": {"n_txq_desc": 1000}}}`)).To(MatchError(ContainSubstring("$.vhost_user.queues.n_txq_desc: must be a power of 2")))
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "vhost_user": {"queues": {"n_rxq_desc": 8192}}}`)).To(MatchError(ContainSubstring("$.vhost_user.queues.n_rxq_desc")))
+	})
+	It("should validate vhost-user settings", func() {
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "vhost_user": {"uid": 1000, "gid": 1000, "selinux_context": "system_u:object_r:container_file_t:s0"}}`)).To(Succeed())
+		Expect(validate(`{"bridge": "br1", "vhost_user": {"uid": 1000}}`)).To(MatchError(ContainSubstring("$.vhost_user: requires interface_type")))
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "vhost_user": {"socket_dir": "sockets"}}`)).To(MatchError(ContainSubstring("$.vhost_user.socket_dir: must be an absolute path")))
+		Expect(validate(`{"bridge": "br1", "interface_type": "dpdkvhostuserclient", "vhost_user": {"selinux_context": "container_file_t"}}`)).To(MatchError(ContainSubstring("$.vhost_user.selinux_context")))
+	})
+	It("should match MAC addresses against prefixes", func() {
+		prefixes, err := AllowedMACPrefixes([]string{"0e:42", "02:00:00"})
+		Expect(err).NotTo(HaveOccurred())
+		mac, err := net.ParseMAC("0e:42:01:02:03:04")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(MACAllowed(mac, prefixes)).To(BeTrue())
+		mac, err = net.ParseMAC("02:00:01:02:03:04")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(MACAllowed(mac, prefixes)).To(BeFalse())
+		Expect(MACAllowed(mac, nil)).To(BeTrue())
+	})
+})
+
+var _ = Describe("ValidateMirror", func() {
+	It("should cap the time traffic of mirrors is verified", func() {
+		Expect(ValidateMirror(&types.MirrorNetConf{VerifyTraffic: 60})).To(Succeed())
+		Expect(ValidateMirror(&types.MirrorNetConf{VerifyTraffic: 61})).To(MatchError(ContainSubstring("$.verify_traffic: must be in range 0 to 60, got 61")))
+		Expect(ValidateMirror(&types.MirrorNetConf{VerifyTraffic: -1})).To(MatchError(ContainSubstring("$.verify_traffic: must be in range 0 to 60, got -1")))
+	})
+})
@@ -0,0 +1,226 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ethtool implements the subset of ethtool settings used by ovs-cni
+// on top of github.com/safchain/ethtool. All functions operate on interfaces
+// of the network namespace of the calling thread.
+package ethtool
+
+import (
+	"fmt"
+
+	"github.com/safchain/ethtool"
+	"golang.org/x/sys/unix"
+)
+
+// Feature is an offload feature as known by ethtool -K, it toggles all the
+// netdev features it consists of
+type Feature struct {
+	Name     string
+	features []string
+}
+
+var (
+	// TSO TCP segmentation offload
+	TSO = Feature{Name: "tso", features: []string{"tx-tcp-segmentation", "tx-tcp-ecn-segmentation", "tx-tcp-mangleid-segmentation", "tx-tcp6-segmentation"}}
+	// GSO generic segmentation offload
+	GSO = Feature{Name: "gso", features: []string{"tx-generic-segmentation"}}
+	// RxChecksum receive checksum offload
+	RxChecksum = Feature{Name: "rx-checksum", features: []string{"rx-checksum"}}
+	// TxChecksum transmit checksum offload
+	TxChecksum = Feature{Name: "tx-checksum", features: []string{"tx-checksum-ipv4", "tx-checksum-ip-generic", "tx-checksum-ipv6", "tx-checksum-fcoe-crc", "tx-checksum-sctp"}}
+)
+
+// GetFeature returns whether the offload feature is enabled on the interface,
+// it is when any of its netdev features is
+func GetFeature(ifName string, feature Feature) (bool, error) {
+	var enabled bool
+	err := withEthtool(ifName, func(e *ethtool.Ethtool) error {
+		features, err := e.Features(ifName)
+		if err != nil {
+			return err
+		}
+		for _, name := range feature.features {
+			enabled = enabled || features[name]
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get %s of %s: %v", feature.Name, ifName, err)
+	}
+	return enabled, nil
+}
+
+// SetFeature enables or disables the offload feature on the interface, the
+// kernel leaves netdev features the interface can't change as they are
+func SetFeature(ifName string, feature Feature, enabled bool) error {
+	err := withEthtool(ifName, func(e *ethtool.Ethtool) error {
+		names, err := e.FeatureNames(ifName)
+		if err != nil {
+			return err
+		}
+		config := map[string]bool{}
+		for _, name := range feature.features {
+			// older kernels don't know all of them
+			if _, found := names[name]; found {
+				config[name] = enabled
+			}
+		}
+		return e.Change(ifName, config)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set %s of %s to %t: %v", feature.Name, ifName, enabled, err)
+	}
+	return nil
+}
+
+// Channels are queue counts of an interface, nil counts are left unchanged
+type Channels struct {
+	Rx       *uint32
+	Tx       *uint32
+	Combined *uint32
+}
+
+// GetChannels returns queue counts of the interface, like ethtool -l
+func GetChannels(ifName string) (Channels, error) {
+	var value ethtool.Channels
+	err := withEthtool(ifName, func(e *ethtool.Ethtool) (err error) {
+		value, err = e.GetChannels(ifName)
+		return err
+	})
+	if err != nil {
+		return Channels{}, fmt.Errorf("failed to get channels of %s: %v", ifName, err)
+	}
+	return Channels{Rx: &value.RxCount, Tx: &value.TxCount, Combined: &value.CombinedCount}, nil
+}
+
+// SetChannels sets queue counts of the interface, like ethtool -L
+func SetChannels(ifName string, channels Channels) error {
+	return withEthtool(ifName, func(e *ethtool.Ethtool) error {
+		value, err := e.GetChannels(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to get channels of %s: %v", ifName, err)
+		}
+		for _, channel := range []struct {
+			name  string
+			count *uint32
+			max   uint32
+			value *uint32
+		}{
+			{"rx", channels.Rx, value.MaxRx, &value.RxCount},
+			{"tx", channels.Tx, value.MaxTx, &value.TxCount},
+			{"combined", channels.Combined, value.MaxCombined, &value.CombinedCount},
+		} {
+			if channel.count == nil {
+				continue
+			}
+			if *channel.count > channel.max {
+				return fmt.Errorf("%s channels of %s must be at most %d, got %d", channel.name, ifName, channel.max, *channel.count)
+			}
+			*channel.value = *channel.count
+		}
+		if _, err := e.SetChannels(ifName, value); err != nil {
+			return fmt.Errorf("failed to set channels of %s: %v", ifName, err)
+		}
+		return nil
+	})
+}
+
+// Coalesce is interrupt coalescing of an interface, nil values are left unchanged
+type Coalesce struct {
+	RxUsecs    *uint32
+	RxFrames   *uint32
+	TxUsecs    *uint32
+	TxFrames   *uint32
+	AdaptiveRx *bool
+	AdaptiveTx *bool
+}
+
+// GetCoalesce returns interrupt coalescing of the interface, like ethtool -c
+func GetCoalesce(ifName string) (Coalesce, error) {
+	var value ethtool.Coalesce
+	err := withEthtool(ifName, func(e *ethtool.Ethtool) (err error) {
+		value, err = e.GetCoalesce(ifName)
+		return err
+	})
+	if err != nil {
+		return Coalesce{}, fmt.Errorf("failed to get coalescing of %s: %v", ifName, err)
+	}
+	adaptiveRx := value.UseAdaptiveRxCoalesce != 0
+	adaptiveTx := value.UseAdaptiveTxCoalesce != 0
+	return Coalesce{
+		RxUsecs:    &value.RxCoalesceUsecs,
+		RxFrames:   &value.RxMaxCoalescedFrames,
+		TxUsecs:    &value.TxCoalesceUsecs,
+		TxFrames:   &value.TxMaxCoalescedFrames,
+		AdaptiveRx: &adaptiveRx,
+		AdaptiveTx: &adaptiveTx,
+	}, nil
+}
+
+// SetCoalesce sets interrupt coalescing of the interface, like ethtool -C
+func SetCoalesce(ifName string, coalesce Coalesce) error {
+	return withEthtool(ifName, func(e *ethtool.Ethtool) error {
+		value, err := e.GetCoalesce(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to get coalescing of %s: %v", ifName, err)
+		}
+		for _, setting := range []struct {
+			value *uint32
+			field *uint32
+		}{
+			{coalesce.RxUsecs, &value.RxCoalesceUsecs},
+			{coalesce.RxFrames, &value.RxMaxCoalescedFrames},
+			{coalesce.TxUsecs, &value.TxCoalesceUsecs},
+			{coalesce.TxFrames, &value.TxMaxCoalescedFrames},
+		} {
+			if setting.value != nil {
+				*setting.field = *setting.value
+			}
+		}
+		for _, setting := range []struct {
+			enabled *bool
+			field   *uint32
+		}{
+			{coalesce.AdaptiveRx, &value.UseAdaptiveRxCoalesce},
+			{coalesce.AdaptiveTx, &value.UseAdaptiveTxCoalesce},
+		} {
+			if setting.enabled == nil {
+				continue
+			}
+			*setting.field = 0
+			if *setting.enabled {
+				*setting.field = 1
+			}
+		}
+		if _, err := e.SetCoalesce(ifName, value); err != nil {
+			return fmt.Errorf("failed to set coalescing of %s: %v", ifName, err)
+		}
+		return nil
+	})
+}
+
+// withEthtool runs f with an ethtool handle, names too long for the kernel
+// are rejected as the library would silently truncate them
+func withEthtool(ifName string, f func(e *ethtool.Ethtool) error) error {
+	if len(ifName) >= unix.IFNAMSIZ {
+		return fmt.Errorf("interface name %s is too long", ifName)
+	}
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	return f(e)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethtool
+
+import (
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("ethtool", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(testNS.Do(func(ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "peer0"})
+		})).To(Succeed())
+	})
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("should toggle offload features", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			for _, feature := range []Feature{TSO, GSO, RxChecksum, TxChecksum} {
+				Expect(SetFeature("veth0", feature, false)).To(Succeed(), feature.Name)
+				Expect(GetFeature("veth0", feature)).To(BeFalse(), feature.Name)
+				Expect(SetFeature("veth0", feature, true)).To(Succeed(), feature.Name)
+				Expect(GetFeature("veth0", feature)).To(BeTrue(), feature.Name)
+			}
+			return nil
+		})).To(Succeed())
+	})
+	It("should set queue counts up to their maximum", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			one := uint32(1)
+			Expect(SetChannels("veth0", Channels{Rx: &one, Tx: &one})).To(Succeed())
+			e, err := ethtool.NewEthtool()
+			Expect(err).NotTo(HaveOccurred())
+			defer e.Close()
+			value, err := e.GetChannels("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.RxCount).To(Equal(one))
+			Expect(value.TxCount).To(Equal(one))
+			channels, err := GetChannels("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*channels.Rx).To(Equal(one))
+			Expect(*channels.Tx).To(Equal(one))
+			Expect(*channels.Combined).To(Equal(value.CombinedCount))
+
+			tooMany := value.MaxRx + 1
+			Expect(SetChannels("veth0", Channels{Rx: &tooMany})).To(MatchError(ContainSubstring("rx channels of veth0 must be at most")))
+			return nil
+		})).To(Succeed())
+	})
+	It("should report interfaces without coalescing support", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			usecs := uint32(50)
+			Expect(SetCoalesce("veth0", Coalesce{RxUsecs: &usecs})).To(MatchError(ContainSubstring("failed to get coalescing of veth0")))
+			_, err := GetCoalesce("veth0")
+			Expect(err).To(MatchError(ContainSubstring("failed to get coalescing of veth0")))
+			return nil
+		})).To(Succeed())
+	})
+	It("should toggle all netdev features of an offload feature", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			Expect(SetFeature("veth0", TSO, false)).To(Succeed())
+			e, err := ethtool.NewEthtool()
+			Expect(err).NotTo(HaveOccurred())
+			defer e.Close()
+			features, err := e.Features("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(features).To(HaveKeyWithValue("tx-tcp-segmentation", false))
+			Expect(features).To(HaveKeyWithValue("tx-tcp6-segmentation", false))
+			return nil
+		})).To(Succeed())
+	})
+	It("should reject missing interfaces and too long names", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			Expect(SetFeature("missing0", TSO, true)).To(MatchError(ContainSubstring("failed to set tso of missing0")))
+			Expect(SetFeature("averyveryverylongname", TSO, true)).To(MatchError(ContainSubstring("is too long")))
+			return nil
+		})).To(Succeed())
+	})
+})
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package faults injects failures into the OVSDB driver and the SR-IOV
+// helpers, so integration tests can exercise rollback and retry paths
+// deterministically. It is inactive unless the OVS_CNI_FAULTS environment
+// variable is set.
+//
+// The variable holds a comma separated list of faults in the form
+// point=action[@call], e.g. "ovsdb-transact=delay:2s,ovsdb-transact=fail@3".
+// The action is "fail" or "delay:<duration>". Without @call the fault
+// applies to every call of the point, otherwise only to the given call
+// within the process, counting from 1.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvVar is the environment variable holding the faults to inject
+const EnvVar = "OVS_CNI_FAULTS"
+
+// Injection points
+const (
+	// OvsdbTransact is hit before every OVSDB transaction
+	OvsdbTransact = "ovsdb-transact"
+	// SriovRepresentor is hit before the representor of a VF is looked up,
+	// a failure simulates a missing representor
+	SriovRepresentor = "sriov-representor"
+)
+
+// ErrInjected is returned by points which are set to fail
+var ErrInjected = errors.New("injected fault")
+
+type fault struct {
+	point string
+	fail  bool
+	delay time.Duration
+	call  int
+}
+
+var (
+	mu         sync.Mutex
+	loadedSpec string
+	loaded     []fault
+	calls      map[string]int
+)
+
+// Inject applies faults configured for the point, it sleeps for configured
+// delays and returns an error wrapping ErrInjected when the call must fail
+func Inject(point string) error {
+	spec := os.Getenv(EnvVar)
+	if spec == "" {
+		return nil
+	}
+
+	mu.Lock()
+	if spec != loadedSpec {
+		faults, err := parse(spec)
+		if err != nil {
+			log.Printf("Ignoring invalid %s: %v", EnvVar, err)
+		}
+		loadedSpec, loaded, calls = spec, faults, map[string]int{}
+	}
+	calls[point]++
+	call := calls[point]
+	var delay time.Duration
+	fail := false
+	for _, f := range loaded {
+		if f.point != point || (f.call != 0 && f.call != call) {
+			continue
+		}
+		delay += f.delay
+		fail = fail || f.fail
+	}
+	mu.Unlock()
+
+	if delay > 0 {
+		log.Printf("Injecting delay of %v at %s call %d", delay, point, call)
+		time.Sleep(delay)
+	}
+	if fail {
+		log.Printf("Injecting failure at %s call %d", point, call)
+		return fmt.Errorf("%w at %s call %d", ErrInjected, point, call)
+	}
+	return nil
+}
+
+func parse(spec string) ([]fault, error) {
+	var faults []fault
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		point, action, found := strings.Cut(entry, "=")
+		if !found || point == "" {
+			return nil, fmt.Errorf("fault %q must be in the form point=action[@call]", entry)
+		}
+		f := fault{point: point}
+		action, callStr, hasCall := strings.Cut(action, "@")
+		if hasCall {
+			call, err := strconv.Atoi(callStr)
+			if err != nil || call < 1 {
+				return nil, fmt.Errorf("invalid call %q of fault %q", callStr, entry)
+			}
+			f.call = call
+		}
+		switch {
+		case action == "fail":
+			f.fail = true
+		case strings.HasPrefix(action, "delay:"):
+			delay, err := time.ParseDuration(strings.TrimPrefix(action, "delay:"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid delay of fault at %s: %v", point, err)
+			}
+			f.delay = delay
+		default:
+			return nil, fmt.Errorf("unknown action %q of fault at %s", action, point)
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package faults
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Faults", func() {
+	AfterEach(func() {
+		Expect(os.Unsetenv(EnvVar)).To(Succeed())
+	})
+	It("should do nothing when not configured", func() {
+		Expect(Inject(OvsdbTransact)).To(Succeed())
+	})
+	It("should fail every call of the point", func() {
+		Expect(os.Setenv(EnvVar, "sriov-representor=fail")).To(Succeed())
+		err := Inject(SriovRepresentor)
+		Expect(errors.Is(err, ErrInjected)).To(BeTrue())
+		Expect(Inject(SriovRepresentor)).NotTo(Succeed())
+		Expect(Inject(OvsdbTransact)).To(Succeed())
+	})
+	It("should fail only the given call", func() {
+		Expect(os.Setenv(EnvVar, "ovsdb-transact=fail@2")).To(Succeed())
+		Expect(Inject(OvsdbTransact)).To(Succeed())
+		Expect(Inject(OvsdbTransact)).NotTo(Succeed())
+		Expect(Inject(OvsdbTransact)).To(Succeed())
+	})
+	It("should restart counting when faults change", func() {
+		Expect(os.Setenv(EnvVar, "ovsdb-transact=fail@1")).To(Succeed())
+		Expect(Inject(OvsdbTransact)).NotTo(Succeed())
+		Expect(os.Setenv(EnvVar, " ovsdb-transact=fail@1")).To(Succeed())
+		Expect(Inject(OvsdbTransact)).NotTo(Succeed())
+	})
+	It("should delay the call", func() {
+		Expect(os.Setenv(EnvVar, "ovsdb-transact=delay:50ms,ovsdb-transact=fail@2")).To(Succeed())
+		start := time.Now()
+		Expect(Inject(OvsdbTransact)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(Inject(OvsdbTransact)).NotTo(Succeed())
+	})
+	It("should reject invalid faults", func() {
+		for _, spec := range []string{"ovsdb-transact", "=fail", "ovsdb-transact=explode", "ovsdb-transact=delay:soon", "ovsdb-transact=fail@0"} {
+			_, err := parse(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+	It("should ignore invalid faults", func() {
+		Expect(os.Setenv(EnvVar, "ovsdb-transact=explode")).To(Succeed())
+		Expect(Inject(OvsdbTransact)).To(Succeed())
+	})
+})
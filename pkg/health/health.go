@@ -0,0 +1,215 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health records the outcome of plugin calls in a machine-readable
+// file on the node, so node-problem-detector or the marker can turn chronic
+// attachment failures into node conditions.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+var (
+	// DefaultStatusFile is the well-known path of the health signal file
+	DefaultStatusFile = "/var/run/ovs-cni/health.json"
+	// used for tests
+	rootDir = ""
+)
+
+// statusLockTimeout limits waiting for another invocation updating the
+// status file
+const statusLockTimeout = 5 * time.Second
+
+// Error classes recorded in the status file
+const (
+	ClassNone             = ""
+	ClassInvalidConfig    = "InvalidConfiguration"
+	ClassUnknownContainer = "UnknownContainer"
+	ClassNetns            = "Netns"
+	ClassTryAgainLater    = "TryAgainLater"
+	ClassOvsUnavailable   = "OVSUnavailable"
+	ClassBridgeFull       = "BridgeFull"
+	ClassInternal         = "Internal"
+)
+
+// Status is the content of the health signal file
+type Status struct {
+	// Command is the CNI command of the last call
+	Command string `json:"command"`
+	// Timestamp is the time of the last call
+	Timestamp time.Time `json:"timestamp"`
+	// ErrorClass is the class of the error of the last call, empty on success
+	ErrorClass string `json:"errorClass,omitempty"`
+	// ErrorCode is the CNI error code of the last call, empty on success
+	ErrorCode uint `json:"errorCode,omitempty"`
+	// Error is the error message of the last call
+	Error string `json:"error,omitempty"`
+	// ConsecutiveFailures counts calls which failed since the last success
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// LastSuccess is the time of the last successful call
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	// LastFailure is the time of the last failed call
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+}
+
+// Code returns the CNI error code of an error returned by the plugin, errors
+// without a code are reported as internal errors by skel
+func Code(err error) uint {
+	if err == nil {
+		return 0
+	}
+	var cniErr *cnitypes.Error
+	if errors.As(err, &cniErr) {
+		return cniErr.Code
+	}
+	return cnitypes.ErrInternal
+}
+
+// Classify maps an error returned by the plugin to an error class by its CNI
+// error code. Errors of the IPAM plugin keep their code and are classified
+// like errors of the plugin with the same code.
+func Classify(err error) string {
+	if err == nil {
+		return ClassNone
+	}
+	switch Code(err) {
+	case cnitypes.ErrInvalidEnvironmentVariables, cnitypes.ErrDecodingFailure, cnitypes.ErrInvalidNetworkConfig:
+		return ClassInvalidConfig
+	case cnitypes.ErrUnknownContainer:
+		return ClassUnknownContainer
+	case cnitypes.ErrInvalidNetNS:
+		return ClassNetns
+	case cnitypes.ErrTryAgainLater:
+		return ClassTryAgainLater
+	case types.ErrOvsUnavailable:
+		return ClassOvsUnavailable
+	case types.ErrBridgeFull:
+		return ClassBridgeFull
+	}
+	return ClassInternal
+}
+
+// Record updates the status file with the outcome of a call of the given
+// command. Concurrent invocations are serialized, so none of their outcomes
+// is lost.
+func Record(command string, callErr error) error {
+	lock, err := utils.AcquireLock("health", statusLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	path := statusFilePath()
+	status, err := read(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	status.Command = command
+	status.Timestamp = now
+	status.ErrorClass = Classify(callErr)
+	status.ErrorCode = Code(callErr)
+	if callErr != nil {
+		status.Error = callErr.Error()
+		status.ConsecutiveFailures++
+		status.LastFailure = &now
+	} else {
+		status.Error = ""
+		status.ConsecutiveFailures = 0
+		status.LastSuccess = &now
+	}
+	return write(path, status)
+}
+
+// Wrap returns the CNI function which records its outcome in the status file,
+// failures to write the file don't change the result of the call
+func Wrap(command string, f func(*skel.CmdArgs) error) func(*skel.CmdArgs) error {
+	return func(args *skel.CmdArgs) error {
+		err := f(args)
+		if recordErr := Record(command, err); recordErr != nil {
+			fmt.Fprintf(os.Stderr, "failed to record health status: %v\n", recordErr)
+		}
+		return err
+	}
+}
+
+// Read returns the content of the status file, an empty status is returned
+// when the file does not exist
+func Read() (*Status, error) {
+	return read(statusFilePath())
+}
+
+func read(path string) (*Status, error) {
+	status := &Status{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status, nil
+		}
+		return nil, fmt.Errorf("failed to read health status file %q: %v", path, err)
+	}
+	if err := json.Unmarshal(data, status); err != nil {
+		// start over instead of failing calls on a corrupted file
+		return &Status{}, nil
+	}
+	return status, nil
+}
+
+func write(path string, status *Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to serialize health status: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create health status dir: %v", err)
+	}
+	// write to a temporary file first, readers never see a partial content
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create health status file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write health status file: %v", err)
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write health status file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write health status file: %v", err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to write health status file: %v", err)
+	}
+	return nil
+}
+
+func statusFilePath() string {
+	return filepath.Join(rootDir, DefaultStatusFile)
+}
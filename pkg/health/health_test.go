@@ -0,0 +1,117 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Health", func() {
+	var (
+		tmpDir string
+		err    error
+	)
+	BeforeEach(func() {
+		tmpDir, err = os.MkdirTemp("", "ovs-cni-health-test*")
+		Expect(err).NotTo(HaveOccurred())
+		rootDir = tmpDir
+	})
+	AfterEach(func() {
+		rootDir = ""
+		Expect(os.RemoveAll(tmpDir)).NotTo(HaveOccurred())
+	})
+	DescribeTable("should classify errors by their CNI error code",
+		func(err error, class string) {
+			Expect(Classify(err)).To(Equal(class))
+		},
+		Entry("success", nil, ClassNone),
+		Entry("invalid configuration", cnitypes.NewError(cnitypes.ErrInvalidNetworkConfig, "invalid", ""), ClassInvalidConfig),
+		Entry("decoding failure", cnitypes.NewError(cnitypes.ErrDecodingFailure, "bad json", ""), ClassInvalidConfig),
+		Entry("invalid CNI_ARGS", cnitypes.NewError(cnitypes.ErrInvalidEnvironmentVariables, "bad args", ""), ClassInvalidConfig),
+		Entry("unknown container", cnitypes.NewError(cnitypes.ErrUnknownContainer, "no cache", ""), ClassUnknownContainer),
+		Entry("netns", cnitypes.NewError(cnitypes.ErrInvalidNetNS, "failed to open netns", ""), ClassNetns),
+		Entry("try again later", cnitypes.NewError(cnitypes.ErrTryAgainLater, "failed to connect to ovsdb", ""), ClassTryAgainLater),
+		Entry("wrapped", fmt.Errorf("ADD failed: %w", cnitypes.NewError(cnitypes.ErrTryAgainLater, "busy", "")), ClassTryAgainLater),
+		Entry("OVS unavailable", cnitypes.NewError(types.ErrOvsUnavailable, "no OVS", ""), ClassOvsUnavailable),
+		Entry("bridge full", cnitypes.NewError(types.ErrBridgeFull, "full", ""), ClassBridgeFull),
+		Entry("internal", cnitypes.NewError(cnitypes.ErrInternal, "failed", ""), ClassInternal),
+		Entry("without a code", errors.New("failed to find bridge br1"), ClassInternal),
+	)
+	It("should record the CNI error code", func() {
+		Expect(Code(nil)).To(BeZero())
+		Expect(Code(errors.New("failed"))).To(Equal(cnitypes.ErrInternal))
+		Expect(Record("ADD", cnitypes.NewError(types.ErrBridgeFull, "full", ""))).To(Succeed())
+		status, err := Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.ErrorCode).To(Equal(types.ErrBridgeFull))
+	})
+	It("should not lose outcomes of concurrent calls", func() {
+		const calls = 100
+		var wg sync.WaitGroup
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				Expect(Record("ADD", errors.New("failed"))).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		status, err := Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.ConsecutiveFailures).To(Equal(calls))
+	})
+	It("should count consecutive failures until a success", func() {
+		bridgeErr := cnitypes.NewError(cnitypes.ErrTryAgainLater, "failed to find bridge br1", "")
+		Expect(Record("ADD", bridgeErr)).To(Succeed())
+		Expect(Record("ADD", bridgeErr)).To(Succeed())
+		status, err := Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Command).To(Equal("ADD"))
+		Expect(status.ErrorClass).To(Equal(ClassTryAgainLater))
+		Expect(status.ConsecutiveFailures).To(Equal(2))
+		Expect(status.LastFailure).NotTo(BeNil())
+		Expect(status.LastSuccess).To(BeNil())
+
+		Expect(Record("DEL", nil)).To(Succeed())
+		status, err = Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Command).To(Equal("DEL"))
+		Expect(status.ErrorClass).To(BeEmpty())
+		Expect(status.ErrorCode).To(BeZero())
+		Expect(status.Error).To(BeEmpty())
+		Expect(status.ConsecutiveFailures).To(Equal(0))
+		Expect(status.LastFailure).NotTo(BeNil())
+		Expect(status.LastSuccess).NotTo(BeNil())
+	})
+	It("should pass through the result of the wrapped function", func() {
+		callErr := errors.New("failed")
+		wrapped := Wrap("CHECK", func(_ *skel.CmdArgs) error { return callErr })
+		Expect(wrapped(&skel.CmdArgs{})).To(Equal(callErr))
+		status, err := Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.ConsecutiveFailures).To(Equal(1))
+	})
+})
@@ -0,0 +1,52 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"github.com/golang/glog"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// WatchCheckCache monitors ports and interfaces in OVSDB and drops cached
+// CHECK results of attachments in the cache directory of the plugin whose
+// port or interface changes, so the next CHECK validates them again
+func (m *Marker) WatchCheckCache(cacheDir string) error {
+	store := utils.NewStore[types.CachedCheck](cacheDir, config.CheckCacheKeyPrefix, 0)
+	return m.watchPorts(func(name string) {
+		invalidateChecks(store, name)
+	})
+}
+
+// invalidateChecks drops cached CHECK results of attachments using the port
+// or interface of the given name
+func invalidateChecks(store *utils.Store[types.CachedCheck], name string) {
+	keys, err := store.List()
+	if err != nil {
+		glog.Errorf("Failed to list cached CHECK results: %v", err)
+		return
+	}
+	for _, key := range keys {
+		cached, err := store.Load(key)
+		if err != nil || cached.Port != name {
+			continue
+		}
+		if err := store.Delete(key); err != nil {
+			glog.Errorf("Failed to drop cached CHECK result of %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+var _ = Describe("CHECK cache invalidation", func() {
+	It("should drop results of attachments using the changed port", func() {
+		store := utils.NewStore[types.CachedCheck](GinkgoT().TempDir(), config.CheckCacheKeyPrefix, 0)
+		Expect(store.Save("net-cid-eth0", &types.CachedCheck{Digest: "d", Port: "veth1"})).To(Succeed())
+		Expect(store.Save("net-cid-eth1", &types.CachedCheck{Digest: "d", Port: "veth2"})).To(Succeed())
+		invalidateChecks(store, "veth1")
+		Expect(store.List()).To(ConsistOf("net-cid-eth1"))
+		invalidateChecks(store, "veth3")
+		Expect(store.List()).To(ConsistOf("net-cid-eth1"))
+	})
+})
@@ -0,0 +1,194 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// kinds of drift between the cache of the plugin and OVSDB
+const (
+	// driftStaleCache is a cached attachment whose port is not in OVSDB
+	driftStaleCache = "stale_cache"
+	// driftOrphanPort is a port created by ovs-cni which no cached
+	// attachment belongs to
+	driftOrphanPort = "orphan_port"
+)
+
+// userspaceIPAMPortPrefix is the prefix of names of internal ports of the
+// userspace IPAM of the plugin
+const userspaceIPAMPortPrefix = "ovsm"
+
+func newCacheDriftGauge() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovs_cni_cache_drift",
+		Help: "Number of cached attachments without a port and of ports created by ovs-cni without a cached attachment",
+	}, []string{"kind"})
+}
+
+// attachmentKey identifies the port of an attachment by its external_ids
+type attachmentKey struct {
+	netns   string
+	network string
+	ifName  string
+}
+
+// cacheDrift is the result of a consistency check
+type cacheDrift struct {
+	// keys of cache entries of attachments without a port
+	staleEntries []string
+	orphanPorts  []ovsdb.OwnedPort
+}
+
+// portKey returns the key of the attachment the port was created for
+func portKey(port ovsdb.OwnedPort) attachmentKey {
+	return attachmentKey{port.ExternalIDs["contNetns"], port.ExternalIDs["contNetwork"], port.ExternalIDs["contIface"]}
+}
+
+// attachmentIfaces returns the container interfaces ports of the attachment
+// are created for: the interface of the attachment, its backup interface,
+// the other VFs of a bond and the internal port of the userspace IPAM. Names
+// follow the ones the plugin gives them.
+func attachmentIfaces(entry *types.CachedNetConf) []string {
+	ifaces := []string{entry.IfName}
+	if backup := entry.Netconf.Backup; backup != nil {
+		if backup.IfName != "" {
+			ifaces = append(ifaces, backup.IfName)
+		} else {
+			ifaces = append(ifaces, entry.IfName+"b")
+		}
+	}
+	for i := 1; i < len(entry.Netconf.DeviceIDs); i++ {
+		ifaces = append(ifaces, sriov.BondedVFName(entry.IfName, i))
+	}
+	if entry.UserspaceIPAMPort != "" {
+		ifaces = append(ifaces, entry.UserspaceIPAMPort)
+	} else if entry.UserspaceMode && entry.Netconf.UserspaceIPAM && entry.Netconf.IPAM.Type != "" {
+		// older versions didn't cache it and named it without the network
+		hash := sha256.Sum256([]byte(entry.ContainerID + "/" + entry.IfName))
+		ifaces = append(ifaces, userspaceIPAMPortPrefix+hex.EncodeToString(hash[:])[:11])
+	}
+	return ifaces
+}
+
+// findCacheDrift compares cached attachments with ports created by ovs-cni.
+// Entries which don't know their attachment, delegated ones and entries of
+// the userspace driver, whose port is not created by ovs-cni, are skipped.
+// A port belongs to an attachment when both are in the same netns and
+// network and the port is created for one of the interfaces of the
+// attachment. Quarantined ports are retained on purpose and never orphans.
+func findCacheDrift(entries map[string]*types.CachedNetConf, ports []ovsdb.OwnedPort) *cacheDrift {
+	portKeys := map[attachmentKey]bool{}
+	for _, port := range ports {
+		portKeys[portKey(port)] = true
+	}
+	attachments := map[attachmentKey]bool{}
+	drift := &cacheDrift{}
+	for cRef, entry := range entries {
+		if entry.Netconf == nil || entry.ContainerID == "" || entry.Delegated {
+			continue
+		}
+		for _, ifName := range attachmentIfaces(entry) {
+			attachments[attachmentKey{entry.Netns, entry.Netconf.Name, ifName}] = true
+		}
+		if entry.UserspaceMode {
+			continue
+		}
+		if !portKeys[attachmentKey{entry.Netns, entry.Netconf.Name, entry.IfName}] {
+			drift.staleEntries = append(drift.staleEntries, cRef)
+		}
+	}
+	for _, port := range ports {
+		if _, quarantined := port.ExternalIDs[ovsdb.QuarantineExpiryKey]; quarantined {
+			continue
+		}
+		if !attachments[portKey(port)] {
+			drift.orphanPorts = append(drift.orphanPorts, port)
+		}
+	}
+	sort.Strings(drift.staleEntries)
+	return drift
+}
+
+// loadCacheEntries returns attachments in the cache of the plugin in
+// cacheDir by their keys, entries which can't be read are skipped
+func loadCacheEntries(cacheDir string) (map[string]*types.CachedNetConf, error) {
+	keys, err := utils.ListCacheDir(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*types.CachedNetConf, len(keys))
+	for _, cRef := range keys {
+		entry, err := config.LoadConfFromCache(cRef, config.WithCacheDir(cacheDir))
+		if err != nil {
+			glog.Warningf("skipping cache entry %s: %v", cRef, err)
+			continue
+		}
+		entries[cRef] = entry
+	}
+	return entries, nil
+}
+
+// CheckCacheConsistency compares attachments in the cache of the plugin in
+// cacheDir with ports created by ovs-cni in OVSDB, logs the drift and
+// reports it in the metrics. With repair, orphan ports whose interface is
+// in error, i.e. its veth is gone with the container, are removed. Stale
+// cache entries are only reported, the IPAM plugin still needs them on DEL.
+func (m *Marker) CheckCacheConsistency(cacheDir string, repair bool) error {
+	entries, err := loadCacheEntries(cacheDir)
+	if err != nil {
+		return err
+	}
+	// ports are listed after the cache, the plugin caches an attachment
+	// before it creates its port, so ports added in between aren't orphans
+	ports, err := m.ovsdb.GetOwnedPorts()
+	if err != nil {
+		return fmt.Errorf("failed to list ports: %v", err)
+	}
+
+	drift := findCacheDrift(entries, ports)
+	for _, cRef := range drift.staleEntries {
+		glog.Warningf("cached attachment %s has no port in OVSDB", cRef)
+	}
+	orphans := 0
+	for _, port := range drift.orphanPorts {
+		if repair && port.Error != "" {
+			bridgeDriver := &ovsdb.OvsBridgeDriver{OvsDriver: *m.ovsdb, OvsBridgeName: port.Bridge}
+			if err := bridgeDriver.DeletePort(port.Name); err != nil {
+				glog.Errorf("failed to remove orphan port %s of bridge %s: %v", port.Name, port.Bridge, err)
+			} else {
+				glog.Infof("removed orphan port %s of bridge %s: %s", port.Name, port.Bridge, port.Error)
+				continue
+			}
+		}
+		glog.Warningf("port %s of bridge %s has no cached attachment", port.Name, port.Bridge)
+		orphans++
+	}
+	m.cacheDrift.WithLabelValues(driftStaleCache).Set(float64(len(drift.staleEntries)))
+	m.cacheDrift.WithLabelValues(driftOrphanPort).Set(float64(orphans))
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+const testNetns = "/var/run/netns/pod1"
+
+// cachedAttachment returns a cache entry of the attachment of net-a in the
+// netns of the test, changed by the options
+func cachedAttachment(ifName string, options ...func(*types.CachedNetConf)) *types.CachedNetConf {
+	entry := &types.CachedNetConf{Netconf: &types.NetConf{}, ContainerID: "cid", IfName: ifName, Netns: testNetns}
+	entry.Netconf.Name = "net-a"
+	for _, option := range options {
+		option(entry)
+	}
+	return entry
+}
+
+// ownedPort returns a port created for the container interface of net-a in
+// the netns of the test
+func ownedPort(name, ifName string) ovsdb.OwnedPort {
+	return ovsdb.OwnedPort{Name: name, Bridge: "br1", ExternalIDs: map[string]string{
+		"contNetns":   testNetns,
+		"contNetwork": "net-a",
+		"contIface":   ifName,
+	}}
+}
+
+// orphanNames returns names of the orphan ports of the drift
+func orphanNames(drift *cacheDrift) []string {
+	names := []string{}
+	for _, port := range drift.orphanPorts {
+		names = append(names, port.Name)
+	}
+	return names
+}
+
+var _ = Describe("Cache consistency", func() {
+	DescribeTable("should find drift between the cache and OVSDB",
+		func(entries map[string]*types.CachedNetConf, ports []ovsdb.OwnedPort, staleEntries, orphanPorts []string) {
+			drift := findCacheDrift(entries, ports)
+			Expect(drift.staleEntries).To(ConsistOf(staleEntries))
+			Expect(orphanNames(drift)).To(ConsistOf(orphanPorts))
+		},
+		Entry("attachment with its port",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1")},
+			[]ovsdb.OwnedPort{ownedPort("veth1", "net1")},
+			nil, nil),
+		Entry("attachment without a port",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1")},
+			nil,
+			[]string{"net-a-cid-net1"}, nil),
+		Entry("port without an attachment",
+			nil,
+			[]ovsdb.OwnedPort{ownedPort("veth1", "net1")},
+			nil, []string{"veth1"}),
+		Entry("port of a removed attachment of a container with another one",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1")},
+			[]ovsdb.OwnedPort{ownedPort("veth1", "net1"), ownedPort("veth2", "net2")},
+			nil, []string{"veth2"}),
+		Entry("port of another network of the container",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1")},
+			[]ovsdb.OwnedPort{ownedPort("veth1", "net1"), func() ovsdb.OwnedPort {
+				port := ownedPort("veth2", "net1")
+				port.ExternalIDs["contNetwork"] = "net-b"
+				return port
+			}()},
+			nil, []string{"veth2"}),
+		Entry("backup port with the default name",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1", func(e *types.CachedNetConf) {
+				e.Netconf.Backup = &types.Backup{Bridge: "br2"}
+			})},
+			[]ovsdb.OwnedPort{ownedPort("veth1", "net1"), ownedPort("veth2", "net1b")},
+			nil, nil),
+		Entry("backup port with a configured name",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1", func(e *types.CachedNetConf) {
+				e.Netconf.Backup = &types.Backup{Bridge: "br2", IfName: "bk1"}
+			})},
+			[]ovsdb.OwnedPort{ownedPort("veth1", "net1"), ownedPort("veth2", "bk1"), ownedPort("veth3", "net1b")},
+			nil, []string{"veth3"}),
+		Entry("ports of bonded VFs",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1", func(e *types.CachedNetConf) {
+				e.Netconf.DeviceID = "0000:03:00.2"
+				e.Netconf.DeviceIDs = []string{"0000:03:00.2", "0000:03:00.3"}
+			})},
+			[]ovsdb.OwnedPort{ownedPort("pf0vf0", "net1"), ownedPort("pf0vf1", "net1v1"), ownedPort("pf0vf2", "net1v2")},
+			nil, []string{"pf0vf2"}),
+		Entry("internal port of the userspace IPAM",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1", func(e *types.CachedNetConf) {
+				e.UserspaceMode = true
+				e.UserspaceIPAMPort = "ovsm0123456789a"
+			})},
+			[]ovsdb.OwnedPort{ownedPort("ovsm0123456789a", "ovsm0123456789a")},
+			nil, nil),
+		Entry("internal port of the userspace IPAM of an older version",
+			map[string]*types.CachedNetConf{"net-a-cid-net1": cachedAttachment("net1", func(e *types.CachedNetConf) {
+				e.UserspaceMode = true
+				e.Netconf.UserspaceIPAM = true
+				e.Netconf.IPAM.Type = "host-local"
+			})},
+			// named after the hash of cid/net1
+			[]ovsdb.OwnedPort{ownedPort("ovsm30bc48f5fb8", "ovsm30bc48f5fb8")},
+			nil, nil),
+		Entry("quarantined port",
+			nil,
+			[]ovsdb.OwnedPort{func() ovsdb.OwnedPort {
+				port := ownedPort("veth1", "net1")
+				port.ExternalIDs[ovsdb.QuarantineExpiryKey] = "2024-05-02T10:15:00Z"
+				return port
+			}()},
+			nil, nil),
+		Entry("entries which don't know their attachment and delegated ones",
+			map[string]*types.CachedNetConf{
+				"net-a-cid-net1": cachedAttachment("net1", func(e *types.CachedNetConf) { e.ContainerID = "" }),
+				"net-a-cid-net2": cachedAttachment("net2", func(e *types.CachedNetConf) { e.Delegated = true }),
+				"net-a-cid-net3": cachedAttachment("net3", func(e *types.CachedNetConf) { e.Netconf = nil }),
+			},
+			nil,
+			nil, nil),
+	)
+})
@@ -0,0 +1,295 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// devicePluginSocketPrefix prefixes sockets of bridge device plugins in
+	// the device plugin directory of kubelet
+	devicePluginSocketPrefix = "ovs-cni-"
+	// kubeletSocketName is the registration socket of kubelet in the device
+	// plugin directory
+	kubeletSocketName = "kubelet.sock"
+	// devicePluginRegisterTimeout limits registration with kubelet
+	devicePluginRegisterTimeout = 10 * time.Second
+)
+
+// bridgeDevicePlugin serves the resource of a bridge to kubelet, each of its
+// devices is a port of the bridge and all of them are unhealthy while the
+// bridge is
+type bridgeDevicePlugin struct {
+	bridge string
+	socket string
+	server *grpc.Server
+
+	lock    sync.Mutex
+	count   int
+	healthy bool
+	devices []*pluginapi.Device
+	// changed is closed and replaced when devices change
+	changed chan struct{}
+}
+
+func newBridgeDevicePlugin(bridge, dir string) *bridgeDevicePlugin {
+	return &bridgeDevicePlugin{
+		bridge:  bridge,
+		socket:  filepath.Join(dir, devicePluginSocketPrefix+bridge+".sock"),
+		changed: make(chan struct{}),
+	}
+}
+
+// setDevices sets the number of devices and their health, ListAndWatch
+// streams are updated when they change
+func (p *bridgeDevicePlugin) setDevices(count int, healthy bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.devices != nil && p.count == count && p.healthy == healthy {
+		return
+	}
+	health := pluginapi.Healthy
+	if !healthy {
+		health = pluginapi.Unhealthy
+	}
+	devices := make([]*pluginapi.Device, 0, count)
+	for i := 0; i < count; i++ {
+		devices = append(devices, &pluginapi.Device{ID: fmt.Sprintf("%s-%d", p.bridge, i), Health: health})
+	}
+	p.count, p.healthy, p.devices = count, healthy, devices
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// setHealth changes health of the devices keeping their number
+func (p *bridgeDevicePlugin) setHealth(healthy bool) {
+	p.lock.Lock()
+	count := p.count
+	p.lock.Unlock()
+	p.setDevices(count, healthy)
+}
+
+// start serves the plugin on its socket and registers it with kubelet
+func (p *bridgeDevicePlugin) start(kubeletSocket string) error {
+	if err := os.Remove(p.socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket %s: %v", p.socket, err)
+	}
+	listener, err := net.Listen("unix", p.socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", p.socket, err)
+	}
+	p.server = grpc.NewServer()
+	pluginapi.RegisterDevicePluginServer(p.server, p)
+	go func() {
+		if err := p.server.Serve(listener); err != nil {
+			glog.Errorf("device plugin of bridge %s stopped serving: %v", p.bridge, err)
+		}
+	}()
+
+	if err := p.register(kubeletSocket); err != nil {
+		p.stop()
+		return err
+	}
+	glog.Infof("registered device plugin of bridge %s with kubelet", p.bridge)
+	return nil
+}
+
+func (p *bridgeDevicePlugin) register(kubeletSocket string) error {
+	conn, err := grpc.NewClient("unix://"+kubeletSocket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to connect to kubelet on %s: %v", kubeletSocket, err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), devicePluginRegisterTimeout)
+	defer cancel()
+	_, err = pluginapi.NewRegistrationClient(conn).Register(ctx, &pluginapi.RegisterRequest{
+		Version:      pluginapi.Version,
+		Endpoint:     filepath.Base(p.socket),
+		ResourceName: resourceNamespace + "/" + p.bridge,
+		Options:      &pluginapi.DevicePluginOptions{GetPreferredAllocationAvailable: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register device plugin of bridge %s with kubelet: %v", p.bridge, err)
+	}
+	return nil
+}
+
+// stop stops serving the plugin, kubelet drops its devices then
+func (p *bridgeDevicePlugin) stop() {
+	p.server.Stop()
+	if err := os.Remove(p.socket); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("failed to remove socket %s: %v", p.socket, err)
+	}
+}
+
+// socketRemoved returns true when the socket of the plugin is gone, kubelet
+// removes sockets of all plugins when it restarts
+func (p *bridgeDevicePlugin) socketRemoved() bool {
+	_, err := os.Stat(p.socket)
+	return os.IsNotExist(err)
+}
+
+// GetDevicePluginOptions returns options of the plugin
+func (p *bridgeDevicePlugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return &pluginapi.DevicePluginOptions{GetPreferredAllocationAvailable: true}, nil
+}
+
+// ListAndWatch sends devices of the plugin whenever they change
+func (p *bridgeDevicePlugin) ListAndWatch(_ *pluginapi.Empty, stream pluginapi.DevicePlugin_ListAndWatchServer) error {
+	for {
+		p.lock.Lock()
+		devices, changed := p.devices, p.changed
+		p.lock.Unlock()
+		if err := stream.Send(&pluginapi.ListAndWatchResponse{Devices: devices}); err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// GetPreferredAllocation prefers devices kubelet must include and then healthy
+// devices in the order of their index, so allocations are packed to the
+// lowest ports and devices of a degraded bridge are never preferred
+func (p *bridgeDevicePlugin) GetPreferredAllocation(_ context.Context, request *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	p.lock.Lock()
+	devices := p.devices
+	p.lock.Unlock()
+
+	response := &pluginapi.PreferredAllocationResponse{}
+	for _, containerRequest := range request.ContainerRequests {
+		available := make(map[string]bool, len(containerRequest.AvailableDeviceIDs))
+		for _, id := range containerRequest.AvailableDeviceIDs {
+			available[id] = true
+		}
+		preferred := append([]string{}, containerRequest.MustIncludeDeviceIDs...)
+		included := make(map[string]bool, len(preferred))
+		for _, id := range preferred {
+			included[id] = true
+		}
+		for _, device := range devices {
+			if len(preferred) >= int(containerRequest.AllocationSize) {
+				break
+			}
+			if available[device.ID] && !included[device.ID] && device.Health == pluginapi.Healthy {
+				preferred = append(preferred, device.ID)
+			}
+		}
+		response.ContainerResponses = append(response.ContainerResponses, &pluginapi.ContainerPreferredAllocationResponse{DeviceIDs: preferred})
+	}
+	return response, nil
+}
+
+// Allocate accepts any devices of the plugin, the bridge is attached by the
+// CNI plugin and the container needs nothing else
+func (p *bridgeDevicePlugin) Allocate(_ context.Context, request *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	response := &pluginapi.AllocateResponse{}
+	for range request.ContainerRequests {
+		response.ContainerResponses = append(response.ContainerResponses, &pluginapi.ContainerAllocateResponse{})
+	}
+	return response, nil
+}
+
+// PreStartContainer is not required by the plugin
+func (p *bridgeDevicePlugin) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
+
+// UpdateDevicePlugins serves every bridge as a device plugin resource of
+// kubelet with its port limit as the number of devices, which are unhealthy
+// while all uplinks of the bridge are down. Plugins of removed bridges are
+// stopped and plugins are registered again when kubelet restarts.
+func (m *Marker) UpdateDevicePlugins(dir string) error {
+	bridges, err := m.getAvailableResources()
+	if err != nil {
+		return fmt.Errorf("failed to list available resources: %v", err)
+	}
+	portLimits, err := m.getPortLimits(bridges)
+	if err != nil {
+		return fmt.Errorf("failed to read port limits of bridges: %v", err)
+	}
+
+	m.devicePluginsLock.Lock()
+	defer m.devicePluginsLock.Unlock()
+	if m.devicePlugins == nil {
+		m.devicePlugins = make(map[string]*bridgeDevicePlugin)
+	}
+	for bridge, plugin := range m.devicePlugins {
+		if bridges[bridge] && !plugin.socketRemoved() {
+			continue
+		}
+		plugin.stop()
+		delete(m.devicePlugins, bridge)
+	}
+
+	var errs []error
+	for bridge := range bridges {
+		plugin, found := m.devicePlugins[bridge]
+		if !found {
+			plugin = newBridgeDevicePlugin(bridge, dir)
+		}
+		plugin.setDevices(portLimits[bridge], m.isBridgeHealthy(bridge))
+		if found {
+			continue
+		}
+		if err := plugin.start(filepath.Join(dir, kubeletSocketName)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.devicePlugins[bridge] = plugin
+	}
+	return errors.Join(errs...)
+}
+
+// WatchDevicePlugins updates health of devices of bridges as soon as ports or
+// their link state change
+func (m *Marker) WatchDevicePlugins() error {
+	trigger := make(chan struct{}, 1)
+	err := m.watchPorts(func(string) {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range trigger {
+			m.devicePluginsLock.Lock()
+			for bridge, plugin := range m.devicePlugins {
+				plugin.setHealth(m.isBridgeHealthy(bridge))
+			}
+			m.devicePluginsLock.Unlock()
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// fakeKubelet records registrations of device plugins
+type fakeKubelet struct {
+	pluginapi.UnimplementedRegistrationServer
+	requests chan *pluginapi.RegisterRequest
+}
+
+func (k *fakeKubelet) Register(_ context.Context, request *pluginapi.RegisterRequest) (*pluginapi.Empty, error) {
+	k.requests <- request
+	return &pluginapi.Empty{}, nil
+}
+
+var _ = Describe("Device plugin", func() {
+	var dir string
+	var kubelet *fakeKubelet
+	var kubeletServer *grpc.Server
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "device-plugins")
+		Expect(err).NotTo(HaveOccurred())
+		listener, err := net.Listen("unix", filepath.Join(dir, kubeletSocketName))
+		Expect(err).NotTo(HaveOccurred())
+		kubelet = &fakeKubelet{requests: make(chan *pluginapi.RegisterRequest, 1)}
+		kubeletServer = grpc.NewServer()
+		pluginapi.RegisterRegistrationServer(kubeletServer, kubelet)
+		go kubeletServer.Serve(listener)
+	})
+	AfterEach(func() {
+		kubeletServer.Stop()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	startPlugin := func(count int, healthy bool) (*bridgeDevicePlugin, pluginapi.DevicePluginClient) {
+		plugin := newBridgeDevicePlugin("br1", dir)
+		plugin.setDevices(count, healthy)
+		Expect(plugin.start(filepath.Join(dir, kubeletSocketName))).To(Succeed())
+		DeferCleanup(plugin.stop)
+		conn, err := grpc.NewClient("unix://"+plugin.socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		return plugin, pluginapi.NewDevicePluginClient(conn)
+	}
+	health := func(devices []*pluginapi.Device) []string {
+		healths := []string{}
+		for _, device := range devices {
+			healths = append(healths, device.Health)
+		}
+		return healths
+	}
+
+	It("should register the resource of the bridge with kubelet", func() {
+		startPlugin(2, true)
+		var request *pluginapi.RegisterRequest
+		Eventually(kubelet.requests).Should(Receive(&request))
+		Expect(request.Version).To(Equal(pluginapi.Version))
+		Expect(request.Endpoint).To(Equal("ovs-cni-br1.sock"))
+		Expect(request.ResourceName).To(Equal("ovs-cni.network.kubevirt.io/br1"))
+		Expect(request.Options.GetPreferredAllocationAvailable).To(BeTrue())
+	})
+	It("should stream devices whenever their health changes", func() {
+		plugin, client := startPlugin(2, true)
+		stream, err := client.ListAndWatch(context.Background(), &pluginapi.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		response, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Devices).To(HaveLen(2))
+		Expect(response.Devices[0].ID).To(Equal("br1-0"))
+		Expect(health(response.Devices)).To(Equal([]string{pluginapi.Healthy, pluginapi.Healthy}))
+
+		plugin.setHealth(false)
+		response, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(health(response.Devices)).To(Equal([]string{pluginapi.Unhealthy, pluginapi.Unhealthy}))
+
+		plugin.setDevices(3, true)
+		response, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(health(response.Devices)).To(Equal([]string{pluginapi.Healthy, pluginapi.Healthy, pluginapi.Healthy}))
+	})
+	It("should prefer devices which must be included and then the lowest healthy ones", func() {
+		_, client := startPlugin(4, true)
+		response, err := client.GetPreferredAllocation(context.Background(), &pluginapi.PreferredAllocationRequest{
+			ContainerRequests: []*pluginapi.ContainerPreferredAllocationRequest{{
+				AvailableDeviceIDs:   []string{"br1-3", "br1-1", "br1-2", "br1-0"},
+				MustIncludeDeviceIDs: []string{"br1-2"},
+				AllocationSize:       2,
+			}, {
+				AvailableDeviceIDs: []string{"br1-3", "br1-1"},
+				AllocationSize:     1,
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.ContainerResponses).To(HaveLen(2))
+		Expect(response.ContainerResponses[0].DeviceIDs).To(Equal([]string{"br1-2", "br1-0"}))
+		Expect(response.ContainerResponses[1].DeviceIDs).To(Equal([]string{"br1-1"}))
+	})
+	It("should not prefer devices of an unhealthy bridge", func() {
+		_, client := startPlugin(2, false)
+		response, err := client.GetPreferredAllocation(context.Background(), &pluginapi.PreferredAllocationRequest{
+			ContainerRequests: []*pluginapi.ContainerPreferredAllocationRequest{{
+				AvailableDeviceIDs: []string{"br1-0", "br1-1"},
+				AllocationSize:     1,
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.ContainerResponses[0].DeviceIDs).To(BeEmpty())
+	})
+	It("should notice that kubelet removed its socket", func() {
+		plugin, _ := startPlugin(1, true)
+		Expect(plugin.socketRemoved()).To(BeFalse())
+		Expect(os.Remove(plugin.socket)).To(Succeed())
+		Expect(plugin.socketRemoved()).To(BeTrue())
+	})
+})
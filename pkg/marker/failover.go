@@ -0,0 +1,153 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// WatchBackupFailover fails active/backup attachments in the cache directory
+// of the plugin over to their backup bridge while all uplinks of their active
+// bridge are down, and back once one of them is up again. Attachments are
+// checked whenever a port or interface in OVSDB changes.
+func (m *Marker) WatchBackupFailover(cacheDir string) error {
+	trigger := make(chan struct{}, 1)
+	err := m.watchPorts(func(string) {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range trigger {
+			if err := m.FailoverBackupAttachments(cacheDir); err != nil {
+				glog.Errorf("FailoverBackupAttachments failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// FailoverBackupAttachments sets the host side of the active veth of
+// active/backup attachments down while all uplinks of their active bridge
+// are down, and up otherwise. The container interface loses its carrier
+// with it, so the kernel uses the routes of the backup interface, whose
+// routes over interfaces without carrier are ignored.
+func (m *Marker) FailoverBackupAttachments(cacheDir string) error {
+	entries, err := loadCacheEntries(cacheDir)
+	if err != nil {
+		return err
+	}
+	ports, err := m.ovsdb.GetOwnedPorts()
+	if err != nil {
+		return fmt.Errorf("failed to list ports: %v", err)
+	}
+	bridgeUp := func(netconf *types.NetConf) (bool, error) {
+		uplinks, err := m.ovsdb.GetBridgeUplinks(netconf.BrName, netconf.UplinkPorts)
+		if err != nil {
+			return false, err
+		}
+		return activeBridgeUp(uplinks), nil
+	}
+	states := failoverStates(entries, ports, bridgeUp)
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := setLinkState(name, states[name]); err != nil {
+			glog.Errorf("failed to fail over port %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// activeBridgeUp returns false when all uplinks of the bridge are down. The
+// state of a bridge without uplinks is not known, it is considered up.
+func activeBridgeUp(uplinks map[string]ovsdb.Uplink) bool {
+	if len(uplinks) == 0 {
+		return true
+	}
+	for _, uplink := range uplinks {
+		if uplink.Up {
+			return true
+		}
+	}
+	return false
+}
+
+// failoverStates returns the wanted link state of the host side of the
+// active veth of each active/backup attachment, by its name. Attachments
+// whose bridge state can't be read are left alone.
+func failoverStates(entries map[string]*types.CachedNetConf, ports []ovsdb.OwnedPort, bridgeUp func(netconf *types.NetConf) (bool, error)) map[string]bool {
+	portNames := map[attachmentKey]string{}
+	for _, port := range ports {
+		portNames[attachmentKey{port.ExternalIDs["contNetns"], port.ExternalIDs["contNetwork"], port.ExternalIDs["contIface"]}] = port.Name
+	}
+	upByBridge := map[string]bool{}
+	states := map[string]bool{}
+	for cRef, entry := range entries {
+		if entry.Netconf == nil || entry.Netconf.Backup == nil || entry.ContainerID == "" || entry.Delegated {
+			continue
+		}
+		portName, found := portNames[attachmentKey{entry.Netns, entry.Netconf.Name, entry.IfName}]
+		if !found {
+			continue
+		}
+		// uplink_ports may differ between networks of the bridge
+		bridgeKey := entry.Netconf.BrName + "/" + strings.Join(entry.Netconf.UplinkPorts, ",")
+		up, known := upByBridge[bridgeKey]
+		if !known {
+			var err error
+			if up, err = bridgeUp(entry.Netconf); err != nil {
+				glog.Warningf("skipping failover of %s: %v", cRef, err)
+				continue
+			}
+			upByBridge[bridgeKey] = up
+		}
+		states[portName] = up
+	}
+	return states
+}
+
+// setLinkState sets the link up or down unless it already is
+func setLinkState(name string, up bool) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if (link.Attrs().Flags&net.FlagUp != 0) == up {
+		return nil
+	}
+	if up {
+		glog.Infof("uplinks of the active bridge of port %s are up again, failing back", name)
+		return netlink.LinkSetUp(link)
+	}
+	glog.Infof("uplinks of the active bridge of port %s are down, failing over to the backup bridge", name)
+	return netlink.LinkSetDown(link)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"errors"
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Backup failover", func() {
+	port := func(name, netns, network, ifName string) ovsdb.OwnedPort {
+		return ovsdb.OwnedPort{Name: name, ExternalIDs: map[string]string{"contNetns": netns, "contNetwork": network, "contIface": ifName}}
+	}
+	entry := func(netns, network, ifName, bridge string, backup bool) *types.CachedNetConf {
+		netconf := &types.NetConf{BrName: bridge}
+		netconf.Name = network
+		if backup {
+			netconf.Backup = &types.Backup{Bridge: bridge + "-backup"}
+		}
+		return &types.CachedNetConf{Netconf: netconf, ContainerID: "c1", IfName: ifName, Netns: netns}
+	}
+
+	It("should consider a bridge up while any uplink is up", func() {
+		Expect(activeBridgeUp(nil)).To(BeTrue())
+		Expect(activeBridgeUp(map[string]ovsdb.Uplink{"eth0": {Up: false}, "eth1": {Up: true}})).To(BeTrue())
+		Expect(activeBridgeUp(map[string]ovsdb.Uplink{"eth0": {Up: false}})).To(BeFalse())
+	})
+	It("should set active ports of attachments down while their bridge is down", func() {
+		entries := map[string]*types.CachedNetConf{
+			"net1-c1-eth1": entry("/ns1", "net1", "eth1", "br-down", true),
+			"net2-c1-eth2": entry("/ns1", "net2", "eth2", "br-up", true),
+			"net3-c1-eth3": entry("/ns1", "net3", "eth3", "br-down", false),
+			"net4-c1-eth4": entry("/ns1", "net4", "eth4", "br-down", true),
+			"net5-c1-eth5": entry("/ns1", "net5", "eth5", "br-broken", true),
+		}
+		ports := []ovsdb.OwnedPort{
+			port("veth1", "/ns1", "net1", "eth1"),
+			port("veth1b", "/ns1", "net1", "eth1b"),
+			port("veth2", "/ns1", "net2", "eth2"),
+			port("veth3", "/ns1", "net3", "eth3"),
+			port("veth5", "/ns1", "net5", "eth5"),
+		}
+		calls := 0
+		bridgeUp := func(netconf *types.NetConf) (bool, error) {
+			calls++
+			if netconf.BrName == "br-broken" {
+				return false, errors.New("bridge not found")
+			}
+			return netconf.BrName == "br-up", nil
+		}
+		Expect(failoverStates(entries, ports, bridgeUp)).To(Equal(map[string]bool{"veth1": false, "veth2": true}))
+		Expect(calls).To(Equal(3))
+	})
+	It("should change the link state only when it differs", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth1"}, PeerName: "peer1"})).To(Succeed())
+			isUp := func() bool {
+				link, err := netlink.LinkByName("veth1")
+				Expect(err).NotTo(HaveOccurred())
+				return link.Attrs().Flags&net.FlagUp != 0
+			}
+			Expect(setLinkState("veth1", true)).To(Succeed())
+			Expect(isUp()).To(BeTrue())
+			Expect(setLinkState("veth1", true)).To(Succeed())
+			Expect(setLinkState("veth1", false)).To(Succeed())
+			Expect(isUp()).To(BeFalse())
+			Expect(setLinkState("veth2", false)).NotTo(Succeed())
+			return nil
+		})).To(Succeed())
+	})
+})
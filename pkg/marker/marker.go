@@ -18,12 +18,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	k8scache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/cache"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
@@ -33,7 +39,7 @@ const (
 	// Expose available bridges as resources in format ovs-cni.network.kubevirt.io/[bridge name]
 	resourceNamespace = "ovs-cni.network.kubevirt.io"
 	// Kubernetes API does not support infinite resources, assume that 1000 connections is enough
-	resourceDefaultValue = "1000"
+	resourceDefaultValue = 1000
 )
 
 type patchOperation struct {
@@ -47,11 +53,43 @@ type Marker struct {
 	nodeName  string
 	clientset kubernetes.Interface
 	ovsdb     *ovsdb.OvsDriver
+
+	registry            *prometheus.Registry
+	bridgePorts         *prometheus.GaugeVec
+	cacheDrift          *prometheus.GaugeVec
+	portStats           *portStatsCollector
+	podInformer         k8scache.SharedIndexInformer
+	reportedUtilization map[string]int
+	reportedVLANs       map[string]string
+	reportedNodeState   []ovsdb.BridgeInventory
+	reportedCapacity    map[string]string
+	writtenLabels       map[string]string
+
+	// portWatchers are called on changes of ports and interfaces, the
+	// monitor is started by the first one
+	portWatchersLock sync.Mutex
+	portWatchers     []func(name string)
+
+	// BridgeHealth makes capacity of bridges whose uplinks are all down zero,
+	// like kubelet does with unhealthy devices, so new pods are scheduled
+	// to nodes where the bridge is connected
+	BridgeHealth bool
+
+	// devicePlugins serve bridges to kubelet in device plugin mode
+	devicePluginsLock sync.Mutex
+	devicePlugins     map[string]*bridgeDevicePlugin
 }
 
-// NewMarker creates new Marker object
-func NewMarker(nodeName string, ovsSocket string) (*Marker, error) {
-	config, err := rest.InClusterConfig()
+// NewMarker creates new Marker object, it uses the in-cluster config of the
+// API server when kubeconfig is empty
+func NewMarker(nodeName string, ovsSocket string, kubeconfig string) (*Marker, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Error while obtaining cluster config: %v", err)
 	}
@@ -66,7 +104,45 @@ func NewMarker(nodeName string, ovsSocket string) (*Marker, error) {
 		return nil, fmt.Errorf("Error creating the ovsdb connection: %v", err)
 	}
 
-	return &Marker{clientset: clientset, nodeName: nodeName, ovsdb: ovsDriver}, nil
+	bridgePorts := newBridgePortsGauge()
+	registry := prometheus.NewRegistry()
+	cacheDrift := newCacheDriftGauge()
+	portStats := newPortStatsCollector()
+	registry.MustRegister(bridgePorts, cacheDrift, portStats)
+	if err := ovsdb.RegisterMetrics(registry); err != nil {
+		return nil, fmt.Errorf("Error registering ovsdb metrics: %v", err)
+	}
+
+	return &Marker{clientset: clientset, nodeName: nodeName, ovsdb: ovsDriver, registry: registry, bridgePorts: bridgePorts, cacheDrift: cacheDrift, portStats: portStats}, nil
+}
+
+// watchPorts calls onChange with the name of every port or interface which is
+// added, removed or changes its link state, all watchers share one monitor
+func (m *Marker) watchPorts(onChange func(name string)) error {
+	m.portWatchersLock.Lock()
+	defer m.portWatchersLock.Unlock()
+	m.portWatchers = append(m.portWatchers, onChange)
+	if len(m.portWatchers) > 1 {
+		return nil
+	}
+	err := m.ovsdb.WatchPorts(func(name string) {
+		m.portWatchersLock.Lock()
+		watchers := m.portWatchers
+		m.portWatchersLock.Unlock()
+		for _, watcher := range watchers {
+			watcher(name)
+		}
+	})
+	if err != nil {
+		m.portWatchers = nil
+	}
+	return err
+}
+
+// SetOvsdbKeyPrefix sets the prefix of external_ids keys written by the
+// plugin, keys without it are still read
+func (m *Marker) SetOvsdbKeyPrefix(prefix string) {
+	m.ovsdb.KeyPrefix = prefix
 }
 
 func (m *Marker) getAvailableResources() (map[string]bool, error) {
@@ -83,6 +159,71 @@ func (m *Marker) getAvailableResources() (map[string]bool, error) {
 	return availableResources, nil
 }
 
+// getPortLimits returns the port limit of bridges set in their external_ids
+// or the default for bridges without one
+func (m *Marker) getPortLimits(bridges map[string]bool) (map[string]int, error) {
+	limits, err := m.ovsdb.BridgeExternalID(ovsdb.BridgeMaxPortsKey)
+	if err != nil {
+		return nil, err
+	}
+	portLimits := make(map[string]int, len(bridges))
+	for bridge := range bridges {
+		portLimits[bridge] = resourceDefaultValue
+		value, found := limits[bridge]
+		if !found {
+			continue
+		}
+		maxPorts, err := ovsdb.ParseMaxPorts(value)
+		if err != nil {
+			glog.Warningf("ignoring port limit of bridge %s: %v", bridge, err)
+			continue
+		}
+		portLimits[bridge] = maxPorts
+	}
+	return portLimits, nil
+}
+
+// getCapacities returns capacity of bridge resources, their port limit
+func (m *Marker) getCapacities(bridges map[string]bool) (map[string]string, error) {
+	portLimits, err := m.getPortLimits(bridges)
+	if err != nil {
+		return nil, err
+	}
+	capacities := make(map[string]string, len(bridges))
+	for bridge, portLimit := range portLimits {
+		capacities[bridge] = strconv.Itoa(portLimit)
+	}
+	if m.BridgeHealth {
+		for bridge := range bridges {
+			if !m.isBridgeHealthy(bridge) {
+				capacities[bridge] = "0"
+			}
+		}
+	}
+	return capacities, nil
+}
+
+// isBridgeHealthy returns false when the bridge has uplinks and none of them
+// is up, bridges without uplinks and bridges whose uplinks can't be read are
+// healthy
+func (m *Marker) isBridgeHealthy(bridge string) bool {
+	states, err := m.ovsdb.GetBridgeUplinkLinkStates(bridge, nil)
+	if err != nil {
+		glog.Warningf("failed to read uplinks of bridge %s, assuming it is healthy: %v", bridge, err)
+		return true
+	}
+	if len(states) == 0 {
+		return true
+	}
+	for _, up := range states {
+		if up {
+			return true
+		}
+	}
+	glog.Warningf("all uplinks of bridge %s are down, reporting it unhealthy", bridge)
+	return false
+}
+
 // GetReportedResources returns bridges that are reported on the node object
 func (m *Marker) GetReportedResources() (map[string]bool, error) {
 	reportedResources := make(map[string]bool)
@@ -111,6 +252,11 @@ func (m *Marker) Update(cache *cache.Cache) error {
 		return fmt.Errorf("failed to list available resources: %v", err)
 	}
 
+	capacities, err := m.getCapacities(availableResources)
+	if err != nil {
+		return fmt.Errorf("failed to read port limits of bridges: %v", err)
+	}
+
 	reportedResources := cache.Bridges()
 
 	patchOperations := make([]patchOperation, 0)
@@ -125,16 +271,21 @@ func (m *Marker) Update(cache *cache.Cache) error {
 	}
 
 	for availableResource := range availableResources {
-		if _, reported := reportedResources[availableResource]; !reported {
-			patchOperations = append(patchOperations, patchOperation{
-				Op:    "add",
-				Path:  fmt.Sprintf("/status/capacity/%s~1%s", resourceNamespace, availableResource),
-				Value: resourceDefaultValue,
-			})
+		_, reported := reportedResources[availableResource]
+		if reported && m.reportedCapacity[availableResource] == capacities[availableResource] {
+			continue
 		}
+		// capacity of reported resources is unknown after restart, it is
+		// replaced once then
+		patchOperations = append(patchOperations, patchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/status/capacity/%s~1%s", resourceNamespace, availableResource),
+			Value: capacities[availableResource],
+		})
 	}
 
 	if len(patchOperations) == 0 {
+		m.reportedCapacity = capacities
 		return nil
 	}
 
@@ -152,5 +303,26 @@ func (m *Marker) Update(cache *cache.Cache) error {
 	}
 
 	cache.Refresh(availableResources)
+	m.reportedCapacity = capacities
+	return nil
+}
+
+// patchAnnotation sets the annotation of the node
+func (m *Marker) patchAnnotation(key, value string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: value},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch of annotation %s: %v", key, err)
+	}
+	_, err = m.clientset.
+		CoreV1().
+		Nodes().
+		Patch(context.TODO(), m.nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to apply patch %s on node: %v", patch, err)
+	}
 	return nil
 }
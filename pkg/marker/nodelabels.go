@@ -0,0 +1,68 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+)
+
+// WriteNodeLabels writes labels of the node to the file, for the plugin to
+// resolve bridges configured as templates of node labels. The file is
+// replaced atomically and only when the labels change.
+func (m *Marker) WriteNodeLabels(path string) error {
+	node, err := m.clientset.
+		CoreV1().
+		Nodes().
+		Get(context.TODO(), m.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node: %v", err)
+	}
+	if m.writtenLabels != nil && reflect.DeepEqual(node.Labels, m.writtenLabels) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of node labels: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed to write node labels: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(config.FormatNodeLabels(node.Labels)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write node labels: %v", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write node labels: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write node labels: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write node labels: %v", err)
+	}
+	m.writtenLabels = node.Labels
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+)
+
+const (
+	// nodeStateVersion is the API version of the OVSNodeState resource
+	nodeStateVersion = "v1alpha1"
+	// nodeStateResource is the plural name of the OVSNodeState resource
+	nodeStateResource = "ovsnodestates"
+	// nodeStateFieldManager owns fields of OVSNodeState applied by marker
+	nodeStateFieldManager = "ovs-cni-marker"
+)
+
+// OVSNodeState is the cluster scoped resource named after the node, holding
+// inventory of OVS on the node
+type OVSNodeState struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status OVSNodeStateStatus `json:"status"`
+}
+
+// OVSNodeStateStatus lists bridges of the node
+type OVSNodeStateStatus struct {
+	Bridges []BridgeState `json:"bridges"`
+}
+
+// BridgeState describes a bridge of the node
+type BridgeState struct {
+	Name         string   `json:"name"`
+	DatapathType string   `json:"datapathType,omitempty"`
+	Uplinks      []string `json:"uplinks,omitempty"`
+	Ports        int      `json:"ports"`
+	OwnedPorts   int      `json:"ownedPorts"`
+}
+
+// newOVSNodeState returns the OVSNodeState of the node with the inventory,
+// owned by the node so it is removed together with it
+func newOVSNodeState(node metav1.Object, inventory []ovsdb.BridgeInventory) *OVSNodeState {
+	bridges := make([]BridgeState, 0, len(inventory))
+	for _, bridge := range inventory {
+		bridges = append(bridges, BridgeState{
+			Name:         bridge.Name,
+			DatapathType: bridge.DatapathType,
+			Uplinks:      bridge.Uplinks,
+			Ports:        bridge.Ports,
+			OwnedPorts:   bridge.OwnedPorts,
+		})
+	}
+	return &OVSNodeState{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: resourceNamespace + "/" + nodeStateVersion,
+			Kind:       "OVSNodeState",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: node.GetName(),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Node",
+				Name:       node.GetName(),
+				UID:        node.GetUID(),
+			}},
+		},
+		Status: OVSNodeStateStatus{Bridges: bridges},
+	}
+}
+
+// UpdateNodeState publishes inventory of OVS on the node in its OVSNodeState
+// resource, which holds more than extended resources can express. The
+// resource is created by server side apply, and applied only when the
+// inventory changes or the resource was deleted.
+func (m *Marker) UpdateNodeState() error {
+	inventory, err := m.ovsdb.GetBridgeInventory()
+	if err != nil {
+		return fmt.Errorf("failed to read inventory of bridges: %v", err)
+	}
+	if m.reportedNodeState != nil && reflect.DeepEqual(inventory, m.reportedNodeState) {
+		exists, err := m.nodeStateExists()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		glog.Infof("OVSNodeState of node %s was deleted, applying it again", m.nodeName)
+		m.reportedNodeState = nil
+	}
+
+	node, err := m.clientset.
+		CoreV1().
+		Nodes().
+		Get(context.TODO(), m.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node: %v", err)
+	}
+	body, err := json.Marshal(newOVSNodeState(node, inventory))
+	if err != nil {
+		return fmt.Errorf("failed to marshal OVSNodeState: %v", err)
+	}
+	err = m.clientset.
+		Discovery().
+		RESTClient().
+		Patch(types.ApplyPatchType).
+		AbsPath("/apis", resourceNamespace, nodeStateVersion, nodeStateResource, m.nodeName).
+		Param("fieldManager", nodeStateFieldManager).
+		Param("force", "true").
+		Body(body).
+		Do(context.TODO()).
+		Error()
+	if err != nil {
+		return fmt.Errorf("failed to apply OVSNodeState of node %s: %v", m.nodeName, err)
+	}
+	m.reportedNodeState = inventory
+	return nil
+}
+
+// nodeStateExists tells whether the OVSNodeState of the node exists, reading
+// it is cheaper than applying it again on every update
+func (m *Marker) nodeStateExists() (bool, error) {
+	err := m.clientset.
+		Discovery().
+		RESTClient().
+		Get().
+		AbsPath("/apis", resourceNamespace, nodeStateVersion, nodeStateResource, m.nodeName).
+		Do(context.TODO()).
+		Error()
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get OVSNodeState of node %s: %v", m.nodeName, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+)
+
+// fakeNodeStateServer serves the node and its OVSNodeState like the API
+// server, counting applies of the OVSNodeState
+type fakeNodeStateServer struct {
+	lock      sync.Mutex
+	nodeState []byte
+	applies   int
+	getStatus int
+}
+
+func (s *fakeNodeStateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/api/v1/nodes/node1" && r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(&corev1.Node{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "node1-uid"},
+		})
+	case r.URL.Path == "/apis/ovs-cni.network.kubevirt.io/v1alpha1/ovsnodestates/node1" && r.Method == http.MethodPatch:
+		body, _ := io.ReadAll(r.Body)
+		s.nodeState = body
+		s.applies++
+		_, _ = w.Write(body)
+	case r.URL.Path == "/apis/ovs-cni.network.kubevirt.io/v1alpha1/ovsnodestates/node1" && r.Method == http.MethodGet:
+		if s.getStatus != 0 {
+			w.WriteHeader(s.getStatus)
+			return
+		}
+		if s.nodeState == nil {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(&metav1.Status{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+				Status:   metav1.StatusFailure,
+				Reason:   metav1.StatusReasonNotFound,
+				Code:     http.StatusNotFound,
+			})
+			return
+		}
+		_, _ = w.Write(s.nodeState)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+var _ = Describe("Node state", func() {
+	const bridge = "br1"
+	var marker *Marker
+	var server *fakeNodeStateServer
+	var fakeOVSDB *testhelpers.FakeOVSDB
+
+	applied := func() OVSNodeState {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		nodeState := OVSNodeState{}
+		ExpectWithOffset(1, json.Unmarshal(server.nodeState, &nodeState)).To(Succeed())
+		return nodeState
+	}
+	applies := func() int {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		return server.applies
+	}
+
+	BeforeEach(func() {
+		var err error
+		fakeOVSDB, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(fakeOVSDB.Close)
+		ovsDriver, err := ovsdb.NewOvsDriver(fakeOVSDB.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		server = &fakeNodeStateServer{}
+		httpServer := httptest.NewServer(server)
+		DeferCleanup(httpServer.Close)
+		clientset, err := kubernetes.NewForConfig(&rest.Config{Host: httpServer.URL})
+		Expect(err).NotTo(HaveOccurred())
+		marker = &Marker{nodeName: "node1", clientset: clientset, ovsdb: ovsDriver}
+	})
+
+	It("should apply the inventory owned by the node", func() {
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		Expect(applies()).To(Equal(1))
+		nodeState := applied()
+		Expect(nodeState.Name).To(Equal("node1"))
+		Expect(nodeState.OwnerReferences).To(ConsistOf(metav1.OwnerReference{APIVersion: "v1", Kind: "Node", Name: "node1", UID: "node1-uid"}))
+		Expect(nodeState.Status.Bridges).To(ConsistOf(BridgeState{Name: bridge}))
+	})
+	It("should apply the inventory again only when it changes", func() {
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		Expect(applies()).To(Equal(1))
+
+		bridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridge, fakeOVSDB.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bridgeDriver.CreatePort(ovsdb.PortOptions{Name: "veth1", ContIface: "net1"})).To(Succeed())
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		Expect(applies()).To(Equal(2))
+		Expect(applied().Status.Bridges).To(ConsistOf(BridgeState{Name: bridge, Ports: 1, OwnedPorts: 1}))
+	})
+	It("should apply the inventory again when the resource is deleted", func() {
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		server.lock.Lock()
+		server.nodeState = nil
+		server.lock.Unlock()
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		Expect(applies()).To(Equal(2))
+		Expect(applied().Status.Bridges).To(ConsistOf(BridgeState{Name: bridge}))
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		Expect(applies()).To(Equal(2))
+	})
+	It("should fail without applying when the resource can't be read", func() {
+		Expect(marker.UpdateNodeState()).To(Succeed())
+		server.lock.Lock()
+		server.getStatus = http.StatusForbidden
+		server.lock.Unlock()
+		Expect(marker.UpdateNodeState()).To(MatchError(ContainSubstring("failed to get OVSNodeState of node node1")))
+		Expect(applies()).To(Equal(1))
+	})
+})
@@ -0,0 +1,174 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// podUIDIndex indexes pods of the node by their UID
+	podUIDIndex = "uid"
+	// podSyncTimeout limits how long an update waits for the first list of
+	// pods of the node
+	podSyncTimeout = 30 * time.Second
+)
+
+// portStatsLabels are labels of the statistics of a port, pod labels are
+// empty for ports whose pod is not found on the node
+var portStatsLabels = []string{"bridge", "port", "interface", "network", "namespace", "pod"}
+
+// portStatsCounters maps statistics of OVS interfaces to exported metrics
+var portStatsCounters = []struct{ statistic, name, help string }{
+	{"rx_bytes", "ovs_cni_port_rx_bytes_total", "Bytes received by the bridge from the port of ovs-cni, i.e. sent by the pod"},
+	{"tx_bytes", "ovs_cni_port_tx_bytes_total", "Bytes sent by the bridge to the port of ovs-cni, i.e. received by the pod"},
+	{"rx_dropped", "ovs_cni_port_rx_dropped_total", "Packets received from the port of ovs-cni dropped by the bridge"},
+	{"tx_dropped", "ovs_cni_port_tx_dropped_total", "Packets sent to the port of ovs-cni dropped by the bridge"},
+	{"rx_errors", "ovs_cni_port_rx_errors_total", "Receive errors of the port of ovs-cni"},
+	{"tx_errors", "ovs_cni_port_tx_errors_total", "Transmit errors of the port of ovs-cni"},
+}
+
+// portStats are the last read statistics of a port
+type portStats struct {
+	labels     []string
+	statistics map[string]uint64
+}
+
+// portStatsCollector exports statistics of ports read by the last update,
+// they are counters of OVS, so they are exported as they are
+type portStatsCollector struct {
+	descs []*prometheus.Desc
+
+	lock  sync.Mutex
+	ports []portStats
+}
+
+func newPortStatsCollector() *portStatsCollector {
+	collector := &portStatsCollector{}
+	for _, counter := range portStatsCounters {
+		collector.descs = append(collector.descs, prometheus.NewDesc(counter.name, counter.help, portStatsLabels, nil))
+	}
+	return collector
+}
+
+// Describe implements prometheus.Collector
+func (c *portStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *portStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, port := range c.ports {
+		for i, counter := range portStatsCounters {
+			value, found := port.statistics[counter.statistic]
+			if !found {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(c.descs[i], prometheus.CounterValue, float64(value), port.labels...)
+		}
+	}
+}
+
+func (c *portStatsCollector) set(ports []portStats) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ports = ports
+}
+
+// podsOfNode returns the informer of pods of the node, it is started by the
+// first call, so pods are watched instead of listed on every update
+func (m *Marker) podsOfNode() cache.SharedIndexInformer {
+	if m.podInformer != nil {
+		return m.podInformer
+	}
+	selector := fields.OneTermEqualSelector("spec.nodeName", m.nodeName).String()
+	pods := m.clientset.CoreV1().Pods(metav1.NamespaceAll)
+	m.podInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return pods.List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return pods.Watch(context.TODO(), options)
+			},
+		},
+		&corev1.Pod{},
+		0,
+		cache.Indexers{podUIDIndex: func(obj interface{}) ([]string, error) {
+			pod, ok := obj.(*corev1.Pod)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object %T", obj)
+			}
+			return []string{string(pod.UID)}, nil
+		}},
+	)
+	go m.podInformer.Run(wait.NeverStop)
+	return m.podInformer
+}
+
+// UpdatePortStatistics reads statistics of ports created by ovs-cni for the
+// metrics, labeled with the pod of the port, which is looked up by the pod
+// UID in external_ids among pods of the node
+func (m *Marker) UpdatePortStatistics() error {
+	ports, err := m.ovsdb.GetOwnedPorts()
+	if err != nil {
+		return fmt.Errorf("failed to list ports: %v", err)
+	}
+	informer := m.podsOfNode()
+	if !informer.HasSynced() {
+		ctx, cancel := context.WithTimeout(context.Background(), podSyncTimeout)
+		defer cancel()
+		if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			return fmt.Errorf("failed to sync pods of the node")
+		}
+	}
+
+	stats := make([]portStats, 0, len(ports))
+	for _, port := range ports {
+		var namespace, name string
+		pods, err := informer.GetIndexer().ByIndex(podUIDIndex, port.ExternalIDs["contPodUid"])
+		if err != nil {
+			return fmt.Errorf("failed to look up the pod of port %s: %v", port.Name, err)
+		}
+		if len(pods) > 0 {
+			pod := pods[0].(*corev1.Pod)
+			namespace, name = pod.Namespace, pod.Name
+		}
+		stats = append(stats, portStats{
+			labels:     []string{port.Bridge, port.Name, port.ExternalIDs["contIface"], port.ExternalIDs["contNetwork"], namespace, name},
+			statistics: port.Statistics,
+		})
+	}
+	m.portStats.set(stats)
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	libovsdb "github.com/ovn-org/libovsdb/ovsdb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+)
+
+var _ = Describe("Port statistics", func() {
+	const bridge = "br1"
+	var marker *Marker
+	var clientset *fake.Clientset
+	var fakeOVSDB *testhelpers.FakeOVSDB
+
+	newPod := func(namespace, name, uid string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(uid)},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}
+	}
+	// addPort adds a port of ovs-cni for the pod with rx_bytes statistics
+	addPort := func(name, podUID string, rxBytes int) {
+		bridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridge, fakeOVSDB.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bridgeDriver.CreatePort(ovsdb.PortOptions{Name: name, ContIface: "net1", ContNetwork: "ns1/net", ContPodUID: podUID})).To(Succeed())
+		_, err = fakeOVSDB.Transact(libovsdb.Operation{
+			Op:    libovsdb.OperationUpdate,
+			Table: "Interface",
+			Row:   libovsdb.Row{"statistics": libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"rx_bytes": rxBytes}}},
+			Where: []libovsdb.Condition{libovsdb.NewCondition("name", libovsdb.ConditionEqual, name)},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+	expectRxBytes := func(expected string) {
+		ExpectWithOffset(1, testutil.CollectAndCompare(marker.portStats, strings.NewReader(`
+# HELP ovs_cni_port_rx_bytes_total Bytes received by the bridge from the port of ovs-cni, i.e. sent by the pod
+# TYPE ovs_cni_port_rx_bytes_total counter
+`+expected), "ovs_cni_port_rx_bytes_total")).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		fakeOVSDB, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(fakeOVSDB.Close)
+		ovsDriver, err := ovsdb.NewOvsDriver(fakeOVSDB.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		clientset = fake.NewSimpleClientset(newPod("ns1", "pod1", "uid1"))
+		marker = &Marker{nodeName: "node1", clientset: clientset, ovsdb: ovsDriver, portStats: newPortStatsCollector()}
+	})
+
+	It("should label statistics of ports with their pod", func() {
+		addPort("veth1", "uid1", 100)
+		addPort("veth2", "uid2", 200)
+		Expect(marker.UpdatePortStatistics()).To(Succeed())
+		expectRxBytes(`ovs_cni_port_rx_bytes_total{bridge="br1",interface="net1",namespace="ns1",network="ns1/net",pod="pod1",port="veth1"} 100
+ovs_cni_port_rx_bytes_total{bridge="br1",interface="net1",namespace="",network="ns1/net",pod="",port="veth2"} 200
+`)
+	})
+	It("should watch pods of the node instead of listing them on every update", func() {
+		addPort("veth1", "uid1", 100)
+		Expect(marker.UpdatePortStatistics()).To(Succeed())
+		Expect(marker.UpdatePortStatistics()).To(Succeed())
+		lists := 0
+		for _, action := range clientset.Actions() {
+			Expect(action.GetResource().Resource).To(Equal("pods"))
+			Expect(action.GetNamespace()).To(Equal(metav1.NamespaceAll))
+			switch action := action.(type) {
+			case k8stesting.ListAction:
+				Expect(action.GetListRestrictions().Fields.String()).To(Equal("spec.nodeName=node1"))
+				lists++
+			case k8stesting.WatchAction:
+				Expect(action.GetWatchRestrictions().Fields.String()).To(Equal("spec.nodeName=node1"))
+			default:
+				Fail("unexpected " + action.GetVerb() + " of pods")
+			}
+		}
+		Expect(lists).To(Equal(1))
+	})
+	It("should label ports of pods started after the first update", func() {
+		Expect(marker.UpdatePortStatistics()).To(Succeed())
+		_, err := clientset.CoreV1().Pods("ns2").Create(context.TODO(), newPod("ns2", "pod2", "uid2"), metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		addPort("veth2", "uid2", 200)
+		Eventually(func() error {
+			if err := marker.UpdatePortStatistics(); err != nil {
+				return err
+			}
+			return testutil.CollectAndCompare(marker.portStats, strings.NewReader(`
+# HELP ovs_cni_port_rx_bytes_total Bytes received by the bridge from the port of ovs-cni, i.e. sent by the pod
+# TYPE ovs_cni_port_rx_bytes_total counter
+ovs_cni_port_rx_bytes_total{bridge="br1",interface="net1",namespace="ns2",network="ns1/net",pod="pod2",port="veth2"} 200
+`), "ovs_cni_port_rx_bytes_total")
+		}).Should(Succeed())
+	})
+})
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// portUtilizationAnnotation holds number of ovs-cni ports per bridge of the node
+const portUtilizationAnnotation = resourceNamespace + "/port-utilization"
+
+func newBridgePortsGauge() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovs_cni_bridge_ports",
+		Help: "Number of ports created by ovs-cni on the bridge",
+	}, []string{"bridge"})
+}
+
+// UpdatePortUtilization reports number of ports created by ovs-cni on each
+// bridge in the node annotation and the metrics, the node is patched only
+// when the numbers change
+func (m *Marker) UpdatePortUtilization() error {
+	counts, err := m.ovsdb.GetOwnedPortCounts()
+	if err != nil {
+		return fmt.Errorf("failed to count ports: %v", err)
+	}
+
+	m.bridgePorts.Reset()
+	for bridge, count := range counts {
+		m.bridgePorts.WithLabelValues(bridge).Set(float64(count))
+	}
+
+	if reflect.DeepEqual(counts, m.reportedUtilization) {
+		return nil
+	}
+	value, err := json.Marshal(counts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal port utilization: %v", err)
+	}
+	if err := m.patchAnnotation(portUtilizationAnnotation, string(value)); err != nil {
+		return err
+	}
+	m.reportedUtilization = counts
+	return nil
+}
+
+// MetricsHandler returns a handler serving metrics of the marker in the
+// Prometheus exposition format
+func (m *Marker) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := m.registry.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		format := expfmt.Negotiate(r.Header)
+		w.Header().Set("Content-Type", string(format))
+		encoder := expfmt.NewEncoder(w, format)
+		for _, family := range families {
+			if err := encoder.Encode(family); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// AllowedVLANsKey is the external_ids key of a bridge listing VLANs which
+	// may be used on it, e.g. "100-199,300"
+	AllowedVLANsKey = resourceNamespace + "/allowed-vlans"
+	// bridgeVLANsAnnotation holds allowed VLANs of bridges of the node
+	bridgeVLANsAnnotation = resourceNamespace + "/bridge-vlans"
+
+	maxVlanID = 4095
+)
+
+// UpdateBridgeVLANs publishes VLANs allowed on bridges of the node, as set by
+// the network team in the external_ids of the bridges, in the node
+// annotation. Bridges with malformed lists are left out. The node is patched
+// only when the lists change.
+func (m *Marker) UpdateBridgeVLANs() error {
+	values, err := m.ovsdb.BridgeExternalID(AllowedVLANsKey)
+	if err != nil {
+		return fmt.Errorf("failed to read allowed VLANs of bridges: %v", err)
+	}
+
+	vlans := make(map[string]string, len(values))
+	for bridge, value := range values {
+		normalized, err := normalizeVLANRanges(value)
+		if err != nil {
+			glog.Warningf("ignoring allowed VLANs %q of bridge %s: %v", value, bridge, err)
+			continue
+		}
+		vlans[bridge] = normalized
+	}
+
+	if reflect.DeepEqual(vlans, m.reportedVLANs) {
+		return nil
+	}
+	value, err := json.Marshal(vlans)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bridge VLANs: %v", err)
+	}
+	if err := m.patchAnnotation(bridgeVLANsAnnotation, string(value)); err != nil {
+		return err
+	}
+	m.reportedVLANs = vlans
+	return nil
+}
+
+// normalizeVLANRanges validates a comma separated list of VLAN IDs and
+// ranges and returns it without whitespace
+func normalizeVLANRanges(value string) (string, error) {
+	var ranges []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		minStr, maxStr, isRange := strings.Cut(item, "-")
+		minID, err := parseVlanID(minStr)
+		if err != nil {
+			return "", err
+		}
+		if !isRange {
+			ranges = append(ranges, strconv.Itoa(minID))
+			continue
+		}
+		maxID, err := parseVlanID(maxStr)
+		if err != nil {
+			return "", err
+		}
+		if minID > maxID {
+			return "", fmt.Errorf("range %s starts after it ends", item)
+		}
+		ranges = append(ranges, fmt.Sprintf("%d-%d", minID, maxID))
+	}
+	return strings.Join(ranges, ","), nil
+}
+
+func parseVlanID(value string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || id < 0 || id > maxVlanID {
+		return 0, fmt.Errorf("invalid VLAN ID %q, must be in range 0 to %d", value, maxVlanID)
+	}
+	return id, nil
+}
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"time"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
@@ -37,6 +38,32 @@ import (
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
+var (
+	// prevResultStore caches prevResult of the attachment for CmdDel
+	prevResultStore = utils.NewStore[types.CachedPrevResultNetConf]("", ".cons-", 0)
+	// legacyPrevResultStore holds prevResults cached by older versions,
+	// their keys are suffixed with _cons
+	legacyPrevResultStore = utils.NewStore[types.CachedPrevResultNetConf]("", "", 0)
+)
+
+// loadPrevResult returns the cached prevResult of the attachment, also when
+// it was cached by an older version
+func loadPrevResult(cRef string) (*types.CachedPrevResultNetConf, error) {
+	cache, err := prevResultStore.Load(cRef)
+	if errors.Is(err, utils.ErrNotCached) {
+		return legacyPrevResultStore.Load(cRef + "_cons")
+	}
+	return cache, err
+}
+
+// deletePrevResult removes the cached prevResult of the attachment
+func deletePrevResult(cRef string) error {
+	if err := legacyPrevResultStore.Delete(cRef + "_cons"); err != nil {
+		return err
+	}
+	return prevResultStore.Delete(cRef)
+}
+
 func init() {
 	// this ensures that main runs only on main thread (thread group leader).
 	// since namespace ops (unshare, setns) are done for a single thread, we
@@ -61,7 +88,12 @@ func getPortUUID(ovsDriver *ovsdb.OvsBridgeDriver, interfaces []*current.Interfa
 }
 
 func attachPortToMirror(ovsDriver *ovsdb.OvsBridgeDriver, portUUIDStr string, mirror *types.Mirror) error {
-	err := ovsDriver.AttachPortToMirrorConsumer(portUUIDStr, mirror.Name)
+	var err error
+	if mirror.OutputVlan != 0 {
+		err = ovsDriver.AttachVlanToMirrorConsumer(portUUIDStr, mirror.Name, mirror.OutputVlan)
+	} else {
+		err = ovsDriver.AttachPortToMirrorConsumer(portUUIDStr, mirror.Name)
+	}
 	if err != nil {
 		return err
 	}
@@ -70,7 +102,12 @@ func attachPortToMirror(ovsDriver *ovsdb.OvsBridgeDriver, portUUIDStr string, mi
 }
 
 func detachPortFromMirror(ovsDriver *ovsdb.OvsBridgeDriver, portUUIDStr string, mirror *types.Mirror) error {
-	err := ovsDriver.DetachPortFromMirrorConsumer(portUUIDStr, mirror.Name)
+	var err error
+	if mirror.OutputVlan != 0 {
+		err = ovsDriver.DetachVlanFromMirrorConsumer(portUUIDStr, mirror.Name)
+	} else {
+		err = ovsDriver.DetachPortFromMirrorConsumer(portUUIDStr, mirror.Name)
+	}
 	if err != nil {
 		return err
 	}
@@ -78,6 +115,32 @@ func detachPortFromMirror(ovsDriver *ovsdb.OvsBridgeDriver, portUUIDStr string,
 	return nil
 }
 
+// verifyTrafficInterval is the interval statistics of the consumer port are
+// polled in when traffic of mirrors is verified
+const verifyTrafficInterval = time.Second
+
+// verifyMirrorTraffic watches the consumer port for packets sent to it by
+// the mirrors and logs a warning if there are none, e.g. when mirrors have no
+// producers or their configuration doesn't select any traffic. Quiet mirrors
+// don't fail ADD, as producers may legitimately be quiet, failing to read the
+// statistics does.
+func verifyMirrorTraffic(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.MirrorNetConf) error {
+	for _, iface := range netconf.PrevResult.Interfaces {
+		if _, err := ovsDriver.GetPortUUID(iface.Name); err != nil {
+			continue
+		}
+		seen, err := ovsDriver.WaitForInterfaceCounter(iface.Name, "tx_packets", time.Duration(netconf.VerifyTraffic)*time.Second, verifyTrafficInterval)
+		if err != nil {
+			return fmt.Errorf("failed to verify traffic of mirrors on port %s: %v", iface.Name, err)
+		}
+		if !seen {
+			log.Printf("Warning: no mirrored packets arrived on port %s within %d seconds, mirrors may be misconfigured", iface.Name, netconf.VerifyTraffic)
+		}
+		return nil
+	}
+	return nil
+}
+
 // CmdAdd add handler for attaching container into network
 func CmdAdd(args *skel.CmdArgs) error {
 	logCall("ADD", args)
@@ -86,11 +149,15 @@ func CmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	if err := config.ValidateMirror(netconf); err != nil {
+		return err
+	}
 
 	ovsDriver, err := ovsdb.NewOvsBridgeDriver(netconf.BrName, netconf.SocketFile)
 	if err != nil {
 		return err
 	}
+	ovsDriver.KeyPrefix = netconf.OvsdbKeyPrefix
 
 	// removes all empty mirrors
 	if err := ovsDriver.CleanEmptyMirrors(); err != nil {
@@ -98,7 +165,7 @@ func CmdAdd(args *skel.CmdArgs) error {
 	}
 
 	// Cache PrevResult for CmdDel
-	if err = utils.SaveCache(config.GetCRef(args.ContainerID, args.IfName)+"_cons",
+	if err = prevResultStore.Save(config.GetCRef(args.ContainerID, args.IfName),
 		&types.CachedPrevResultNetConf{PrevResult: netconf.PrevResult}); err != nil {
 		return fmt.Errorf("error saving NetConf %q", err)
 	}
@@ -108,6 +175,12 @@ func CmdAdd(args *skel.CmdArgs) error {
 		return fmt.Errorf("cannot get existing portUuid from db %v", err)
 	}
 
+	for _, mirror := range netconf.Mirrors {
+		if mirror.OutputVlan > 4094 {
+			return fmt.Errorf("invalid output_vlan %d of mirror %s, it must be between 1 and 4094", mirror.OutputVlan, mirror.Name)
+		}
+	}
+
 	for _, mirror := range netconf.Mirrors {
 
 		err = ovsDriver.CreateMirror(netconf.BrName, mirror.Name)
@@ -117,7 +190,7 @@ func CmdAdd(args *skel.CmdArgs) error {
 
 		alreadyAttached, err := ovsDriver.IsMirrorConsumerAlreadyAttached(mirror.Name)
 		if err != nil {
-			return fmt.Errorf("cannot check if mirror %s has already an output port with error: %v ", mirror.Name, err)
+			return fmt.Errorf("cannot check if mirror %s has already an output port or vlan with error: %v ", mirror.Name, err)
 		}
 		if alreadyAttached {
 			return fmt.Errorf("cannot attach port %s to mirror %s because there is already another port. Error: %v", portUUID, mirror.Name, err)
@@ -128,6 +201,12 @@ func CmdAdd(args *skel.CmdArgs) error {
 		}
 	}
 
+	if netconf.VerifyTraffic > 0 {
+		if err := verifyMirrorTraffic(ovsDriver, netconf); err != nil {
+			return err
+		}
+	}
+
 	result := &current.Result{
 		Interfaces: netconf.PrevResult.Interfaces,
 	}
@@ -140,13 +219,13 @@ func CmdDel(args *skel.CmdArgs) error {
 	logCall("DEL", args)
 
 	cRef := config.GetCRef(args.ContainerID, args.IfName)
-	cache, err := config.LoadPrevResultConfFromCache(cRef + "_cons")
+	cache, err := loadPrevResult(cRef)
 	if err != nil {
 		// If cmdDel() fails, cached prevResult is cleaned up by
 		// the followed defer call. However, subsequence calls
 		// of cmdDel() from kubelet fail in a dead loop due to
 		// cached prevResult doesn't exist.
-		// Return nil when LoadPrevResultConfFromCache fails since the rest
+		// Return nil when loading prevResult fails since the rest
 		// of cmdDel() code relies on prevResult as input argument
 		// and there is no meaning to continue.
 		return nil
@@ -154,7 +233,7 @@ func CmdDel(args *skel.CmdArgs) error {
 
 	defer func() {
 		if err == nil {
-			if err := utils.CleanCache(cRef + "_cons"); err != nil {
+			if err := deletePrevResult(cRef); err != nil {
 				log.Printf("Failed cleaning up cache: %v", err)
 			}
 		}
@@ -171,6 +250,7 @@ func CmdDel(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	ovsDriver.KeyPrefix = netconf.OvsdbKeyPrefix
 
 	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces)
 	if err != nil {
@@ -231,6 +311,7 @@ func CmdCheck(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	ovsDriver.KeyPrefix = netconf.OvsdbKeyPrefix
 
 	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces)
 	if err != nil {
@@ -239,7 +320,12 @@ func CmdCheck(args *skel.CmdArgs) error {
 
 	for _, mirror := range netconf.Mirrors {
 
-		mirrorExist, err := ovsDriver.CheckMirrorConsumerWithPorts(mirror.Name, portUUID)
+		var mirrorExist bool
+		if mirror.OutputVlan != 0 {
+			mirrorExist, err = ovsDriver.CheckMirrorConsumerWithVlan(mirror.Name, mirror.OutputVlan, portUUID)
+		} else {
+			mirrorExist, err = ovsDriver.CheckMirrorConsumerWithPorts(mirror.Name, portUUID)
+		}
 		if err != nil {
 			return err
 		}
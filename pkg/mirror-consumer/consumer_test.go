@@ -279,6 +279,83 @@ var testFunc = func(version string) {
 		})
 	})
 
+	Context("adding host port to a mirror with traffic verification", func() {
+		mirrors := []types.Mirror{
+			{
+				Name: "mirror-cons",
+			},
+		}
+		mirrorsJSONStr, err := ToJSONString(mirrors)
+		Expect(err).NotTo(HaveOccurred())
+
+		conf := fmt.Sprintf(`{
+			"cniVersion": "%s",
+			"name": "mynet",
+			"type": "ovs-mirror-consumer",
+			"bridge": "%s",
+			"mirrors": %s,
+			"verify_traffic": 1
+		}`, version, bridgeName, mirrorsJSONStr)
+
+		It("should complete ADD although no mirrored packets arrive", func() {
+			targetNs := newNS()
+			defer func() {
+				closeNS(targetNs)
+			}()
+
+			By("create interfaces using ovs-cni plugin")
+			prevResult := createInterfaces(IFNAME1, targetNs)
+
+			By("run ovs-mirror-consumer passing prevResult")
+			confMirror, result := testAdd(conf, mirrors, prevResult, IFNAME1, false, targetNs)
+			testDel(confMirror, mirrors, result, IFNAME1, targetNs)
+		})
+	})
+
+	Context("adding host port to a mirror with output_vlan", func() {
+		mirrors := []types.Mirror{
+			{
+				Name:       "mirror-cons",
+				OutputVlan: 100,
+			},
+		}
+		mirrorsJSONStr, err := ToJSONString(mirrors)
+		Expect(err).NotTo(HaveOccurred())
+
+		conf := fmt.Sprintf(`{
+			"cniVersion": "%s",
+			"name": "mynet",
+			"type": "ovs-mirror-consumer",
+			"bridge": "%s",
+			"mirrors": %s
+		}`, version, bridgeName, mirrorsJSONStr)
+
+		It("should set output_vlan instead of output_port of the mirror", func() {
+			targetNs := newNS()
+			defer func() {
+				closeNS(targetNs)
+			}()
+
+			By("create interfaces using ovs-cni plugin")
+			prevResult := createInterfaces(IFNAME1, targetNs)
+
+			By("run ovs-mirror-consumer passing prevResult")
+			confMirror, result, err := add(version, conf, prevResult, IFNAME1, targetNs)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Checking output_vlan and output_port of the mirror")
+			outputVlan, err := GetMirrorAttribute("mirror-cons", "output_vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outputVlan).To(Equal("100"))
+			outputPorts, err := GetMirrorOutputPorts("mirror-cons")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outputPorts).To(BeEmpty())
+
+			testCheck(confMirror, result, IFNAME1, targetNs)
+			testDel(confMirror, mirrors, result, IFNAME1, targetNs)
+		})
+	})
+
 	Context("adding host port to multiple mirrors", func() {
 		Context("as consumer (output_port in ovsdb)", func() {
 			mirrors := []types.Mirror{
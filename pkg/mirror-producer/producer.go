@@ -34,9 +34,36 @@ import (
 	current "github.com/containernetworking/cni/pkg/types/100"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
+var (
+	// prevResultStore caches prevResult of the attachment for CmdDel
+	prevResultStore = utils.NewStore[types.CachedPrevResultNetConf]("", ".prod-", 0)
+	// legacyPrevResultStore holds prevResults cached by older versions,
+	// their keys are suffixed with _prod
+	legacyPrevResultStore = utils.NewStore[types.CachedPrevResultNetConf]("", "", 0)
+)
+
+// loadPrevResult returns the cached prevResult of the attachment, also when
+// it was cached by an older version
+func loadPrevResult(cRef string) (*types.CachedPrevResultNetConf, error) {
+	cache, err := prevResultStore.Load(cRef)
+	if errors.Is(err, utils.ErrNotCached) {
+		return legacyPrevResultStore.Load(cRef + "_prod")
+	}
+	return cache, err
+}
+
+// deletePrevResult removes the cached prevResult of the attachment
+func deletePrevResult(cRef string) error {
+	if err := legacyPrevResultStore.Delete(cRef + "_prod"); err != nil {
+		return err
+	}
+	return prevResultStore.Delete(cRef)
+}
+
 func init() {
 	// this ensures that main runs only on main thread (thread group leader).
 	// since namespace ops (unshare, setns) are done for a single thread, we
@@ -49,7 +76,7 @@ func logCall(command string, args *skel.CmdArgs) {
 		command, args.ContainerID, args.Netns, args.IfName, string(args.StdinData[:]))
 }
 
-func getPortUUID(ovsDriver *ovsdb.OvsBridgeDriver, interfaces []*current.Interface) (string, error) {
+func getPortUUID(ovsDriver *ovsdb.OvsBridgeDriver, interfaces []*current.Interface, deviceID string) (string, error) {
 	for _, iface := range interfaces {
 		uuid, err := ovsDriver.GetPortUUID(iface.Name)
 		if err == nil {
@@ -57,9 +84,47 @@ func getPortUUID(ovsDriver *ovsdb.OvsBridgeDriver, interfaces []*current.Interfa
 		}
 	}
 
+	// SR-IOV attachments are plugged into the bridge by the representor
+	// of the VF, which may not be listed in prevResult
+	deviceIDs := []string{}
+	if deviceID != "" {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	for _, iface := range interfaces {
+		if iface.PciID != "" && iface.PciID != deviceID {
+			deviceIDs = append(deviceIDs, iface.PciID)
+		}
+	}
+	for _, id := range deviceIDs {
+		uuid, err := getRepresentorPortUUID(ovsDriver, id)
+		if err == nil {
+			return uuid, nil
+		}
+		log.Printf("cannot find representor port of device %s: %v", id, err)
+	}
+
 	return "", errors.New("cannot find port in db")
 }
 
+// getRepresentorPortUUID returns UUID of the port of the VF representor, either
+// a kernel representor netdev or a representor of an OVS-DPDK port
+func getRepresentorPortUUID(ovsDriver *ovsdb.OvsBridgeDriver, deviceID string) (string, error) {
+	if rep, err := sriov.GetNetRepresentor(deviceID, nil); err == nil {
+		if uuid, err := ovsDriver.GetPortUUID(rep); err == nil {
+			return uuid.GoUUID, nil
+		}
+	}
+	devargs, err := sriov.GetDpdkRepresentorDevargs(deviceID)
+	if err != nil {
+		return "", err
+	}
+	uuid, err := ovsDriver.GetPortUUIDByDpdkDevargs(devargs)
+	if err != nil {
+		return "", err
+	}
+	return uuid.GoUUID, nil
+}
+
 func attachPortToMirror(ovsDriver *ovsdb.OvsBridgeDriver, portUUIDStr string, mirror *types.Mirror) error {
 	err := ovsDriver.AttachPortToMirrorProducer(portUUIDStr, mirror.Name, mirror.Ingress, mirror.Egress)
 	if err != nil {
@@ -91,6 +156,7 @@ func CmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	ovsDriver.KeyPrefix = netconf.OvsdbKeyPrefix
 
 	// removes all empty mirrors
 	if err := ovsDriver.CleanEmptyMirrors(); err != nil {
@@ -98,12 +164,12 @@ func CmdAdd(args *skel.CmdArgs) error {
 	}
 
 	// Cache PrevResult for CmdDel
-	if err = utils.SaveCache(config.GetCRef(args.ContainerID, args.IfName)+"_prod",
+	if err = prevResultStore.Save(config.GetCRef(args.ContainerID, args.IfName),
 		&types.CachedPrevResultNetConf{PrevResult: netconf.PrevResult}); err != nil {
 		return fmt.Errorf("error saving NetConf %q", err)
 	}
 
-	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces)
+	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces, netconf.DeviceID)
 	if err != nil {
 		return fmt.Errorf("cannot get existing portUuid from db %v", err)
 	}
@@ -132,13 +198,13 @@ func CmdDel(args *skel.CmdArgs) error {
 	logCall("DEL", args)
 
 	cRef := config.GetCRef(args.ContainerID, args.IfName)
-	cache, err := config.LoadPrevResultConfFromCache(cRef + "_prod")
+	cache, err := loadPrevResult(cRef)
 	if err != nil {
 		// If cmdDel() fails, cached prevResult is cleaned up by
 		// the followed defer call. However, subsequence calls
 		// of cmdDel() from kubelet fail in a dead loop due to
 		// cached prevResult doesn't exist.
-		// Return nil when LoadPrevResultConfFromCache fails since the rest
+		// Return nil when loading prevResult fails since the rest
 		// of cmdDel() code relies on prevResult as input argument
 		// and there is no meaning to continue.
 		return nil
@@ -146,7 +212,7 @@ func CmdDel(args *skel.CmdArgs) error {
 
 	defer func() {
 		if err == nil {
-			if err := utils.CleanCache(cRef + "_prod"); err != nil {
+			if err := deletePrevResult(cRef); err != nil {
 				log.Printf("Failed cleaning up cache: %v", err)
 			}
 		}
@@ -163,8 +229,9 @@ func CmdDel(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	ovsDriver.KeyPrefix = netconf.OvsdbKeyPrefix
 
-	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces)
+	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces, netconf.DeviceID)
 	if err != nil {
 		return fmt.Errorf("cannot get existing portUuid from db %v", err)
 	}
@@ -223,8 +290,9 @@ func CmdCheck(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
+	ovsDriver.KeyPrefix = netconf.OvsdbKeyPrefix
 
-	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces)
+	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces, netconf.DeviceID)
 	if err != nil {
 		return fmt.Errorf("cannot get existing portUuid from db %v", err)
 	}
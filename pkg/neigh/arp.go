@@ -0,0 +1,144 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package neigh detects addresses used by other hosts of the network and
+// announces addresses of attachments to them
+package neigh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	arpRequest    = 1
+	arpHeaderSize = 28
+	// probeCount of ARP probes sent for each address, RFC 5227 PROBE_NUM
+	probeCount = 3
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// arpProbe returns an ARP probe of the target, a request with an unspecified
+// sender address, so caches of other hosts are not updated by it
+func arpProbe(mac net.HardwareAddr, target net.IP) []byte {
+	msg := make([]byte, arpHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(msg[2:4], unix.ETH_P_IP)
+	msg[4] = 6
+	msg[5] = 4
+	binary.BigEndian.PutUint16(msg[6:8], arpRequest)
+	copy(msg[8:14], mac)
+	copy(msg[24:28], target.To4())
+	return msg
+}
+
+// arpConflict returns the target the ARP packet conflicts with, sent by a
+// host using it or probing for it as well, nil when it doesn't conflict
+func arpConflict(msg []byte, mac net.HardwareAddr, targets []net.IP) net.IP {
+	if len(msg) < arpHeaderSize || msg[4] != 6 || msg[5] != 4 {
+		return nil
+	}
+	senderMAC := net.HardwareAddr(msg[8:14])
+	if bytes.Equal(senderMAC, mac) {
+		return nil
+	}
+	senderIP, targetIP := net.IP(msg[14:18]), net.IP(msg[24:28])
+	probe := binary.BigEndian.Uint16(msg[6:8]) == arpRequest && senderIP.Equal(net.IPv4zero)
+	for _, target := range targets {
+		if senderIP.Equal(target) || (probe && targetIP.Equal(target)) {
+			return target
+		}
+	}
+	return nil
+}
+
+// ProbeIPv4 sends ARP probes of the IPv4 addresses from the interface as
+// described in RFC 5227 and returns those used by other hosts. Addresses must
+// not be configured yet, the interface must be up. It must run in the netns
+// of the interface and returns within the timeout.
+func ProbeIPv4(iface *net.Interface, ips []net.IP, timeout time.Duration) ([]net.IP, error) {
+	var targets []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			targets = append(targets, ip.To4())
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	proto := htons(unix.ETH_P_ARP)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ARP socket: %v", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}); err != nil {
+		return nil, fmt.Errorf("failed to bind ARP socket to %s: %v", iface.Name, err)
+	}
+	dst := &unix.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index, Halen: 6}
+	copy(dst.Addr[:], broadcastMAC)
+
+	conflicts := map[string]net.IP{}
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(timeout)
+	for i := 0; i < probeCount; i++ {
+		for _, target := range targets {
+			if _, found := conflicts[target.String()]; found {
+				continue
+			}
+			if err := unix.Sendto(fd, arpProbe(iface.HardwareAddr, target), 0, dst); err != nil {
+				return nil, fmt.Errorf("failed to send ARP probe of %s: %v", target, err)
+			}
+		}
+		// probes are spread over the timeout, replies are read until the next one
+		next := deadline.Add(-timeout * time.Duration(probeCount-i-1) / probeCount)
+		for wait := time.Until(next); wait > 0; wait = time.Until(next) {
+			tv := unix.NsecToTimeval(wait.Nanoseconds())
+			if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+				return nil, err
+			}
+			n, from, err := unix.Recvfrom(fd, buf, 0)
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to receive ARP replies on %s: %v", iface.Name, err)
+			}
+			if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING {
+				continue
+			}
+			if ip := arpConflict(buf[:n], iface.HardwareAddr, targets); ip != nil {
+				conflicts[ip.String()] = ip
+			}
+		}
+	}
+	var used []net.IP
+	for _, target := range targets {
+		if ip, found := conflicts[target.String()]; found {
+			used = append(used, ip)
+		}
+	}
+	return used, nil
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
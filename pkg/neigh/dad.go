@@ -0,0 +1,69 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neigh
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// dadPollInterval is how often addresses are checked while DAD runs
+const dadPollInterval = 50 * time.Millisecond
+
+// WaitDAD waits until duplicate address detection of the IPv6 addresses of
+// the interface finishes and returns those it failed for, they are used by
+// other hosts. Addresses still tentative after the timeout are an error. It
+// must run in the netns of the interface.
+func WaitDAD(ifName string, ips []net.IP, timeout time.Duration) ([]net.IP, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %q: %v", ifName, err)
+		}
+		var failed, tentative []net.IP
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if !addr.IP.Equal(ip) {
+					continue
+				}
+				switch {
+				case addr.Flags&unix.IFA_F_DADFAILED != 0:
+					failed = append(failed, ip)
+				case addr.Flags&unix.IFA_F_TENTATIVE != 0:
+					tentative = append(tentative, ip)
+				}
+			}
+		}
+		if len(tentative) == 0 {
+			return failed, nil
+		}
+		if time.Now().After(deadline) {
+			return failed, fmt.Errorf("addresses %v of %q are still tentative after %v", tentative, ifName, timeout)
+		}
+		time.Sleep(dadPollInterval)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neigh
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	icmpv6NeighborAdvertisement = 136
+	// ndpOverrideFlag makes peers replace the link-layer address they have
+	ndpOverrideFlag = 0x20
+	// ndpTargetLinkLayerAddress is the option carrying MAC of the target
+	ndpTargetLinkLayerAddress = 2
+	// ndpHopLimit is required by receivers of neighbor discovery messages
+	ndpHopLimit = 255
+)
+
+// neighborAdvertisement returns an unsolicited neighbor advertisement of the
+// target, the checksum is left to the kernel
+func neighborAdvertisement(target net.IP, mac net.HardwareAddr) []byte {
+	msg := make([]byte, 24, 32)
+	msg[0] = icmpv6NeighborAdvertisement
+	msg[4] = ndpOverrideFlag
+	copy(msg[8:24], target.To16())
+	if len(mac) == 6 {
+		msg = append(msg, ndpTargetLinkLayerAddress, 1)
+		msg = append(msg, mac...)
+	}
+	return msg
+}
+
+// SendUnsolicitedNA advertises ip on the interface to all nodes, so peers
+// and routers refresh their neighbor caches. It must run in the netns of the
+// interface, the address must have passed duplicate address detection.
+func SendUnsolicitedNA(ip net.IP, iface *net.Interface) error {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return fmt.Errorf("failed to open ICMPv6 socket: %v", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ndpHopLimit); err != nil {
+		return fmt.Errorf("failed to set hop limit: %v", err)
+	}
+	src := &unix.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(src.Addr[:], ip.To16())
+	if err := unix.Bind(fd, src); err != nil {
+		return fmt.Errorf("failed to bind to %s: %v", ip, err)
+	}
+	dst := &unix.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(dst.Addr[:], net.IPv6linklocalallnodes)
+	return unix.Sendto(fd, neighborAdvertisement(ip, iface.HardwareAddr), 0, dst)
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neigh
+
+import (
+	"net"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("ARP probes", func() {
+	mac, _ := net.ParseMAC("0a:58:0a:01:02:03")
+	other, _ := net.ParseMAC("0a:58:0a:01:02:04")
+	targets := []net.IP{net.ParseIP("10.1.2.3").To4()}
+
+	It("should not announce the sender address", func() {
+		msg := arpProbe(mac, net.ParseIP("10.1.2.3"))
+		Expect(msg).To(HaveLen(arpHeaderSize))
+		Expect(msg[:8]).To(Equal([]byte{0, 1, 8, 0, 6, 4, 0, 1}))
+		Expect(net.HardwareAddr(msg[8:14])).To(Equal(mac))
+		Expect(net.IP(msg[14:18]).Equal(net.IPv4zero)).To(BeTrue())
+		Expect(net.IP(msg[24:28]).Equal(net.ParseIP("10.1.2.3"))).To(BeTrue())
+	})
+	It("should detect hosts using or probing for the address", func() {
+		reply := arpProbe(other, net.ParseIP("10.1.2.1"))
+		reply[7] = 2
+		copy(reply[14:18], net.ParseIP("10.1.2.3").To4())
+		Expect(arpConflict(reply, mac, targets)).To(Equal(targets[0]))
+		Expect(arpConflict(arpProbe(other, net.ParseIP("10.1.2.3")), mac, targets)).To(Equal(targets[0]))
+	})
+	It("should ignore own probes and other addresses", func() {
+		Expect(arpConflict(arpProbe(mac, net.ParseIP("10.1.2.3")), mac, targets)).To(BeNil())
+		Expect(arpConflict(arpProbe(other, net.ParseIP("10.1.2.4")), mac, targets)).To(BeNil())
+		Expect(arpConflict([]byte{0, 1}, mac, targets)).To(BeNil())
+	})
+})
+
+var _ = Describe("Neighbor advertisement", func() {
+	It("should override entries of the target with its MAC", func() {
+		mac, err := net.ParseMAC("0a:58:0a:01:02:03")
+		Expect(err).NotTo(HaveOccurred())
+		msg := neighborAdvertisement(net.ParseIP("fd00::1"), mac)
+		Expect(msg).To(HaveLen(32))
+		Expect(msg[:8]).To(Equal([]byte{136, 0, 0, 0, 0x20, 0, 0, 0}))
+		Expect(net.IP(msg[8:24]).Equal(net.ParseIP("fd00::1"))).To(BeTrue())
+		Expect(msg[24:]).To(Equal([]byte{2, 1, 0x0a, 0x58, 0x0a, 0x01, 0x02, 0x03}))
+	})
+	It("should omit the link-layer address option without a MAC", func() {
+		Expect(neighborAdvertisement(net.ParseIP("fd00::1"), nil)).To(HaveLen(24))
+	})
+})
+
+var _ = Describe("Duplicate address detection", func() {
+	var hostNS, peerNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		hostNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		peerNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hostNS.Do(func(ns.NetNS) error {
+			_, _, err := ip.SetupVethWithName("eth0", "peer0", 1500, "", peerNS)
+			if err != nil {
+				return err
+			}
+			return setUp("eth0")
+		})).To(Succeed())
+		Expect(peerNS.Do(func(ns.NetNS) error {
+			link, err := netlink.LinkByName("peer0")
+			if err != nil {
+				return err
+			}
+			if err := netlink.LinkSetUp(link); err != nil {
+				return err
+			}
+			if err := netlink.AddrAdd(link, mustParseAddr("10.1.2.3/24")); err != nil {
+				return err
+			}
+			addr := mustParseAddr("fd00::3/64")
+			addr.Flags = unix.IFA_F_NODAD
+			return netlink.AddrAdd(link, addr)
+		})).To(Succeed())
+	})
+	AfterEach(func() {
+		Expect(hostNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(hostNS)).To(Succeed())
+		Expect(peerNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(peerNS)).To(Succeed())
+	})
+
+	It("should find IPv4 addresses used by other hosts", func() {
+		Expect(hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			iface, err := net.InterfaceByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			used, err := ProbeIPv4(iface, []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("10.1.2.4"), net.ParseIP("fd00::3")}, 300*time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(used).To(HaveLen(1))
+			Expect(used[0].Equal(net.ParseIP("10.1.2.3"))).To(BeTrue())
+			return nil
+		})).To(Succeed())
+	})
+	It("should find IPv6 addresses which failed DAD", func() {
+		Expect(hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			link, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.AddrAdd(link, mustParseAddr("fd00::3/64"))).To(Succeed())
+			Expect(netlink.AddrAdd(link, mustParseAddr("fd00::4/64"))).To(Succeed())
+			failed, err := WaitDAD("eth0", []net.IP{net.ParseIP("fd00::3"), net.ParseIP("fd00::4"), net.ParseIP("10.1.2.4")}, 5*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(failed).To(HaveLen(1))
+			Expect(failed[0].Equal(net.ParseIP("fd00::3"))).To(BeTrue())
+			return nil
+		})).To(Succeed())
+	})
+	It("should advertise IPv6 addresses which passed DAD to other hosts", func() {
+		fd := -1
+		Expect(peerNS.Do(func(ns.NetNS) error {
+			var err error
+			if fd, err = unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6); err != nil {
+				return err
+			}
+			return unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 5})
+		})).To(Succeed())
+		defer unix.Close(fd)
+
+		var mac net.HardwareAddr
+		Expect(hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			link, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.AddrAdd(link, mustParseAddr("fd00::5/64"))).To(Succeed())
+			failed, err := WaitDAD("eth0", []net.IP{net.ParseIP("fd00::5")}, 5*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(failed).To(BeEmpty())
+			iface, err := net.InterfaceByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			mac = iface.HardwareAddr
+			return SendUnsolicitedNA(net.ParseIP("fd00::5"), iface)
+		})).To(Succeed())
+
+		// the peer receives DAD solicitations and router solicitations as well
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			Expect(err).NotTo(HaveOccurred())
+			if n < 24 || buf[0] != icmpv6NeighborAdvertisement {
+				continue
+			}
+			Expect(net.IP(buf[8:24]).Equal(net.ParseIP("fd00::5"))).To(BeTrue())
+			Expect(buf[4] & ndpOverrideFlag).NotTo(BeZero())
+			Expect(net.HardwareAddr(buf[26:n])).To(Equal(mac))
+			break
+		}
+	})
+})
+
+func setUp(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(link)
+}
+
+func mustParseAddr(s string) *netlink.Addr {
+	addr, err := netlink.ParseAddr(s)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return addr
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !simulation
+
+package netif
+
+import (
+	"errors"
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Default are the operations of the kernel
+var Default Ops = kernel{}
+
+// IsNotFound returns true when err is returned for a missing interface
+func IsNotFound(err error) bool {
+	var notFound netlink.LinkNotFoundError
+	return errors.As(err, &notFound)
+}
+
+type kernel struct{}
+
+func (kernel) LinkByName(name string) (netlink.Link, error) {
+	return netlink.LinkByName(name)
+}
+
+func (kernel) LinkByIndex(index int) (netlink.Link, error) {
+	return netlink.LinkByIndex(index)
+}
+
+func (kernel) LinkSetUp(link netlink.Link) error {
+	return netlink.LinkSetUp(link)
+}
+
+func (kernel) LinkSetDown(link netlink.Link) error {
+	return netlink.LinkSetDown(link)
+}
+
+func (kernel) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	return netlink.LinkSetHardwareAddr(link, hwaddr)
+}
+
+func (kernel) LinkDel(link netlink.Link) error {
+	return netlink.LinkDel(link)
+}
+
+func (kernel) LinkAdd(link netlink.Link) error {
+	return netlink.LinkAdd(link)
+}
+
+func (kernel) LinkSetName(link netlink.Link, name string) error {
+	return netlink.LinkSetName(link, name)
+}
+
+func (kernel) LinkSetMTU(link netlink.Link, mtu int) error {
+	return netlink.LinkSetMTU(link, mtu)
+}
+
+func (kernel) LinkSetMaster(link, master netlink.Link) error {
+	return netlink.LinkSetMaster(link, master)
+}
+
+func (kernel) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	return netlink.LinkSetMasterByIndex(link, masterIndex)
+}
+
+func (kernel) LinkSetNoMaster(link netlink.Link) error {
+	return netlink.LinkSetNoMaster(link)
+}
+
+func (kernel) LinkSetNs(link netlink.Link, netns ns.NetNS) error {
+	return netlink.LinkSetNsFd(link, int(netns.Fd()))
+}
+
+func (kernel) LinkAddAltName(link netlink.Link, altName string) error {
+	return linkAltNameRequest(link, altName, unix.RTM_NEWLINKPROP)
+}
+
+func (kernel) LinkDelAltName(link netlink.Link, altName string) error {
+	return linkAltNameRequest(link, altName, unix.RTM_DELLINKPROP)
+}
+
+// linkAltNameRequest adds or, with RTM_DELLINKPROP, removes an alternative
+// name of the link, netlink library doesn't support them
+func linkAltNameRequest(link netlink.Link, altName string, cmd int) error {
+	req := nl.NewNetlinkRequest(cmd, unix.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+	props := nl.NewRtAttr(unix.IFLA_PROP_LIST|unix.NLA_F_NESTED, nil)
+	props.AddRtAttr(unix.IFLA_ALT_IFNAME, nl.ZeroTerminated(altName))
+	req.AddData(props)
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+func (kernel) VethPeerIndex(link *netlink.Veth) (int, error) {
+	return netlink.VethPeerIndex(link)
+}
+
+func (kernel) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	return netlink.AddrList(link, family)
+}
+
+func (kernel) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	return netlink.AddrAdd(link, addr)
+}
+
+func (kernel) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	return netlink.AddrDel(link, addr)
+}
+
+func (kernel) RouteList(family int) ([]netlink.Route, error) {
+	return netlink.RouteListFiltered(family, nil, 0)
+}
+
+func (kernel) RouteReplace(route *netlink.Route) error {
+	return netlink.RouteReplace(route)
+}
+
+func (kernel) RouteAddEcmp(route *netlink.Route) error {
+	return netlink.RouteAddEcmp(route)
+}
+
+func (kernel) RouteDel(route *netlink.Route) error {
+	return netlink.RouteDel(route)
+}
+
+func (kernel) NeighSet(neigh *netlink.Neigh) error {
+	return netlink.NeighSet(neigh)
+}
+
+func (kernel) NeighDel(neigh *netlink.Neigh) error {
+	return netlink.NeighDel(neigh)
+}
+
+func (kernel) QdiscAdd(qdisc netlink.Qdisc) error {
+	return netlink.QdiscAdd(qdisc)
+}
+
+func (kernel) QdiscDel(qdisc netlink.Qdisc) error {
+	return netlink.QdiscDel(qdisc)
+}
+
+func (kernel) FilterAdd(filter netlink.Filter) error {
+	return netlink.FilterAdd(filter)
+}
+
+func (kernel) Sysctl(name string, value ...string) (string, error) {
+	return sysctl.Sysctl(name, value...)
+}
+
+func (kernel) SetupVeth(contVethName, hostVethName string, mtu int, contVethMac string, hostNS ns.NetNS) (net.Interface, net.Interface, error) {
+	return ip.SetupVethWithName(contVethName, hostVethName, mtu, contVethMac, hostNS)
+}
+
+func (kernel) DelLinkByName(name string) error {
+	return ip.DelLinkByName(name)
+}
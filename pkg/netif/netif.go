@@ -0,0 +1,73 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netif operates network interfaces in the namespace the caller is
+// in. The plugin uses Default instead of netlink directly, built with the
+// simulation tag it is an in-memory fake working with simulated namespaces
+// of package netns.
+package netif
+
+import (
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+// Ops are the operations on network interfaces used by the plugin, they
+// behave like the functions of netlink and ip of the same name
+type Ops interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	LinkSetUp(link netlink.Link) error
+	LinkSetDown(link netlink.Link) error
+	LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error
+	LinkDel(link netlink.Link) error
+	LinkAdd(link netlink.Link) error
+	LinkSetName(link netlink.Link, name string) error
+	LinkSetMTU(link netlink.Link, mtu int) error
+	LinkSetMaster(link, master netlink.Link) error
+	LinkSetMasterByIndex(link netlink.Link, masterIndex int) error
+	LinkSetNoMaster(link netlink.Link) error
+	// LinkSetNs moves the interface to the namespace
+	LinkSetNs(link netlink.Link, netns ns.NetNS) error
+	// LinkAddAltName and LinkDelAltName add and remove an alternative name
+	// of the interface, they fail with EEXIST and ENOENT like the kernel
+	LinkAddAltName(link netlink.Link, altName string) error
+	LinkDelAltName(link netlink.Link, altName string) error
+	VethPeerIndex(link *netlink.Veth) (int, error)
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+	AddrDel(link netlink.Link, addr *netlink.Addr) error
+	// RouteList lists routes of the main table of the family in the current
+	// namespace, like netlink.RouteListFiltered without a filter
+	RouteList(family int) ([]netlink.Route, error)
+	RouteReplace(route *netlink.Route) error
+	RouteAddEcmp(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+	NeighSet(neigh *netlink.Neigh) error
+	NeighDel(neigh *netlink.Neigh) error
+	QdiscAdd(qdisc netlink.Qdisc) error
+	QdiscDel(qdisc netlink.Qdisc) error
+	FilterAdd(filter netlink.Filter) error
+	// Sysctl reads the sysctl of the current namespace, it sets the value
+	// first when one is given
+	Sysctl(name string, value ...string) (string, error)
+	// SetupVeth creates a veth pair in the current namespace and moves its
+	// host side to hostNS, it returns the host and the container side
+	SetupVeth(contVethName, hostVethName string, mtu int, contVethMac string, hostNS ns.NetNS) (net.Interface, net.Interface, error)
+	// DelLinkByName removes the interface, it returns ip.ErrLinkNotFound
+	// when there is none
+	DelLinkByName(name string) error
+}
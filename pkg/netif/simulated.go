@@ -0,0 +1,668 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build simulation
+
+package netif
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+)
+
+// Default are the in-memory operations, interfaces are kept in the simulated
+// namespace the caller is in, see netns.Current
+var Default Ops = NewSimulated()
+
+// IsNotFound returns true when err is returned for a missing interface
+func IsNotFound(err error) bool {
+	var notFound notFoundError
+	return errors.As(err, &notFound)
+}
+
+type notFoundError struct {
+	name string
+}
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("Link not found: %s", e.name)
+}
+
+// simulatedLink is a simulated interface
+type simulatedLink struct {
+	netns     string
+	linkType  string
+	attrs     netlink.LinkAttrs
+	peerIndex int
+	altNames  []string
+	addrs     []netlink.Addr
+	qdiscs    []netlink.Qdisc
+	filters   []netlink.Filter
+}
+
+// link returns the interface like netlink does, typed by its kind
+func (l *simulatedLink) link() netlink.Link {
+	attrs := l.attrs
+	switch l.linkType {
+	case "veth":
+		return &netlink.Veth{LinkAttrs: attrs}
+	case "bond":
+		return &netlink.Bond{LinkAttrs: attrs}
+	case "bridge":
+		return &netlink.Bridge{LinkAttrs: attrs}
+	case "tuntap":
+		return &netlink.Tuntap{LinkAttrs: attrs}
+	}
+	return &netlink.Device{LinkAttrs: attrs}
+}
+
+// Simulated are operations on interfaces, addresses, routes, neighbors and
+// traffic control only existing in memory. Routes and neighbors are kept per
+// namespace, the rest on the interface.
+type Simulated struct {
+	lastIndex int
+	links     map[int]*simulatedLink
+	routes    map[string][]netlink.Route
+	neighs    map[string][]netlink.Neigh
+	sysctls   map[string]map[string]string
+}
+
+// NewSimulated returns the operations on a new set of simulated interfaces
+func NewSimulated() *Simulated {
+	return &Simulated{links: map[int]*simulatedLink{}, routes: map[string][]netlink.Route{}, neighs: map[string][]netlink.Neigh{},
+		sysctls: map[string]map[string]string{}}
+}
+
+// lookup returns the interface of the index in the current namespace
+func (s *Simulated) lookup(index int) (*simulatedLink, error) {
+	link, found := s.links[index]
+	if !found || link.netns != netns.Current().Path() {
+		return nil, notFoundError{fmt.Sprintf("index %d", index)}
+	}
+	return link, nil
+}
+
+// byName returns the interface with the name or alternative name in the
+// namespace, they share a namespace like in the kernel
+func (s *Simulated) byName(netnsPath, name string) *simulatedLink {
+	for _, link := range s.links {
+		if link.netns != netnsPath {
+			continue
+		}
+		if link.attrs.Name == name || slices.Contains(link.altNames, name) {
+			return link
+		}
+	}
+	return nil
+}
+
+// add adds the interface to the current namespace with the next index
+func (s *Simulated) add(linkType string, attrs netlink.LinkAttrs) (*simulatedLink, error) {
+	if attrs.MTU == 0 {
+		attrs.MTU = 1500
+	}
+	if attrs.HardwareAddr == nil {
+		mac, err := randomMac()
+		if err != nil {
+			return nil, err
+		}
+		attrs.HardwareAddr = mac
+	}
+	s.lastIndex++
+	attrs.Index = s.lastIndex
+	link := &simulatedLink{netns: netns.Current().Path(), linkType: linkType, attrs: attrs}
+	s.links[link.attrs.Index] = link
+	return link, nil
+}
+
+// forget removes routes and neighbors of the interface from its namespace
+func (s *Simulated) forget(link *simulatedLink) {
+	s.routes[link.netns] = slices.DeleteFunc(s.routes[link.netns], func(route netlink.Route) bool {
+		return route.LinkIndex == link.attrs.Index
+	})
+	s.neighs[link.netns] = slices.DeleteFunc(s.neighs[link.netns], func(neigh netlink.Neigh) bool {
+		return neigh.LinkIndex == link.attrs.Index
+	})
+}
+
+func (s *Simulated) LinkByName(name string) (netlink.Link, error) {
+	link := s.byName(netns.Current().Path(), name)
+	if link == nil {
+		return nil, notFoundError{name}
+	}
+	return link.link(), nil
+}
+
+func (s *Simulated) LinkByIndex(index int) (netlink.Link, error) {
+	link, err := s.lookup(index)
+	if err != nil {
+		return nil, err
+	}
+	return link.link(), nil
+}
+
+func (s *Simulated) LinkSetUp(link netlink.Link) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	simulated.attrs.Flags |= net.FlagUp
+	simulated.attrs.OperState = netlink.OperUp
+	return nil
+}
+
+func (s *Simulated) LinkSetDown(link netlink.Link) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	simulated.attrs.Flags &^= net.FlagUp
+	simulated.attrs.OperState = netlink.OperDown
+	return nil
+}
+
+func (s *Simulated) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	simulated.attrs.HardwareAddr = hwaddr
+	return nil
+}
+
+func (s *Simulated) LinkDel(link netlink.Link) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	// removing a side of a veth removes its peer as well
+	for _, index := range []int{simulated.peerIndex, simulated.attrs.Index} {
+		if removed, found := s.links[index]; found {
+			s.forget(removed)
+			delete(s.links, index)
+		}
+	}
+	for _, other := range s.links {
+		if other.attrs.MasterIndex == simulated.attrs.Index {
+			other.attrs.MasterIndex = 0
+		}
+	}
+	return nil
+}
+
+// LinkAdd adds the interface to the current namespace, a veth is added along
+// with its peer
+func (s *Simulated) LinkAdd(link netlink.Link) error {
+	current := netns.Current().Path()
+	if s.byName(current, link.Attrs().Name) != nil {
+		return syscall.EEXIST
+	}
+	veth, isVeth := link.(*netlink.Veth)
+	if isVeth && (veth.PeerName == "" || s.byName(current, veth.PeerName) != nil) {
+		return syscall.EEXIST
+	}
+	added, err := s.add(link.Type(), *link.Attrs())
+	if err != nil {
+		return err
+	}
+	link.Attrs().Index = added.attrs.Index
+	if isVeth {
+		peerAttrs := netlink.NewLinkAttrs()
+		peerAttrs.Name = veth.PeerName
+		peerAttrs.MTU = added.attrs.MTU
+		peerAttrs.HardwareAddr = veth.PeerHardwareAddr
+		peer, err := s.add(link.Type(), peerAttrs)
+		if err != nil {
+			return err
+		}
+		added.peerIndex, peer.peerIndex = peer.attrs.Index, added.attrs.Index
+	}
+	return nil
+}
+
+func (s *Simulated) LinkSetName(link netlink.Link, name string) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	if other := s.byName(simulated.netns, name); other != nil && other != simulated {
+		return syscall.EEXIST
+	}
+	simulated.attrs.Name = name
+	return nil
+}
+
+func (s *Simulated) LinkSetMTU(link netlink.Link, mtu int) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	simulated.attrs.MTU = mtu
+	return nil
+}
+
+func (s *Simulated) LinkSetMaster(link, master netlink.Link) error {
+	if master == nil {
+		return s.LinkSetNoMaster(link)
+	}
+	return s.LinkSetMasterByIndex(link, master.Attrs().Index)
+}
+
+func (s *Simulated) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	if _, err := s.lookup(masterIndex); err != nil {
+		return err
+	}
+	simulated.attrs.MasterIndex = masterIndex
+	return nil
+}
+
+func (s *Simulated) LinkSetNoMaster(link netlink.Link) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	simulated.attrs.MasterIndex = 0
+	return nil
+}
+
+// LinkSetNs moves the interface, like the kernel it loses its addresses,
+// routes and master
+func (s *Simulated) LinkSetNs(link netlink.Link, target ns.NetNS) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	if s.byName(target.Path(), simulated.attrs.Name) != nil {
+		return syscall.EEXIST
+	}
+	s.forget(simulated)
+	simulated.netns = target.Path()
+	simulated.addrs = nil
+	simulated.attrs.MasterIndex = 0
+	return nil
+}
+
+func (s *Simulated) LinkAddAltName(link netlink.Link, altName string) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	if s.byName(simulated.netns, altName) != nil {
+		return syscall.EEXIST
+	}
+	simulated.altNames = append(simulated.altNames, altName)
+	return nil
+}
+
+func (s *Simulated) LinkDelAltName(link netlink.Link, altName string) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(simulated.altNames, altName) {
+		return syscall.ENOENT
+	}
+	simulated.altNames = slices.DeleteFunc(simulated.altNames, func(name string) bool { return name == altName })
+	return nil
+}
+
+func (s *Simulated) VethPeerIndex(link *netlink.Veth) (int, error) {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return 0, err
+	}
+	return simulated.peerIndex, nil
+}
+
+// AddrList returns addresses of the interface, of all interfaces in the
+// current namespace when link is nil
+func (s *Simulated) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	var links []*simulatedLink
+	if link == nil {
+		for _, simulated := range s.links {
+			if simulated.netns == netns.Current().Path() {
+				links = append(links, simulated)
+			}
+		}
+	} else {
+		simulated, err := s.lookup(link.Attrs().Index)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, simulated)
+	}
+	var addrs []netlink.Addr
+	for _, simulated := range links {
+		for _, addr := range simulated.addrs {
+			if family == netlink.FAMILY_ALL || family == addrFamily(addr.IP) {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+func (s *Simulated) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	if simulated.addrIndex(addr) >= 0 {
+		return syscall.EEXIST
+	}
+	added := *addr
+	added.LinkIndex = simulated.attrs.Index
+	simulated.addrs = append(simulated.addrs, added)
+	return nil
+}
+
+func (s *Simulated) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	simulated, err := s.lookup(link.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	index := simulated.addrIndex(addr)
+	if index < 0 {
+		return syscall.EADDRNOTAVAIL
+	}
+	simulated.addrs = slices.Delete(simulated.addrs, index, index+1)
+	return nil
+}
+
+// addrIndex returns the index of the address of the interface, -1 when it
+// has none
+func (l *simulatedLink) addrIndex(addr *netlink.Addr) int {
+	return slices.IndexFunc(l.addrs, func(existing netlink.Addr) bool {
+		return existing.IP.Equal(addr.IP)
+	})
+}
+
+// routeIndex returns the index of the route to the destination of route in
+// the current namespace, -1 when there is none
+func (s *Simulated) routeIndex(route *netlink.Route) int {
+	return slices.IndexFunc(s.routes[netns.Current().Path()], func(existing netlink.Route) bool {
+		return existing.Table == route.Table && existing.Dst.String() == route.Dst.String() &&
+			(route.LinkIndex == 0 || existing.LinkIndex == route.LinkIndex)
+	})
+}
+
+// checkLink fails when the interface of the index is not in the current
+// namespace, index 0 is no interface
+func (s *Simulated) checkLink(index int) error {
+	if index == 0 {
+		return nil
+	}
+	if _, err := s.lookup(index); err != nil {
+		return syscall.ENODEV
+	}
+	return nil
+}
+
+// RouteList returns routes of the current namespace with their family set
+// like the kernel reports them
+func (s *Simulated) RouteList(family int) ([]netlink.Route, error) {
+	var routes []netlink.Route
+	for _, route := range s.routes[netns.Current().Path()] {
+		if route.Table != 0 && route.Table != syscall.RT_TABLE_MAIN {
+			continue
+		}
+		if route.Family == 0 && route.Dst != nil {
+			route.Family = addrFamily(route.Dst.IP)
+		}
+		if family == netlink.FAMILY_ALL || family == route.Family {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+func (s *Simulated) RouteReplace(route *netlink.Route) error {
+	if err := s.checkLink(route.LinkIndex); err != nil {
+		return err
+	}
+	current := netns.Current().Path()
+	if index := s.routeIndex(route); index >= 0 {
+		s.routes[current][index] = *route
+		return nil
+	}
+	s.routes[current] = append(s.routes[current], *route)
+	return nil
+}
+
+// RouteAddEcmp adds the route as another path to its destination, it fails
+// when the route exists already
+func (s *Simulated) RouteAddEcmp(route *netlink.Route) error {
+	if err := s.checkLink(route.LinkIndex); err != nil {
+		return err
+	}
+	current := netns.Current().Path()
+	for _, existing := range s.routes[current] {
+		if existing.Table == route.Table && existing.Dst.String() == route.Dst.String() &&
+			existing.LinkIndex == route.LinkIndex && existing.Gw.Equal(route.Gw) {
+			return syscall.EEXIST
+		}
+	}
+	s.routes[current] = append(s.routes[current], *route)
+	return nil
+}
+
+func (s *Simulated) RouteDel(route *netlink.Route) error {
+	index := s.routeIndex(route)
+	if index < 0 {
+		return syscall.ESRCH
+	}
+	current := netns.Current().Path()
+	s.routes[current] = slices.Delete(s.routes[current], index, index+1)
+	return nil
+}
+
+// neighIndex returns the index of the neighbor of the interface with the IP
+// in the current namespace, -1 when there is none
+func (s *Simulated) neighIndex(neigh *netlink.Neigh) int {
+	return slices.IndexFunc(s.neighs[netns.Current().Path()], func(existing netlink.Neigh) bool {
+		return existing.LinkIndex == neigh.LinkIndex && existing.IP.Equal(neigh.IP)
+	})
+}
+
+func (s *Simulated) NeighSet(neigh *netlink.Neigh) error {
+	if _, err := s.lookup(neigh.LinkIndex); err != nil {
+		return syscall.ENODEV
+	}
+	current := netns.Current().Path()
+	if index := s.neighIndex(neigh); index >= 0 {
+		s.neighs[current][index] = *neigh
+		return nil
+	}
+	s.neighs[current] = append(s.neighs[current], *neigh)
+	return nil
+}
+
+func (s *Simulated) NeighDel(neigh *netlink.Neigh) error {
+	index := s.neighIndex(neigh)
+	if index < 0 {
+		return syscall.ENOENT
+	}
+	current := netns.Current().Path()
+	s.neighs[current] = slices.Delete(s.neighs[current], index, index+1)
+	return nil
+}
+
+func (s *Simulated) QdiscAdd(qdisc netlink.Qdisc) error {
+	simulated, err := s.lookup(qdisc.Attrs().LinkIndex)
+	if err != nil {
+		return syscall.ENODEV
+	}
+	if slices.ContainsFunc(simulated.qdiscs, func(existing netlink.Qdisc) bool {
+		return existing.Attrs().Parent == qdisc.Attrs().Parent
+	}) {
+		return syscall.EEXIST
+	}
+	simulated.qdiscs = append(simulated.qdiscs, qdisc)
+	return nil
+}
+
+// QdiscDel removes the qdisc along with its filters
+func (s *Simulated) QdiscDel(qdisc netlink.Qdisc) error {
+	simulated, err := s.lookup(qdisc.Attrs().LinkIndex)
+	if err != nil {
+		return syscall.ENODEV
+	}
+	index := slices.IndexFunc(simulated.qdiscs, func(existing netlink.Qdisc) bool {
+		return existing.Attrs().Parent == qdisc.Attrs().Parent
+	})
+	if index < 0 {
+		return syscall.ENOENT
+	}
+	handle := simulated.qdiscs[index].Attrs().Handle
+	simulated.qdiscs = slices.Delete(simulated.qdiscs, index, index+1)
+	simulated.filters = slices.DeleteFunc(simulated.filters, func(filter netlink.Filter) bool {
+		return filter.Attrs().Parent == handle
+	})
+	return nil
+}
+
+// FilterAdd adds the filter to the qdisc of the interface it is attached to
+func (s *Simulated) FilterAdd(filter netlink.Filter) error {
+	simulated, err := s.lookup(filter.Attrs().LinkIndex)
+	if err != nil {
+		return syscall.ENODEV
+	}
+	if !slices.ContainsFunc(simulated.qdiscs, func(qdisc netlink.Qdisc) bool {
+		return qdisc.Attrs().Handle == filter.Attrs().Parent
+	}) {
+		return syscall.EINVAL
+	}
+	simulated.filters = append(simulated.filters, filter)
+	return nil
+}
+
+// Sysctl reads and sets sysctls of the current namespace, unset ones are 0.
+// Like in the kernel, ones of an interface, e.g. net/ipv4/conf/eth0/forwarding,
+// only exist while the interface does.
+func (s *Simulated) Sysctl(name string, value ...string) (string, error) {
+	current := netns.Current().Path()
+	parts := strings.Split(name, "/")
+	if len(parts) == 5 && parts[0] == "net" && parts[2] == "conf" && !slices.Contains([]string{"all", "default", "lo"}, parts[3]) &&
+		s.byName(current, parts[3]) == nil {
+		return "", &os.PathError{Op: "open", Path: filepath.Join("/proc/sys", name), Err: syscall.ENOENT}
+	}
+	if s.sysctls[current] == nil {
+		s.sysctls[current] = map[string]string{}
+	}
+	if len(value) > 0 {
+		s.sysctls[current][name] = value[0]
+	}
+	if set, found := s.sysctls[current][name]; found {
+		return set, nil
+	}
+	return "0", nil
+}
+
+func (s *Simulated) SetupVeth(contVethName, hostVethName string, mtu int, contVethMac string, hostNS ns.NetNS) (net.Interface, net.Interface, error) {
+	if hostVethName == "" {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return net.Interface{}, net.Interface{}, err
+		}
+		hostVethName = "veth" + hex.EncodeToString(suffix)
+	}
+	if mtu == 0 {
+		mtu = 1500
+	}
+	contMac, err := randomMac()
+	if err != nil {
+		return net.Interface{}, net.Interface{}, err
+	}
+	if contVethMac != "" {
+		if contMac, err = net.ParseMAC(contVethMac); err != nil {
+			return net.Interface{}, net.Interface{}, err
+		}
+	}
+	hostMac, err := randomMac()
+	if err != nil {
+		return net.Interface{}, net.Interface{}, err
+	}
+	contNetns := netns.Current().Path()
+	if s.byName(contNetns, contVethName) != nil {
+		return net.Interface{}, net.Interface{}, fmt.Errorf("container veth name provided (%v) already exists", contVethName)
+	}
+	if s.byName(hostNS.Path(), hostVethName) != nil {
+		return net.Interface{}, net.Interface{}, fmt.Errorf("failed to move veth to host netns: %v", syscall.EEXIST)
+	}
+
+	cont := &simulatedLink{netns: contNetns, linkType: "veth", attrs: netlink.LinkAttrs{Name: contVethName, MTU: mtu, HardwareAddr: contMac}}
+	host := &simulatedLink{netns: hostNS.Path(), linkType: "veth", attrs: netlink.LinkAttrs{Name: hostVethName, MTU: mtu, HardwareAddr: hostMac}}
+	s.lastIndex++
+	cont.attrs.Index = s.lastIndex
+	s.lastIndex++
+	host.attrs.Index = s.lastIndex
+	cont.peerIndex, host.peerIndex = host.attrs.Index, cont.attrs.Index
+	s.links[cont.attrs.Index] = cont
+	s.links[host.attrs.Index] = host
+	return toInterface(host), toInterface(cont), nil
+}
+
+func (s *Simulated) DelLinkByName(name string) error {
+	link, err := s.LinkByName(name)
+	if err != nil {
+		if IsNotFound(err) {
+			return ip.ErrLinkNotFound
+		}
+		return fmt.Errorf("failed to lookup %q: %v", name, err)
+	}
+	if err := s.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete %q: %v", name, err)
+	}
+	return nil
+}
+
+func toInterface(link *simulatedLink) net.Interface {
+	return net.Interface{Index: link.attrs.Index, MTU: link.attrs.MTU, Name: link.attrs.Name, HardwareAddr: link.attrs.HardwareAddr, Flags: link.attrs.Flags}
+}
+
+// addrFamily returns the netlink family of the IP
+func addrFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// randomMac returns a random locally administered unicast address
+func randomMac() (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return nil, err
+	}
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac, nil
+}
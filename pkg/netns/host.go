@@ -0,0 +1,24 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !simulation
+
+package netns
+
+import "github.com/containernetworking/plugins/pkg/ns"
+
+// getNS opens the namespace of the kernel at path
+func getNS(path string) (ns.NetNS, error) {
+	return ns.GetNS(path)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netns runs operations in network namespaces of containers with
+// bounded retries, entering a namespace may transiently fail while the
+// container is being torn down
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+var (
+	// Retries is how many times entering a busy namespace is retried
+	Retries = 5
+	// Interval is the delay between the retries
+	Interval = 100 * time.Millisecond
+)
+
+// ErrBusy is returned when the namespace stays busy after all retries
+var ErrBusy = errors.New("network namespace is busy")
+
+// IsGone returns true when the namespace doesn't exist anymore, e.g. its
+// container was already removed
+func IsGone(err error) bool {
+	var notExist ns.NSPathNotExistErr
+	return errors.As(err, &notExist)
+}
+
+// isTransient returns true when the failure of opening or entering the
+// namespace may succeed on a retry, errors of ns don't wrap the errno
+func isTransient(err error) bool {
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ESRCH) {
+		return true
+	}
+	msg := err.Error()
+	return strings.HasSuffix(msg, syscall.EBUSY.Error()) || strings.HasSuffix(msg, syscall.ESRCH.Error())
+}
+
+// retry runs op until it succeeds, fails permanently or the retries are
+// exhausted
+func retry(op func() error, retriable func(error) bool) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = op(); err == nil || !retriable(err) {
+			return err
+		}
+		if attempt >= Retries {
+			return fmt.Errorf("%w: %v", ErrBusy, err)
+		}
+		time.Sleep(Interval)
+	}
+}
+
+// Get opens the namespace at path
+func Get(path string) (ns.NetNS, error) {
+	var netns ns.NetNS
+	err := retry(func() error {
+		var err error
+		netns, err = getNS(path)
+		return err
+	}, isTransient)
+	return netns, err
+}
+
+// Do runs toRun in the namespace, only entering the namespace is retried,
+// toRun runs at most once and its error is returned as is
+func Do(netns ns.NetNS, toRun func(ns.NetNS) error) error {
+	entered := false
+	return retry(func() error {
+		return netns.Do(func(hostNS ns.NetNS) error {
+			entered = true
+			return toRun(hostNS)
+		})
+	}, func(err error) bool {
+		return !entered && isTransient(err)
+	})
+}
+
+// WithPath opens the namespace at path and runs toRun in it
+func WithPath(path string, toRun func(ns.NetNS) error) error {
+	netns, err := Get(path)
+	if err != nil {
+		return err
+	}
+	defer netns.Close()
+	return Do(netns, toRun)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeNS fails to be entered as many times as there are errors in enterErrs
+type fakeNS struct {
+	ns.NetNS
+	enterErrs []error
+	entered   int
+}
+
+func (f *fakeNS) Do(toRun func(ns.NetNS) error) error {
+	if len(f.enterErrs) > 0 {
+		err := f.enterErrs[0]
+		f.enterErrs = f.enterErrs[1:]
+		return err
+	}
+	f.entered++
+	return toRun(nil)
+}
+
+var _ = Describe("Netns", func() {
+	busy := fmt.Errorf("error switching to ns /var/run/netns/test: device or resource busy")
+	BeforeEach(func() {
+		retries, interval := Retries, Interval
+		Retries, Interval = 2, time.Millisecond
+		DeferCleanup(func() { Retries, Interval = retries, interval })
+	})
+	It("should retry entering a busy namespace", func() {
+		netns := &fakeNS{enterErrs: []error{busy, busy}}
+		Expect(Do(netns, func(ns.NetNS) error { return nil })).To(Succeed())
+		Expect(netns.entered).To(Equal(1))
+	})
+	It("should give up when the namespace stays busy", func() {
+		netns := &fakeNS{enterErrs: []error{busy, busy, busy}}
+		err := Do(netns, func(ns.NetNS) error { return nil })
+		Expect(errors.Is(err, ErrBusy)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("device or resource busy")))
+		Expect(netns.entered).To(Equal(0))
+	})
+	It("should not retry permanent failures", func() {
+		netns := &fakeNS{enterErrs: []error{errors.New("error switching to ns /var/run/netns/test: invalid argument"), busy}}
+		err := Do(netns, func(ns.NetNS) error { return nil })
+		Expect(err).To(MatchError("error switching to ns /var/run/netns/test: invalid argument"))
+		Expect(errors.Is(err, ErrBusy)).To(BeFalse())
+	})
+	It("should run the operation once and return its error as is", func() {
+		opErr := errors.New("no such process")
+		netns := &fakeNS{}
+		Expect(Do(netns, func(ns.NetNS) error { return opErr })).To(BeIdenticalTo(opErr))
+		Expect(netns.entered).To(Equal(1))
+	})
+	It("should classify a missing namespace as gone", func() {
+		err := WithPath("/nonexistent/netns", func(ns.NetNS) error { return nil })
+		Expect(IsGone(err)).To(BeTrue())
+		Expect(IsGone(fmt.Errorf("failed: %w", err))).To(BeTrue())
+		Expect(IsGone(busy)).To(BeFalse())
+	})
+})
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build simulation
+
+package netns
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// Built with the simulation tag, namespaces only exist in memory. Do switches
+// the namespace seen by operations of package netif instead of the one of the
+// thread, so the plugin can be tested without root. Simulated namespaces are
+// not safe for concurrent use.
+
+// SimulatedHostPath is the path of the simulated namespace of the host
+const SimulatedHostPath = "simulated-host"
+
+var (
+	simulatedHost = &simulatedNS{path: SimulatedHostPath}
+	simulated     = map[string]*simulatedNS{}
+	current       = simulatedHost
+)
+
+// goneError is the error of a simulated namespace which doesn't exist, it
+// unwraps to the one of ns, so IsGone recognizes it
+type goneError struct {
+	path string
+}
+
+func (e goneError) Error() string {
+	return fmt.Sprintf("simulated namespace %q does not exist", e.path)
+}
+
+func (e goneError) Unwrap() error {
+	return ns.NSPathNotExistErr{}
+}
+
+type simulatedNS struct {
+	path string
+}
+
+func (n *simulatedNS) Do(toRun func(ns.NetNS) error) error {
+	if n != simulatedHost && simulated[n.path] != n {
+		return goneError{n.path}
+	}
+	previous := current
+	current = n
+	defer func() { current = previous }()
+	return toRun(previous)
+}
+
+func (n *simulatedNS) Set() error {
+	current = n
+	return nil
+}
+
+func (n *simulatedNS) Path() string {
+	return n.path
+}
+
+func (n *simulatedNS) Fd() uintptr {
+	return 0
+}
+
+func (n *simulatedNS) Close() error {
+	return nil
+}
+
+// getNS returns the simulated namespace at path
+func getNS(path string) (ns.NetNS, error) {
+	if path == SimulatedHostPath {
+		return simulatedHost, nil
+	}
+	if netns, found := simulated[path]; found {
+		return netns, nil
+	}
+	return nil, goneError{path}
+}
+
+// NewSimulated creates a simulated namespace at path
+func NewSimulated(path string) ns.NetNS {
+	netns := &simulatedNS{path: path}
+	simulated[path] = netns
+	return netns
+}
+
+// DeleteSimulated removes the simulated namespace at path, e.g. when its
+// container is gone
+func DeleteSimulated(path string) {
+	delete(simulated, path)
+}
+
+// Current returns the simulated namespace the caller is in
+func Current() ns.NetNS {
+	return current
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package openflow manages flows of ovs-cni on OVS bridges. Flows of a
+// feature are tagged by a cookie, so they can be removed together without
+// touching flows of others. Flows are programmed through ovs-ofctl, which
+// talks OpenFlow to ovs-vswitchd. Flow changes are sent as OpenFlow bundles,
+// which need OpenFlow 1.4 or later to be enabled in protocols of the bridge.
+package openflow
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// flowCountPattern matches the flow count in output of dump-aggregate
+var flowCountPattern = regexp.MustCompile(`flow_count=(\d+)`)
+
+// Flow is an OpenFlow flow, Match and Actions use ovs-ofctl syntax, e.g.
+// "ip,nw_dst=10.0.0.1" and "output:veth1"
+type Flow struct {
+	Cookie   uint64
+	Table    uint8
+	Priority uint16
+	Match    string
+	Actions  string
+}
+
+// String returns the flow in ovs-ofctl syntax
+func (f Flow) String() string {
+	fields := []string{
+		fmt.Sprintf("cookie=%#x", f.Cookie),
+		fmt.Sprintf("table=%d", f.Table),
+		fmt.Sprintf("priority=%d", f.Priority),
+	}
+	if f.Match != "" {
+		fields = append(fields, f.Match)
+	}
+	return strings.Join(fields, ",") + ",actions=" + f.Actions
+}
+
+// runner runs ovs-ofctl with the given arguments and standard input
+type runner func(stdin string, args ...string) ([]byte, error)
+
+func runOfctl(stdin string, args ...string) ([]byte, error) {
+	cmd := exec.Command("ovs-ofctl", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	return cmd.CombinedOutput()
+}
+
+// Client manages flows of a bridge
+type Client struct {
+	bridge string
+	run    runner
+}
+
+// NewClient returns a client managing flows of the bridge
+func NewClient(bridge string) *Client {
+	return &Client{bridge: bridge, run: runOfctl}
+}
+
+func (c *Client) ofctl(stdin, command string, args ...string) (string, error) {
+	return c.ofctlWithOptions(nil, stdin, command, args...)
+}
+
+// bundle runs a flow command of ovs-ofctl in an OpenFlow bundle, so that
+// either all or none of the changes are applied
+func (c *Client) bundle(stdin, command string, args ...string) (string, error) {
+	return c.ofctlWithOptions([]string{"--bundle"}, stdin, command, args...)
+}
+
+func (c *Client) ofctlWithOptions(options []string, stdin, command string, args ...string) (string, error) {
+	args = append(append(options, command, c.bridge), args...)
+	output, err := c.run(stdin, args...)
+	if err != nil {
+		return "", fmt.Errorf("ovs-ofctl %s %s failed: %v: %s", command, c.bridge, err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// AddFlows adds the flows atomically in a single OpenFlow bundle, flows with
+// the same table, match and priority as an existing flow replace it
+func (c *Client) AddFlows(flows []Flow) error {
+	if len(flows) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(flows))
+	for _, flow := range flows {
+		lines = append(lines, flow.String())
+	}
+	_, err := c.bundle(strings.Join(lines, "\n"), "add-flows", "-")
+	return err
+}
+
+// DeleteFlows removes all flows with the cookie
+func (c *Client) DeleteFlows(cookie uint64) error {
+	_, err := c.bundle("", "del-flows", fmt.Sprintf("cookie=%#x/-1", cookie))
+	return err
+}
+
+// SetPortFlood enables or disables flooding of packets to the port by the
+// NORMAL action
+func (c *Client) SetPortFlood(port string, flood bool) error {
+	action := "flood"
+	if !flood {
+		action = "no-flood"
+	}
+	_, err := c.ofctl("", "mod-port", port, action)
+	return err
+}
+
+// FlowCount returns the number of flows of the bridge in all tables
+func (c *Client) FlowCount() (int, error) {
+	output, err := c.ofctl("", "dump-aggregate")
+	if err != nil {
+		return 0, err
+	}
+	match := flowCountPattern.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("unexpected output of ovs-ofctl dump-aggregate: %s", strings.TrimSpace(output))
+	}
+	return strconv.Atoi(match[1])
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openflow
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenFlow", func() {
+	var (
+		client *Client
+		calls  [][]string
+		stdins []string
+	)
+	BeforeEach(func() {
+		calls, stdins = nil, nil
+		client = NewClient("br1")
+		client.run = func(stdin string, args ...string) ([]byte, error) {
+			calls = append(calls, args)
+			stdins = append(stdins, stdin)
+			return nil, nil
+		}
+	})
+	It("should format flows in ovs-ofctl syntax", func() {
+		flow := Flow{Cookie: 0xab, Priority: 100, Match: "in_port=veth1,ip", Actions: "output:LOCAL"}
+		Expect(flow.String()).To(Equal("cookie=0xab,table=0,priority=100,in_port=veth1,ip,actions=output:LOCAL"))
+		Expect(Flow{Table: 1, Actions: "drop"}.String()).To(Equal("cookie=0x0,table=1,priority=0,actions=drop"))
+	})
+	It("should add all flows at once", func() {
+		Expect(client.AddFlows([]Flow{
+			{Cookie: 1, Priority: 200, Match: "ip", Actions: "drop"},
+			{Cookie: 1, Priority: 100, Actions: "normal"},
+		})).To(Succeed())
+		Expect(calls).To(Equal([][]string{{"--bundle", "add-flows", "br1", "-"}}))
+		Expect(stdins[0]).To(Equal("cookie=0x1,table=0,priority=200,ip,actions=drop\ncookie=0x1,table=0,priority=100,actions=normal"))
+		Expect(client.AddFlows(nil)).To(Succeed())
+		Expect(calls).To(HaveLen(1))
+	})
+	It("should delete flows by cookie", func() {
+		Expect(client.DeleteFlows(0xdead)).To(Succeed())
+		Expect(calls).To(Equal([][]string{{"--bundle", "del-flows", "br1", "cookie=0xdead/-1"}}))
+	})
+	It("should change flooding of the port", func() {
+		Expect(client.SetPortFlood("veth1", false)).To(Succeed())
+		Expect(client.SetPortFlood("veth1", true)).To(Succeed())
+		Expect(calls).To(Equal([][]string{{"mod-port", "br1", "veth1", "no-flood"}, {"mod-port", "br1", "veth1", "flood"}}))
+	})
+	It("should count flows of the bridge", func() {
+		client.run = func(stdin string, args ...string) ([]byte, error) {
+			calls = append(calls, args)
+			return []byte("NXST_AGGREGATE reply (xid=0x4): packet_count=12 byte_count=840 flow_count=3\n"), nil
+		}
+		Expect(client.FlowCount()).To(Equal(3))
+		Expect(calls).To(Equal([][]string{{"dump-aggregate", "br1"}}))
+	})
+	It("should fail to count flows on unexpected output", func() {
+		client.run = func(stdin string, args ...string) ([]byte, error) {
+			return []byte("garbage\n"), nil
+		}
+		_, err := client.FlowCount()
+		Expect(err).To(MatchError("unexpected output of ovs-ofctl dump-aggregate: garbage"))
+	})
+	It("should report output of failed commands", func() {
+		client.run = func(stdin string, args ...string) ([]byte, error) {
+			return []byte("ovs-ofctl: br1 is not a bridge or a socket\n"), errors.New("exit status 1")
+		}
+		Expect(client.DeleteFlows(1)).To(MatchError("ovs-ofctl del-flows br1 failed: exit status 1: ovs-ofctl: br1 is not a bridge or a socket"))
+	})
+})
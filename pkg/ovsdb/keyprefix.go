@@ -0,0 +1,221 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"strings"
+
+	"github.com/ovn-org/libovsdb/ovsdb"
+)
+
+const externalIDsColumn = "external_ids"
+
+// prefixedKeys are the external_ids keys owned by ovs-cni, KeyPrefix is only
+// prepended to these. Keys read by other components, e.g. iface-id read by
+// ovn-controller, are written as they are.
+var prefixedKeys = map[string]bool{
+	"owner":             true,
+	"contPodUid":        true,
+	"contNetns":         true,
+	"contIface":         true,
+	"contNetwork":       true,
+	CreationTimeKey:     true,
+	VersionKey:          true,
+	QuarantineExpiryKey: true,
+	CaptureExpiryKey:    true,
+	ContainerIDKey:      true,
+	MirrorConsumerKey:   true,
+	VlanTranslationKey:  true,
+	PortQoSKey:          true,
+	BridgeMaxPortsKey:   true,
+}
+
+// withKeyPrefix returns the operations with KeyPrefix prepended to keys of
+// external_ids they write or match. Keys of rows created before the prefix
+// was configured are still read: selects with conditions on external_ids
+// are repeated with the original conditions, appended after the other
+// operations so indexes of results don't change. Other operations only
+// match rows with prefixed keys, so they are applied once.
+func (ovsd *OvsDriver) withKeyPrefix(ops []ovsdb.Operation) []ovsdb.Operation {
+	prefixed := make([]ovsdb.Operation, 0, len(ops))
+	var legacy []ovsdb.Operation
+	for _, op := range ops {
+		op.Row = ovsd.prefixRow(op.Row)
+		mutations := make([]ovsdb.Mutation, 0, len(op.Mutations))
+		for _, mutation := range op.Mutations {
+			if mutation.Column == externalIDsColumn {
+				mutation.Value = ovsd.prefixMutationValue(mutation.Mutator, mutation.Value)
+			}
+			mutations = append(mutations, mutation)
+		}
+		op.Mutations = mutations
+
+		legacyOp := op
+		matchesKeys := false
+		isSelect := op.Op == ovsdb.OperationSelect
+		where := make([]ovsdb.Condition, 0, len(op.Where))
+		for _, condition := range op.Where {
+			if condition.Column == externalIDsColumn {
+				if value, ok := condition.Value.(ovsdb.OvsMap); ok {
+					condition.Value = ovsd.prefixMap(value)
+					matchesKeys = matchesKeys || hasPrefixedKey(value)
+				}
+			}
+			where = append(where, condition)
+		}
+		op.Where = where
+		if matchesKeys && isSelect && len(op.Columns) > 0 {
+			// rows of both operations are merged by their UUID
+			op.Columns = append(append([]string{}, op.Columns...), "_uuid")
+		}
+		prefixed = append(prefixed, op)
+		if matchesKeys && isSelect {
+			legacyOp.Columns = op.Columns
+			legacy = append(legacy, legacyOp)
+		}
+	}
+	return append(prefixed, legacy...)
+}
+
+// withoutKeyPrefix merges rows of selects repeated by withKeyPrefix into
+// results of the original selects and strips KeyPrefix from keys of
+// external_ids of returned rows
+func (ovsd *OvsDriver) withoutKeyPrefix(ops []ovsdb.Operation, reply []ovsdb.OperationResult) []ovsdb.OperationResult {
+	results := reply[:len(ops)]
+	legacyIndex := len(ops)
+	for i := range ops {
+		if legacyIndex >= len(reply) || !isRepeated(ops[i]) {
+			continue
+		}
+		legacy := reply[legacyIndex]
+		legacyIndex++
+		seen := map[interface{}]bool{}
+		for _, row := range results[i].Rows {
+			seen[row["_uuid"]] = true
+		}
+		for _, row := range legacy.Rows {
+			if uuid, ok := row["_uuid"]; !ok || !seen[uuid] {
+				results[i].Rows = append(results[i].Rows, row)
+			}
+		}
+	}
+	for i := range results {
+		for _, row := range results[i].Rows {
+			if externalIDs, ok := row[externalIDsColumn].(ovsdb.OvsMap); ok {
+				row[externalIDsColumn] = ovsd.stripMap(externalIDs)
+			}
+		}
+	}
+	return results
+}
+
+// isRepeated returns whether withKeyPrefix repeats the operation
+func isRepeated(op ovsdb.Operation) bool {
+	if op.Op != ovsdb.OperationSelect {
+		return false
+	}
+	for _, condition := range op.Where {
+		if value, ok := condition.Value.(ovsdb.OvsMap); ok && condition.Column == externalIDsColumn && hasPrefixedKey(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixedKey(value ovsdb.OvsMap) bool {
+	for key := range value.GoMap {
+		if name, ok := key.(string); ok && prefixedKeys[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (ovsd *OvsDriver) prefixRow(row ovsdb.Row) ovsdb.Row {
+	externalIDs, ok := row[externalIDsColumn].(ovsdb.OvsMap)
+	if !ok {
+		return row
+	}
+	prefixed := make(ovsdb.Row, len(row))
+	for column, value := range row {
+		prefixed[column] = value
+	}
+	prefixed[externalIDsColumn] = ovsd.prefixMap(externalIDs)
+	return prefixed
+}
+
+// prefixMutationValue prefixes keys inserted into external_ids, keys of
+// ovs-cni are deleted both with and without the prefix
+func (ovsd *OvsDriver) prefixMutationValue(mutator ovsdb.Mutator, value interface{}) interface{} {
+	switch value := value.(type) {
+	case ovsdb.OvsMap:
+		if mutator == ovsdb.MutateOperationDelete {
+			merged := ovsd.prefixMap(value)
+			for key, v := range value.GoMap {
+				merged.GoMap[key] = v
+			}
+			return merged
+		}
+		return ovsd.prefixMap(value)
+	case ovsdb.OvsSet:
+		keys := make([]interface{}, 0, 2*len(value.GoSet))
+		for _, key := range value.GoSet {
+			if name, ok := key.(string); ok && prefixedKeys[name] {
+				keys = append(keys, ovsd.KeyPrefix+name)
+			}
+			keys = append(keys, key)
+		}
+		return ovsdb.OvsSet{GoSet: keys}
+	}
+	return value
+}
+
+func (ovsd *OvsDriver) prefixMap(value ovsdb.OvsMap) ovsdb.OvsMap {
+	prefixed := ovsdb.OvsMap{GoMap: make(map[interface{}]interface{}, len(value.GoMap))}
+	for key, v := range value.GoMap {
+		if name, ok := key.(string); ok && prefixedKeys[name] {
+			key = ovsd.KeyPrefix + name
+		}
+		prefixed.GoMap[key] = v
+	}
+	return prefixed
+}
+
+// stripMap returns external_ids with KeyPrefix stripped from keys of
+// ovs-cni, they take precedence over the same keys without the prefix
+func (ovsd *OvsDriver) stripMap(value ovsdb.OvsMap) ovsdb.OvsMap {
+	stripped := ovsdb.OvsMap{GoMap: make(map[interface{}]interface{}, len(value.GoMap))}
+	for key, v := range value.GoMap {
+		if _, ok := ovsd.ownKey(key); !ok {
+			stripped.GoMap[key] = v
+		}
+	}
+	for key, v := range value.GoMap {
+		if name, ok := ovsd.ownKey(key); ok {
+			stripped.GoMap[name] = v
+		}
+	}
+	return stripped
+}
+
+// ownKey returns the key of ovs-cni without KeyPrefix, if the key is one
+func (ovsd *OvsDriver) ownKey(key interface{}) (string, bool) {
+	name, ok := key.(string)
+	if !ok || !strings.HasPrefix(name, ovsd.KeyPrefix) {
+		return "", false
+	}
+	name = strings.TrimPrefix(name, ovsd.KeyPrefix)
+	return name, prefixedKeys[name]
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"github.com/ovn-org/libovsdb/ovsdb"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("external_ids key prefix", func() {
+	ovsd := &OvsDriver{KeyPrefix: "example.com/"}
+	ovsMap := func(m map[string]string) ovsdb.OvsMap {
+		value, err := ovsdb.NewOvsMap(m)
+		Expect(err).NotTo(HaveOccurred())
+		return value
+	}
+
+	It("should prefix written keys and repeat operations matching keys without it", func() {
+		owner := ovsMap(map[string]string{"owner": ovsPortOwner})
+		ops := []ovsdb.Operation{
+			{Op: "insert", Table: "Port", Row: ovsdb.Row{"name": "veth1", "external_ids": owner}},
+			{Op: "select", Table: "Port", Columns: []string{"name"}, Where: []ovsdb.Condition{ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, owner)}},
+		}
+		prefixed := ovsd.withKeyPrefix(ops)
+		Expect(prefixed).To(HaveLen(3))
+		Expect(prefixed[0].Row["external_ids"]).To(Equal(ovsMap(map[string]string{"example.com/owner": ovsPortOwner})))
+		Expect(prefixed[1].Where[0].Value).To(Equal(ovsMap(map[string]string{"example.com/owner": ovsPortOwner})))
+		Expect(prefixed[1].Columns).To(Equal([]string{"name", "_uuid"}))
+		Expect(prefixed[2].Where[0].Value).To(Equal(owner))
+		Expect(ops[0].Row["external_ids"]).To(Equal(owner), "original operations must not change")
+	})
+
+	It("should leave keys of other components unprefixed", func() {
+		externalIDs := ovsMap(map[string]string{"iface-id": "ns_pod", "owner": ovsPortOwner})
+		ops := []ovsdb.Operation{{Op: "insert", Table: "Interface", Row: ovsdb.Row{"name": "veth1", "external_ids": externalIDs}}}
+		prefixed := ovsd.withKeyPrefix(ops)
+		Expect(prefixed[0].Row["external_ids"]).To(Equal(ovsMap(map[string]string{"iface-id": "ns_pod", "example.com/owner": ovsPortOwner})))
+		Expect(ovsd.stripMap(ovsMap(map[string]string{"example.com/iface-id": "other", "iface-id": "ns_pod"}))).
+			To(Equal(ovsMap(map[string]string{"example.com/iface-id": "other", "iface-id": "ns_pod"})))
+	})
+
+	It("should only repeat selects", func() {
+		owner := ovsMap(map[string]string{"owner": ovsPortOwner})
+		ops := []ovsdb.Operation{
+			{Op: "delete", Table: "QoS", Where: []ovsdb.Condition{ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, owner)}},
+			{Op: "select", Table: "Port", Where: []ovsdb.Condition{ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, ovsMap(map[string]string{"iface-id": "ns_pod"}))}},
+		}
+		Expect(ovsd.withKeyPrefix(ops)).To(HaveLen(2))
+	})
+
+	It("should apply mutations once to rows with keys with and without the prefix", func() {
+		driver, fake := newFakeDriver()
+		Expect(driver.CreatePort(PortOptions{Name: "veth1", ContNetns: "/var/run/netns/pod", ContIface: "net1"})).To(Succeed())
+		name := ovsdb.NewCondition("name", ovsdb.ConditionEqual, "veth1")
+		_, err := fake.Transact(ovsdb.Operation{
+			Op:        "mutate",
+			Table:     "Interface",
+			Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("external_ids", ovsdb.MutateOperationInsert, ovsMap(map[string]string{"owner": ovsPortOwner, "example.com/owner": ovsPortOwner, VlanTranslationKey: "true", "example.com/" + VlanTranslationKey: "true"}))},
+			Where:     []ovsdb.Condition{name},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		driver.KeyPrefix = "example.com/"
+		keys, err := ovsdb.NewOvsSet(VlanTranslationKey)
+		Expect(err).NotTo(HaveOccurred())
+		result, err := driver.ovsdbTransact([]ovsdb.Operation{{
+			Op:    "mutate",
+			Table: "Interface",
+			Mutations: []ovsdb.Mutation{
+				*ovsdb.NewMutation("ingress_policing_rate", ovsdb.MutateOperationAdd, 100),
+				*ovsdb.NewMutation("external_ids", ovsdb.MutateOperationDelete, keys),
+			},
+			Where: []ovsdb.Condition{name, ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, ovsMap(map[string]string{"owner": ovsPortOwner}))},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].Count).To(Equal(1))
+		row := rowsOf(fake, "Interface", name)[0]
+		Expect(row["ingress_policing_rate"]).To(BeEquivalentTo(100))
+		externalIDs, err := getExternalIDs(row)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(externalIDs).NotTo(HaveKey(VlanTranslationKey))
+		Expect(externalIDs).NotTo(HaveKey("example.com/" + VlanTranslationKey))
+	})
+
+	It("should delete keys with and without the prefix", func() {
+		keys, err := ovsdb.NewOvsSet(QuarantineExpiryKey)
+		Expect(err).NotTo(HaveOccurred())
+		ops := []ovsdb.Operation{{Op: "mutate", Table: "Port", Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("external_ids", ovsdb.MutateOperationDelete, keys)}}}
+		prefixed := ovsd.withKeyPrefix(ops)
+		Expect(prefixed).To(HaveLen(1))
+		Expect(prefixed[0].Mutations[0].Value).To(Equal(ovsdb.OvsSet{GoSet: []interface{}{"example.com/" + QuarantineExpiryKey, QuarantineExpiryKey}}))
+	})
+
+	It("should merge rows of repeated operations and strip the prefix", func() {
+		owner := ovsMap(map[string]string{"owner": ovsPortOwner})
+		ops := []ovsdb.Operation{{Op: "select", Table: "Port", Columns: []string{"name"}, Where: []ovsdb.Condition{ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, owner)}}}
+		both := ovsdb.UUID{GoUUID: "both"}
+		reply := []ovsdb.OperationResult{
+			{Rows: []ovsdb.Row{{"_uuid": both, "name": "veth1", "external_ids": ovsMap(map[string]string{"example.com/owner": ovsPortOwner, "owner": "other"})}}},
+			{Rows: []ovsdb.Row{
+				{"_uuid": both, "name": "veth1", "external_ids": ovsMap(map[string]string{"example.com/owner": ovsPortOwner, "owner": "other"})},
+				{"_uuid": ovsdb.UUID{GoUUID: "legacy"}, "name": "veth2", "external_ids": owner},
+			}},
+		}
+		results := ovsd.withoutKeyPrefix(ops, reply)
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Rows).To(HaveLen(2))
+		for _, row := range results[0].Rows {
+			externalIDs, err := getExternalIDs(row)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(externalIDs).To(Equal(map[string]string{"owner": ovsPortOwner}))
+		}
+	})
+})
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ovn-org/libovsdb/ovsdb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SlowTransactionThreshold is the duration above which OVSDB transactions
+// are logged together with their operations
+var SlowTransactionThreshold = time.Second
+
+var (
+	transactionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovs_cni_ovsdb_transactions_total",
+		Help: "Number of OVSDB transactions by result",
+	}, []string{"result"})
+	slowTransactionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ovs_cni_ovsdb_slow_transactions_total",
+		Help: "Number of OVSDB transactions slower than the slow transaction threshold",
+	})
+	transactionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ovs_cni_ovsdb_transaction_duration_seconds",
+		Help:    "Duration of OVSDB transactions",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+)
+
+// RegisterMetrics registers metrics of OVSDB transactions of the process
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{transactionsTotal, slowTransactionsTotal, transactionDuration} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeTransaction updates metrics of the transaction and logs it when it
+// was slow
+func observeTransaction(ops []ovsdb.Operation, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	transactionsTotal.WithLabelValues(result).Inc()
+	transactionDuration.Observe(duration.Seconds())
+	if SlowTransactionThreshold > 0 && duration >= SlowTransactionThreshold {
+		slowTransactionsTotal.Inc()
+		log.Printf("Slow OVSDB transaction took %v (%s): %s", duration, result, operationSummary(ops))
+	}
+}
+
+// operationSummary describes operations of a transaction, e.g.
+// "insert Interface, insert Port, mutate Bridge"
+func operationSummary(ops []ovsdb.Operation) string {
+	summary := make([]string, 0, len(ops))
+	for _, op := range ops {
+		summary = append(summary, op.Op+" "+op.Table)
+	}
+	return strings.Join(summary, ", ")
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ovn-org/libovsdb/ovsdb"
+)
+
+// ofportAllocationAttempts limits retries of allocation of an ofport from the
+// range, when ports of the bridge keep changing concurrently
+const ofportAllocationAttempts = 5
+
+// errWaitTimedOut is returned when a wait operation of a transaction is not
+// satisfied, i.e. the rows changed since they were read
+var errWaitTimedOut = errors.New("rows changed concurrently")
+
+// allocateOfport returns the first ofport of the range which is neither used
+// nor requested by an interface of the bridge, together with an operation
+// failing the transaction when ports of the bridge change in the meantime
+func (ovsd *OvsBridgeDriver) allocateOfport() (uint, *ovsdb.Operation, error) {
+	ports, used, err := ovsd.bridgeOfports()
+	if err != nil {
+		return 0, nil, err
+	}
+	ofport, found := firstFreeOfport(ovsd.OfportRangeMin, ovsd.OfportRangeMax, used)
+	if !found {
+		return 0, nil, fmt.Errorf("no free ofport in range %d-%d of bridge %s", ovsd.OfportRangeMin, ovsd.OfportRangeMax, ovsd.OvsBridgeName)
+	}
+	timeout := 0
+	waitOp := &ovsdb.Operation{
+		Op:      "wait",
+		Table:   bridgeTable,
+		Timeout: &timeout,
+		Where:   []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, ovsd.OvsBridgeName)},
+		Columns: []string{"ports"},
+		Until:   "==",
+		Rows:    []ovsdb.Row{{"ports": ports}},
+	}
+	return ofport, waitOp, nil
+}
+
+// bridgeOfports returns the ports column of the bridge as read and the
+// ofports and ofport requests of its interfaces
+func (ovsd *OvsBridgeDriver) bridgeOfports() (interface{}, map[uint]bool, error) {
+	selectOps := []ovsdb.Operation{
+		{
+			Op:      "select",
+			Table:   bridgeTable,
+			Columns: []string{"ports"},
+			Where:   []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, ovsd.OvsBridgeName)},
+		},
+		{
+			Op:      "select",
+			Table:   portTable,
+			Columns: []string{"_uuid", "interfaces"},
+		},
+		{
+			Op:      "select",
+			Table:   interfaceTable,
+			Columns: []string{"_uuid", "ofport", "ofport_request"},
+		},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(selectOps)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(transactionResult) != len(selectOps) || len(transactionResult[0].Rows) != 1 {
+		return nil, nil, fmt.Errorf("failed to find bridge %s", ovsd.OvsBridgeName)
+	}
+	ports := transactionResult[0].Rows[0]["ports"]
+	bridgePorts, err := convertToArray(ports)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot convert ports to an array error: %v", err)
+	}
+	onBridge := make(map[ovsdb.UUID]bool, len(bridgePorts))
+	for _, port := range bridgePorts {
+		onBridge[port.(ovsdb.UUID)] = true
+	}
+
+	bridgeIntfs := map[ovsdb.UUID]bool{}
+	for _, port := range transactionResult[1].Rows {
+		if !onBridge[port["_uuid"].(ovsdb.UUID)] {
+			continue
+		}
+		intfs, err := convertToArray(port["interfaces"])
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot convert interfaces to an array error: %v", err)
+		}
+		for _, intf := range intfs {
+			bridgeIntfs[intf.(ovsdb.UUID)] = true
+		}
+	}
+
+	used := map[uint]bool{}
+	for _, intf := range transactionResult[2].Rows {
+		if !bridgeIntfs[intf["_uuid"].(ovsdb.UUID)] {
+			continue
+		}
+		for _, column := range []string{"ofport", "ofport_request"} {
+			if ofport, ok := ofportValue(intf[column]); ok {
+				used[ofport] = true
+			}
+		}
+	}
+	return ports, used, nil
+}
+
+// ofportValue returns value of an optional integer column, ofport of an
+// interface which failed to be added is -1
+func ofportValue(value interface{}) (uint, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v >= 1 {
+			return uint(v), true
+		}
+	case int:
+		if v >= 1 {
+			return uint(v), true
+		}
+	}
+	return 0, false
+}
+
+// firstFreeOfport returns the lowest ofport of the range which is not used
+func firstFreeOfport(min, max uint, used map[uint]bool) (uint, bool) {
+	for ofport := min; ofport <= max; ofport++ {
+		if !used[ofport] {
+			return ofport, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"github.com/ovn-org/libovsdb/ovsdb"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ofport range", func() {
+	It("should allocate the first free ofport of the range", func() {
+		ofport, found := firstFreeOfport(1000, 2000, map[uint]bool{})
+		Expect(found).To(BeTrue())
+		Expect(ofport).To(Equal(uint(1000)))
+		ofport, found = firstFreeOfport(1000, 2000, map[uint]bool{1: true, 1000: true, 1001: true, 1003: true})
+		Expect(found).To(BeTrue())
+		Expect(ofport).To(Equal(uint(1002)))
+	})
+	It("should report an exhausted range", func() {
+		_, found := firstFreeOfport(1000, 1001, map[uint]bool{1000: true, 1001: true})
+		Expect(found).To(BeFalse())
+	})
+	It("should read ofport columns", func() {
+		ofport, ok := ofportValue(float64(1000))
+		Expect(ok).To(BeTrue())
+		Expect(ofport).To(Equal(uint(1000)))
+		_, ok = ofportValue(float64(-1))
+		Expect(ok).To(BeFalse())
+		_, ok = ofportValue(ovsdb.OvsSet{})
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -21,20 +21,68 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
+	"strconv"
+	"time"
 
+	"github.com/containernetworking/plugins/pkg/utils/buildversion"
+	"github.com/ovn-org/libovsdb/cache"
 	"github.com/ovn-org/libovsdb/client"
 	"github.com/ovn-org/libovsdb/model"
 	"github.com/ovn-org/libovsdb/ovsdb"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/faults"
 )
 
 const ovsPortOwner = "ovs-cni.network.kubevirt.io"
+
+// BridgeMaxPortsKey is the external_ids key of a bridge limiting the number
+// of ports ovs-cni may create on it
+const BridgeMaxPortsKey = ovsPortOwner + "/max-ports"
+
+// external_ids keys stamped on every Port and Interface created by ovs-cni
+const (
+	// CreationTimeKey holds the RFC3339 (UTC) time the row was created
+	CreationTimeKey = "ovs-cni.creation-time"
+	// VersionKey holds the version of the plugin which created the row
+	VersionKey = "ovs-cni.version"
+	// QuarantineExpiryKey holds the RFC3339 (UTC) time until a port retained on DEL is kept
+	QuarantineExpiryKey = "ovs-cni.quarantine-expiry"
+	// CaptureExpiryKey holds the RFC3339 (UTC) time until a capture mirror is kept
+	CaptureExpiryKey = "ovs-cni.capture-expiry"
+	// ContainerIDKey holds ID of the container of the attachment of a port,
+	// so its cache entry can be rebuilt
+	ContainerIDKey = "ovs-cni.container-id"
+	// MirrorConsumerKey holds UUID of the consumer port of a mirror with
+	// output_vlan, which has no output_port referring to the consumer
+	MirrorConsumerKey = "ovs-cni.mirror-consumer"
+	// VlanTranslationKey marks the port of an attachment with VLAN
+	// translation, so its cache entry can be rebuilt
+	VlanTranslationKey = "ovs-cni.vlan-translation"
+	// QinQEthTypeKey holds the ethertype of the S-VLAN in other_config of a
+	// dot1q-tunnel port
+	QinQEthTypeKey = "qinq-ethtype"
+)
+
 const (
-	bridgeTable = "Bridge"
-	ovsTable    = "Open_vSwitch"
+	bridgeTable    = "Bridge"
+	ovsTable       = "Open_vSwitch"
+	portTable      = "Port"
+	interfaceTable = "Interface"
+
+	// error of operations rejected by RBAC of ovsdb-server
+	permissionError = "permission error"
+	// error of wait operations which are not satisfied within their timeout
+	timedOutError = "timed out"
 )
 
 var (
 	errObjectNotFound = errors.New("object not found")
+	// ErrPermissionDenied is returned when ovsdb-server RBAC rejects an operation
+	ErrPermissionDenied = errors.New("operation denied by OVSDB RBAC, check the role of the ovs-cni client")
+	// ErrNotOwned is returned when a destructive operation is refused on a
+	// port without the owner external ID of ovs-cni
+	ErrNotOwned = errors.New("port not created by ovs-cni")
 )
 
 // Bridge defines an object in Bridge table
@@ -47,10 +95,59 @@ type OpenvSwitch struct {
 	UUID string `ovsdb:"_uuid"`
 }
 
+// Port defines an object in Port table, only columns watched by WatchPorts
+type Port struct {
+	UUID string `ovsdb:"_uuid"`
+	Name string `ovsdb:"name"`
+}
+
+// Interface defines an object in Interface table, only columns watched by
+// WatchPorts
+type Interface struct {
+	UUID      string  `ovsdb:"_uuid"`
+	Name      string  `ovsdb:"name"`
+	LinkState *string `ovsdb:"link_state"`
+}
+
+// PortOptions describes a port of a container interface created by
+// CreatePort, unset fields are left unset in OVSDB
+type PortOptions struct {
+	// Name of the port and its interface
+	Name string
+	// ContNetns, ContIface and ContNetwork identify the container interface
+	// the port belongs to, ContPodUID and ContID its pod and container
+	ContNetns   string
+	ContIface   string
+	ContNetwork string
+	ContPodUID  string
+	ContID      string
+	// OvnPort is the OVN logical port, iface-id of the interface
+	OvnPort       string
+	OfportRequest uint
+	VlanTag       uint
+	Trunks        []uint
+	VlanMode      string
+	// QinQEthType is the ethertype of the S-VLAN of dot1q-tunnel ports
+	QinQEthType string
+	// IntfType and IntfOptions are type and type specific options of the
+	// interface
+	IntfType    string
+	IntfOptions map[string]string
+}
+
 // OvsDriver OVS driver state
 type OvsDriver struct {
 	// OVS client
 	ovsClient client.Client
+
+	// LeastPrivilege limits the driver to operations needed to manage its
+	// own ports, so it works with an RBAC restricted OVSDB client
+	LeastPrivilege bool
+
+	// KeyPrefix is prepended to external_ids keys written by the driver, so
+	// they don't collide with keys of other tooling. Keys without it are
+	// still read, so rows created before it was configured are found.
+	KeyPrefix string
 }
 
 // OvsBridgeDriver OVS bridge driver state
@@ -59,6 +156,16 @@ type OvsBridgeDriver struct {
 
 	// Name of the OVS bridge
 	OvsBridgeName string
+
+	// Force allows removal and quarantine of ports without the owner
+	// external ID, e.g. ports created by old versions of ovs-cni
+	Force bool
+
+	// OfportRangeMin and OfportRangeMax bound the range ofport_request of
+	// ports created without a static one is allocated from, no ofport is
+	// requested when OfportRangeMax is 0
+	OfportRangeMin uint
+	OfportRangeMax uint
 }
 
 // constants used to identify if a mirror is a comsumer or a producer
@@ -70,7 +177,7 @@ const (
 // connectToOvsDb connect to ovsdb
 func connectToOvsDb(ovsSocket string) (client.Client, error) {
 	dbmodel, err := model.NewClientDBModel("Open_vSwitch",
-		map[string]model.Model{bridgeTable: &Bridge{}, ovsTable: &OpenvSwitch{}})
+		map[string]model.Model{bridgeTable: &Bridge{}, ovsTable: &OpenvSwitch{}, portTable: &Port{}, interfaceTable: &Interface{}})
 	if err != nil {
 		return nil, fmt.Errorf("unable to create DB model error: %v", err)
 	}
@@ -136,20 +243,43 @@ func NewOvsBridgeDriver(bridgeName, socketFile string) (*OvsBridgeDriver, error)
 }
 
 // Wrapper for ovsDB transaction
-func (ovsd *OvsDriver) ovsdbTransact(ops []ovsdb.Operation) ([]ovsdb.OperationResult, error) {
+func (ovsd *OvsDriver) ovsdbTransact(ops []ovsdb.Operation) (_ []ovsdb.OperationResult, err error) {
+	start := time.Now()
+	defer func() {
+		observeTransaction(ops, time.Since(start), err)
+	}()
+
+	if err := faults.Inject(faults.OvsdbTransact); err != nil {
+		return nil, fmt.Errorf("OVS transaction failed err %v", err)
+	}
+
+	transactOps := ops
+	if ovsd.KeyPrefix != "" {
+		transactOps = ovsd.withKeyPrefix(ops)
+	}
+
 	// Perform OVSDB transaction
-	reply, _ := ovsd.ovsClient.Transact(context.Background(), ops...)
+	reply, _ := ovsd.ovsClient.Transact(context.Background(), transactOps...)
 
-	if len(reply) < len(ops) {
+	if len(reply) < len(transactOps) {
 		return nil, errors.New("OVS transaction failed. Less replies than operations")
 	}
 
 	// Parse reply and look for errors
 	for _, o := range reply {
+		if o.Error == permissionError {
+			return nil, fmt.Errorf("%w: %s", ErrPermissionDenied, o.Details)
+		}
+		if o.Error == timedOutError {
+			return nil, fmt.Errorf("%w: %s", errWaitTimedOut, o.Details)
+		}
 		if o.Error != "" {
 			return nil, errors.New("OVS Transaction failed err " + o.Error + " Details: " + o.Details)
 		}
 	}
+	if ovsd.KeyPrefix != "" {
+		reply = ovsd.withoutKeyPrefix(ops, reply)
+	}
 
 	// Return success
 	return reply, nil
@@ -158,26 +288,122 @@ func (ovsd *OvsDriver) ovsdbTransact(ops []ovsdb.Operation) ([]ovsdb.OperationRe
 // **************** OVS driver API ********************
 
 // CreatePort Create an internal port in OVS
-func (ovsd *OvsBridgeDriver) CreatePort(intfName, contNetnsPath, contIfaceName, ovnPortName string, ofportRequest uint, vlanTag uint, trunks []uint, portType string, intfType string, contPodUid string) error {
-	intfUUID, intfOp, err := createInterfaceOperation(intfName, ofportRequest, ovnPortName, intfType)
+func (ovsd *OvsBridgeDriver) CreatePort(port PortOptions) error {
+	if port.OfportRequest != 0 || ovsd.OfportRangeMax == 0 {
+		return ovsd.createPort(nil, port)
+	}
+	// the allocated ofport may be taken by a concurrent ADD, the transaction
+	// fails then and the next free one is allocated
+	for attempt := 1; ; attempt++ {
+		ofport, waitOp, err := ovsd.allocateOfport()
+		if err != nil {
+			return err
+		}
+		port.OfportRequest = ofport
+		err = ovsd.createPort([]ovsdb.Operation{*waitOp}, port)
+		if !errors.Is(err, errWaitTimedOut) {
+			return err
+		}
+		if attempt == ofportAllocationAttempts {
+			return fmt.Errorf("failed to allocate ofport of port %s in range %d-%d after %d attempts: %v", port.Name, ovsd.OfportRangeMin, ovsd.OfportRangeMax, attempt, err)
+		}
+		log.Printf("Info: ports of bridge %s changed while requesting ofport %d for port %s, retrying", ovsd.OvsBridgeName, ofport, port.Name)
+	}
+}
+
+// createPort creates the port, waitOps are checked before any change is made
+func (ovsd *OvsBridgeDriver) createPort(waitOps []ovsdb.Operation, port PortOptions) error {
+	intfUUID, intfOp, err := createInterfaceOperation(port.Name, port.OfportRequest, port.OvnPort, port.IntfType, port.IntfOptions)
 	if err != nil {
 		return err
 	}
 
-	portUUID, portOp, err := createPortOperation(intfName, contNetnsPath, contIfaceName, vlanTag, trunks, portType, intfUUID, contPodUid)
+	portUUID, portOp, err := createPortOperation(port, intfUUID)
 	if err != nil {
 		return err
 	}
 
 	mutateOp := attachPortOperation(portUUID, ovsd.OvsBridgeName)
 
+	// stale ports are removed in the same transaction, so the new port can
+	// take over their name or ofport_request
+	reclaimOps, err := ovsd.reclaimStalePortsOperations(port.ContNetns, port.ContIface, port.ContNetwork, port.ContPodUID)
+	if err != nil {
+		return err
+	}
+
 	// Perform OVS transaction
-	operations := []ovsdb.Operation{*intfOp, *portOp, *mutateOp}
+	operations := append(waitOps, reclaimOps...)
+	operations = append(operations, *intfOp, *portOp, *mutateOp)
 
 	_, err = ovsd.ovsdbTransact(operations)
 	return err
 }
 
+// reclaimStalePortsOperations returns operations removing ports of the bridge
+// attached for the same interface and network of the same pod in another
+// network namespace. Static pods may recreate their sandbox while the port of
+// the previous one still lingers. Quarantined ports are kept.
+func (ovsd *OvsBridgeDriver) reclaimStalePortsOperations(contNetnsPath, contIfaceName, contNetwork, contPodUid string) ([]ovsdb.Operation, error) {
+	if contPodUid == "" {
+		return nil, nil
+	}
+	ovsmap, err := ovsdb.NewOvsMap(map[string]string{
+		"contPodUid": contPodUid,
+		"contIface":  contIfaceName,
+		"owner":      ovsPortOwner,
+	})
+	if err != nil {
+		return nil, err
+	}
+	selectOps := []ovsdb.Operation{
+		{
+			Op:      "select",
+			Table:   "Port",
+			Columns: []string{"_uuid", "name", "external_ids"},
+			Where:   []ovsdb.Condition{ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, ovsmap)},
+		},
+		{
+			Op:      "select",
+			Table:   "Bridge",
+			Columns: []string{"ports"},
+			Where:   []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, ovsd.OvsBridgeName)},
+		},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(selectOps)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != len(selectOps) || len(transactionResult[1].Rows) != 1 {
+		return nil, fmt.Errorf("failed to find bridge %s", ovsd.OvsBridgeName)
+	}
+	bridgePorts, err := convertToArray(transactionResult[1].Rows[0]["ports"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert ports to an array error: %v", err)
+	}
+	onBridge := make(map[ovsdb.UUID]bool, len(bridgePorts))
+	for _, port := range bridgePorts {
+		onBridge[port.(ovsdb.UUID)] = true
+	}
+
+	var operations []ovsdb.Operation
+	for _, port := range transactionResult[0].Rows {
+		externalIDs, err := getExternalIDs(port)
+		if err != nil {
+			return nil, fmt.Errorf("get external ids: %v", err)
+		}
+		portUUID := port["_uuid"].(ovsdb.UUID)
+		if externalIDs["contNetns"] == contNetnsPath || externalIDs["contNetwork"] != contNetwork ||
+			externalIDs[QuarantineExpiryKey] != "" || !onBridge[portUUID] {
+			continue
+		}
+		name := fmt.Sprintf("%v", port["name"])
+		log.Printf("Info: reclaiming port %s of interface %s of pod %s left by sandbox %s", name, contIfaceName, contPodUid, externalIDs["contNetns"])
+		operations = append(operations, *deleteInterfaceOperation(name), *deletePortOperation(name), *detachPortOperation(portUUID, ovsd.OvsBridgeName))
+	}
+	return operations, nil
+}
+
 // DeletePort Delete a port from OVS
 func (ovsd *OvsBridgeDriver) DeletePort(intfName string) error {
 	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, intfName)
@@ -190,8 +416,8 @@ func (ovsd *OvsBridgeDriver) DeletePort(intfName string) error {
 	if err != nil {
 		return fmt.Errorf("get external ids: %v", err)
 	}
-	if externalIDs["owner"] != ovsPortOwner {
-		return fmt.Errorf("port not created by ovs-cni")
+	if externalIDs["owner"] != ovsPortOwner && !ovsd.Force {
+		return fmt.Errorf("%w: %s", ErrNotOwned, intfName)
 	}
 
 	// We make a select transaction using the interface name
@@ -211,6 +437,91 @@ func (ovsd *OvsBridgeDriver) DeletePort(intfName string) error {
 	return err
 }
 
+// QuarantinePort renames a port created by ovs-cni together with its interface
+// and marks it as quarantined until the given expiry time. Names of rows are
+// immutable, so the port is replaced by a copy under the new name.
+func (ovsd *OvsBridgeDriver) QuarantinePort(intfName, newName string, expiry time.Time) error {
+	if ovsd.LeastPrivilege {
+		return errors.New("quarantine is not supported in least privilege mode")
+	}
+	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, intfName)
+	row, err := ovsd.findByCondition("Port", condition, nil)
+	if err != nil {
+		return err
+	}
+
+	externalIDs, err := getExternalIDs(row)
+	if err != nil {
+		return fmt.Errorf("get external ids: %v", err)
+	}
+	if externalIDs["owner"] != ovsPortOwner && !ovsd.Force {
+		return fmt.Errorf("%w: %s", ErrNotOwned, intfName)
+	}
+
+	portUUID := row["_uuid"].(ovsdb.UUID)
+
+	// the quarantined interface is not bound to the OVN port of the attachment
+	newIntfUUID, newIntfOp, err := createInterfaceOperation(newName, 0, "", "", nil)
+	if err != nil {
+		return err
+	}
+	externalIDs[QuarantineExpiryKey] = expiry.UTC().Format(time.RFC3339)
+	newPortUUID, newPortOp, err := quarantinedPortOperation(row, newName, externalIDs, newIntfUUID)
+	if err != nil {
+		return err
+	}
+
+	// Perform OVS transaction
+	operations := []ovsdb.Operation{
+		*deleteInterfaceOperation(intfName), *deletePortOperation(intfName), *detachPortOperation(portUUID, ovsd.OvsBridgeName),
+		*newIntfOp, *newPortOp, *attachPortOperation(newPortUUID, ovsd.OvsBridgeName),
+	}
+
+	_, err = ovsd.ovsdbTransact(operations)
+	return err
+}
+
+// FindExpiredQuarantinedPorts returns the quarantined ports which expired before the given time
+func (ovsd *OvsDriver) FindExpiredQuarantinedPorts(now time.Time) ([]string, error) {
+	selectOp := ovsdb.Operation{
+		Op:      "select",
+		Columns: []string{"name", "external_ids"},
+		Table:   "Port",
+	}
+	transactionResult, err := ovsd.ovsdbTransact([]ovsdb.Operation{selectOp})
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != 1 {
+		return nil, fmt.Errorf("no transaction result")
+	}
+	operationResult := transactionResult[0]
+	if operationResult.Error != "" {
+		return nil, errors.New(operationResult.Error)
+	}
+
+	var names []string
+	for _, row := range operationResult.Rows {
+		externalIDs, err := getExternalIDs(row)
+		if err != nil || externalIDs["owner"] != ovsPortOwner {
+			continue
+		}
+		expiryValue, quarantined := externalIDs[QuarantineExpiryKey]
+		if !quarantined {
+			continue
+		}
+		expiry, err := time.Parse(time.RFC3339, expiryValue)
+		if err != nil {
+			log.Printf("invalid quarantine expiry %q on port %v: %v", expiryValue, row["name"], err)
+			continue
+		}
+		if now.After(expiry) {
+			names = append(names, fmt.Sprintf("%v", row["name"]))
+		}
+	}
+	return names, nil
+}
+
 func getExternalIDs(row map[string]interface{}) (map[string]string, error) {
 	rowVal, ok := row["external_ids"]
 	if !ok {
@@ -259,6 +570,53 @@ func (ovsd *OvsDriver) BridgeList() ([]string, error) {
 	return bridges, nil
 }
 
+// BridgeExternalID returns value of the external_ids key of every bridge
+// which has it set
+func (ovsd *OvsDriver) BridgeExternalID(key string) (map[string]string, error) {
+	selectOp := []ovsdb.Operation{{
+		Op:      "select",
+		Table:   "Bridge",
+		Columns: []string{"name", "external_ids"},
+	}}
+
+	transactionResult, err := ovsd.ovsdbTransact(selectOp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transactionResult) != 1 {
+		return nil, fmt.Errorf("no transaction result")
+	}
+
+	operationResult := transactionResult[0]
+	if operationResult.Error != "" {
+		return nil, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+	}
+
+	values := map[string]string{}
+	for _, bridge := range operationResult.Rows {
+		externalIDs, err := getExternalIDs(bridge)
+		if err != nil {
+			return nil, fmt.Errorf("get external ids: %v", err)
+		}
+		if value, found := externalIDs[key]; found {
+			values[fmt.Sprintf("%v", bridge["name"])] = value
+		}
+	}
+
+	return values, nil
+}
+
+// ParseMaxPorts parses the limit of ports of a bridge set in its external_ids
+// under BridgeMaxPortsKey
+func ParseMaxPorts(value string) (int, error) {
+	maxPorts, err := strconv.Atoi(value)
+	if err != nil || maxPorts < 0 {
+		return 0, fmt.Errorf("invalid %s %q, must be a non-negative integer", BridgeMaxPortsKey, value)
+	}
+	return maxPorts, nil
+}
+
 // GetOFPortOpState retrieves link state of the OF port
 func (ovsd *OvsDriver) GetOFPortOpState(portName string) (string, error) {
 	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)
@@ -290,6 +648,193 @@ func (ovsd *OvsDriver) GetOFPortOpState(portName string) (string, error) {
 	return fmt.Sprintf("%v", operationResult.Rows[0]["link_state"]), nil
 }
 
+// GetInterfaceStatistics returns statistics counters of the interface, e.g. rx_bytes
+func (ovsd *OvsDriver) GetInterfaceStatistics(intfName string) (map[string]uint64, error) {
+	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, intfName)
+	row, err := ovsd.findByCondition("Interface", condition, []string{"statistics"})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := row["statistics"].(ovsdb.OvsMap); !ok {
+		return nil, fmt.Errorf("not a OvsMap: %T: %v", row["statistics"], row["statistics"])
+	}
+	return statisticsOf(row), nil
+}
+
+// statisticsOf returns the statistics column of an interface row, empty when
+// the row has none
+func statisticsOf(row map[string]interface{}) map[string]uint64 {
+	rowValOvsMap, _ := row["statistics"].(ovsdb.OvsMap)
+	stats := make(map[string]uint64, len(rowValOvsMap.GoMap))
+	for key, value := range rowValOvsMap.GoMap {
+		switch v := value.(type) {
+		case float64:
+			stats[key.(string)] = uint64(v)
+		case int:
+			stats[key.(string)] = uint64(v)
+		}
+	}
+	return stats
+}
+
+// WaitForInterfaceCounter polls statistics of the interface until the counter,
+// e.g. tx_packets, increases or the timeout expires. It returns whether the
+// counter increased. OVS refreshes statistics every 5 seconds by default.
+func (ovsd *OvsDriver) WaitForInterfaceCounter(intfName, counter string, timeout, interval time.Duration) (bool, error) {
+	stats, err := ovsd.GetInterfaceStatistics(intfName)
+	if err != nil {
+		return false, err
+	}
+	initial := stats[counter]
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		time.Sleep(interval)
+		if stats, err = ovsd.GetInterfaceStatistics(intfName); err != nil {
+			return false, err
+		}
+		if stats[counter] > initial {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetIngressPolicing limits the rate of traffic the bridge receives from the
+// interface, rate is in kbps and burst in kilobits
+func (ovsd *OvsBridgeDriver) SetIngressPolicing(intfName string, rate, burst uint) error {
+	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, intfName)
+	updateOp := ovsdb.Operation{
+		Op:    "update",
+		Table: "Interface",
+		Row: map[string]interface{}{
+			"ingress_policing_rate":  rate,
+			"ingress_policing_burst": burst,
+		},
+		Where: []ovsdb.Condition{condition},
+	}
+
+	_, err := ovsd.ovsdbTransact([]ovsdb.Operation{updateOp})
+	return err
+}
+
+// SetPortExternalIDs adds the keys to external_ids of the port, existing
+// keys are kept
+func (ovsd *OvsBridgeDriver) SetPortExternalIDs(portName string, externalIDs map[string]string) error {
+	mutateMap, err := ovsdb.NewOvsMap(externalIDs)
+	if err != nil {
+		return err
+	}
+	mutation := ovsdb.NewMutation("external_ids", ovsdb.MutateOperationInsert, mutateMap)
+	mutateOp := ovsdb.Operation{
+		Op:        "mutate",
+		Table:     "Port",
+		Mutations: []ovsdb.Mutation{*mutation},
+		Where:     []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)},
+	}
+
+	_, err = ovsd.ovsdbTransact([]ovsdb.Operation{mutateOp})
+	return err
+}
+
+// SetPortsVlan changes vlan_mode, tag and trunks of existing ports in a
+// single transaction, either all of them are changed or none
+func (ovsd *OvsBridgeDriver) SetPortsVlan(portNames []string, vlanTag uint, trunks []uint, portType string) error {
+	row := map[string]interface{}{"vlan_mode": portType}
+	var err error
+	if portType == "access" || portType == "native-tagged" || portType == "native-untagged" || portType == "dot1q-tunnel" {
+		row["tag"] = vlanTag
+	} else {
+		row["tag"] = ovsdb.OvsSet{GoSet: []interface{}{}}
+	}
+	if portType != "access" && len(trunks) > 0 {
+		row["trunks"], err = ovsdb.NewOvsSet(trunks)
+		if err != nil {
+			return err
+		}
+	} else {
+		row["trunks"] = ovsdb.OvsSet{GoSet: []interface{}{}}
+	}
+
+	// the wait operations abort the transaction when a port is missing
+	timeout := 0
+	var operations []ovsdb.Operation
+	for _, portName := range portNames {
+		condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)
+		operations = append(operations, ovsdb.Operation{
+			Op:      "wait",
+			Table:   "Port",
+			Timeout: &timeout,
+			Where:   []ovsdb.Condition{condition},
+			Columns: []string{"name"},
+			Until:   "==",
+			Rows:    []ovsdb.Row{{"name": portName}},
+		}, ovsdb.Operation{
+			Op:    "update",
+			Table: "Port",
+			Row:   row,
+			Where: []ovsdb.Condition{condition},
+		})
+	}
+	if _, err := ovsd.ovsdbTransact(operations); err != nil {
+		return fmt.Errorf("failed to set vlan of ports %v: %v", portNames, err)
+	}
+	return nil
+}
+
+// BridgeControlState is the OpenFlow control state of a bridge
+type BridgeControlState struct {
+	// FailMode is standalone or secure, empty when not set, which means standalone
+	FailMode string
+	// Controllers maps targets of the controllers to whether they are connected
+	Controllers map[string]bool
+}
+
+// GetBridgeControlState returns fail mode and controllers of the bridge
+func (ovsd *OvsDriver) GetBridgeControlState(bridgeName string) (*BridgeControlState, error) {
+	operations := []ovsdb.Operation{
+		{
+			Op:      "select",
+			Table:   "Bridge",
+			Columns: []string{"fail_mode", "controller"},
+			Where:   []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridgeName)},
+		},
+		{
+			Op:      "select",
+			Table:   "Controller",
+			Columns: []string{"_uuid", "target", "is_connected"},
+		},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(operations)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != len(operations) || len(transactionResult[0].Rows) != 1 {
+		return nil, fmt.Errorf("failed to find bridge %s", bridgeName)
+	}
+	bridge := transactionResult[0].Rows[0]
+
+	state := &BridgeControlState{Controllers: map[string]bool{}}
+	if failMode, ok := bridge["fail_mode"].(string); ok {
+		state.FailMode = failMode
+	}
+	controllers, err := convertToArray(bridge["controller"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert controllers to an array error: %v", err)
+	}
+	ofBridge := make(map[ovsdb.UUID]bool, len(controllers))
+	for _, controller := range controllers {
+		ofBridge[controller.(ovsdb.UUID)] = true
+	}
+	for _, controller := range transactionResult[1].Rows {
+		if !ofBridge[controller["_uuid"].(ovsdb.UUID)] {
+			continue
+		}
+		connected, _ := controller["is_connected"].(bool)
+		state.Controllers[fmt.Sprintf("%v", controller["target"])] = connected
+	}
+	return state, nil
+}
+
 // GetOFPortVlanState retrieves port vlan state of the OF port
 func (ovsd *OvsDriver) GetOFPortVlanState(portName string) (string, *uint, []uint, error) {
 	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)
@@ -344,6 +889,31 @@ func (ovsd *OvsDriver) GetOFPortVlanState(portName string) (string, *uint, []uin
 	return vlanMode, tag, trunks, nil
 }
 
+// GetPortQinQEthType returns the ethertype of the S-VLAN of the port, empty
+// when it is not set
+func (ovsd *OvsDriver) GetPortQinQEthType(portName string) (string, error) {
+	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)
+	selectOp := []ovsdb.Operation{{
+		Op:      "select",
+		Table:   "Port",
+		Columns: []string{"other_config"},
+		Where:   []ovsdb.Condition{condition},
+	}}
+	transactionResult, err := ovsd.ovsdbTransact(selectOp)
+	if err != nil {
+		return "", err
+	}
+	if len(transactionResult) != 1 || len(transactionResult[0].Rows) != 1 {
+		return "", fmt.Errorf("port %s not found", portName)
+	}
+	otherConfig, ok := transactionResult[0].Rows[0]["other_config"].(ovsdb.OvsMap)
+	if !ok {
+		return "", nil
+	}
+	ethType, _ := otherConfig.GoMap[QinQEthTypeKey].(string)
+	return ethType, nil
+}
+
 // CreateMirror Creates a new mirror to a specific bridge
 func (ovsd *OvsBridgeDriver) CreateMirror(bridgeName, mirrorName string) error {
 	mirrorExist, err := ovsd.IsMirrorPresent(mirrorName)
@@ -402,17 +972,119 @@ func (ovsd *OvsBridgeDriver) DeleteMirror(bridgeName, mirrorName string) error {
 	if externalIDs["owner"] != ovsPortOwner {
 		return fmt.Errorf("mirror not created by ovs-cni")
 	}
-
-	mirrorUUID := row["_uuid"].(ovsdb.UUID)
-
-	deleteOp := deleteMirrorOperation(mirrorName)
-	detachFromBridgeOp := detachMirrorFromBridgeOperation(mirrorUUID, bridgeName)
-
-	// Perform OVS transaction
-	operations := []ovsdb.Operation{*deleteOp, *detachFromBridgeOp}
-
-	_, err = ovsd.ovsdbTransact(operations)
-	return err
+
+	mirrorUUID := row["_uuid"].(ovsdb.UUID)
+
+	deleteOp := deleteMirrorOperation(mirrorName)
+	detachFromBridgeOp := detachMirrorFromBridgeOperation(mirrorUUID, bridgeName)
+
+	// Perform OVS transaction
+	operations := []ovsdb.Operation{*deleteOp, *detachFromBridgeOp}
+
+	_, err = ovsd.ovsdbTransact(operations)
+	return err
+}
+
+// CreateCaptureMirror creates a mirror of all traffic of the port to the
+// output port, marked to be removed after the given expiry time
+func (ovsd *OvsBridgeDriver) CreateCaptureMirror(mirrorName, portName, outputPortName string, expiry time.Time) error {
+	portUUID, err := ovsd.GetPortUUID(portName)
+	if err != nil {
+		return fmt.Errorf("failed to find port %s: %v", portName, err)
+	}
+	outputPortUUID, err := ovsd.GetPortUUID(outputPortName)
+	if err != nil {
+		return fmt.Errorf("failed to find capture port %s: %v", outputPortName, err)
+	}
+
+	mirrorUUID, mirrorOp, err := createMirrorOperation(mirrorName)
+	if err != nil {
+		return err
+	}
+	mirrorOp.Row["select_src_port"], err = ovsdb.NewOvsSet(portUUID)
+	if err != nil {
+		return err
+	}
+	mirrorOp.Row["select_dst_port"], err = ovsdb.NewOvsSet(portUUID)
+	if err != nil {
+		return err
+	}
+	mirrorOp.Row["output_port"] = outputPortUUID
+	mirrorOp.Row["external_ids"], err = ovsdb.NewOvsMap(map[string]string{
+		"owner":          ovsPortOwner,
+		CaptureExpiryKey: expiry.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	attachMirrorOp := attachMirrorOperation(mirrorUUID, ovsd.OvsBridgeName)
+
+	// Perform OVS transaction
+	operations := []ovsdb.Operation{*mirrorOp, *attachMirrorOp}
+
+	_, err = ovsd.ovsdbTransact(operations)
+	return err
+}
+
+// FindExpiredCaptureMirrors returns the capture mirrors of the bridge which
+// expired before the given time
+func (ovsd *OvsBridgeDriver) FindExpiredCaptureMirrors(now time.Time) ([]string, error) {
+	operations := []ovsdb.Operation{
+		{
+			Op:      "select",
+			Table:   "Bridge",
+			Where:   []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, ovsd.OvsBridgeName)},
+			Columns: []string{"mirrors"},
+		},
+		{Op: "select", Table: "Mirror", Columns: []string{"_uuid", "name", "external_ids"}},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(operations)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != len(operations) {
+		return nil, fmt.Errorf("no transaction result")
+	}
+	for _, operationResult := range transactionResult {
+		if operationResult.Error != "" {
+			return nil, errors.New(operationResult.Error)
+		}
+	}
+	if len(transactionResult[0].Rows) != 1 {
+		return nil, fmt.Errorf("bridge %s not found", ovsd.OvsBridgeName)
+	}
+	bridgeMirrors, err := convertToArray(transactionResult[0].Rows[0]["mirrors"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert mirrors to an array error: %v", err)
+	}
+	ofBridge := make(map[ovsdb.UUID]bool, len(bridgeMirrors))
+	for _, mirror := range bridgeMirrors {
+		ofBridge[mirror.(ovsdb.UUID)] = true
+	}
+
+	var names []string
+	for _, row := range transactionResult[1].Rows {
+		if !ofBridge[row["_uuid"].(ovsdb.UUID)] {
+			continue
+		}
+		externalIDs, err := getExternalIDs(row)
+		if err != nil || externalIDs["owner"] != ovsPortOwner {
+			continue
+		}
+		expiryValue, isCapture := externalIDs[CaptureExpiryKey]
+		if !isCapture {
+			continue
+		}
+		expiry, err := time.Parse(time.RFC3339, expiryValue)
+		if err != nil {
+			log.Printf("invalid capture expiry %q on mirror %v: %v", expiryValue, row["name"], err)
+			continue
+		}
+		if now.After(expiry) {
+			names = append(names, fmt.Sprintf("%v", row["name"]))
+		}
+	}
+	return names, nil
 }
 
 // AttachPortToMirrorProducer Adds a portUUID as 'select_src_port' or 'select_dst_port' to an existing mirror
@@ -446,6 +1118,62 @@ func (ovsd *OvsBridgeDriver) AttachPortToMirrorConsumer(portUUIDStr, mirrorName
 	return err
 }
 
+// AttachVlanToMirrorConsumer Sets 'output_vlan' of an existing mirror, traffic
+// is flooded to the VLAN instead of sent to an output port, so portUUID is
+// only recorded in external_ids of the mirror
+func (ovsd *OvsBridgeDriver) AttachVlanToMirrorConsumer(portUUIDStr, mirrorName string, outputVlan uint) error {
+	consumer, err := ovsdb.NewOvsMap(map[string]string{MirrorConsumerKey: portUUIDStr})
+	if err != nil {
+		return err
+	}
+	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
+	operations := []ovsdb.Operation{{
+		Op:    "update",
+		Table: "Mirror",
+		Row:   map[string]interface{}{"output_vlan": outputVlan},
+		Where: []ovsdb.Condition{condition},
+	}, {
+		Op:        "mutate",
+		Table:     "Mirror",
+		Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("external_ids", ovsdb.MutateOperationInsert, consumer)},
+		Where:     []ovsdb.Condition{condition},
+	}}
+
+	_, err = ovsd.ovsdbTransact(operations)
+	return err
+}
+
+// DetachVlanFromMirrorConsumer Clears 'output_vlan' of an existing mirror if
+// it was set for portUUID
+func (ovsd *OvsBridgeDriver) DetachVlanFromMirrorConsumer(portUUIDStr, mirrorName string) error {
+	consumer, err := ovsdb.NewOvsMap(map[string]string{MirrorConsumerKey: portUUIDStr})
+	if err != nil {
+		return err
+	}
+	consumerKey, err := ovsdb.NewOvsSet(MirrorConsumerKey)
+	if err != nil {
+		return err
+	}
+	conditions := []ovsdb.Condition{
+		ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName),
+		ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, consumer),
+	}
+	operations := []ovsdb.Operation{{
+		Op:    "update",
+		Table: "Mirror",
+		Row:   map[string]interface{}{"output_vlan": ovsdb.OvsSet{GoSet: []interface{}{}}},
+		Where: conditions,
+	}, {
+		Op:        "mutate",
+		Table:     "Mirror",
+		Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("external_ids", ovsdb.MutateOperationDelete, consumerKey)},
+		Where:     conditions,
+	}}
+
+	_, err = ovsd.ovsdbTransact(operations)
+	return err
+}
+
 // DetachPortFromMirrorProducer Removes portUUID as both 'select_src_port' and 'select_dst_port' from an existing mirror
 func (ovsd *OvsBridgeDriver) DetachPortFromMirrorProducer(portUUIDStr, mirrorName string) error {
 	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
@@ -502,7 +1230,46 @@ func (ovsd *OvsBridgeDriver) GetPortUUID(portName string) (ovsdb.UUID, error) {
 	return portUUID, nil
 }
 
+// GetPortUUIDByDpdkDevargs returns UUID of the port of the DPDK interface
+// with given dpdk-devargs option, e.g. "0000:03:00.0,representor=[3]"
+func (ovsd *OvsBridgeDriver) GetPortUUIDByDpdkDevargs(devargs string) (ovsdb.UUID, error) {
+	options, err := ovsdb.NewOvsMap(map[string]string{"dpdk-devargs": devargs})
+	if err != nil {
+		return ovsdb.UUID{}, err
+	}
+	iface, err := ovsd.findByCondition("Interface",
+		ovsdb.NewCondition("options", ovsdb.ConditionIncludes, options),
+		[]string{"name", "_uuid"})
+	if err != nil {
+		return ovsdb.UUID{}, err
+	}
+	port, err := ovsd.findByCondition("Port",
+		ovsdb.NewCondition("interfaces", ovsdb.ConditionIncludes, iface["_uuid"]),
+		[]string{"_uuid"})
+	if err != nil {
+		return ovsdb.UUID{}, err
+	}
+	return port["_uuid"].(ovsdb.UUID), nil
+}
+
+// GetInterfaceNameByDpdkDevargs returns name of the DPDK interface with given
+// dpdk-devargs option, e.g. "0000:03:00.0,representor=[3]"
+func (ovsd *OvsDriver) GetInterfaceNameByDpdkDevargs(devargs string) (string, error) {
+	options, err := ovsdb.NewOvsMap(map[string]string{"dpdk-devargs": devargs})
+	if err != nil {
+		return "", err
+	}
+	iface, err := ovsd.findByCondition("Interface",
+		ovsdb.NewCondition("options", ovsdb.ConditionIncludes, options),
+		[]string{"name"})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v", iface["name"]), nil
+}
+
 // IsMirrorConsumerAlreadyAttached Checks if the 'output_port' column of a mirror consumer contains a port UUID
+// or its 'output_vlan' is set
 func (ovsd *OvsDriver) IsMirrorConsumerAlreadyAttached(mirrorName string) (bool, error) {
 	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
 	row, err := ovsd.findByCondition("Mirror", condition, nil)
@@ -515,7 +1282,7 @@ func (ovsd *OvsDriver) IsMirrorConsumerAlreadyAttached(mirrorName string) (bool,
 		return false, fmt.Errorf("cannot convert output_port to an array error: %v", err)
 	}
 
-	if len(outputPorts) == 0 {
+	if len(outputPorts) == 0 && !hasOutputVlan(row) {
 		return false, nil
 	}
 	return true, nil
@@ -561,6 +1328,22 @@ func (ovsd *OvsDriver) CheckMirrorConsumerWithPorts(mirrorName string, portUUIDS
 	return ovsd.isMirrorExistsByConditions(conditions)
 }
 
+// CheckMirrorConsumerWithVlan Checks the configuration of a mirror consumer with output_vlan
+func (ovsd *OvsDriver) CheckMirrorConsumerWithVlan(mirrorName string, outputVlan uint, portUUIDStr string) (bool, error) {
+	consumer, err := ovsdb.NewOvsMap(map[string]string{MirrorConsumerKey: portUUIDStr})
+	if err != nil {
+		return false, err
+	}
+	conditions := []ovsdb.Condition{
+		ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName),
+		// output_vlan = Output VLAN for selected packets
+		ovsdb.NewCondition("output_vlan", ovsdb.ConditionEqual, outputVlan),
+		ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, consumer),
+	}
+
+	return ovsd.isMirrorExistsByConditions(conditions)
+}
+
 // IsMirrorPresent Checks if the Mirror entry already exists
 func (ovsd *OvsDriver) IsMirrorPresent(mirrorName string) (bool, error) {
 	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
@@ -647,7 +1430,9 @@ func (ovsd *OvsDriver) FindBridgeByInterface(ifaceName string) (string, error) {
 }
 
 // GetOvsPortForContIface Return ovs port name for an container interface
-func (ovsd *OvsDriver) GetOvsPortForContIface(contIface, contNetnsPath string) (string, bool, error) {
+// attached to the given network. Ports created by older versions don't
+// record the network and match any network.
+func (ovsd *OvsDriver) GetOvsPortForContIface(contIface, contNetnsPath, contNetwork string) (string, bool, error) {
 	searchMap := map[string]string{
 		"contNetns": contNetnsPath,
 		"contIface": contIface,
@@ -658,17 +1443,42 @@ func (ovsd *OvsDriver) GetOvsPortForContIface(contIface, contNetnsPath string) (
 		return "", false, err
 	}
 
-	condition := ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, ovsmap)
-	colums := []string{"name", "external_ids"}
-	port, err := ovsd.findByCondition("Port", condition, colums)
+	selectOp := ovsdb.Operation{
+		Op:      "select",
+		Table:   "Port",
+		Columns: []string{"name", "external_ids"},
+		Where:   []ovsdb.Condition{ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, ovsmap)},
+	}
+	transactionResult, err := ovsd.ovsdbTransact([]ovsdb.Operation{selectOp})
 	if err != nil {
-		if errors.Is(err, errObjectNotFound) {
-			return "", false, nil
-		}
 		return "", false, err
 	}
+	if len(transactionResult) != 1 {
+		return "", false, fmt.Errorf("unknown error")
+	}
+	operationResult := transactionResult[0]
+	if operationResult.Error != "" {
+		return "", false, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+	}
 
-	return fmt.Sprintf("%v", port["name"]), true, nil
+	legacyPort := ""
+	for _, port := range operationResult.Rows {
+		externalIDs, err := getExternalIDs(port)
+		if err != nil {
+			return "", false, fmt.Errorf("get external ids: %v", err)
+		}
+		network, found := externalIDs["contNetwork"]
+		if found && network == contNetwork {
+			return fmt.Sprintf("%v", port["name"]), true, nil
+		}
+		if !found {
+			legacyPort = fmt.Sprintf("%v", port["name"])
+		}
+	}
+	if legacyPort != "" {
+		return legacyPort, true, nil
+	}
+	return "", false, nil
 }
 
 // CleanEmptyMirrors removes all empty mirrors
@@ -688,6 +1498,345 @@ func (ovsd *OvsBridgeDriver) CleanEmptyMirrors() error {
 	return nil
 }
 
+// uplinkInterfaceTypes are types of interfaces connecting a bridge to the fabric
+var uplinkInterfaceTypes = map[string]bool{"": true, "system": true, "dpdk": true}
+
+// GetUplinkLinkStates returns link state of uplink ports of the bridge, i.e.
+// ports not created by ovs-cni with system or dpdk interfaces. A bond port is
+// up when any of its interfaces is up. When names are given, only ports with
+// those names are returned.
+func (ovsd *OvsBridgeDriver) GetUplinkLinkStates(names []string) (map[string]bool, error) {
+	return ovsd.GetBridgeUplinkLinkStates(ovsd.OvsBridgeName, names)
+}
+
+// GetBridgeUplinkLinkStates returns link state of uplink ports of the given
+// bridge, like OvsBridgeDriver.GetUplinkLinkStates
+func (ovsd *OvsDriver) GetBridgeUplinkLinkStates(bridgeName string, names []string) (map[string]bool, error) {
+	uplinks, err := ovsd.GetBridgeUplinks(bridgeName, names)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]bool, len(uplinks))
+	for name, uplink := range uplinks {
+		states[name] = uplink.Up
+	}
+	return states, nil
+}
+
+// Uplink is the state of an uplink port of a bridge
+type Uplink struct {
+	// Up is true when any interface of the port is up
+	Up bool
+	// MTU is the smallest MTU of interfaces of the port, 0 when unknown
+	MTU int
+}
+
+// GetUplinks returns state of uplink ports of the bridge, like GetUplinkLinkStates
+func (ovsd *OvsBridgeDriver) GetUplinks(names []string) (map[string]Uplink, error) {
+	return ovsd.GetBridgeUplinks(ovsd.OvsBridgeName, names)
+}
+
+// GetBridgeUplinks returns state of uplink ports of the given bridge
+func (ovsd *OvsDriver) GetBridgeUplinks(bridgeName string, names []string) (map[string]Uplink, error) {
+	operations := []ovsdb.Operation{
+		{
+			Op:      "select",
+			Table:   "Bridge",
+			Columns: []string{"ports"},
+			Where:   []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridgeName)},
+		},
+		{Op: "select", Table: "Port", Columns: []string{"_uuid", "name", "interfaces", "external_ids"}},
+		{Op: "select", Table: "Interface", Columns: []string{"_uuid", "type", "link_state", "mtu"}},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(operations)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != len(operations) {
+		return nil, fmt.Errorf("no transaction result")
+	}
+	for _, operationResult := range transactionResult {
+		if operationResult.Error != "" {
+			return nil, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+		}
+	}
+	if len(transactionResult[0].Rows) != 1 {
+		return nil, fmt.Errorf("failed to find bridge %s", bridgeName)
+	}
+
+	bridgePorts, err := convertToArray(transactionResult[0].Rows[0]["ports"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert ports to an array error: %v", err)
+	}
+	onBridge := make(map[ovsdb.UUID]bool, len(bridgePorts))
+	for _, port := range bridgePorts {
+		onBridge[port.(ovsdb.UUID)] = true
+	}
+	ifaces := make(map[ovsdb.UUID]map[string]interface{}, len(transactionResult[2].Rows))
+	for _, row := range transactionResult[2].Rows {
+		ifaces[row["_uuid"].(ovsdb.UUID)] = row
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	uplinks := map[string]Uplink{}
+	for _, port := range transactionResult[1].Rows {
+		name := fmt.Sprintf("%v", port["name"])
+		if !onBridge[port["_uuid"].(ovsdb.UUID)] || (len(wanted) > 0 && !wanted[name]) {
+			continue
+		}
+		externalIDs, err := getExternalIDs(port)
+		if err != nil {
+			return nil, fmt.Errorf("get external ids: %v", err)
+		}
+		if externalIDs["owner"] == ovsPortOwner {
+			continue
+		}
+		portIfaces, err := convertToArray(port["interfaces"])
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert interfaces to an array error: %v", err)
+		}
+		isUplink := false
+		var uplink Uplink
+		for _, ifaceUUID := range portIfaces {
+			iface, ok := ifaces[ifaceUUID.(ovsdb.UUID)]
+			if !ok {
+				continue
+			}
+			ifaceType, _ := iface["type"].(string)
+			if !uplinkInterfaceTypes[ifaceType] {
+				continue
+			}
+			isUplink = true
+			if linkState, _ := iface["link_state"].(string); linkState == "up" {
+				uplink.Up = true
+			}
+			// mtu is an empty set while it is not known
+			if mtu, ok := iface["mtu"].(float64); ok && (uplink.MTU == 0 || int(mtu) < uplink.MTU) {
+				uplink.MTU = int(mtu)
+			}
+		}
+		if isUplink {
+			uplinks[name] = uplink
+		}
+	}
+	return uplinks, nil
+}
+
+// GetOwnedPortCounts returns the number of ports created by ovs-cni on each
+// bridge, bridges without such ports are included with zero
+func (ovsd *OvsDriver) GetOwnedPortCounts() (map[string]int, error) {
+	operations := []ovsdb.Operation{
+		{Op: "select", Table: "Bridge", Columns: []string{"name", "ports"}},
+		{Op: "select", Table: "Port", Columns: []string{"_uuid", "external_ids"}},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(operations)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != len(operations) {
+		return nil, fmt.Errorf("no transaction result")
+	}
+	for _, operationResult := range transactionResult {
+		if operationResult.Error != "" {
+			return nil, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+		}
+	}
+
+	owned := map[ovsdb.UUID]bool{}
+	for _, port := range transactionResult[1].Rows {
+		externalIDs, err := getExternalIDs(port)
+		if err != nil {
+			return nil, fmt.Errorf("get external ids: %v", err)
+		}
+		if externalIDs["owner"] == ovsPortOwner {
+			owned[port["_uuid"].(ovsdb.UUID)] = true
+		}
+	}
+	counts := make(map[string]int, len(transactionResult[0].Rows))
+	for _, bridge := range transactionResult[0].Rows {
+		bridgePorts, err := convertToArray(bridge["ports"])
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert ports to an array error: %v", err)
+		}
+		name := fmt.Sprintf("%v", bridge["name"])
+		counts[name] = 0
+		for _, port := range bridgePorts {
+			if owned[port.(ovsdb.UUID)] {
+				counts[name]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// OwnedPort is a port created by ovs-cni
+type OwnedPort struct {
+	Name        string
+	Bridge      string
+	ExternalIDs map[string]string
+	// Error of the interface of the port, e.g. when its veth is gone
+	Error string
+	// InterfaceType is the type of the interface of the port, empty for system
+	InterfaceType string
+	// Statistics are counters of the interface of the port, as seen by the bridge
+	Statistics map[string]uint64
+}
+
+// GetOwnedPorts returns ports created by ovs-cni on all bridges of the node
+// with their external_ids and the error of their interface
+func (ovsd *OvsDriver) GetOwnedPorts() ([]OwnedPort, error) {
+	operations := []ovsdb.Operation{
+		{Op: "select", Table: "Bridge", Columns: []string{"name", "ports"}},
+		{Op: "select", Table: "Port", Columns: []string{"_uuid", "name", "external_ids"}},
+		{Op: "select", Table: "Interface", Columns: []string{"name", "error", "type", "statistics"}},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(operations)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != len(operations) {
+		return nil, fmt.Errorf("no transaction result")
+	}
+	for _, operationResult := range transactionResult {
+		if operationResult.Error != "" {
+			return nil, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+		}
+	}
+
+	bridges := map[ovsdb.UUID]string{}
+	for _, bridge := range transactionResult[0].Rows {
+		bridgePorts, err := convertToArray(bridge["ports"])
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert ports to an array error: %v", err)
+		}
+		for _, port := range bridgePorts {
+			bridges[port.(ovsdb.UUID)] = fmt.Sprintf("%v", bridge["name"])
+		}
+	}
+	ifaceErrors := map[string]string{}
+	ifaceTypes := map[string]string{}
+	ifaceStats := map[string]map[string]uint64{}
+	for _, row := range transactionResult[2].Rows {
+		if hasError(row) {
+			ifaceErrors[fmt.Sprintf("%v", row["name"])] = row["error"].(string)
+		}
+		if ifaceType, ok := row["type"].(string); ok {
+			ifaceTypes[fmt.Sprintf("%v", row["name"])] = ifaceType
+		}
+		ifaceStats[fmt.Sprintf("%v", row["name"])] = statisticsOf(row)
+	}
+	var ports []OwnedPort
+	for _, port := range transactionResult[1].Rows {
+		externalIDs, err := getExternalIDs(port)
+		if err != nil {
+			return nil, fmt.Errorf("get external ids: %v", err)
+		}
+		if externalIDs["owner"] != ovsPortOwner {
+			continue
+		}
+		name := fmt.Sprintf("%v", port["name"])
+		ports = append(ports, OwnedPort{
+			Name:          name,
+			Bridge:        bridges[port["_uuid"].(ovsdb.UUID)],
+			ExternalIDs:   externalIDs,
+			Error:         ifaceErrors[name],
+			InterfaceType: ifaceTypes[name],
+			Statistics:    ifaceStats[name],
+		})
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports, nil
+}
+
+// BridgeInventory describes a bridge of the node as reported by the marker
+type BridgeInventory struct {
+	Name         string
+	DatapathType string
+	Uplinks      []string
+	Ports        int
+	OwnedPorts   int
+}
+
+// GetBridgeInventory returns all bridges of the node with their datapath
+// type, uplink ports, i.e. ports not created by ovs-cni with system or dpdk
+// interfaces, and counts of all ports and of ports created by ovs-cni
+func (ovsd *OvsDriver) GetBridgeInventory() ([]BridgeInventory, error) {
+	operations := []ovsdb.Operation{
+		{Op: "select", Table: "Bridge", Columns: []string{"name", "ports", "datapath_type"}},
+		{Op: "select", Table: "Port", Columns: []string{"_uuid", "name", "interfaces", "external_ids"}},
+		{Op: "select", Table: "Interface", Columns: []string{"_uuid", "type"}},
+	}
+	transactionResult, err := ovsd.ovsdbTransact(operations)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactionResult) != len(operations) {
+		return nil, fmt.Errorf("no transaction result")
+	}
+	for _, operationResult := range transactionResult {
+		if operationResult.Error != "" {
+			return nil, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+		}
+	}
+
+	ifaceTypes := make(map[ovsdb.UUID]string, len(transactionResult[2].Rows))
+	for _, row := range transactionResult[2].Rows {
+		ifaceType, _ := row["type"].(string)
+		ifaceTypes[row["_uuid"].(ovsdb.UUID)] = ifaceType
+	}
+	owned := map[ovsdb.UUID]bool{}
+	uplinks := map[ovsdb.UUID]string{}
+	for _, port := range transactionResult[1].Rows {
+		uuid := port["_uuid"].(ovsdb.UUID)
+		externalIDs, err := getExternalIDs(port)
+		if err != nil {
+			return nil, fmt.Errorf("get external ids: %v", err)
+		}
+		if externalIDs["owner"] == ovsPortOwner {
+			owned[uuid] = true
+			continue
+		}
+		portIfaces, err := convertToArray(port["interfaces"])
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert interfaces to an array error: %v", err)
+		}
+		for _, ifaceUUID := range portIfaces {
+			ifaceType, ok := ifaceTypes[ifaceUUID.(ovsdb.UUID)]
+			if ok && uplinkInterfaceTypes[ifaceType] {
+				uplinks[uuid] = fmt.Sprintf("%v", port["name"])
+				break
+			}
+		}
+	}
+
+	inventory := make([]BridgeInventory, 0, len(transactionResult[0].Rows))
+	for _, bridge := range transactionResult[0].Rows {
+		bridgePorts, err := convertToArray(bridge["ports"])
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert ports to an array error: %v", err)
+		}
+		name := fmt.Sprintf("%v", bridge["name"])
+		datapathType, _ := bridge["datapath_type"].(string)
+		item := BridgeInventory{Name: name, DatapathType: datapathType, Ports: len(bridgePorts)}
+		for _, port := range bridgePorts {
+			uuid := port.(ovsdb.UUID)
+			if owned[uuid] {
+				item.OwnedPorts++
+			}
+			if uplink, ok := uplinks[uuid]; ok {
+				item.Uplinks = append(item.Uplinks, uplink)
+			}
+		}
+		sort.Strings(item.Uplinks)
+		inventory = append(inventory, item)
+	}
+	sort.Slice(inventory, func(i, j int) bool { return inventory[i].Name < inventory[j].Name })
+	return inventory, nil
+}
+
 // FindInterfacesWithError returns the interfaces which are in error state
 func (ovsd *OvsDriver) FindInterfacesWithError() ([]string, error) {
 	selectOp := ovsdb.Operation{
@@ -714,9 +1863,6 @@ func (ovsd *OvsDriver) FindInterfacesWithError() ([]string, error) {
 		}
 		names = append(names, fmt.Sprintf("%v", row["name"]))
 	}
-	if len(names) > 0 {
-		log.Printf("found %d interfaces with error", len(names))
-	}
 	return names, nil
 }
 
@@ -730,6 +1876,49 @@ func hasError(row map[string]interface{}) bool {
 	}
 }
 
+// WatchPorts monitors ports and interfaces and calls onChange with the name
+// of every row which is added, removed or changes its link state. It is meant
+// for long-running processes which keep the driver open.
+func (ovsd *OvsDriver) WatchPorts(onChange func(name string)) error {
+	ovsd.ovsClient.Cache().AddEventHandler(&cache.EventHandlerFuncs{
+		AddFunc: func(table string, row model.Model) {
+			if name, ok := watchedRowName(row); ok {
+				onChange(name)
+			}
+		},
+		UpdateFunc: func(table string, old, new model.Model) {
+			if name, ok := watchedRowName(new); ok {
+				onChange(name)
+			}
+		},
+		DeleteFunc: func(table string, row model.Model) {
+			if name, ok := watchedRowName(row); ok {
+				onChange(name)
+			}
+		},
+	})
+	port := &Port{}
+	iface := &Interface{}
+	monitor := ovsd.ovsClient.NewMonitor(
+		client.WithTable(port, &port.Name),
+		client.WithTable(iface, &iface.Name, &iface.LinkState),
+	)
+	if _, err := ovsd.ovsClient.Monitor(context.Background(), monitor); err != nil {
+		return fmt.Errorf("failed to monitor ports: %v", err)
+	}
+	return nil
+}
+
+func watchedRowName(row model.Model) (string, bool) {
+	switch row := row.(type) {
+	case *Port:
+		return row.Name, true
+	case *Interface:
+		return row.Name, true
+	}
+	return "", false
+}
+
 // ************************ Notification handler for OVS DB changes ****************
 
 // Update yet to be implemented
@@ -816,7 +2005,7 @@ func (ovsd *OvsDriver) isMirrorExistsByConditions(conditions []ovsdb.Condition)
 	return true, nil
 }
 
-func createInterfaceOperation(intfName string, ofportRequest uint, ovnPortName string, intfType string) (ovsdb.UUID, *ovsdb.Operation, error) {
+func createInterfaceOperation(intfName string, ofportRequest uint, ovnPortName string, intfType string, intfOptions map[string]string) (ovsdb.UUID, *ovsdb.Operation, error) {
 	intfUUIDStr := fmt.Sprintf("Intf%s", intfName)
 	intfUUID := ovsdb.UUID{GoUUID: intfUUIDStr}
 
@@ -828,13 +2017,24 @@ func createInterfaceOperation(intfName string, ofportRequest uint, ovnPortName s
 		intf["type"] = intfType
 	}
 
+	externalIDs := creationExternalIDs()
 	// Configure interface ID for ovn
 	if ovnPortName != "" {
-		oMap, err := ovsdb.NewOvsMap(map[string]string{"iface-id": ovnPortName})
+		externalIDs["iface-id"] = ovnPortName
+	}
+	oMap, err := ovsdb.NewOvsMap(externalIDs)
+	if err != nil {
+		return ovsdb.UUID{}, nil, err
+	}
+	intf["external_ids"] = oMap
+
+	// Type specific options, e.g. vhost-server-path of vhost-user interfaces
+	if len(intfOptions) > 0 {
+		options, err := ovsdb.NewOvsMap(intfOptions)
 		if err != nil {
 			return ovsdb.UUID{}, nil, err
 		}
-		intf["external_ids"] = oMap
+		intf["options"] = options
 	}
 
 	// Requested OpenFlow port number for this interface
@@ -853,19 +2053,26 @@ func createInterfaceOperation(intfName string, ofportRequest uint, ovnPortName s
 	return intfUUID, &intfOp, nil
 }
 
-func createPortOperation(intfName, contNetnsPath, contIfaceName string, vlanTag uint, trunks []uint, portType string, intfUUID ovsdb.UUID, contPodUid string) (ovsdb.UUID, *ovsdb.Operation, error) {
-	portUUIDStr := intfName
+func createPortOperation(options PortOptions, intfUUID ovsdb.UUID) (ovsdb.UUID, *ovsdb.Operation, error) {
+	portUUIDStr := options.Name
 	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
 
 	port := make(map[string]interface{})
-	port["name"] = intfName
+	port["name"] = options.Name
 
-	port["vlan_mode"] = portType
+	port["vlan_mode"] = options.VlanMode
 	var err error
-	if portType == "access" {
-		port["tag"] = vlanTag
-	} else if len(trunks) > 0 {
-		port["trunks"], err = ovsdb.NewOvsSet(trunks)
+	if options.VlanMode == "access" || options.VlanMode == "native-tagged" || options.VlanMode == "native-untagged" || options.VlanMode == "dot1q-tunnel" {
+		port["tag"] = options.VlanTag
+	}
+	if options.VlanMode != "access" && len(options.Trunks) > 0 {
+		port["trunks"], err = ovsdb.NewOvsSet(options.Trunks)
+		if err != nil {
+			return ovsdb.UUID{}, nil, err
+		}
+	}
+	if options.QinQEthType != "" {
+		port["other_config"], err = ovsdb.NewOvsMap(map[string]string{QinQEthTypeKey: options.QinQEthType})
 		if err != nil {
 			return ovsdb.UUID{}, nil, err
 		}
@@ -876,12 +2083,18 @@ func createPortOperation(intfName, contNetnsPath, contIfaceName string, vlanTag
 		return ovsdb.UUID{}, nil, err
 	}
 
-	oMap, err := ovsdb.NewOvsMap(map[string]string{
-		"contPodUid": contPodUid,
-		"contNetns":  contNetnsPath,
-		"contIface":  contIfaceName,
-		"owner":      ovsPortOwner,
-	})
+	externalIDs := creationExternalIDs()
+	externalIDs["contPodUid"] = options.ContPodUID
+	externalIDs["contNetns"] = options.ContNetns
+	externalIDs["contIface"] = options.ContIface
+	if options.ContNetwork != "" {
+		externalIDs["contNetwork"] = options.ContNetwork
+	}
+	if options.ContID != "" {
+		externalIDs[ContainerIDKey] = options.ContID
+	}
+	externalIDs["owner"] = ovsPortOwner
+	oMap, err := ovsdb.NewOvsMap(externalIDs)
 	if err != nil {
 		return ovsdb.UUID{}, nil, err
 	}
@@ -898,6 +2111,15 @@ func createPortOperation(intfName, contNetnsPath, contIfaceName string, vlanTag
 	return portUUID, &portOp, nil
 }
 
+// creationExternalIDs returns the external_ids common to all rows created by ovs-cni,
+// they are used for age based cleanup and for debugging of mixed version nodes.
+func creationExternalIDs() map[string]string {
+	return map[string]string{
+		CreationTimeKey: time.Now().UTC().Format(time.RFC3339),
+		VersionKey:      buildversion.BuildVersion,
+	}
+}
+
 func attachPortOperation(portUUID ovsdb.UUID, bridgeName string) *ovsdb.Operation {
 	// mutate the Ports column of the row in the Bridge table
 	mutateSet, _ := ovsdb.NewOvsSet(portUUID)
@@ -950,6 +2172,32 @@ func detachPortOperation(portUUID ovsdb.UUID, bridgeName string) *ovsdb.Operatio
 	return &mutateOp
 }
 
+// quarantinedPortOperation inserts a copy of the VLAN settings of the port
+// row under the new name with the given external_ids
+func quarantinedPortOperation(row map[string]interface{}, newName string, externalIDs map[string]string, intfUUID ovsdb.UUID) (ovsdb.UUID, *ovsdb.Operation, error) {
+	portUUIDStr := newName
+	port := map[string]interface{}{"name": newName}
+	for _, column := range []string{"vlan_mode", "tag", "trunks", "other_config"} {
+		if value, found := row[column]; found {
+			port[column] = value
+		}
+	}
+	var err error
+	if port["interfaces"], err = ovsdb.NewOvsSet(intfUUID); err != nil {
+		return ovsdb.UUID{}, nil, err
+	}
+	if port["external_ids"], err = ovsdb.NewOvsMap(externalIDs); err != nil {
+		return ovsdb.UUID{}, nil, err
+	}
+	portOp := ovsdb.Operation{
+		Op:       "insert",
+		Table:    "Port",
+		Row:      port,
+		UUIDName: portUUIDStr,
+	}
+	return ovsdb.UUID{GoUUID: portUUIDStr}, &portOp, nil
+}
+
 func createMirrorOperation(mirrorName string) (ovsdb.UUID, *ovsdb.Operation, error) {
 	// Create an operation 'named-uuid' with a simple string as defined in RFC7047.
 	// Spec states that 'uuid-name is only meaningful within the scope of a single transaction'.
@@ -1092,14 +2340,14 @@ func detachMirrorFromBridgeOperation(mirrorUUID ovsdb.UUID, bridgeName string) *
 	return &mutateOp
 }
 
-// findEmptyMirrors returns the empty mirrors (no select_src_port, select_dst_port and output ports)
+// findEmptyMirrors returns the empty mirrors (no select_src_port, select_dst_port, output ports and output_vlan)
 func (ovsd *OvsDriver) findEmptyMirrors() ([]string, error) {
 	var names []string
 
 	// get all mirrors
 	selectOp := ovsdb.Operation{
 		Op:      "select",
-		Columns: []string{"name", "output_port", "select_src_port", "select_dst_port"},
+		Columns: []string{"name", "output_port", "output_vlan", "select_src_port", "select_dst_port"},
 		Table:   "Mirror",
 	}
 	transactionResult, err := ovsd.ovsdbTransact([]ovsdb.Operation{selectOp})
@@ -1151,10 +2399,23 @@ func isMirrorEmpty(dbRow map[string]interface{}) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("cannot convert output_port to an array error: %v", err)
 	}
-	isEmpty := len(selectSrcPorts) == 0 && len(selectDstPorts) == 0 && len(outputPorts) == 0
+	isEmpty := len(selectSrcPorts) == 0 && len(selectDstPorts) == 0 && len(outputPorts) == 0 && !hasOutputVlan(dbRow)
 	return isEmpty, nil
 }
 
+// hasOutputVlan Checks if output_vlan of a mirror db row is set, it is an
+// empty ovsdb.OvsSet when unset and a number otherwise
+func hasOutputVlan(dbRow map[string]interface{}) bool {
+	switch outputVlan := dbRow["output_vlan"].(type) {
+	case nil:
+		return false
+	case ovsdb.OvsSet:
+		return len(outputVlan.GoSet) > 0
+	default:
+		return true
+	}
+}
+
 // utility function to convert an element (UUID or OvsSet) to an array of UUIDs
 func convertToArray(elem interface{}) ([]interface{}, error) {
 	elemType := reflect.TypeOf(elem)
@@ -0,0 +1,247 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"time"
+
+	"github.com/ovn-org/libovsdb/ovsdb"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+)
+
+const testBridge = "br-test"
+
+// newFakeDriver returns a driver of the bridge of a fake OVSDB
+func newFakeDriver() (*OvsBridgeDriver, *testhelpers.FakeOVSDB) {
+	fake, err := testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), testBridge)
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(fake.Close)
+	driver, err := NewOvsBridgeDriver(testBridge, fake.Endpoint)
+	Expect(err).NotTo(HaveOccurred())
+	return driver, fake
+}
+
+// rowsOf returns rows of the table in the fake OVSDB
+func rowsOf(fake *testhelpers.FakeOVSDB, table string, where ...ovsdb.Condition) []ovsdb.Row {
+	rows, err := fake.Select(table, where...)
+	Expect(err).NotTo(HaveOccurred())
+	return rows
+}
+
+var _ = Describe("OVSDB driver", func() {
+	It("should fail for a missing bridge", func() {
+		fake, err := testhelpers.NewFakeOVSDB(GinkgoT().TempDir())
+		Expect(err).NotTo(HaveOccurred())
+		defer fake.Close()
+		_, err = NewOvsBridgeDriver(testBridge, fake.Endpoint)
+		Expect(err).To(MatchError(ContainSubstring("failed to find bridge")))
+	})
+	It("should create, find and delete ports of containers", func() {
+		driver, fake := newFakeDriver()
+		Expect(driver.CreatePort(PortOptions{Name: "veth1", ContNetns: "/var/run/netns/ns1", ContIface: "eth0", ContNetwork: "net1", ContPodUID: "uid1", ContID: "cid1", VlanTag: 10})).To(Succeed())
+
+		portName, found, err := driver.GetOvsPortForContIface("eth0", "/var/run/netns/ns1", "net1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(portName).To(Equal("veth1"))
+		_, found, err = driver.GetOvsPortForContIface("eth0", "/var/run/netns/ns1", "net2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+
+		Expect(driver.DeletePort("veth1")).To(Succeed())
+		Expect(rowsOf(fake, "Port")).To(BeEmpty())
+		Expect(rowsOf(fake, "Interface")).To(BeEmpty())
+	})
+	It("should quarantine a port under a new name keeping its VLAN and identity", func() {
+		driver, fake := newFakeDriver()
+		Expect(driver.CreatePort(PortOptions{Name: "veth1", ContNetns: "/var/run/netns/ns1", ContIface: "eth0", ContNetwork: "net1",
+			OvnPort: "ovn1", VlanTag: 10, VlanMode: "access"})).To(Succeed())
+		expiry := time.Now().Add(time.Minute)
+		Expect(driver.QuarantinePort("veth1", "qh1", expiry)).To(Succeed())
+
+		Expect(rowsOf(fake, "Port", ovsdb.NewCondition("name", ovsdb.ConditionEqual, "veth1"))).To(BeEmpty())
+		ports := rowsOf(fake, "Port", ovsdb.NewCondition("name", ovsdb.ConditionEqual, "qh1"))
+		Expect(ports).To(HaveLen(1))
+		Expect(ports[0]["tag"]).To(BeEquivalentTo(10))
+		Expect(ports[0]["vlan_mode"]).To(Equal("access"))
+		externalIDs, err := getExternalIDs(ports[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(externalIDs).To(HaveKeyWithValue("contIface", "eth0"))
+		Expect(externalIDs).To(HaveKeyWithValue(QuarantineExpiryKey, expiry.UTC().Format(time.RFC3339)))
+		intfs := rowsOf(fake, "Interface")
+		Expect(intfs).To(HaveLen(1))
+		Expect(intfs[0]["name"]).To(Equal("qh1"))
+		intfExternalIDs, err := getExternalIDs(intfs[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(intfExternalIDs).NotTo(HaveKey("iface-id"))
+
+		expired, err := driver.FindExpiredQuarantinedPorts(expiry.Add(time.Second))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expired).To(ConsistOf("qh1"))
+	})
+	It("should create dot1q-tunnel ports with the ethertype of the S-VLAN", func() {
+		driver, _ := newFakeDriver()
+		Expect(driver.CreatePort(PortOptions{Name: "veth1", ContNetns: "/var/run/netns/ns1", ContIface: "eth0", VlanTag: 100, VlanMode: "dot1q-tunnel", QinQEthType: "802.1q"})).To(Succeed())
+		Expect(driver.CreatePort(PortOptions{Name: "veth2", ContNetns: "/var/run/netns/ns1", ContIface: "eth1", VlanTag: 100, VlanMode: "access"})).To(Succeed())
+
+		vlanMode, tag, _, err := driver.GetOFPortVlanState("veth1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vlanMode).To(Equal("dot1q-tunnel"))
+		Expect(tag).To(HaveValue(Equal(uint(100))))
+		ethType, err := driver.GetPortQinQEthType("veth1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ethType).To(Equal("802.1q"))
+		ethType, err = driver.GetPortQinQEthType("veth2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ethType).To(BeEmpty())
+		_, err = driver.GetPortQinQEthType("missing")
+		Expect(err).To(MatchError("port missing not found"))
+	})
+	It("should list ports created by ovs-cni with the state of their interfaces", func() {
+		driver, fake := newFakeDriver()
+		Expect(driver.CreatePort(PortOptions{Name: "veth1", ContNetns: "/var/run/netns/ns1", ContIface: "eth0", ContNetwork: "net1", ContID: "cid1"})).To(Succeed())
+		Expect(driver.CreatePort(PortOptions{Name: "veth2", ContNetns: "/var/run/netns/ns1", ContIface: "eth1", ContNetwork: "net1", ContID: "cid1", IntfType: "internal"})).To(Succeed())
+		_, err := fake.Transact(
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Interface", Row: ovsdb.Row{"name": "foreign"}, UUIDName: "intf"},
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Port", Row: ovsdb.Row{"name": "foreign", "interfaces": ovsdb.UUID{GoUUID: "intf"}}, UUIDName: "port"},
+			ovsdb.Operation{
+				Op:        ovsdb.OperationMutate,
+				Table:     "Bridge",
+				Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("ports", ovsdb.MutateOperationInsert, ovsdb.UUID{GoUUID: "port"})},
+				Where:     []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, testBridge)},
+			},
+			ovsdb.Operation{
+				Op:    ovsdb.OperationUpdate,
+				Table: "Interface",
+				Row: ovsdb.Row{
+					"error":      "could not open network device veth1 (No such device)",
+					"statistics": ovsdb.OvsMap{GoMap: map[interface{}]interface{}{"rx_bytes": 100, "tx_bytes": 200}},
+				},
+				Where: []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, "veth1")},
+			})
+		Expect(err).NotTo(HaveOccurred())
+
+		ports, err := driver.GetOwnedPorts()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ports).To(HaveLen(2))
+		byName := map[string]OwnedPort{}
+		for _, port := range ports {
+			byName[port.Name] = port
+		}
+		Expect(byName).To(HaveKey("veth1"))
+		Expect(byName).To(HaveKey("veth2"))
+		veth1 := byName["veth1"]
+		Expect(veth1.Bridge).To(Equal(testBridge))
+		Expect(veth1.ExternalIDs).To(HaveKeyWithValue("contIface", "eth0"))
+		Expect(veth1.ExternalIDs).To(HaveKeyWithValue("contNetwork", "net1"))
+		Expect(veth1.Error).To(Equal("could not open network device veth1 (No such device)"))
+		Expect(veth1.InterfaceType).To(BeEmpty())
+		Expect(veth1.Statistics).To(Equal(map[string]uint64{"rx_bytes": 100, "tx_bytes": 200}))
+		veth2 := byName["veth2"]
+		Expect(veth2.Error).To(BeEmpty())
+		Expect(veth2.InterfaceType).To(Equal("internal"))
+		Expect(veth2.Statistics).To(BeEmpty())
+	})
+	It("should find DPDK interfaces by their devargs", func() {
+		driver, fake := newFakeDriver()
+		// interfaces not referenced by a port of a bridge are garbage collected
+		addDpdkPort := func(name, devargs string) {
+			_, err := fake.Transact(
+				ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Interface", UUIDName: "intf", Row: ovsdb.Row{
+					"name":    name,
+					"type":    "dpdk",
+					"options": ovsdb.OvsMap{GoMap: map[interface{}]interface{}{"dpdk-devargs": devargs, "n_rxq": "2"}},
+				}},
+				ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Port", Row: ovsdb.Row{"name": name, "interfaces": ovsdb.UUID{GoUUID: "intf"}}, UUIDName: "port"},
+				ovsdb.Operation{
+					Op:        ovsdb.OperationMutate,
+					Table:     "Bridge",
+					Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("ports", ovsdb.MutateOperationInsert, ovsdb.UUID{GoUUID: "port"})},
+					Where:     []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, testBridge)},
+				})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		addDpdkPort("dpdk0", "0000:03:00.0,representor=[2]")
+		addDpdkPort("dpdk1", "0000:03:00.0,representor=[3]")
+
+		name, err := driver.GetInterfaceNameByDpdkDevargs("0000:03:00.0,representor=[3]")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("dpdk1"))
+		_, err = driver.GetInterfaceNameByDpdkDevargs("0000:03:00.0,representor=[4]")
+		Expect(err).To(MatchError(errObjectNotFound))
+		_, err = driver.GetInterfaceNameByDpdkDevargs("0000:03:00.0")
+		Expect(err).To(MatchError(errObjectNotFound))
+	})
+	It("should refuse to delete ports not created by ovs-cni", func() {
+		driver, fake := newFakeDriver()
+		_, err := fake.Transact(
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Interface", Row: ovsdb.Row{"name": "foreign"}, UUIDName: "intf"},
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Port", Row: ovsdb.Row{"name": "foreign", "interfaces": ovsdb.UUID{GoUUID: "intf"}}, UUIDName: "port"},
+			ovsdb.Operation{
+				Op:        ovsdb.OperationMutate,
+				Table:     "Bridge",
+				Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("ports", ovsdb.MutateOperationInsert, ovsdb.UUID{GoUUID: "port"})},
+				Where:     []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, testBridge)},
+			})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driver.DeletePort("foreign")).To(MatchError(ErrNotOwned))
+		Expect(rowsOf(fake, "Port")).To(HaveLen(1))
+	})
+	Context("with QoS of a port", func() {
+		var driver *OvsBridgeDriver
+		var fake *testhelpers.FakeOVSDB
+		BeforeEach(func() {
+			driver, fake = newFakeDriver()
+			Expect(driver.CreatePort(PortOptions{Name: "veth1", ContNetns: "/var/run/netns/ns1", ContIface: "eth0", ContNetwork: "net1", ContID: "cid1"})).To(Succeed())
+		})
+		It("should replace and remove its own QoS", func() {
+			Expect(driver.SetPortQoS("veth1", "linux-htb", map[string]string{"max-rate": "1000"}, map[string]string{"max-rate": "1000"})).To(Succeed())
+			Expect(driver.SetPortQoS("veth1", "linux-htb", map[string]string{"max-rate": "2000"}, map[string]string{"max-rate": "2000"})).To(Succeed())
+			Expect(rowsOf(fake, "QoS")).To(HaveLen(1))
+			Expect(rowsOf(fake, "Queue")).To(HaveLen(1))
+			port := rowsOf(fake, "Port", ovsdb.NewCondition("name", ovsdb.ConditionEqual, "veth1"))
+			Expect(port[0]["qos"]).To(Equal(rowsOf(fake, "QoS")[0]["_uuid"]))
+
+			Expect(driver.DeletePortQoS("veth1")).To(Succeed())
+			Expect(rowsOf(fake, "QoS")).To(BeEmpty())
+			Expect(rowsOf(fake, "Queue")).To(BeEmpty())
+			port = rowsOf(fake, "Port", ovsdb.NewCondition("name", ovsdb.ConditionEqual, "veth1"))
+			Expect(port[0]["qos"]).To(Equal(ovsdb.OvsSet{GoSet: []interface{}{}}))
+		})
+		It("should keep QoS set on the port by others", func() {
+			Expect(driver.SetPortQoS("veth1", "linux-htb", map[string]string{"max-rate": "1000"}, nil)).To(Succeed())
+			_, err := fake.Transact(
+				ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "QoS", Row: ovsdb.Row{"type": "linux-hfsc"}, UUIDName: "foreign"},
+				ovsdb.Operation{
+					Op:    ovsdb.OperationUpdate,
+					Table: "Port",
+					Row:   ovsdb.Row{"qos": ovsdb.UUID{GoUUID: "foreign"}},
+					Where: []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, "veth1")},
+				})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(driver.DeletePortQoS("veth1")).To(Succeed())
+			qos := rowsOf(fake, "QoS")
+			Expect(qos).To(HaveLen(1))
+			Expect(qos[0]["type"]).To(Equal("linux-hfsc"))
+			port := rowsOf(fake, "Port", ovsdb.NewCondition("name", ovsdb.ConditionEqual, "veth1"))
+			Expect(port[0]["qos"]).To(Equal(qos[0]["_uuid"]))
+		})
+	})
+})
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"fmt"
+
+	"github.com/ovn-org/libovsdb/ovsdb"
+)
+
+// PortQoSKey is the external_ids key of QoS and Queue rows created by
+// ovs-cni holding the name of the port whose traffic they shape
+const PortQoSKey = "ovs-cni.port"
+
+// QoSTypeEgressPolicer is the type of QoS policing traffic sent out of DPDK
+// ports, linux-htb and linux-hfsc are the values of qos.type of the network
+// configuration
+const QoSTypeEgressPolicer = "egress-policer"
+
+// SetPortQoS shapes traffic OVS sends out of the port by a QoS of qosType
+// with qosConfig as its other_config. With queueConfig, the QoS gets queue 0
+// with it as its other_config, linux-htb and linux-hfsc leave traffic of
+// queues which don't exist unshaped. QoS set on the port by ovs-cni before is replaced.
+func (ovsd *OvsBridgeDriver) SetPortQoS(portName, qosType string, qosConfig, queueConfig map[string]string) error {
+	externalIDs, err := ovsdb.NewOvsMap(portQoSExternalIDs(portName))
+	if err != nil {
+		return err
+	}
+	// the port is updated to the new QoS in the same transaction
+	operations := deletePortQoSOperations(externalIDs)
+
+	qos := map[string]interface{}{"type": qosType, "external_ids": externalIDs}
+	if qos["other_config"], err = ovsdb.NewOvsMap(qosConfig); err != nil {
+		return err
+	}
+	if queueConfig != nil {
+		queue := map[string]interface{}{"external_ids": externalIDs}
+		if queue["other_config"], err = ovsdb.NewOvsMap(queueConfig); err != nil {
+			return err
+		}
+		operations = append(operations, ovsdb.Operation{Op: "insert", Table: "Queue", Row: queue, UUIDName: "queue0"})
+		if qos["queues"], err = ovsdb.NewOvsMap(map[int]ovsdb.UUID{0: {GoUUID: "queue0"}}); err != nil {
+			return err
+		}
+	}
+	operations = append(operations,
+		ovsdb.Operation{Op: "insert", Table: "QoS", Row: qos, UUIDName: "qos"},
+		ovsdb.Operation{
+			Op:    "update",
+			Table: portTable,
+			Row:   map[string]interface{}{"qos": ovsdb.UUID{GoUUID: "qos"}},
+			Where: []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)},
+		})
+	result, err := ovsd.ovsdbTransact(operations)
+	if err != nil {
+		return err
+	}
+	// QoS is a root table, it would be kept without the port
+	if result[len(operations)-1].Count == 0 {
+		return fmt.Errorf("port %s not found", portName)
+	}
+	return nil
+}
+
+// DeletePortQoS removes QoS set on the port by SetPortQoS, QoS and queues
+// are not removed together with the port. QoS set on the port by others is
+// kept.
+func (ovsd *OvsBridgeDriver) DeletePortQoS(portName string) error {
+	externalIDs, err := ovsdb.NewOvsMap(portQoSExternalIDs(portName))
+	if err != nil {
+		return err
+	}
+	operations, err := ovsd.clearPortQoSOperations(portName, externalIDs)
+	if err != nil {
+		return err
+	}
+	_, err = ovsd.ovsdbTransact(append(operations, deletePortQoSOperations(externalIDs)...))
+	return err
+}
+
+// portQoSExternalIDs returns external_ids of QoS and Queue rows shaping
+// traffic of the port
+func portQoSExternalIDs(portName string) map[string]string {
+	return map[string]string{"owner": ovsPortOwner, PortQoSKey: portName}
+}
+
+// clearPortQoSOperations returns operations clearing the qos column of the
+// port while it references QoS created by ovs-cni, so QoS set by an
+// administrator or another controller after ADD is left in place
+func (ovsd *OvsDriver) clearPortQoSOperations(portName string, externalIDs ovsdb.OvsMap) ([]ovsdb.Operation, error) {
+	owned := ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, externalIDs)
+	result, err := ovsd.ovsdbTransact([]ovsdb.Operation{{Op: "select", Table: "QoS", Columns: []string{"_uuid"}, Where: []ovsdb.Condition{owned}}})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != 1 {
+		return nil, fmt.Errorf("no transaction result")
+	}
+	if result[0].Error != "" {
+		return nil, fmt.Errorf("%s - %s", result[0].Error, result[0].Details)
+	}
+
+	var operations []ovsdb.Operation
+	for _, row := range result[0].Rows {
+		qosUUID, ok := row["_uuid"].(ovsdb.UUID)
+		if !ok {
+			continue
+		}
+		operations = append(operations, ovsdb.Operation{
+			Op:    "update",
+			Table: portTable,
+			Row:   map[string]interface{}{"qos": ovsdb.OvsSet{GoSet: []interface{}{}}},
+			Where: []ovsdb.Condition{
+				ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName),
+				ovsdb.NewCondition("qos", ovsdb.ConditionEqual, qosUUID),
+			},
+		})
+	}
+	return operations, nil
+}
+
+// deletePortQoSOperations returns operations removing QoS and Queue rows
+// with the external_ids, they must not be referenced by the port anymore
+func deletePortQoSOperations(externalIDs ovsdb.OvsMap) []ovsdb.Operation {
+	owned := ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, externalIDs)
+	return []ovsdb.Operation{
+		{Op: "delete", Table: "QoS", Where: []ovsdb.Condition{owned}},
+		{Op: "delete", Table: "Queue", Where: []ovsdb.Condition{owned}},
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+)
+
+// addAltNames adds alternative names to the interface, names it already has
+// are kept, so ADD can be retried. Must run in the netns of the interface.
+func addAltNames(ifName string, altNames []string) error {
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	for _, altName := range altNames {
+		err := netif.Default.LinkAddAltName(link, altName)
+		if errors.Is(err, unix.EEXIST) {
+			// names and altnames share a namespace, the kernel resolves both
+			if owner, lookupErr := netif.Default.LinkByName(altName); lookupErr == nil && owner.Attrs().Index == link.Attrs().Index {
+				continue
+			}
+			return fmt.Errorf("failed to add altname %q to %q: name is used by another interface", altName, ifName)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add altname %q to %q: %v", altName, ifName, err)
+		}
+	}
+	return nil
+}
+
+// delAltNames removes alternative names from the container interface before
+// it is moved back to the host, e.g. a VF, so they don't clash with ones of
+// other interfaces there. Failures are only logged.
+func delAltNames(netnsPath, ifName string, altNames []string) {
+	err := netns.WithPath(netnsPath, func(_ ns.NetNS) error {
+		return delLinkAltNames(ifName, altNames)
+	})
+	if err != nil {
+		log.Printf("Failed to remove altnames of %q: %v", ifName, err)
+	}
+}
+
+// delVFAltNames removes alternative names from a VF which is already back in
+// the host netns, e.g. when the container netns is gone. Failures are only
+// logged.
+func delVFAltNames(deviceID string, altNames []string) {
+	ifName, err := sriov.GetVFLinkName(deviceID)
+	if err == nil {
+		err = delLinkAltNames(ifName, altNames)
+	}
+	if err != nil {
+		log.Printf("Failed to remove altnames of VF %s: %v", deviceID, err)
+	}
+}
+
+// delLinkAltNames removes alternative names from the interface in the
+// current netns, names it doesn't have are skipped
+func delLinkAltNames(ifName string, altNames []string) error {
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		return err
+	}
+	for _, altName := range altNames {
+		if err := netif.Default.LinkDelAltName(link, altName); err != nil && !errors.Is(err, unix.ENOENT) {
+			log.Printf("Failed to remove altname %q of %q: %v", altName, ifName, err)
+		}
+	}
+	return nil
+}
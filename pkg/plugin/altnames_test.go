@@ -0,0 +1,99 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !simulation
+
+package plugin
+
+import (
+	"github.com/containernetworking/plugins/pkg/ns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+)
+
+var _ = Describe("Altnames", func() {
+	It("should add altnames to the container interface and remove them", func() {
+		targetNs := newNS()
+		defer closeNS(targetNs)
+
+		altNames := []string{"net1-mynet", "storage-network"}
+		err := targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net1"}, PeerName: "peer0"})).To(Succeed())
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net2"}, PeerName: "peer1"})).To(Succeed())
+
+			Expect(addAltNames("net1", altNames)).To(Succeed())
+			// retried ADD keeps altnames the interface already has
+			Expect(addAltNames("net1", altNames)).To(Succeed())
+			for _, altName := range altNames {
+				link, err := netlink.LinkByName(altName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link.Attrs().Name).To(Equal("net1"))
+			}
+
+			Expect(addAltNames("net2", altNames[:1])).To(MatchError(ContainSubstring("used by another interface")))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		delAltNames(targetNs.Path(), "net1", altNames)
+		err = targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			for _, altName := range altNames {
+				_, err := netlink.LinkByName(altName)
+				Expect(err).To(HaveOccurred())
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should remove altnames of a VF back in the host netns", func() {
+		targetNs := newNS()
+		defer closeNS(targetNs)
+		origDevices := sriov.Devices
+		defer func() { sriov.Devices = origDevices }()
+		sriov.Devices = fakeVFHost{vfs: map[string]string{"0000:03:00.2": "net1"}}
+
+		altNames := []string{"net1-mynet"}
+		err := targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net1"}, PeerName: "peer0"})).To(Succeed())
+			Expect(addAltNames("net1", altNames)).To(Succeed())
+
+			delVFAltNames("0000:03:00.2", altNames)
+			_, err := netlink.LinkByName(altNames[0])
+			Expect(err).To(HaveOccurred())
+			// VFs without a netdevice are skipped
+			delVFAltNames("0000:03:00.3", altNames)
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+// fakeVFHost resolves netdevices of VFs from a map
+type fakeVFHost struct {
+	sriov.SysfsHost
+	vfs map[string]string
+}
+
+func (h fakeVFHost) NetDevices(pciAddr string) ([]string, error) {
+	if name, found := h.vfs[pciAddr]; found {
+		return []string{name}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// backupRouteMetric is added to metrics of routes over the backup interface,
+// so they are used only while the active interface has no carrier
+const backupRouteMetric = 100
+
+// metrics the kernel gives IPv6 routes added without one
+const (
+	kernelIPv6RouteMetric  = 1024
+	kernelIPv6PrefixMetric = 256
+)
+
+// backupIfName returns name of the backup interface in the container
+func backupIfName(netconf *types.NetConf, ifName string) string {
+	if netconf.Backup.IfName != "" {
+		return netconf.Backup.IfName
+	}
+	return ifName + "b"
+}
+
+// backupMetric returns metric of the backup route of a route with the given
+// metric, 0 stands for the default metric of the kernel
+func backupMetric(metric int, isIPv6 bool, kernelIPv6Metric int) int {
+	if metric == 0 && isIPv6 {
+		metric = kernelIPv6Metric
+	}
+	return metric + backupRouteMetric
+}
+
+// setupBackup attaches the backup interface of an active/backup attachment to
+// the backup bridge. Addresses and routes of the result are configured on it
+// as well, with higher metrics, and routes over interfaces without carrier are
+// ignored, so the kernel fails over to the backup fabric when the active one
+// goes down.
+func setupBackup(args *skel.CmdArgs, netconf *types.NetConf, contNetns ns.NetNS, vlanTag uint, trunks []uint, portType, contPodUid string, result *current.Result) (hostIface, contIface *current.Interface, err error) {
+	ifName := backupIfName(netconf, args.IfName)
+	backupDriver, err := newBridgeDriver(netconf.Backup.Bridge, netconf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := removeStaleContIface(backupDriver, contNetns, ifName, netconf.Name); err != nil {
+		return nil, nil, err
+	}
+	capacityLock, err := checkBridgeCapacity(&backupDriver.OvsDriver, netconf.Backup.Bridge, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer capacityLock.Release()
+	hostIface, contIface, err = setupVeth(contNetns, ifName, "", "", netconf.MTU)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the results are nil once an error is returned
+	portName := hostIface.Name
+	defer func() {
+		if err != nil {
+			if err := delBackup(netconf, args.Netns, args.IfName, portName); err != nil {
+				log.Printf("Failed best-effort cleanup of backup interface: %v", err)
+			}
+		}
+	}()
+	if netconf.AcceptRA != nil || netconf.IPv6Autoconf != nil {
+		err = netns.Do(contNetns, func(_ ns.NetNS) error {
+			return setRASysctls(netconf, ifName)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := attachIfaceToBridge(backupDriver, ovsdb.PortOptions{
+		Name:        hostIface.Name,
+		ContNetns:   args.Netns,
+		ContIface:   ifName,
+		ContNetwork: netconf.Name,
+		ContPodUID:  contPodUid,
+		ContID:      args.ContainerID,
+		VlanTag:     vlanTag,
+		Trunks:      trunks,
+		VlanMode:    portType,
+		QinQEthType: qinqEthType(netconf),
+		IntfType:    netconf.InterfaceType,
+	}); err != nil {
+		return nil, nil, err
+	}
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		return configureBackupIface(args.IfName, ifName, result)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return hostIface, contIface, nil
+}
+
+// configureBackupIface configures addresses and routes of the result on the
+// backup interface, must run in the container netns
+func configureBackupIface(activeIfName, ifName string, result *current.Result) error {
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	for _, name := range []string{activeIfName, ifName} {
+		for _, family := range []string{"ipv4", "ipv6"} {
+			if _, err := netif.Default.Sysctl(fmt.Sprintf("net/%s/conf/%s/ignore_routes_with_linkdown", family, name), "1"); err != nil {
+				return fmt.Errorf("failed to ignore routes of %s without carrier: %v", name, err)
+			}
+		}
+	}
+	if err := netif.Default.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set %q up: %v", ifName, err)
+	}
+	for _, ipc := range result.IPs {
+		isIPv6 := ipc.Address.IP.To4() == nil
+		addr := &netlink.Addr{IPNet: &ipc.Address, Flags: unix.IFA_F_NOPREFIXROUTE}
+		if isIPv6 {
+			// the address is already in use by the active interface
+			addr.Flags |= unix.IFA_F_NODAD
+		}
+		if err := netif.Default.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("failed to add address %v to %q: %v", ipc.Address, ifName, err)
+		}
+		prefix := &net.IPNet{IP: ipc.Address.IP.Mask(ipc.Address.Mask), Mask: ipc.Address.Mask}
+		route := &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       prefix,
+			Src:       ipc.Address.IP,
+			Scope:     netlink.SCOPE_LINK,
+			Priority:  backupMetric(0, isIPv6, kernelIPv6PrefixMetric),
+		}
+		if isIPv6 {
+			route.Src = nil
+		}
+		if err := netif.Default.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to add route %v to %q: %v", prefix, ifName, err)
+		}
+	}
+	v4gw, v6gw := familyGateways(result.IPs)
+	for _, r := range result.Routes {
+		route := &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       &r.Dst,
+			Gw:        routeGateway(r, v4gw, v6gw),
+			Priority:  backupMetric(r.Priority, r.Dst.IP.To4() == nil, kernelIPv6RouteMetric),
+		}
+		if err := netif.Default.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to add route %v via %s to %q: %v", r.Dst, route.Gw, ifName, err)
+		}
+	}
+	return nil
+}
+
+// delBackup removes the backup interface and its port on the backup bridge,
+// resources which are already gone are ignored
+func delBackup(netconf *types.NetConf, contNetnsPath, ifName, portName string) error {
+	err := netns.WithPath(contNetnsPath, func(ns.NetNS) error {
+		return netif.Default.DelLinkByName(backupIfName(netconf, ifName))
+	})
+	if err != nil && !netns.IsGone(err) && err != ip.ErrLinkNotFound {
+		return err
+	}
+	if portName == "" {
+		return nil
+	}
+	backupDriver, err := newBridgeDriver(netconf.Backup.Bridge, netconf)
+	if err != nil {
+		return err
+	}
+	if _, err := backupDriver.GetPortUUID(portName); err != nil {
+		return nil
+	}
+	if err := removeOvsPort(backupDriver, portName); err != nil {
+		return err
+	}
+	if err := netif.Default.DelLinkByName(portName); err != nil && err != ip.ErrLinkNotFound {
+		log.Printf("Failed best-effort cleanup of %s: %v", portName, err)
+	}
+	return nil
+}
+
+// checkBackup verifies the port of the backup interface on the backup bridge
+func checkBackup(netconf *types.NetConf, portName string) error {
+	backupDriver, err := newBridgeDriver(netconf.Backup.Bridge, netconf)
+	if err != nil {
+		return err
+	}
+	if _, err := backupDriver.GetPortUUID(portName); err != nil {
+		return fmt.Errorf("backup port %s is not found on bridge %s: %v", portName, netconf.Backup.Bridge, err)
+	}
+	return nil
+}
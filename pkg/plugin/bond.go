@@ -0,0 +1,241 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// bondLockTimeout limits waiting for another attachment of the pod joining
+// or leaving the same bond
+const bondLockTimeout = 30 * time.Second
+
+// bondStore caches members of bonds, shared by attachments of the pod. Its
+// keys are hidden like other cache entries which aren't attachments.
+var bondStore = utils.NewStore[types.CachedBond]("", ".bond-", 0)
+
+// bondCacheKey returns the cache key of members of the bond. Unlike keys of
+// attachments it is not scoped by a network, as the bond is shared by
+// attachments of the pod to several networks.
+func bondCacheKey(containerID, bondName string) string {
+	return config.GetCRef(containerID, bondName)
+}
+
+// lockBond serializes ADD and DEL of attachments of the pod sharing the bond,
+// the runtime may run them concurrently
+func lockBond(containerID, bondName string) (*utils.Lock, error) {
+	lock, err := utils.AcquireLock("bond-"+bondCacheKey(containerID, bondName), bondLockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock bond %s: %v", bondName, err)
+	}
+	return lock, nil
+}
+
+// readBond returns interfaces enslaved to the bond by attachments and the
+// addresses they configured on it, none before the first one joins it
+func readBond(containerID, bondName string) *types.CachedBond {
+	cached, err := bondStore.Load(bondCacheKey(containerID, bondName))
+	if err != nil {
+		return &types.CachedBond{}
+	}
+	return cached
+}
+
+// ensureBond returns the bond in the container netns, creating it when it
+// doesn't exist yet. Members take over MTU of the bond, so it gets the one of
+// the first member.
+func ensureBond(bond *types.Bond, mtu int) (netlink.Link, error) {
+	if link, err := netif.Default.LinkByName(bond.Name); err == nil {
+		if link.Type() != "bond" {
+			return nil, fmt.Errorf("interface %s already exists in container netns and it is not a bond", bond.Name)
+		}
+		return link, nil
+	}
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = bond.Name
+	attrs.MTU = mtu
+	link := netlink.NewLinkBond(attrs)
+	link.Mode = netlink.StringToBondMode(bond.Mode)
+	if bond.Miimon != nil {
+		link.Miimon = int(*bond.Miimon)
+	}
+	if err := netif.Default.LinkAdd(link); err != nil {
+		return nil, fmt.Errorf("failed to create bond %s: %v", bond.Name, err)
+	}
+	return netif.Default.LinkByName(bond.Name)
+}
+
+// joinBond enslaves the container interface of the attachment to the bond,
+// creating it when the attachment is the first of the pod to join. Members
+// are recorded in the cache, so the bond is removed with the last of them.
+// The container interface takes over MAC of the bond.
+func joinBond(contNetns ns.NetNS, containerID string, contIface *current.Interface, bond *types.Bond) (*current.Interface, error) {
+	lock, err := lockBond(containerID, bond.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	bondIface := &current.Interface{Name: bond.Name, Sandbox: contNetns.Path()}
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		bondLink, err := ensureBond(bond, contIface.Mtu)
+		if err != nil {
+			return err
+		}
+		link, err := netif.Default.LinkByName(contIface.Name)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", contIface.Name, err)
+		}
+		// an interface must be down to be enslaved
+		if err := netif.Default.LinkSetDown(link); err != nil {
+			return fmt.Errorf("failed to set %q down: %v", contIface.Name, err)
+		}
+		if err := netif.Default.LinkSetMasterByIndex(link, bondLink.Attrs().Index); err != nil {
+			return fmt.Errorf("failed to enslave %q to bond %s: %v", contIface.Name, bond.Name, err)
+		}
+		if err := netif.Default.LinkSetUp(link); err != nil {
+			return fmt.Errorf("failed to set %q up: %v", contIface.Name, err)
+		}
+		if err := netif.Default.LinkSetUp(bondLink); err != nil {
+			return fmt.Errorf("failed to set bond %s up: %v", bond.Name, err)
+		}
+		if bondLink, err = netif.Default.LinkByName(bond.Name); err != nil {
+			return fmt.Errorf("failed to lookup bond %s: %v", bond.Name, err)
+		}
+		bondIface.Mac = bondLink.Attrs().HardwareAddr.String()
+		bondIface.Mtu = bondLink.Attrs().MTU
+		contIface.Mac = bondIface.Mac
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cached := readBond(containerID, bond.Name)
+	for _, member := range cached.Members {
+		if member == contIface.Name {
+			return bondIface, nil
+		}
+	}
+	cached.Members = append(cached.Members, contIface.Name)
+	if err := bondStore.Save(bondCacheKey(containerID, bond.Name), cached); err != nil {
+		return nil, fmt.Errorf("error saving members of bond %s: %v", bond.Name, err)
+	}
+	return bondIface, nil
+}
+
+// recordBondAddresses remembers the addresses IPAM of the attachment
+// configured on the bond, so they are removed when it leaves the bond while
+// other members keep it
+func recordBondAddresses(containerID, ifName string, bond *types.Bond, result *current.Result) error {
+	lock, err := lockBond(containerID, bond.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	cached := readBond(containerID, bond.Name)
+	if cached.Addresses == nil {
+		cached.Addresses = map[string][]string{}
+	}
+	cached.Addresses[ifName] = nil
+	for _, ipc := range result.IPs {
+		cached.Addresses[ifName] = append(cached.Addresses[ifName], ipc.Address.String())
+	}
+	if err := bondStore.Save(bondCacheKey(containerID, bond.Name), cached); err != nil {
+		return fmt.Errorf("error saving addresses of bond %s: %v", bond.Name, err)
+	}
+	return nil
+}
+
+// delBondAddresses removes addresses from the bond, ones which are already
+// gone are ignored
+func delBondAddresses(bondName string, addresses []string) error {
+	link, err := netif.Default.LinkByName(bondName)
+	if err != nil {
+		if netif.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to lookup bond %s: %v", bondName, err)
+	}
+	for _, address := range addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return err
+		}
+		if err := netif.Default.AddrDel(link, addr); err != nil && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return fmt.Errorf("failed to remove address %s from bond %s: %v", address, bondName, err)
+		}
+	}
+	return nil
+}
+
+// leaveBond removes the attachment from members of the bond and removes the
+// bond when it was the last one, otherwise addresses the attachment
+// configured on the bond are removed from it. The member itself is released by the
+// kernel once it is deleted. A bond which is already gone is ignored.
+func leaveBond(contNetnsPath, containerID, ifName string, bond *types.Bond) error {
+	lock, err := lockBond(containerID, bond.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	cached := readBond(containerID, bond.Name)
+	var remaining []string
+	for _, member := range cached.Members {
+		if member != ifName {
+			remaining = append(remaining, member)
+		}
+	}
+	key := bondCacheKey(containerID, bond.Name)
+	if len(remaining) > 0 {
+		// the bond stays, but the lease of the addresses is released
+		if addresses := cached.Addresses[ifName]; len(addresses) > 0 && contNetnsPath != "" {
+			err = netns.WithPath(contNetnsPath, func(ns.NetNS) error {
+				return delBondAddresses(bond.Name, addresses)
+			})
+			if err != nil && !netns.IsGone(err) {
+				return err
+			}
+		}
+		cached.Members = remaining
+		delete(cached.Addresses, ifName)
+		return bondStore.Save(key, cached)
+	}
+	if contNetnsPath != "" {
+		err = netns.WithPath(contNetnsPath, func(ns.NetNS) error {
+			return netif.Default.DelLinkByName(bond.Name)
+		})
+		if err != nil && !netns.IsGone(err) && err != ip.ErrLinkNotFound {
+			return err
+		}
+	}
+	return bondStore.Delete(key)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// isBondedVFMode returns true when the attachment bonds two VFs in the
+// container instead of using a single one
+func isBondedVFMode(netconf *types.NetConf) bool {
+	return len(netconf.DeviceIDs) > 0
+}
+
+// bondedDeviceIDs returns VFs of the bonded attachment, the one of deviceID
+// first. Its representor is the port of the attachment, representors of the
+// others are additional ports.
+func bondedDeviceIDs(netconf *types.NetConf) []string {
+	deviceIDs := []string{netconf.DeviceID}
+	for _, deviceID := range netconf.DeviceIDs {
+		if deviceID != netconf.DeviceID {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	return deviceIDs
+}
+
+// bondedVFOrigIfNames returns names of the VFs of the bonded attachment on
+// the host, they get them back on DEL. Bonded VFs need kernel netdevs.
+func bondedVFOrigIfNames(netconf *types.NetConf) ([]string, error) {
+	var origIfNames []string
+	for _, deviceID := range bondedDeviceIDs(netconf) {
+		userspace, err := sriov.HasUserspaceDriver(deviceID)
+		if err != nil {
+			return nil, err
+		}
+		if userspace {
+			return nil, fmt.Errorf("VF %s of deviceIDs is bound to a userspace driver, bonded VFs need a kernel driver", deviceID)
+		}
+		origIfName, err := sriov.GetVFLinkName(deviceID)
+		if err != nil {
+			return nil, err
+		}
+		origIfNames = append(origIfNames, origIfName)
+	}
+	return origIfNames, nil
+}
+
+// attachBondedVFs attaches representors of the other VFs of the bonded
+// attachment to the bridge the same way as the one of the first VF, each is
+// recorded with the name of its VF in the container
+func attachBondedVFs(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, args *skel.CmdArgs, hostIfaces []*current.Interface, vlanTag uint, trunks []uint, portType, ovnPort, contPodUid string) error {
+	for i := 1; i < len(hostIfaces); i++ {
+		// ofport_request belongs to the port of the first VF
+		if err := attachPortToBridge(ovsBridgeDriver, netconf, ovsdb.PortOptions{
+			Name:        hostIfaces[i].Name,
+			ContNetns:   args.Netns,
+			ContIface:   sriov.BondedVFName(args.IfName, i),
+			ContNetwork: netconf.Name,
+			ContPodUID:  contPodUid,
+			ContID:      args.ContainerID,
+			OvnPort:     ovnPort,
+			VlanTag:     vlanTag,
+			Trunks:      trunks,
+			VlanMode:    portType,
+			QinQEthType: qinqEthType(netconf),
+			IntfType:    netconf.InterfaceType,
+		}); err != nil {
+			return err
+		}
+		if err := setupRateLimit(ovsBridgeDriver, netconf, hostIfaces[i].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBondedVFPorts removes ports of representors of the other VFs of the
+// bonded attachment, ports which are already gone are skipped
+func removeBondedVFPorts(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf) error {
+	for _, deviceID := range bondedDeviceIDs(netconf)[1:] {
+		rep, err := representorPortName(netconf, deviceID)
+		if err != nil {
+			return err
+		}
+		if _, err := ovsBridgeDriver.GetPortUUID(rep); err != nil {
+			continue
+		}
+		teardownRateLimit(ovsBridgeDriver, netconf, rep)
+		if err := removeOvsPort(ovsBridgeDriver, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseBondedVFs releases VFs of the bonded attachment into the host
+// namespace, on failure they are reset into their original state as much as
+// possible
+func releaseBondedVFs(args *skel.CmdArgs, cache *types.CachedNetConf) error {
+	err := sriov.ReleaseBondedVFs(args, cache.OrigIfNames)
+	if err != nil {
+		if err := resetBondedVFs(args, cache); err != nil {
+			log.Printf("Failed best-effort cleanup of bonded VFs: %v", err)
+		}
+	}
+	return err
+}
+
+// resetBondedVFs resets VFs of the bonded attachment which were moved into
+// the host namespace by a container failure, all are tried
+func resetBondedVFs(args *skel.CmdArgs, cache *types.CachedNetConf) error {
+	var firstErr error
+	for i, deviceID := range bondedDeviceIDs(cache.Netconf) {
+		if i >= len(cache.OrigIfNames) {
+			break
+		}
+		if err := sriov.ResetVF(args, deviceID, cache.OrigIfNames[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
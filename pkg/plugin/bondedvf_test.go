@@ -0,0 +1,33 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("bondedDeviceIDs", func() {
+	It("should return the VF of deviceID first", func() {
+		netconf := &types.NetConf{DeviceID: "0000:81:00.2", DeviceIDs: []string{"0000:03:00.2", "0000:81:00.2"}}
+		Expect(bondedDeviceIDs(netconf)).To(Equal([]string{"0000:81:00.2", "0000:03:00.2"}))
+	})
+	It("should keep order of deviceIDs when deviceID is the first of them", func() {
+		netconf := &types.NetConf{DeviceID: "0000:03:00.2", DeviceIDs: []string{"0000:03:00.2", "0000:81:00.2"}}
+		Expect(bondedDeviceIDs(netconf)).To(Equal([]string{"0000:03:00.2", "0000:81:00.2"}))
+	})
+})
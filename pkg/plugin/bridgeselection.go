@@ -0,0 +1,145 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// reasons of bridge selection
+const (
+	bridgeSelectedByConfig   = "configured"
+	bridgeSelectedByOvnPort  = "ovnPort"
+	bridgeSelectedByDeviceID = "deviceID"
+)
+
+// selectBridge returns the bridge of the attachment together with the trail
+// of its discovery when it is not configured
+func selectBridge(driver *ovsdb.OvsDriver, bridgeName, ovnPort, deviceID string) (*types.BridgeSelection, error) {
+	if bridgeName != "" {
+		return &types.BridgeSelection{Reason: bridgeSelectedByConfig, Bridge: bridgeName}, nil
+	} else if bridgeName == "" && ovnPort != "" {
+		return &types.BridgeSelection{Reason: bridgeSelectedByOvnPort, Bridge: "br-int"}, nil
+	} else if deviceID != "" {
+		possibleUplinkNames, err := sriov.GetBridgeUplinkNameByDeviceID(deviceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bridge name - failed to resolve uplink name: %v", err)
+		}
+		selection := &types.BridgeSelection{Reason: bridgeSelectedByDeviceID, DeviceID: deviceID}
+		var errList []error
+		for _, uplinkName := range possibleUplinkNames {
+			bridgeName, err = driver.FindBridgeByInterface(uplinkName)
+			if err != nil {
+				errList = append(errList,
+					fmt.Errorf("failed to get bridge name - failed to find bridge name by uplink name %s: %v", uplinkName, err))
+				selection.Candidates = append(selection.Candidates, types.BridgeCandidate{Uplink: uplinkName, Error: err.Error()})
+				continue
+			}
+			selection.Candidates = append(selection.Candidates, types.BridgeCandidate{Uplink: uplinkName, Bridge: bridgeName})
+			selection.Bridge = bridgeName
+			return selection, nil
+		}
+		return nil, fmt.Errorf("failed to find bridge by uplink names %v: %v", possibleUplinkNames, errList)
+	}
+
+	return nil, fmt.Errorf("failed to get bridge name")
+}
+
+// resolveBridgeTemplate replaces placeholders of the configured bridge by
+// labels of the node, a missing file of labels is worth a retry as marker
+// may not have written it yet
+func resolveBridgeTemplate(netconf *types.NetConf) error {
+	if !config.IsBridgeTemplate(netconf.BrName) {
+		return nil
+	}
+	path := netconf.NodeLabelsFile
+	if path == "" {
+		path = config.DefaultNodeLabelsFile
+	}
+	labels, err := config.LoadNodeLabels(path)
+	if err != nil {
+		return newError(cnitypes.ErrTryAgainLater, fmt.Errorf("failed to resolve bridge %s: %v", netconf.BrName, err))
+	}
+	bridge, err := config.ExpandBridgeTemplate(netconf.BrName, labels)
+	if err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
+	}
+	netconf.BrName = bridge
+	return nil
+}
+
+// verifyDeviceBridge fails ADD when the VF given by deviceID doesn't belong
+// to the configured bridge, e.g. when the device plugin handed out a VF of
+// another PF, as traffic of its representor would be blackholed. Uplinks
+// which can't be resolved or are on no bridge of the OVSDB, e.g. of a bridge
+// on a DPU, are only logged.
+func verifyDeviceBridge(driver *ovsdb.OvsDriver, bridgeName, deviceID string) error {
+	uplinks, err := sriov.GetBridgeUplinkNameByDeviceID(deviceID)
+	if err != nil {
+		log.Printf("Warning: can't verify device %s belongs to bridge %s, failed to resolve its uplink: %v", deviceID, bridgeName, err)
+		return nil
+	}
+	return verifyUplinksBridge(driver.FindBridgeByInterface, bridgeName, deviceID, uplinks)
+}
+
+// verifyUplinksBridge checks that one of the uplinks of the device, see
+// sriov.GetBridgeUplinkNameByDeviceID, is attached to the bridge
+func verifyUplinksBridge(findBridge func(string) (string, error), bridgeName, deviceID string, uplinks []string) error {
+	var others []string
+	for _, uplink := range uplinks {
+		bridge, err := findBridge(uplink)
+		if err != nil {
+			continue
+		}
+		if bridge == bridgeName {
+			return nil
+		}
+		others = append(others, fmt.Sprintf("uplink %s is on bridge %s", uplink, bridge))
+	}
+	if len(others) > 0 {
+		return newError(cnitypes.ErrInvalidNetworkConfig, fmt.Errorf("device %s doesn't belong to bridge %s: %s",
+			deviceID, bridgeName, strings.Join(others, ", ")))
+	}
+	log.Printf("Warning: can't verify device %s belongs to bridge %s, its uplinks %v are on no bridge", deviceID, bridgeName, uplinks)
+	return nil
+}
+
+// describeBridgeSelection explains how the bridge was selected
+func describeBridgeSelection(selection *types.BridgeSelection) string {
+	switch selection.Reason {
+	case bridgeSelectedByOvnPort:
+		return fmt.Sprintf("bridge %s selected for OVN port", selection.Bridge)
+	case bridgeSelectedByDeviceID:
+		var tried []string
+		for _, candidate := range selection.Candidates {
+			if candidate.Error != "" {
+				tried = append(tried, fmt.Sprintf("uplink %s not used (%s)", candidate.Uplink, candidate.Error))
+			} else {
+				tried = append(tried, fmt.Sprintf("uplink %s on bridge %s", candidate.Uplink, candidate.Bridge))
+			}
+		}
+		return fmt.Sprintf("bridge %s selected by uplink of device %s: %s", selection.Bridge, selection.DeviceID, strings.Join(tried, ", "))
+	}
+	return fmt.Sprintf("bridge %s configured", selection.Bridge)
+}
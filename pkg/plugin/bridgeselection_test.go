@@ -0,0 +1,69 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Bridge selection", func() {
+	It("should explain the selection by uplinks of the device", func() {
+		selection := &types.BridgeSelection{
+			Reason:   bridgeSelectedByDeviceID,
+			DeviceID: "0000:00:01.0",
+			Candidates: []types.BridgeCandidate{
+				{Uplink: "p0", Error: "failed to find interface p0: not found"},
+				{Uplink: "bond0", Bridge: "br-ex"},
+			},
+			Bridge: "br-ex",
+		}
+		Expect(describeBridgeSelection(selection)).To(Equal("bridge br-ex selected by uplink of device 0000:00:01.0: " +
+			"uplink p0 not used (failed to find interface p0: not found), uplink bond0 on bridge br-ex"))
+	})
+	It("should select the configured bridge", func() {
+		selection, err := selectBridge(nil, "br1", "port1", "0000:00:01.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selection).To(Equal(&types.BridgeSelection{Reason: bridgeSelectedByConfig, Bridge: "br1"}))
+		Expect(describeBridgeSelection(selection)).To(Equal("bridge br1 configured"))
+	})
+	It("should verify an uplink of the device is on the configured bridge", func() {
+		bridges := map[string]string{"p0": "br-a", "p1": "br-b"}
+		findBridge := func(uplink string) (string, error) {
+			if bridge, found := bridges[uplink]; found {
+				return bridge, nil
+			}
+			return "", fmt.Errorf("failed to find interface %s", uplink)
+		}
+		Expect(verifyUplinksBridge(findBridge, "br-a", "0000:00:01.0", []string{"bond0", "p0", "p1"})).To(Succeed())
+		Expect(verifyUplinksBridge(findBridge, "br-a", "0000:00:01.0", []string{"dpu0"})).To(Succeed())
+		err := verifyUplinksBridge(findBridge, "br-a", "0000:00:01.0", []string{"bond1", "p1"})
+		Expect(err).To(MatchError("device 0000:00:01.0 doesn't belong to bridge br-a: uplink p1 is on bridge br-b"))
+		var cniErr *cnitypes.Error
+		Expect(errors.As(err, &cniErr)).To(BeTrue())
+		Expect(cniErr.Code).To(BeEquivalentTo(cnitypes.ErrInvalidNetworkConfig))
+	})
+	It("should select the integration bridge for OVN ports", func() {
+		selection, err := selectBridge(nil, "", "port1", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(describeBridgeSelection(selection)).To(Equal("bridge br-int selected for OVN port"))
+	})
+})
@@ -0,0 +1,61 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// checkBridgeCapacity fails ADD when the bridge has no room for the given
+// number of ports of ovs-cni within the limit of its external_ids, before
+// OpenFlow ports or forwarding tables of the bridge are exhausted. Bridges
+// without the limit and bridges with a malformed one are not limited. For a
+// limited bridge it returns the lock of the bridge, which must be held until
+// the ports are created, so concurrent invocations don't exceed the limit.
+func checkBridgeCapacity(ovsDriver *ovsdb.OvsDriver, bridgeName string, ports int) (*utils.Lock, error) {
+	limits, err := ovsDriver.BridgeExternalID(ovsdb.BridgeMaxPortsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read port limit of bridge %s: %v", bridgeName, err)
+	}
+	value, found := limits[bridgeName]
+	if !found {
+		return nil, nil
+	}
+	maxPorts, err := ovsdb.ParseMaxPorts(value)
+	if err != nil {
+		log.Printf("Warning: ignoring port limit of bridge %s: %v", bridgeName, err)
+		return nil, nil
+	}
+	lock, err := lockBridge(bridgeName)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := ovsDriver.GetOwnedPortCounts()
+	if err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to count ports of bridge %s: %v", bridgeName, err)
+	}
+	if counts[bridgeName]+ports > maxPorts {
+		lock.Release()
+		return nil, newError(types.ErrBridgeFull, fmt.Errorf("bridge %s is full, it has %d ports of ovs-cni and %s is %d",
+			bridgeName, counts[bridgeName], ovsdb.BridgeMaxPortsKey, maxPorts))
+	}
+	return lock, nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"log"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// captureMirrorName returns the name of the capture mirror of the port
+func captureMirrorName(portName string) string {
+	return "capture-" + portName
+}
+
+// startCapture mirrors traffic of the new port to the capture port for the
+// configured time, so early traffic like DHCP can be captured there. It is a
+// debugging aid, failures are only logged.
+func startCapture(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, portName string) {
+	if netconf.Capture == nil {
+		return
+	}
+	expiry := time.Now().Add(time.Duration(netconf.Capture.Duration) * time.Second)
+	if err := ovsBridgeDriver.CreateCaptureMirror(captureMirrorName(portName), portName, netconf.Capture.Port, expiry); err != nil {
+		log.Printf("Failed to start capture of port %s: %v", portName, err)
+		return
+	}
+	log.Printf("Mirroring traffic of port %s to %s until %s", portName, netconf.Capture.Port, expiry.UTC().Format(time.RFC3339))
+}
+
+// stopCapture removes the capture mirror of the port before it expires
+func stopCapture(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, portName string) {
+	if netconf.Capture == nil {
+		return
+	}
+	mirrorName := captureMirrorName(portName)
+	if present, err := ovsBridgeDriver.IsMirrorPresent(mirrorName); err != nil || !present {
+		return
+	}
+	if err := ovsBridgeDriver.DeleteMirror(ovsBridgeDriver.OvsBridgeName, mirrorName); err != nil {
+		log.Printf("Failed to stop capture of port %s: %v", portName, err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// checkStore returns the cache of attachments which passed CHECK, so a
+// repeated CHECK with the same configuration doesn't query OVSDB again.
+// Entries expire after ttl seconds, the marker drops them earlier when their
+// port changes.
+func checkStore(ttl int) *utils.Store[types.CachedCheck] {
+	return utils.NewStore[types.CachedCheck]("", config.CheckCacheKeyPrefix, time.Duration(ttl)*time.Second)
+}
+
+func checkDigest(stdinData []byte, netns string) string {
+	hash := sha256.Sum256(append(append([]byte{}, stdinData...), netns...))
+	return hex.EncodeToString(hash[:])
+}
+
+// checkCached returns true when the attachment passed CHECK with the same
+// digest and the result did not expire yet
+func checkCached(netconf *types.NetConf, cRef, digest string) bool {
+	if netconf.CheckCacheTTL == 0 {
+		return false
+	}
+	cached, err := checkStore(netconf.CheckCacheTTL).Load(cRef)
+	return err == nil && cached.Digest == digest
+}
+
+// cacheCheck remembers that the attachment using the port passed CHECK,
+// failures are only logged
+func cacheCheck(netconf *types.NetConf, cRef, digest, port string) {
+	if netconf.CheckCacheTTL == 0 {
+		return
+	}
+	if err := checkStore(netconf.CheckCacheTTL).Save(cRef, &types.CachedCheck{Digest: digest, Port: port}); err != nil {
+		log.Printf("Failed to cache CHECK of %s: %v", cRef, err)
+	}
+}
+
+// forgetCheck drops the passed CHECK of the attachment, failures are only
+// logged
+func forgetCheck(cRef string) {
+	if err := checkStore(0).Delete(cRef); err != nil {
+		log.Printf("Failed to drop cached CHECK of %s: %v", cRef, err)
+	}
+}
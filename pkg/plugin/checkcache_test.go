@@ -0,0 +1,53 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("CHECK result cache", func() {
+	const cRef = "net-checkcache-test-eth0"
+	AfterEach(func() {
+		forgetCheck(cRef)
+	})
+	It("should not remember results when disabled", func() {
+		netconf := &types.NetConf{}
+		cacheCheck(netconf, cRef, "digest", "veth1")
+		Expect(checkCached(netconf, cRef, "digest")).To(BeFalse())
+		_, err := checkStore(0).Load(cRef)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should remember results until they expire", func() {
+		netconf := &types.NetConf{CheckCacheTTL: 1}
+		cacheCheck(netconf, cRef, "digest", "veth1")
+		Expect(checkCached(netconf, cRef, "digest")).To(BeTrue())
+		Expect(checkCached(netconf, cRef, "other")).To(BeFalse())
+		Eventually(func() bool {
+			return checkCached(netconf, cRef, "digest")
+		}, 3*time.Second, 100*time.Millisecond).Should(BeFalse())
+	})
+	It("should drop results of forgotten attachments", func() {
+		netconf := &types.NetConf{CheckCacheTTL: 60}
+		cacheCheck(netconf, cRef, "digest", "veth1")
+		forgetCheck(cRef)
+		Expect(checkCached(netconf, cRef, "digest")).To(BeFalse())
+	})
+})
@@ -0,0 +1,154 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+)
+
+// validateContainerAddresses checks that addresses of the result and their
+// prefix routes are configured on the container interface. Unlike
+// ip.ValidateExpectedInterfaceIPs, an IPv6 address which failed DAD doesn't
+// pass, prefix routes are looked up on the interface, and host addresses of
+// routed attachments, which have no prefix route, pass. It must run in the
+// container netns.
+func validateContainerAddresses(ifName string, ips []*current.IPConfig) error {
+	link, routes, err := containerRoutes(ifName)
+	if err != nil {
+		return err
+	}
+	addrs, err := netif.Default.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %q: %v", ifName, err)
+	}
+	for _, ipc := range ips {
+		addr := findAddr(addrs, ipc.Address)
+		if addr == nil {
+			return fmt.Errorf("address %s is not configured on %q", ipc.Address.String(), ifName)
+		}
+		if addr.Flags&unix.IFA_F_DADFAILED != 0 {
+			return fmt.Errorf("address %s of %q failed duplicate address detection", ipc.Address.String(), ifName)
+		}
+		if ones, bits := ipc.Address.Mask.Size(); ones == bits {
+			continue
+		}
+		prefix := &net.IPNet{IP: ipc.Address.IP.Mask(ipc.Address.Mask), Mask: ipc.Address.Mask}
+		if !hasRoute(routes, link.Attrs().Index, prefix, nil) {
+			return fmt.Errorf("route to %s of address %s is missing on %q", prefix, ipc.Address.String(), ifName)
+		}
+	}
+	return nil
+}
+
+// validateContainerRoutes checks that routes of the result go over the
+// container interface. Unlike ip.ValidateExpectedRoute, routes without a
+// gateway are expected via the gateway of their address family, like
+// ipam.ConfigureIface adds them. It must run in the container netns.
+func validateContainerRoutes(ifName string, result *current.Result) error {
+	link, routes, err := containerRoutes(ifName)
+	if err != nil {
+		return err
+	}
+	v4gw, v6gw := familyGateways(result.IPs)
+	for _, r := range result.Routes {
+		gw := routeGateway(r, v4gw, v6gw)
+		dst := r.Dst
+		if !hasRoute(routes, link.Attrs().Index, &dst, gw) {
+			if gw == nil {
+				return fmt.Errorf("route to %s is missing on %q", r.Dst.String(), ifName)
+			}
+			return fmt.Errorf("route to %s via %s is missing on %q", r.Dst.String(), gw, ifName)
+		}
+	}
+	return nil
+}
+
+// containerRoutes returns the container interface and routes of the main
+// table of both address families
+func containerRoutes(ifName string) (netlink.Link, []netlink.Route, error) {
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	routes, err := netif.Default.RouteList(netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list routes: %v", err)
+	}
+	return link, routes, nil
+}
+
+// findAddr returns the address of the list with the IP and prefix length
+func findAddr(addrs []netlink.Addr, address net.IPNet) *netlink.Addr {
+	for i, addr := range addrs {
+		addrOnes, _ := addr.Mask.Size()
+		ones, _ := address.Mask.Size()
+		if addr.IP.Equal(address.IP) && addrOnes == ones {
+			return &addrs[i]
+		}
+	}
+	return nil
+}
+
+// hasRoute tells whether a route to dst goes over the link, via gw unless it
+// is nil, including next hops of ECMP routes which ipam.ConfigureIface adds
+// for routes to the same destination
+func hasRoute(routes []netlink.Route, linkIndex int, dst *net.IPNet, gw net.IP) bool {
+	family := netlink.FAMILY_V6
+	if dst.IP.To4() != nil {
+		family = netlink.FAMILY_V4
+	}
+	for _, route := range routes {
+		if route.Family != family || !sameDestination(route.Dst, dst) {
+			continue
+		}
+		hops := []*netlink.NexthopInfo{{LinkIndex: route.LinkIndex, Gw: route.Gw}}
+		if len(route.MultiPath) > 0 {
+			hops = route.MultiPath
+		}
+		for _, hop := range hops {
+			if hop.LinkIndex == linkIndex && (gw == nil || hop.Gw.Equal(gw)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameDestination compares destinations of routes of the same family,
+// default routes have none in netlink
+func sameDestination(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return isDefault(a) && isDefault(b)
+	}
+	aOnes, _ := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+	return aOnes == bOnes && a.IP.Equal(b.IP)
+}
+
+// isDefault tells whether the destination is a default route
+func isDefault(dst *net.IPNet) bool {
+	if dst == nil {
+		return true
+	}
+	ones, _ := dst.Mask.Size()
+	return ones == 0
+}
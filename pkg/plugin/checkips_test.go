@@ -0,0 +1,188 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build simulation
+
+package plugin
+
+import (
+	"net"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+)
+
+var _ = Describe("Container addresses and routes", func() {
+	const contNetnsPath = "/var/run/netns/simulated-ips"
+	var contNetns ns.NetNS
+	var result *current.Result
+
+	cidr := func(s string) net.IPNet {
+		ip, ipNet, err := net.ParseCIDR(s)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		ipNet.IP = ip
+		return *ipNet
+	}
+	// inContainer runs the function in the container netns with the link of
+	// the interface
+	inContainer := func(ifName string, toRun func(link netlink.Link)) {
+		ExpectWithOffset(1, netns.Do(contNetns, func(ns.NetNS) error {
+			defer GinkgoRecover()
+			link, err := netif.Default.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+			toRun(link)
+			return nil
+		})).To(Succeed())
+	}
+	addAddr := func(link netlink.Link, address string, flags int) {
+		ipNet := cidr(address)
+		ExpectWithOffset(1, netif.Default.AddrAdd(link, &netlink.Addr{IPNet: &ipNet, Flags: flags})).To(Succeed())
+	}
+	addRoute := func(link netlink.Link, dst, gw string) {
+		dstNet := cidr(dst)
+		ExpectWithOffset(1, netif.Default.RouteReplace(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: &dstNet, Gw: net.ParseIP(gw)})).To(Succeed())
+	}
+	validate := func() error {
+		var err error
+		ExpectWithOffset(1, netns.Do(contNetns, func(ns.NetNS) error {
+			if err = validateContainerAddresses("eth0", result.IPs); err != nil {
+				return nil
+			}
+			err = validateContainerRoutes("eth0", result)
+			return nil
+		})).To(Succeed())
+		return err
+	}
+
+	BeforeEach(func() {
+		netif.Default = netif.NewSimulated()
+		contNetns = netns.NewSimulated(contNetnsPath)
+		DeferCleanup(netns.DeleteSimulated, contNetnsPath)
+		for _, names := range [][2]string{{"eth0", "host0"}, {"eth1", "host1"}} {
+			_, _, err := setupVeth(contNetns, names[0], names[1], "", 1500)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		result = &current.Result{
+			Interfaces: []*current.Interface{{Name: "eth0"}},
+			IPs: []*current.IPConfig{
+				{Interface: current.Int(0), Address: cidr("fd00::5/64"), Gateway: net.ParseIP("fd00::1")},
+				{Interface: current.Int(0), Address: cidr("10.1.2.5/24"), Gateway: net.ParseIP("10.1.2.1")},
+			},
+			Routes: []*cnitypes.Route{{Dst: cidr("0.0.0.0/0")}, {Dst: cidr("::/0")}, {Dst: cidr("10.2.0.0/16"), GW: net.ParseIP("10.1.2.254")}},
+		}
+		// the kernel adds prefix routes of addresses itself
+		inContainer("eth0", func(link netlink.Link) {
+			addAddr(link, "fd00::5/64", 0)
+			addAddr(link, "10.1.2.5/24", 0)
+			addRoute(link, "fd00::/64", "")
+			addRoute(link, "10.1.2.0/24", "")
+		})
+	})
+
+	It("should pass with routes via the gateway of their family on dual-stack", func() {
+		inContainer("eth0", func(link netlink.Link) {
+			addRoute(link, "0.0.0.0/0", "10.1.2.1")
+			addRoute(link, "::/0", "fd00::1")
+			addRoute(link, "10.2.0.0/16", "10.1.2.254")
+		})
+		Expect(validate()).To(Succeed())
+	})
+	It("should pass on IPv6-only", func() {
+		result.IPs = result.IPs[:1]
+		result.Routes = []*cnitypes.Route{{Dst: cidr("::/0")}, {Dst: cidr("fd01::/64"), GW: net.ParseIP("fd00::fe")}}
+		inContainer("eth0", func(link netlink.Link) {
+			addRoute(link, "::/0", "fd00::1")
+			addRoute(link, "fd01::/64", "fd00::fe")
+		})
+		Expect(validate()).To(Succeed())
+	})
+	It("should pass with host addresses of routed attachments, which have no prefix route", func() {
+		toRoutedResult(result)
+		result.Routes = nil
+		inContainer("eth0", func(link netlink.Link) {
+			addAddr(link, "10.1.2.6/32", 0)
+			addAddr(link, "fd00::6/128", 0)
+		})
+		result.IPs[0].Address = cidr("fd00::6/128")
+		result.IPs[1].Address = cidr("10.1.2.6/32")
+		Expect(validate()).To(Succeed())
+	})
+	It("should pass with ECMP routes through the interface", func() {
+		inContainer("eth0", func(link netlink.Link) {
+			addRoute(link, "10.2.0.0/16", "10.1.2.254")
+			addRoute(link, "::/0", "fd00::1")
+			ecmp := cidr("0.0.0.0/0")
+			Expect(netif.Default.RouteReplace(&netlink.Route{Dst: &ecmp, MultiPath: []*netlink.NexthopInfo{
+				{LinkIndex: link.Attrs().Index + 100, Gw: net.ParseIP("10.1.2.2")},
+				{LinkIndex: link.Attrs().Index, Gw: net.ParseIP("10.1.2.1")},
+			}})).To(Succeed())
+		})
+		Expect(validate()).To(Succeed())
+	})
+	It("should fail when a route goes via the gateway of the other family's address", func() {
+		inContainer("eth0", func(link netlink.Link) {
+			addRoute(link, "0.0.0.0/0", "10.1.2.1")
+			addRoute(link, "::/0", "fd00::2")
+			addRoute(link, "10.2.0.0/16", "10.1.2.254")
+		})
+		Expect(validate()).To(MatchError(`route to ::/0 via fd00::1 is missing on "eth0"`))
+	})
+	It("should fail when a route goes over another interface", func() {
+		inContainer("eth0", func(link netlink.Link) {
+			addRoute(link, "::/0", "fd00::1")
+			addRoute(link, "10.2.0.0/16", "10.1.2.254")
+		})
+		inContainer("eth1", func(link netlink.Link) {
+			addRoute(link, "0.0.0.0/0", "10.1.2.1")
+		})
+		Expect(validate()).To(MatchError(`route to 0.0.0.0/0 via 10.1.2.1 is missing on "eth0"`))
+	})
+	It("should fail when an IPv6 address failed DAD", func() {
+		result.IPs = append(result.IPs, &current.IPConfig{Interface: current.Int(0), Address: cidr("fd00::7/64")})
+		inContainer("eth0", func(link netlink.Link) {
+			addAddr(link, "fd00::7/64", unix.IFA_F_DADFAILED|unix.IFA_F_TENTATIVE)
+		})
+		Expect(validate()).To(MatchError(`address fd00::7/64 of "eth0" failed duplicate address detection`))
+	})
+	It("should fail when an address or its prefix route is missing", func() {
+		result.IPs = append(result.IPs, &current.IPConfig{Interface: current.Int(0), Address: cidr("fd02::5/64")})
+		Expect(validate()).To(MatchError(`address fd02::5/64 is not configured on "eth0"`))
+		inContainer("eth0", func(link netlink.Link) {
+			addAddr(link, "fd02::5/64", 0)
+		})
+		Expect(validate()).To(MatchError(`route to fd02::/64 of address fd02::5/64 is missing on "eth0"`))
+	})
+	It("should add routes of the backup interface via the gateway of their family", func() {
+		_, _, err := setupVeth(contNetns, "eth0b", "host0b", "", 1500)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netns.Do(contNetns, func(ns.NetNS) error {
+			return configureBackupIface("eth0", "eth0b", result)
+		})).To(Succeed())
+		inContainer("eth0b", func(link netlink.Link) {
+			routes, err := netif.Default.RouteList(netlink.FAMILY_ALL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hasRoute(routes, link.Attrs().Index, &result.Routes[0].Dst, net.ParseIP("10.1.2.1"))).To(BeTrue())
+			Expect(hasRoute(routes, link.Attrs().Index, &result.Routes[1].Dst, net.ParseIP("fd00::1"))).To(BeTrue())
+			Expect(hasRoute(routes, link.Attrs().Index, &result.Routes[2].Dst, net.ParseIP("10.1.2.254"))).To(BeTrue())
+		})
+	})
+})
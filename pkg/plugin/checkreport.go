@@ -0,0 +1,176 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// checkReport is the machine-readable result of CHECK of an attachment,
+// written to check_report_dir so automation doesn't parse error strings
+type checkReport struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Network     string            `json:"network"`
+	ContainerID string            `json:"containerID"`
+	IfName      string            `json:"ifName"`
+	Passed      bool              `json:"passed"`
+	Error       *checkReportError `json:"error,omitempty"`
+	Checks      []checkReportItem `json:"checks"` // validated items in the order they were checked
+	OVS         *checkReportOVS   `json:"ovs,omitempty"`
+
+	netconf *types.NetConf
+	port    string
+	driver  *ovsdb.OvsBridgeDriver // connection CHECK used, the snapshot is read through it
+}
+
+// checkReportItem is an item validated by CHECK, the mismatch is in the
+// detail of a failed one
+type checkReportItem struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// checkReportError is the error CHECK returned, with its CNI error code
+type checkReportError struct {
+	Code uint   `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// checkReportOVS is a snapshot of the OVS state of the attachment taken
+// after CHECK, parts which can't be read are left out and explained in error
+type checkReportOVS struct {
+	Bridge      string `json:"bridge"`
+	BridgeState string `json:"bridgeState,omitempty"`
+	Port        string `json:"port,omitempty"`
+	LinkState   string `json:"linkState,omitempty"`
+	VlanMode    string `json:"vlanMode,omitempty"`
+	Tag         *uint  `json:"tag,omitempty"`
+	Trunks      []uint `json:"trunks,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// check records the validated item and returns its error
+func (r *checkReport) check(name string, err error) error {
+	item := checkReportItem{Name: name, Passed: err == nil}
+	if err != nil {
+		item.Detail = err.Error()
+	}
+	r.Checks = append(r.Checks, item)
+	return err
+}
+
+// checkReportPath returns the report file of the attachment
+func checkReportPath(dir, networkName, containerID, ifName string) string {
+	return filepath.Join(dir, config.GetNetworkCRef(networkName, containerID, ifName)+".json")
+}
+
+// saveCheckReport completes the report with the result of CHECK and the OVS
+// state of the attachment and writes it, when check_report_dir is set. CHECK
+// fails before the netconf is validated produce no report and the OVS state
+// is left out when CHECK ended before it connected to the bridge. Failures
+// are only logged, they must not change the result of CHECK.
+func saveCheckReport(args *skel.CmdArgs, report *checkReport, checkErr error) {
+	netconf := report.netconf
+	if netconf == nil || netconf.CheckReportDir == "" {
+		return
+	}
+	report.Timestamp = time.Now().UTC()
+	report.Network = netconf.Name
+	report.ContainerID = args.ContainerID
+	report.IfName = args.IfName
+	report.Passed = checkErr == nil
+	if checkErr != nil {
+		// skel returns other errors as internal ones
+		report.Error = &checkReportError{Code: cnitypes.ErrInternal, Msg: checkErr.Error()}
+		var cniErr *cnitypes.Error
+		if errors.As(checkErr, &cniErr) {
+			report.Error.Code = cniErr.Code
+		}
+	}
+	if report.Checks == nil {
+		report.Checks = []checkReportItem{}
+	}
+	if report.driver != nil {
+		report.OVS = ovsSnapshot(report.driver, netconf, report.port, checkErr != nil)
+	}
+	if err := writeCheckReport(netconf.CheckReportDir, report); err != nil {
+		log.Printf("Failed to write CHECK report of %s: %v", args.IfName, err)
+	}
+}
+
+// ovsSnapshot reads the OVS state of the bridge and the port of the
+// attachment, if it was found. Flows are counted only for a failed CHECK.
+func ovsSnapshot(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, portName string, failed bool) *checkReportOVS {
+	snapshot := &checkReportOVS{Bridge: netconf.BrName, Port: portName}
+	snapshot.BridgeState = bridgeState(ovsBridgeDriver, netconf, failed && netconf.OvsDiagnostics)
+	if portName == "" {
+		return snapshot
+	}
+	var err error
+	if snapshot.LinkState, err = ovsBridgeDriver.GetOFPortOpState(portName); err != nil {
+		snapshot.Error = fmt.Sprintf("failed to read link state of port %s: %v", portName, err)
+		return snapshot
+	}
+	if snapshot.VlanMode, snapshot.Tag, snapshot.Trunks, err = ovsBridgeDriver.GetOFPortVlanState(portName); err != nil {
+		snapshot.Error = fmt.Sprintf("failed to read VLAN state of port %s: %v", portName, err)
+	}
+	return snapshot
+}
+
+func writeCheckReport(dir string, report *checkReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := checkReportPath(dir, report.Network, report.ContainerID, report.IfName)
+	// written aside and renamed, a reader never sees a partial report
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// cleanCheckReport removes the report of the attachment, a missing one is
+// ignored and failures are only logged as they must not block DEL
+func cleanCheckReport(netconf *types.NetConf, containerID, ifName string) {
+	if netconf.CheckReportDir == "" {
+		return
+	}
+	if err := os.Remove(checkReportPath(netconf.CheckReportDir, netconf.Name, containerID, ifName)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove CHECK report of %s: %v", ifName, err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("CHECK report", func() {
+	var dir string
+	var netconf *types.NetConf
+	args := &skel.CmdArgs{ContainerID: "abc", IfName: "net1"}
+
+	BeforeEach(func() {
+		dir = filepath.Join(GinkgoT().TempDir(), "check")
+		netconf = &types.NetConf{CheckReportDir: dir}
+		netconf.Name = "net-a"
+	})
+
+	readReport := func() *checkReport {
+		data, err := os.ReadFile(filepath.Join(dir, "net-a-abc-net1.json"))
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		report := &checkReport{}
+		ExpectWithOffset(1, json.Unmarshal(data, report)).To(Succeed())
+		return report
+	}
+
+	It("should report validated items of a passed CHECK", func() {
+		report := &checkReport{netconf: netconf}
+		Expect(report.check("cache", nil)).To(Succeed())
+		Expect(report.check("addresses", nil)).To(Succeed())
+		saveCheckReport(args, report, nil)
+
+		saved := readReport()
+		Expect(saved.Network).To(Equal("net-a"))
+		Expect(saved.ContainerID).To(Equal("abc"))
+		Expect(saved.IfName).To(Equal("net1"))
+		Expect(saved.Passed).To(BeTrue())
+		Expect(saved.Error).To(BeNil())
+		Expect(saved.Checks).To(Equal([]checkReportItem{{Name: "cache", Passed: true}, {Name: "addresses", Passed: true}}))
+		Expect(saved.OVS).To(BeNil())
+	})
+	It("should report the mismatch and the error code of a failed CHECK", func() {
+		report := &checkReport{netconf: netconf}
+		report.check("cache", nil)
+		err := report.check("cache", fmt.Errorf("BrName mismatch. cache=br1,netconf=br2"))
+		Expect(err).To(HaveOccurred())
+		saveCheckReport(args, report, newError(cnitypes.ErrInvalidNetworkConfig, err))
+
+		saved := readReport()
+		Expect(saved.Passed).To(BeFalse())
+		Expect(saved.Checks[1]).To(Equal(checkReportItem{Name: "cache", Passed: false, Detail: "BrName mismatch. cache=br1,netconf=br2"}))
+		Expect(saved.Error.Code).To(Equal(uint(cnitypes.ErrInvalidNetworkConfig)))
+		Expect(saved.Error.Msg).To(ContainSubstring("BrName mismatch"))
+	})
+	It("should report other errors as internal ones", func() {
+		saveCheckReport(args, &checkReport{netconf: netconf}, fmt.Errorf("routes mismatch"))
+		saved := readReport()
+		Expect(saved.Error.Code).To(Equal(uint(cnitypes.ErrInternal)))
+		Expect(saved.Checks).To(BeEmpty())
+	})
+	It("should remove the report of the attachment", func() {
+		saveCheckReport(args, &checkReport{netconf: netconf}, nil)
+		cleanCheckReport(netconf, "abc", "net1")
+		Expect(filepath.Join(dir, "net-a-abc-net1.json")).NotTo(BeAnExistingFile())
+		// removing it again is a no-op
+		cleanCheckReport(netconf, "abc", "net1")
+	})
+	It("should not write a report without check_report_dir", func() {
+		netconf.CheckReportDir = ""
+		saveCheckReport(args, &checkReport{netconf: netconf}, nil)
+		saveCheckReport(args, &checkReport{}, nil)
+		Expect(dir).NotTo(BeADirectory())
+	})
+})
@@ -0,0 +1,267 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"syscall"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// configureIface applies the IPAM result to the container interface,
+// merging it with addresses already on the interface if configured
+func configureIface(netconf *types.NetConf, ifName string, res *current.Result) error {
+	if netconf.PreserveAddresses {
+		return ConfigureIfacePreservingAddresses(ifName, res)
+	}
+	return ipam.ConfigureIface(ifName, res)
+}
+
+// reusableContIface returns the container interface and its host side left
+// by a previous attachment of the pod, so ADD with preserve_addresses keeps
+// the addresses a restored checkpoint or a migrated VM configured on it. The
+// interface is reused if it is a veth whose peer is in the host netns and is
+// attached nowhere or is the OVS port of the same attachment, the port is
+// removed then to be attached again. Otherwise nil interfaces are returned
+// and the interface is handled like one left by a failed ADD.
+func reusableContIface(ovsDriver *ovsdb.OvsBridgeDriver, contNetns ns.NetNS, contIfaceName, contNetwork string) (*current.Interface, *current.Interface, error) {
+	var contIface *current.Interface
+	peerIndex := 0
+	err := netns.Do(contNetns, func(_ ns.NetNS) error {
+		link, err := netif.Default.LinkByName(contIfaceName)
+		if err != nil {
+			if netif.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		veth, isVeth := link.(*netlink.Veth)
+		if !isVeth {
+			return nil
+		}
+		if peerIndex, err = netif.Default.VethPeerIndex(veth); err != nil {
+			return err
+		}
+		if err := setInterfaceUp(contIfaceName); err != nil {
+			return err
+		}
+		contIface = &current.Interface{
+			Name:    contIfaceName,
+			Mac:     link.Attrs().HardwareAddr.String(),
+			Mtu:     link.Attrs().MTU,
+			Sandbox: contNetns.Path(),
+		}
+		return nil
+	})
+	if err != nil || contIface == nil {
+		return nil, nil, err
+	}
+
+	peer, err := netif.Default.LinkByIndex(peerIndex)
+	if err != nil {
+		// the peer is in another netns
+		return nil, nil, nil
+	}
+	portName, portFound, err := getOvsPortForContIface(ovsDriver, contIfaceName, contNetns.Path(), contNetwork)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to obtain OVS port for container iface %s: %v", contIfaceName, err)
+	}
+	if portFound && portName != peer.Attrs().Name || !portFound && peer.Attrs().MasterIndex != 0 {
+		return nil, nil, nil
+	}
+	if portFound {
+		if err := removeOvsPort(ovsDriver, portName); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove port %s of container iface %s: %v", portName, contIfaceName, err)
+		}
+	}
+
+	log.Printf("Info: reusing interface %s in container netns and its peer %s", contIfaceName, peer.Attrs().Name)
+	hostIface := &current.Interface{Name: peer.Attrs().Name}
+	if err := refetchIface(hostIface); err != nil {
+		return nil, nil, err
+	}
+	return hostIface, contIface, nil
+}
+
+// ConfigureIfacePreservingAddresses is an alternative of ipam.ConfigureIface
+// for interfaces which may already have addresses, e.g. when an attachment is
+// reattached after checkpoint/restore or live migration. Addresses of the
+// result which are already on the interface and routes which already exist
+// are kept as they are instead of failing, other addresses of the interface
+// are preserved. Addresses listed twice in the result are added once.
+func ConfigureIfacePreservingAddresses(ifName string, res *current.Result) error {
+	if len(res.Interfaces) == 0 {
+		return fmt.Errorf("no interfaces to configure")
+	}
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	existing, err := netif.Default.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %q: %v", ifName, err)
+	}
+	present := map[string]*net.IPNet{}
+	for _, addr := range existing {
+		present[addr.IP.String()] = addr.IPNet
+	}
+
+	var v4gw, v6gw net.IP
+	ipv6Enabled := false
+	for _, ipc := range res.IPs {
+		if ipc.Interface == nil {
+			continue
+		}
+		if idx := *ipc.Interface; idx < 0 || idx >= len(res.Interfaces) || res.Interfaces[idx].Name != ifName {
+			return fmt.Errorf("failed to add IP addr %v to %q: invalid interface index", ipc, ifName)
+		}
+		if ipc.Address.IP.To4() == nil && !ipv6Enabled {
+			if err := enableIPv6(ifName); err != nil {
+				return err
+			}
+			ipv6Enabled = true
+		}
+
+		if prefix, found := present[ipc.Address.IP.String()]; found {
+			if prefix.String() != ipc.Address.String() {
+				log.Printf("Warning: keeping address %s of %q, IPAM assigned it as %s", prefix, ifName, ipc.Address.String())
+			}
+		} else {
+			address := ipc.Address
+			if err := netif.Default.AddrAdd(link, &netlink.Addr{IPNet: &address}); err != nil {
+				return fmt.Errorf("failed to add IP addr %v to %q: %v", ipc, ifName, err)
+			}
+			present[ipc.Address.IP.String()] = &address
+		}
+
+		if ipc.Gateway.To4() != nil && v4gw == nil {
+			v4gw = ipc.Gateway
+		} else if ipc.Gateway.To4() == nil && v6gw == nil {
+			v6gw = ipc.Gateway
+		}
+	}
+
+	if err := netif.Default.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set %q UP: %v", ifName, err)
+	}
+	if v6gw != nil {
+		ip.SettleAddresses(ifName, 10)
+	}
+
+	for _, r := range res.Routes {
+		gw := routeGateway(r, v4gw, v6gw)
+		dst := r.Dst
+		route := netlink.Route{Dst: &dst, LinkIndex: link.Attrs().Index, Gw: gw}
+		if err := netif.Default.RouteAddEcmp(&route); err != nil && !errors.Is(err, syscall.EEXIST) {
+			return fmt.Errorf("failed to add route '%v via %v dev %v': %v", r.Dst, gw, ifName, err)
+		}
+	}
+	return nil
+}
+
+// familyGateways returns the first IPv4 and IPv6 gateway of addresses of the
+// result, like ipam.ConfigureIface picks them
+func familyGateways(ips []*current.IPConfig) (v4gw, v6gw net.IP) {
+	for _, ipc := range ips {
+		switch {
+		case ipc.Gateway == nil:
+		case ipc.Gateway.To4() != nil:
+			if v4gw == nil {
+				v4gw = ipc.Gateway
+			}
+		case v6gw == nil:
+			v6gw = ipc.Gateway
+		}
+	}
+	return v4gw, v6gw
+}
+
+// routeGateway returns the gateway of the route, routes of the result
+// without one go via the gateway of their address family
+func routeGateway(route *cnitypes.Route, v4gw, v6gw net.IP) net.IP {
+	if route.GW != nil {
+		return route.GW
+	}
+	if route.Dst.IP.To4() != nil {
+		return v4gw
+	}
+	return v6gw
+}
+
+// setRASysctls sets accept_ra and autoconf of the container interface as
+// configured, before its port is attached and it can receive router
+// advertisements. Interfaces without IPv6 don't get any, the sysctls are
+// skipped then.
+func setRASysctls(netconf *types.NetConf, ifName string) error {
+	for _, setting := range []struct {
+		name    string
+		enabled *bool
+	}{
+		{"accept_ra", netconf.AcceptRA},
+		{"autoconf", netconf.IPv6Autoconf},
+	} {
+		if setting.enabled == nil {
+			continue
+		}
+		name := fmt.Sprintf("net/ipv6/conf/%s/%s", ifName, setting.name)
+		if _, err := netif.Default.Sysctl(name); os.IsNotExist(err) {
+			log.Printf("Warning: IPv6 is disabled, skipping %s of %q", setting.name, ifName)
+			continue
+		}
+		value := "0"
+		if *setting.enabled {
+			value = "1"
+		}
+		if _, err := netif.Default.Sysctl(name, value); err != nil {
+			return fmt.Errorf("failed to set %s of %q to %s: %v", setting.name, ifName, value, err)
+		}
+	}
+	return nil
+}
+
+// enableIPv6 makes sure IPv6 is enabled on loopback and the interface
+// before an IPv6 address is added
+func enableIPv6(ifName string) error {
+	for _, iface := range []string{"lo", ifName} {
+		name := fmt.Sprintf(ipam.DisableIPv6SysctlTemplate, iface)
+		value, err := netif.Default.Sysctl(name)
+		if err != nil {
+			log.Printf("Failed to read sysctl %q: %v", name, err)
+			continue
+		}
+		if value == "0" {
+			continue
+		}
+		if _, err := netif.Default.Sysctl(name, "0"); err != nil {
+			return fmt.Errorf("failed to enable IPv6 for interface %q (%s=%s): %v", iface, name, value, err)
+		}
+	}
+	return nil
+}
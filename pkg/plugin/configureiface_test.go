@@ -0,0 +1,114 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !simulation
+
+package plugin
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	ovscnitypes "github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("ConfigureIfacePreservingAddresses", func() {
+	It("should merge the result with addresses already on the interface", func() {
+		targetNs := newNS()
+		defer closeNS(targetNs)
+
+		ipNet := func(cidr string) net.IPNet {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			Expect(err).NotTo(HaveOccurred())
+			ipNet.IP = ip
+			return *ipNet
+		}
+		result := &current.Result{
+			Interfaces: []*current.Interface{{Name: "veth0"}},
+			IPs: []*current.IPConfig{
+				{Interface: current.Int(0), Address: ipNet("10.1.3.5/24"), Gateway: net.ParseIP("10.1.3.1")},
+				{Interface: current.Int(0), Address: ipNet("10.1.3.6/24"), Gateway: net.ParseIP("10.1.3.1")},
+				{Interface: current.Int(0), Address: ipNet("10.1.3.6/24"), Gateway: net.ParseIP("10.1.3.1")},
+			},
+			Routes: []*types.Route{{Dst: ipNet("10.2.0.0/16")}},
+		}
+
+		err := targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "peer0"})).To(Succeed())
+			link, err := netlink.LinkByName("veth0")
+			Expect(err).NotTo(HaveOccurred())
+			for _, cidr := range []string{"10.1.3.5/24", "192.168.1.5/24"} {
+				address := ipNet(cidr)
+				Expect(netlink.AddrAdd(link, &netlink.Addr{IPNet: &address})).To(Succeed())
+			}
+
+			Expect(ConfigureIfacePreservingAddresses("veth0", result)).To(Succeed())
+			// reattaching with the same result keeps everything as is
+			Expect(ConfigureIfacePreservingAddresses("veth0", result)).To(Succeed())
+
+			addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			var cidrs []string
+			for _, addr := range addrs {
+				cidrs = append(cidrs, addr.IPNet.String())
+			}
+			Expect(cidrs).To(ConsistOf("10.1.3.5/24", "10.1.3.6/24", "192.168.1.5/24"))
+			dst := ipNet("10.2.0.0/16")
+			routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Dst: &dst}, netlink.RT_FILTER_DST)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(HaveLen(1))
+			Expect(routes[0].Gw.String()).To(Equal("10.1.3.1"))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("setRASysctls", func() {
+	It("should set accept_ra and autoconf of the interface", func() {
+		targetNs := newNS()
+		defer closeNS(targetNs)
+
+		disabled, enabled := false, true
+		err := targetNs.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "peer0"})).To(Succeed())
+
+			Expect(setRASysctls(&ovscnitypes.NetConf{AcceptRA: &disabled, IPv6Autoconf: &disabled}, "veth0")).To(Succeed())
+			for _, name := range []string{"accept_ra", "autoconf"} {
+				value, err := sysctl.Sysctl("net/ipv6/conf/veth0/" + name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(Equal("0"))
+			}
+
+			Expect(setRASysctls(&ovscnitypes.NetConf{AcceptRA: &enabled}, "veth0")).To(Succeed())
+			value, err := sysctl.Sysctl("net/ipv6/conf/veth0/accept_ra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("1"))
+			value, err = sysctl.Sysctl("net/ipv6/conf/veth0/autoconf")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("0"))
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
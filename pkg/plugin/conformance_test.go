@@ -0,0 +1,193 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build simulation
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cniversion "github.com/containernetworking/cni/pkg/version"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// runPlugin runs the command through the dispatcher of the plugin binary, so
+// version negotiation of skel is exercised as well, and returns its output
+func runPlugin(command string, args *skel.CmdArgs) ([]byte, *cnitypes.Error) {
+	GinkgoT().Setenv("CNI_COMMAND", command)
+	GinkgoT().Setenv("CNI_CONTAINERID", args.ContainerID)
+	GinkgoT().Setenv("CNI_NETNS", args.Netns)
+	GinkgoT().Setenv("CNI_IFNAME", args.IfName)
+	GinkgoT().Setenv("CNI_PATH", "/opt/cni/bin")
+	// the simulated netns is not a real one skel could verify
+	GinkgoT().Setenv("CNI_NETNS_OVERRIDE", "1")
+
+	dir := GinkgoT().TempDir()
+	stdinPath, stdoutPath := filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout")
+	ExpectWithOffset(1, os.WriteFile(stdinPath, args.StdinData, 0600)).To(Succeed())
+	stdin, err := os.Open(stdinPath)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	defer stdin.Close()
+	stdout, err := os.Create(stdoutPath)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	defer stdout.Close()
+
+	origStdin, origStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	cniErr := skel.PluginMainFuncsWithError(skel.CNIFuncs{
+		Add:    CmdAdd,
+		Check:  CmdCheck,
+		Del:    CmdDel,
+		GC:     CmdGC,
+		Status: CmdStatus,
+	}, cniversion.All, "")
+	os.Stdin, os.Stdout = origStdin, origStdout
+
+	out, err := os.ReadFile(stdoutPath)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return out, cniErr
+}
+
+// expectSuccess fails with the message of the error returned by the plugin
+func expectSuccess(cniErr *cnitypes.Error) {
+	ExpectWithOffset(1, cniErr).To(BeNil(), func() string { return cniErr.Error() })
+}
+
+// expectIncompatible fails unless the plugin rejected the spec version
+func expectIncompatible(cniErr *cnitypes.Error) {
+	ExpectWithOffset(1, cniErr).NotTo(BeNil())
+	ExpectWithOffset(1, cniErr.Code).To(Equal(uint(cnitypes.ErrIncompatibleCNIVersion)))
+}
+
+// Attachments have no IPAM, the simulation doesn't isolate addresses of
+// interfaces of simulated netns from the host.
+var _ = Describe("CNI spec conformance", func() {
+	const bridge = "br-conformance"
+	const contNetnsPath = "/var/run/netns/conformance"
+	var fake *testhelpers.FakeOVSDB
+
+	BeforeEach(func() {
+		netif.Default = netif.NewSimulated()
+		netns.NewSimulated(contNetnsPath)
+		DeferCleanup(netns.DeleteSimulated, contNetnsPath)
+		var err error
+		fake, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(fake.Close)
+		cacheDir, lockDir, hooksDir := utils.DefaultCacheDir, utils.DefaultLockDir, config.HooksDir
+		utils.DefaultCacheDir, utils.DefaultLockDir, config.HooksDir = GinkgoT().TempDir(), GinkgoT().TempDir(), GinkgoT().TempDir()
+		DeferCleanup(func() {
+			utils.DefaultCacheDir, utils.DefaultLockDir, config.HooksDir = cacheDir, lockDir, hooksDir
+		})
+	})
+
+	for _, specVersion := range cniversion.All.SupportedVersions() {
+		specVersion := specVersion
+		Context("with spec version "+specVersion, func() {
+			var args *skel.CmdArgs
+			conf := func(extra string) []byte {
+				return []byte(fmt.Sprintf(`{"cniVersion": %q, "name": "mynet", "type": "ovs", "bridge": %q, "socket_file": %q%s}`,
+					specVersion, bridge, fake.Endpoint, extra))
+			}
+			atLeast := func(minVersion string) bool {
+				ok, err := cniversion.GreaterThanOrEqualTo(specVersion, minVersion)
+				Expect(err).NotTo(HaveOccurred())
+				return ok
+			}
+			BeforeEach(func() {
+				args = &skel.CmdArgs{ContainerID: "conformance", Netns: contNetnsPath, IfName: "eth0", StdinData: conf("")}
+			})
+
+			It("should return the result in the requested version on ADD and accept DEL", func() {
+				out, cniErr := runPlugin("ADD", args)
+				if !atLeast("0.3.0") {
+					// results before 0.3.0 carry only addresses of the IPAM
+					Expect(cniErr).NotTo(BeNil())
+					Expect(cniErr.Code).To(Equal(uint(cnitypes.ErrInvalidNetworkConfig)))
+					Expect(fake.Select("Port")).To(BeEmpty())
+					return
+				}
+				expectSuccess(cniErr)
+				result, err := cniversion.NewResult(specVersion, out)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Version()).To(Equal(specVersion))
+				Expect(fake.Select("Port")).To(HaveLen(1))
+
+				_, cniErr = runPlugin("DEL", args)
+				expectSuccess(cniErr)
+				Expect(fake.Select("Port")).To(BeEmpty())
+				// DEL of an attachment which is gone succeeds
+				_, cniErr = runPlugin("DEL", args)
+				expectSuccess(cniErr)
+			})
+			It("should accept CHECK only from spec version 0.4.0", func() {
+				if !atLeast("0.4.0") {
+					_, cniErr := runPlugin("CHECK", args)
+					expectIncompatible(cniErr)
+					return
+				}
+				out, cniErr := runPlugin("ADD", args)
+				expectSuccess(cniErr)
+				args.StdinData = conf(fmt.Sprintf(`, "prevResult": %s`, out))
+				_, cniErr = runPlugin("CHECK", args)
+				expectSuccess(cniErr)
+				args.StdinData = conf("")
+				_, cniErr = runPlugin("DEL", args)
+				expectSuccess(cniErr)
+			})
+			It("should accept STATUS and GC only from spec version 1.1.0", func() {
+				status := &skel.CmdArgs{StdinData: conf("")}
+				gc := &skel.CmdArgs{StdinData: conf(`, "cni.dev/valid-attachments": []`)}
+				if !atLeast("1.1.0") {
+					_, cniErr := runPlugin("STATUS", status)
+					expectIncompatible(cniErr)
+					_, cniErr = runPlugin("GC", gc)
+					expectIncompatible(cniErr)
+					return
+				}
+				_, cniErr := runPlugin("ADD", args)
+				expectSuccess(cniErr)
+				_, cniErr = runPlugin("STATUS", status)
+				expectSuccess(cniErr)
+				_, cniErr = runPlugin("GC", gc)
+				expectSuccess(cniErr)
+				Expect(fake.Select("Port")).To(BeEmpty())
+			})
+		})
+	}
+
+	It("should reject spec versions which are not supported", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "conformance",
+			Netns:       contNetnsPath,
+			IfName:      "eth0",
+			StdinData:   []byte(fmt.Sprintf(`{"cniVersion": "9.9.9", "name": "mynet", "type": "ovs", "bridge": %q, "socket_file": %q}`, bridge, fake.Endpoint)),
+		}
+		_, cniErr := runPlugin("ADD", args)
+		expectIncompatible(cniErr)
+		Expect(fake.Select("Port")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// defaultOvsdbSocketPath is the OVSDB socket used when socket_file is not set
+const defaultOvsdbSocketPath = "/var/run/openvswitch/db.sock"
+
+// ovsdbSocketPath returns path of the OVSDB unix socket of the node, false
+// when OVSDB is reached over tcp or ssl
+func ovsdbSocketPath(netconf *types.NetConf) (string, bool) {
+	if netconf.SocketFile == "" {
+		return defaultOvsdbSocketPath, true
+	}
+	if !strings.HasPrefix(netconf.SocketFile, "unix:") {
+		return "", false
+	}
+	return strings.TrimPrefix(netconf.SocketFile, "unix:"), true
+}
+
+// ovsUnavailable returns true when the degraded mode is configured and the
+// OVSDB unix socket doesn't exist on the node
+func ovsUnavailable(netconf *types.NetConf) bool {
+	if netconf.OvsUnavailable == nil {
+		return false
+	}
+	path, isUnix := ovsdbSocketPath(netconf)
+	if !isUnix {
+		return false
+	}
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
+// newOvsUnavailableError returns the error of ADD and CHECK failed fast
+func newOvsUnavailableError(netconf *types.NetConf) error {
+	path, _ := ovsdbSocketPath(netconf)
+	return newError(types.ErrOvsUnavailable, fmt.Errorf("OVS is not available on the node, OVSDB socket %s doesn't exist", path))
+}
+
+// fallbackConf returns type and configuration of the fallback plugin, it
+// inherits name, CNI version and previous result of the network
+func fallbackConf(netconf *types.NetConf) (string, []byte, error) {
+	conf := make(map[string]interface{}, len(netconf.OvsUnavailable.Plugin)+3)
+	for key, value := range netconf.OvsUnavailable.Plugin {
+		conf[key] = value
+	}
+	conf["name"] = netconf.Name
+	conf["cniVersion"] = netconf.CNIVersion
+	if netconf.RawPrevResult != nil {
+		conf["prevResult"] = netconf.RawPrevResult
+	}
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode configuration of the fallback plugin: %v", err)
+	}
+	pluginType, _ := conf["type"].(string)
+	return pluginType, data, nil
+}
+
+// addDegraded fails ADD fast or delegates it to the fallback plugin when OVS
+// is not available on the node
+func addDegraded(args *skel.CmdArgs, netconf *types.NetConf) error {
+	if netconf.OvsUnavailable.Action != config.OvsUnavailableDelegate {
+		return newOvsUnavailableError(netconf)
+	}
+	pluginType, conf, err := fallbackConf(netconf)
+	if err != nil {
+		return err
+	}
+	log.Printf("Warning: OVS is not available on the node, delegating ADD to %s", pluginType)
+
+	// DEL and CHECK are delegated as well, even once OVS is back
+	cRef := config.GetNetworkCRef(netconf.Name, args.ContainerID, args.IfName)
+	if err := utils.SaveCache(cRef, &types.CachedNetConf{Netconf: netconf, ContainerID: args.ContainerID, IfName: args.IfName, Netns: args.Netns, Delegated: true}); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+	result, err := invoke.DelegateAdd(context.TODO(), pluginType, conf, nil)
+	if err != nil {
+		return err
+	}
+	return cnitypes.PrintResult(result, netconf.CNIVersion)
+}
+
+// delDelegated delegates DEL of an attachment added by the fallback plugin
+func delDelegated(netconf *types.NetConf) error {
+	pluginType, conf, err := fallbackConf(netconf)
+	if err != nil {
+		return err
+	}
+	return invoke.DelegateDel(context.TODO(), pluginType, conf, nil)
+}
+
+// checkDegraded delegates CHECK of an attachment added by the fallback plugin
+// and fails it fast when OVS is not available, it returns false when the
+// attachment is to be checked in OVS
+func checkDegraded(args *skel.CmdArgs, netconf *types.NetConf) (bool, error) {
+	cache, _, err := config.LoadNetworkConfFromCache(netconf.Name, args.ContainerID, args.IfName)
+	if err == nil && cache.Delegated {
+		delegated := *netconf
+		delegated.OvsUnavailable = cache.Netconf.OvsUnavailable
+		pluginType, conf, err := fallbackConf(&delegated)
+		if err != nil {
+			return true, err
+		}
+		return true, invoke.DelegateCheck(context.TODO(), pluginType, conf, nil)
+	}
+	if ovsUnavailable(netconf) {
+		return true, newOvsUnavailableError(netconf)
+	}
+	return false, nil
+}
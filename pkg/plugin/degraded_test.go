@@ -0,0 +1,95 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+var _ = Describe("Degraded mode", func() {
+	var (
+		callsFile string
+		netconf   *types.NetConf
+		args      *skel.CmdArgs
+	)
+	BeforeEach(func() {
+		pluginDir := GinkgoT().TempDir()
+		callsFile = filepath.Join(GinkgoT().TempDir(), "calls")
+		fallback := "#!/bin/sh\necho $CNI_COMMAND >> " + callsFile + "\n" +
+			"[ $CNI_COMMAND = ADD ] && echo '{\"cniVersion\": \"1.0.0\", \"interfaces\": [{\"name\": \"eth0\"}]}'\nexit 0\n"
+		Expect(os.WriteFile(filepath.Join(pluginDir, "fallback"), []byte(fallback), 0700)).To(Succeed())
+		GinkgoT().Setenv("CNI_PATH", pluginDir)
+
+		netconf = &types.NetConf{
+			BrName:         "br1",
+			SocketFile:     "unix:" + filepath.Join(GinkgoT().TempDir(), "db.sock"),
+			OvsUnavailable: &types.OvsUnavailable{Action: config.OvsUnavailableDelegate, Plugin: map[string]interface{}{"type": "fallback"}},
+		}
+		netconf.Name = "mynet"
+		netconf.CNIVersion = "1.0.0"
+		args = &skel.CmdArgs{ContainerID: "degraded", IfName: "eth0", Netns: "/var/run/netns/test"}
+	})
+	AfterEach(func() {
+		Expect(utils.CleanCache(config.GetNetworkCRef(netconf.Name, args.ContainerID, args.IfName))).To(Succeed())
+	})
+	It("should consider OVS unavailable only when the socket doesn't exist", func() {
+		Expect(ovsUnavailable(netconf)).To(BeTrue())
+		path, _ := ovsdbSocketPath(netconf)
+		Expect(os.WriteFile(path, nil, 0600)).To(Succeed())
+		Expect(ovsUnavailable(netconf)).To(BeFalse())
+
+		netconf.SocketFile = "tcp:127.0.0.1:6640"
+		Expect(ovsUnavailable(netconf)).To(BeFalse())
+		netconf.SocketFile = "unix:/nonexistent/db.sock"
+		netconf.OvsUnavailable = nil
+		Expect(ovsUnavailable(netconf)).To(BeFalse())
+	})
+	It("should fail fast with a specific error code", func() {
+		netconf.OvsUnavailable = &types.OvsUnavailable{Action: config.OvsUnavailableFail}
+		err := addDegraded(args, netconf)
+		var cniErr *cnitypes.Error
+		Expect(errors.As(err, &cniErr)).To(BeTrue())
+		Expect(cniErr.Code).To(Equal(types.ErrOvsUnavailable))
+		degraded, err := checkDegraded(args, netconf)
+		Expect(degraded).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("OVS is not available on the node")))
+	})
+	It("should delegate ADD, CHECK and DEL to the fallback plugin", func() {
+		Expect(addDegraded(args, netconf)).To(Succeed())
+		cache, _, err := config.LoadNetworkConfFromCache(netconf.Name, args.ContainerID, args.IfName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cache.Delegated).To(BeTrue())
+
+		degraded, err := checkDegraded(args, netconf)
+		Expect(degraded).To(BeTrue())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delDelegated(cache.Netconf)).To(Succeed())
+
+		calls, err := os.ReadFile(callsFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(calls)).To(Equal("ADD\nCHECK\nDEL\n"))
+	})
+})
@@ -0,0 +1,61 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadutils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// deviceInfoPath returns the device info file of the attachment, the one
+// Multus injected into the netconf or else the one it looks up by default
+func deviceInfoPath(netconf *types.NetConf, containerID, ifName string) string {
+	if netconf.CNIDeviceInfoFile != "" {
+		return netconf.CNIDeviceInfoFile
+	}
+	return nadutils.GetCNIDeviceInfoPath(fmt.Sprintf("%s-%s-%s-device-info.json", netconf.Name, containerID, ifName))
+}
+
+// saveDeviceInfo writes the device info of a VF or vhost-user attachment, so
+// Multus can publish it in the network-status annotation and the pod can
+// find its PCI device or vhost-user socket. Nothing is written for veth
+// attachments without device info.
+func saveDeviceInfo(netconf *types.NetConf, containerID, ifName string, info *nadv1.DeviceInfo) error {
+	if info == nil {
+		return nil
+	}
+	path := deviceInfoPath(netconf, containerID, ifName)
+	// a file left by an earlier ADD of the attachment is replaced
+	if err := nadutils.CleanDeviceInfoForCNI(path); err != nil {
+		return fmt.Errorf("failed to remove stale device info of %s: %v", ifName, err)
+	}
+	if err := nadutils.SaveDeviceInfoForCNI(path, info); err != nil {
+		return fmt.Errorf("failed to write device info of %s: %v", ifName, err)
+	}
+	return nil
+}
+
+// cleanDeviceInfo removes the device info file of the attachment, a missing
+// one is ignored and failures are only logged as they must not block DEL
+func cleanDeviceInfo(netconf *types.NetConf, containerID, ifName string) {
+	if err := nadutils.CleanDeviceInfoForCNI(deviceInfoPath(netconf, containerID, ifName)); err != nil {
+		log.Printf("Failed to remove device info of %s: %v", ifName, err)
+	}
+}
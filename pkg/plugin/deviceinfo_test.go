@@ -0,0 +1,75 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Device info", func() {
+	var netconf *types.NetConf
+
+	BeforeEach(func() {
+		netconf = &types.NetConf{CNIDeviceInfoFile: filepath.Join(GinkgoT().TempDir(), "devinfo", "cni", "net-a-abc_net1")}
+		netconf.Name = "net-a"
+	})
+
+	It("should look up the file Multus looks up by default", func() {
+		netconf.CNIDeviceInfoFile = ""
+		Expect(deviceInfoPath(netconf, "abc", "net1")).To(Equal("/var/run/k8s.cni.cncf.io/devinfo/cni/net-a-abc-net1-device-info.json"))
+		netconf.Name = "ns1/net-a"
+		Expect(deviceInfoPath(netconf, "abc", "net1")).To(Equal("/var/run/k8s.cni.cncf.io/devinfo/cni/ns1-net-a-abc-net1-device-info.json"))
+	})
+	It("should write and remove the device info file injected by Multus", func() {
+		info := &nadv1.DeviceInfo{
+			Type:      nadv1.DeviceInfoTypeVHostUser,
+			Version:   nadv1.DeviceInfoVersion,
+			VhostUser: &nadv1.VhostDevice{Mode: nadv1.VhostDeviceModeServer, Path: "/var/run/ovs-cni/vhostuser/abc/socket"},
+		}
+		Expect(deviceInfoPath(netconf, "abc", "net1")).To(Equal(netconf.CNIDeviceInfoFile))
+		Expect(saveDeviceInfo(netconf, "abc", "net1", info)).To(Succeed())
+
+		data, err := os.ReadFile(netconf.CNIDeviceInfoFile)
+		Expect(err).NotTo(HaveOccurred())
+		saved := &nadv1.DeviceInfo{}
+		Expect(json.Unmarshal(data, saved)).To(Succeed())
+		Expect(saved).To(Equal(info))
+
+		// a repeated ADD replaces the file
+		info.VhostUser.Path = "/var/run/ovs-cni/vhostuser/abc/socket2"
+		Expect(saveDeviceInfo(netconf, "abc", "net1", info)).To(Succeed())
+		data, err = os.ReadFile(netconf.CNIDeviceInfoFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(data, saved)).To(Succeed())
+		Expect(saved).To(Equal(info))
+
+		cleanDeviceInfo(netconf, "abc", "net1")
+		Expect(netconf.CNIDeviceInfoFile).NotTo(BeAnExistingFile())
+		// removing it again is a no-op
+		cleanDeviceInfo(netconf, "abc", "net1")
+	})
+	It("should not write a file for attachments without device info", func() {
+		Expect(saveDeviceInfo(netconf, "abc", "net1", nil)).To(Succeed())
+		Expect(filepath.Dir(netconf.CNIDeviceInfoFile)).NotTo(BeADirectory())
+	})
+})
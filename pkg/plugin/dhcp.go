@@ -0,0 +1,94 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	dhcpIPAMType         = "dhcp"
+	dhcpClientIDOption   = "dhcp-client-identifier"
+	dhcpHostNameOption   = "host-name"
+	dhcpClientIDPrefix   = "ovs-cni-"
+	dhcpClientIDHashSize = 16
+)
+
+// podIdentity identifies a pod attachment across pod restarts, unlike
+// container ID or pod UID it stays the same when the pod is recreated
+type podIdentity struct {
+	bridge    string
+	namespace string
+	name      string
+	ifName    string
+}
+
+func (p podIdentity) known() bool {
+	return p.name != ""
+}
+
+func (p podIdentity) hash() [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.Join([]string{p.bridge, p.namespace, p.name, p.ifName}, "/")))
+}
+
+// dhcpClientID returns a stable DHCP client identifier for the attachment
+func (p podIdentity) dhcpClientID() string {
+	hash := p.hash()
+	return dhcpClientIDPrefix + hex.EncodeToString(hash[:dhcpClientIDHashSize/2])
+}
+
+// stableHWAddr returns a locally administered MAC address derived from the
+// pod identity, so DHCP servers binding leases to MAC see the same address
+// after the pod is recreated.
+func (p podIdentity) stableHWAddr() net.HardwareAddr {
+	hash := p.hash()
+	return net.HardwareAddr{0x0A, 0x59, hash[0], hash[1], hash[2], hash[3]}
+}
+
+// addDHCPOptions extends IPAM configuration of the dhcp plugin with client
+// identifier and host name options derived from the pod identity. Options
+// already provided by the user are kept untouched.
+func addDHCPOptions(stdinData []byte, pod podIdentity) ([]byte, error) {
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(stdinData, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse netconf: %v", err)
+	}
+	ipamConf, ok := conf["ipam"].(map[string]interface{})
+	if !ok {
+		return stdinData, nil
+	}
+
+	provide, _ := ipamConf["provide"].([]interface{})
+	provided := map[string]bool{}
+	for _, item := range provide {
+		if option, ok := item.(map[string]interface{}); ok {
+			provided[fmt.Sprintf("%v", option["option"])] = true
+		}
+	}
+	if !provided[dhcpClientIDOption] {
+		provide = append(provide, map[string]interface{}{"option": dhcpClientIDOption, "value": pod.dhcpClientID()})
+	}
+	if !provided[dhcpHostNameOption] {
+		provide = append(provide, map[string]interface{}{"option": dhcpHostNameOption, "value": pod.name})
+	}
+	ipamConf["provide"] = provide
+
+	return json.Marshal(conf)
+}
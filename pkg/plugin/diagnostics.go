@@ -0,0 +1,143 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/openflow"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/unixctl"
+)
+
+const datapathActionsPrefix = "Datapath actions:"
+
+// ovsRunDir returns OVS run directory derived from the OVSDB socket, ovs-vswitchd
+// control socket is expected next to the database socket
+func ovsRunDir(socketFile string) string {
+	path := strings.TrimPrefix(socketFile, "unix:")
+	if path == "" || strings.HasPrefix(path, "tcp:") || strings.HasPrefix(path, "ssl:") {
+		return unixctl.DefaultRunDir
+	}
+	return filepath.Dir(path)
+}
+
+// summarizeTrace returns forwarding verdict from the output of ofproto/trace
+func summarizeTrace(trace string) string {
+	for _, line := range strings.Split(trace, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, datapathActionsPrefix) {
+			continue
+		}
+		actions := strings.TrimSpace(strings.TrimPrefix(line, datapathActionsPrefix))
+		if actions == "" || actions == "drop" {
+			return "dropped"
+		}
+		return fmt.Sprintf("forwarded (datapath actions: %s)", actions)
+	}
+	return "unknown"
+}
+
+// forwardingVerdict traces a broadcast frame sent from the container through
+// the bridge and returns a summary of the datapath actions
+func forwardingVerdict(netconf *types.NetConf, portName, mac string) string {
+	client, err := unixctl.NewTargetClient(ovsRunDir(bridgeSocketFile(netconf)), unixctl.VswitchdTarget)
+	if err != nil {
+		return fmt.Sprintf("not available: %v", err)
+	}
+	flow := fmt.Sprintf("in_port=%s,dl_dst=ff:ff:ff:ff:ff:ff", portName)
+	if mac != "" {
+		flow += ",dl_src=" + mac
+	}
+	trace, err := client.Call("ofproto/trace", netconf.BrName, flow)
+	if err != nil {
+		return fmt.Sprintf("not available: %v", err)
+	}
+	return summarizeTrace(trace)
+}
+
+// withForwardingVerdict adds forwarding verdict of the port to the error
+// of a failed CHECK, when diagnostics are enabled
+func withForwardingVerdict(err error, netconf *types.NetConf, portName, mac string) error {
+	if !netconf.OvsDiagnostics || portName == "" {
+		return err
+	}
+	return withDiagnostics(err, "forwarding verdict: "+forwardingVerdict(netconf, portName, mac))
+}
+
+// withDiagnostics appends diagnostics to the error of a failed CHECK, keeping
+// its CNI error code
+func withDiagnostics(err error, diagnostics string) error {
+	var cniErr *cnitypes.Error
+	if errors.As(err, &cniErr) {
+		return cnitypes.NewError(cniErr.Code, fmt.Sprintf("%s (%s)", cniErr.Msg, diagnostics), cniErr.Details)
+	}
+	return fmt.Errorf("%w (%s)", err, diagnostics)
+}
+
+// summarizeBridgeState returns fail mode, state of controllers and number of
+// flows of the bridge, a secure bridge with no connected controller and no
+// flows drops all traffic
+func summarizeBridgeState(bridgeName string, state *ovsdb.BridgeControlState, flows string) string {
+	failMode := state.FailMode
+	if failMode == "" {
+		failMode = "standalone"
+	}
+	controllers := []string{}
+	for target, connected := range state.Controllers {
+		if connected {
+			controllers = append(controllers, fmt.Sprintf("controller %s connected", target))
+		} else {
+			controllers = append(controllers, fmt.Sprintf("controller %s disconnected", target))
+		}
+	}
+	sort.Strings(controllers)
+	if len(controllers) == 0 {
+		controllers = append(controllers, "no controller")
+	}
+	return fmt.Sprintf("bridge %s: fail_mode %s, %s, %s", bridgeName, failMode, strings.Join(controllers, ", "), flows)
+}
+
+// bridgeState looks up the OpenFlow control state of the bridge of the
+// attachment through the connection CHECK already has. Flows are only counted
+// when requested, as that takes an OpenFlow connection.
+func bridgeState(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, countFlows bool) string {
+	state, err := ovsBridgeDriver.GetBridgeControlState(netconf.BrName)
+	if err != nil {
+		return fmt.Sprintf("bridge %s: state not available: %v", netconf.BrName, err)
+	}
+	flows := "flows not counted"
+	if countFlows {
+		flows = "flows not available"
+		if count, err := openflow.NewClient(netconf.BrName).FlowCount(); err == nil {
+			flows = fmt.Sprintf("%d flows", count)
+		}
+	}
+	return summarizeBridgeState(netconf.BrName, state, flows)
+}
+
+// withBridgeState adds fail mode, controllers and, with diagnostics, number
+// of flows of the bridge to the error of a failed CHECK, missing controller
+// flows are the most common cause of an attachment without connectivity
+func withBridgeState(err error, ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf) error {
+	return withDiagnostics(err, bridgeState(ovsBridgeDriver, netconf, netconf.OvsDiagnostics))
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+)
+
+var _ = Describe("CHECK diagnostics", func() {
+	It("should keep the CNI error code of the failure", func() {
+		err := withDiagnostics(cnitypes.NewError(cnitypes.ErrTryAgainLater, "port is down", "details"), "bridge br1: fail_mode secure")
+		var cniErr *cnitypes.Error
+		Expect(errors.As(err, &cniErr)).To(BeTrue())
+		Expect(cniErr.Code).To(Equal(cnitypes.ErrTryAgainLater))
+		Expect(cniErr.Msg).To(Equal("port is down (bridge br1: fail_mode secure)"))
+		Expect(cniErr.Details).To(Equal("details"))
+	})
+	It("should wrap other failures", func() {
+		cause := fmt.Errorf("vlan tag mismatch")
+		err := withDiagnostics(cause, "forwarding verdict: dropped")
+		Expect(err).To(MatchError("vlan tag mismatch (forwarding verdict: dropped)"))
+		Expect(errors.Is(err, cause)).To(BeTrue())
+	})
+	It("should summarize the bridge state", func() {
+		state := &ovsdb.BridgeControlState{FailMode: "secure", Controllers: map[string]bool{"tcp:10.0.0.2:6653": true, "tcp:10.0.0.1:6653": false}}
+		Expect(summarizeBridgeState("br1", state, "0 flows")).To(Equal("bridge br1: fail_mode secure, controller tcp:10.0.0.1:6653 disconnected, controller tcp:10.0.0.2:6653 connected, 0 flows"))
+		Expect(summarizeBridgeState("br1", &ovsdb.BridgeControlState{}, "flows not counted")).To(Equal("bridge br1: fail_mode standalone, no controller, flows not counted"))
+	})
+})
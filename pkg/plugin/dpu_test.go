@@ -0,0 +1,116 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/ovn-org/libovsdb/ovsdb"
+
+	ovsdbdriver "github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("DPU-hosted bridge", func() {
+	const bridge = "br-dpu"
+	// hostRep is the representor of the VF on the host, the DPU one is pf0vf2
+	const hostRep = "enp3s0f0_2"
+	var host, dpu *testhelpers.FakeOVSDB
+	var netconf *types.NetConf
+
+	BeforeEach(func() {
+		var err error
+		host, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(host.Close)
+		dpu, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(dpu.Close)
+		netconf = &types.NetConf{BrName: bridge, DeviceID: "0000:03:00.2", SocketFile: host.Endpoint, BridgeSocketFile: dpu.Endpoint}
+		netconf.Name = "mynet"
+		origDevices := sriov.Devices
+		DeferCleanup(func() { sriov.Devices = origDevices })
+		sriov.Devices = fakeRepresentorHost{representor: hostRep}
+	})
+
+	portNames := func(fake *testhelpers.FakeOVSDB) []string {
+		rows, err := fake.Select("Port")
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		var names []string
+		for _, row := range rows {
+			names = append(names, row["name"].(string))
+		}
+		return names
+	}
+
+	It("should name the port after the representor on the host without bridge_socket_file", func() {
+		netconf.BridgeSocketFile = ""
+		Expect(representorPortName(netconf, netconf.DeviceID)).To(Equal(hostRep))
+	})
+	It("should name the port after the representor on the DPU", func() {
+		Expect(representorPortName(netconf, netconf.DeviceID)).To(Equal("pf0vf2"))
+		netconf.Representor = &types.Representor{DPUName: "c1pf{pf}vf{vf}"}
+		Expect(representorPortName(netconf, netconf.DeviceID)).To(Equal("c1pf0vf2"))
+	})
+	It("should rename host interfaces of the VFs after their representors on the DPU", func() {
+		hostIfaces := []*current.Interface{{Name: hostRep, Mac: "02:00:00:00:00:02"}}
+		Expect(useDPURepresentors(netconf, []string{netconf.DeviceID}, hostIfaces)).To(Succeed())
+		Expect(*hostIfaces[0]).To(Equal(current.Interface{Name: "pf0vf2"}))
+	})
+	It("should attach, check and remove the port of the representor in the DPU OVSDB", func() {
+		netconf.DeviceIDs = []string{"0000:03:00.3", netconf.DeviceID}
+		hostIfaces := []*current.Interface{{Name: hostRep}, {Name: hostRep}}
+		Expect(useDPURepresentors(netconf, bondedDeviceIDs(netconf), hostIfaces)).To(Succeed())
+
+		driver, err := newBridgeDriver(bridge, netconf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attachPortToBridge(driver, netconf, ovsdbdriver.PortOptions{
+			Name:        hostIfaces[0].Name,
+			ContNetns:   "/var/run/netns/pod",
+			ContIface:   "net1",
+			ContNetwork: netconf.Name,
+		})).To(Succeed())
+		Expect(portNames(dpu)).To(ConsistOf("pf0vf2"))
+		Expect(portNames(host)).To(BeEmpty())
+		Expect(validateOvs(driver, netconf, hostIfaces[0].Name)).To(Succeed())
+
+		// the port of the other VF of a bonded attachment has the same name
+		// as the fake host has a single VF index
+		Expect(removeBondedVFPorts(driver, netconf)).To(Succeed())
+		Expect(portNames(dpu)).To(BeEmpty())
+	})
+	It("should record statistics of the port of the representor in the DPU OVSDB", func() {
+		statsFile := GinkgoT().TempDir() + "/stats.json"
+		netconf.StatsFile = statsFile
+		_, err := dpu.Transact(
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Interface", UUIDName: "intf", Row: ovsdb.Row{"name": "pf0vf2"}},
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Port", Row: ovsdb.Row{"name": "pf0vf2", "interfaces": ovsdb.UUID{GoUUID: "intf"}}, UUIDName: "port"},
+			ovsdb.Operation{
+				Op:        ovsdb.OperationMutate,
+				Table:     "Bridge",
+				Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("ports", ovsdb.MutateOperationInsert, ovsdb.UUID{GoUUID: "port"})},
+				Where:     []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridge)},
+			})
+		Expect(err).NotTo(HaveOccurred())
+		driver, err := newBridgeDriver(bridge, netconf)
+		Expect(err).NotTo(HaveOccurred())
+		recordRepresentorStats(driver, netconf, &skel.CmdArgs{ContainerID: "cid", IfName: "net1"}, nil)
+		Expect(statsFile).To(BeAnExistingFile())
+	})
+})
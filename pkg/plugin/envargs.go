@@ -0,0 +1,235 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// EnvArgs args containing common, desired mac and ovs port name
+type EnvArgs struct {
+	cnitypes.CommonArgs
+	MAC               cnitypes.UnmarshallableString `json:"mac,omitempty"`
+	OvnPort           cnitypes.UnmarshallableString `json:"ovnPort,omitempty"`
+	OfportRequest     ofportArg                     `json:"ofportRequest,omitempty"`
+	Trunk             trunkArg                      `json:"trunk,omitempty"`
+	K8S_POD_UID       cnitypes.UnmarshallableString
+	K8S_POD_NAMESPACE cnitypes.UnmarshallableString
+	K8S_POD_NAME      cnitypes.UnmarshallableString
+
+	// conflicts describes keys repeated with different values, the last
+	// value is parsed
+	conflicts []string
+}
+
+// orchestratorArgs are passed by container runtimes and orchestrators,
+// sometimes without IgnoreUnknown, they are accepted although not used
+var orchestratorArgs = map[string]bool{
+	"K8S_POD_INFRA_CONTAINER_ID": true,
+	"K8S_POD_RUNTIME":            true,
+	"K8S_POD_NETWORK":            true,
+}
+
+// ofportArg is an OpenFlow port number requested for the port
+type ofportArg uint
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (o *ofportArg) UnmarshalText(data []byte) error {
+	ofport, err := strconv.ParseUint(string(data), 10, 16)
+	if err != nil || ofport < 1 || ofport > 65279 {
+		return fmt.Errorf("invalid OpenFlow port number %q", string(data))
+	}
+	*o = ofportArg(ofport)
+	return nil
+}
+
+// trunkArg is a list of VLAN IDs and ranges, e.g. "10,20-30"
+type trunkArg []*types.Trunk
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (t *trunkArg) UnmarshalText(data []byte) error {
+	trunks, err := config.ParseTrunks(string(data))
+	if err != nil {
+		return err
+	}
+	*t = trunkArg(trunks)
+	return nil
+}
+
+// getEnvArgs parses CNI_ARGS, values of arguments known to the plugin are
+// parsed strictly. Unknown arguments fail unless IgnoreUnknown is set,
+// except of the ones passed by orchestrators, their values aren't checked.
+func getEnvArgs(envArgsString string) (*EnvArgs, error) {
+	if envArgsString == "" {
+		return nil, nil
+	}
+	e := EnvArgs{}
+	envArgsType := reflect.TypeOf(e)
+	var known, unknown []string
+	values := map[string]string{}
+	for _, pair := range strings.Split(envArgsString, ";") {
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("ARGS: invalid pair %q", pair)
+		}
+		if previous, repeated := values[key]; repeated && previous != value {
+			e.conflicts = append(e.conflicts, fmt.Sprintf("CNI_ARGS %s is set to both %q and %q", key, previous, value))
+		}
+		values[key] = value
+		if _, isField := envArgsType.FieldByName(key); isField {
+			known = append(known, pair)
+		} else if !orchestratorArgs[key] {
+			unknown = append(unknown, pair)
+		}
+	}
+	if err := cnitypes.LoadArgs(strings.Join(known, ";"), &e); err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 && !e.IgnoreUnknown {
+		return nil, fmt.Errorf("ARGS: unknown args %q", unknown)
+	}
+	return &e, nil
+}
+
+// resolveMAC returns the MAC address requested for the container interface.
+// runtimeConfig.mac takes precedence over args.cni.mac and that over MAC of
+// CNI_ARGS. Requests of different addresses and keys repeated in CNI_ARGS with
+// different values are logged, with strict_args they fail instead.
+func resolveMAC(netconf *types.NetConf, envArgs *EnvArgs) (string, error) {
+	var conflicts []string
+	var sources, macs []string
+	if netconf.RuntimeConfig != nil && netconf.RuntimeConfig.MAC != "" {
+		sources, macs = append(sources, "runtimeConfig.mac"), append(macs, netconf.RuntimeConfig.MAC)
+	}
+	if netconf.Args != nil && netconf.Args.CNI != nil && netconf.Args.CNI.MAC != "" {
+		sources, macs = append(sources, "args.cni.mac"), append(macs, netconf.Args.CNI.MAC)
+	}
+	if envArgs != nil {
+		conflicts = append(conflicts, envArgs.conflicts...)
+		if envArgs.MAC != "" {
+			sources, macs = append(sources, "CNI_ARGS MAC"), append(macs, string(envArgs.MAC))
+		}
+	}
+	for i := 1; i < len(macs); i++ {
+		if !sameMAC(macs[0], macs[i]) {
+			conflicts = append(conflicts, fmt.Sprintf("%s %s differs from %s %s", sources[0], macs[0], sources[i], macs[i]))
+		}
+	}
+	if len(conflicts) > 0 {
+		if netconf.StrictArgs {
+			return "", fmt.Errorf("conflicting args: %s", strings.Join(conflicts, ", "))
+		}
+		log.Printf("Warning: conflicting args, resolved by precedence: %s", strings.Join(conflicts, ", "))
+	}
+	if len(macs) == 0 {
+		return "", nil
+	}
+	return macs[0], nil
+}
+
+// sameMAC compares MAC addresses regardless of their notation
+func sameMAC(a, b string) bool {
+	macA, errA := net.ParseMAC(a)
+	macB, errB := net.ParseMAC(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return bytes.Equal(macA, macB)
+}
+
+// applyEnvArgs overrides attachment settings of the network configuration by
+// the ones requested in CNI_ARGS, before the configuration is validated
+func applyEnvArgs(netconf *types.NetConf, envArgs *EnvArgs) {
+	if envArgs == nil {
+		return
+	}
+	if envArgs.OfportRequest != 0 {
+		netconf.OfportRequest = uint(envArgs.OfportRequest)
+	}
+	if len(envArgs.Trunk) > 0 {
+		netconf.Trunk = envArgs.Trunk
+	}
+}
+
+// applyRuntimeConfig overrides attachment settings of the network
+// configuration by the ones passed by the runtime for capabilities of the
+// plugin, before the configuration is validated
+func applyRuntimeConfig(netconf *types.NetConf) {
+	if netconf.RuntimeConfig == nil {
+		return
+	}
+	// queues are meaningful only for vhost-user attachments, which always
+	// have vhost_user set by defaults
+	if queues := netconf.RuntimeConfig.VhostUserQueues; queues != nil && netconf.VhostUser != nil {
+		if netconf.VhostUser.Queues == nil {
+			netconf.VhostUser.Queues = &types.VhostUserQueues{}
+		}
+		merged := netconf.VhostUser.Queues
+		if queues.NRxq != 0 {
+			merged.NRxq = queues.NRxq
+		}
+		if queues.NTxq != 0 {
+			merged.NTxq = queues.NTxq
+		}
+		if queues.NRxqDesc != 0 {
+			merged.NRxqDesc = queues.NRxqDesc
+		}
+		if queues.NTxqDesc != 0 {
+			merged.NTxqDesc = queues.NTxqDesc
+		}
+	}
+	if bandwidth := netconf.RuntimeConfig.Bandwidth; bandwidth != nil {
+		// egress of the container is policed by rate_limit, keeping its method
+		if bandwidth.EgressRate != 0 {
+			if netconf.RateLimit == nil {
+				netconf.RateLimit = &types.RateLimit{}
+			}
+			netconf.RateLimit.Rate = kilo(bandwidth.EgressRate)
+			netconf.RateLimit.Burst = kilo(bandwidth.EgressBurst)
+			if netconf.RateLimit.Burst == 0 {
+				netconf.RateLimit.Burst = config.DefaultRateLimitBurst(netconf.RateLimit.Rate)
+			}
+		}
+		if bandwidth.IngressRate != 0 {
+			netconf.IngressRateLimit = &types.IngressRateLimit{
+				Rate:  kilo(bandwidth.IngressRate),
+				Burst: kilo(bandwidth.IngressBurst),
+			}
+			if netconf.IngressRateLimit.Burst == 0 {
+				netconf.IngressRateLimit.Burst = config.DefaultRateLimitBurst(netconf.IngressRateLimit.Rate)
+			}
+		}
+	}
+}
+
+// kilo converts bits per second or bits of the bandwidth capability to kbps
+// or kilobits, rounded up so small rates are not disabled
+func kilo(value uint64) uint {
+	return uint((value + 999) / 1000)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("CNI_ARGS", func() {
+	It("should parse arguments of the plugin", func() {
+		envArgs, err := getEnvArgs("MAC=0a:00:00:00:00:80;OvnPort=port1;OfportRequest=5001;Trunk=10,20-30;K8S_POD_NAME=pod1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(envArgs.MAC)).To(Equal("0a:00:00:00:00:80"))
+		Expect(string(envArgs.OvnPort)).To(Equal("port1"))
+		Expect(uint(envArgs.OfportRequest)).To(Equal(uint(5001)))
+		Expect(string(envArgs.K8S_POD_NAME)).To(Equal("pod1"))
+
+		netconf := &types.NetConf{OfportRequest: 1}
+		applyEnvArgs(netconf, envArgs)
+		Expect(netconf.OfportRequest).To(Equal(uint(5001)))
+		Expect(netconf.Trunk).To(HaveLen(2))
+		Expect(*netconf.Trunk[0].ID).To(Equal(uint(10)))
+		Expect(*netconf.Trunk[1].MinID).To(Equal(uint(20)))
+		Expect(*netconf.Trunk[1].MaxID).To(Equal(uint(30)))
+	})
+	It("should accept arguments of orchestrators and empty pairs", func() {
+		envArgs, err := getEnvArgs("K8S_POD_NAMESPACE=ns1;K8S_POD_INFRA_CONTAINER_ID=abc;")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(envArgs.K8S_POD_NAMESPACE)).To(Equal("ns1"))
+	})
+	It("should fail on unknown arguments unless IgnoreUnknown is set", func() {
+		_, err := getEnvArgs("MAC=0a:00:00:00:00:80;foo=bar")
+		Expect(err).To(MatchError(ContainSubstring("unknown args")))
+
+		envArgs, err := getEnvArgs("foo=a=b;MAC=0a:00:00:00:00:80;IgnoreUnknown=1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(envArgs.MAC)).To(Equal("0a:00:00:00:00:80"))
+	})
+	It("should fail on invalid values of arguments of the plugin", func() {
+		for _, args := range []string{
+			"OfportRequest=0;IgnoreUnknown=1",
+			"OfportRequest=65280",
+			"Trunk=10,a",
+			"Trunk=30-20",
+			"Trunk=4096",
+			"IgnoreUnknown=maybe",
+			"MAC",
+		} {
+			_, err := getEnvArgs(args)
+			Expect(err).To(HaveOccurred(), args)
+		}
+	})
+})
+
+var _ = Describe("requested MAC", func() {
+	It("should resolve the MAC address by precedence", func() {
+		envArgs, err := getEnvArgs("MAC=0a:00:00:00:00:80")
+		Expect(err).NotTo(HaveOccurred())
+		netconf := &types.NetConf{}
+		Expect(resolveMAC(netconf, envArgs)).To(Equal("0a:00:00:00:00:80"))
+		netconf.Args = &types.Args{CNI: &types.CNIArgs{MAC: "0a:00:00:00:00:81"}}
+		Expect(resolveMAC(netconf, envArgs)).To(Equal("0a:00:00:00:00:81"))
+		netconf.RuntimeConfig = &types.RuntimeConfig{MAC: "0a:00:00:00:00:82"}
+		Expect(resolveMAC(netconf, envArgs)).To(Equal("0a:00:00:00:00:82"))
+		Expect(resolveMAC(&types.NetConf{}, nil)).To(BeEmpty())
+	})
+	It("should reject conflicting MAC addresses in strict mode", func() {
+		envArgs, err := getEnvArgs("MAC=0A:00:00:00:00:80")
+		Expect(err).NotTo(HaveOccurred())
+		netconf := &types.NetConf{StrictArgs: true, RuntimeConfig: &types.RuntimeConfig{MAC: "0a:00:00:00:00:80"}}
+		Expect(resolveMAC(netconf, envArgs)).To(Equal("0a:00:00:00:00:80"))
+		netconf.Args = &types.Args{CNI: &types.CNIArgs{MAC: "0a:00:00:00:00:81"}}
+		_, err = resolveMAC(netconf, envArgs)
+		Expect(err).To(MatchError("conflicting args: runtimeConfig.mac 0a:00:00:00:00:80 differs from args.cni.mac 0a:00:00:00:00:81"))
+	})
+	It("should reject keys repeated with different values in strict mode", func() {
+		envArgs, err := getEnvArgs("MAC=0a:00:00:00:00:80;K8S_POD_NAME=pod1;MAC=0a:00:00:00:00:81;K8S_POD_NAME=pod1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(envArgs.MAC)).To(Equal("0a:00:00:00:00:81"))
+		Expect(resolveMAC(&types.NetConf{}, envArgs)).To(Equal("0a:00:00:00:00:81"))
+		_, err = resolveMAC(&types.NetConf{StrictArgs: true}, envArgs)
+		Expect(err).To(MatchError(`conflicting args: CNI_ARGS MAC is set to both "0a:00:00:00:00:80" and "0a:00:00:00:00:81"`))
+	})
+})
+
+var _ = Describe("runtimeConfig", func() {
+	It("should override configured vhost-user queues", func() {
+		netconf := &types.NetConf{
+			VhostUser:     &types.VhostUser{Queues: &types.VhostUserQueues{NRxq: 2, NRxqDesc: 512}},
+			RuntimeConfig: &types.RuntimeConfig{VhostUserQueues: &types.VhostUserQueues{NRxq: 4, NTxq: 4}},
+		}
+		applyRuntimeConfig(netconf)
+		Expect(*netconf.VhostUser.Queues).To(Equal(types.VhostUserQueues{NRxq: 4, NTxq: 4, NRxqDesc: 512}))
+		Expect(vhostUserQueueOptions(netconf.VhostUser)).To(Equal(map[string]string{"n_rxq": "4", "n_txq": "4", "n_rxq_desc": "512"}))
+	})
+	It("should turn bandwidth into rate limits", func() {
+		netconf := &types.NetConf{
+			RateLimit: &types.RateLimit{Rate: 5000, Burst: 500, Method: "tc"},
+			RuntimeConfig: &types.RuntimeConfig{Bandwidth: &types.Bandwidth{
+				IngressRate: 20000000, IngressBurst: 4000000, EgressRate: 1500,
+			}},
+		}
+		applyRuntimeConfig(netconf)
+		Expect(*netconf.RateLimit).To(Equal(types.RateLimit{Rate: 2, Burst: 16, Method: "tc"}))
+		Expect(*netconf.IngressRateLimit).To(Equal(types.IngressRateLimit{Rate: 20000, Burst: 4000}))
+
+		qosType, qosConfig, queueConfig := portQoS("", netconf.IngressRateLimit)
+		Expect(qosType).To(Equal("linux-htb"))
+		Expect(qosConfig).To(Equal(map[string]string{"max-rate": "20000000"}))
+		Expect(queueConfig).To(Equal(map[string]string{"max-rate": "20000000", "burst": "4000000"}))
+		qosType, qosConfig, queueConfig = portQoS("dpdkvhostuserclient", netconf.IngressRateLimit)
+		Expect(qosType).To(Equal("egress-policer"))
+		Expect(qosConfig).To(Equal(map[string]string{"cir": "2500000", "cbs": "500000"}))
+		Expect(queueConfig).To(BeNil())
+	})
+	It("should ignore vhost-user queues of other attachments", func() {
+		netconf := &types.NetConf{RuntimeConfig: &types.RuntimeConfig{VhostUserQueues: &types.VhostUserQueues{NRxq: 4}}}
+		applyRuntimeConfig(netconf)
+		Expect(netconf.VhostUser).To(BeNil())
+	})
+})
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+)
+
+// newError returns err as a CNI error with the given code, so runtimes can
+// decide whether to retry. Errors which already are CNI errors, e.g. returned
+// by the IPAM plugin, are returned unchanged.
+func newError(code uint, err error) error {
+	if err == nil {
+		return nil
+	}
+	var cniErr *cnitypes.Error
+	if errors.As(err, &cniErr) {
+		return err
+	}
+	return cnitypes.NewError(code, err.Error(), "")
+}
+
+// wrapError adds context to the message of err, keeping its CNI error code
+func wrapError(err error, msg string) error {
+	var cniErr *cnitypes.Error
+	if errors.As(err, &cniErr) {
+		return cnitypes.NewError(cniErr.Code, msg+": "+cniErr.Msg, cniErr.Details)
+	}
+	return fmt.Errorf("%s: %v", msg, err)
+}
+
+// openNetnsError returns the error of opening the container netns, a netns
+// which stays busy during teardown of the container is worth a retry
+func openNetnsError(path string, err error) error {
+	code := uint(cnitypes.ErrInvalidNetNS)
+	if errors.Is(err, netns.ErrBusy) {
+		code = cnitypes.ErrTryAgainLater
+	}
+	return newError(code, fmt.Errorf("failed to open netns %q: %v", path, err))
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"log"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ip"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// CmdGC garbage collection handler removing attachments of the network which
+// are not listed as valid by the runtime
+func CmdGC(args *skel.CmdArgs) error {
+	logCall("GC", args)
+
+	stdinData, err := config.ExpandStaticIPAM(args.StdinData)
+	if err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
+	}
+	args.StdinData = stdinData
+
+	netconf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return newError(cnitypes.ErrDecodingFailure, err)
+	}
+
+	valid := make(map[string]bool, len(netconf.ValidAttachments))
+	for _, attachment := range netconf.ValidAttachments {
+		valid[config.GetNetworkCRef(netconf.Name, attachment.ContainerID, attachment.IfName)] = true
+	}
+
+	keys, err := utils.ListCache()
+	if err != nil {
+		return err
+	}
+	for _, cRef := range keys {
+		if valid[cRef] {
+			continue
+		}
+		cache, err := config.LoadConfFromCache(cRef)
+		if err != nil {
+			log.Printf("GC: skipping cache entry %s: %v", cRef, err)
+			continue
+		}
+		// entries created before GC was supported don't know their attachment
+		if cache.Netconf == nil || cache.Netconf.Name != netconf.Name || cache.ContainerID == "" {
+			continue
+		}
+		// entries of older versions are cached under a key without the network
+		if valid[config.GetNetworkCRef(cache.Netconf.Name, cache.ContainerID, cache.IfName)] {
+			continue
+		}
+		if err := gcAttachment(cache); err != nil {
+			// keep the cache, the attachment is collected again by the next GC
+			log.Printf("GC: failed to remove stale attachment %s: %v", cRef, err)
+			continue
+		}
+		if err := utils.CleanCache(cRef); err != nil {
+			log.Printf("GC: failed cleaning up cache: %v", err)
+		}
+	}
+
+	// IP addresses of stale attachments are released by the IPAM plugin itself
+	if netconf.IPAM.Type != "" {
+		if err := setupIPAMEnv(netconf); err != nil {
+			return err
+		}
+		ipamPlugins, err := ipamPluginsOf(netconf, args.StdinData)
+		if err != nil {
+			return err
+		}
+		for _, plugin := range ipamPlugins {
+			if err := invoke.DelegateGC(context.TODO(), plugin.pluginType, plugin.stdinData, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gcAttachment removes OVS port and host side interfaces of a stale attachment
+func gcAttachment(cache *types.CachedNetConf) error {
+	log.Printf("GC: removing stale attachment %s of container %s", cache.IfName, cache.ContainerID)
+	cleanCheckReport(cache.Netconf, cache.ContainerID, cache.IfName)
+	if cache.Delegated {
+		// interfaces of the fallback plugin are in the container netns
+		// and are gone with it
+		return nil
+	}
+	if cache.Netconf.Bond != nil {
+		if err := leaveBond(cache.Netns, cache.ContainerID, cache.IfName, cache.Netconf.Bond); err != nil {
+			return err
+		}
+	}
+	ovsBridgeDriver, err := newBridgeDriver(cache.Netconf.BrName, cache.Netconf)
+	if err != nil {
+		return newError(cnitypes.ErrTryAgainLater, err)
+	}
+	if isRoutedMode(cache.Netconf) {
+		if err := teardownRoutedPort(cache.Netconf.BrName, cache.ContainerID, cache.IfName, cache.RoutedIPs); err != nil {
+			return err
+		}
+	}
+	if cache.VlanTranslationPort != "" {
+		if err := teardownVlanTranslation(cache); err != nil {
+			return err
+		}
+	}
+	if isVhostUserMode(cache.Netconf) {
+		return delVhostUser(ovsBridgeDriver, cache.ContainerID, cache.IfName, cache.Netconf)
+	}
+	if cache.Netconf.Backup != nil {
+		if err := delBackup(cache.Netconf, cache.Netns, cache.IfName, cache.BackupPort); err != nil {
+			return err
+		}
+	}
+	portName, portFound, err := getOvsPortForContIface(ovsBridgeDriver, cache.IfName, cache.Netns, cache.Netconf.Name)
+	if err != nil {
+		return err
+	}
+	// pod of the attachment is not known to GC, stats are recorded without it
+	args := &skel.CmdArgs{ContainerID: cache.ContainerID, IfName: cache.IfName, Netns: cache.Netns}
+	if portFound {
+		recordStats(ovsBridgeDriver, cache.Netconf, args, nil, portName)
+		stopCapture(ovsBridgeDriver, cache.Netconf, portName)
+		teardownRateLimit(ovsBridgeDriver, cache.Netconf, portName)
+		if err := removeOvsPort(ovsBridgeDriver, portName); err != nil {
+			return err
+		}
+	} else if cache.UserspaceMode {
+		recordRepresentorStats(ovsBridgeDriver, cache.Netconf, args, nil)
+	}
+	if isBondedVFMode(cache.Netconf) {
+		if err := removeBondedVFPorts(ovsBridgeDriver, cache.Netconf); err != nil {
+			return err
+		}
+	}
+
+	if sriov.IsOvsHardwareOffloadEnabled(cache.Netconf.DeviceID) {
+		// there is no network interface in case of userspace driver
+		if cache.UserspaceMode {
+			if hasUserspaceIPAM(cache.Netconf, cache.UserspaceMode) {
+				return delUserspaceIPAMPort(ovsBridgeDriver, cachedUserspaceIPAMPort(cache))
+			}
+			return nil
+		}
+		if isBondedVFMode(cache.Netconf) {
+			if err := resetBondedVFs(args, cache); err != nil {
+				return err
+			}
+			restoreVFTuning(cache)
+			return nil
+		}
+		if len(cache.Netconf.AltNames) > 0 {
+			delVFAltNames(cache.Netconf.DeviceID, cache.Netconf.AltNames)
+		}
+		if err := sriov.ResetVF(args, cache.Netconf.DeviceID, cache.OrigIfName); err != nil {
+			return err
+		}
+		restoreVFTuning(cache)
+		return nil
+	}
+	if portFound {
+		// removing host side of the veth removes its peer as well
+		if err := netif.Default.DelLinkByName(portName); err != nil && err != ip.ErrLinkNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// hook events, passed as the only argument of the hook
+const (
+	hookEventPostAdd = "post-add"
+	hookEventPreDel  = "pre-del"
+)
+
+// hookAttachment describes the attachment to a hook, it is passed as JSON on
+// its standard input
+type hookAttachment struct {
+	Event        string   `json:"event"`
+	Network      string   `json:"network"`
+	ContainerID  string   `json:"containerID"`
+	IfName       string   `json:"ifName"`
+	Netns        string   `json:"netns,omitempty"`
+	PodNamespace string   `json:"podNamespace,omitempty"`
+	PodName      string   `json:"podName,omitempty"`
+	PodUID       string   `json:"podUID,omitempty"`
+	Bridge       string   `json:"bridge"`
+	Port         string   `json:"port,omitempty"`
+	MAC          string   `json:"mac,omitempty"`
+	IPs          []string `json:"ips,omitempty"`
+}
+
+func newHookAttachment(event string, netconf *types.NetConf, args *skel.CmdArgs, envArgs *EnvArgs) *hookAttachment {
+	attachment := &hookAttachment{
+		Event:       event,
+		Network:     netconf.Name,
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		Netns:       args.Netns,
+		Bridge:      netconf.BrName,
+	}
+	if envArgs != nil {
+		attachment.PodNamespace = string(envArgs.K8S_POD_NAMESPACE)
+		attachment.PodName = string(envArgs.K8S_POD_NAME)
+		attachment.PodUID = string(envArgs.K8S_POD_UID)
+	}
+	return attachment
+}
+
+// runPostAddHook runs the post-ADD hook with the attachment described by the
+// result, its failure fails ADD
+func runPostAddHook(netconf *types.NetConf, args *skel.CmdArgs, envArgs *EnvArgs, result *current.Result) error {
+	if netconf.Hooks == nil || netconf.Hooks.PostAdd == "" {
+		return nil
+	}
+	attachment := newHookAttachment(hookEventPostAdd, netconf, args, envArgs)
+	for _, iface := range result.Interfaces {
+		if iface.Sandbox == "" {
+			attachment.Port = iface.Name
+		} else if iface.Name == args.IfName {
+			attachment.MAC = iface.Mac
+		}
+	}
+	for _, ipc := range result.IPs {
+		attachment.IPs = append(attachment.IPs, ipc.Address.String())
+	}
+	return runHook(netconf.Hooks.PostAdd, netconf.Hooks.Timeout, attachment)
+}
+
+// runPreDelHook runs the pre-DEL hook, failures are only logged as they must
+// not block DEL
+func runPreDelHook(netconf *types.NetConf, args *skel.CmdArgs, envArgs *EnvArgs) {
+	if netconf.Hooks == nil || netconf.Hooks.PreDel == "" {
+		return
+	}
+	if err := runHook(netconf.Hooks.PreDel, netconf.Hooks.Timeout, newHookAttachment(hookEventPreDel, netconf, args, envArgs)); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// checkHook verifies the hook is an executable in the hooks directory which
+// only its owner can modify
+func checkHook(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by group or others", path)
+	}
+	return nil
+}
+
+// runHook runs the hook with the event as its argument and the attachment on
+// its standard input, it is killed once the timeout expires
+func runHook(name string, timeout int, attachment *hookAttachment) error {
+	path := filepath.Join(config.HooksDir, name)
+	if err := checkHook(path); err != nil {
+		return fmt.Errorf("%s hook %s is not allowed: %v", attachment.Event, name, err)
+	}
+	data, err := json.Marshal(attachment)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, attachment.Event)
+	cmd.Stdin = bytes.NewReader(data)
+	// don't wait for children of the hook keeping its output open
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s hook %s timed out after %ds", attachment.Event, name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook %s failed: %v: %s", attachment.Event, name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
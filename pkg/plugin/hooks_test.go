@@ -0,0 +1,87 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Attachment hooks", func() {
+	var (
+		origHooksDir string
+		outputFile   string
+		netconf      *types.NetConf
+		args         *skel.CmdArgs
+	)
+	writeHook := func(name, script string, mode os.FileMode) {
+		path := filepath.Join(config.HooksDir, name)
+		Expect(os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700)).To(Succeed())
+		Expect(os.Chmod(path, mode)).To(Succeed())
+	}
+	BeforeEach(func() {
+		origHooksDir = config.HooksDir
+		config.HooksDir = GinkgoT().TempDir()
+		outputFile = filepath.Join(GinkgoT().TempDir(), "output")
+		netconf = &types.NetConf{BrName: "br1", Hooks: &types.Hooks{Timeout: 10}}
+		netconf.Name = "mynet"
+		args = &skel.CmdArgs{ContainerID: "dummy", IfName: "eth0", Netns: "/var/run/netns/test"}
+	})
+	AfterEach(func() {
+		config.HooksDir = origHooksDir
+	})
+	It("should pass the event and the attachment to the hook", func() {
+		writeHook("register", "echo $1 > "+outputFile+"; cat >> "+outputFile, 0700)
+		netconf.Hooks.PreDel = "register"
+		runPreDelHook(netconf, args, &EnvArgs{K8S_POD_NAME: "pod1"})
+
+		data, err := os.ReadFile(outputFile)
+		Expect(err).NotTo(HaveOccurred())
+		event, description, found := bytes.Cut(data, []byte("\n"))
+		Expect(found).To(BeTrue())
+		Expect(string(event)).To(Equal(hookEventPreDel))
+		attachment := hookAttachment{}
+		Expect(json.Unmarshal(description, &attachment)).To(Succeed())
+		Expect(attachment).To(Equal(hookAttachment{Event: hookEventPreDel, Network: "mynet", ContainerID: "dummy",
+			IfName: "eth0", Netns: "/var/run/netns/test", PodName: "pod1", Bridge: "br1"}))
+	})
+	It("should report failures of the hook", func() {
+		writeHook("register", "echo no fabric; exit 1", 0700)
+		err := runHook("register", 10, newHookAttachment(hookEventPostAdd, netconf, args, nil))
+		Expect(err).To(MatchError("post-add hook register failed: exit status 1: no fabric"))
+	})
+	It("should kill the hook once it times out", func() {
+		writeHook("register", "sleep 10", 0700)
+		err := runHook("register", 1, newHookAttachment(hookEventPostAdd, netconf, args, nil))
+		Expect(err).To(MatchError("post-add hook register timed out after 1s"))
+	})
+	It("should not run hooks which are missing or writable by others", func() {
+		err := runHook("missing", 10, newHookAttachment(hookEventPostAdd, netconf, args, nil))
+		Expect(err).To(MatchError(ContainSubstring("post-add hook missing is not allowed")))
+
+		writeHook("register", "exit 0", 0722)
+		err = runHook("register", 10, newHookAttachment(hookEventPostAdd, netconf, args, nil))
+		Expect(err).To(MatchError(ContainSubstring("is writable by group or others")))
+	})
+})
@@ -0,0 +1,209 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// infraVethPrefix is prepended to names of the infra netns ends of veths
+// connecting the infra netns to the bridge
+const infraVethPrefix = "ovsi"
+
+// isInfraNetnsMode returns true when the host end of the veth of the
+// attachment is placed in the infra netns instead of the host netns
+func isInfraNetnsMode(netconf *types.NetConf) bool {
+	return netconf.InfraNetns != ""
+}
+
+// infraVethName returns the name of the infra netns end of the veth
+// connecting the attachment to the bridge, it is stable so the veth can be
+// found on DEL
+func infraVethName(containerID, ifName string) string {
+	hash := sha256.Sum256([]byte(containerID + "/" + ifName))
+	return infraVethPrefix + hex.EncodeToString(hash[:])[:11]
+}
+
+// setupInfraVeth creates the veth of the attachment with its host end in the
+// infra netns and a second veth from the infra netns to the host netns,
+// traffic is redirected between the two by tc in the infra netns. Only the end
+// attached to the bridge is left in the host netns, pod host ends are isolated
+// from it.
+func setupInfraVeth(contNetns ns.NetNS, infraNetnsPath, contIfaceName, containerID, hostIfaceName, requestedMac string, mtu int) (hostIface, contIface *current.Interface, err error) {
+	infraNetns, err := netns.Get(infraNetnsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open infra netns %s: %v", infraNetnsPath, err)
+	}
+	defer infraNetns.Close()
+
+	contIface = &current.Interface{}
+	var podEndName string
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		podEnd, containerVeth, err := netif.Default.SetupVeth(contIfaceName, "", mtu, requestedMac, infraNetns)
+		if err != nil {
+			return err
+		}
+		if err := setInterfaceUp(contIfaceName); err != nil {
+			return err
+		}
+		contIface.Name = containerVeth.Name
+		contIface.Mac = containerVeth.HardwareAddr.String()
+		contIface.Mtu = containerVeth.MTU
+		contIface.Sandbox = contNetns.Path()
+		podEndName = podEnd.Name
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			// removing the veth removes its end in the infra netns as well
+			if err := netns.Do(contNetns, func(_ ns.NetNS) error {
+				return netif.Default.DelLinkByName(contIfaceName)
+			}); err != nil {
+				log.Printf("Failed best-effort cleanup of %s: %v", contIfaceName, err)
+			}
+		}
+	}()
+
+	infraName := infraVethName(containerID, contIfaceName)
+	err = netns.Do(infraNetns, func(hostNetns ns.NetNS) error {
+		hostVeth, _, err := netif.Default.SetupVeth(infraName, hostIfaceName, mtu, "", hostNetns)
+		if err != nil {
+			return fmt.Errorf("failed to connect infra netns to the host: %v", err)
+		}
+		hostIfaceName = hostVeth.Name
+		podEnd, err := netif.Default.LinkByName(podEndName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", podEndName, err)
+		}
+		infraEnd, err := netif.Default.LinkByName(infraName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", infraName, err)
+		}
+		if err := redirectIngress(podEnd, infraEnd); err != nil {
+			return err
+		}
+		if err := redirectIngress(infraEnd, podEnd); err != nil {
+			return err
+		}
+		for _, l := range []netlink.Link{podEnd, infraEnd} {
+			if err := netif.Default.LinkSetUp(l); err != nil {
+				return fmt.Errorf("failed to set %q up: %v", l.Attrs().Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if err := delInfraVeth(infraNetnsPath, containerID, contIfaceName); err != nil {
+			log.Printf("Failed best-effort cleanup of %s: %v", infraName, err)
+		}
+		return nil, nil, err
+	}
+
+	hostIface = &current.Interface{Name: hostIfaceName}
+	if err = refetchIface(hostIface); err != nil {
+		return nil, nil, err
+	}
+	return hostIface, contIface, nil
+}
+
+// removeStaleInfraVeth removes the veths and the port of the attachment left
+// by a previous partially failed ADD. The container interface is removed
+// only when it is a veth with its peer in the infra netns.
+func removeStaleInfraVeth(ovsDriver *ovsdb.OvsBridgeDriver, contNetns ns.NetNS, infraNetnsPath, containerID, ifName, contNetwork string) error {
+	portName, portFound, err := getOvsPortForContIface(ovsDriver, ifName, contNetns.Path(), contNetwork)
+	if err != nil {
+		return fmt.Errorf("failed to obtain OVS port for container iface %s: %v", ifName, err)
+	}
+	if portFound {
+		log.Printf("Info: removing port %s left by a previous attempt", portName)
+		if err := removeOvsPort(ovsDriver, portName); err != nil {
+			return err
+		}
+	}
+	if err := delInfraVeth(infraNetnsPath, containerID, ifName); err != nil {
+		return err
+	}
+
+	var stale netlink.Link
+	peerIndex := 0
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		link, err := netif.Default.LinkByName(ifName)
+		if err != nil {
+			if netif.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		veth, isVeth := link.(*netlink.Veth)
+		if !isVeth {
+			return fmt.Errorf("interface %s already exists in container netns and it is not a veth", ifName)
+		}
+		stale = link
+		peerIndex, err = netif.Default.VethPeerIndex(veth)
+		return err
+	})
+	if err != nil || stale == nil {
+		return err
+	}
+	err = netns.WithPath(infraNetnsPath, func(_ ns.NetNS) error {
+		peer, err := netif.Default.LinkByIndex(peerIndex)
+		if err != nil {
+			return fmt.Errorf("interface %s already exists in container netns and its peer is not in infra netns", ifName)
+		}
+		if veth, isVeth := peer.(*netlink.Veth); isVeth {
+			if index, err := netif.Default.VethPeerIndex(veth); err == nil && index == stale.Attrs().Index {
+				return nil
+			}
+		}
+		return fmt.Errorf("interface %s already exists in container netns and its peer is not in infra netns", ifName)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Info: removing interface %s left in container netns by a previous attempt", ifName)
+	return netns.Do(contNetns, func(_ ns.NetNS) error {
+		return netif.Default.LinkDel(stale)
+	})
+}
+
+// delInfraVeth removes the veth connecting the attachment to the bridge,
+// which removes its end in the host netns as well. A veth or an infra netns
+// which are already gone are ignored.
+func delInfraVeth(infraNetnsPath, containerID, ifName string) error {
+	err := netns.WithPath(infraNetnsPath, func(ns.NetNS) error {
+		return netif.Default.DelLinkByName(infraVethName(containerID, ifName))
+	})
+	if err != nil && !netns.IsGone(err) && err != ip.ErrLinkNotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// internalPortTimeout is how long ovs-vswitchd is given to create the
+// netdevice of the internal port
+const internalPortTimeout = 5 * time.Second
+
+// isInternalPortMode returns true when the container interface is an OVS
+// internal port instead of a veth
+func isInternalPortMode(netconf *types.NetConf) bool {
+	return netconf.InterfaceType == config.InternalInterfaceType
+}
+
+// setupInternalPort creates an OVS internal port of the attachment and moves
+// its interface into the container netns under the name of the attachment,
+// so there is no veth hop between the container and the bridge. The port
+// keeps its name in OVSDB, it is the host interface of the result.
+func setupInternalPort(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, contNetns ns.NetNS, contIfaceName, portName, requestedMac string, vlanTag uint, trunks []uint, portType, ovnPort, contPodUid, contID string) (hostIface, contIface *current.Interface, err error) {
+	if portName == "" {
+		if portName, err = ip.RandomVethName(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = ovsDriver.CreatePort(ovsdb.PortOptions{
+		Name:          portName,
+		ContNetns:     contNetns.Path(),
+		ContIface:     contIfaceName,
+		ContNetwork:   netconf.Name,
+		ContPodUID:    contPodUid,
+		ContID:        contID,
+		OvnPort:       ovnPort,
+		OfportRequest: netconf.OfportRequest,
+		VlanTag:       vlanTag,
+		Trunks:        trunks,
+		VlanMode:      portType,
+		QinQEthType:   qinqEthType(netconf),
+		IntfType:      config.InternalInterfaceType,
+	}); err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			// the interface is removed with the port wherever it is
+			if err := removeOvsPort(ovsDriver, portName); err != nil {
+				log.Printf("Failed best-effort cleanup: %v", err)
+			}
+		}
+	}()
+
+	link, err := waitForLink(portName, internalPortTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if netconf.MTU != 0 {
+		if err = netif.Default.LinkSetMTU(link, netconf.MTU); err != nil {
+			return nil, nil, fmt.Errorf("failed to set MTU of internal port %s: %v", portName, err)
+		}
+	}
+	if requestedMac != "" {
+		var hwAddr net.HardwareAddr
+		if hwAddr, err = net.ParseMAC(requestedMac); err != nil {
+			return nil, nil, err
+		}
+		if err = netif.Default.LinkSetHardwareAddr(link, hwAddr); err != nil {
+			return nil, nil, fmt.Errorf("failed to set MAC of internal port %s: %v", portName, err)
+		}
+	}
+	if err = netif.Default.LinkSetNs(link, contNetns); err != nil {
+		return nil, nil, fmt.Errorf("failed to move internal port %s to container netns: %v", portName, err)
+	}
+
+	contIface = &current.Interface{Name: contIfaceName, Sandbox: contNetns.Path()}
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		link, err := netif.Default.LinkByName(portName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup internal port %s in container netns: %v", portName, err)
+		}
+		if err := netif.Default.LinkSetName(link, contIfaceName); err != nil {
+			return fmt.Errorf("failed to rename internal port %s to %s: %v", portName, contIfaceName, err)
+		}
+		if err := setInterfaceUp(contIfaceName); err != nil {
+			return err
+		}
+		if link, err = netif.Default.LinkByName(contIfaceName); err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", contIfaceName, err)
+		}
+		contIface.Mac = link.Attrs().HardwareAddr.String()
+		contIface.Mtu = link.Attrs().MTU
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &current.Interface{Name: portName}, contIface, nil
+}
+
+// removeStaleInternalPort removes the internal port of the attachment left by
+// a previous partially failed ADD, its interface in the container netns is
+// removed with it
+func removeStaleInternalPort(ovsDriver *ovsdb.OvsBridgeDriver, contNetns ns.NetNS, contIfaceName, contNetwork string) error {
+	portName, portFound, err := getOvsPortForContIface(ovsDriver, contIfaceName, contNetns.Path(), contNetwork)
+	if err != nil {
+		return fmt.Errorf("failed to obtain OVS port for container iface %s: %v", contIfaceName, err)
+	}
+	if !portFound {
+		return nil
+	}
+	log.Printf("Info: removing internal port %s left by a previous attempt", portName)
+	return removeOvsPort(ovsDriver, portName)
+}
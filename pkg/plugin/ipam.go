@@ -0,0 +1,171 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ipam"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// ipamPlugin is an IPAM plugin of the attachment with the netconf it is
+// called with
+type ipamPlugin struct {
+	pluginType string
+	stdinData  []byte
+}
+
+// ipamPluginsOf returns the IPAM plugin of the netconf, followed by the one of
+// IPv6 addresses when ipam_v6 is set. The IPv6 plugin gets the netconf with
+// ipam_v6 in place of the ipam block.
+func ipamPluginsOf(netconf *types.NetConf, stdinData []byte) ([]ipamPlugin, error) {
+	plugins := []ipamPlugin{{pluginType: netconf.IPAM.Type, stdinData: stdinData}}
+	if netconf.IPAMV6 == nil {
+		return plugins, nil
+	}
+	conf := map[string]json.RawMessage{}
+	if err := json.Unmarshal(stdinData, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse netconf: %v", err)
+	}
+	conf["ipam"] = conf["ipam_v6"]
+	delete(conf, "ipam_v6")
+	v6StdinData, err := json.Marshal(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal netconf of IPv6 IPAM plugin: %v", err)
+	}
+	return append(plugins, ipamPlugin{pluginType: netconf.IPAMV6.Type, stdinData: v6StdinData}), nil
+}
+
+// ipamDelStdinData returns the netconf IPAM plugins get on DEL: the cached
+// one they got on ADD with prevResult of DEL. Runtimes may call DEL with a
+// minimal netconf or one changed since ADD, addresses allocated on ADD would
+// leak then. Without the cached netconf, e.g. of attachments added by older
+// versions, the netconf of DEL is used.
+func ipamDelStdinData(stdinData []byte, cache *types.CachedNetConf) []byte {
+	if len(cache.IPAMStdinData) == 0 {
+		return stdinData
+	}
+	conf := map[string]json.RawMessage{}
+	if err := json.Unmarshal(cache.IPAMStdinData, &conf); err != nil {
+		log.Printf("Failed to parse cached netconf of IPAM plugins, using the one of DEL: %v", err)
+		return stdinData
+	}
+	delConf := map[string]json.RawMessage{}
+	if err := json.Unmarshal(stdinData, &delConf); err == nil {
+		if prevResult, found := delConf["prevResult"]; found {
+			conf["prevResult"] = prevResult
+		}
+		if !jsonEqual(delConf["ipam"], conf["ipam"]) {
+			log.Printf("IPAM config of DEL differs from the one of ADD, releasing addresses with the one of ADD")
+		}
+	}
+	data, err := json.Marshal(conf)
+	if err != nil {
+		log.Printf("Failed to marshal cached netconf of IPAM plugins, using the one of DEL: %v", err)
+		return stdinData
+	}
+	return data
+}
+
+// jsonEqual returns true when both documents have the same content
+func jsonEqual(a, b json.RawMessage) bool {
+	var valueA, valueB interface{}
+	if json.Unmarshal(a, &valueA) != nil || json.Unmarshal(b, &valueB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(valueA, valueB)
+}
+
+// execIPAMAdd runs ADD of the IPAM plugins and merges their results, the
+// caller releases addresses of all plugins when it fails
+func execIPAMAdd(plugins []ipamPlugin) (*current.Result, error) {
+	var result *current.Result
+	for _, plugin := range plugins {
+		r, err := ipam.ExecAdd(plugin.pluginType, plugin.stdinData)
+		if err != nil {
+			return nil, wrapError(err, fmt.Sprintf("failed to set up IPAM plugin type %q", plugin.pluginType))
+		}
+		// Convert the IPAM result into the current Result type
+		pluginResult, err := current.NewResultFromResult(r)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = pluginResult
+			continue
+		}
+		mergeIPAMResult(result, pluginResult)
+	}
+	return result, nil
+}
+
+// mergeIPAMResult adds addresses, routes and DNS settings of other to result
+func mergeIPAMResult(result, other *current.Result) {
+	result.IPs = append(result.IPs, other.IPs...)
+	result.Routes = append(result.Routes, other.Routes...)
+	if result.DNS.Domain == "" {
+		result.DNS.Domain = other.DNS.Domain
+	}
+	result.DNS.Nameservers = appendMissing(result.DNS.Nameservers, other.DNS.Nameservers...)
+	result.DNS.Search = appendMissing(result.DNS.Search, other.DNS.Search...)
+	result.DNS.Options = appendMissing(result.DNS.Options, other.DNS.Options...)
+}
+
+func appendMissing(values []string, others ...string) []string {
+	for _, other := range others {
+		found := false
+		for _, value := range values {
+			if value == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, other)
+		}
+	}
+	return values
+}
+
+// execIPAMDel runs DEL of all IPAM plugins in reverse order, so addresses of
+// the other plugins are released when one of them fails
+func execIPAMDel(plugins []ipamPlugin) error {
+	var firstErr error
+	for i := len(plugins) - 1; i >= 0; i-- {
+		if err := ipam.ExecDel(plugins[i].pluginType, plugins[i].stdinData); err != nil {
+			log.Printf("Failed to release addresses of IPAM plugin type %q: %v", plugins[i].pluginType, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// execIPAMCheck runs CHECK of all IPAM plugins
+func execIPAMCheck(plugins []ipamPlugin) error {
+	for _, plugin := range plugins {
+		if err := ipam.ExecCheck(plugin.pluginType, plugin.stdinData); err != nil {
+			return fmt.Errorf("failed to check with IPAM plugin type %q: %v", plugin.pluginType, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"net"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("IPAM plugins", func() {
+	It("should pass ipam_v6 to the IPv6 IPAM plugin", func() {
+		conf := []byte(`{"name": "net1", "type": "ovs", "bridge": "br1",
+			"ipam": {"type": "host-local", "subnet": "10.1.0.0/24"},
+			"ipam_v6": {"type": "static", "addresses": [{"address": "fd00::5/64"}]}}`)
+		netconf, err := config.LoadConf(conf)
+		Expect(err).NotTo(HaveOccurred())
+		plugins, err := ipamPluginsOf(netconf, conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plugins).To(HaveLen(2))
+		Expect(plugins[0]).To(Equal(ipamPlugin{pluginType: "host-local", stdinData: conf}))
+		Expect(plugins[1].pluginType).To(Equal("static"))
+		Expect(plugins[1].stdinData).To(MatchJSON(`{"name": "net1", "type": "ovs", "bridge": "br1",
+			"ipam": {"type": "static", "addresses": [{"address": "fd00::5/64"}]}}`))
+	})
+	It("should call only the IPAM plugin without ipam_v6", func() {
+		conf := []byte(`{"name": "net1", "type": "ovs", "bridge": "br1", "ipam": {"type": "host-local"}}`)
+		netconf, err := config.LoadConf(conf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipamPluginsOf(netconf, conf)).To(Equal([]ipamPlugin{{pluginType: "host-local", stdinData: conf}}))
+	})
+	It("should merge results of the IPAM plugins", func() {
+		_, v4Net, _ := net.ParseCIDR("10.1.0.5/24")
+		_, v6Net, _ := net.ParseCIDR("fd00::5/64")
+		result := &current.Result{
+			IPs:    []*current.IPConfig{{Address: *v4Net}},
+			Routes: []*cnitypes.Route{{Dst: *v4Net}},
+			DNS:    cnitypes.DNS{Nameservers: []string{"10.1.0.1"}, Search: []string{"example.com"}},
+		}
+		mergeIPAMResult(result, &current.Result{
+			IPs:    []*current.IPConfig{{Address: *v6Net}},
+			Routes: []*cnitypes.Route{{Dst: *v6Net}},
+			DNS:    cnitypes.DNS{Domain: "example.com", Nameservers: []string{"fd00::1"}, Search: []string{"example.com"}},
+		})
+		Expect(result.IPs).To(HaveLen(2))
+		Expect(result.IPs[1].Address).To(Equal(*v6Net))
+		Expect(result.Routes).To(HaveLen(2))
+		Expect(result.DNS).To(Equal(cnitypes.DNS{
+			Domain:      "example.com",
+			Nameservers: []string{"10.1.0.1", "fd00::1"},
+			Search:      []string{"example.com"},
+		}))
+	})
+})
+
+var _ = Describe("IPAM netconf of DEL", func() {
+	addConf := []byte(`{"cniVersion": "1.0.0", "name": "net1", "type": "ovs", "ipam": {"type": "host-local", "subnet": "10.1.0.0/24"}}`)
+
+	It("should use the netconf of ADD with prevResult of DEL", func() {
+		delConf := []byte(`{"cniVersion": "1.0.0", "name": "net1", "type": "ovs", "ipam": {"type": "host-local", "subnet": "10.2.0.0/24"},
+			"prevResult": {"cniVersion": "1.0.0", "ips": [{"address": "10.1.0.5/24"}]}}`)
+		Expect(ipamDelStdinData(delConf, &types.CachedNetConf{IPAMStdinData: addConf})).To(MatchJSON(`{"cniVersion": "1.0.0", "name": "net1", "type": "ovs",
+			"ipam": {"type": "host-local", "subnet": "10.1.0.0/24"},
+			"prevResult": {"cniVersion": "1.0.0", "ips": [{"address": "10.1.0.5/24"}]}}`))
+	})
+	It("should use the netconf of ADD when DEL has a minimal one", func() {
+		Expect(ipamDelStdinData([]byte(`{}`), &types.CachedNetConf{IPAMStdinData: addConf})).To(MatchJSON(addConf))
+	})
+	It("should use the netconf of DEL without a cached one", func() {
+		delConf := []byte(`{"name": "net1", "type": "ovs", "ipam": {"type": "host-local"}}`)
+		Expect(ipamDelStdinData(delConf, &types.CachedNetConf{})).To(Equal(delConf))
+	})
+})
@@ -0,0 +1,150 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/neigh"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// dadTimeout bounds the wait for DAD of IPv6 addresses, the kernel takes 1
+// to 2 seconds by default
+const dadTimeout = 3 * time.Second
+
+// ipConflictError lists addresses of the IPAM result used by other hosts
+type ipConflictError struct {
+	ips []net.IP
+}
+
+func (e *ipConflictError) Error() string {
+	return fmt.Sprintf("addresses %v are used by other hosts of the network", e.ips)
+}
+
+// probeIPConflicts sends ARP probes of IPv4 addresses of the result from the
+// interface, they must not be configured yet. It must run in the container
+// netns.
+func probeIPConflicts(netconf *types.NetConf, ifName string, result *current.Result) error {
+	if netconf.IPConflict == nil {
+		return nil
+	}
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to look up %q: %v", ifName, err)
+	}
+	used, err := neigh.ProbeIPv4(iface, resultIPs(result), time.Duration(netconf.IPConflict.Timeout)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if len(used) > 0 {
+		return &ipConflictError{ips: used}
+	}
+	return nil
+}
+
+// checkDADConflicts waits for DAD of IPv6 addresses of the result configured
+// on the interface. Addresses and routes of the result are removed when it
+// fails for any, so other addresses can be configured instead. It must run in
+// the container netns.
+func checkDADConflicts(netconf *types.NetConf, ifName string, result *current.Result) error {
+	if netconf.IPConflict == nil || !hasIPv6(result.IPs) {
+		return nil
+	}
+	failed, err := neigh.WaitDAD(ifName, resultIPs(result), dadTimeout)
+	if err != nil {
+		return err
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	unconfigureIface(ifName, result)
+	return &ipConflictError{ips: failed}
+}
+
+// unconfigureIface removes addresses and routes of the result from the
+// interface, failures are only logged
+func unconfigureIface(ifName string, result *current.Result) {
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		log.Printf("Failed to lookup %q: %v", ifName, err)
+		return
+	}
+	for _, route := range result.Routes {
+		dst := route.Dst
+		if err := netif.Default.RouteDel(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: &dst}); err != nil {
+			log.Printf("Failed best-effort cleanup of route %v: %v", route.Dst, err)
+		}
+	}
+	for _, ipc := range result.IPs {
+		if err := netif.Default.AddrDel(link, &netlink.Addr{IPNet: &ipc.Address}); err != nil {
+			log.Printf("Failed best-effort cleanup of address %v: %v", ipc.Address, err)
+		}
+	}
+}
+
+// reallocateIPs releases addresses of the IPAM plugins and gets new ones,
+// IPAM plugins allocating addresses in order, e.g. host-local, hand out the
+// next free one
+func reallocateIPs(plugins []ipamPlugin) (*current.Result, error) {
+	if err := execIPAMDel(plugins); err != nil {
+		return nil, err
+	}
+	return execIPAMAdd(plugins)
+}
+
+// configureIPsWithReallocation configures addresses of the result in the
+// container. Addresses conflicting with ones in use on the network are
+// replaced by other ones of the IPAM plugins, up to ip_conflict.reallocations
+// times, the result with the configured addresses is returned.
+func configureIPsWithReallocation(netconf *types.NetConf, plugins []ipamPlugin, contNetns ns.NetNS, result *current.Result, contIface, bondIface *current.Interface, ipIfName, contIfName, mac string, macPrefix net.HardwareAddr, contPodUid string) (*current.Result, error) {
+	for attempt := 0; ; attempt++ {
+		err := netns.Do(contNetns, func(_ ns.NetNS) error {
+			return configureContainerIPs(netconf, result, ipIfName, contIfName, mac, macPrefix, contPodUid)
+		})
+		var conflict *ipConflictError
+		if !errors.As(err, &conflict) || attempt == netconf.IPConflict.Reallocations {
+			if err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		log.Printf("Warning: %v, getting other addresses from IPAM (attempt %d of %d)", conflict, attempt+1, netconf.IPConflict.Reallocations)
+		if result, err = reallocateIPs(plugins); err != nil {
+			return nil, err
+		}
+		if err = prepareIPAMResult(netconf, result, contIface, bondIface); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func resultIPs(result *current.Result) []net.IP {
+	ips := make([]net.IP, 0, len(result.IPs))
+	for _, ipc := range result.IPs {
+		ips = append(ips, ipc.Address.IP)
+	}
+	return ips
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	libovsdb "github.com/ovn-org/libovsdb/ovsdb"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Link state policy", func() {
+	const bridge = "br-link"
+	var fake *testhelpers.FakeOVSDB
+	var driver *ovsdb.OvsBridgeDriver
+
+	BeforeEach(func() {
+		var err error
+		fake, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(fake.Close)
+		driver, err = ovsdb.NewOvsBridgeDriver(bridge, fake.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driver.CreatePort(ovsdb.PortOptions{Name: "veth1"})).To(Succeed())
+	})
+
+	setLinkState := func(state string) {
+		_, err := fake.Transact(libovsdb.Operation{
+			Op:    libovsdb.OperationUpdate,
+			Table: "Interface",
+			Row:   libovsdb.Row{"link_state": state},
+			Where: []libovsdb.Condition{libovsdb.NewCondition("name", libovsdb.ConditionEqual, "veth1")},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	DescribeTable("waitPortUp",
+		func(policy, initialState string, retryErr error, stateAfterRetry string, expectedRetries int, expectedErr string) {
+			if initialState != "" {
+				setLinkState(initialState)
+			}
+			netconf := &types.NetConf{LinkStateCheckRetries: 2, LinkStateCheckInterval: 1, LinkStatePolicy: policy}
+			retries := 0
+			err := waitPortUp(driver, netconf, "veth1", func() error {
+				retries++
+				if retryErr != nil {
+					return retryErr
+				}
+				setLinkState(stateAfterRetry)
+				return nil
+			})
+			Expect(retries).To(Equal(expectedRetries))
+			if expectedErr == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			}
+		},
+		Entry("should succeed when the port is up", "", "up", nil, "", 0, ""),
+		Entry("should fail when the port stays down by default", "", "down", nil, "", 0, "The OF port veth1 state is not up"),
+		Entry("should fail when the port stays down with the fail policy", config.LinkStatePolicyFail, "down", nil, "", 0, "The OF port veth1 state is not up"),
+		Entry("should fail when the port has no link state", config.LinkStatePolicyFail, "", nil, "", 0, "The OF port veth1 state is not up"),
+		Entry("should continue when the port stays down with the warn policy", config.LinkStatePolicyWarn, "down", nil, "", 0, ""),
+		Entry("should not retry when the port is up with the retry policy", config.LinkStatePolicyRetry, "up", nil, "", 0, ""),
+		Entry("should succeed when the port comes up after the retry", config.LinkStatePolicyRetry, "down", nil, "up", 1, ""),
+		Entry("should fail when the port stays down after the retry", config.LinkStatePolicyRetry, "down", nil, "down", 1, "The OF port veth1 state is not up"),
+		Entry("should fail when the retry fails", config.LinkStatePolicyRetry, "down", errors.New("no veth"), "", 1, "failed to retry port veth1: no veth"),
+	)
+})
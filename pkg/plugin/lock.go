@@ -0,0 +1,37 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// bridgeLockTimeout limits waiting for another invocation in a critical
+// section of the same bridge
+const bridgeLockTimeout = 30 * time.Second
+
+// lockBridge serializes critical sections of concurrent invocations on the
+// same bridge of the node, e.g. a check of free resources of the bridge
+// followed by creation of the port taking them
+func lockBridge(bridgeName string) (*utils.Lock, error) {
+	lock, err := utils.AcquireLock("bridge-"+bridgeName, bridgeLockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock bridge %s: %v", bridgeName, err)
+	}
+	return lock, nil
+}
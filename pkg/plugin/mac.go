@@ -0,0 +1,102 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+)
+
+// withMACPrefix replaces leading octets of the generated MAC address with
+// the configured prefix, the address is returned unchanged without a prefix
+func withMACPrefix(mac, prefix net.HardwareAddr) net.HardwareAddr {
+	if len(prefix) == 0 {
+		return mac
+	}
+	prefixed := make(net.HardwareAddr, len(mac))
+	copy(prefixed, mac)
+	copy(prefixed, prefix)
+	return prefixed
+}
+
+// randomHWAddr returns a random MAC address with the given prefix
+func randomHWAddr(prefix net.HardwareAddr) (string, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return "", fmt.Errorf("failed to generate MAC address: %v", err)
+	}
+	return withMACPrefix(mac, prefix).String(), nil
+}
+
+// checkRequestedMAC fails when the MAC address requested for the attachment
+// does not match any of the allowed prefixes
+func checkRequestedMAC(mac string, allowedPrefixes []string) error {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return fmt.Errorf("invalid requested MAC address %q: %v", mac, err)
+	}
+	prefixes, err := config.AllowedMACPrefixes(allowedPrefixes)
+	if err != nil {
+		return err
+	}
+	if !config.MACAllowed(hwAddr, prefixes) {
+		return fmt.Errorf("requested MAC address %s is not within allowed prefixes %v", mac, allowedPrefixes)
+	}
+	return nil
+}
+
+// derivedHWAddr returns the MAC address of the container interface derived
+// from the IPAM result or the pod UID, as configured by mac_derivation
+func derivedHWAddr(derivation string, ips []*current.IPConfig, podUID, ifName string) net.HardwareAddr {
+	switch derivation {
+	case config.MACDerivationEUI64:
+		return eui64HWAddr(hwAddrSourceIP(ips))
+	case config.MACDerivationPodUID:
+		if podUID != "" {
+			return podUIDHWAddr(podUID, ifName)
+		}
+		log.Printf("Warning: pod UID is unknown, MAC address of %s is derived from its IP address", ifName)
+	}
+	return IPAddrToHWAddr(hwAddrSourceIP(ips))
+}
+
+// eui64HWAddr returns the MAC address an IPv6 address with EUI-64 interface
+// identifier was formed from. Other IPv6 addresses map to 0A:58 followed by
+// their last four octets, IPv4 addresses are mapped as by IPAddrToHWAddr.
+func eui64HWAddr(ip net.IP) net.HardwareAddr {
+	if ip.To4() != nil {
+		return IPAddrToHWAddr(ip)
+	}
+	ip = ip.To16()
+	// the universal/local bit is inverted in the interface identifier
+	if ip[11] == 0xff && ip[12] == 0xfe && (ip[8]^0x02)&0x01 == 0 {
+		return net.HardwareAddr{ip[8] ^ 0x02, ip[9], ip[10], ip[13], ip[14], ip[15]}
+	}
+	return net.HardwareAddr{0x0A, 0x58, ip[12], ip[13], ip[14], ip[15]}
+}
+
+// podUIDHWAddr returns a MAC address derived from the pod UID and the name
+// of the interface, so all interfaces of the pod get distinct addresses
+func podUIDHWAddr(podUID, ifName string) net.HardwareAddr {
+	hash := sha256.Sum256([]byte(podUID + "/" + ifName))
+	return net.HardwareAddr{0x0A, 0x58, hash[0], hash[1], hash[2], hash[3]}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"net"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+)
+
+var _ = Describe("MAC derivation", func() {
+	ipConfigs := func(addrs ...string) []*current.IPConfig {
+		var ips []*current.IPConfig
+		for _, addr := range addrs {
+			ips = append(ips, &current.IPConfig{Address: net.IPNet{IP: net.ParseIP(addr)}})
+		}
+		return ips
+	}
+
+	It("should hash IPv6 addresses by default", func() {
+		ips := ipConfigs("fd00::1")
+		Expect(derivedHWAddr("", ips, "", "eth0")).To(Equal(IPAddrToHWAddr(net.ParseIP("fd00::1"))))
+		Expect(derivedHWAddr(config.MACDerivationIPHash, ips, "", "eth0")).To(Equal(IPAddrToHWAddr(net.ParseIP("fd00::1"))))
+	})
+	It("should recover MAC addresses of EUI-64 interface identifiers", func() {
+		Expect(eui64HWAddr(net.ParseIP("fd00::0858:aff:fe01:203")).String()).To(Equal("0a:58:0a:01:02:03"))
+		Expect(eui64HWAddr(net.ParseIP("fd00::1:0:a:1")).String()).To(Equal("0a:58:00:0a:00:01"))
+		Expect(eui64HWAddr(net.ParseIP("fd00::a:2")).String()).To(Equal("0a:58:00:0a:00:02"))
+		Expect(eui64HWAddr(net.ParseIP("10.1.2.3")).String()).To(Equal("0a:58:0a:01:02:03"))
+	})
+	It("should not recover multicast MAC addresses", func() {
+		Expect(eui64HWAddr(net.ParseIP("fd00::0358:aff:fe01:203")).String()).To(Equal("0a:58:fe:01:02:03"))
+	})
+	It("should prefer IPv4 addresses", func() {
+		ips := ipConfigs("fd00::0858:aff:fe01:203", "10.1.2.4")
+		Expect(derivedHWAddr(config.MACDerivationEUI64, ips, "", "eth0").String()).To(Equal("0a:58:0a:01:02:04"))
+	})
+	It("should derive MAC addresses from the pod UID", func() {
+		ips := ipConfigs("fd00::1")
+		eth0 := derivedHWAddr(config.MACDerivationPodUID, ips, "uid1", "eth0")
+		Expect(eth0).To(Equal(podUIDHWAddr("uid1", "eth0")))
+		Expect(eth0[:2]).To(Equal(net.HardwareAddr{0x0A, 0x58}))
+		Expect(derivedHWAddr(config.MACDerivationPodUID, ips, "uid1", "net1")).NotTo(Equal(eth0))
+		Expect(derivedHWAddr(config.MACDerivationPodUID, ips, "", "eth0")).To(Equal(IPAddrToHWAddr(net.ParseIP("fd00::1"))))
+	})
+})
@@ -0,0 +1,178 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// MigrateFromLinuxBridge moves a running attachment of the Linux bridge
+// plugin to the OVS bridge of the netconf, so a cluster can be migrated to
+// OVS networking without recreating its pods. The host side of the veth of
+// the attachment is moved from its Linux bridge to a port of the OVS bridge,
+// the container side including its addresses is kept. The attachment is
+// cached like one added by ovs-cni, with the IPAM plugin of bridgeStdinData,
+// the netconf of the bridge plugin, which gets it on DEL, so the addresses
+// are released where they were allocated. It is moved back to the Linux
+// bridge when the port can't be created.
+func MigrateFromLinuxBridge(netconf *types.NetConf, containerID, ifName, netnsPath string, bridgeStdinData []byte) error {
+	if err := config.Validate(netconf); err != nil {
+		return err
+	}
+	if err := resolveBridgeTemplate(netconf); err != nil {
+		return err
+	}
+	if netconf.BrName == "" || netconf.DeviceID != "" || isVhostUserMode(netconf) || isRoutedMode(netconf) || netconf.Backup != nil || len(netconf.VlanTranslation) > 0 {
+		return fmt.Errorf("only a veth attachment to a configured bridge can be migrated")
+	}
+	cRef := config.GetNetworkCRef(netconf.Name, containerID, ifName)
+	if _, _, err := config.LoadNetworkConfFromCache(netconf.Name, containerID, ifName); err == nil {
+		return fmt.Errorf("attachment %s is already cached by ovs-cni", cRef)
+	}
+
+	contNetns, err := netns.Get(netnsPath)
+	if err != nil {
+		return openNetnsError(netnsPath, err)
+	}
+	defer contNetns.Close()
+	hostLink, linuxBridge, err := linuxBridgePort(contNetns, ifName)
+	if err != nil {
+		return err
+	}
+	hostIfName := hostLink.Attrs().Name
+
+	vlanTag, trunks, portType := portVlan(netconf)
+	ovsBridgeDriver, err := newBridgeDriver(netconf.BrName, netconf)
+	if err != nil {
+		return err
+	}
+
+	cachedNetConf := &types.CachedNetConf{Netconf: netconf, ContainerID: containerID, IfName: ifName, Netns: netnsPath}
+	if len(bridgeStdinData) > 0 {
+		if cachedNetConf.Netconf, err = withBridgeIPAM(netconf, bridgeStdinData); err != nil {
+			return err
+		}
+		if cachedNetConf.Netconf.IPAM.Type != "" {
+			cachedNetConf.IPAMStdinData = bridgeStdinData
+		}
+	}
+	if err := utils.SaveCache(cRef, cachedNetConf); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+
+	if err := netif.Default.LinkSetNoMaster(hostLink); err != nil {
+		if err := utils.CleanCache(cRef); err != nil {
+			log.Printf("Failed cleaning up cache: %v", err)
+		}
+		return fmt.Errorf("failed to detach %s from bridge %s: %v", hostIfName, linuxBridge.Attrs().Name, err)
+	}
+	err = attachIfaceToBridge(ovsBridgeDriver, ovsdb.PortOptions{
+		Name:          hostIfName,
+		ContNetns:     netnsPath,
+		ContIface:     ifName,
+		ContNetwork:   netconf.Name,
+		ContID:        containerID,
+		OfportRequest: netconf.OfportRequest,
+		VlanTag:       vlanTag,
+		Trunks:        trunks,
+		VlanMode:      portType,
+		QinQEthType:   qinqEthType(netconf),
+		IntfType:      netconf.InterfaceType,
+	})
+	if err == nil {
+		err = setupRateLimit(ovsBridgeDriver, netconf, hostIfName)
+	}
+	if err != nil {
+		if _, portErr := ovsBridgeDriver.GetPortUUID(hostIfName); portErr == nil {
+			if err := removeOvsPort(ovsBridgeDriver, hostIfName); err != nil {
+				log.Printf("Failed best-effort cleanup: %v", err)
+			}
+		}
+		if err := netif.Default.LinkSetMaster(hostLink, linuxBridge); err != nil {
+			log.Printf("Failed to move %s back to bridge %s: %v", hostIfName, linuxBridge.Attrs().Name, err)
+		}
+		if err := utils.CleanCache(cRef); err != nil {
+			log.Printf("Failed cleaning up cache: %v", err)
+		}
+		return err
+	}
+	log.Printf("Moved port %s of attachment %s from Linux bridge %s to OVS bridge %s", hostIfName, cRef, linuxBridge.Attrs().Name, netconf.BrName)
+	return nil
+}
+
+// withBridgeIPAM returns a copy of the netconf with the IPAM plugin of the
+// netconf of the bridge plugin, which allocated the addresses of the
+// attachment and releases them on DEL
+func withBridgeIPAM(netconf *types.NetConf, bridgeStdinData []byte) (*types.NetConf, error) {
+	bridgeConf := struct {
+		IPAM cnitypes.IPAM `json:"ipam"`
+	}{}
+	if err := json.Unmarshal(bridgeStdinData, &bridgeConf); err != nil {
+		return nil, fmt.Errorf("failed to parse netconf of the bridge plugin: %v", err)
+	}
+	migrated := *netconf
+	migrated.IPAM = bridgeConf.IPAM
+	migrated.IPAMV6 = nil
+	return &migrated, nil
+}
+
+// linuxBridgePort returns the host side of the veth of the container
+// interface and the Linux bridge it is attached to
+func linuxBridgePort(contNetns ns.NetNS, ifName string) (netlink.Link, netlink.Link, error) {
+	peerIndex := 0
+	err := netns.Do(contNetns, func(ns.NetNS) error {
+		link, err := netif.Default.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		veth, isVeth := link.(*netlink.Veth)
+		if !isVeth {
+			return fmt.Errorf("interface %s of the container is not a veth", ifName)
+		}
+		peerIndex, err = netif.Default.VethPeerIndex(veth)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	hostLink, err := netif.Default.LinkByIndex(peerIndex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup peer of %s: %v", ifName, err)
+	}
+	if hostLink.Attrs().MasterIndex == 0 {
+		return nil, nil, fmt.Errorf("peer %s of %s is not attached to a bridge", hostLink.Attrs().Name, ifName)
+	}
+	master, err := netif.Default.LinkByIndex(hostLink.Attrs().MasterIndex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup master of %s: %v", hostLink.Attrs().Name, err)
+	}
+	if _, isBridge := master.(*netlink.Bridge); !isBridge {
+		return nil, nil, fmt.Errorf("peer %s of %s is attached to %s, which is not a Linux bridge", hostLink.Attrs().Name, ifName, master.Attrs().Name)
+	}
+	return hostLink, master, nil
+}
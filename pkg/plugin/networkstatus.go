@@ -0,0 +1,210 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+const (
+	// networkStatusTimeout limits publishing of the network-status annotation
+	networkStatusTimeout = 10 * time.Second
+	// networkStatusRetries is how many times an update of the pod is retried
+	// on conflict with other writers of the annotation
+	networkStatusRetries = 3
+)
+
+// attachmentStatus is the entry of the attachment in the network-status
+// annotation, VLAN is an extension of ovs-cni ignored by other readers
+type attachmentStatus struct {
+	nadv1.NetworkStatus
+	VLAN uint `json:"vlan,omitempty"`
+}
+
+// networkStatus returns the entry of the attachment in the network-status
+// annotation of the pod, the same one Multus renders from the result
+func networkStatus(netconf *types.NetConf, ifName string, vlanTag uint, result *current.Result) *attachmentStatus {
+	status := &attachmentStatus{
+		NetworkStatus: nadv1.NetworkStatus{Name: netconf.Name, Interface: ifName},
+		VLAN:          vlanTag,
+	}
+	contIndex := -1
+	var hostIface *current.Interface
+	for i, iface := range result.Interfaces {
+		switch {
+		case iface.Sandbox == "" && hostIface == nil:
+			hostIface = iface
+		case iface.Sandbox != "" && iface.Name == ifName && contIndex == -1:
+			contIndex = i
+		}
+	}
+	if contIndex != -1 {
+		contIface := result.Interfaces[contIndex]
+		status.Mac = contIface.Mac
+		status.Mtu = contIface.Mtu
+		status.DeviceInfo = deviceInfo(contIface, hostIface)
+	}
+	for _, ipc := range result.IPs {
+		if ipc.Interface != nil && *ipc.Interface != contIndex {
+			continue
+		}
+		status.IPs = append(status.IPs, ipc.Address.IP.String())
+		if ipc.Gateway != nil {
+			status.Gateway = append(status.Gateway, ipc.Gateway.String())
+		}
+	}
+	status.DNS = nadv1.DNS{
+		Nameservers: result.DNS.Nameservers,
+		Domain:      result.DNS.Domain,
+		Search:      result.DNS.Search,
+		Options:     result.DNS.Options,
+	}
+	return status
+}
+
+// deviceInfo returns the device info of the container interface of a VF or
+// vhost-user attachment, nil for veth attachments
+func deviceInfo(contIface, hostIface *current.Interface) *nadv1.DeviceInfo {
+	switch {
+	case contIface.PciID != "":
+		pci := &nadv1.PciDevice{PciAddress: contIface.PciID}
+		if pfPci, err := sriov.Devices.PFPci(contIface.PciID); err == nil {
+			pci.PfPciAddress = pfPci
+		}
+		if hostIface != nil {
+			pci.RepresentorDevice = hostIface.Name
+		}
+		return &nadv1.DeviceInfo{Type: nadv1.DeviceInfoTypePCI, Version: nadv1.DeviceInfoVersion, Pci: pci}
+	case contIface.SocketPath != "":
+		// OVS connects to the socket as client, the pod listens on it
+		return &nadv1.DeviceInfo{
+			Type:      nadv1.DeviceInfoTypeVHostUser,
+			Version:   nadv1.DeviceInfoVersion,
+			VhostUser: &nadv1.VhostDevice{Mode: nadv1.VhostDeviceModeServer, Path: contIface.SocketPath},
+		}
+	}
+	return nil
+}
+
+// setNetworkStatus replaces the entry of the network and interface in the
+// network-status annotation, a nil status removes it. Other entries are
+// kept as they are.
+func setNetworkStatus(annotation, name, ifName string, status *attachmentStatus) (string, error) {
+	var entries []json.RawMessage
+	if annotation != "" {
+		if err := json.Unmarshal([]byte(annotation), &entries); err != nil {
+			return "", fmt.Errorf("failed to parse %s annotation: %v", nadv1.NetworkStatusAnnot, err)
+		}
+	}
+	updated := make([]json.RawMessage, 0, len(entries)+1)
+	for _, entry := range entries {
+		var key struct {
+			Name      string `json:"name"`
+			Interface string `json:"interface"`
+		}
+		if err := json.Unmarshal(entry, &key); err == nil && key.Name == name && key.Interface == ifName {
+			continue
+		}
+		updated = append(updated, entry)
+	}
+	if status != nil {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return "", err
+		}
+		updated = append(updated, data)
+	}
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// publishNetworkStatus writes the entry of the attachment to the
+// network-status annotation of the pod when network_status is configured,
+// a nil status removes it. Failures are only logged, Multus renders the
+// annotation from the result anyway.
+func publishNetworkStatus(netconf *types.NetConf, envArgs *EnvArgs, ifName string, status *attachmentStatus) {
+	if netconf.NetworkStatus == nil {
+		return
+	}
+	if envArgs == nil || envArgs.K8S_POD_NAMESPACE == "" || envArgs.K8S_POD_NAME == "" {
+		log.Printf("Warning: network status of %s is not published, the pod is unknown", ifName)
+		return
+	}
+	namespace, name := string(envArgs.K8S_POD_NAMESPACE), string(envArgs.K8S_POD_NAME)
+	if err := updatePodNetworkStatus(netconf.NetworkStatus.Kubeconfig, namespace, name, netconf.Name, ifName, status); err != nil {
+		log.Printf("Failed to publish network status of %s of pod %s/%s: %v", ifName, namespace, name, err)
+	}
+}
+
+func updatePodNetworkStatus(kubeconfig, namespace, podName, name, ifName string, status *attachmentStatus) error {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), networkStatusTimeout)
+	defer cancel()
+	pods := clientset.CoreV1().Pods(namespace)
+	for attempt := 1; ; attempt++ {
+		pod, err := pods.Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			if status == nil && apierrors.IsNotFound(err) {
+				// nothing to remove from a deleted pod
+				return nil
+			}
+			return err
+		}
+		annotation, err := setNetworkStatus(pod.Annotations[nadv1.NetworkStatusAnnot], name, ifName, status)
+		if err != nil {
+			return err
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[nadv1.NetworkStatusAnnot] = annotation
+		_, err = pods.Update(ctx, pod, metav1.UpdateOptions{})
+		if err == nil || !apierrors.IsConflict(err) || attempt == networkStatusRetries {
+			return err
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"net"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Network status", func() {
+	netconf := &types.NetConf{NetConf: cnitypes.NetConf{Name: "net-a"}}
+
+	It("should describe the container interface of a veth attachment", func() {
+		result := &current.Result{
+			Interfaces: []*current.Interface{
+				{Name: "veth1234", Mac: "02:00:00:00:00:01"},
+				{Name: "net1", Mac: "0a:58:0a:01:00:05", Mtu: 9000, Sandbox: "/var/run/netns/pod"},
+			},
+			IPs: []*current.IPConfig{{
+				Interface: current.Int(1),
+				Address:   net.IPNet{IP: net.ParseIP("10.1.0.5"), Mask: net.CIDRMask(24, 32)},
+				Gateway:   net.ParseIP("10.1.0.1"),
+			}},
+			DNS: cnitypes.DNS{Nameservers: []string{"10.1.0.2"}},
+		}
+		status := networkStatus(netconf, "net1", 100, result)
+		Expect(status.Name).To(Equal("net-a"))
+		Expect(status.Interface).To(Equal("net1"))
+		Expect(status.Mac).To(Equal("0a:58:0a:01:00:05"))
+		Expect(status.Mtu).To(Equal(9000))
+		Expect(status.IPs).To(Equal([]string{"10.1.0.5"}))
+		Expect(status.Gateway).To(Equal([]string{"10.1.0.1"}))
+		Expect(status.DNS.Nameservers).To(Equal([]string{"10.1.0.2"}))
+		Expect(status.DeviceInfo).To(BeNil())
+		Expect(status.VLAN).To(Equal(uint(100)))
+	})
+	It("should describe the VF and its representor", func() {
+		result := &current.Result{
+			Interfaces: []*current.Interface{
+				{Name: "pf0vf3"},
+				{Name: "net1", Sandbox: "/var/run/netns/pod", PciID: "0000:03:00.5"},
+			},
+		}
+		status := networkStatus(netconf, "net1", 0, result)
+		Expect(status.DeviceInfo).NotTo(BeNil())
+		Expect(status.DeviceInfo.Type).To(Equal(nadv1.DeviceInfoTypePCI))
+		Expect(status.DeviceInfo.Pci.PciAddress).To(Equal("0000:03:00.5"))
+		Expect(status.DeviceInfo.Pci.RepresentorDevice).To(Equal("pf0vf3"))
+	})
+	It("should describe the vhost-user socket", func() {
+		result := &current.Result{
+			Interfaces: []*current.Interface{{Name: "net1", Sandbox: "/var/run/netns/pod", SocketPath: "/var/lib/vhost/socket"}},
+		}
+		status := networkStatus(netconf, "net1", 0, result)
+		Expect(status.DeviceInfo.Type).To(Equal(nadv1.DeviceInfoTypeVHostUser))
+		Expect(status.DeviceInfo.VhostUser).To(Equal(&nadv1.VhostDevice{Mode: nadv1.VhostDeviceModeServer, Path: "/var/lib/vhost/socket"}))
+	})
+	It("should replace only the entry of the attachment in the annotation", func() {
+		annotation := `[{"name":"default","interface":"eth0","ips":["10.244.0.5"],"default":true,"extra":1},{"name":"net-a","interface":"net1","ips":["10.1.0.4"]}]`
+		status := &attachmentStatus{NetworkStatus: nadv1.NetworkStatus{Name: "net-a", Interface: "net1", IPs: []string{"10.1.0.5"}}, VLAN: 100}
+		updated, err := setNetworkStatus(annotation, "net-a", "net1", status)
+		Expect(err).NotTo(HaveOccurred())
+
+		var entries []map[string]interface{}
+		Expect(json.Unmarshal([]byte(updated), &entries)).To(Succeed())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0]).To(HaveKeyWithValue("extra", BeNumerically("==", 1)))
+		Expect(entries[1]).To(HaveKeyWithValue("ips", ConsistOf("10.1.0.5")))
+		Expect(entries[1]).To(HaveKeyWithValue("vlan", BeNumerically("==", 100)))
+
+		updated, err = setNetworkStatus(updated, "net-a", "net1", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal([]byte(updated), &entries)).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0]).To(HaveKeyWithValue("name", "default"))
+	})
+	It("should start the annotation when the pod has none", func() {
+		status := &attachmentStatus{NetworkStatus: nadv1.NetworkStatus{Name: "net-a", Interface: "net1"}}
+		Expect(setNetworkStatus("", "net-a", "net1", status)).To(Equal(`[{"name":"net-a","interface":"net1","dns":{}}]`))
+		_, err := setNetworkStatus("{", "net-a", "net1", status)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -22,12 +22,16 @@ package plugin
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
@@ -35,25 +39,30 @@ import (
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ip"
-	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/j-keck/arping"
 	"github.com/vishvananda/netlink"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ethtool"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/neigh"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
 
-// EnvArgs args containing common, desired mac and ovs port name
-type EnvArgs struct {
-	cnitypes.CommonArgs
-	MAC         cnitypes.UnmarshallableString `json:"mac,omitempty"`
-	OvnPort     cnitypes.UnmarshallableString `json:"ovnPort,omitempty"`
-	K8S_POD_UID cnitypes.UnmarshallableString
-}
+// quarantinePrefix is prepended to names of interfaces retained on DEL
+const quarantinePrefix = "q"
+
+// backoff of removal of ports whose interfaces have an error
+const (
+	cleanPortsBackoffKey  = ".clean-ports-backoff"
+	cleanPortsBackoffBase = 10 * time.Second
+	cleanPortsBackoffMax  = 10 * time.Minute
+)
 
 func init() {
 	// this ensures that main runs only on main thread (thread group leader).
@@ -67,20 +76,29 @@ func logCall(command string, args *skel.CmdArgs) {
 		command, args.ContainerID, args.Netns, args.IfName, string(args.StdinData[:]))
 }
 
-func getEnvArgs(envArgsString string) (*EnvArgs, error) {
-	if envArgsString != "" {
-		e := EnvArgs{}
-		err := cnitypes.LoadArgs(envArgsString, &e)
-		if err != nil {
-			return nil, err
+// setupIPAMEnv extends environment of the plugin process with the configured
+// IPAM environment, it is inherited by ipam.ExecAdd/ExecDel/ExecCheck.
+// CNI protocol variables can't be overridden, except of CNI_PATH.
+func setupIPAMEnv(netconf *types.NetConf) error {
+	for key, value := range netconf.IPAMEnv {
+		if strings.HasPrefix(key, "CNI_") && key != "CNI_PATH" {
+			return fmt.Errorf("ipam_env: overriding %s is not allowed", key)
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("ipam_env: failed to set %s: %v", key, err)
+		}
+	}
+	if len(netconf.IPAMPath) > 0 {
+		cniPath := append(append([]string{}, netconf.IPAMPath...), filepath.SplitList(os.Getenv("CNI_PATH"))...)
+		if err := os.Setenv("CNI_PATH", strings.Join(cniPath, string(os.PathListSeparator))); err != nil {
+			return fmt.Errorf("ipam_path: failed to set CNI_PATH: %v", err)
 		}
-		return &e, nil
 	}
-	return nil, nil
+	return nil
 }
 
 func getHardwareAddr(ifName string) string {
-	ifLink, err := netlink.LinkByName(ifName)
+	ifLink, err := netif.Default.LinkByName(ifName)
 	if err != nil {
 		return ""
 	}
@@ -104,15 +122,170 @@ func IPAddrToHWAddr(ip net.IP) net.HardwareAddr {
 	return net.HardwareAddr{0x0A, 0x58, hash[0], hash[1], hash[2], hash[3]}
 }
 
-func setupVeth(contNetns ns.NetNS, contIfaceName string, requestedMac string, mtu int) (*current.Interface, *current.Interface, error) {
+// hwAddrSourceIP returns the IP address the container MAC address is derived from.
+// IPv4 address is preferred, so dual-stack attachments get the same MAC address as
+// IPv4-only ones regardless of the order of IPAM results, IPv6-only attachments use
+// the first IPv6 address.
+func hwAddrSourceIP(ips []*current.IPConfig) net.IP {
+	for _, ipc := range ips {
+		if ipc.Address.IP.To4() != nil {
+			return ipc.Address.IP
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0].Address.IP
+	}
+	return nil
+}
+
+// prepareIPAMResult adds interfaces of the attachment to the IPAM result,
+// its addresses apply to the container interface or the bond
+func prepareIPAMResult(netconf *types.NetConf, newResult *current.Result, contIface, bondIface *current.Interface) error {
+	if len(newResult.IPs) == 0 {
+		return errors.New("IPAM plugin returned missing IP config")
+	}
+
+	newResult.Interfaces = []*current.Interface{contIface}
+	newResult.Interfaces[0].Mac = contIface.Mac
+
+	ipIfIndex := 0
+	if bondIface != nil {
+		newResult.Interfaces = append(newResult.Interfaces, bondIface)
+		ipIfIndex = 1
+	}
+	for _, ipc := range newResult.IPs {
+		// All addresses apply to the container interface
+		ipc.Interface = current.Int(ipIfIndex)
+	}
+	if isRoutedMode(netconf) {
+		toRoutedResult(newResult)
+	}
+	return nil
+}
+
+// configureContainerIPs sets MAC derived from addresses of the result on the
+// interface, configures them and announces them. With ip_conflict, addresses
+// used by other hosts are returned in an ipConflictError instead. It must run
+// in the container netns.
+func configureContainerIPs(netconf *types.NetConf, newResult *current.Result, ipIfName, contIfName, mac string, macPrefix net.HardwareAddr, contPodUid string) error {
+	if mac == "" && !sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) && len(newResult.IPs) >= 1 {
+		containerMac := withMACPrefix(derivedHWAddr(netconf.MACDerivation, newResult.IPs, contPodUid, contIfName), macPrefix)
+		containerLink, err := netif.Default.LinkByName(ipIfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup container interface %q: %v", ipIfName, err)
+		}
+		err = assignMacToLink(containerLink, containerMac, ipIfName)
+		if err != nil {
+			return err
+		}
+		// members of the bond take over its MAC
+		for _, iface := range newResult.Interfaces {
+			iface.Mac = containerMac.String()
+		}
+	}
+	ifaceResult := newResult
+	if isRoutedMode(netconf) {
+		// routes via the virtual gateway are added once it is reachable
+		ifaceResult = &current.Result{Interfaces: newResult.Interfaces, IPs: newResult.IPs}
+	}
+	if err := probeIPConflicts(netconf, ipIfName, newResult); err != nil {
+		return err
+	}
+	if err := configureIface(netconf, ipIfName, ifaceResult); err != nil {
+		return err
+	}
+	if err := checkDADConflicts(netconf, ipIfName, newResult); err != nil {
+		return err
+	}
+	if isRoutedMode(netconf) {
+		// there is no flooding domain to announce the addresses in
+		return configureRoutedContainer(contIfName, newResult)
+	}
+	contVeth, err := net.InterfaceByName(ipIfName)
+	if err != nil {
+		return fmt.Errorf("failed to look up %q: %v", ipIfName, err)
+	}
+	announceIPs(contVeth, newResult.IPs, netconf.Garp)
+	return nil
+}
+
+// announceIPs lets other ends refresh their neighbor caches for the addresses
+// assigned to the container interface. Switches rate limiting ARP may drop a
+// single announcement, garp repeats them. IPv6 addresses are advertised once
+// they pass DAD, the wait is bounded by dad_timeout or dadTimeout. Failures
+// are only logged.
+func announceIPs(contIface *net.Interface, ips []*current.IPConfig, garp *types.Garp) {
+	count, interval, settleTimeout := 1, time.Duration(0), dadTimeout
+	if garp != nil {
+		if garp.Disabled {
+			return
+		}
+		count, interval = garp.Count, time.Duration(garp.Interval)*time.Millisecond
+		if garp.DADTimeout > 0 {
+			settleTimeout = time.Duration(garp.DADTimeout) * time.Second
+		}
+	}
+	unsettled := map[string]bool{}
+	if hasIPv6(ips) {
+		// a tentative address can't be bound to send the advertisement
+		var addrs []net.IP
+		for _, ipc := range ips {
+			addrs = append(addrs, ipc.Address.IP)
+		}
+		failed, err := neigh.WaitDAD(contIface.Name, addrs, settleTimeout)
+		if err != nil {
+			log.Printf("Warning: %v, IPv6 addresses are not advertised", err)
+			for _, addr := range addrs {
+				unsettled[addr.String()] = true
+			}
+		}
+		for _, addr := range failed {
+			log.Printf("Warning: %s failed DAD, it is not advertised", addr)
+			unsettled[addr.String()] = true
+		}
+	}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		for _, ipc := range ips {
+			if ipc.Address.IP.To4() == nil {
+				if unsettled[ipc.Address.IP.String()] {
+					continue
+				}
+				// send unsolicited NA for other ends to refresh their neighbor cache
+				if err := neigh.SendUnsolicitedNA(ipc.Address.IP, contIface); err != nil {
+					log.Printf("error sending unsolicited NA for ip %s: %v", ipc.Address.IP.String(), err)
+				}
+				continue
+			}
+			// send gratuitous arp for other ends to refresh its arp cache
+			if err := arping.GratuitousArpOverIface(ipc.Address.IP, *contIface); err != nil {
+				// ok to ignore returning this error
+				log.Printf("error sending garp for ip %s: %v", ipc.Address.IP.String(), err)
+			}
+		}
+	}
+}
+
+func hasIPv6(ips []*current.IPConfig) bool {
+	for _, ipc := range ips {
+		if ipc.Address.IP.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func setupVeth(contNetns ns.NetNS, contIfaceName, hostIfaceName string, requestedMac string, mtu int) (*current.Interface, *current.Interface, error) {
 	hostIface := &current.Interface{}
 	contIface := &current.Interface{}
 
 	// Enter container network namespace and create veth pair inside. Doing
 	// this we will make sure that both ends of the veth pair will be removed
 	// when the container is gone.
-	err := contNetns.Do(func(hostNetns ns.NetNS) error {
-		hostVeth, containerVeth, err := ip.SetupVeth(contIfaceName, mtu, requestedMac, hostNetns)
+	err := netns.Do(contNetns, func(hostNetns ns.NetNS) error {
+		hostVeth, containerVeth, err := netif.Default.SetupVeth(contIfaceName, hostIfaceName, mtu, requestedMac, hostNetns)
 		if err != nil {
 			return err
 		}
@@ -123,6 +296,7 @@ func setupVeth(contNetns ns.NetNS, contIfaceName string, requestedMac string, mt
 
 		contIface.Name = containerVeth.Name
 		contIface.Mac = containerVeth.HardwareAddr.String()
+		contIface.Mtu = containerVeth.MTU
 		contIface.Sandbox = contNetns.Path()
 		hostIface.Name = hostVeth.Name
 		return nil
@@ -139,13 +313,97 @@ func setupVeth(contNetns ns.NetNS, contIfaceName string, requestedMac string, mt
 	return hostIface, contIface, nil
 }
 
+// removeStaleContIface removes an interface with the requested name left in the
+// container netns by a previous partially failed ADD, instead of letting
+// ip.SetupVeth fail with an opaque EEXIST. The interface is removed only if it
+// is a veth which belongs to ovs-cni, either because its OVS port is still
+// present or because its host side was never attached anywhere.
+func removeStaleContIface(ovsDriver *ovsdb.OvsBridgeDriver, contNetns ns.NetNS, contIfaceName, contNetwork string) error {
+	portName, portFound, err := getOvsPortForContIface(ovsDriver, contIfaceName, contNetns.Path(), contNetwork)
+	if err != nil {
+		return fmt.Errorf("failed to obtain OVS port for container iface %s: %v", contIfaceName, err)
+	}
+
+	var stale netlink.Link
+	peerIndex := 0
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		link, err := netif.Default.LinkByName(contIfaceName)
+		if err != nil {
+			if netif.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		veth, isVeth := link.(*netlink.Veth)
+		if !isVeth {
+			return fmt.Errorf("interface %s already exists in container netns and it is not a veth", contIfaceName)
+		}
+		stale = link
+		peerIndex, err = netif.Default.VethPeerIndex(veth)
+		return err
+	})
+	if err != nil || stale == nil {
+		return err
+	}
+
+	if !portFound {
+		peer, err := netif.Default.LinkByIndex(peerIndex)
+		if err != nil {
+			return fmt.Errorf("failed to lookup peer of existing container iface %s: %v", contIfaceName, err)
+		}
+		if peer.Attrs().MasterIndex != 0 {
+			return fmt.Errorf("interface %s already exists in container netns and its peer %s is in use",
+				contIfaceName, peer.Attrs().Name)
+		}
+	}
+
+	log.Printf("Info: removing interface %s left in container netns by a previous attempt", contIfaceName)
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		return netif.Default.LinkDel(stale)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove stale container iface %s: %v", contIfaceName, err)
+	}
+	if portFound {
+		if err := removeOvsPort(ovsDriver, portName); err != nil {
+			log.Printf("Failed best-effort cleanup of stale port %s: %v", portName, err)
+		}
+	}
+	return nil
+}
+
+// setOffload applies configured offload settings on the interface
+func setOffload(ifName string, offload *types.Offload) error {
+	if offload == nil {
+		return nil
+	}
+	settings := []struct {
+		feature ethtool.Feature
+		enabled *bool
+	}{
+		{ethtool.TSO, offload.TSO},
+		{ethtool.GSO, offload.GSO},
+		{ethtool.RxChecksum, offload.RxChecksum},
+		{ethtool.TxChecksum, offload.TxChecksum},
+	}
+	for _, setting := range settings {
+		if setting.enabled == nil {
+			continue
+		}
+		if err := ethtool.SetFeature(ifName, setting.feature, *setting.enabled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func setInterfaceUp(name string) error {
-	link, err := netlink.LinkByName(name)
+	link, err := netif.Default.LinkByName(name)
 	if err != nil {
 		return err
 	}
 
-	if err := netlink.LinkSetUp(link); err != nil {
+	if err := netif.Default.LinkSetUp(link); err != nil {
 		return err
 	}
 
@@ -153,7 +411,7 @@ func setInterfaceUp(name string) error {
 }
 
 func assignMacToLink(link netlink.Link, mac net.HardwareAddr, name string) error {
-	err := netlink.LinkSetHardwareAddr(link, mac)
+	err := netif.Default.LinkSetHardwareAddr(link, mac)
 	if err != nil {
 		return fmt.Errorf("failed to set container iface %q MAC %q: %v", name, mac.String(), err)
 	}
@@ -161,97 +419,147 @@ func assignMacToLink(link netlink.Link, mac net.HardwareAddr, name string) error
 }
 
 func getBridgeName(driver *ovsdb.OvsDriver, bridgeName, ovnPort, deviceID string) (string, error) {
-	if bridgeName != "" {
-		return bridgeName, nil
-	} else if bridgeName == "" && ovnPort != "" {
-		return "br-int", nil
-	} else if deviceID != "" {
-		possibleUplinkNames, err := sriov.GetBridgeUplinkNameByDeviceID(deviceID)
+	selection, err := selectBridge(driver, bridgeName, ovnPort, deviceID)
+	if err != nil {
+		return "", err
+	}
+	return selection.Bridge, nil
+}
+
+// bridgeSocketFile returns the OVSDB socket of the database holding the bridge
+// ports are attached to. It differs from socket_file when the bridge is managed
+// by OVS running on a DPU, while the host OVSDB is used to discover representors.
+func bridgeSocketFile(netconf *types.NetConf) string {
+	if netconf.BridgeSocketFile != "" {
+		return netconf.BridgeSocketFile
+	}
+	return netconf.SocketFile
+}
+
+// representorPortName returns the name of the port of the representor of the
+// VF on the bridge. A DPU-hosted bridge has the representor on the DPU, named
+// differently than the one discovered on the host.
+func representorPortName(netconf *types.NetConf, deviceID string) (string, error) {
+	if netconf.BridgeSocketFile != "" {
+		return sriov.GetDPURepresentor(deviceID, netconf.Representor)
+	}
+	return sriov.GetNetRepresentor(deviceID, netconf.Representor)
+}
+
+// useDPURepresentors names host interfaces of the VFs after their
+// representors on the DPU, the ports of the attachment on a DPU-hosted bridge.
+// MAC addresses of the representors on the host don't apply to them.
+func useDPURepresentors(netconf *types.NetConf, deviceIDs []string, hostIfaces []*current.Interface) error {
+	for i, deviceID := range deviceIDs {
+		name, err := sriov.GetDPURepresentor(deviceID, netconf.Representor)
 		if err != nil {
-			return "", fmt.Errorf("failed to get bridge name - failed to resolve uplink name: %v", err)
-		}
-		var errList []error
-		for _, uplinkName := range possibleUplinkNames {
-			bridgeName, err = driver.FindBridgeByInterface(uplinkName)
-			if err != nil {
-				errList = append(errList,
-					fmt.Errorf("failed to get bridge name - failed to find bridge name by uplink name %s: %v", uplinkName, err))
-				continue
-			}
-			return bridgeName, nil
+			return err
 		}
-		return "", fmt.Errorf("failed to find bridge by uplink names %v: %v", possibleUplinkNames, errList)
+		hostIfaces[i].Name = name
+		hostIfaces[i].Mac = ""
 	}
+	return nil
+}
 
-	return "", fmt.Errorf("failed to get bridge name")
+// newBridgeDriver connects to the OVSDB holding the bridge in the mode configured by netconf
+func newBridgeDriver(bridgeName string, netconf *types.NetConf) (*ovsdb.OvsBridgeDriver, error) {
+	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridgeName, bridgeSocketFile(netconf))
+	if err != nil {
+		return nil, err
+	}
+	ovsBridgeDriver.LeastPrivilege = netconf.OvsdbLeastPrivilege
+	ovsBridgeDriver.Force = netconf.ForcePortRemoval
+	ovsBridgeDriver.KeyPrefix = netconf.OvsdbKeyPrefix
+	if netconf.OfportRange != nil {
+		ovsBridgeDriver.OfportRangeMin = netconf.OfportRange.Min
+		ovsBridgeDriver.OfportRangeMax = netconf.OfportRange.Max
+	}
+	return ovsBridgeDriver, nil
 }
 
-func attachIfaceToBridge(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string, contIfaceName string, ofportRequest uint, vlanTag uint, trunks []uint, portType string, intfType string, contNetnsPath string, ovnPortName string, contPodUid string) error {
-	err := ovsDriver.CreatePort(hostIfaceName, contNetnsPath, contIfaceName, ovnPortName, ofportRequest, vlanTag, trunks, portType, intfType, contPodUid)
+func attachIfaceToBridge(ovsDriver *ovsdb.OvsBridgeDriver, port ovsdb.PortOptions) error {
+	err := ovsDriver.CreatePort(port)
 	if err != nil {
 		return err
 	}
 
-	hostLink, err := netlink.LinkByName(hostIfaceName)
+	hostLink, err := netif.Default.LinkByName(port.Name)
 	if err != nil {
 		return err
 	}
 
-	if err := netlink.LinkSetUp(hostLink); err != nil {
+	if err := netif.Default.LinkSetUp(hostLink); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// attachPortToBridge attaches the host side of the attachment to the bridge,
+// the representor attached to a DPU-hosted bridge is on the DPU, so it's left
+// to the DPU to bring it up
+func attachPortToBridge(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, port ovsdb.PortOptions) error {
+	if netconf.BridgeSocketFile != "" && sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) {
+		return ovsDriver.CreatePort(port)
+	}
+	return attachIfaceToBridge(ovsDriver, port)
+}
+
 func refetchIface(iface *current.Interface) error {
 	iface.Mac = getHardwareAddr(iface.Name)
 	return nil
 }
 
-func splitVlanIds(trunks []*types.Trunk) ([]uint, error) {
+// vlanMode returns vlan_mode of the port: the one of the netconf when set,
+// dot1q-tunnel with qinq, access
+// with only a VLAN ID set, native-tagged with both a VLAN ID and trunks,
+// trunk otherwise
+func vlanMode(netconf *types.NetConf) string {
+	switch {
+	case netconf.VlanMode != "":
+		return netconf.VlanMode
+	case netconf.QinQ != nil:
+		return "dot1q-tunnel"
+	case netconf.VlanTag != nil && len(netconf.Trunk) > 0:
+		return "native-tagged"
+	case netconf.VlanTag != nil:
+		return "access"
+	}
+	return "trunk"
+}
+
+// qinqEthType returns ethertype of the S-VLAN tag of a port in dot1q-tunnel
+// mode, empty for other ports
+func qinqEthType(netconf *types.NetConf) string {
+	if netconf.QinQ == nil {
+		return ""
+	}
+	if netconf.QinQ.EthType == "" {
+		return config.QinQEthType8021ad
+	}
+	return netconf.QinQ.EthType
+}
+
+// splitVlanIds returns sorted VLAN IDs of trunks, they must be validated by
+// config.Validate
+func splitVlanIds(trunks []*types.Trunk) []uint {
 	vlans := make(map[uint]bool)
 	for _, item := range trunks {
-		var minID uint = 0
-		var maxID uint = 0
-		if item.MinID != nil {
-			minID = *item.MinID
-			if minID > 4096 {
-				return nil, errors.New("incorrect trunk minID parameter")
-			}
-		}
-		if item.MaxID != nil {
-			maxID = *item.MaxID
-			if maxID > 4096 {
-				return nil, errors.New("incorrect trunk maxID parameter")
-			}
-			if maxID < minID {
-				return nil, errors.New("minID is greater than maxID in trunk parameter")
-			}
-		}
-		if minID > 0 && maxID > 0 {
-			for v := minID; v <= maxID; v++ {
+		if item.MinID != nil && item.MaxID != nil {
+			for v := *item.MinID; v <= *item.MaxID; v++ {
 				vlans[v] = true
 			}
 		}
-		var id uint
 		if item.ID != nil {
-			id = *item.ID
-			if minID > 4096 {
-				return nil, errors.New("incorrect trunk id parameter")
-			}
-			vlans[id] = true
+			vlans[*item.ID] = true
 		}
 	}
-	if len(vlans) == 0 {
-		return nil, errors.New("trunk parameter is misconfigured")
-	}
 	vlanIds := make([]uint, 0, len(vlans))
 	for k := range vlans {
 		vlanIds = append(vlanIds, k)
 	}
 	sort.Slice(vlanIds, func(i, j int) bool { return vlanIds[i] < vlanIds[j] })
-	return vlanIds, nil
+	return vlanIds
 }
 
 // CmdAdd add handler for attaching container into network
@@ -260,55 +568,88 @@ func CmdAdd(args *skel.CmdArgs) error {
 
 	envArgs, err := getEnvArgs(args.Args)
 	if err != nil {
-		return err
+		return newError(cnitypes.ErrInvalidEnvironmentVariables, err)
+	}
+	if args.StdinData, err = config.ExpandStaticIPAM(args.StdinData); err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
 	}
 
-	var mac string
 	var ovnPort string
 	var contPodUid string
+	pod := podIdentity{ifName: args.IfName}
 	if envArgs != nil {
-		mac = string(envArgs.MAC)
 		ovnPort = string(envArgs.OvnPort)
 		contPodUid = string(envArgs.K8S_POD_UID)
+		pod.namespace = string(envArgs.K8S_POD_NAMESPACE)
+		pod.name = string(envArgs.K8S_POD_NAME)
 	}
 
 	netconf, err := config.LoadConf(args.StdinData)
 	if err != nil {
+		return newError(cnitypes.ErrDecodingFailure, err)
+	}
+	mac, err := resolveMAC(netconf, envArgs)
+	if err != nil {
+		return newError(cnitypes.ErrInvalidEnvironmentVariables, err)
+	}
+	applyEnvArgs(netconf, envArgs)
+	applyRuntimeConfig(netconf)
+	if err := config.Validate(netconf); err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
+	}
+	if err := config.ValidateIfName(netconf, args.IfName); err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
+	}
+	if err := resolveBridgeTemplate(netconf); err != nil {
 		return err
 	}
+	forgetCheck(config.GetNetworkCRef(netconf.Name, args.ContainerID, args.IfName))
 
-	var vlanTagNum uint = 0
-	trunks := make([]uint, 0)
-	portType := "access"
-	if netconf.VlanTag == nil || len(netconf.Trunk) > 0 {
-		portType = "trunk"
-		if len(netconf.Trunk) > 0 {
-			trunkVlanIds, err := splitVlanIds(netconf.Trunk)
-			if err != nil {
-				return err
-			}
-			trunks = append(trunks, trunkVlanIds...)
-		}
-	} else if netconf.VlanTag != nil {
-		vlanTagNum = *netconf.VlanTag
+	if ovsUnavailable(netconf) {
+		return addDegraded(args, netconf)
 	}
+
+	vlanTagNum, trunks, portType := portVlan(netconf)
 	ovsDriver, err := ovsdb.NewOvsDriver(netconf.SocketFile)
 	if err != nil {
-		return err
+		return newError(cnitypes.ErrTryAgainLater, err)
 	}
-	bridgeName, err := getBridgeName(ovsDriver, netconf.BrName, ovnPort, netconf.DeviceID)
+	bridgeSelection, err := resolveAddBridge(ovsDriver, netconf, ovnPort)
 	if err != nil {
 		return err
 	}
-	// save discovered bridge name to the netconf struct to make
-	// sure it is save in the cache.
-	// we need to cache discovered bridge name to make sure that we will
-	// use the right bridge name in CmdDel
-	netconf.BrName = bridgeName
+	bridgeName := netconf.BrName
+	pod.bridge = bridgeName
 
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridgeName, netconf.SocketFile)
+	var macPrefix net.HardwareAddr
+	if netconf.MACPrefix != "" {
+		if macPrefix, err = config.ParseMACPrefix(netconf.MACPrefix); err != nil {
+			return newError(cnitypes.ErrInvalidNetworkConfig, err)
+		}
+	}
+	if mac != "" && len(netconf.AllowedMACPrefixes) > 0 {
+		if err = checkRequestedMAC(mac, netconf.AllowedMACPrefixes); err != nil {
+			return newError(cnitypes.ErrInvalidNetworkConfig, err)
+		}
+	}
+
+	// leases of the dhcp daemon are bound to client identifier and MAC
+	// address, make both stable across pod restarts. The MAC must be set
+	// before DHCP runs, so it is not derived from the assigned IP later.
+	ipamStdinData := args.StdinData
+	if netconf.IPAM.Type == dhcpIPAMType && pod.known() {
+		ipamStdinData, err = addDHCPOptions(args.StdinData, pod)
+		if err != nil {
+			return err
+		}
+		if mac == "" {
+			mac = withMACPrefix(pod.stableHWAddr(), macPrefix).String()
+		}
+	}
+
+	ovsBridgeDriver, err := newBridgeDriver(bridgeName, netconf)
 	if err != nil {
-		return err
+		return newError(cnitypes.ErrTryAgainLater, err)
 	}
 
 	// check if the device driver is the type of userspace driver
@@ -325,198 +666,749 @@ func CmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
-	contNetns, err := ns.GetNS(args.Netns)
+	if err := checkUplink(ovsBridgeDriver, netconf); err != nil {
+		return err
+	}
+	detectMTU(ovsBridgeDriver, netconf, userspaceMode)
+	checkUplinkMTU(ovsBridgeDriver, netconf)
+
+	ports := 1
+	if isBondedVFMode(netconf) {
+		ports = len(bondedDeviceIDs(netconf))
+	}
+	capacityLock, err := checkBridgeCapacity(ovsDriver, bridgeName, ports)
+	if err != nil {
+		return err
+	}
+	defer capacityLock.Release()
+
+	if isVhostUserMode(netconf) {
+		return addVhostUser(args, netconf, envArgs, ovsBridgeDriver, vlanTagNum, trunks, portType, ovnPort, contPodUid)
+	}
+
+	contNetns, err := netns.Get(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return openNetnsError(args.Netns, err)
 	}
 	defer contNetns.Close()
 
-	// userspace driver does not create a network interface for the VF on the host
-	var origIfName string
-	if sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) && !userspaceMode {
-		origIfName, err = sriov.GetVFLinkName(netconf.DeviceID)
-		if err != nil {
-			return err
-		}
+	origIfName, origIfNames, err := vfOrigIfNames(netconf, userspaceMode)
+	if err != nil {
+		return err
+	}
+	// vf_tuning is reverted on DEL, so the next pod gets the VFs as they were
+	vfIfNames := origIfNames
+	if !isBondedVFMode(netconf) && origIfName != "" {
+		vfIfNames = []string{origIfName}
+	}
+	origTuning, err := origVFTuning(netconf, vfIfNames)
+	if err != nil {
+		return err
 	}
 
 	// Cache NetConf for CmdDel
-	if err = utils.SaveCache(config.GetCRef(args.ContainerID, args.IfName),
-		&types.CachedNetConf{Netconf: netconf, OrigIfName: origIfName, UserspaceMode: userspaceMode}); err != nil {
+	cRef := config.GetNetworkCRef(netconf.Name, args.ContainerID, args.IfName)
+	cachedNetConf := &types.CachedNetConf{Netconf: netconf, OrigIfName: origIfName, OrigIfNames: origIfNames, UserspaceMode: userspaceMode,
+		ContainerID: args.ContainerID, IfName: args.IfName, Netns: args.Netns, BridgeSelection: bridgeSelection, OrigVFTuning: origTuning}
+	if netconf.IPAM.Type != "" {
+		cachedNetConf.IPAMStdinData = ipamStdinData
+	}
+	if hasUserspaceIPAM(netconf, userspaceMode) {
+		cachedNetConf.UserspaceIPAMPort = userspaceIPAMPortName(netconf.Name, args.ContainerID, args.IfName)
+	}
+	if err = utils.SaveCache(cRef, cachedNetConf); err != nil {
 		return fmt.Errorf("error saving NetConf %q", err)
 	}
 
 	var hostIface, contIface *current.Interface
-	if sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) {
-		hostIface, contIface, err = sriov.SetupSriovInterface(contNetns, args.ContainerID, args.IfName, mac, netconf.MTU, netconf.DeviceID, userspaceMode)
-		if err != nil {
+	var bondedHostIfaces []*current.Interface
+	if isBondedVFMode(netconf) || sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) {
+		hostIface, contIface, bondedHostIfaces, err = setupSriovAttachment(netconf, contNetns, args.ContainerID, args.IfName, mac, userspaceMode)
+	} else {
+		hostIface, contIface, err = setupVethAttachment(ovsBridgeDriver, netconf, contNetns, args.ContainerID, args.IfName, pod, mac, macPrefix, vlanTagNum, trunks, portType, ovnPort, contPodUid)
+	}
+	if err != nil {
+		return err
+	}
+
+	// userspace driver does not have a network interface to configure
+	if !userspaceMode {
+		if err = tuneAttachment(netconf, contNetns, hostIface, contIface); err != nil {
 			return err
 		}
-	} else {
-		hostIface, contIface, err = setupVeth(contNetns, args.IfName, mac, netconf.MTU)
-		if err != nil {
+	}
+
+	var bondIface *current.Interface
+	if netconf.Bond != nil {
+		if userspaceMode {
+			return fmt.Errorf("bond can't be used with VF %s bound to a userspace driver", netconf.DeviceID)
+		}
+		if bondIface, err = joinBond(contNetns, args.ContainerID, contIface, netconf.Bond); err != nil {
 			return err
 		}
+		defer func() {
+			if err != nil {
+				if err := leaveBond(args.Netns, args.ContainerID, args.IfName, netconf.Bond); err != nil {
+					log.Printf("Failed best-effort cleanup of bond: %v", err)
+				}
+			}
+		}()
 	}
 
-	if err = attachIfaceToBridge(ovsBridgeDriver, hostIface.Name, contIface.Name, netconf.OfportRequest, vlanTagNum, trunks, portType, netconf.InterfaceType, args.Netns, ovnPort, contPodUid); err != nil {
-		return err
+	port := ovsdb.PortOptions{
+		Name:          hostIface.Name,
+		ContNetns:     args.Netns,
+		ContIface:     contIface.Name,
+		ContNetwork:   netconf.Name,
+		ContPodUID:    contPodUid,
+		ContID:        args.ContainerID,
+		OvnPort:       ovnPort,
+		OfportRequest: netconf.OfportRequest,
+		VlanTag:       vlanTagNum,
+		Trunks:        trunks,
+		VlanMode:      portType,
+		QinQEthType:   qinqEthType(netconf),
+		IntfType:      netconf.InterfaceType,
+	}
+	// an internal port is on the bridge already
+	if !isInternalPortMode(netconf) {
+		if err = attachPortToBridge(ovsBridgeDriver, netconf, port); err != nil {
+			return err
+		}
 	}
+	startCapture(ovsBridgeDriver, netconf, hostIface.Name)
 	defer func() {
 		if err != nil {
-			// Unlike veth pair, OVS port will not be automatically removed
-			// if the following IPAM configuration fails and netns gets removed.
-			portName, portFound, err := getOvsPortForContIface(ovsBridgeDriver, args.IfName, args.Netns)
-			if err != nil {
-				log.Printf("Failed best-effort cleanup: %v", err)
-			}
-			if portFound {
-				if err := removeOvsPort(ovsBridgeDriver, portName); err != nil {
-					log.Printf("Failed best-effort cleanup: %v", err)
-				}
-			}
+			removeAttachedPorts(ovsBridgeDriver, netconf, args.Netns, args.IfName)
 		}
 	}()
 
+	if err = setupRateLimit(ovsBridgeDriver, netconf, hostIface.Name); err != nil {
+		return err
+	}
+	if isBondedVFMode(netconf) {
+		if err = attachBondedVFs(ovsBridgeDriver, netconf, args, bondedHostIfaces, vlanTagNum, trunks, portType, ovnPort, contPodUid); err != nil {
+			return err
+		}
+	}
+	// the ports count against the limit of the bridge now
+	capacityLock.Release()
+
 	result := &current.Result{
 		Interfaces: []*current.Interface{hostIface, contIface},
 	}
+	if bondIface != nil {
+		result.Interfaces = append(result.Interfaces, bondIface)
+	}
 
 	// run the IPAM plugin
 	// userspace driver does not support IPAM plugin,
 	// because there is no network interface for the VF on the host
 	if netconf.IPAM.Type != "" && !userspaceMode {
-		var r cnitypes.Result
-		r, err = ipam.ExecAdd(netconf.IPAM.Type, args.StdinData)
+		if err = setupIPAMEnv(netconf); err != nil {
+			return err
+		}
+		var ipamPlugins []ipamPlugin
+		if ipamPlugins, err = ipamPluginsOf(netconf, ipamStdinData); err != nil {
+			return err
+		}
 		defer func() {
 			if err != nil {
-				if err := ipam.ExecDel(netconf.IPAM.Type, args.StdinData); err != nil {
+				if err := execIPAMDel(ipamPlugins); err != nil {
 					log.Printf("Failed best-effort cleanup IPAM configuration: %v", err)
 				}
 			}
 		}()
-		if err != nil {
-			return fmt.Errorf("failed to set up IPAM plugin type %q: %v", netconf.IPAM.Type, err)
-		}
 
-		// Convert the IPAM result into the current Result type
+		// addresses of a bonded attachment are configured on the bond
+		ipIfName := args.IfName
+		if bondIface != nil {
+			ipIfName = bondIface.Name
+		}
 		var newResult *current.Result
-		newResult, err = current.NewResultFromResult(r)
+		if newResult, err = addIPAM(ovsBridgeDriver, netconf, ipamPlugins, contNetns, port, contIface, bondIface, args.IfName, ipIfName, mac, macPrefix); err != nil {
+			return err
+		}
+		if bondIface != nil {
+			if err = recordBondAddresses(args.ContainerID, args.IfName, netconf.Bond, newResult); err != nil {
+				return err
+			}
+		}
+		if isRoutedMode(netconf) {
+			if err = setupRoutedAttachment(cRef, cachedNetConf, hostIface.Name, newResult); err != nil {
+				return err
+			}
+		}
+		result = newResult
+		prependHostIface(result, hostIface, ipIfName)
+	}
+
+	if len(netconf.VlanTranslation) > 0 {
+		if err = setupVlanTranslation(ovsBridgeDriver, cRef, cachedNetConf, hostIface.Name, result.Interfaces[1].Mac); err != nil {
+			if err := teardownVlanTranslation(cachedNetConf); err != nil {
+				log.Printf("Failed best-effort cleanup of VLAN translation: %v", err)
+			}
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if err := teardownVlanTranslation(cachedNetConf); err != nil {
+					log.Printf("Failed best-effort cleanup of VLAN translation: %v", err)
+				}
+			}
+		}()
+	}
+
+	if netconf.Backup != nil {
+		var backupHostIface, backupContIface *current.Interface
+		backupHostIface, backupContIface, err = setupBackup(args, netconf, contNetns, vlanTagNum, trunks, portType, contPodUid, result)
 		if err != nil {
 			return err
 		}
+		defer func() {
+			if err != nil {
+				if err := delBackup(netconf, args.Netns, args.IfName, backupHostIface.Name); err != nil {
+					log.Printf("Failed best-effort cleanup of backup interface: %v", err)
+				}
+			}
+		}()
+		cachedNetConf.BackupPort = backupHostIface.Name
+		if err = utils.SaveCache(cRef, cachedNetConf); err != nil {
+			return fmt.Errorf("error saving NetConf %q", err)
+		}
+		result.Interfaces = append(result.Interfaces, backupHostIface, backupContIface)
+	}
+
+	if hasUserspaceIPAM(netconf, userspaceMode) {
+		if err = addUserspaceIPAM(ovsBridgeDriver, netconf, args, contNetns, ipamStdinData, vlanTagNum, trunks, portType, contPodUid, result); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if err := delUserspaceIPAM(ovsBridgeDriver, netconf, args, ipamStdinData); err != nil {
+					log.Printf("Failed best-effort cleanup: %v", err)
+				}
+			}
+		}()
+	}
+
+	if err = runPostAddHook(netconf, args, envArgs, result); err != nil {
+		return err
+	}
+
+	if netconf.Probe != nil && !userspaceMode {
+		runProbe(netconf.Probe, contNetns, args.IfName, result)
+	}
+	status := networkStatus(netconf, args.IfName, vlanTagNum, result)
+	if err = saveDeviceInfo(netconf, args.ContainerID, args.IfName, status.DeviceInfo); err != nil {
+		return err
+	}
+	publishNetworkStatus(netconf, envArgs, args.IfName, status)
+
+	return cnitypes.PrintResult(result, netconf.CNIVersion)
+}
+
+// resolveAddBridge selects the bridge of the attachment and verifies that its
+// VFs are connected to it. The bridge is saved to netconf, so it is cached
+// and CmdDel uses it. The selection is returned only when the bridge is not
+// the configured one.
+func resolveAddBridge(ovsDriver *ovsdb.OvsDriver, netconf *types.NetConf, ovnPort string) (*types.BridgeSelection, error) {
+	bridgeSelection, err := selectBridge(ovsDriver, netconf.BrName, ovnPort, netconf.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	bridgeName := bridgeSelection.Bridge
+	if bridgeSelection.Reason != bridgeSelectedByConfig {
+		log.Printf("Info: %s", describeBridgeSelection(bridgeSelection))
+	} else {
+		// uplinks of a bridge in another OVSDB are not known
+		if sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) && netconf.BridgeSocketFile == "" {
+			if err := verifyDeviceBridge(ovsDriver, bridgeName, netconf.DeviceID); err != nil {
+				return nil, err
+			}
+		}
+		bridgeSelection = nil
+	}
+	// the other VFs of a bonded attachment share the bridge of the first
+	if isBondedVFMode(netconf) && netconf.BridgeSocketFile == "" {
+		for _, deviceID := range bondedDeviceIDs(netconf)[1:] {
+			if err := verifyDeviceBridge(ovsDriver, bridgeName, deviceID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	netconf.BrName = bridgeName
+	return bridgeSelection, nil
+}
+
+// vfOrigIfNames returns the host names of the VF of the attachment, or of all
+// VFs of a bonded attachment, which are restored on DEL. A userspace driver
+// does not create a network interface for the VF on the host.
+func vfOrigIfNames(netconf *types.NetConf, userspaceMode bool) (string, []string, error) {
+	if isBondedVFMode(netconf) {
+		origIfNames, err := bondedVFOrigIfNames(netconf)
+		if err != nil {
+			return "", nil, err
+		}
+		return origIfNames[0], origIfNames, nil
+	}
+	if sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) && !userspaceMode {
+		origIfName, err := sriov.GetVFLinkName(netconf.DeviceID)
+		return origIfName, nil, err
+	}
+	return "", nil, nil
+}
+
+// setupSriovAttachment moves the VF, or all VFs of a bonded attachment, into
+// the container netns, their representors are the host interfaces
+func setupSriovAttachment(netconf *types.NetConf, contNetns ns.NetNS, containerID, ifName, mac string, userspaceMode bool) (hostIface, contIface *current.Interface, bondedHostIfaces []*current.Interface, err error) {
+	if isBondedVFMode(netconf) {
+		bondedHostIfaces, contIface, err = sriov.SetupBondedSriovInterface(contNetns, containerID, ifName, mac, netconf.MTU, bondedDeviceIDs(netconf), netconf.Representor, netconf.VFTuning)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if netconf.BridgeSocketFile != "" {
+			if err = useDPURepresentors(netconf, bondedDeviceIDs(netconf), bondedHostIfaces); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		return bondedHostIfaces[0], contIface, bondedHostIfaces, nil
+	}
 
-		if len(newResult.IPs) == 0 {
-			return errors.New("IPAM plugin returned missing IP config")
+	if userspaceMode && netconf.VFTuning != nil {
+		log.Printf("Warning: vf_tuning is ignored, VF %s is bound to a userspace driver", netconf.DeviceID)
+	}
+	hostIface, contIface, err = sriov.SetupSriovInterface(contNetns, containerID, ifName, mac, netconf.MTU, netconf.DeviceID, netconf.Representor, netconf.VFTuning, userspaceMode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if netconf.BridgeSocketFile != "" {
+		if err = useDPURepresentors(netconf, []string{netconf.DeviceID}, []*current.Interface{hostIface}); err != nil {
+			return nil, nil, nil, err
 		}
+	}
+	return hostIface, contIface, nil, nil
+}
 
-		newResult.Interfaces = []*current.Interface{contIface}
-		newResult.Interfaces[0].Mac = contIface.Mac
+// setupVethAttachment creates the container interface and its host side
+// according to the mode of the attachment, i.e. a veth pair, an internal
+// port, a tap or a veth pair with the host side in the infra netns. With
+// preserve_addresses the interface kept in the container netns is reused.
+func setupVethAttachment(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, contNetns ns.NetNS, containerID, ifName string, pod podIdentity, mac string, macPrefix net.HardwareAddr, vlanTag uint, trunks []uint, portType, ovnPort, contPodUid string) (*current.Interface, *current.Interface, error) {
+	// an interface kept in the container netns keeps its addresses
+	if netconf.PreserveAddresses {
+		hostIface, contIface, err := reusableContIface(ovsDriver, contNetns, ifName, netconf.Name)
+		if err != nil || contIface != nil {
+			return hostIface, contIface, err
+		}
+	}
+	if err := removeStaleAttachment(ovsDriver, netconf, contNetns, containerID, ifName); err != nil {
+		return nil, nil, err
+	}
 
-		for _, ipc := range newResult.IPs {
-			// All addresses apply to the container interface
-			ipc.Interface = current.Int(0)
+	// MAC address derived from IP address replaces the random one later
+	vethMac := mac
+	if vethMac == "" && macPrefix != nil {
+		var err error
+		if vethMac, err = randomHWAddr(macPrefix); err != nil {
+			return nil, nil, err
 		}
+	}
+	// random name is used when the pod is not known
+	var hostIfaceName string
+	if portNameTemplate(netconf) != "" {
+		var err error
+		if hostIfaceName, err = templatePortName(ovsDriver, netconf, containerID, pod); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case isInternalPortMode(netconf):
+		return setupInternalPort(ovsDriver, netconf, contNetns, ifName, hostIfaceName, vethMac, vlanTag, trunks, portType, ovnPort, contPodUid, containerID)
+	case isTapMode(netconf):
+		return setupTapAttachment(ovsDriver, contNetns, ifName, netconf.Name, containerID, hostIfaceName, vethMac, netconf.Tap, netconf.MTU)
+	case isInfraNetnsMode(netconf):
+		return setupInfraVeth(contNetns, netconf.InfraNetns, ifName, containerID, hostIfaceName, vethMac, netconf.MTU)
+	default:
+		return setupVeth(contNetns, ifName, hostIfaceName, vethMac, netconf.MTU)
+	}
+}
 
-		// wait until OF port link state becomes up. This is needed to make
-		// gratuitous arp for args.IfName to be sent over ovs bridge
-		err = waitLinkUp(ovsBridgeDriver, hostIface.Name, netconf.LinkStateCheckRetries, netconf.LinkStateCheckInterval)
+// removeStaleAttachment removes leftovers of a previous ADD of the attachment
+// which DEL didn't remove
+func removeStaleAttachment(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, contNetns ns.NetNS, containerID, ifName string) error {
+	switch {
+	case isInternalPortMode(netconf):
+		return removeStaleInternalPort(ovsDriver, contNetns, ifName, netconf.Name)
+	case isTapMode(netconf):
+		// leftovers are removed when the tap attachment is set up
+		return nil
+	case isInfraNetnsMode(netconf):
+		return removeStaleInfraVeth(ovsDriver, contNetns, netconf.InfraNetns, containerID, ifName, netconf.Name)
+	default:
+		return removeStaleContIface(ovsDriver, contNetns, ifName, netconf.Name)
+	}
+}
+
+// tuneAttachment applies offload settings, altnames and RA sysctls of the
+// netconf to the interfaces of the attachment
+func tuneAttachment(netconf *types.NetConf, contNetns ns.NetNS, hostIface, contIface *current.Interface) error {
+	if netconf.Offload != nil {
+		// an internal port has no interface left on the host, the representor
+		// attached to a DPU-hosted bridge is on the DPU
+		if !isInternalPortMode(netconf) && netconf.BridgeSocketFile == "" {
+			if err := setOffload(hostIface.Name, netconf.Offload); err != nil {
+				return err
+			}
+		}
+		err := netns.Do(contNetns, func(_ ns.NetNS) error {
+			return setOffload(contIface.Name, netconf.Offload)
+		})
 		if err != nil {
 			return err
 		}
+	}
 
-		err = contNetns.Do(func(_ ns.NetNS) error {
-			if mac == "" && !sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID) && len(newResult.IPs) >= 1 {
-				containerMac := IPAddrToHWAddr(newResult.IPs[0].Address.IP)
-				containerLink, err := netlink.LinkByName(args.IfName)
+	if len(netconf.AltNames) > 0 {
+		err := netns.Do(contNetns, func(_ ns.NetNS) error {
+			return addAltNames(contIface.Name, netconf.AltNames)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if netconf.AcceptRA != nil || netconf.IPv6Autoconf != nil {
+		return netns.Do(contNetns, func(_ ns.NetNS) error {
+			return setRASysctls(netconf, contIface.Name)
+		})
+	}
+	return nil
+}
+
+// removeAttachedPorts removes OVS ports of a failed ADD, unlike the veth pair
+// they are not removed along with the netns
+func removeAttachedPorts(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, contNetnsPath, ifName string) {
+	portName, portFound, err := getOvsPortForContIface(ovsDriver, ifName, contNetnsPath, netconf.Name)
+	if err != nil {
+		log.Printf("Failed best-effort cleanup: %v", err)
+	}
+	if portFound {
+		stopCapture(ovsDriver, netconf, portName)
+		teardownRateLimit(ovsDriver, netconf, portName)
+		if err := removeOvsPort(ovsDriver, portName); err != nil {
+			log.Printf("Failed best-effort cleanup: %v", err)
+		}
+	}
+	if isBondedVFMode(netconf) {
+		if err := removeBondedVFPorts(ovsDriver, netconf); err != nil {
+			log.Printf("Failed best-effort cleanup: %v", err)
+		}
+	}
+}
+
+// addIPAM gets addresses of the attachment from the IPAM plugins and
+// configures them in the container once the OF port is up, the interfaces of
+// the returned result are the container ones
+func addIPAM(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, ipamPlugins []ipamPlugin, contNetns ns.NetNS, port ovsdb.PortOptions, contIface, bondIface *current.Interface, contIfName, ipIfName, mac string, macPrefix net.HardwareAddr) (*current.Result, error) {
+	result, err := execIPAMAdd(ipamPlugins)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareIPAMResult(netconf, result, contIface, bondIface); err != nil {
+		return nil, err
+	}
+
+	// wait until OF port link state becomes up. This is needed to make
+	// gratuitous arp for contIfName to be sent over ovs bridge
+	if err := waitPortUp(ovsDriver, netconf, port.Name, restartPort(ovsDriver, netconf, contNetns, contIfName, port)); err != nil {
+		return nil, newError(cnitypes.ErrTryAgainLater, err)
+	}
+
+	return configureIPsWithReallocation(netconf, ipamPlugins, contNetns, result, contIface, bondIface, ipIfName, contIfName, mac, macPrefix, port.ContPodUID)
+}
+
+// restartPort returns the retry of waitPortUp, which attaches the port to the
+// bridge again
+func restartPort(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, contNetns ns.NetNS, contIfName string, port ovsdb.PortOptions) func() error {
+	return func() error {
+		if isInternalPortMode(netconf) {
+			// removing the port would destroy the container interface
+			// along with its offload settings, altnames, sysctls and
+			// bond membership, so only bring it up again
+			return netns.Do(contNetns, func(_ ns.NetNS) error {
+				link, err := netif.Default.LinkByName(contIfName)
 				if err != nil {
-					return fmt.Errorf("failed to lookup container interface %q: %v", args.IfName, err)
+					return err
 				}
-				err = assignMacToLink(containerLink, containerMac, args.IfName)
-				if err != nil {
+				if err := netif.Default.LinkSetDown(link); err != nil {
 					return err
 				}
-				newResult.Interfaces[0].Mac = containerMac.String()
-			}
-			err := ipam.ConfigureIface(args.IfName, newResult)
-			if err != nil {
-				return err
+				return netif.Default.LinkSetUp(link)
+			})
+		}
+		if err := removeOvsPort(ovsDriver, port.Name); err != nil {
+			return err
+		}
+		if err := attachPortToBridge(ovsDriver, netconf, port); err != nil {
+			return err
+		}
+		return setupRateLimit(ovsDriver, netconf, port.Name)
+	}
+}
+
+// prependHostIface puts the host interface in front of the interfaces of the
+// IPAM result and points its IPs to the interface they are configured on
+func prependHostIface(result *current.Result, hostIface *current.Interface, ipIfName string) {
+	result.Interfaces = append([]*current.Interface{hostIface}, result.Interfaces...)
+	for ifIndex, ifCfg := range result.Interfaces {
+		if ifCfg.Name == ipIfName {
+			for ipIndex := range result.IPs {
+				result.IPs[ipIndex].Interface = current.Int(ifIndex)
 			}
-			contVeth, err := net.InterfaceByName(args.IfName)
-			if err != nil {
-				return fmt.Errorf("failed to look up %q: %v", args.IfName, err)
-			}
-			for _, ipc := range newResult.IPs {
-				// if ip address version is 4
-				if ipc.Address.IP.To4() != nil {
-					// send gratuitous arp for other ends to refresh its arp cache
-					err = arping.GratuitousArpOverIface(ipc.Address.IP, *contVeth)
-					if err != nil {
-						// ok to ignore returning this error
-						log.Printf("error sending garp for ip %s: %v", ipc.Address.IP.String(), err)
-					}
-				}
+		}
+	}
+}
+
+// waitPortUp waits for the OF port to come up and handles a port which
+// never does according to link_state_policy. retry recreates or restarts the
+// port.
+func waitPortUp(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, ofPortName string, retry func() error) error {
+	err := waitLinkUp(ovsDriver, ofPortName, netconf.LinkStateCheckRetries, netconf.LinkStateCheckInterval)
+	if err == nil {
+		return nil
+	}
+	switch netconf.LinkStatePolicy {
+	case config.LinkStatePolicyWarn:
+		log.Printf("Warning: %v, continuing as configured by link_state_policy", err)
+		return nil
+	case config.LinkStatePolicyRetry:
+		log.Printf("Warning: %v, retrying", err)
+		if err := retry(); err != nil {
+			return fmt.Errorf("failed to retry port %s: %v", ofPortName, err)
+		}
+		return waitLinkUp(ovsDriver, ofPortName, netconf.LinkStateCheckRetries, netconf.LinkStateCheckInterval)
+	}
+	return err
+}
+
+func waitLinkUp(ovsDriver *ovsdb.OvsBridgeDriver, ofPortName string, retryCount, interval int) error {
+	checkInterval := time.Duration(interval) * time.Millisecond
+	for i := 1; i <= retryCount; i++ {
+		portState, err := ovsDriver.GetOFPortOpState(ofPortName)
+		if err != nil {
+			log.Printf("error in retrieving port %s state: %v", ofPortName, err)
+		} else {
+			if portState == "up" {
+				break
 			}
-			return nil
-		})
+		}
+		if i == retryCount {
+			return fmt.Errorf("The OF port %s state is not up, try increasing number of retries/interval config parameter", ofPortName)
+		}
+		time.Sleep(checkInterval)
+	}
+	return nil
+}
+
+func getOvsPortForContIface(ovsDriver *ovsdb.OvsBridgeDriver, contIface string, contNetnsPath string, contNetwork string) (string, bool, error) {
+	// External IDs were set on the port during ADD call.
+	return ovsDriver.GetOvsPortForContIface(contIface, contNetnsPath, contNetwork)
+}
+
+// cleanPorts removes all ports whose interfaces have an error.
+func cleanPorts(ovsDriver *ovsdb.OvsBridgeDriver) error {
+	// scanning all interfaces is optional, ports of others can't be removed
+	// by a restricted client anyway
+	if ovsDriver.LeastPrivilege {
+		return nil
+	}
+	ifaces, err := ovsDriver.FindInterfacesWithError()
+	if err != nil {
+		return fmt.Errorf("clean ports: %v", err)
+	}
+	// the same interfaces may stay in error for a long time, e.g. after
+	// OVS upgrade, don't retry and log their removal on every call
+	backoff, err := utils.LoadBackoff(cleanPortsBackoffKey, cleanPortsBackoffBase, cleanPortsBackoffMax)
+	if err != nil {
+		return fmt.Errorf("clean ports: %v", err)
+	}
+	defer backoff.Release()
+	now := time.Now()
+	changed := false
+	skipped := 0
+	for _, iface := range ifaces {
+		if !backoff.Has(iface) {
+			changed = true
+		}
+		if !backoff.Allow(iface, now) {
+			skipped++
+			continue
+		}
+		attempts := backoff.Record(iface, now)
+		log.Printf("Info: interface %s has error: removing corresponding port (attempt %d)", iface, attempts)
+		if err := ovsDriver.DeletePort(iface); errors.Is(err, ovsdb.ErrNotOwned) {
+			log.Printf("Info: %v, keeping it, set force_port_removal to remove it", err)
+		} else if err != nil {
+			// Don't return an error here, just log its occurrence.
+			// Something else may have removed the port already.
+			log.Printf("Error: %v\n", err)
+		}
+	}
+	if backoff.Retain(ifaces) > 0 {
+		changed = true
+	}
+	if changed {
+		log.Printf("Info: found %d interfaces with error, removal of %d ports is retried later", len(ifaces), skipped)
+	}
+	if err := backoff.Save(); err != nil {
+		log.Printf("Error: %v\n", err)
+	}
+
+	expiredMirrors, err := ovsDriver.FindExpiredCaptureMirrors(now)
+	if err != nil {
+		return fmt.Errorf("clean ports: %v", err)
+	}
+	for _, mirror := range expiredMirrors {
+		log.Printf("Info: capture mirror %s expired: removing it", mirror)
+		if err := ovsDriver.DeleteMirror(ovsDriver.OvsBridgeName, mirror); err != nil {
+			log.Printf("Error: %v\n", err)
+		}
+	}
+
+	expiredPorts, err := ovsDriver.FindExpiredQuarantinedPorts(now)
+	if err != nil {
+		return fmt.Errorf("clean ports: %v", err)
+	}
+	for _, port := range expiredPorts {
+		log.Printf("Info: quarantine of port %s expired: removing it", port)
+		if err := ovsDriver.DeletePort(port); err != nil {
+			log.Printf("Error: %v\n", err)
+		}
+		// removing host side of the veth removes its peer as well
+		if err := netif.Default.DelLinkByName(port); err != nil && err != ip.ErrLinkNotFound {
+			log.Printf("Error: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// quarantineIfNames returns names of host and peer interfaces of a quarantined
+// veth pair, derived from a hash of the whole port name so templated names
+// sharing a prefix don't collide
+func quarantineIfNames(portName string) (string, string) {
+	hash := sha256.Sum256([]byte(portName))
+	suffix := hex.EncodeToString(hash[:])[:12]
+	return quarantinePrefix + "h" + suffix, quarantinePrefix + "c" + suffix
+}
+
+// quarantinePort moves the container side of the veth pair into the host
+// network namespace and renames both ends and the OVS port, so the state of
+// the attachment can be captured after the container is gone. Quarantined
+// ports are removed by cleanPorts once their quarantine expires. On failure
+// the veth pair is restored, so DEL can remove it as usual.
+func quarantinePort(ovsDriver *ovsdb.OvsBridgeDriver, portName string, args *skel.CmdArgs, timeout int) (err error) {
+	hostName, peerName := quarantineIfNames(portName)
+
+	err = netns.WithPath(args.Netns, func(hostNetns ns.NetNS) error {
+		contLink, err := netif.Default.LinkByName(args.IfName)
 		if err != nil {
 			return err
 		}
-		result = newResult
-		result.Interfaces = []*current.Interface{hostIface, result.Interfaces[0]}
-
-		for ifIndex, ifCfg := range result.Interfaces {
-			// Adjust interface index with new container interface index in result.Interfaces
-			if ifCfg.Name == args.IfName {
-				for ipIndex := range result.IPs {
-					result.IPs[ipIndex].Interface = current.Int(ifIndex)
-				}
+		if err = netif.Default.LinkSetDown(contLink); err != nil {
+			return err
+		}
+		if err = netif.Default.LinkSetName(contLink, peerName); err != nil {
+			return err
+		}
+		if err = netif.Default.LinkSetNs(contLink, hostNetns); err != nil {
+			if err := netif.Default.LinkSetName(contLink, args.IfName); err != nil {
+				log.Printf("Failed to restore name of container iface %s: %v", args.IfName, err)
 			}
+			return err
 		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move container iface %s to host netns: %v", args.IfName, err)
+	}
+	defer func() {
+		if err != nil {
+			if err := restoreContIface(peerName, args); err != nil {
+				log.Printf("Failed to restore container iface %s of quarantined port %s: %v", args.IfName, portName, err)
+			}
+		}
+	}()
+	if err = setInterfaceUp(peerName); err != nil {
+		return err
+	}
+
+	hostLink, err := netif.Default.LinkByName(portName)
+	if err != nil {
+		return err
+	}
+	if err = netif.Default.LinkSetDown(hostLink); err != nil {
+		return err
+	}
+	if err = netif.Default.LinkSetName(hostLink, hostName); err != nil {
+		return err
 	}
-
-	return cnitypes.PrintResult(result, netconf.CNIVersion)
-}
-
-func waitLinkUp(ovsDriver *ovsdb.OvsBridgeDriver, ofPortName string, retryCount, interval int) error {
-	checkInterval := time.Duration(interval) * time.Millisecond
-	for i := 1; i <= retryCount; i++ {
-		portState, err := ovsDriver.GetOFPortOpState(ofPortName)
+	defer func() {
 		if err != nil {
-			log.Printf("error in retrieving port %s state: %v", ofPortName, err)
-		} else {
-			if portState == "up" {
-				break
+			if err := renameLink(hostName, portName); err != nil {
+				log.Printf("Failed to restore name of host iface %s of quarantined port: %v", portName, err)
 			}
 		}
-		if i == retryCount {
-			return fmt.Errorf("The OF port %s state is not up, try increasing number of retries/interval config parameter", ofPortName)
-		}
-		time.Sleep(checkInterval)
+	}()
+	if err = setInterfaceUp(hostName); err != nil {
+		return err
 	}
+
+	expiry := time.Now().Add(time.Duration(timeout) * time.Second)
+	if err = ovsDriver.QuarantinePort(portName, hostName, expiry); err != nil {
+		return err
+	}
+	log.Printf("Info: port %s quarantined as %s (peer %s) until %s", portName, hostName, peerName, expiry.Format(time.RFC3339))
 	return nil
 }
 
-func getOvsPortForContIface(ovsDriver *ovsdb.OvsBridgeDriver, contIface string, contNetnsPath string) (string, bool, error) {
-	// External IDs were set on the port during ADD call.
-	return ovsDriver.GetOvsPortForContIface(contIface, contNetnsPath)
+// restoreContIface moves the peer of a quarantined veth pair back into the
+// container netns under its original name
+func restoreContIface(peerName string, args *skel.CmdArgs) error {
+	contNetns, err := netns.Get(args.Netns)
+	if err != nil {
+		return err
+	}
+	defer contNetns.Close()
+	link, err := netif.Default.LinkByName(peerName)
+	if err != nil {
+		return err
+	}
+	if err = netif.Default.LinkSetDown(link); err != nil {
+		return err
+	}
+	if err = netif.Default.LinkSetNs(link, contNetns); err != nil {
+		return err
+	}
+	return netns.Do(contNetns, func(ns.NetNS) error {
+		return renameLink(peerName, args.IfName)
+	})
 }
 
-// cleanPorts removes all ports whose interfaces have an error.
-func cleanPorts(ovsDriver *ovsdb.OvsBridgeDriver) error {
-	ifaces, err := ovsDriver.FindInterfacesWithError()
+// renameLink renames the interface, bringing it down for the rename and up again
+func renameLink(name, newName string) error {
+	link, err := netif.Default.LinkByName(name)
 	if err != nil {
-		return fmt.Errorf("clean ports: %v", err)
+		return err
 	}
-	for _, iface := range ifaces {
-		log.Printf("Info: interface %s has error: removing corresponding port", iface)
-		if err := ovsDriver.DeletePort(iface); err != nil {
-			// Don't return an error here, just log its occurrence.
-			// Something else may have removed the port already.
-			log.Printf("Error: %v\n", err)
-		}
+	if err = netif.Default.LinkSetDown(link); err != nil {
+		return err
 	}
-	return nil
+	if err = netif.Default.LinkSetName(link, newName); err != nil {
+		return err
+	}
+	return setInterfaceUp(newName)
 }
 
 func removeOvsPort(ovsDriver *ovsdb.OvsBridgeDriver, portName string) error {
@@ -524,12 +1416,111 @@ func removeOvsPort(ovsDriver *ovsdb.OvsBridgeDriver, portName string) error {
 	return ovsDriver.DeletePort(portName)
 }
 
+// delBridgeDriver connects to the bridge of the attachment on DEL, retrying
+// as configured by del_bridge_retries while OVSDB or the bridge are missing
+func delBridgeDriver(netconf *types.NetConf, ovnPort string) (*ovsdb.OvsBridgeDriver, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var ovsDriver *ovsdb.OvsDriver
+		var bridgeName string
+		var ovsBridgeDriver *ovsdb.OvsBridgeDriver
+		ovsDriver, err = ovsdb.NewOvsDriver(netconf.SocketFile)
+		if err == nil {
+			bridgeName, err = getBridgeName(ovsDriver, netconf.BrName, ovnPort, netconf.DeviceID)
+		}
+		if err == nil {
+			ovsBridgeDriver, err = newBridgeDriver(bridgeName, netconf)
+		}
+		if err == nil {
+			return ovsBridgeDriver, nil
+		}
+		if attempt >= netconf.DelBridgeRetries {
+			return nil, err
+		}
+		time.Sleep(time.Duration(netconf.DelBridgeRetryInterval) * time.Millisecond)
+	}
+}
+
+// delWithoutBridge removes what it can of an attachment whose bridge is not
+// available: IP addresses and the container side of the attachment
+func delWithoutBridge(args *skel.CmdArgs, cache *types.CachedNetConf) error {
+	if isVhostUserMode(cache.Netconf) {
+		return os.RemoveAll(existingVhostUserSocketDir(cache.Netconf, args.ContainerID, args.IfName))
+	}
+	if cache.Netconf.IPAM.Type != "" {
+		if err := setupIPAMEnv(cache.Netconf); err != nil {
+			return err
+		}
+		ipamPlugins, err := ipamPluginsOf(cache.Netconf, ipamDelStdinData(args.StdinData, cache))
+		if err != nil {
+			return err
+		}
+		if err := execIPAMDel(ipamPlugins); err != nil {
+			return err
+		}
+	}
+	if isRoutedMode(cache.Netconf) {
+		// flows are gone with the bridge, host routes are removed anyway
+		if err := teardownRoutedPort(cache.Netconf.BrName, args.ContainerID, args.IfName, cache.RoutedIPs); err != nil {
+			log.Printf("Failed best-effort cleanup of routed attachment: %v", err)
+		}
+	}
+	if cache.VlanTranslationPort != "" {
+		// OVSDB of the bridge may be unreachable while its flows are not
+		if err := teardownVlanTranslation(cache); err != nil {
+			log.Printf("Failed best-effort cleanup of VLAN translation: %v", err)
+		}
+	}
+	if isInfraNetnsMode(cache.Netconf) {
+		if err := delInfraVeth(cache.Netconf.InfraNetns, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
+	}
+	if args.Netns == "" {
+		return nil
+	}
+
+	if sriov.IsOvsHardwareOffloadEnabled(cache.Netconf.DeviceID) {
+		// there is no network interface in case of userspace driver, so OrigIfName is empty
+		if cache.UserspaceMode {
+			return nil
+		}
+		if len(cache.Netconf.AltNames) > 0 {
+			delAltNames(args.Netns, args.IfName, cache.Netconf.AltNames)
+		}
+		if isBondedVFMode(cache.Netconf) {
+			if err := releaseBondedVFs(args, cache); err != nil {
+				log.Printf("Failed best-effort release of bonded VFs: %v", err)
+			}
+		} else if err := sriov.ReleaseVF(args, cache.OrigIfName); err != nil {
+			log.Printf("Failed best-effort release of VF %s: %v", cache.OrigIfName, err)
+		}
+		restoreVFTuning(cache)
+		return nil
+	}
+	// removing container side of the veth removes the host side as well
+	err := netns.WithPath(args.Netns, func(ns.NetNS) error {
+		return netif.Default.DelLinkByName(args.IfName)
+	})
+	if netns.IsGone(err) || err == ip.ErrLinkNotFound {
+		return nil
+	}
+	return err
+}
+
 // CmdDel remove handler for deleting container from network
 func CmdDel(args *skel.CmdArgs) error {
 	logCall("DEL", args)
 
-	cRef := config.GetCRef(args.ContainerID, args.IfName)
-	cache, err := config.LoadConfFromCache(cRef)
+	// DEL must succeed for netconfs ADD rejected, the IPAM plugin gets the
+	// netconf as is then
+	if stdinData, err := config.ExpandStaticIPAM(args.StdinData); err == nil {
+		args.StdinData = stdinData
+	}
+
+	forgetCheck(config.GetNetworkCRef(config.GetNetworkName(args.StdinData), args.ContainerID, args.IfName))
+
+	cache, cRef, err := config.LoadNetworkConfFromCache(config.GetNetworkName(args.StdinData), args.ContainerID, args.IfName)
 	if err != nil {
 		// If cmdDel() fails, cached netconf is cleaned up by
 		// the followed defer call. However, subsequence calls
@@ -549,32 +1540,87 @@ func CmdDel(args *skel.CmdArgs) error {
 		}
 	}()
 
+	if cache.Delegated {
+		err = delDelegated(cache.Netconf)
+		return err
+	}
+
 	envArgs, err := getEnvArgs(args.Args)
 	if err != nil {
-		return err
+		return newError(cnitypes.ErrInvalidEnvironmentVariables, err)
+	}
+
+	if !isVhostUserMode(cache.Netconf) {
+		runPreDelHook(cache.Netconf, args, envArgs)
 	}
+	publishNetworkStatus(cache.Netconf, envArgs, args.IfName, nil)
+	cleanDeviceInfo(cache.Netconf, args.ContainerID, args.IfName)
+	cleanCheckReport(cache.Netconf, args.ContainerID, args.IfName)
 
 	var ovnPort string
 	if envArgs != nil {
 		ovnPort = string(envArgs.OvnPort)
 	}
-	ovsDriver, err := ovsdb.NewOvsDriver(cache.Netconf.SocketFile)
+	ovsBridgeDriver, err := delBridgeDriver(cache.Netconf, ovnPort)
 	if err != nil {
+		if cache.Netconf.DelBridgeRetries == 0 {
+			return newError(cnitypes.ErrTryAgainLater, err)
+		}
+		// the bridge may be gone for a long time, e.g. during OVS upgrade,
+		// don't block pod teardown. Its stale port is removed by a later
+		// ADD or DEL once the bridge is back.
+		log.Printf("Warning: bridge of the attachment is not available, removing it without OVS: %v", err)
+		err = delWithoutBridge(args, cache)
 		return err
 	}
-	bridgeName, err := getBridgeName(ovsDriver, cache.Netconf.BrName, ovnPort, cache.Netconf.DeviceID)
-	if err != nil {
+
+	if isVhostUserMode(cache.Netconf) {
+		err = delVhostUser(ovsBridgeDriver, args.ContainerID, args.IfName, cache.Netconf)
 		return err
 	}
 
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridgeName, cache.Netconf.SocketFile)
-	if err != nil {
-		return err
+	if cache.Netconf.Backup != nil {
+		if err = delBackup(cache.Netconf, args.Netns, args.IfName, cache.BackupPort); err != nil {
+			return err
+		}
+	}
+	if cache.Netconf.Bond != nil {
+		if err = leaveBond(args.Netns, args.ContainerID, args.IfName, cache.Netconf.Bond); err != nil {
+			return err
+		}
+	}
+	if isTapMode(cache.Netconf) && args.Netns != "" {
+		if err = delTapVeth(args.Netns, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
 	}
 
 	if cache.Netconf.IPAM.Type != "" {
-		err = ipam.ExecDel(cache.Netconf.IPAM.Type, args.StdinData)
-		if err != nil {
+		if err = setupIPAMEnv(cache.Netconf); err != nil {
+			return err
+		}
+		var ipamPlugins []ipamPlugin
+		if ipamPlugins, err = ipamPluginsOf(cache.Netconf, ipamDelStdinData(args.StdinData, cache)); err != nil {
+			return err
+		}
+		if err = execIPAMDel(ipamPlugins); err != nil {
+			return err
+		}
+	}
+
+	if hasUserspaceIPAM(cache.Netconf, cache.UserspaceMode) {
+		if err = delUserspaceIPAMPort(ovsBridgeDriver, cachedUserspaceIPAMPort(cache)); err != nil {
+			return err
+		}
+	}
+
+	if isRoutedMode(cache.Netconf) {
+		if err = teardownRoutedPort(cache.Netconf.BrName, args.ContainerID, args.IfName, cache.RoutedIPs); err != nil {
+			return err
+		}
+	}
+	if cache.VlanTranslationPort != "" {
+		if err = teardownVlanTranslation(cache); err != nil {
 			return err
 		}
 	}
@@ -586,21 +1632,45 @@ func CmdDel(args *skel.CmdArgs) error {
 			// SR-IOV Case - The sriov device is moved into host network namespace when args.Netns is empty.
 			// This happens container is killed due to an error (example: CrashLoopBackOff, OOMKilled)
 			var rep string
-			if rep, err = sriov.GetNetRepresentor(cache.Netconf.DeviceID); err != nil {
+			if rep, err = representorPortName(cache.Netconf, cache.Netconf.DeviceID); err != nil {
 				return err
 			}
+			recordStats(ovsBridgeDriver, cache.Netconf, args, envArgs, rep)
+			teardownRateLimit(ovsBridgeDriver, cache.Netconf, rep)
 			if err = removeOvsPort(ovsBridgeDriver, rep); err != nil {
 				// Don't throw err as delete can be called multiple times because of error in ResetVF and ovs
 				// port is already deleted in a previous invocation.
 				log.Printf("Error: %v\n", err)
 			}
+			if isBondedVFMode(cache.Netconf) {
+				if err = removeBondedVFPorts(ovsBridgeDriver, cache.Netconf); err != nil {
+					log.Printf("Error: %v\n", err)
+				}
+				if err = resetBondedVFs(args, cache); err != nil {
+					return err
+				}
+				restoreVFTuning(cache)
+				return nil
+			}
 			// there is no network interface in case of userspace driver, so OrigIfName is empty
 			if !cache.UserspaceMode {
+				// the VF came back to the host netns with its altnames
+				if len(cache.Netconf.AltNames) > 0 {
+					delVFAltNames(cache.Netconf.DeviceID, cache.Netconf.AltNames)
+				}
 				if err = sriov.ResetVF(args, cache.Netconf.DeviceID, cache.OrigIfName); err != nil {
 					return err
 				}
+				restoreVFTuning(cache)
 			}
 		} else {
+			// the port of the attachment turns into error once its end in
+			// the host netns is gone
+			if isInfraNetnsMode(cache.Netconf) {
+				if err = delInfraVeth(cache.Netconf.InfraNetns, args.ContainerID, args.IfName); err != nil {
+					return err
+				}
+			}
 			// In accordance with the spec we clean up as many resources as possible.
 			if err := cleanPorts(ovsBridgeDriver); err != nil {
 				return err
@@ -612,7 +1682,7 @@ func CmdDel(args *skel.CmdArgs) error {
 	// Unlike veth pair, OVS port will not be automatically removed when
 	// container namespace is gone. Find port matching DEL arguments and remove
 	// it explicitly.
-	portName, portFound, err := getOvsPortForContIface(ovsBridgeDriver, args.IfName, args.Netns)
+	portName, portFound, err := getOvsPortForContIface(ovsBridgeDriver, args.IfName, args.Netns, cache.Netconf.Name)
 	if err != nil {
 		return fmt.Errorf("Failed to obtain OVS port for given connection: %v", err)
 	}
@@ -620,32 +1690,65 @@ func CmdDel(args *skel.CmdArgs) error {
 	// Do not return an error if the port was not found, it may have been
 	// already removed by someone.
 	if portFound {
+		recordStats(ovsBridgeDriver, cache.Netconf, args, envArgs, portName)
+		stopCapture(ovsBridgeDriver, cache.Netconf, portName)
+		teardownRateLimit(ovsBridgeDriver, cache.Netconf, portName)
+		// Keep the host side of the attachment for inspection if requested,
+		// fall back to regular removal if that's not possible.
+		if cache.Netconf.RetainOnDelete && !ovsBridgeDriver.LeastPrivilege && !sriov.IsOvsHardwareOffloadEnabled(cache.Netconf.DeviceID) {
+			if err = quarantinePort(ovsBridgeDriver, portName, args, cache.Netconf.RetainOnDeleteTimeout); err == nil {
+				return cleanPorts(ovsBridgeDriver)
+			}
+			log.Printf("Failed to quarantine port %s, removing it: %v", portName, err)
+			err = nil
+		}
 		if err := removeOvsPort(ovsBridgeDriver, portName); err != nil {
 			return err
 		}
+	} else if cache.UserspaceMode {
+		recordRepresentorStats(ovsBridgeDriver, cache.Netconf, args, envArgs)
+	}
+	if isBondedVFMode(cache.Netconf) {
+		if err := removeBondedVFPorts(ovsBridgeDriver, cache.Netconf); err != nil {
+			return err
+		}
 	}
 
 	if sriov.IsOvsHardwareOffloadEnabled(cache.Netconf.DeviceID) {
 		// there is no network interface in case of userspace driver, so OrigIfName is empty
 		if !cache.UserspaceMode {
-			err = sriov.ReleaseVF(args, cache.OrigIfName)
-			if err != nil {
+			if len(cache.Netconf.AltNames) > 0 {
+				delAltNames(args.Netns, args.IfName, cache.Netconf.AltNames)
+			}
+			if isBondedVFMode(cache.Netconf) {
+				err = releaseBondedVFs(args, cache)
+			} else if err = sriov.ReleaseVF(args, cache.OrigIfName); err != nil {
 				// try to reset vf into original state as much as possible in case of error
 				if err := sriov.ResetVF(args, cache.Netconf.DeviceID, cache.OrigIfName); err != nil {
 					log.Printf("Failed best-effort cleanup of VF %s: %v", cache.OrigIfName, err)
 				}
 			}
+			restoreVFTuning(cache)
 		}
 	} else {
-		err = ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
-			err = ip.DelLinkByName(args.IfName)
+		// the end of the attachment in the host netns is not removed with
+		// the container interface
+		if isInfraNetnsMode(cache.Netconf) {
+			if err = delInfraVeth(cache.Netconf.InfraNetns, args.ContainerID, args.IfName); err != nil {
+				return err
+			}
+		}
+		err = netns.WithPath(args.Netns, func(ns.NetNS) error {
+			err = netif.Default.DelLinkByName(args.IfName)
 			return err
 		})
 		// do the following as per cni spec (i.e. Plugins should generally complete a DEL action
 		// without error even if some resources are missing)
-		if _, ok := err.(ns.NSPathNotExistErr); ok || err == ip.ErrLinkNotFound {
-			if portFound {
-				if err := ip.DelLinkByName(portName); err != nil {
+		if netns.IsGone(err) || err == ip.ErrLinkNotFound {
+			// the interface of an internal port is removed with the port,
+			// the one of an infra netns attachment with its infra veth
+			if portFound && !isInternalPortMode(cache.Netconf) && !isInfraNetnsMode(cache.Netconf) {
+				if err := netif.Default.DelLinkByName(portName); err != nil {
 					log.Printf("Failed best-effort cleanup of %s: %v", portName, err)
 				}
 			}
@@ -665,41 +1768,86 @@ func CmdDel(args *skel.CmdArgs) error {
 func CmdCheck(args *skel.CmdArgs) error {
 	logCall("CHECK", args)
 
+	report := &checkReport{}
+	err := cmdCheck(args, report)
+	saveCheckReport(args, report, err)
+	return err
+}
+
+// cmdCheck checks the attachment, validated items are recorded in the report
+func cmdCheck(args *skel.CmdArgs, report *checkReport) error {
+	envArgs, err := getEnvArgs(args.Args)
+	if err != nil {
+		return newError(cnitypes.ErrInvalidEnvironmentVariables, err)
+	}
+	if args.StdinData, err = config.ExpandStaticIPAM(args.StdinData); err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
+	}
 	netconf, err := config.LoadConf(args.StdinData)
 	if err != nil {
+		return newError(cnitypes.ErrDecodingFailure, err)
+	}
+	applyEnvArgs(netconf, envArgs)
+	if err := config.Validate(netconf); err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
+	}
+	report.netconf = netconf
+	if err := resolveBridgeTemplate(netconf); err != nil {
 		return err
 	}
-	ovsHWOffloadEnable := sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID)
-
-	envArgs, err := getEnvArgs(args.Args)
-	if err != nil {
+	if degraded, err := checkDegraded(args, netconf); degraded {
 		return err
 	}
+	ovsHWOffloadEnable := sriov.IsOvsHardwareOffloadEnabled(netconf.DeviceID)
+
 	var ovnPort string
 	if envArgs != nil {
 		ovnPort = string(envArgs.OvnPort)
 	}
-	ovsDriver, err := ovsdb.NewOvsDriver(netconf.SocketFile)
-	if err != nil {
-		return err
-	}
-	// cached config may contain bridge name which were automatically
-	// discovered in CmdAdd, we need to re-discover the bridge name before we validating the cache
-	bridgeName, err := getBridgeName(ovsDriver, netconf.BrName, ovnPort, netconf.DeviceID)
-	if err != nil {
-		return err
+
+	cRef := config.GetNetworkCRef(netconf.Name, args.ContainerID, args.IfName)
+	digest := checkDigest(args.StdinData, args.Netns)
+	// OVSDB is not queried again for an attachment which passed CHECK until
+	// its port changes, IPAM and the netns are checked every time
+	ovsdbCached := checkCached(netconf, cRef, digest)
+
+	var bridgeSelection *types.BridgeSelection
+	if !ovsdbCached {
+		ovsDriver, err := ovsdb.NewOvsDriver(netconf.SocketFile)
+		if err != nil {
+			return newError(cnitypes.ErrTryAgainLater, report.check("ovsdb", err))
+		}
+		// cached config may contain bridge name which were automatically
+		// discovered in CmdAdd, we need to re-discover the bridge name before we validating the cache
+		bridgeSelection, err = selectBridge(ovsDriver, netconf.BrName, ovnPort, netconf.DeviceID)
+		if err != nil {
+			return report.check("bridge", err)
+		}
+		netconf.BrName = bridgeSelection.Bridge
+		report.check("bridge", nil)
 	}
-	netconf.BrName = bridgeName
 
 	// check cache
-	cRef := config.GetCRef(args.ContainerID, args.IfName)
-	cache, err := config.LoadConfFromCache(cRef)
+	cache, _, err := config.LoadNetworkConfFromCache(netconf.Name, args.ContainerID, args.IfName)
 	if err != nil {
-		return err
+		return newError(cnitypes.ErrUnknownContainer, report.check("cache", err))
+	}
+	if ovsdbCached {
+		// the bridge was selected by the CHECK which passed
+		netconf.BrName = cache.Netconf.BrName
+		report.check("check cache", nil)
 	}
 
 	if err := validateCache(cache, netconf); err != nil {
-		return err
+		if cache.BridgeSelection != nil && bridgeSelection != nil {
+			err = fmt.Errorf("%v (ADD: %s; now: %s)", err, describeBridgeSelection(cache.BridgeSelection), describeBridgeSelection(bridgeSelection))
+		}
+		return newError(cnitypes.ErrInvalidNetworkConfig, report.check("cache", err))
+	}
+	report.check("cache", nil)
+	if cache.VlanUpdated {
+		netconf.VlanTag = cache.Netconf.VlanTag
+		netconf.Trunk = cache.Netconf.Trunk
 	}
 
 	// TODO: CmdCheck for userspace driver
@@ -707,39 +1855,97 @@ func CmdCheck(args *skel.CmdArgs) error {
 		return nil
 	}
 
+	var ovsBridgeDriver *ovsdb.OvsBridgeDriver
+	if !ovsdbCached {
+		if ovsBridgeDriver, err = newBridgeDriver(netconf.BrName, netconf); err != nil {
+			return newError(cnitypes.ErrTryAgainLater, report.check("ovsdb", err))
+		}
+		report.driver = ovsBridgeDriver
+	}
+	if isVhostUserMode(netconf) {
+		report.port = vhostUserPortName(args.ContainerID, args.IfName)
+		if ovsdbCached {
+			return nil
+		}
+		if err := report.check("vhost-user port", checkVhostUser(ovsBridgeDriver, args, netconf)); err != nil {
+			return err
+		}
+		cacheCheck(netconf, cRef, digest, report.port)
+		return nil
+	}
+
 	// run the IPAM plugin
 	// userspace driver does not support IPAM plugin,
-	// because there is no network interface for the VF on the host
-	if netconf.NetConf.IPAM.Type != "" && !cache.UserspaceMode {
-		err = ipam.ExecCheck(netconf.NetConf.IPAM.Type, args.StdinData)
-		if err != nil {
-			return fmt.Errorf("failed to check with IPAM plugin type %q: %v", netconf.NetConf.IPAM.Type, err)
+	// because there is no network interface for the VF on the host,
+	// unless addresses are configured on an internal port
+	userspaceIPAM := hasUserspaceIPAM(netconf, cache.UserspaceMode)
+	if netconf.NetConf.IPAM.Type != "" && (!cache.UserspaceMode || userspaceIPAM) {
+		if err = setupIPAMEnv(netconf); err != nil {
+			return err
+		}
+		var ipamPlugins []ipamPlugin
+		if ipamPlugins, err = ipamPluginsOf(netconf, args.StdinData); err != nil {
+			return err
+		}
+		if err = report.check("ipam", execIPAMCheck(ipamPlugins)); err != nil {
+			return err
 		}
 	}
 
 	// Parse previous result.
 	if netconf.NetConf.RawPrevResult == nil {
-		return fmt.Errorf("Required prevResult missing")
+		// only the OVS port is checked
+		if ovsdbCached {
+			return nil
+		}
+		portName, err := checkWithoutPrevResult(ovsBridgeDriver, args, netconf)
+		if err != nil {
+			return report.check("ovs port", err)
+		}
+		report.port = portName
+		report.check("ovs port", nil)
+		cacheCheck(netconf, cRef, digest, portName)
+		return nil
 	}
 	if err := version.ParsePrevResult(&netconf.NetConf); err != nil {
-		return err
+		return report.check("prevResult", err)
 	}
 	result, err := current.NewResultFromResult(netconf.NetConf.PrevResult)
 	if err != nil {
-		return err
+		return report.check("prevResult", err)
 	}
 
 	var contIntf, hostIntf current.Interface
 	// Find interfaces
+	ipIfName := args.IfName
+	if userspaceIPAM {
+		ipIfName = cachedUserspaceIPAMPort(cache)
+	}
+	if netconf.Bond != nil {
+		ipIfName = netconf.Bond.Name
+	}
 	for _, intf := range result.Interfaces {
+		if netconf.Backup != nil && (intf.Name == cache.BackupPort || intf.Name == backupIfName(netconf, args.IfName)) {
+			continue
+		}
+		if netconf.Bond != nil && intf.Name == netconf.Bond.Name && intf.Sandbox != "" {
+			continue
+		}
+		if userspaceIPAM && intf.Name == ipIfName {
+			continue
+		}
 		if args.IfName == intf.Name {
 			if args.Netns == intf.Sandbox {
 				contIntf = *intf
 			}
 		} else {
-			// Check prevResults for ips against values found in the host
-			if err := validateInterface(*intf, true, ovsHWOffloadEnable); err != nil {
-				return err
+			// Check prevResults for ips against values found in the host,
+			// an internal port has no interface left on the host, the
+			// representor attached to a DPU-hosted bridge is on the DPU
+			if !isInternalPortMode(netconf) && !(ovsHWOffloadEnable && netconf.BridgeSocketFile != "") {
+				if err := report.check("host interface "+intf.Name, validateInterface(*intf, true, ovsHWOffloadEnable)); err != nil {
+					return err
+				}
 			}
 			hostIntf = *intf
 		}
@@ -747,44 +1953,58 @@ func CmdCheck(args *skel.CmdArgs) error {
 
 	// The namespace must be the same as what was configured
 	if args.Netns != contIntf.Sandbox {
-		return fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
-			contIntf.Sandbox, args.Netns)
+		return report.check("container netns", fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
+			contIntf.Sandbox, args.Netns))
 	}
 
-	netns, err := ns.GetNS(args.Netns)
+	contNetns, err := netns.Get(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return openNetnsError(args.Netns, err)
 	}
-	defer netns.Close()
+	defer contNetns.Close()
 
 	// Check prevResults for ips and routes against values found in the container
-	if err := netns.Do(func(_ ns.NetNS) error {
+	if err := netns.Do(contNetns, func(_ ns.NetNS) error {
 
 		// Check interface against values found in the container
-		err := validateInterface(contIntf, false, ovsHWOffloadEnable)
-		if err != nil {
+		err := validateInterface(contIntf, false, ovsHWOffloadEnable || isInternalPortMode(netconf) || isTapMode(netconf))
+		if err = report.check("container interface "+contIntf.Name, err); err != nil {
 			return err
 		}
 
-		err = ip.ValidateExpectedInterfaceIPs(args.IfName, result.IPs)
-		if err != nil {
+		err = validateContainerAddresses(ipIfName, result.IPs)
+		if err = report.check("addresses", err); err != nil {
 			return err
 		}
 
-		err = ip.ValidateExpectedRoute(result.Routes)
-		if err != nil {
+		err = validateContainerRoutes(ipIfName, result)
+		if err = report.check("routes", err); err != nil {
 			return err
 		}
 		return nil
 	}); err != nil {
-		return err
+		err = withForwardingVerdict(err, netconf, hostIntf.Name, contIntf.Mac)
+		if ovsdbCached {
+			return err
+		}
+		return withBridgeState(err, ovsBridgeDriver, netconf)
 	}
 
 	// ovs specific check
-	if err := validateOvs(args, netconf, hostIntf.Name); err != nil {
-		return err
+	report.port = hostIntf.Name
+	if ovsdbCached {
+		return nil
+	}
+	if err := report.check("ovs port", validateOvs(ovsBridgeDriver, netconf, hostIntf.Name)); err != nil {
+		return withBridgeState(withForwardingVerdict(err, netconf, hostIntf.Name, contIntf.Mac), ovsBridgeDriver, netconf)
+	}
+	if netconf.Backup != nil {
+		if err := report.check("backup", checkBackup(netconf, cache.BackupPort)); err != nil {
+			return err
+		}
 	}
 
+	cacheCheck(netconf, cRef, digest, hostIntf.Name)
 	return nil
 }
 
@@ -799,6 +2019,11 @@ func validateCache(cache *types.CachedNetConf, netconf *types.NetConf) error {
 			cache.Netconf.SocketFile, netconf.SocketFile)
 	}
 
+	if bridgeSocketFile(cache.Netconf) != bridgeSocketFile(netconf) {
+		return fmt.Errorf("BridgeSocketFile mismatch. cache=%s,netconf=%s",
+			bridgeSocketFile(cache.Netconf), bridgeSocketFile(netconf))
+	}
+
 	if cache.Netconf.IPAM.Type != netconf.IPAM.Type {
 		return fmt.Errorf("IPAM mismatch. cache=%s,netconf=%s",
 			cache.Netconf.IPAM.Type, netconf.IPAM.Type)
@@ -825,7 +2050,7 @@ func validateInterface(intf current.Interface, isHost bool, hwOffload bool) erro
 	if intf.Name == "" {
 		return fmt.Errorf("%s interface name missing in prevResult: %v", iftype, intf.Name)
 	}
-	link, err = netlink.LinkByName(intf.Name)
+	link, err = netif.Default.LinkByName(intf.Name)
 	if err != nil {
 		return fmt.Errorf("Error: %s Interface name in prevResult: %s not found", iftype, intf.Name)
 	}
@@ -846,12 +2071,7 @@ func validateInterface(intf current.Interface, isHost bool, hwOffload bool) erro
 	return nil
 }
 
-func validateOvs(args *skel.CmdArgs, netconf *types.NetConf, hostIfname string) error {
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(netconf.BrName, netconf.SocketFile)
-	if err != nil {
-		return err
-	}
-
+func validateOvs(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, hostIfname string) error {
 	found, err := ovsBridgeDriver.IsBridgePresent(netconf.BrName)
 	if err != nil {
 		return err
@@ -860,45 +2080,41 @@ func validateOvs(args *skel.CmdArgs, netconf *types.NetConf, hostIfname string)
 		return fmt.Errorf("Error: bridge %s is not found in OVS", netconf.BrName)
 	}
 
-	ifaces, err := ovsBridgeDriver.FindInterfacesWithError()
-	if err != nil {
-		return err
-	}
-	if len(ifaces) > 0 {
-		return fmt.Errorf("Error: There are some interfaces in error state: %v", ifaces)
+	if !ovsBridgeDriver.LeastPrivilege {
+		ifaces, err := ovsBridgeDriver.FindInterfacesWithError()
+		if err != nil {
+			return err
+		}
+		if len(ifaces) > 0 {
+			return fmt.Errorf("Error: There are some interfaces in error state: %v", ifaces)
+		}
 	}
 
-	vlanMode, tag, trunk, err := ovsBridgeDriver.GetOFPortVlanState(hostIfname)
+	portVlanMode, tag, trunk, err := ovsBridgeDriver.GetOFPortVlanState(hostIfname)
 	if err != nil {
 		return fmt.Errorf("Error: Failed to retrieve port %s state: %v", hostIfname, err)
 	}
 
-	// check vlan tag
-	if netconf.VlanTag == nil {
+	// check vlan tag, the S-VLAN of a dot1q-tunnel port
+	netconfTag := netconf.VlanTag
+	if netconf.QinQ != nil {
+		netconfTag = &netconf.QinQ.SVlan
+	}
+	if netconfTag == nil {
 		if tag != nil {
 			return fmt.Errorf("vlan tag mismatch. ovs=%d,netconf=nil", *tag)
 		}
 	} else {
 		if tag == nil {
-			return fmt.Errorf("vlan tag mismatch. ovs=nil,netconf=%d", *netconf.VlanTag)
-		}
-		if *tag != *netconf.VlanTag {
-			return fmt.Errorf("vlan tag mismatch. ovs=%d,netconf=%d", *tag, *netconf.VlanTag)
+			return fmt.Errorf("vlan tag mismatch. ovs=nil,netconf=%d", *netconfTag)
 		}
-		if vlanMode != "access" {
-			return fmt.Errorf("vlan mode mismatch. expected=access,real=%s", vlanMode)
+		if *tag != *netconfTag {
+			return fmt.Errorf("vlan tag mismatch. ovs=%d,netconf=%d", *tag, *netconfTag)
 		}
 	}
 
 	// check trunk
-	netconfTrunks := make([]uint, 0)
-	if len(netconf.Trunk) > 0 {
-		trunkVlanIds, err := splitVlanIds(netconf.Trunk)
-		if err != nil {
-			return err
-		}
-		netconfTrunks = append(netconfTrunks, trunkVlanIds...)
-	}
+	_, netconfTrunks, _ := portVlan(netconf)
 	if len(trunk) != len(netconfTrunks) {
 		return fmt.Errorf("trunk mismatch. ovs=%v,netconf=%v", trunk, netconfTrunks)
 	}
@@ -908,9 +2124,23 @@ func validateOvs(args *skel.CmdArgs, netconf *types.NetConf, hostIfname string)
 				return fmt.Errorf("trunk mismatch. ovs=%v,netconf=%v", trunk, netconfTrunks)
 			}
 		}
+	}
+
+	// check vlan mode
+	if netconf.VlanMode != "" || netconfTag != nil || len(netconfTrunks) > 0 {
+		if expected := vlanMode(netconf); portVlanMode != expected {
+			return fmt.Errorf("vlan mode mismatch. expected=%s,real=%s", expected, portVlanMode)
+		}
+	}
 
-		if vlanMode != "trunk" {
-			return fmt.Errorf("vlan mode mismatch. expected=trunk,real=%s", vlanMode)
+	// check ethertype of the S-VLAN
+	if netconf.QinQ != nil {
+		ethType, err := ovsBridgeDriver.GetPortQinQEthType(hostIfname)
+		if err != nil {
+			return fmt.Errorf("Error: Failed to retrieve port %s state: %v", hostIfname, err)
+		}
+		if expected := qinqEthType(netconf); ethType != expected {
+			return fmt.Errorf("qinq-ethtype mismatch. expected=%s,real=%s", expected, ethType)
 		}
 	}
 
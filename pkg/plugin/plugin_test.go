@@ -12,6 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !simulation
+
 package plugin
 
 import (
@@ -20,9 +22,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ethtool"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/faults"
 	"math/rand"
 	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -41,7 +47,9 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
 
 type Range struct {
@@ -111,6 +119,7 @@ var _ = Describe("CNI Plugin 0.3.0", func() { testFunc("0.3.0") })
 var _ = Describe("CNI Plugin 0.3.1", func() { testFunc("0.3.1") })
 var _ = Describe("CNI Plugin 0.4.0", func() { testFunc("0.4.0") })
 var _ = Describe("CNI Plugin 1.0.0", func() { testFunc("1.0.0") })
+var _ = Describe("CNI Plugin 1.1.0", func() { testFunc("1.1.0") })
 
 var testFunc = func(version string) {
 	BeforeEach(func() {
@@ -129,23 +138,13 @@ var testFunc = func(version string) {
 		Expect(err).NotTo(HaveOccurred(), "Failed to remove testing OVS bridge: %v", string(output[:]))
 	})
 
-	testSplitVlanIds := func(conf string, expTrunks []uint, expErr error, setUnmarshalErr bool) {
+	testSplitVlanIds := func(conf string, expTrunks []uint) {
 		var trunks []*types.Trunk
-		err := json.Unmarshal([]byte(conf), &trunks)
-		if setUnmarshalErr {
-			Expect(err).To(HaveOccurred())
-			return
-		}
-		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal([]byte(conf), &trunks)).To(Succeed())
 		By("Calling testSplitVlanIds method")
-		vlanIds, err := splitVlanIds(trunks)
-		if expErr != nil {
-			By("Checking expected error is occurred")
-			Expect(err).To(Equal(expErr))
-		} else {
-			By("Checking vlanIds are same as trunk vlans")
-			Expect(vlanIds).To(Equal(expTrunks))
-		}
+		vlanIds := splitVlanIds(trunks)
+		By("Checking vlanIds are same as trunk vlans")
+		Expect(vlanIds).To(Equal(expTrunks))
 	}
 
 	testCheck := func(conf string, r cnitypes.Result, targetNs ns.NetNS) {
@@ -260,6 +259,16 @@ var testFunc = func(version string) {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(link.Attrs().HardwareAddr).To(Equal(hwaddr))
 
+			if ipPrefix == "" {
+				By("Checking that IPv6-only interface has MAC address derived from its IPv6 address")
+				addrs, err := netlink.AddrList(link, syscall.AF_INET6)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(addrs)).To(Equal(2))
+				Expect(addrs[0].String()).To(HavePrefix(ip6Prefix))
+				Expect(link.Attrs().HardwareAddr).To(Equal(IPAddrToHWAddr(addrs[0].IP)))
+				return nil
+			}
+
 			addrs, err := netlink.AddrList(link, syscall.AF_INET)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(len(addrs)).To(Equal(1))
@@ -396,6 +405,11 @@ var testFunc = func(version string) {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(externalIDContIface).To(Equal(contIface.Name))
 
+		By("Checking that port external-id:contNetwork contains name of the network")
+		externalIDContNetwork, err := getPortAttribute(hostIface.Name, "external-ids:contNetwork")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(externalIDContNetwork).To(Equal("mynet"))
+
 		By("Checking that port external-id:contNetns contains reference to container namespace path")
 		externalIDContNetns, err := getPortAttribute(hostIface.Name, "external-ids:contNetns")
 		Expect(err).NotTo(HaveOccurred())
@@ -500,6 +514,57 @@ var testFunc = func(version string) {
 				testDel(conf, hostIfName, targetNs, true)
 			})
 		})
+		Context("with VLAN ID and trunk set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": %d,
+				"trunk": [ {"minID": 10, "maxID": 12} ]
+			}`, version, bridgeName, vlanID)
+			It("should create a native-tagged port and complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, true, false, "[10, 11, 12]", targetNs)
+
+				By("Checking that the port is in native-tagged mode")
+				portVlanMode, err := getPortAttribute(hostIfName, "vlan_mode")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(portVlanMode).To(Equal("native-tagged"))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with explicit vlan_mode set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": %d,
+				"vlan_mode": "native-untagged",
+				"trunk": [ {"minID": 10, "maxID": 12} ]
+			}`, version, bridgeName, vlanID)
+			It("should create a port in the given mode and complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, true, false, "[10, 11, 12]", targetNs)
+
+				By("Checking that the port is in native-untagged mode")
+				portVlanMode, err := getPortAttribute(hostIfName, "vlan_mode")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(portVlanMode).To(Equal("native-untagged"))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
 		Context("with specific VLAN ID ranges set (via both range and id) for the port", func() {
 			conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -568,6 +633,38 @@ var testFunc = func(version string) {
 				testIPAM(conf, true, "10.1.2", "3ffe:ffff:0:1ff")
 			})
 		})
+		Context("with dual stack ip addresses listed IPv6 first set for container interface", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[{"subnet": "3ffe:ffff:0:1ff::/64", "rangeStart": "3ffe:ffff:0:1ff::10", "rangeEnd": "3ffe:ffff:0:1ff::20"}], [ {"subnet": "10.1.2.0/24", "gateway": "10.1.2.1"} ]],
+					"dataDir": "/tmp/ovs-cni/conf"
+				}
+			}`, version, bridgeName)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				testIPAM(conf, true, "10.1.2", "3ffe:ffff:0:1ff")
+			})
+		})
+		Context("with IPv6 only address set for container interface", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[{"subnet": "3ffe:ffff:0:1ff::/64", "rangeStart": "3ffe:ffff:0:1ff::10", "rangeEnd": "3ffe:ffff:0:1ff::20"}]],
+					"dataDir": "/tmp/ovs-cni/conf"
+				}
+			}`, version, bridgeName)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				testIPAM(conf, false, "", "3ffe:ffff:0:1ff")
+			})
+		})
 		Context("with invalid VLAN configuration", func() {
 			conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -596,6 +693,23 @@ var testFunc = func(version string) {
 				testInvalidAdd(conf)
 			})
 		})
+		Context("with failing OVSDB transactions", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+			BeforeEach(func() {
+				Expect(os.Setenv(faults.EnvVar, faults.OvsdbTransact+"=fail")).To(Succeed())
+			})
+			AfterEach(func() {
+				Expect(os.Unsetenv(faults.EnvVar)).To(Succeed())
+			})
+			It("should fail and leave no leftovers", func() {
+				testInvalidAdd(conf)
+			})
+		})
 		Context("with MTU set on port", func() {
 			conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -614,6 +728,179 @@ var testFunc = func(version string) {
 				testDel(conf, hostIfName, targetNs, true)
 			})
 		})
+		Context("with rate limit set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"rate_limit": {"rate": 100000}
+			}`, version, bridgeName)
+			It("should police traffic received from the port by OVS", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, true, "", targetNs)
+				for attribute, value := range map[string]string{"ingress_policing_rate": "100000", "ingress_policing_burst": "10000"} {
+					output, err := exec.Command("ovs-vsctl", "get", "Interface", hostIfName, attribute).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+					Expect(strings.TrimSpace(string(output))).To(Equal(value))
+				}
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with bandwidth passed by the runtime", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"capabilities": {"bandwidth": true},
+				"runtimeConfig": {"bandwidth": {"ingressRate": 20000000, "ingressBurst": 4000000, "egressRate": 10000000}}
+			}`, version, bridgeName)
+			It("should police egress and shape ingress of the container on its port", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, true, "", targetNs)
+				for attribute, value := range map[string]string{"ingress_policing_rate": "10000", "ingress_policing_burst": "1000"} {
+					output, err := exec.Command("ovs-vsctl", "get", "Interface", hostIfName, attribute).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+					Expect(strings.TrimSpace(string(output))).To(Equal(value))
+				}
+				output, err := exec.Command("ovs-vsctl", "--columns=type,other_config", "list", "QoS").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(And(ContainSubstring("linux-htb"), ContainSubstring(`max-rate="20000000"`)))
+				output, err = exec.Command("ovs-vsctl", "--columns=other_config", "list", "Queue").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(ContainSubstring(`burst="4000000"`))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+				for _, table := range []string{"QoS", "Queue"} {
+					output, err := exec.Command("ovs-vsctl", "--columns=_uuid", "list", table).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+					Expect(strings.TrimSpace(string(output))).To(BeEmpty())
+				}
+			})
+		})
+		Context("with qos set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"qos": {"min_rate": 100000, "max_rate": 500000, "burst": 5000, "priority": 1}
+			}`, version, bridgeName)
+			It("should shape traffic sent to the port by a queue and remove it on DEL", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, true, "", targetNs)
+				output, err := exec.Command("ovs-vsctl", "get", "Port", hostIfName, "qos").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				qosUUID := strings.TrimSpace(string(output))
+				output, err = exec.Command("ovs-vsctl", "get", "QoS", qosUUID, "type", "other_config").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(And(ContainSubstring("linux-htb"), ContainSubstring(`max-rate="500000000"`)))
+				output, err = exec.Command("ovs-vsctl", "--columns=other_config", "list", "Queue").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(And(ContainSubstring(`min-rate="100000000"`), ContainSubstring(`burst="5000000"`), ContainSubstring(`priority="1"`)))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+				for _, table := range []string{"QoS", "Queue"} {
+					output, err := exec.Command("ovs-vsctl", "--columns=_uuid", "list", table).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+					Expect(strings.TrimSpace(string(output))).To(BeEmpty())
+				}
+			})
+			It("should keep qos set on the port by others on DEL", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, _ := testAdd(conf, false, true, "", targetNs)
+				output, err := exec.Command("ovs-vsctl", "--", "set", "Port", hostIfName, "qos=@qos",
+					"--", "--id=@qos", "create", "QoS", "type=linux-htb").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				foreignUUID := strings.TrimSpace(string(output))
+				defer func() {
+					output, err := exec.Command("ovs-vsctl", "destroy", "QoS", foreignUUID).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+				}()
+
+				testDel(conf, hostIfName, targetNs, true)
+				output, err = exec.Command("ovs-vsctl", "--columns=_uuid", "--bare", "list", "QoS").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.TrimSpace(string(output))).To(Equal(foreignUUID))
+				output, err = exec.Command("ovs-vsctl", "--columns=_uuid", "list", "Queue").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.TrimSpace(string(output))).To(BeEmpty())
+			})
+		})
+		Context("with qinq set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"qinq": {"s_vlan": %d, "ethtype": "802.1q"}
+			}`, version, bridgeName, vlanID)
+			It("should tag traffic of the container with the S-VLAN", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, true, false, "", targetNs)
+				vlanMode, err := getPortAttribute(hostIfName, "vlan_mode")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vlanMode).To(Equal("dot1q-tunnel"))
+				otherConfig, err := getPortAttribute(hostIfName, "other_config")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(otherConfig).To(ContainSubstring(`qinq-ethtype="802.1q"`))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+			It("should fail CHECK when the ethertype of the S-VLAN differs", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"qinq": {"s_vlan": %d, "ethtype": "802.1q"},
+				"missing_prev_result": "warn"
+			}`, version, bridgeName, vlanID)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					args.StdinData = []byte(conf)
+					Expect(cmdDelWithArgs(args, func() error {
+						return CmdDel(args)
+					})).To(Succeed())
+				}()
+
+				args.StdinData = []byte(strings.Replace(conf, `"ethtype": "802.1q"`, `"ethtype": "802.1ad"`, 1))
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(MatchError(ContainSubstring("qinq-ethtype mismatch. expected=802.1ad,real=802.1q")))
+			})
+		})
 		Context("invoke DEL action after deleting container net namespace", func() {
 			conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -657,6 +944,130 @@ var testFunc = func(version string) {
 				testDel(conf, hostIfName, targetNs, true)
 			})
 		})
+		Context("with interface left in container by a previous attempt", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+			It("should replace the stale interface and complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				err := targetNs.Do(func(hostNs ns.NetNS) error {
+					defer GinkgoRecover()
+					_, _, err := ip.SetupVeth(IFNAME, defaultMTU, "", hostNs)
+					return err
+				})
+				Expect(err).NotTo(HaveOccurred())
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with port of a previous sandbox of the pod", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+			It("should reclaim the stale port on ADD", func() {
+				oldNs := newNS()
+				defer func() {
+					closeNS(oldNs)
+				}()
+				newNs := newNS()
+				defer func() {
+					closeNS(newNs)
+				}()
+				oldArgs := &skel.CmdArgs{
+					ContainerID: "old-sandbox",
+					Netns:       oldNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+					Args:        "K8S_POD_UID=static-pod-uid",
+				}
+				_, _, err := cmdAddWithArgs(oldArgs, func() error {
+					return CmdAdd(oldArgs)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				oldPorts, err := listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(oldPorts).To(HaveLen(1))
+
+				newArgs := &skel.CmdArgs{
+					ContainerID: "new-sandbox",
+					Netns:       newNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+					Args:        "K8S_POD_UID=static-pod-uid",
+				}
+				_, _, err = cmdAddWithArgs(newArgs, func() error {
+					return CmdAdd(newArgs)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				newPorts, err := listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newPorts).To(HaveLen(1))
+				Expect(newPorts).NotTo(Equal(oldPorts))
+
+				By("Checking that DEL of the previous sandbox keeps the new port")
+				Expect(cmdDelWithArgs(oldArgs, func() error {
+					return CmdDel(oldArgs)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).To(Equal(newPorts))
+
+				Expect(cmdDelWithArgs(newArgs, func() error {
+					return CmdDel(newArgs)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).To(BeEmpty())
+			})
+		})
+		Context("with stable port names", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"stable_port_names": true
+			}`, version, bridgeName)
+			It("should name the port after the pod and add a suffix when the name is taken", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				pod := podIdentity{namespace: "default", name: "pod1", ifName: IFNAME}
+				taken := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "veth" + pod.portNameHash()}}
+				Expect(netlink.LinkAdd(taken)).To(Succeed())
+				defer func() {
+					Expect(netlink.LinkDel(taken)).To(Succeed())
+				}()
+
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+					Args:        "K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod1",
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces[0].Name).To(Equal("veth" + pod.portNameHash() + "1"))
+				Expect(listBridgePorts(bridgeName)).To(Equal([]string{"veth" + pod.portNameHash() + "1"}))
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).To(BeEmpty())
+			})
+		})
 		Context("random mac address on container interface", func() {
 			It("should create eth0 on two different namespace with different mac addresses", func() {
 				conf := fmt.Sprintf(`{
@@ -711,130 +1122,1660 @@ var testFunc = func(version string) {
 				Expect(contIface.Mac).To(Equal(mac))
 			})
 		})
-		Context("specified OvnPort", func() {
-			It("should configure and ovs interface with iface-id", func() {
-				const ovsOutput = "external_ids        : {iface-id=test-port}"
-
+		Context("with MAC prefix", func() {
+			It("should generate container MAC address with the prefix", func() {
 				conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
 				"name": "mynet",
 				"type": "ovs",
-				"OvnPort": "test-port",
+				"bridge": "%s",
+				"mac_prefix": "0e:42:01"
+				}`, version, bridgeName)
+
+				targetNs := newNS()
+				defer closeNS(targetNs)
+
+				result := attach(targetNs, conf, IFNAME, "", "")
+				Expect(result.Interfaces[1].Mac).To(HavePrefix("0e:42:01:"))
+			})
+			It("should reject requested MAC address outside allowed prefixes", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"allowed_mac_prefixes": ["0e:42"]
+				}`, version, bridgeName)
+
+				targetNs := newNS()
+				defer closeNS(targetNs)
+
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+					Args:        "MAC=0a:00:00:00:00:80",
+				}
+				_, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).To(MatchError(ContainSubstring("not within allowed prefixes")))
+			})
+		})
+		Context("specified OvnPort", func() {
+			It("should configure and ovs interface with iface-id", func() {
+				const ovsOutput = "\"test-port\""
+
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"OvnPort": "test-port",
 				"bridge": "%s"}`, version, bridgeName)
 
 				targetNs := newNS()
 				defer func() {
-					closeNS(targetNs)
+					closeNS(targetNs)
+				}()
+
+				OvnPort := "test-port"
+				result := attach(targetNs, conf, IFNAME, "", OvnPort)
+				hostIface := result.Interfaces[0]
+				output, err := exec.Command("ovs-vsctl", "get", "Interface", hostIface.Name, "external_ids:iface-id").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(output[:len(output)-1])).To(Equal(ovsOutput))
+
+				By("Checking that the interface is stamped with creation time and plugin version")
+				output, err = exec.Command("ovs-vsctl", "--column=external_ids", "find", "Interface", fmt.Sprintf("name=%s", hostIface.Name)).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(output)).To(ContainSubstring("ovs-cni.creation-time="))
+				Expect(string(output)).To(ContainSubstring("ovs-cni.version="))
+			})
+		})
+		Context("specified OfportRequest", func() {
+			It("should configure an ovs interface with a specific ofport", func() {
+				// Pick a random ofport 5000-6000
+				ofportRequest := rand.Intn(1000) + 5000
+				ovsOutput := fmt.Sprintf("ofport              : %d", ofportRequest)
+
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"ofport_request": %d,
+				"bridge": "%s"}`, version, ofportRequest, bridgeName)
+
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+
+				result := attach(targetNs, conf, IFNAME, "", "")
+				hostIface := result.Interfaces[0]
+
+				// Wait for OVS to actually assign the port, even when the add returns
+				// successfully, ofport can still be blank ("[]") for a short period of
+				// time.
+				Eventually(func() bool {
+					output, err := exec.Command("ovs-vsctl", "--column=ofport", "find", "Interface", fmt.Sprintf("name=%s", hostIface.Name)).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred())
+					return (string(output[:len(output)-1]) == ovsOutput)
+				}, time.Minute, 100*time.Millisecond).Should(Equal(true))
+			})
+		})
+		Context("with interface of type system for port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"interface_type": "%s"
+			}`, version, bridgeName, systemType)
+			It("should successfully complete ADD and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("without interface type on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with cache entry saved without network name", func() {
+			It("should migrate it and complete DEL", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				hostIfName, _ := testAdd(conf, false, false, "", targetNs)
+
+				By("Moving the cache entry to the legacy key")
+				cRef := config.GetNetworkCRef("mynet", "dummy", IFNAME)
+				data, err := utils.ReadCache(cRef)
+				Expect(err).NotTo(HaveOccurred())
+				cache := &types.CachedNetConf{}
+				Expect(json.Unmarshal(data, cache)).To(Succeed())
+				Expect(utils.SaveCache(config.GetCRef("dummy", IFNAME), cache)).To(Succeed())
+				Expect(utils.CleanCache(cRef)).To(Succeed())
+
+				testDel(conf, hostIfName, targetNs, true)
+				_, err = utils.ReadCache(config.GetCRef("dummy", IFNAME))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("with lost cache entry", func() {
+			It("should rebuild it from the port and complete CHECK and DEL", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+
+				cRef := config.GetNetworkCRef("mynet", "dummy", IFNAME)
+				Expect(utils.CleanCache(cRef)).To(Succeed())
+				rebuilt, err := RebuildCache("", "", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rebuilt.Restored).To(Equal([]string{cRef}))
+
+				data, err := utils.ReadCache(cRef)
+				Expect(err).NotTo(HaveOccurred())
+				cache := &types.CachedNetConf{}
+				Expect(json.Unmarshal(data, cache)).To(Succeed())
+				Expect(cache.Netconf.BrName).To(Equal(bridgeName))
+				Expect(cache.Netns).To(Equal(targetNs.Path()))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with uplink check", func() {
+			const uplinkName = "uplink0"
+			BeforeEach(func() {
+				// uplink stays down, its peer is never brought up
+				veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: uplinkName}, PeerName: uplinkName + "p"}
+				Expect(netlink.LinkAdd(veth)).To(Succeed())
+				output, err := exec.Command("ovs-vsctl", "add-port", bridgeName, uplinkName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			AfterEach(func() {
+				output, err := exec.Command("ovs-vsctl", "--if-exists", "del-port", bridgeName, uplinkName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(ip.DelLinkByName(uplinkName)).To(Succeed())
+			})
+			It("should fail ADD when the uplink is down", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"uplink_check": "fail"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).To(MatchError(ContainSubstring("uplink of bridge " + bridgeName + " is not up: [" + uplinkName + "]")))
+			})
+			It("should only warn when configured so", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"uplink_check": "warn",
+				"uplink_ports": ["%s"]
+			}`, version, bridgeName, uplinkName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				// testAdd and testDel expect no other ports on the bridge
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				brPorts, err := listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(brPorts).To(Equal([]string{uplinkName}))
+			})
+			It("should clamp mtu to MTU of the uplink when configured so", func() {
+				uplink, err := netlink.LinkByName(uplinkName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetMTU(uplink, 1400)).To(Succeed())
+				Eventually(func() string {
+					output, _ := exec.Command("ovs-vsctl", "get", "Interface", uplinkName, "mtu").CombinedOutput()
+					return strings.TrimSpace(string(output))
+				}, time.Second*5, time.Millisecond*100).Should(Equal("1400"))
+
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"mtu": 1500,
+				"mtu_check": "clamp"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err = cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					Expect(cmdDelWithArgs(args, func() error {
+						return CmdDel(args)
+					})).To(Succeed())
+				}()
+				Expect(targetNs.Do(func(ns.NetNS) error {
+					link, err := netlink.LinkByName(IFNAME)
+					if err != nil {
+						return err
+					}
+					Expect(link.Attrs().MTU).To(Equal(1400))
+					return nil
+				})).To(Succeed())
+			})
+			It("should use MTU of the uplink when mtu is not set", func() {
+				uplink, err := netlink.LinkByName(uplinkName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetMTU(uplink, 1400)).To(Succeed())
+				Eventually(func() string {
+					output, _ := exec.Command("ovs-vsctl", "get", "Interface", uplinkName, "mtu").CombinedOutput()
+					return strings.TrimSpace(string(output))
+				}, time.Second*5, time.Millisecond*100).Should(Equal("1400"))
+
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err = cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					Expect(cmdDelWithArgs(args, func() error {
+						return CmdDel(args)
+					})).To(Succeed())
+				}()
+				Expect(targetNs.Do(func(ns.NetNS) error {
+					link, err := netlink.LinkByName(IFNAME)
+					if err != nil {
+						return err
+					}
+					Expect(link.Attrs().MTU).To(Equal(1400))
+					return nil
+				})).To(Succeed())
+			})
+		})
+		Context("with port limit of the bridge", func() {
+			BeforeEach(func() {
+				output, err := exec.Command("ovs-vsctl", "set", "Bridge", bridgeName, "external_ids:"+ovsdb.BridgeMaxPortsKey+"=1").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			AfterEach(func() {
+				output, err := exec.Command("ovs-vsctl", "remove", "Bridge", bridgeName, "external_ids", ovsdb.BridgeMaxPortsKey).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			It("should fail ADD when the bridge is full", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					Expect(cmdDelWithArgs(args, func() error {
+						return CmdDel(args)
+					})).To(Succeed())
+				}()
+
+				secondArgs := *args
+				secondArgs.IfName = "net2"
+				_, _, err = cmdAddWithArgs(&secondArgs, func() error {
+					return CmdAdd(&secondArgs)
+				})
+				Expect(err).To(MatchError(ContainSubstring("bridge " + bridgeName + " is full")))
+				var cniErr *cnitypes.Error
+				Expect(errors.As(err, &cniErr)).To(BeTrue())
+				Expect(cniErr.Code).To(Equal(types.ErrBridgeFull))
+			})
+		})
+		Context("with missing prevResult allowed", func() {
+			It("should check only the OVS port of the attachment", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": 100,
+				"missing_prev_result": "warn"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					args.StdinData = []byte(conf)
+					Expect(cmdDelWithArgs(args, func() error {
+						return CmdDel(args)
+					})).To(Succeed())
+				}()
+
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(Succeed())
+
+				args.StdinData = []byte(strings.Replace(conf, `"vlan": 100`, `"vlan": 200`, 1))
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(MatchError(ContainSubstring("vlan tag mismatch")))
+			})
+		})
+		Context("with an attachment of the Linux bridge plugin", func() {
+			const linuxBridgeName = "test-lbr"
+			BeforeEach(func() {
+				Expect(netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: linuxBridgeName}})).To(Succeed())
+			})
+			AfterEach(func() {
+				if link, err := netlink.LinkByName(linuxBridgeName); err == nil {
+					Expect(netlink.LinkDel(link)).To(Succeed())
+				}
+			})
+			It("should move the host veth to the OVS bridge", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": 100
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				var hostIfName string
+				err := targetNs.Do(func(hostNs ns.NetNS) error {
+					defer GinkgoRecover()
+					hostVeth, _, err := ip.SetupVeth(IFNAME, defaultMTU, "", hostNs)
+					hostIfName = hostVeth.Name
+					return err
+				})
+				Expect(err).NotTo(HaveOccurred())
+				hostLink, err := netlink.LinkByName(hostIfName)
+				Expect(err).NotTo(HaveOccurred())
+				linuxBridge, err := netlink.LinkByName(linuxBridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetMaster(hostLink, linuxBridge)).To(Succeed())
+
+				netconf, err := config.LoadConf([]byte(conf))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(MigrateFromLinuxBridge(netconf, "dummy", IFNAME, targetNs.Path(), nil)).To(Succeed())
+
+				Expect(listBridgePorts(bridgeName)).To(ContainElement(hostIfName))
+				output, err := exec.Command("ovs-vsctl", "get", "Port", hostIfName, "tag").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.TrimSpace(string(output))).To(Equal("100"))
+				_, _, err = config.LoadNetworkConfFromCache("mynet", "dummy", IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+
+				By("Refusing to migrate it again")
+				Expect(MigrateFromLinuxBridge(netconf, "dummy", IFNAME, targetNs.Path(), nil)).To(MatchError(ContainSubstring("already cached")))
+
+				args := &skel.CmdArgs{ContainerID: "dummy", Netns: targetNs.Path(), IfName: IFNAME, StdinData: []byte(conf)}
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).NotTo(ContainElement(hostIfName))
+			})
+			It("should cache IPAM of the bridge plugin to release addresses on DEL", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"ipam": {"type": "static", "addresses": [{"address": "10.1.9.5/24"}]}
+			}`, version, bridgeName)
+				bridgeConf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "bridge",
+				"bridge": "%s",
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[ {"subnet": "10.1.9.0/24"} ]],
+					"dataDir": "/tmp/ovs-cni/conf"
+				}
+			}`, version, linuxBridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				var hostIfName string
+				err := targetNs.Do(func(hostNs ns.NetNS) error {
+					defer GinkgoRecover()
+					hostVeth, _, err := ip.SetupVeth(IFNAME, defaultMTU, "", hostNs)
+					hostIfName = hostVeth.Name
+					return err
+				})
+				Expect(err).NotTo(HaveOccurred())
+				hostLink, err := netlink.LinkByName(hostIfName)
+				Expect(err).NotTo(HaveOccurred())
+				linuxBridge, err := netlink.LinkByName(linuxBridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetMaster(hostLink, linuxBridge)).To(Succeed())
+
+				netconf, err := config.LoadConf([]byte(conf))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(MigrateFromLinuxBridge(netconf, "dummy", IFNAME, targetNs.Path(), []byte(bridgeConf))).To(Succeed())
+
+				cache, _, err := config.LoadNetworkConfFromCache("mynet", "dummy", IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cache.Netconf.IPAM.Type).To(Equal("host-local"))
+				Expect(cache.IPAMStdinData).To(MatchJSON(bridgeConf))
+
+				args := &skel.CmdArgs{ContainerID: "dummy", Netns: targetNs.Path(), IfName: IFNAME, StdinData: []byte(conf)}
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+			})
+		})
+		Context("with VLAN updated on the live port", func() {
+			It("should change the port and the cached netconf", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": 100,
+				"missing_prev_result": "warn"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					args.StdinData = []byte(conf)
+					Expect(cmdDelWithArgs(args, func() error {
+						return CmdDel(args)
+					})).To(Succeed())
+				}()
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				hostIface := result.Interfaces[0].Name
+
+				trunks, err := config.ParseTrunks("10-20")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(UpdateAttachmentVlan("mynet", args.ContainerID, IFNAME, nil, trunks)).To(Succeed())
+
+				output, err := exec.Command("ovs-vsctl", "get", "Port", hostIface, "vlan_mode", "tag", "trunks").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.Fields(string(output))).To(Equal([]string{"trunk", "[]", "[10,", "11,", "12,", "13,", "14,", "15,", "16,", "17,", "18,", "19,", "20]"}))
+
+				cache, _, err := config.LoadNetworkConfFromCache("mynet", args.ContainerID, IFNAME)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cache.VlanUpdated).To(BeTrue())
+				Expect(cache.Netconf.VlanTag).To(BeNil())
+
+				// the updated VLAN takes precedence over the netconf
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(Succeed())
+			})
+			It("should reject an invalid VLAN without changing the port", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": 100
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					Expect(cmdDelWithArgs(args, func() error {
+						return CmdDel(args)
+					})).To(Succeed())
+				}()
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+
+				vlan := uint(5000)
+				Expect(UpdateAttachmentVlan("mynet", args.ContainerID, IFNAME, &vlan, nil)).To(MatchError(ContainSubstring("$.vlan")))
+
+				output, err := exec.Command("ovs-vsctl", "get", "Port", result.Interfaces[0].Name, "tag").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.TrimSpace(string(output))).To(Equal("100"))
+			})
+		})
+		Context("with capture of early traffic", func() {
+			const capturePort = "capture0"
+			BeforeEach(func() {
+				output, err := exec.Command("ovs-vsctl", "add-port", bridgeName, capturePort, "--", "set", "Interface", capturePort, "type=internal").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			AfterEach(func() {
+				output, err := exec.Command("ovs-vsctl", "--if-exists", "del-port", bridgeName, capturePort).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			It("should mirror the new port to the capture port until DEL", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"capture": {"port": "%s", "duration": 30}
+			}`, version, bridgeName, capturePort)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				// testAdd and testDel expect no other ports on the bridge
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				mirrorName := captureMirrorName(result.Interfaces[0].Name)
+
+				By("Checking that the capture mirror outputs to the capture port")
+				output, err := exec.Command("ovs-vsctl", "--bare", "--columns=output_port", "find", "Mirror", "name="+mirrorName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				capturePortUUID, err := exec.Command("ovs-vsctl", "get", "Port", capturePort, "_uuid").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(capturePortUUID))
+				Expect(strings.TrimSpace(string(output))).To(Equal(strings.TrimSpace(string(capturePortUUID))))
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+
+				By("Checking that the capture mirror is removed on DEL")
+				present, err := mustBridgeDriver().IsMirrorPresent(mirrorName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(present).To(BeFalse())
+			})
+			It("should only find expired capture mirrors of its own bridge", func() {
+				const otherBridge = "test-capture"
+				output, err := exec.Command("ovs-vsctl", "add-br", otherBridge).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				defer exec.Command("ovs-vsctl", "--if-exists", "del-br", otherBridge).Run()
+				expired := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+				for _, bridge := range []string{bridgeName, otherBridge} {
+					output, err = exec.Command("ovs-vsctl", "--", "--id=@m", "create", "Mirror", "name=capture-"+bridge,
+						"external_ids:owner=ovs-cni.network.kubevirt.io",
+						fmt.Sprintf("external_ids:%s=%q", ovsdb.CaptureExpiryKey, expired),
+						"--", "add", "Bridge", bridge, "mirrors", "@m").CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+				}
+				defer exec.Command("ovs-vsctl", "clear", "Bridge", bridgeName, "mirrors").Run()
+
+				mirrors, err := mustBridgeDriver().FindExpiredCaptureMirrors(time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mirrors).To(ConsistOf("capture-" + bridgeName))
+			})
+		})
+		Context("with routed mode", func() {
+			It("should route the container addresses through the bridge", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"mode": "routed",
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[ {"subnet": "10.1.3.0/24", "gateway": "10.1.3.1"} ]],
+					"dataDir": "/tmp/ovs-cni/conf"
+				}
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				By("Checking the container has a host address and a route via the virtual gateway")
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					link, err := netlink.LinkByName(IFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addrs).To(HaveLen(1))
+					ones, _ := addrs[0].Mask.Size()
+					Expect(ones).To(Equal(32))
+					routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+					Expect(err).NotTo(HaveOccurred())
+					gateways := []string{}
+					for _, route := range routes {
+						if route.Gw != nil {
+							gateways = append(gateways, route.Gw.String())
+						}
+					}
+					Expect(gateways).To(ContainElement(routedGatewayIPv4))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				cookie := fmt.Sprintf("cookie=%#x/-1", routedCookie("dummy", IFNAME))
+				output, err := exec.Command("ovs-ofctl", "dump-flows", bridgeName, cookie).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(ContainSubstring("nw_dst=10.1.3."))
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				output, err = exec.Command("ovs-ofctl", "dump-flows", bridgeName, cookie).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).NotTo(ContainSubstring("nw_dst="))
+			})
+		})
+		Context("with bridge template", func() {
+			It("should attach to the bridge named by the node label", func() {
+				labelsFile := filepath.Join(GinkgoT().TempDir(), "node-labels")
+				Expect(os.WriteFile(labelsFile, config.FormatNodeLabels(map[string]string{"ovs-bridge": bridgeName}), 0644)).To(Succeed())
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "{label:ovs-bridge}",
+				"node_labels_file": "%s"
+			}`, version, labelsFile)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(listBridgePorts(bridgeName)).To(ContainElement(result.Interfaces[0].Name))
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+			})
+		})
+		Context("with vlan translation", func() {
+			It("should translate the container VLAN on the port", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan_translation": [{"container": 100, "bridge": 1100}],
+				"missing_prev_result": "warn"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				hostIfName := result.Interfaces[0].Name
+
+				By("Checking the port trunks the bridge VLAN")
+				output, err := exec.Command("ovs-vsctl", "get", "Port", hostIfName, "trunks").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.TrimSpace(string(output))).To(Equal("[1100]"))
+
+				By("Checking the translation and flood flows")
+				cookie := fmt.Sprintf("cookie=%#x/-1", vlanTranslationCookie("mynet", "dummy", IFNAME))
+				output, err = exec.Command("ovs-ofctl", "dump-flows", bridgeName, cookie).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(ContainSubstring("actions=mod_vlan_vid:1100,resubmit(,0)"))
+				floodCookie := fmt.Sprintf("cookie=%#x/-1", vlanTranslationFloodCookie(1100))
+				output, err = exec.Command("ovs-ofctl", "dump-flows", bridgeName, floodCookie).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(string(output)).To(ContainSubstring("mod_vlan_vid:100,output:" + hostIfName))
+
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(Succeed())
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				for _, c := range []string{cookie, floodCookie} {
+					output, err = exec.Command("ovs-ofctl", "dump-flows", bridgeName, c).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+					Expect(string(output)).NotTo(ContainSubstring("mod_vlan_vid"))
+				}
+			})
+		})
+		Context("with backup interface", func() {
+			const backupBridgeName = "test-backup"
+			BeforeEach(func() {
+				output, err := exec.Command("ovs-vsctl", "add-br", backupBridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			AfterEach(func() {
+				output, err := exec.Command("ovs-vsctl", "--if-exists", "del-br", backupBridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			It("should attach the backup interface to the backup bridge with routes of higher metric", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"backup": {"bridge": "%s"},
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[ {"subnet": "10.1.4.0/24", "gateway": "10.1.4.1"} ]],
+					"routes": [{"dst": "0.0.0.0/0"}],
+					"dataDir": "/tmp/ovs-cni/conf"
+				}
+			}`, version, bridgeName, backupBridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces).To(HaveLen(4))
+				Expect(result.Interfaces[3].Name).To(Equal(IFNAME + "b"))
+				Expect(listBridgePorts(backupBridgeName)).To(Equal([]string{result.Interfaces[2].Name}))
+
+				By("Checking the default route over the backup interface has a higher metric")
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					link, err := netlink.LinkByName(IFNAME + "b")
+					Expect(err).NotTo(HaveOccurred())
+					routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+					Expect(err).NotTo(HaveOccurred())
+					metrics := []int{}
+					for _, route := range routes {
+						if route.Dst == nil || route.Dst.IP.Equal(net.IPv4zero) {
+							metrics = append(metrics, route.Priority)
+						}
+					}
+					Expect(metrics).To(Equal([]int{backupRouteMetric}))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var data bytes.Buffer
+				Expect(result.PrintTo(&data)).To(Succeed())
+				checkConf := map[string]interface{}{}
+				Expect(json.Unmarshal([]byte(conf), &checkConf)).To(Succeed())
+				checkConf["prevResult"] = json.RawMessage(data.Bytes())
+				args.StdinData, err = json.Marshal(checkConf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(Succeed())
+				args.StdinData = []byte(conf)
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				Expect(listBridgePorts(backupBridgeName)).To(BeEmpty())
+			})
+		})
+		Context("with internal interface type", func() {
+			It("should move the internal port into the container netns", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"interface_type": "internal",
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[ {"subnet": "10.1.6.0/24", "gateway": "10.1.6.1"} ]],
+					"dataDir": "/tmp/ovs-cni/conf"
+				}
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				portName := result.Interfaces[0].Name
+				Expect(listBridgePorts(bridgeName)).To(ContainElement(portName))
+
+				By("Checking the port has no interface on the host")
+				_, err = netlink.LinkByName(portName)
+				Expect(err).To(HaveOccurred())
+
+				By("Checking the container interface is the internal port holding the address")
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					link, err := netlink.LinkByName(IFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(link.Type()).To(Equal("openvswitch"))
+					addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addrs).To(HaveLen(1))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var data bytes.Buffer
+				Expect(result.PrintTo(&data)).To(Succeed())
+				checkConf := map[string]interface{}{}
+				Expect(json.Unmarshal([]byte(conf), &checkConf)).To(Succeed())
+				checkConf["prevResult"] = json.RawMessage(data.Bytes())
+				args.StdinData, err = json.Marshal(checkConf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(Succeed())
+				args.StdinData = []byte(conf)
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).NotTo(ContainElement(portName))
+			})
+		})
+		Context("with offload set", func() {
+			It("should apply the settings to both ends of the veth", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"offload": {"tso": false, "gso": false, "tx_checksum": true}
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectOffload := func(ifName string) {
+					defer GinkgoRecover()
+					Expect(ethtool.GetFeature(ifName, ethtool.TSO)).To(BeFalse())
+					Expect(ethtool.GetFeature(ifName, ethtool.GSO)).To(BeFalse())
+					Expect(ethtool.GetFeature(ifName, ethtool.TxChecksum)).To(BeTrue())
+				}
+				expectOffload(result.Interfaces[0].Name)
+				Expect(targetNs.Do(func(ns.NetNS) error {
+					expectOffload(IFNAME)
+					return nil
+				})).To(Succeed())
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+			})
+			It("should fail on interfaces which don't exist", func() {
+				tso := true
+				Expect(setOffload("missing0", &types.Offload{TSO: &tso})).To(MatchError(ContainSubstring("failed to set tso of missing0")))
+				Expect(setOffload("missing0", nil)).To(Succeed())
+			})
+		})
+		Context("with tap set", func() {
+			It("should connect the tap device to the veth of the attachment", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"tap": {"uid": 107, "gid": 107, "queues": 2}
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+					Args:        "MAC=0a:00:00:00:00:81",
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces).To(HaveLen(2))
+				Expect(result.Interfaces[1].Name).To(Equal(IFNAME))
+				Expect(listBridgePorts(bridgeName)).To(ContainElement(result.Interfaces[0].Name))
+
+				By("Checking the tap device and the redirects of the veth")
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					link, err := netlink.LinkByName(IFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					tap, isTap := link.(*netlink.Tuntap)
+					Expect(isTap).To(BeTrue())
+					Expect(tap.Owner).To(Equal(uint32(107)))
+					Expect(tap.Group).To(Equal(uint32(107)))
+					Expect(tap.Flags & netlink.TUNTAP_MULTI_QUEUE).NotTo(BeZero())
+					Expect(tap.Attrs().HardwareAddr.String()).To(Equal("0a:00:00:00:00:81"))
+					veth, err := netlink.LinkByName(tapVethName(args.ContainerID, IFNAME))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(veth.Attrs().HardwareAddr.String()).NotTo(Equal("0a:00:00:00:00:81"))
+					for _, l := range []netlink.Link{veth, link} {
+						filters, err := netlink.FilterList(l, ingressHandle)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(filters).To(HaveLen(1))
+					}
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).NotTo(ContainElement(result.Interfaces[0].Name))
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					_, err := netlink.LinkByName(IFNAME)
+					Expect(err).To(HaveOccurred())
+					_, err = netlink.LinkByName(tapVethName(args.ContainerID, IFNAME))
+					Expect(err).To(HaveOccurred())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+		Context("with infra_netns set", func() {
+			var infraNs ns.NetNS
+			var conf string
+			BeforeEach(func() {
+				infraNs = newNS()
+				conf = fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"infra_netns": "%s"
+			}`, version, bridgeName, infraNs.Path())
+			})
+			AfterEach(func() {
+				closeNS(infraNs)
+			})
+			// infraLinks returns names of the links in the infra netns other
+			// than loopback
+			infraLinks := func() []string {
+				names := []string{}
+				err := infraNs.Do(func(ns.NetNS) error {
+					links, err := netlink.LinkList()
+					if err != nil {
+						return err
+					}
+					for _, l := range links {
+						if l.Attrs().Flags&net.FlagLoopback == 0 {
+							names = append(names, l.Attrs().Name)
+						}
+					}
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+				return names
+			}
+			It("should connect the attachment to the bridge through the infra netns", func() {
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces).To(HaveLen(2))
+				hostIface, contIface := result.Interfaces[0], result.Interfaces[1]
+				Expect(contIface.Name).To(Equal(IFNAME))
+				Expect(contIface.Sandbox).To(Equal(targetNs.Path()))
+				Expect(listBridgePorts(bridgeName)).To(ContainElement(hostIface.Name))
+				_, err = netlink.LinkByName(hostIface.Name)
+				Expect(err).NotTo(HaveOccurred())
+
+				By("Checking the veths and the redirects in the infra netns")
+				infraName := infraVethName(args.ContainerID, IFNAME)
+				links := infraLinks()
+				Expect(links).To(HaveLen(2))
+				Expect(links).To(ContainElement(infraName))
+				peerName := links[0]
+				if peerName == infraName {
+					peerName = links[1]
+				}
+				err = infraNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					for _, name := range []string{infraName, peerName} {
+						l, err := netlink.LinkByName(name)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(l.Attrs().Flags & net.FlagUp).NotTo(BeZero())
+						filters, err := netlink.FilterList(l, ingressHandle)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(filters).To(HaveLen(1))
+					}
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				By("Checking the attachment")
+				var data bytes.Buffer
+				Expect(result.PrintTo(&data)).To(Succeed())
+				checkConf := map[string]interface{}{}
+				Expect(json.Unmarshal([]byte(conf), &checkConf)).To(Succeed())
+				checkConf["prevResult"] = json.RawMessage(data.Bytes())
+				args.StdinData, err = json.Marshal(checkConf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cmdCheckWithArgs(args, func() error {
+					return CmdCheck(args)
+				})).To(Succeed())
+				args.StdinData = []byte(conf)
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).NotTo(ContainElement(hostIface.Name))
+				_, err = netlink.LinkByName(hostIface.Name)
+				Expect(err).To(HaveOccurred())
+				Expect(infraLinks()).To(BeEmpty())
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					_, err := netlink.LinkByName(IFNAME)
+					Expect(err).To(HaveOccurred())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("should replace veths and the port left by a previous ADD", func() {
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				first, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+
+				r, _, err = cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				second, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				ports, err := listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ports).To(ContainElement(second.Interfaces[0].Name))
+				if first.Interfaces[0].Name != second.Interfaces[0].Name {
+					Expect(ports).NotTo(ContainElement(first.Interfaces[0].Name))
+				}
+				Expect(infraLinks()).To(HaveLen(2))
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				Expect(listBridgePorts(bridgeName)).NotTo(ContainElement(second.Interfaces[0].Name))
+				Expect(infraLinks()).To(BeEmpty())
+			})
+			It("should keep a container veth which is not connected to the infra netns", func() {
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				var hostIfName string
+				err := targetNs.Do(func(hostNs ns.NetNS) error {
+					defer GinkgoRecover()
+					hostVeth, _, err := ip.SetupVeth(IFNAME, defaultMTU, "", hostNs)
+					hostIfName = hostVeth.Name
+					return err
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					if link, err := netlink.LinkByName(hostIfName); err == nil {
+						Expect(netlink.LinkDel(link)).To(Succeed())
+					}
 				}()
-
-				OvnPort := "test-port"
-				result := attach(targetNs, conf, IFNAME, "", OvnPort)
-				hostIface := result.Interfaces[0]
-				output, err := exec.Command("ovs-vsctl", "--column=external_ids", "find", "Interface", fmt.Sprintf("name=%s", hostIface.Name)).CombinedOutput()
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err = cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).To(MatchError(ContainSubstring("its peer is not in infra netns")))
+				_, err = netlink.LinkByName(hostIfName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(infraLinks()).To(BeEmpty())
+			})
+			It("should keep a container interface which is not a veth", func() {
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				err := targetNs.Do(func(ns.NetNS) error {
+					return netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: IFNAME}})
+				})
+				Expect(err).NotTo(HaveOccurred())
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err = cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).To(MatchError(ContainSubstring("it is not a veth")))
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					_, err := netlink.LinkByName(IFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					return nil
+				})
 				Expect(err).NotTo(HaveOccurred())
-				Expect(string(output[:len(output)-1])).To(Equal(ovsOutput))
 			})
 		})
-		Context("specified OfportRequest", func() {
-			It("should configure an ovs interface with a specific ofport", func() {
-				// Pick a random ofport 5000-6000
-				ofportRequest := rand.Intn(1000) + 5000
-				ovsOutput := fmt.Sprintf("ofport              : %d", ofportRequest)
-
-				conf := fmt.Sprintf(`{
+		Context("with bond set on two attachments", func() {
+			const secondBridgeName = "test-bond"
+			BeforeEach(func() {
+				output, err := exec.Command("ovs-vsctl", "add-br", secondBridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			AfterEach(func() {
+				output, err := exec.Command("ovs-vsctl", "--if-exists", "del-br", secondBridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+			It("should enslave both interfaces to the bond and remove it with the last one", func() {
+				ipamConf := fmt.Sprintf(`{
 				"cniVersion": "%s",
-				"name": "mynet",
+				"name": "net-a",
 				"type": "ovs",
-				"ofport_request": %d,
-				"bridge": "%s"}`, version, ofportRequest, bridgeName)
-
+				"bridge": "%s",
+				"bond": {"name": "bond0"},
+				"ipam": {
+					"type": "host-local",
+					"ranges": [[ {"subnet": "10.1.5.0/24", "gateway": "10.1.5.1"} ]],
+					"dataDir": "/tmp/ovs-cni/conf"
+				}
+			}`, version, bridgeName)
+				memberConf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "net-b",
+				"type": "ovs",
+				"bridge": "%s",
+				"bond": {"name": "bond0"}
+			}`, version, secondBridgeName)
 				targetNs := newNS()
-				defer func() {
-					closeNS(targetNs)
-				}()
+				defer closeNS(targetNs)
+				argsA := &skel.CmdArgs{ContainerID: "dummy", Netns: targetNs.Path(), IfName: "net1", StdinData: []byte(ipamConf)}
+				argsB := &skel.CmdArgs{ContainerID: "dummy", Netns: targetNs.Path(), IfName: "net2", StdinData: []byte(memberConf)}
 
-				result := attach(targetNs, conf, IFNAME, "", "")
-				hostIface := result.Interfaces[0]
+				r, _, err := cmdAddWithArgs(argsA, func() error {
+					return CmdAdd(argsA)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces).To(HaveLen(3))
+				Expect(result.Interfaces[2].Name).To(Equal("bond0"))
+				Expect(*result.IPs[0].Interface).To(Equal(2))
+				_, _, err = cmdAddWithArgs(argsB, func() error {
+					return CmdAdd(argsB)
+				})
+				Expect(err).NotTo(HaveOccurred())
 
-				// Wait for OVS to actually assign the port, even when the add returns
-				// successfully, ofport can still be blank ("[]") for a short period of
-				// time.
-				Eventually(func() bool {
-					output, err := exec.Command("ovs-vsctl", "--column=ofport", "find", "Interface", fmt.Sprintf("name=%s", hostIface.Name)).CombinedOutput()
+				By("Checking both interfaces are enslaved to the bond holding the address")
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					bond, err := netlink.LinkByName("bond0")
 					Expect(err).NotTo(HaveOccurred())
-					return (string(output[:len(output)-1]) == ovsOutput)
-				}, time.Minute, 100*time.Millisecond).Should(Equal(true))
+					for _, name := range []string{"net1", "net2"} {
+						link, err := netlink.LinkByName(name)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(link.Attrs().MasterIndex).To(Equal(bond.Attrs().Index))
+					}
+					addrs, err := netlink.AddrList(bond, netlink.FAMILY_V4)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addrs).To(HaveLen(1))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				By("Checking the bond is kept until its last member is removed, without the released address")
+				Expect(cmdDelWithArgs(argsA, func() error {
+					return CmdDel(argsA)
+				})).To(Succeed())
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+					bond, err := netlink.LinkByName("bond0")
+					Expect(err).NotTo(HaveOccurred())
+					addrs, err := netlink.AddrList(bond, netlink.FAMILY_V4)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addrs).To(BeEmpty())
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cmdDelWithArgs(argsB, func() error {
+					return CmdDel(argsB)
+				})).To(Succeed())
+				err = targetNs.Do(func(ns.NetNS) error {
+					_, err := netlink.LinkByName("bond0")
+					return err
+				})
+				Expect(err).To(HaveOccurred())
 			})
 		})
-		Context("with interface of type system for port", func() {
-			conf := fmt.Sprintf(`{
+		Context("with stats file set", func() {
+			It("should record final counters of the port on DEL", func() {
+				statsDir, err := os.MkdirTemp("", "ovs-cni-stats-test*")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(statsDir)
+				statsFile := filepath.Join(statsDir, "stats.json")
+				conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
 				"name": "mynet",
 				"type": "ovs",
 				"bridge": "%s",
-				"interface_type": "%s"
-			}`, version, bridgeName, systemType)
-			It("should successfully complete ADD and DEL commands", func() {
+				"stats_file": "%s"
+			}`, version, bridgeName, statsFile)
 				targetNs := newNS()
-				defer func() {
-					closeNS(targetNs)
-				}()
-				hostIfName, result := testAdd(conf, false, false, "", targetNs)
-				testCheck(conf, result, targetNs)
+				defer closeNS(targetNs)
+				hostIfName, _ := testAdd(conf, false, false, "", targetNs)
 				testDel(conf, hostIfName, targetNs, true)
+
+				data, err := os.ReadFile(statsFile)
+				Expect(err).NotTo(HaveOccurred())
+				record := statsRecord{}
+				Expect(json.Unmarshal(data, &record)).To(Succeed())
+				Expect(record.Network).To(Equal("mynet"))
+				Expect(record.IfName).To(Equal(IFNAME))
+				Expect(record.Port).To(Equal(hostIfName))
+				Expect(record.Statistics).To(HaveKey("rx_bytes"))
+				Expect(record.Statistics).To(HaveKey("tx_bytes"))
 			})
 		})
-		Context("without interface type on port", func() {
-			conf := fmt.Sprintf(`{
+		Context("with vhost-user interface type", func() {
+			It("should create the socket dir with configured owner and remove it on DEL", func() {
+				socketDir, err := os.MkdirTemp("", "ovs-cni-vhostuser-test*")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(socketDir)
+				conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
 				"name": "mynet",
 				"type": "ovs",
-				"bridge": "%s"
-			}`, version, bridgeName)
-			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				"bridge": "%s",
+				"interface_type": "dpdkvhostuserclient",
+				"vhost_user": {"socket_dir": "%s", "uid": 1000, "gid": 1001, "queues": {"n_rxq": 2}},
+				"runtimeConfig": {"vhostUserQueues": {"n_txq": 2}}
+			}`, version, bridgeName, socketDir)
 				targetNs := newNS()
-				defer func() {
-					closeNS(targetNs)
-				}()
-				hostIfName, result := testAdd(conf, false, false, "", targetNs)
-				testCheck(conf, result, targetNs)
-				testDel(conf, hostIfName, targetNs, true)
+				defer closeNS(targetNs)
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				_, _, err = cmdAddWithArgs(args, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				attachmentDir := filepath.Join(socketDir, "mynet-dummy-"+IFNAME)
+				info, err := os.Stat(attachmentDir)
+				Expect(err).NotTo(HaveOccurred())
+				stat := info.Sys().(*syscall.Stat_t)
+				Expect(stat.Uid).To(Equal(uint32(1000)))
+				Expect(stat.Gid).To(Equal(uint32(1001)))
+				ports, err := listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ports).To(ContainElement(vhostUserPortName("dummy", IFNAME)))
+				output, err := exec.Command("ovs-vsctl", "get", "Interface", vhostUserPortName("dummy", IFNAME), "options:n_rxq", "options:n_txq").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.Fields(string(output))).To(Equal([]string{`"2"`, `"2"`}))
+
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				_, err = os.Stat(attachmentDir)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+				ports, err = listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ports).NotTo(ContainElement(vhostUserPortName("dummy", IFNAME)))
 			})
 		})
 		Context("specify trunk with multiple ranges", func() {
 			trunks := `[ {"minID": 10, "maxID": 12}, {"minID": 19, "maxID": 20} ]`
 			It("testSplitVlanIds method should return with specifed values in the range", func() {
-				testSplitVlanIds(trunks, []uint{10, 11, 12, 19, 20}, nil, false)
+				testSplitVlanIds(trunks, []uint{10, 11, 12, 19, 20})
 			})
 		})
 		Context("specify trunk with multiple ids", func() {
 			trunks := `[ {"id": 15}, {"id": 19}, {"id": 40} ]`
 			It("testSplitVlanIds method should return with specifed id values", func() {
-				testSplitVlanIds(trunks, []uint{15, 19, 40}, nil, false)
+				testSplitVlanIds(trunks, []uint{15, 19, 40})
 			})
 		})
 		Context("specify trunk with minID/maxID same value and duplicate values", func() {
 			trunks := `[ {"minID": 10, "maxID": 14}, {"id": 11}, {"minID": 13, "maxID": 13} ]`
 			It("testSplitVlanIds method should return without duplicate trunk values", func() {
-				testSplitVlanIds(trunks, []uint{10, 11, 12, 13, 14}, nil, false)
+				testSplitVlanIds(trunks, []uint{10, 11, 12, 13, 14})
+			})
+		})
+		Context("specify trunk with range starting at 0", func() {
+			trunks := `[ {"minID": 0, "maxID": 2}, {"id": 4095} ]`
+			It("testSplitVlanIds method should return all values of the range", func() {
+				testSplitVlanIds(trunks, []uint{0, 1, 2, 4095})
 			})
 		})
-		Context("specify trunk with negative value", func() {
-			trunks := `[ {"id": 15}, {"id": 15}, {"id": -20} ]`
-			It("testSplitVlanIds method should throw appropriate error", func() {
-				testSplitVlanIds(trunks, nil, errors.New("incorrect trunk id parameter"), true)
+
+		Context("with bridge missing on DEL", func() {
+			It("should fail DEL by default", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				testAdd(conf, false, false, "", targetNs)
+
+				By("Removing the bridge")
+				output, err := exec.Command("ovs-vsctl", "del-br", bridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				defer func() {
+					output, err := exec.Command("ovs-vsctl", "add-br", bridgeName).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+				}()
+
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				err = cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})
+				Expect(err).To(HaveOccurred())
+				cniErr, ok := err.(*cnitypes.Error)
+				Expect(ok).To(BeTrue())
+				Expect(cniErr.Code).To(Equal(cnitypes.ErrTryAgainLater))
+			})
+			It("should succeed DEL after retries when del_bridge_retries is set", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"del_bridge_retries": 2,
+				"del_bridge_retry_interval": 100
+			}`, version, bridgeName)
+				targetNs := newNS()
+				defer closeNS(targetNs)
+				hostIfName, _ := testAdd(conf, false, false, "", targetNs)
+
+				By("Removing the bridge")
+				output, err := exec.Command("ovs-vsctl", "del-br", bridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				defer func() {
+					output, err := exec.Command("ovs-vsctl", "add-br", bridgeName).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+				}()
+
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+
+				By("Checking that both sides of the veth pair were deleted")
+				_, err = netlink.LinkByName(hostIfName)
+				Expect(err).To(HaveOccurred())
+				err = targetNs.Do(func(ns.NetNS) error {
+					_, err := netlink.LinkByName(IFNAME)
+					return err
+				})
+				Expect(err).To(HaveOccurred())
 			})
 		})
-		Context("specify trunk with minID greater than maxID", func() {
-			trunks := `[ {"minID": 10, "maxID": 12}, {"minID": 11, "maxID": 5} ]`
-			It("testSplitVlanIds method should throw appropriate error", func() {
-				testSplitVlanIds(trunks, nil, errors.New("minID is greater than maxID in trunk parameter"), false)
+		Context("STATUS command", func() {
+			BeforeEach(func() {
+				if supported, _ := cniversion.GreaterThanOrEqualTo(version, "1.1.0"); !supported {
+					Skip("STATUS is supported since CNI 1.1.0")
+				}
+			})
+			It("should succeed when the bridge is present", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+				Expect(CmdStatus(&skel.CmdArgs{StdinData: []byte(conf)})).To(Succeed())
+			})
+			It("should report the plugin is not available when the bridge is missing", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "missing-bridge"
+			}`, version)
+				err := CmdStatus(&skel.CmdArgs{StdinData: []byte(conf)})
+				Expect(err).To(HaveOccurred())
+				cniErr, ok := err.(*cnitypes.Error)
+				Expect(ok).To(BeTrue())
+				Expect(cniErr.Code).To(Equal(uint(50)))
 			})
 		})
-		Context("specify trunk with maxID greater than 4096", func() {
-			trunks := `[ {"minID": 10, "maxID": 12}, {"minID": 1, "maxID": 5000} ]`
-			It("testSplitVlanIds method should throw appropriate error", func() {
-				testSplitVlanIds(trunks, nil, errors.New("incorrect trunk maxID parameter"), false)
+		Context("GC command", func() {
+			BeforeEach(func() {
+				if supported, _ := cniversion.GreaterThanOrEqualTo(version, "1.1.0"); !supported {
+					Skip("GC is supported since CNI 1.1.0")
+				}
+			})
+			It("should remove attachments which are not valid anymore", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, bridgeName)
+				validNs := newNS()
+				defer closeNS(validNs)
+				staleNs := newNS()
+				defer closeNS(staleNs)
+
+				attach := func(containerID string, targetNs ns.NetNS) {
+					args := &skel.CmdArgs{
+						ContainerID: containerID,
+						Netns:       targetNs.Path(),
+						IfName:      IFNAME,
+						StdinData:   []byte(conf),
+					}
+					_, _, err := cmdAddWithArgs(args, func() error {
+						return CmdAdd(args)
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				attach("gc-valid", validNs)
+				attach("gc-stale", staleNs)
+				ports, err := listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ports).To(HaveLen(2))
+
+				By("Calling GC command")
+				gcConf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"cni.dev/valid-attachments": [{"containerID": "gc-valid", "ifname": "%s"}]
+			}`, version, bridgeName, IFNAME)
+				Expect(CmdGC(&skel.CmdArgs{StdinData: []byte(gcConf)})).To(Succeed())
+
+				By("Checking that only the stale attachment was removed")
+				remaining, err := listBridgePorts(bridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(remaining).To(HaveLen(1))
+				_, found, err := getOvsPortForContIface(mustBridgeDriver(), IFNAME, validNs.Path(), "mynet")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(found).To(BeTrue())
+
+				args := &skel.CmdArgs{
+					ContainerID: "gc-valid",
+					Netns:       validNs.Path(),
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
 			})
 		})
 
@@ -904,6 +2845,52 @@ var testFunc = func(version string) {
 					ContainSubstring(secondHostIface.Name), "OVS port with healthy interface should have been kept")
 			})
 		})
+
+		Context("ports with failed interfaces not created by ovs-cni", func() {
+			const foreignPort = "foreign0"
+			BeforeEach(func() {
+				// the interface doesn't exist, so it ends up in error
+				output, err := exec.Command("ovs-vsctl", "add-port", bridgeName, foreignPort).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				waitForIfaceError(foreignPort, 10, 100*time.Millisecond)
+				// don't let the backoff of removal delay the next test
+				Expect(utils.CleanCache(cleanPortsBackoffKey)).To(Succeed())
+			})
+			del := func(conf string) {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				attach(targetNs, conf, IFNAME, "", "")
+				// without network namespace DEL only removes ports in error
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					IfName:      IFNAME,
+					StdinData:   []byte(conf),
+				}
+				Expect(cmdDelWithArgs(args, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+			}
+
+			It("DEL keeps them", func() {
+				del(fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"}`, version, bridgeName))
+				Expect(listBridgePorts(bridgeName)).To(ContainElement(foreignPort))
+			})
+			It("DEL removes them when forced", func() {
+				del(fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"force_port_removal": true,
+				"bridge": "%s"}`, version, bridgeName))
+				Expect(listBridgePorts(bridgeName)).NotTo(ContainElement(foreignPort))
+			})
+		})
 	})
 }
 
@@ -963,6 +2950,12 @@ func cmdDelWithArgs(args *skel.CmdArgs, f func() error) error {
 	return testutils.CmdDel(args.Netns, args.ContainerID, args.IfName, f)
 }
 
+func mustBridgeDriver() *ovsdb.OvsBridgeDriver {
+	driver, err := ovsdb.NewOvsBridgeDriver(bridgeName, "")
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return driver
+}
+
 func listBridgePorts(brName string) ([]string, error) {
 	output, err := exec.Command("ovs-vsctl", "list-ports", brName).CombinedOutput()
 	if err != nil {
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// stablePortNameSuffixes are appended to the port name when it is taken, e.g.
+// by the port of a previous sandbox of the pod
+var stablePortNameSuffixes = []string{"", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+
+// portNameHash returns the {hash} field of port name templates derived from
+// the pod
+func (p podIdentity) portNameHash() string {
+	hash := sha256.Sum256([]byte(p.namespace + "/" + p.name + "/" + p.ifName))
+	return hex.EncodeToString(hash[:])[:10]
+}
+
+// portNameTemplate returns the template of the host veth name of the
+// attachment, stable_port_names is the same as veth{hash}
+func portNameTemplate(netconf *types.NetConf) string {
+	if netconf.StablePortNames {
+		return config.StablePortNameTemplate
+	}
+	return netconf.PortNameTemplate
+}
+
+// templatePortName returns name of the host veth, and so of the OVS port, of
+// the attachment expanded from its port name template, so ports can be
+// correlated with pods in flow exports, sFlow records and OVSDB dumps. A
+// suffix is added when the name is already used by a link or a port. An empty
+// one, i.e. a random name, is returned when all names with a suffix are taken
+// as well or the template uses fields which are not known, e.g. the pod
+// outside Kubernetes.
+func templatePortName(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, containerID string, pod podIdentity) (string, error) {
+	fields := map[string]string{
+		config.PortNameFieldPodName:      pod.name,
+		config.PortNameFieldPodNamespace: pod.namespace,
+		config.PortNameFieldIfName:       pod.ifName,
+		config.PortNameFieldNetwork:      netconf.Name,
+		config.PortNameFieldContainerID:  containerID,
+	}
+	if pod.known() {
+		fields[config.PortNameFieldHash] = pod.portNameHash()
+	}
+	name, err := freePortName(portNameTaken(ovsDriver), func(suffix string) (string, error) {
+		name, err := config.ExpandPortNameTemplate(portNameTemplate(netconf), fields, config.MaxPortNameLength-len(suffix))
+		return name + suffix, err
+	})
+	var expandErr *portNameExpandError
+	if errors.As(err, &expandErr) {
+		log.Printf("Warning: using a random port name, %v", expandErr.err)
+		return "", nil
+	}
+	return name, err
+}
+
+// portNameExpandError wraps failures to derive the port name, as opposed to
+// all derived names being taken
+type portNameExpandError struct {
+	err error
+}
+
+func (e *portNameExpandError) Error() string {
+	return e.err.Error()
+}
+
+// portNameTaken returns whether the name is used by a link or a port
+func portNameTaken(ovsDriver *ovsdb.OvsBridgeDriver) func(name string) bool {
+	return func(name string) bool {
+		if _, err := netif.Default.LinkByName(name); err == nil {
+			return true
+		}
+		_, err := ovsDriver.GetPortUUID(name)
+		return err == nil
+	}
+}
+
+// freePortName returns the first name derived from the suffixes which is not
+// taken. When all of them are, e.g. by ports leaked by failed sandboxes, an
+// empty one, i.e. a random name, is returned so ADD doesn't fail.
+func freePortName(taken func(name string) bool, nameWithSuffix func(suffix string) (string, error)) (string, error) {
+	for _, suffix := range stablePortNameSuffixes {
+		name, err := nameWithSuffix(suffix)
+		if err != nil {
+			return "", &portNameExpandError{err: err}
+		}
+		if !taken(name) {
+			return name, nil
+		}
+	}
+	name, _ := nameWithSuffix("")
+	log.Printf("Warning: all port names derived from %s are taken, using a random port name", name)
+	return "", nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("freePortName", func() {
+	withSuffix := func(suffix string) (string, error) {
+		return "vethabc" + suffix, nil
+	}
+	takenNames := func(names ...string) func(string) bool {
+		return func(name string) bool {
+			for _, taken := range names {
+				if name == taken {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	It("should return the name without a suffix when it is free", func() {
+		Expect(freePortName(takenNames(), withSuffix)).To(Equal("vethabc"))
+	})
+	It("should append the first free suffix", func() {
+		Expect(freePortName(takenNames("vethabc", "vethabc1"), withSuffix)).To(Equal("vethabc2"))
+	})
+	It("should fall back to a random name when all names are taken", func() {
+		allTaken := func(string) bool { return true }
+		Expect(freePortName(allTaken, withSuffix)).To(BeEmpty())
+	})
+	It("should report failures to derive the name", func() {
+		_, err := freePortName(takenNames(), func(string) (string, error) {
+			return "", errors.New("no pod name")
+		})
+		var expandErr *portNameExpandError
+		Expect(errors.As(err, &expandErr)).To(BeTrue())
+	})
+})
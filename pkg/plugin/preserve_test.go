@@ -0,0 +1,90 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build simulation
+
+package plugin
+
+import (
+	"github.com/containernetworking/plugins/pkg/ns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+)
+
+var _ = Describe("Reuse of the container interface", func() {
+	const bridge = "br-preserve"
+	const contNetnsPath = "/var/run/netns/simulated-preserve"
+	var contNetns ns.NetNS
+	var driver *ovsdb.OvsBridgeDriver
+
+	BeforeEach(func() {
+		netif.Default = netif.NewSimulated()
+		contNetns = netns.NewSimulated(contNetnsPath)
+		DeferCleanup(netns.DeleteSimulated, contNetnsPath)
+		fake, err := testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(fake.Close)
+		driver, err = ovsdb.NewOvsBridgeDriver(bridge, fake.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should not reuse a missing interface", func() {
+		hostIface, contIface, err := reusableContIface(driver, contNetns, "net1", "mynet")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hostIface).To(BeNil())
+		Expect(contIface).To(BeNil())
+	})
+	It("should reuse the interface of the same attachment and remove its port", func() {
+		hostVeth, _, err := setupVeth(contNetns, "net1", "", "", 1500)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driver.CreatePort(ovsdb.PortOptions{Name: hostVeth.Name, ContNetns: contNetnsPath, ContIface: "net1", ContNetwork: "mynet"})).To(Succeed())
+
+		hostIface, contIface, err := reusableContIface(driver, contNetns, "net1", "mynet")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hostIface.Name).To(Equal(hostVeth.Name))
+		Expect(contIface.Name).To(Equal("net1"))
+		Expect(contIface.Sandbox).To(Equal(contNetnsPath))
+		_, found, err := driver.GetOvsPortForContIface("net1", contNetnsPath, "mynet")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+	It("should reuse an interface whose peer is attached nowhere", func() {
+		hostVeth, _, err := setupVeth(contNetns, "net1", "", "", 1500)
+		Expect(err).NotTo(HaveOccurred())
+		hostIface, _, err := reusableContIface(driver, contNetns, "net1", "mynet")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hostIface.Name).To(Equal(hostVeth.Name))
+	})
+	It("should not reuse an interface whose peer is in use", func() {
+		hostVeth, _, err := setupVeth(contNetns, "net1", "", "", 1500)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netif.Default.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}})).To(Succeed())
+		peer, err := netif.Default.LinkByName(hostVeth.Name)
+		Expect(err).NotTo(HaveOccurred())
+		master, err := netif.Default.LinkByName("br0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netif.Default.LinkSetMaster(peer, master)).To(Succeed())
+
+		hostIface, contIface, err := reusableContIface(driver, contNetns, "net1", "mynet")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hostIface).To(BeNil())
+		Expect(contIface).To(BeNil())
+	})
+})
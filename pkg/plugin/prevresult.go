@@ -0,0 +1,61 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/version"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// missingPrevResultPolicy returns how CHECK handles a missing prevResult, by
+// default it fails for CNI 1.0.0 and newer which require it
+func missingPrevResultPolicy(netconf *types.NetConf) string {
+	if netconf.MissingPrevResult != "" {
+		return netconf.MissingPrevResult
+	}
+	if newer, err := version.GreaterThanOrEqualTo(netconf.CNIVersion, "1.0.0"); err == nil && !newer {
+		return config.MissingPrevResultWarn
+	}
+	return config.MissingPrevResultFail
+}
+
+// checkWithoutPrevResult validates only the OVS port of the attachment when
+// the runtime didn't pass prevResult, the port is found by the identity of
+// the attachment stored in its external IDs. It returns name of the port.
+func checkWithoutPrevResult(ovsBridgeDriver *ovsdb.OvsBridgeDriver, args *skel.CmdArgs, netconf *types.NetConf) (string, error) {
+	if missingPrevResultPolicy(netconf) == config.MissingPrevResultFail {
+		return "", fmt.Errorf("Required prevResult missing")
+	}
+	log.Printf("Warning: prevResult of %s is missing, only its OVS port is checked", args.IfName)
+
+	portName, found, err := getOvsPortForContIface(ovsBridgeDriver, args.IfName, args.Netns, netconf.Name)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("OVS port of %s is not found on bridge %s", args.IfName, netconf.BrName)
+	}
+	if err := validateOvs(ovsBridgeDriver, netconf, portName); err != nil {
+		return "", withBridgeState(err, ovsBridgeDriver, netconf)
+	}
+	return portName, nil
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Missing prevResult", func() {
+	netconf := func(cniVersion, policy string) *types.NetConf {
+		return &types.NetConf{NetConf: cnitypes.NetConf{CNIVersion: cniVersion}, MissingPrevResult: policy}
+	}
+
+	It("should fail by default for versions requiring prevResult", func() {
+		Expect(missingPrevResultPolicy(netconf("1.0.0", ""))).To(Equal(config.MissingPrevResultFail))
+		Expect(missingPrevResultPolicy(netconf("1.1.0", ""))).To(Equal(config.MissingPrevResultFail))
+	})
+	It("should warn by default for older versions", func() {
+		Expect(missingPrevResultPolicy(netconf("0.4.0", ""))).To(Equal(config.MissingPrevResultWarn))
+	})
+	It("should follow the configured policy", func() {
+		Expect(missingPrevResultPolicy(netconf("1.0.0", config.MissingPrevResultWarn))).To(Equal(config.MissingPrevResultWarn))
+		Expect(missingPrevResultPolicy(netconf("0.4.0", config.MissingPrevResultFail))).To(Equal(config.MissingPrevResultFail))
+	})
+	It("should fail CHECK without prevResult when required", func() {
+		_, err := checkWithoutPrevResult(nil, nil, netconf("1.0.0", ""))
+		Expect(err).To(MatchError("Required prevResult missing"))
+	})
+})
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// ICMP message types of echo requests and replies
+const (
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// probeTarget returns the address the connectivity probe pings, the
+// configured target or the first gateway of the result
+func probeTarget(probe *types.Probe, result *current.Result) net.IP {
+	if probe.Target != "" {
+		return net.ParseIP(probe.Target)
+	}
+	for _, ipc := range result.IPs {
+		if ipc.Gateway != nil {
+			return ipc.Gateway
+		}
+	}
+	return nil
+}
+
+// runProbe pings the probe target from the container netns and logs the
+// result, so a missing VLAN on the fabric side shows up right after ADD
+// instead of when the application fails. It never fails the ADD.
+func runProbe(probe *types.Probe, contNetns ns.NetNS, ifName string, result *current.Result) {
+	target := probeTarget(probe, result)
+	if target == nil {
+		log.Printf("Warning: connectivity probe of %s skipped, the result has no gateway to ping", ifName)
+		return
+	}
+	timeout := time.Duration(probe.Timeout) * time.Millisecond
+	var rtt time.Duration
+	var err error
+	for i := 0; i < probe.Count; i++ {
+		err = netns.Do(contNetns, func(_ ns.NetNS) error {
+			rtt, err = ping(target, uint16(i), timeout)
+			return err
+		})
+		if err == nil {
+			log.Printf("Connectivity probe of %s: %s replied in %v", ifName, target, rtt)
+			return
+		}
+	}
+	log.Printf("Warning: connectivity probe of %s failed, %s did not reply to %d echo requests: %v", ifName, target, probe.Count, err)
+}
+
+// ping sends an ICMP echo request to the target and waits for its reply,
+// must run in the netns the request is sent from
+func ping(target net.IP, seq uint16, timeout time.Duration) (time.Duration, error) {
+	network, request, reply := "ip6:ipv6-icmp", byte(icmpv6EchoRequest), byte(icmpv6EchoReply)
+	if target.To4() != nil {
+		network, request, reply = "ip4:icmp", icmpv4EchoRequest, icmpv4EchoReply
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to open ICMP socket: %v", err)
+	}
+	defer conn.Close()
+
+	id := uint16(os.Getpid())
+	msg := make([]byte, 8)
+	msg[0] = request
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	if target.To4() != nil {
+		// the kernel computes the checksum of ICMPv6 messages only
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+
+	start := time.Now()
+	if err := conn.SetDeadline(start.Add(timeout)); err != nil {
+		return 0, err
+	}
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: target}); err != nil {
+		return 0, fmt.Errorf("failed to send echo request: %v", err)
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		addr, ok := peer.(*net.IPAddr)
+		if !ok || !addr.IP.Equal(target) || n < 8 || buf[0] != reply {
+			continue
+		}
+		if binary.BigEndian.Uint16(buf[4:]) == id && binary.BigEndian.Uint16(buf[6:]) == seq {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// icmpChecksum returns the internet checksum of the message
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
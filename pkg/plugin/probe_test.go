@@ -0,0 +1,100 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !simulation
+
+package plugin
+
+import (
+	"net"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("connectivity probe", func() {
+	It("should compute checksum of ICMP messages", func() {
+		Expect(icmpChecksum([]byte{8, 0, 0, 0, 0x12, 0x34, 0, 1})).To(Equal(uint16(0xe5ca)))
+		Expect(icmpChecksum([]byte{0xff})).To(Equal(uint16(0x00ff)))
+	})
+	It("should ping the configured target or the gateway", func() {
+		result := &current.Result{IPs: []*current.IPConfig{
+			{Address: net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(64, 128)}},
+			{Address: net.IPNet{IP: net.ParseIP("10.1.0.5"), Mask: net.CIDRMask(24, 32)}, Gateway: net.ParseIP("10.1.0.1")},
+		}}
+		Expect(probeTarget(&types.Probe{}, result)).To(Equal(net.ParseIP("10.1.0.1")))
+		Expect(probeTarget(&types.Probe{Target: "10.1.0.2"}, result)).To(Equal(net.ParseIP("10.1.0.2")))
+		Expect(probeTarget(&types.Probe{}, &current.Result{})).To(BeNil())
+	})
+	Context("with a peer", func() {
+		var contNetns, peerNetns ns.NetNS
+		BeforeEach(func() {
+			contNetns = newNS()
+			peerNetns = newNS()
+			Expect(contNetns.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "peer0"}
+				Expect(netlink.LinkAdd(veth)).To(Succeed())
+				peer, err := netlink.LinkByName("peer0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetNsFd(peer, int(peerNetns.Fd()))).To(Succeed())
+				configureProbeLink("eth0", "10.9.0.1/24", "fd09::1/64")
+				return nil
+			})).To(Succeed())
+			Expect(peerNetns.Do(func(ns.NetNS) error {
+				defer GinkgoRecover()
+				configureProbeLink("peer0", "10.9.0.2/24", "fd09::2/64")
+				return nil
+			})).To(Succeed())
+		})
+		AfterEach(func() {
+			closeNS(contNetns)
+			closeNS(peerNetns)
+		})
+		It("should get replies of the peer", func() {
+			Expect(contNetns.Do(func(ns.NetNS) error {
+				_, err := ping(net.ParseIP("10.9.0.2"), 1, time.Second)
+				return err
+			})).To(Succeed())
+			Expect(contNetns.Do(func(ns.NetNS) error {
+				_, err := ping(net.ParseIP("fd09::2"), 2, time.Second)
+				return err
+			})).To(Succeed())
+		})
+		It("should time out without replies", func() {
+			Expect(contNetns.Do(func(ns.NetNS) error {
+				_, err := ping(net.ParseIP("10.9.0.3"), 1, 100*time.Millisecond)
+				return err
+			})).To(MatchError(ContainSubstring("timeout")))
+		})
+	})
+})
+
+func configureProbeLink(name string, addrs ...string) {
+	link, err := netlink.LinkByName(name)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	for _, addr := range addrs {
+		ipNet, err := netlink.ParseIPNet(addr)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		ExpectWithOffset(1, netlink.AddrAdd(link, &netlink.Addr{IPNet: ipNet, Flags: unix.IFA_F_NODAD})).To(Succeed())
+	}
+	ExpectWithOffset(1, netlink.LinkSetUp(link)).To(Succeed())
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build simulation
+
+package plugin
+
+import (
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+)
+
+var _ = Describe("Port quarantine", func() {
+	const bridge = "br-quarantine"
+	const contNetnsPath = "/var/run/netns/simulated-quarantine"
+	var contNetns ns.NetNS
+	var fake *testhelpers.FakeOVSDB
+	var driver *ovsdb.OvsBridgeDriver
+	var portName, hostName, peerName string
+	args := &skel.CmdArgs{ContainerID: "cid", Netns: contNetnsPath, IfName: "net1"}
+
+	BeforeEach(func() {
+		netif.Default = netif.NewSimulated()
+		contNetns = netns.NewSimulated(contNetnsPath)
+		DeferCleanup(netns.DeleteSimulated, contNetnsPath)
+		hostIface, _, err := setupVeth(contNetns, args.IfName, "", "", 1500)
+		Expect(err).NotTo(HaveOccurred())
+		portName = hostIface.Name
+		hostName, peerName = quarantineIfNames(portName)
+
+		fake, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(fake.Close)
+		driver, err = ovsdb.NewOvsBridgeDriver(bridge, fake.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driver.CreatePort(ovsdb.PortOptions{Name: portName, ContNetns: contNetnsPath, ContIface: args.IfName, ContNetwork: "mynet"})).To(Succeed())
+	})
+
+	// expectLinks expects the host netns to have exactly the host links and
+	// the container netns to have the container link or not
+	expectLinks := func(hostLinks []string, missingHostLinks []string, contLinkPresent bool) {
+		for _, name := range hostLinks {
+			_, err := netif.Default.LinkByName(name)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred(), name)
+		}
+		for _, name := range missingHostLinks {
+			_, err := netif.Default.LinkByName(name)
+			ExpectWithOffset(1, netif.IsNotFound(err)).To(BeTrue(), name)
+		}
+		ExpectWithOffset(1, netns.Do(contNetns, func(ns.NetNS) error {
+			_, err := netif.Default.LinkByName(args.IfName)
+			if contLinkPresent {
+				ExpectWithOffset(2, err).NotTo(HaveOccurred())
+			} else {
+				ExpectWithOffset(2, netif.IsNotFound(err)).To(BeTrue())
+			}
+			return nil
+		})).To(Succeed())
+	}
+
+	It("should move and rename both ends of the veth pair and the port", func() {
+		Expect(quarantinePort(driver, portName, args, 60)).To(Succeed())
+		expectLinks([]string{hostName, peerName}, []string{portName}, false)
+		_, err := driver.GetPortUUID(hostName)
+		Expect(err).NotTo(HaveOccurred())
+		expired, err := driver.FindExpiredQuarantinedPorts(time.Now().Add(2 * time.Minute))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expired).To(ConsistOf(hostName))
+	})
+	It("should restore the veth pair when the port can't be quarantined", func() {
+		// quarantine is refused in least privilege mode, after both ends
+		// of the veth pair were renamed
+		driver.LeastPrivilege = true
+		Expect(quarantinePort(driver, portName, args, 60)).To(MatchError(ContainSubstring("least privilege")))
+		expectLinks([]string{portName}, []string{hostName, peerName}, true)
+	})
+	It("should restore the container interface when the host interface can't be renamed", func() {
+		Expect(netif.Default.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: hostName}})).To(Succeed())
+		Expect(quarantinePort(driver, portName, args, 60)).NotTo(Succeed())
+		expectLinks([]string{portName, hostName}, []string{peerName}, true)
+		_, err := driver.GetPortUUID(portName)
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should keep the container interface when its quarantine name is taken", func() {
+		Expect(netif.Default.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: peerName}})).To(Succeed())
+		Expect(quarantinePort(driver, portName, args, 60)).To(MatchError(ContainSubstring("failed to move container iface net1")))
+		expectLinks([]string{portName, peerName}, []string{hostName}, true)
+	})
+	It("should derive distinct names of templated ports sharing a prefix", func() {
+		host1, peer1 := quarantineIfNames("mypod-abcdef-net1")
+		host2, peer2 := quarantineIfNames("mypod-abcdef-net2")
+		Expect(host1).NotTo(Equal(host2))
+		Expect(peer1).NotTo(Equal(peer2))
+		for _, name := range []string{host1, peer1} {
+			Expect(len(name)).To(BeNumerically("<=", 15))
+		}
+	})
+})
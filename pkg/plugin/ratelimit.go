@@ -0,0 +1,174 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// ingressHandle is the handle of the ingress qdisc holding the police filter
+var ingressHandle = netlink.MakeHandle(0xffff, 0)
+
+// setupRateLimit polices traffic sent by the container on its port, either
+// by OVS or by a tc filter on the VF representor, which NICs can offload
+// when they don't offload OVS policing, and shapes traffic received by the
+// container by a QoS of the port
+func setupRateLimit(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, portName string) error {
+	if rateLimit := netconf.RateLimit; rateLimit != nil {
+		if rateLimit.Method == config.RateLimitMethodTC {
+			if err := setupTCPolice(portName, rateLimit.Rate, rateLimit.Burst); err != nil {
+				return err
+			}
+		} else if err := ovsBridgeDriver.SetIngressPolicing(portName, rateLimit.Rate, rateLimit.Burst); err != nil {
+			return fmt.Errorf("failed to set rate limit of port %s: %v", portName, err)
+		}
+	}
+	if rateLimit := netconf.IngressRateLimit; rateLimit != nil {
+		qosType, qosConfig, queueConfig := portQoS(netconf.InterfaceType, rateLimit)
+		if err := ovsBridgeDriver.SetPortQoS(portName, qosType, qosConfig, queueConfig); err != nil {
+			return fmt.Errorf("failed to set ingress rate limit of port %s: %v", portName, err)
+		}
+	}
+	if netconf.QoS != nil {
+		qosType, qosConfig, queueConfig := attachmentQoS(netconf.QoS)
+		if err := ovsBridgeDriver.SetPortQoS(portName, qosType, qosConfig, queueConfig); err != nil {
+			return fmt.Errorf("failed to set qos of port %s: %v", portName, err)
+		}
+	}
+	return nil
+}
+
+// attachmentQoS returns type and configuration of QoS and its default queue
+// of the qos of the attachment, rates of OVS are in bits per second
+func attachmentQoS(qos *types.QoS) (string, map[string]string, map[string]string) {
+	qosType := qos.Type
+	if qosType == "" {
+		qosType = config.QoSTypeHTB
+	}
+	qosConfig := map[string]string{}
+	queueConfig := map[string]string{}
+	if qos.MaxRate != 0 {
+		maxRate := strconv.FormatUint(uint64(qos.MaxRate)*1000, 10)
+		qosConfig["max-rate"] = maxRate
+		queueConfig["max-rate"] = maxRate
+	}
+	if qos.MinRate != 0 {
+		queueConfig["min-rate"] = strconv.FormatUint(uint64(qos.MinRate)*1000, 10)
+	}
+	if qos.Burst != 0 {
+		queueConfig["burst"] = strconv.FormatUint(uint64(qos.Burst)*1000, 10)
+	}
+	if qos.Priority != nil {
+		queueConfig["priority"] = strconv.FormatUint(uint64(*qos.Priority), 10)
+	}
+	return qosType, qosConfig, queueConfig
+}
+
+// portQoS returns type and configuration of QoS and its default queue
+// limiting the rate OVS sends to the port, DPDK ports support only the
+// egress-policer in bytes
+func portQoS(interfaceType string, rateLimit *types.IngressRateLimit) (string, map[string]string, map[string]string) {
+	rate := uint64(rateLimit.Rate) * 1000
+	burst := uint64(rateLimit.Burst) * 1000
+	if strings.HasPrefix(interfaceType, "dpdk") {
+		return ovsdb.QoSTypeEgressPolicer, map[string]string{
+			"cir": strconv.FormatUint(rate/8, 10),
+			"cbs": strconv.FormatUint(burst/8, 10),
+		}, nil
+	}
+	return config.QoSTypeHTB, map[string]string{
+		"max-rate": strconv.FormatUint(rate, 10),
+	}, map[string]string{
+		"max-rate": strconv.FormatUint(rate, 10),
+		"burst":    strconv.FormatUint(burst, 10),
+	}
+}
+
+// teardownRateLimit removes the tc filter from the representor and QoS of
+// the port, they outlive the attachment unlike OVS policing removed together
+// with the port
+func teardownRateLimit(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, portName string) {
+	if netconf.IngressRateLimit != nil || netconf.QoS != nil {
+		if err := ovsBridgeDriver.DeletePortQoS(portName); err != nil {
+			log.Printf("Failed best-effort cleanup of QoS of %s: %v", portName, err)
+		}
+	}
+	if netconf.RateLimit == nil || netconf.RateLimit.Method != config.RateLimitMethodTC {
+		return
+	}
+	link, err := netif.Default.LinkByName(portName)
+	if err == nil {
+		err = netif.Default.QdiscDel(ingressQdisc(link))
+	}
+	if err != nil {
+		log.Printf("Failed best-effort cleanup of rate limit of %s: %v", portName, err)
+	}
+}
+
+func ingressQdisc(link netlink.Link) *netlink.Ingress {
+	return &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    ingressHandle,
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+}
+
+// setupTCPolice adds matchall filter with police action to the ingress qdisc
+// of the link, rate is in kbps and burst in kilobits
+func setupTCPolice(linkName string, rate, burst uint) error {
+	link, err := netif.Default.LinkByName(linkName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", linkName, err)
+	}
+	// start with an empty qdisc, filters of a previous attachment of the VF
+	// are removed with the old one
+	qdisc := ingressQdisc(link)
+	_ = netif.Default.QdiscDel(qdisc)
+	if err := netif.Default.QdiscAdd(qdisc); err != nil {
+		return fmt.Errorf("failed to add ingress qdisc to %s: %v", linkName, err)
+	}
+
+	police := netlink.NewPoliceAction()
+	police.Rate = uint32(rate * 1000 / 8)
+	police.Burst = uint32(burst * 1000 / 8)
+	police.ExceedAction = netlink.TC_POLICE_SHOT
+	police.NotExceedAction = netlink.TC_POLICE_OK
+	filter := &netlink.MatchAll{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    ingressHandle,
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Actions: []netlink.Action{police},
+	}
+	if err := netif.Default.FilterAdd(filter); err != nil {
+		return fmt.Errorf("failed to add police filter to %s: %v", linkName, err)
+	}
+	return nil
+}
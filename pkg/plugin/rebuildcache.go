@@ -0,0 +1,162 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// RebuiltCache is the outcome of RebuildCache
+type RebuiltCache struct {
+	// Restored are keys of the cache entries written
+	Restored []string
+	// Skipped are ports which were not restored with the reason
+	Skipped map[string]string
+}
+
+// RebuildCache recreates cache entries of attachments from external_ids of
+// their ports, so DEL and CHECK keep working when the cache directory was
+// lost while the OVS configuration was preserved, e.g. on a node reimage.
+// netconfs are network configurations by their name. An attachment of a
+// network without one is cached with a netconf naming only its network and
+// bridge, its DEL removes the port and the veth but can't release addresses
+// of the attachment. Existing entries are kept, ports created without the
+// container ID, i.e. by older versions, and ports of VFs are skipped.
+func RebuildCache(socketFile, keyPrefix string, netconfs map[string]*types.NetConf) (*RebuiltCache, error) {
+	ovsDriver, err := ovsdb.NewOvsDriver(socketFile)
+	if err != nil {
+		return nil, err
+	}
+	ovsDriver.KeyPrefix = keyPrefix
+	ports, err := ovsDriver.GetOwnedPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	rebuilt := &RebuiltCache{Skipped: map[string]string{}}
+	var vethPorts []ovsdb.OwnedPort
+	for _, port := range ports {
+		if port.InterfaceType == "" {
+			// the original name of a VF is not recorded, it can't be
+			// restored on DEL
+			if link, err := netif.Default.LinkByName(port.Name); err == nil && link.Type() != "veth" {
+				rebuilt.Skipped[port.Name] = fmt.Sprintf("interface of type %s is not a veth", link.Type())
+				continue
+			}
+		}
+		vethPorts = append(vethPorts, port)
+	}
+
+	entries, skipped := cacheEntriesOfPorts(vethPorts, netconfs, socketFile)
+	for name, reason := range skipped {
+		rebuilt.Skipped[name] = reason
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := utils.ReadCache(key); err == nil {
+			log.Printf("Info: attachment %s is already cached", key)
+			continue
+		}
+		if err := utils.SaveCache(key, entries[key]); err != nil {
+			return rebuilt, fmt.Errorf("error saving NetConf %q", err)
+		}
+		rebuilt.Restored = append(rebuilt.Restored, key)
+	}
+	return rebuilt, nil
+}
+
+// cacheEntriesOfPorts returns cache entries of attachments of the ports by
+// their keys and the ports which are skipped with the reason. Backup ports
+// and ports of userspace IPAM belong to the entry of their attachment.
+func cacheEntriesOfPorts(ports []ovsdb.OwnedPort, netconfs map[string]*types.NetConf, socketFile string) (map[string]*types.CachedNetConf, map[string]string) {
+	entries := map[string]*types.CachedNetConf{}
+	skipped := map[string]string{}
+	hostPorts := map[string]string{}
+	userspaceIPAMPorts := map[string]bool{}
+	for _, port := range ports {
+		containerID := port.ExternalIDs[ovsdb.ContainerIDKey]
+		network := port.ExternalIDs["contNetwork"]
+		ifName := port.ExternalIDs["contIface"]
+		switch {
+		case port.ExternalIDs[ovsdb.QuarantineExpiryKey] != "":
+			skipped[port.Name] = "port is quarantined"
+			continue
+		case containerID == "":
+			skipped[port.Name] = "ID of the container is not recorded"
+			continue
+		case network == "" || ifName == "":
+			skipped[port.Name] = "network or interface of the container is not recorded"
+			continue
+		case port.InterfaceType == "internal" && ifName == port.Name:
+			// port of userspace IPAM, recorded in the entry of its attachment
+			userspaceIPAMPorts[port.Name] = true
+			continue
+		}
+
+		netconf := &types.NetConf{SocketFile: socketFile}
+		if configured, found := netconfs[network]; found {
+			copied := *configured
+			netconf = &copied
+		}
+		netconf.Name = network
+		netconf.BrName = port.Bridge
+		if port.InterfaceType == config.VhostUserInterfaceType {
+			netconf.InterfaceType = port.InterfaceType
+		}
+		config.ApplyDefaults(netconf)
+		key := config.GetNetworkCRef(network, containerID, ifName)
+		entries[key] = &types.CachedNetConf{
+			Netconf:     netconf,
+			ContainerID: containerID,
+			IfName:      ifName,
+			Netns:       port.ExternalIDs["contNetns"],
+		}
+		if port.ExternalIDs[ovsdb.VlanTranslationKey] != "" {
+			entries[key].VlanTranslationPort = port.Name
+		}
+		hostPorts[key] = port.Name
+	}
+
+	for _, entry := range entries {
+		if portName := userspaceIPAMPortName(entry.Netconf.Name, entry.ContainerID, entry.IfName); userspaceIPAMPorts[portName] {
+			entry.UserspaceIPAMPort = portName
+		}
+	}
+
+	// backup interfaces are attached like separate attachments of the network
+	for key, entry := range entries {
+		if entry.Netconf.Backup == nil {
+			continue
+		}
+		backupKey := config.GetNetworkCRef(entry.Netconf.Name, entry.ContainerID, backupIfName(entry.Netconf, entry.IfName))
+		if _, found := entries[backupKey]; found && backupKey != key {
+			entry.BackupPort = hostPorts[backupKey]
+			delete(entries, backupKey)
+		}
+	}
+	return entries, skipped
+}
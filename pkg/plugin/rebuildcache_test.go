@@ -0,0 +1,107 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("cacheEntriesOfPorts", func() {
+	port := func(name, network, containerID, ifName string) ovsdb.OwnedPort {
+		return ovsdb.OwnedPort{
+			Name:   name,
+			Bridge: "br1",
+			ExternalIDs: map[string]string{
+				"contNetwork":        network,
+				"contIface":          ifName,
+				"contNetns":          "/var/run/netns/" + containerID,
+				ovsdb.ContainerIDKey: containerID,
+			},
+		}
+	}
+	It("should cache attachments with the netconf of their network", func() {
+		vlan := uint(100)
+		netconfs := map[string]*types.NetConf{"net1": {BrName: "br0", VlanTag: &vlan}}
+		entries, skipped := cacheEntriesOfPorts([]ovsdb.OwnedPort{
+			port("veth1", "net1", "c1", "eth1"),
+			port("veth2", "net2", "c1", "eth2"),
+		}, netconfs, "")
+		Expect(skipped).To(BeEmpty())
+		Expect(entries).To(HaveLen(2))
+
+		entry := entries["net1-c1-eth1"]
+		Expect(entry.ContainerID).To(Equal("c1"))
+		Expect(entry.IfName).To(Equal("eth1"))
+		Expect(entry.Netns).To(Equal("/var/run/netns/c1"))
+		Expect(entry.Netconf.Name).To(Equal("net1"))
+		Expect(entry.Netconf.BrName).To(Equal("br1"))
+		Expect(*entry.Netconf.VlanTag).To(Equal(vlan))
+		Expect(netconfs["net1"].BrName).To(Equal("br0"))
+
+		entry = entries["net2-c1-eth2"]
+		Expect(entry.Netconf.Name).To(Equal("net2"))
+		Expect(entry.Netconf.BrName).To(Equal("br1"))
+		Expect(entry.Netconf.VlanTag).To(BeNil())
+	})
+	It("should cache backup ports with their attachment", func() {
+		netconfs := map[string]*types.NetConf{"net1": {Backup: &types.Backup{Bridge: "br2"}}}
+		entries, _ := cacheEntriesOfPorts([]ovsdb.OwnedPort{
+			port("veth1", "net1", "c1", "eth1"),
+			port("veth2", "net1", "c1", "eth1b"),
+		}, netconfs, "")
+		Expect(entries).To(HaveLen(1))
+		Expect(entries["net1-c1-eth1"].BackupPort).To(Equal("veth2"))
+	})
+	It("should restore the port of VLAN translation", func() {
+		translated := port("veth1", "net1", "c1", "eth1")
+		translated.ExternalIDs[ovsdb.VlanTranslationKey] = "true"
+		entries, _ := cacheEntriesOfPorts([]ovsdb.OwnedPort{
+			translated,
+			port("veth2", "net1", "c2", "eth1"),
+		}, nil, "")
+		Expect(entries["net1-c1-eth1"].VlanTranslationPort).To(Equal("veth1"))
+		Expect(entries["net1-c2-eth1"].VlanTranslationPort).To(BeEmpty())
+	})
+	It("should restore the port of userspace IPAM", func() {
+		portName := userspaceIPAMPortName("net1", "c1", "eth1")
+		userspaceIPAM := port(portName, "net1", "c1", portName)
+		userspaceIPAM.InterfaceType = "internal"
+		entries, _ := cacheEntriesOfPorts([]ovsdb.OwnedPort{
+			port("dpdk0", "net1", "c1", "eth1"),
+			userspaceIPAM,
+			port("dpdk1", "net1", "c2", "eth1"),
+		}, nil, "")
+		Expect(entries).To(HaveLen(2))
+		Expect(entries["net1-c1-eth1"].UserspaceIPAMPort).To(Equal(portName))
+		Expect(entries["net1-c2-eth1"].UserspaceIPAMPort).To(BeEmpty())
+	})
+	It("should skip ports which can't be restored", func() {
+		quarantined := port("veth1", "net1", "c1", "eth1")
+		quarantined.ExternalIDs[ovsdb.QuarantineExpiryKey] = "2024-01-01T00:00:00Z"
+		old := port("veth2", "net1", "c2", "eth1")
+		delete(old.ExternalIDs, ovsdb.ContainerIDKey)
+		userspaceIPAM := port("usi0", "net1", "c3", "usi0")
+		userspaceIPAM.InterfaceType = "internal"
+		entries, skipped := cacheEntriesOfPorts([]ovsdb.OwnedPort{quarantined, old, userspaceIPAM}, nil, "")
+		Expect(entries).To(BeEmpty())
+		Expect(skipped).To(HaveKeyWithValue("veth1", "port is quarantined"))
+		Expect(skipped).To(HaveKeyWithValue("veth2", "ID of the container is not recorded"))
+		Expect(skipped).NotTo(HaveKey("usi0"))
+	})
+})
@@ -0,0 +1,285 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/openflow"
+	ovscnitypes "github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// Virtual gateway of routed attachments, the container reaches it through
+// a permanent neighbor entry, so no ARP or ND is answered on the bridge
+const (
+	routedGatewayMAC  = "0a:58:a9:fe:01:01"
+	routedGatewayIPv4 = "169.254.1.1"
+	routedGatewayIPv6 = "fe80::1"
+)
+
+// priorities of flows of routed attachments
+const (
+	routedFlowPriorityIngress = 200
+	routedFlowPriorityEgress  = 100
+	routedFlowPriorityDrop    = 50
+)
+
+// isRoutedMode returns true when traffic of the attachment is routed by the
+// bridge instead of bridged
+func isRoutedMode(netconf *ovscnitypes.NetConf) bool {
+	return netconf.Mode == config.ModeRouted
+}
+
+// routedCookie returns cookie of the flows of the attachment
+func routedCookie(containerID, ifName string) uint64 {
+	hash := sha256.Sum256([]byte(containerID + "/" + ifName))
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+// routedGateway returns the virtual gateway of the address family of ip
+func routedGateway(ip net.IP) net.IP {
+	if ip.To4() != nil {
+		return net.ParseIP(routedGatewayIPv4)
+	}
+	return net.ParseIP(routedGatewayIPv6)
+}
+
+// toRoutedResult turns the IPAM result into the one of a routed attachment,
+// addresses become host routes and all routes point to the virtual gateway
+func toRoutedResult(result *current.Result) {
+	hasDefault := map[bool]bool{}
+	families := map[bool]bool{}
+	for _, ipc := range result.IPs {
+		bits := 8 * len(ipc.Address.IP)
+		if ipc.Address.IP.To4() != nil {
+			ipc.Address.IP = ipc.Address.IP.To4()
+			bits = 32
+		}
+		ipc.Address.Mask = net.CIDRMask(bits, bits)
+		ipc.Gateway = routedGateway(ipc.Address.IP)
+		families[ipc.Address.IP.To4() != nil] = true
+	}
+	for _, route := range result.Routes {
+		route.GW = routedGateway(route.Dst.IP)
+		if ones, _ := route.Dst.Mask.Size(); ones == 0 {
+			hasDefault[route.Dst.IP.To4() != nil] = true
+		}
+	}
+	for isIPv4 := range families {
+		if hasDefault[isIPv4] {
+			continue
+		}
+		dst := net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		if isIPv4 {
+			dst = net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+		}
+		result.Routes = append(result.Routes, &types.Route{Dst: dst, GW: routedGateway(dst.IP)})
+	}
+}
+
+// configureRoutedContainer points the container interface to the virtual
+// gateway and adds routes of the result, must run in the container netns
+// after its addresses are configured
+func configureRoutedContainer(ifName string, result *current.Result) error {
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	gatewayMAC, err := net.ParseMAC(routedGatewayMAC)
+	if err != nil {
+		return err
+	}
+	gateways := map[string]net.IP{}
+	for _, ipc := range result.IPs {
+		gateways[ipc.Gateway.String()] = ipc.Gateway
+	}
+	for _, gw := range gateways {
+		family := netlink.FAMILY_V6
+		if gw.To4() != nil {
+			family = netlink.FAMILY_V4
+			// IPv4 gateway is not within the host route of the address
+			gwRoute := &netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Dst:       &net.IPNet{IP: gw, Mask: net.CIDRMask(32, 32)},
+				Scope:     netlink.SCOPE_LINK,
+			}
+			if err := netif.Default.RouteReplace(gwRoute); err != nil {
+				return fmt.Errorf("failed to add route to gateway %s: %v", gw, err)
+			}
+		}
+		neigh := &netlink.Neigh{
+			LinkIndex:    link.Attrs().Index,
+			Family:       family,
+			State:        netlink.NUD_PERMANENT,
+			IP:           gw,
+			HardwareAddr: gatewayMAC,
+		}
+		if err := netif.Default.NeighSet(neigh); err != nil {
+			return fmt.Errorf("failed to add neighbor entry of gateway %s: %v", gw, err)
+		}
+	}
+	for _, route := range result.Routes {
+		dst := route.Dst
+		if err := netif.Default.RouteReplace(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: &dst, Gw: route.GW}); err != nil {
+			return fmt.Errorf("failed to add route %v via %s: %v", route.Dst, route.GW, err)
+		}
+	}
+	return nil
+}
+
+// routedFlows returns flows of the attachment: traffic to its addresses is
+// routed to the port, traffic from the port to other destinations goes to
+// the host and everything else sent by the port is dropped
+func routedFlows(cookie uint64, portName, podMAC string, bridgeMAC net.HardwareAddr, ips []net.IP) []openflow.Flow {
+	var flows []openflow.Flow
+	for _, ip := range ips {
+		match := fmt.Sprintf("ip,nw_dst=%s", ip)
+		if ip.To4() == nil {
+			match = fmt.Sprintf("ipv6,ipv6_dst=%s", ip)
+		}
+		flows = append(flows, openflow.Flow{
+			Cookie:   cookie,
+			Priority: routedFlowPriorityIngress,
+			Match:    match,
+			Actions:  fmt.Sprintf("mod_dl_src:%s,mod_dl_dst:%s,dec_ttl,output:%s", routedGatewayMAC, podMAC, portName),
+		})
+	}
+	for _, proto := range []string{"ip", "ipv6"} {
+		flows = append(flows, openflow.Flow{
+			Cookie:   cookie,
+			Priority: routedFlowPriorityEgress,
+			Match:    fmt.Sprintf("in_port=%s,%s", portName, proto),
+			Actions:  fmt.Sprintf("mod_dl_dst:%s,output:LOCAL", bridgeMAC),
+		})
+	}
+	flows = append(flows, openflow.Flow{
+		Cookie:   cookie,
+		Priority: routedFlowPriorityDrop,
+		Match:    fmt.Sprintf("in_port=%s", portName),
+		Actions:  "drop",
+	})
+	return flows
+}
+
+// setupRoutedPort installs flows of the routed attachment, excludes its port
+// from flooding and routes its addresses from the host through the bridge
+func setupRoutedPort(bridgeName, portName, containerID, ifName, podMAC string, ips []net.IP) error {
+	bridgeLink, err := netif.Default.LinkByName(bridgeName)
+	if err != nil {
+		return fmt.Errorf("routed mode requires local interface of bridge %s: %v", bridgeName, err)
+	}
+	flows := routedFlows(routedCookie(containerID, ifName), portName, podMAC, bridgeLink.Attrs().HardwareAddr, ips)
+	ofClient := openflow.NewClient(bridgeName)
+	if err := ofClient.AddFlows(flows); err != nil {
+		return err
+	}
+	if err := ofClient.SetPortFlood(portName, false); err != nil {
+		return err
+	}
+
+	mac, err := net.ParseMAC(podMAC)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		bits := 8 * len(ip)
+		family := netlink.FAMILY_V6
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+			family = netlink.FAMILY_V4
+		}
+		neigh := &netlink.Neigh{
+			LinkIndex:    bridgeLink.Attrs().Index,
+			Family:       family,
+			State:        netlink.NUD_PERMANENT,
+			IP:           ip,
+			HardwareAddr: mac,
+		}
+		if err := netif.Default.NeighSet(neigh); err != nil {
+			return fmt.Errorf("failed to add neighbor entry of %s: %v", ip, err)
+		}
+		route := &netlink.Route{
+			LinkIndex: bridgeLink.Attrs().Index,
+			Dst:       &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)},
+			Scope:     netlink.SCOPE_LINK,
+		}
+		if err := netif.Default.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to add host route to %s: %v", ip, err)
+		}
+	}
+	return nil
+}
+
+// setupRoutedAttachment sets up the port of the routed attachment and stores
+// its addresses in the cache, so they can be unrouted on DEL
+func setupRoutedAttachment(cRef string, cachedNetConf *ovscnitypes.CachedNetConf, portName string, result *current.Result) error {
+	var ips []net.IP
+	cachedNetConf.RoutedIPs = nil
+	for _, ipc := range result.IPs {
+		ips = append(ips, ipc.Address.IP)
+		cachedNetConf.RoutedIPs = append(cachedNetConf.RoutedIPs, ipc.Address.IP.String())
+	}
+	if err := utils.SaveCache(cRef, cachedNetConf); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+	netconf := cachedNetConf.Netconf
+	err := setupRoutedPort(netconf.BrName, portName, cachedNetConf.ContainerID, cachedNetConf.IfName, result.Interfaces[0].Mac, ips)
+	if err != nil {
+		if err := teardownRoutedPort(netconf.BrName, cachedNetConf.ContainerID, cachedNetConf.IfName, cachedNetConf.RoutedIPs); err != nil {
+			log.Printf("Failed best-effort cleanup of routed attachment: %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// teardownRoutedPort removes flows and host routes of the routed attachment,
+// host routes which are already gone are ignored
+func teardownRoutedPort(bridgeName, containerID, ifName string, ips []string) error {
+	bridgeLink, err := netif.Default.LinkByName(bridgeName)
+	if err == nil {
+		for _, addr := range ips {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				continue
+			}
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			route := &netlink.Route{LinkIndex: bridgeLink.Attrs().Index, Dst: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}
+			if err := netif.Default.RouteDel(route); err != nil {
+				log.Printf("Failed best-effort cleanup of host route to %s: %v", ip, err)
+			}
+			if err := netif.Default.NeighDel(&netlink.Neigh{LinkIndex: bridgeLink.Attrs().Index, IP: ip}); err != nil {
+				log.Printf("Failed best-effort cleanup of neighbor entry of %s: %v", ip, err)
+			}
+		}
+	}
+	return openflow.NewClient(bridgeName).DeleteFlows(routedCookie(containerID, ifName))
+}
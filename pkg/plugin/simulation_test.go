@@ -0,0 +1,311 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build simulation
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/ovn-org/libovsdb/ovsdb"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	ovsdbdriver "github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+var _ = Describe("Simulation", func() {
+	const contNetnsPath = "/var/run/netns/simulated"
+	var contNetns ns.NetNS
+	BeforeEach(func() {
+		netif.Default = netif.NewSimulated()
+		contNetns = netns.NewSimulated(contNetnsPath)
+		DeferCleanup(netns.DeleteSimulated, contNetnsPath)
+	})
+	It("should set up a veth pair between container and host", func() {
+		hostIface, contIface, err := setupVeth(contNetns, "eth0", "host0", "02:00:00:00:00:01", 1400)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contIface.Name).To(Equal("eth0"))
+		Expect(contIface.Mac).To(Equal("02:00:00:00:00:01"))
+		Expect(contIface.Mtu).To(Equal(1400))
+		Expect(contIface.Sandbox).To(Equal(contNetnsPath))
+
+		hostLink, err := netif.Default.LinkByName("host0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hostIface.Mac).To(Equal(hostLink.Attrs().HardwareAddr.String()))
+		Expect(netns.Do(contNetns, func(ns.NetNS) error {
+			link, err := netif.Default.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().Flags & net.FlagUp).NotTo(BeZero())
+			return nil
+		})).To(Succeed())
+	})
+	It("should leave no host side when the container interface exists", func() {
+		_, _, err := setupVeth(contNetns, "eth0", "host0", "", 1500)
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = setupVeth(contNetns, "eth0", "host1", "", 1500)
+		Expect(err).To(MatchError(ContainSubstring("already exists")))
+		_, err = netif.Default.LinkByName("host1")
+		Expect(netif.IsNotFound(err)).To(BeTrue())
+	})
+	It("should remove the host side with the container interface", func() {
+		_, _, err := setupVeth(contNetns, "eth0", "host0", "", 1500)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netns.WithPath(contNetnsPath, func(ns.NetNS) error {
+			return netif.Default.DelLinkByName("eth0")
+		})).To(Succeed())
+		_, err = netif.Default.LinkByName("host0")
+		Expect(netif.IsNotFound(err)).To(BeTrue())
+		Expect(netns.WithPath(contNetnsPath, func(ns.NetNS) error {
+			return netif.Default.DelLinkByName("eth0")
+		})).To(Equal(ip.ErrLinkNotFound))
+	})
+	It("should report the namespace of a removed container as gone", func() {
+		netns.DeleteSimulated(contNetnsPath)
+		err := netns.WithPath(contNetnsPath, func(ns.NetNS) error { return nil })
+		Expect(netns.IsGone(err)).To(BeTrue())
+		_, _, err = setupVeth(contNetns, "eth0", "host0", "", 1500)
+		Expect(netns.IsGone(err)).To(BeTrue())
+	})
+	Context("with a fake OVSDB", func() {
+		const bridge = "br-sim"
+		const backupBridge = "br-sim-backup"
+		var fake *testhelpers.FakeOVSDB
+		var args *skel.CmdArgs
+		conf := func(extra string) []byte {
+			return []byte(fmt.Sprintf(`{"cniVersion": "1.0.0", "name": "mynet", "type": "ovs", "bridge": %q, "socket_file": %q%s}`,
+				bridge, fake.Endpoint, extra))
+		}
+		rowsOf := func(table string) []ovsdb.Row {
+			rows, err := fake.Select(table)
+			Expect(err).NotTo(HaveOccurred())
+			return rows
+		}
+		BeforeEach(func() {
+			var err error
+			fake, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge, backupBridge)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(fake.Close)
+			cacheDir, lockDir, hooksDir := utils.DefaultCacheDir, utils.DefaultLockDir, config.HooksDir
+			utils.DefaultCacheDir, utils.DefaultLockDir, config.HooksDir = GinkgoT().TempDir(), GinkgoT().TempDir(), GinkgoT().TempDir()
+			DeferCleanup(func() {
+				utils.DefaultCacheDir, utils.DefaultLockDir, config.HooksDir = cacheDir, lockDir, hooksDir
+			})
+			args = &skel.CmdArgs{ContainerID: "sim", Netns: contNetnsPath, IfName: "eth0"}
+		})
+		It("should attach the container to the bridge on ADD and detach it on DEL", func() {
+			args.StdinData = conf("")
+			r, _, err := testutils.CmdAdd(args.Netns, args.ContainerID, args.IfName, args.StdinData, func() error {
+				return CmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(2))
+			hostIface, contIface := result.Interfaces[0], result.Interfaces[1]
+			Expect(contIface.Name).To(Equal("eth0"))
+			Expect(contIface.Sandbox).To(Equal(contNetnsPath))
+			Expect(hostIface.Sandbox).To(BeEmpty())
+
+			ports := rowsOf("Port")
+			Expect(ports).To(HaveLen(1))
+			Expect(ports[0]["name"]).To(Equal(hostIface.Name))
+			Expect(rowsOf("Interface")).To(HaveLen(1))
+			hostLink, err := netif.Default.LinkByName(hostIface.Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hostLink.Attrs().Flags & net.FlagUp).NotTo(BeZero())
+
+			Expect(testutils.CmdDel(args.Netns, args.ContainerID, args.IfName, func() error {
+				return CmdDel(args)
+			})).To(Succeed())
+			Expect(rowsOf("Port")).To(BeEmpty())
+			Expect(rowsOf("Interface")).To(BeEmpty())
+			_, err = netif.Default.LinkByName(hostIface.Name)
+			Expect(netif.IsNotFound(err)).To(BeTrue())
+		})
+		It("should name ports after the pod by stable_port_names and port_name_template", func() {
+			args.Args = "K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod1"
+			hostIfaceName := func(extra string) string {
+				args.StdinData = conf(extra)
+				r, _, err := testutils.CmdAdd(args.Netns, args.ContainerID, args.IfName, args.StdinData, func() error {
+					return CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(testutils.CmdDel(args.Netns, args.ContainerID, args.IfName, func() error {
+					return CmdDel(args)
+				})).To(Succeed())
+				return result.Interfaces[0].Name
+			}
+			pod := podIdentity{namespace: "default", name: "pod1", ifName: args.IfName}
+			Expect(hostIfaceName(`, "stable_port_names": true`)).To(Equal("veth" + pod.portNameHash()))
+			Expect(hostIfaceName(`, "port_name_template": "veth{hash}"`)).To(Equal("veth" + pod.portNameHash()))
+			Expect(hostIfaceName(`, "port_name_template": "veth{podName trunc}-{ifname}"`)).To(Equal("vethpod1-eth0"))
+		})
+		It("should check the netns of an attachment whose OVS port passed CHECK before", func() {
+			const extra = `, "check_cache_ttl": 60, "vlan": 10`
+			args.StdinData = conf(extra)
+			r, _, err := testutils.CmdAdd(args.Netns, args.ContainerID, args.IfName, args.StdinData, func() error {
+				return CmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			prevResult, err := json.Marshal(r)
+			Expect(err).NotTo(HaveOccurred())
+			args.StdinData = conf(fmt.Sprintf(`%s, "prevResult": %s`, extra, prevResult))
+			check := func() error {
+				return testutils.CmdCheck(args.Netns, args.ContainerID, args.IfName, func() error {
+					return CmdCheck(args)
+				})
+			}
+			Expect(check()).To(Succeed())
+
+			// OVSDB is not queried again until the marker drops the result
+			_, err = fake.Transact(ovsdb.Operation{
+				Op:    ovsdb.OperationUpdate,
+				Table: "Port",
+				Row:   ovsdb.Row{"tag": 20},
+				Where: []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, rowsOf("Port")[0]["name"])},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(check()).To(Succeed())
+
+			Expect(netns.WithPath(contNetnsPath, func(ns.NetNS) error {
+				return netif.Default.DelLinkByName(args.IfName)
+			})).To(Succeed())
+			Expect(check()).To(MatchError(ContainSubstring("not found")))
+		})
+		It("should report the OVS state on CHECK and remove the report on GC", func() {
+			reportDir := GinkgoT().TempDir()
+			args.StdinData = conf(fmt.Sprintf(`, "check_report_dir": %q`, reportDir))
+			r, _, err := testutils.CmdAdd(args.Netns, args.ContainerID, args.IfName, args.StdinData, func() error {
+				return CmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+
+			report := &checkReport{}
+			netconf, err := config.LoadConf(args.StdinData)
+			Expect(err).NotTo(HaveOccurred())
+			// flows of a passed CHECK are not counted even with diagnostics
+			netconf.OvsDiagnostics = true
+			report.netconf = netconf
+			report.port = result.Interfaces[0].Name
+			report.driver, err = ovsdbdriver.NewOvsBridgeDriver(bridge, fake.Endpoint)
+			Expect(err).NotTo(HaveOccurred())
+			saveCheckReport(args, report, nil)
+			reportPath := checkReportPath(reportDir, "mynet", args.ContainerID, args.IfName)
+			Expect(reportPath).To(BeAnExistingFile())
+			Expect(report.OVS.Bridge).To(Equal(bridge))
+			Expect(report.OVS.Port).To(Equal(result.Interfaces[0].Name))
+			Expect(report.OVS.BridgeState).To(HaveSuffix("flows not counted"))
+			Expect(report.OVS.Error).To(BeEmpty())
+
+			cache, _, err := config.LoadNetworkConfFromCache("mynet", args.ContainerID, args.IfName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gcAttachment(cache)).To(Succeed())
+			Expect(reportPath).NotTo(BeAnExistingFile())
+			Expect(rowsOf("Port")).To(BeEmpty())
+		})
+		It("should stop the capture and record statistics of the port on GC", func() {
+			_, err := fake.Transact(
+				ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Interface", Row: ovsdb.Row{"name": "cap0"}, UUIDName: "intf"},
+				ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Port", Row: ovsdb.Row{"name": "cap0", "interfaces": ovsdb.UUID{GoUUID: "intf"}}, UUIDName: "port"},
+				ovsdb.Operation{
+					Op:        ovsdb.OperationMutate,
+					Table:     "Bridge",
+					Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("ports", ovsdb.MutateOperationInsert, ovsdb.UUID{GoUUID: "port"})},
+					Where:     []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridge)},
+				})
+			Expect(err).NotTo(HaveOccurred())
+			statsFile := filepath.Join(GinkgoT().TempDir(), "stats.json")
+			args.StdinData = conf(fmt.Sprintf(`, "capture": {"port": "cap0"}, "stats_file": %q`, statsFile))
+			r, _, err := testutils.CmdAdd(args.Netns, args.ContainerID, args.IfName, args.StdinData, func() error {
+				return CmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rowsOf("Mirror")).To(HaveLen(1))
+
+			cache, _, err := config.LoadNetworkConfFromCache("mynet", args.ContainerID, args.IfName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gcAttachment(cache)).To(Succeed())
+			Expect(rowsOf("Mirror")).To(BeEmpty())
+			data, err := os.ReadFile(statsFile)
+			Expect(err).NotTo(HaveOccurred())
+			record := statsRecord{}
+			Expect(json.Unmarshal(data, &record)).To(Succeed())
+			Expect(record.ContainerID).To(Equal(args.ContainerID))
+			Expect(record.IfName).To(Equal(args.IfName))
+			Expect(record.Port).To(Equal(result.Interfaces[0].Name))
+		})
+		It("should remove the port when ADD fails after attaching it", func() {
+			hook := filepath.Join(config.HooksDir, "register")
+			Expect(os.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0700)).To(Succeed())
+			args.StdinData = conf(`, "hooks": {"post_add": "register"}`)
+			_, _, err := testutils.CmdAdd(args.Netns, args.ContainerID, args.IfName, args.StdinData, func() error {
+				return CmdAdd(args)
+			})
+			Expect(err).To(MatchError(ContainSubstring("post-add hook register failed")))
+			Expect(rowsOf("Port")).To(BeEmpty())
+			Expect(rowsOf("Interface")).To(BeEmpty())
+			bridges, err := fake.Select("Bridge", ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridge))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bridges).To(HaveLen(1))
+			Expect(bridges[0]["ports"]).To(Equal(ovsdb.OvsSet{GoSet: []interface{}{}}))
+		})
+		It("should respect the port limit of the backup bridge", func() {
+			_, err := fake.Transact(ovsdb.Operation{
+				Op:    ovsdb.OperationUpdate,
+				Table: "Bridge",
+				Row:   ovsdb.Row{"external_ids": ovsdb.OvsMap{GoMap: map[interface{}]interface{}{ovsdbdriver.BridgeMaxPortsKey: "1"}}},
+				Where: []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, backupBridge)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			args.StdinData = conf(fmt.Sprintf(`, "backup": {"bridge": %q}`, backupBridge))
+			_, _, err = testutils.CmdAdd(args.Netns, args.ContainerID, args.IfName, args.StdinData, func() error {
+				return CmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rowsOf("Port")).To(HaveLen(2))
+
+			const otherNetnsPath = "/var/run/netns/simulated-other"
+			netns.NewSimulated(otherNetnsPath)
+			DeferCleanup(netns.DeleteSimulated, otherNetnsPath)
+			other := &skel.CmdArgs{ContainerID: "sim-other", Netns: otherNetnsPath, IfName: "eth0", StdinData: args.StdinData}
+			_, _, err = testutils.CmdAdd(other.Netns, other.ContainerID, other.IfName, other.StdinData, func() error {
+				return CmdAdd(other)
+			})
+			Expect(err).To(MatchError(ContainSubstring("bridge " + backupBridge + " is full")))
+			Expect(rowsOf("Port")).To(HaveLen(2))
+		})
+	})
+})
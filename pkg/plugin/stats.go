@@ -0,0 +1,124 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// statsRecord is a line of the stats file, written when an attachment is removed
+type statsRecord struct {
+	Timestamp    time.Time         `json:"timestamp"`
+	Network      string            `json:"network"`
+	ContainerID  string            `json:"containerID"`
+	IfName       string            `json:"ifName"`
+	PodNamespace string            `json:"podNamespace,omitempty"`
+	PodName      string            `json:"podName,omitempty"`
+	PodUID       string            `json:"podUID,omitempty"`
+	Bridge       string            `json:"bridge"`
+	Port         string            `json:"port"`
+	DeviceID     string            `json:"deviceID,omitempty"`
+	Statistics   map[string]uint64 `json:"statistics"`
+}
+
+// recordStats appends final statistics counters of the port to the stats
+// file, failures are only logged as they must not block DEL
+func recordStats(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, args *skel.CmdArgs, envArgs *EnvArgs, portName string) {
+	if netconf.StatsFile == "" || ovsBridgeDriver.LeastPrivilege {
+		return
+	}
+	stats, err := ovsBridgeDriver.GetInterfaceStatistics(portName)
+	if err != nil {
+		log.Printf("Failed to read statistics of port %s: %v", portName, err)
+		return
+	}
+	record := statsRecord{
+		Timestamp:   time.Now().UTC(),
+		Network:     netconf.Name,
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		Bridge:      netconf.BrName,
+		Port:        portName,
+		DeviceID:    netconf.DeviceID,
+		Statistics:  stats,
+	}
+	if envArgs != nil {
+		record.PodNamespace = string(envArgs.K8S_POD_NAMESPACE)
+		record.PodName = string(envArgs.K8S_POD_NAME)
+		record.PodUID = string(envArgs.K8S_POD_UID)
+	}
+	log.Printf("Statistics of port %s: rx_bytes %d, tx_bytes %d", portName, stats["rx_bytes"], stats["tx_bytes"])
+	if err := appendStatsRecord(netconf.StatsFile, &record); err != nil {
+		log.Printf("Failed to record statistics of port %s: %v", portName, err)
+	}
+}
+
+// recordRepresentorStats records statistics of the representor of the VF of
+// the attachment when its port is not found, e.g. an OVS-DPDK representor
+// port not created by ovs-cni. A VF bound to a userspace driver has no kernel
+// netdev, so OVSDB is the only source of its counters.
+func recordRepresentorStats(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, args *skel.CmdArgs, envArgs *EnvArgs) {
+	if netconf.StatsFile == "" || ovsBridgeDriver.LeastPrivilege {
+		return
+	}
+	if rep, err := representorPortName(netconf, netconf.DeviceID); err == nil {
+		if _, err := ovsBridgeDriver.GetPortUUID(rep); err == nil {
+			recordStats(ovsBridgeDriver, netconf, args, envArgs, rep)
+			return
+		}
+	}
+	devargs, err := sriov.GetDpdkRepresentorDevargs(netconf.DeviceID)
+	if err != nil {
+		log.Printf("Failed to find representor of VF %s to record its statistics: %v", netconf.DeviceID, err)
+		return
+	}
+	iface, err := ovsBridgeDriver.GetInterfaceNameByDpdkDevargs(devargs)
+	if err != nil {
+		log.Printf("Failed to find representor of VF %s to record its statistics: %v", netconf.DeviceID, err)
+		return
+	}
+	recordStats(ovsBridgeDriver, netconf, args, envArgs, iface)
+}
+
+func appendStatsRecord(path string, record *statsRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// single write of the whole line, concurrent DELs don't interleave
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return f.Close()
+}
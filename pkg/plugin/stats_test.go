@@ -0,0 +1,149 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/ovn-org/libovsdb/ovsdb"
+
+	ovsdbdriver "github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/testhelpers"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Representor statistics", func() {
+	const bridge = "br-stats"
+	var fake *testhelpers.FakeOVSDB
+	var driver *ovsdbdriver.OvsBridgeDriver
+	var netconf *types.NetConf
+	var statsFile string
+	args := &skel.CmdArgs{ContainerID: "cid", IfName: "net1"}
+
+	BeforeEach(func() {
+		var err error
+		fake, err = testhelpers.NewFakeOVSDB(GinkgoT().TempDir(), bridge)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(fake.Close)
+		driver, err = ovsdbdriver.NewOvsBridgeDriver(bridge, fake.Endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		statsFile = filepath.Join(GinkgoT().TempDir(), "stats.json")
+		netconf = &types.NetConf{BrName: bridge, DeviceID: "0000:03:00.2", StatsFile: statsFile}
+		netconf.Name = "mynet"
+		origDevices := sriov.Devices
+		DeferCleanup(func() { sriov.Devices = origDevices })
+	})
+
+	// addPort adds a port not created by ovs-cni with statistics of its
+	// interface, like a representor added by the admin
+	addPort := func(name string, options map[interface{}]interface{}, rxBytes int) {
+		_, err := fake.Transact(
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Interface", UUIDName: "intf", Row: ovsdb.Row{
+				"name":       name,
+				"options":    ovsdb.OvsMap{GoMap: options},
+				"statistics": ovsdb.OvsMap{GoMap: map[interface{}]interface{}{"rx_bytes": rxBytes}},
+			}},
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Port", Row: ovsdb.Row{"name": name, "interfaces": ovsdb.UUID{GoUUID: "intf"}}, UUIDName: "port"},
+			ovsdb.Operation{
+				Op:        ovsdb.OperationMutate,
+				Table:     "Bridge",
+				Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("ports", ovsdb.MutateOperationInsert, ovsdb.UUID{GoUUID: "port"})},
+				Where:     []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridge)},
+			})
+		Expect(err).NotTo(HaveOccurred())
+	}
+	readRecord := func() statsRecord {
+		data, err := os.ReadFile(statsFile)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		record := statsRecord{}
+		ExpectWithOffset(1, json.Unmarshal(data, &record)).To(Succeed())
+		return record
+	}
+
+	It("should record statistics of the port of the representor netdevice", func() {
+		sriov.Devices = fakeRepresentorHost{representor: "pf0vf2"}
+		addPort("pf0vf2", map[interface{}]interface{}{}, 100)
+		addPort("dpdk0", map[interface{}]interface{}{"dpdk-devargs": "0000:03:00.0,representor=[2]"}, 200)
+		recordRepresentorStats(driver, netconf, args, nil)
+		record := readRecord()
+		Expect(record.Port).To(Equal("pf0vf2"))
+		Expect(record.DeviceID).To(Equal("0000:03:00.2"))
+		Expect(record.Statistics).To(HaveKeyWithValue("rx_bytes", uint64(100)))
+	})
+	It("should record statistics of the DPDK representor without a netdevice", func() {
+		sriov.Devices = fakeRepresentorHost{}
+		addPort("dpdk0", map[interface{}]interface{}{"dpdk-devargs": "0000:03:00.0,representor=[2]"}, 200)
+		addPort("dpdk1", map[interface{}]interface{}{"dpdk-devargs": "0000:03:00.0,representor=[3]"}, 300)
+		recordRepresentorStats(driver, netconf, args, nil)
+		record := readRecord()
+		Expect(record.Port).To(Equal("dpdk0"))
+		Expect(record.Statistics).To(HaveKeyWithValue("rx_bytes", uint64(200)))
+	})
+	It("should fall back to the DPDK representor when the netdevice has no port", func() {
+		sriov.Devices = fakeRepresentorHost{representor: "pf0vf2"}
+		addPort("dpdk0", map[interface{}]interface{}{"dpdk-devargs": "0000:03:00.0,representor=[2]"}, 200)
+		recordRepresentorStats(driver, netconf, args, nil)
+		Expect(readRecord().Port).To(Equal("dpdk0"))
+	})
+	It("should record nothing when the representor is not on a bridge", func() {
+		sriov.Devices = fakeRepresentorHost{}
+		addPort("dpdk1", map[interface{}]interface{}{"dpdk-devargs": "0000:03:00.0,representor=[3]"}, 300)
+		recordRepresentorStats(driver, netconf, args, nil)
+		Expect(statsFile).NotTo(BeAnExistingFile())
+	})
+	It("should record nothing without stats_file or with least privilege", func() {
+		sriov.Devices = fakeRepresentorHost{representor: "pf0vf2"}
+		addPort("pf0vf2", map[interface{}]interface{}{}, 100)
+		netconf.StatsFile = ""
+		recordRepresentorStats(driver, netconf, args, nil)
+		netconf.StatsFile = statsFile
+		driver.LeastPrivilege = true
+		recordRepresentorStats(driver, netconf, args, nil)
+		Expect(statsFile).NotTo(BeAnExistingFile())
+	})
+})
+
+// fakeRepresentorHost is a host with VF 2 of PF 0000:03:00.0, its uplink is
+// pf0 and its representor netdevice, if any, is representor
+type fakeRepresentorHost struct {
+	sriov.SysfsHost
+	representor string
+}
+
+func (h fakeRepresentorHost) UplinkRepresentor(string) (string, error) {
+	return "pf0", nil
+}
+
+func (h fakeRepresentorHost) VFIndex(string) (int, error) {
+	return 2, nil
+}
+
+func (h fakeRepresentorHost) PFPci(string) (string, error) {
+	return "0000:03:00.0", nil
+}
+
+func (h fakeRepresentorHost) VFRepresentor(string, int) (string, error) {
+	if h.representor == "" {
+		return "", errors.New("no representor netdevice")
+	}
+	return h.representor, nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+)
+
+// error codes of STATUS introduced by CNI spec 1.1
+const (
+	errPluginNotAvailable  uint = 50
+	errLimitedConnectivity uint = 51
+)
+
+// CmdStatus status handler reporting whether the plugin is ready to attach containers
+func CmdStatus(args *skel.CmdArgs) error {
+	logCall("STATUS", args)
+
+	stdinData, err := config.ExpandStaticIPAM(args.StdinData)
+	if err != nil {
+		return newError(cnitypes.ErrInvalidNetworkConfig, err)
+	}
+	args.StdinData = stdinData
+
+	netconf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return cnitypes.NewError(cnitypes.ErrDecodingFailure, "failed to load netconf", err.Error())
+	}
+
+	if _, err := ovsdb.NewOvsDriver(netconf.SocketFile); err != nil {
+		return cnitypes.NewError(errPluginNotAvailable, "OVSDB is not available", err.Error())
+	}
+	if err := resolveBridgeTemplate(netconf); err != nil {
+		return cnitypes.NewError(errPluginNotAvailable, "failed to resolve the bridge", err.Error())
+	}
+
+	// bridge may be discovered per attachment, it can be checked only when it is configured
+	if netconf.BrName != "" {
+		ovsBridgeDriver, err := newBridgeDriver(netconf.BrName, netconf)
+		if err != nil {
+			return cnitypes.NewError(errPluginNotAvailable, "OVSDB of the bridge is not available", err.Error())
+		}
+		found, err := ovsBridgeDriver.IsBridgePresent(netconf.BrName)
+		if err != nil {
+			return cnitypes.NewError(errPluginNotAvailable, "failed to look up the bridge", err.Error())
+		}
+		if !found {
+			return cnitypes.NewError(errPluginNotAvailable, "bridge "+netconf.BrName+" is not found in OVS", "")
+		}
+		if !ovsBridgeDriver.LeastPrivilege {
+			ifaces, err := ovsBridgeDriver.FindInterfacesWithError()
+			if err == nil && len(ifaces) > 0 {
+				return cnitypes.NewError(errLimitedConnectivity, "some interfaces are in error state", "")
+			}
+		}
+	}
+
+	if netconf.IPAM.Type != "" {
+		if err := setupIPAMEnv(netconf); err != nil {
+			return err
+		}
+		ipamPlugins, err := ipamPluginsOf(netconf, args.StdinData)
+		if err != nil {
+			return err
+		}
+		for _, plugin := range ipamPlugins {
+			if err := invoke.DelegateStatus(context.TODO(), plugin.pluginType, plugin.stdinData, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
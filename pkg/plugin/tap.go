@@ -0,0 +1,203 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// tapVethPrefix is prepended to names of container veths of tap attachments
+const tapVethPrefix = "ovst"
+
+// isTapMode returns true when the container interface of the attachment is
+// a tap device instead of the veth
+func isTapMode(netconf *types.NetConf) bool {
+	return netconf.Tap != nil
+}
+
+// tapVethName returns the name of the container end of the veth of a tap
+// attachment, it is stable so the veth can be found on DEL
+func tapVethName(containerID, ifName string) string {
+	hash := sha256.Sum256([]byte(containerID + "/" + ifName))
+	return tapVethPrefix + hex.EncodeToString(hash[:])[:11]
+}
+
+// setupTapAttachment creates the veth of the attachment with its container
+// end under a stable name and the tap device named after the attachment,
+// traffic is redirected between them by tc, so the consumer of the tap, e.g.
+// a VM, is connected to the bridge without a bridge in the container
+func setupTapAttachment(ovsDriver *ovsdb.OvsBridgeDriver, contNetns ns.NetNS, contIfaceName, contNetwork, containerID, hostIfaceName, requestedMac string, tap *types.Tap, mtu int) (hostIface, contIface *current.Interface, err error) {
+	vethName := tapVethName(containerID, contIfaceName)
+	if err := removeStaleTap(ovsDriver, contNetns, contIfaceName, vethName, contNetwork); err != nil {
+		return nil, nil, err
+	}
+	// the veth is hidden from the consumer, the tap device gets the MAC
+	hostIface, _, err = setupVeth(contNetns, vethName, hostIfaceName, "", mtu)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		contIface, err = setupTap(contIfaceName, vethName, requestedMac, tap, mtu)
+		return err
+	})
+	if err != nil {
+		// removing the veth removes its host end as well
+		if err := netns.Do(contNetns, func(_ ns.NetNS) error {
+			return netif.Default.DelLinkByName(vethName)
+		}); err != nil {
+			log.Printf("Failed best-effort cleanup of %s: %v", vethName, err)
+		}
+		return nil, nil, err
+	}
+	contIface.Sandbox = contNetns.Path()
+	return hostIface, contIface, nil
+}
+
+// setupTap creates the persistent tap device, owned as configured and with
+// the requested MAC, and redirects traffic between it and the veth, must run
+// in the container netns
+func setupTap(ifName, vethName, requestedMac string, tap *types.Tap, mtu int) (*current.Interface, error) {
+	veth, err := netif.Default.LinkByName(vethName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", vethName, err)
+	}
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = ifName
+	attrs.MTU = mtu
+	tapLink := &netlink.Tuntap{
+		LinkAttrs: attrs,
+		Mode:      netlink.TUNTAP_MODE_TAP,
+		Flags:     netlink.TUNTAP_NO_PI | netlink.TUNTAP_VNET_HDR,
+	}
+	if tap.Queues > 1 {
+		tapLink.Flags |= netlink.TUNTAP_MULTI_QUEUE
+	}
+	if tap.UID != nil {
+		tapLink.Owner = uint32(*tap.UID)
+	}
+	if tap.GID != nil {
+		tapLink.Group = uint32(*tap.GID)
+	}
+	if err := netif.Default.LinkAdd(tapLink); err != nil {
+		return nil, fmt.Errorf("failed to create tap device %s: %v", ifName, err)
+	}
+	link, err := netif.Default.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+	if requestedMac != "" {
+		mac, err := net.ParseMAC(requestedMac)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse MAC %q: %v", requestedMac, err)
+		}
+		if err := netif.Default.LinkSetHardwareAddr(link, mac); err != nil {
+			return nil, fmt.Errorf("failed to set MAC of %q: %v", ifName, err)
+		}
+		if link, err = netif.Default.LinkByName(ifName); err != nil {
+			return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+	}
+	if err := redirectIngress(veth, link); err != nil {
+		return nil, err
+	}
+	if err := redirectIngress(link, veth); err != nil {
+		return nil, err
+	}
+	for _, l := range []netlink.Link{veth, link} {
+		if err := netif.Default.LinkSetUp(l); err != nil {
+			return nil, fmt.Errorf("failed to set %q up: %v", l.Attrs().Name, err)
+		}
+	}
+	return &current.Interface{Name: ifName, Mac: link.Attrs().HardwareAddr.String(), Mtu: link.Attrs().MTU}, nil
+}
+
+// redirectIngress adds matchall filter redirecting all traffic received by
+// the link to the target
+func redirectIngress(link, target netlink.Link) error {
+	if err := netif.Default.QdiscAdd(ingressQdisc(link)); err != nil {
+		return fmt.Errorf("failed to add ingress qdisc to %s: %v", link.Attrs().Name, err)
+	}
+	filter := &netlink.MatchAll{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    ingressHandle,
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Actions: []netlink.Action{netlink.NewMirredAction(target.Attrs().Index)},
+	}
+	if err := netif.Default.FilterAdd(filter); err != nil {
+		return fmt.Errorf("failed to redirect traffic of %s to %s: %v", link.Attrs().Name, target.Attrs().Name, err)
+	}
+	return nil
+}
+
+// removeStaleTap removes the tap device, the veth and the port of the
+// attachment left by a previous partially failed ADD. The names of the tap
+// device and the veth are owned by the attachment.
+func removeStaleTap(ovsDriver *ovsdb.OvsBridgeDriver, contNetns ns.NetNS, ifName, vethName, contNetwork string) error {
+	portName, portFound, err := getOvsPortForContIface(ovsDriver, ifName, contNetns.Path(), contNetwork)
+	if err != nil {
+		return fmt.Errorf("failed to obtain OVS port for container iface %s: %v", ifName, err)
+	}
+	if portFound {
+		log.Printf("Info: removing port %s left by a previous attempt", portName)
+		if err := removeOvsPort(ovsDriver, portName); err != nil {
+			return err
+		}
+	}
+	return netns.Do(contNetns, func(_ ns.NetNS) error {
+		if link, err := netif.Default.LinkByName(ifName); err == nil {
+			if _, isTap := link.(*netlink.Tuntap); !isTap {
+				return fmt.Errorf("interface %s already exists in container netns and it is not a tap device", ifName)
+			}
+			if err := netif.Default.LinkDel(link); err != nil {
+				return fmt.Errorf("failed to remove stale tap device %s: %v", ifName, err)
+			}
+		}
+		if err := netif.Default.DelLinkByName(vethName); err != nil && err != ip.ErrLinkNotFound {
+			return fmt.Errorf("failed to remove stale container iface %s: %v", vethName, err)
+		}
+		return nil
+	})
+}
+
+// delTapVeth removes the veth of the tap attachment, the tap device is
+// removed as the container interface of other attachments. A veth which is
+// already gone is ignored.
+func delTapVeth(contNetnsPath, containerID, ifName string) error {
+	err := netns.WithPath(contNetnsPath, func(ns.NetNS) error {
+		return netif.Default.DelLinkByName(tapVethName(containerID, ifName))
+	})
+	if err != nil && !netns.IsGone(err) && err != ip.ErrLinkNotFound {
+		return err
+	}
+	return nil
+}
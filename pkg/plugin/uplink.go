@@ -0,0 +1,146 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// checkUplink verifies that an uplink of the bridge is up before the
+// attachment is created, so a pod doesn't silently end up without
+// connectivity. Depending on uplink_check it only logs a warning.
+func checkUplink(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf) error {
+	if netconf.UplinkCheck == "" {
+		return nil
+	}
+	states, err := ovsBridgeDriver.GetUplinkLinkStates(netconf.UplinkPorts)
+	if err != nil {
+		return fmt.Errorf("failed to check uplink of bridge %s: %v", netconf.BrName, err)
+	}
+
+	var problem string
+	var down []string
+	for _, name := range netconf.UplinkPorts {
+		if _, found := states[name]; !found {
+			down = append(down, name+" (not found)")
+		}
+	}
+	for name, up := range states {
+		if !up {
+			down = append(down, name)
+		}
+	}
+	sort.Strings(down)
+	switch {
+	case len(states) == 0 && len(netconf.UplinkPorts) == 0:
+		problem = fmt.Sprintf("bridge %s has no uplink port", netconf.BrName)
+	case len(down) > 0:
+		problem = fmt.Sprintf("uplink of bridge %s is not up: %v", netconf.BrName, down)
+	default:
+		return nil
+	}
+
+	if netconf.UplinkCheck == config.UplinkCheckFail {
+		return newError(cnitypes.ErrTryAgainLater, fmt.Errorf("%s", problem))
+	}
+	log.Printf("Warning: %s, the attachment may have no connectivity", problem)
+	return nil
+}
+
+// checkUplinkMTU compares mtu of the netconf with MTU of uplinks of the
+// bridge, traffic of an attachment with a larger MTU is fragmented or dropped
+// on the uplink. It warns naming the uplink or, depending on mtu_check,
+// clamps mtu to the MTU of the uplink. Failures to read uplinks are only
+// logged, it is an advisory check.
+func checkUplinkMTU(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf) {
+	if netconf.MTU == 0 || netconf.MTUCheck == config.MTUCheckOff {
+		return
+	}
+	uplinks, err := ovsBridgeDriver.GetUplinks(netconf.UplinkPorts)
+	if err != nil {
+		log.Printf("Failed to read MTU of uplinks of bridge %s: %v", netconf.BrName, err)
+		return
+	}
+	uplink, mtu := smallestUplinkMTU(uplinks)
+	if mtu == 0 || netconf.MTU <= mtu {
+		return
+	}
+	if netconf.MTUCheck == config.MTUCheckClamp {
+		log.Printf("Warning: mtu %d exceeds MTU %d of uplink %s of bridge %s, clamping it to %d", netconf.MTU, mtu, uplink, netconf.BrName, mtu)
+		netconf.MTU = mtu
+		return
+	}
+	log.Printf("Warning: mtu %d exceeds MTU %d of uplink %s of bridge %s, larger packets leaving the node will be fragmented or dropped", netconf.MTU, mtu, uplink, netconf.BrName)
+}
+
+// detectMTU sets mtu of the netconf without one to the smallest MTU of
+// uplinks of the bridge, or of its local interface when it has none, so
+// attachments of jumbo frame fabrics get jumbo frames without configuring
+// them in every network. Failures are only logged, the kernel default is
+// used then.
+func detectMTU(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, userspaceMode bool) {
+	// vhost-user interfaces and userspace VFs are configured by the pod
+	if netconf.MTU != 0 || isVhostUserMode(netconf) || userspaceMode {
+		return
+	}
+	uplinks, err := ovsBridgeDriver.GetUplinks(netconf.UplinkPorts)
+	if err != nil {
+		log.Printf("Failed to detect MTU of uplinks of bridge %s: %v", netconf.BrName, err)
+		return
+	}
+	if uplink, mtu := smallestUplinkMTU(uplinks); mtu != 0 {
+		log.Printf("Using MTU %d of uplink %s of bridge %s", mtu, uplink, netconf.BrName)
+		netconf.MTU = mtu
+		return
+	}
+	// the bridge of a remote OVSDB, e.g. on a DPU, has no local interface
+	if netconf.BridgeSocketFile != "" {
+		return
+	}
+	link, err := netif.Default.LinkByName(netconf.BrName)
+	if err != nil {
+		log.Printf("Failed to detect MTU of bridge %s: %v", netconf.BrName, err)
+		return
+	}
+	log.Printf("Using MTU %d of bridge %s without uplinks", link.Attrs().MTU, netconf.BrName)
+	netconf.MTU = link.Attrs().MTU
+}
+
+// smallestUplinkMTU returns the uplink with the smallest known MTU, the
+// first by name when several have it
+func smallestUplinkMTU(uplinks map[string]ovsdb.Uplink) (string, int) {
+	names := make([]string, 0, len(uplinks))
+	for name := range uplinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var smallest string
+	var mtu int
+	for _, name := range names {
+		if uplinkMTU := uplinks[name].MTU; uplinkMTU > 0 && (mtu == 0 || uplinkMTU < mtu) {
+			smallest, mtu = name, uplinkMTU
+		}
+	}
+	return smallest, mtu
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+)
+
+var _ = Describe("smallestUplinkMTU", func() {
+	It("should return the uplink with the smallest known MTU", func() {
+		uplink, mtu := smallestUplinkMTU(map[string]ovsdb.Uplink{
+			"eth2":  {Up: true, MTU: 9000},
+			"bond0": {Up: true, MTU: 1500},
+			"eth1":  {Up: true, MTU: 1500},
+			"dpdk0": {Up: true},
+		})
+		Expect(uplink).To(Equal("bond0"))
+		Expect(mtu).To(Equal(1500))
+	})
+	It("should return no MTU when none is known", func() {
+		uplink, mtu := smallestUplinkMTU(map[string]ovsdb.Uplink{"dpdk0": {Up: true}})
+		Expect(uplink).To(BeEmpty())
+		Expect(mtu).To(BeZero())
+	})
+})
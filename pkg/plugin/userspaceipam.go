@@ -0,0 +1,206 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netif"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+const (
+	// userspaceIPAMPortPrefix is prepended to names of internal ports
+	// carrying IPAM addresses of userspace VF attachments
+	userspaceIPAMPortPrefix = "ovsm"
+	// userspaceIPAMPortTimeout is how long ovs-vswitchd is given to create
+	// the netdevice of the internal port
+	userspaceIPAMPortTimeout = 5 * time.Second
+)
+
+// userspaceIPAMPortName returns the name of the internal port of the
+// attachment, it is stable so the port can be found on DEL
+func userspaceIPAMPortName(netName, containerID, ifName string) string {
+	hash := sha256.Sum256([]byte(config.GetNetworkCRef(netName, containerID, ifName)))
+	return userspaceIPAMPortPrefix + hex.EncodeToString(hash[:])[:11]
+}
+
+// cachedUserspaceIPAMPort returns the name of the internal port of the cached
+// attachment, older versions didn't cache it and named it without the network
+func cachedUserspaceIPAMPort(cache *types.CachedNetConf) string {
+	if cache.UserspaceIPAMPort != "" {
+		return cache.UserspaceIPAMPort
+	}
+	hash := sha256.Sum256([]byte(cache.ContainerID + "/" + cache.IfName))
+	return userspaceIPAMPortPrefix + hex.EncodeToString(hash[:])[:11]
+}
+
+// hasUserspaceIPAM returns true when addresses of a userspace VF attachment
+// are configured on a companion internal port
+func hasUserspaceIPAM(netconf *types.NetConf, userspaceMode bool) bool {
+	return userspaceMode && netconf.UserspaceIPAM && netconf.IPAM.Type != ""
+}
+
+// addUserspaceIPAM runs IPAM for a VF bound to a userspace driver, which has
+// no netdevice to carry the addresses. They are configured on an OVS internal
+// port on the same bridge and VLAN moved into the container netns instead,
+// so the pod has management connectivity next to its userspace datapath.
+// The port is added to the result.
+func addUserspaceIPAM(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, args *skel.CmdArgs, contNetns ns.NetNS, ipamStdinData []byte, vlanTag uint, trunks []uint, portType, contPodUid string, result *current.Result) (err error) {
+	if err = setupIPAMEnv(netconf); err != nil {
+		return err
+	}
+	ipamPlugins, err := ipamPluginsOf(netconf, ipamStdinData)
+	if err != nil {
+		return err
+	}
+	ipamResult, err := execIPAMAdd(ipamPlugins)
+	defer func() {
+		if err != nil {
+			if err := execIPAMDel(ipamPlugins); err != nil {
+				log.Printf("Failed best-effort cleanup IPAM configuration: %v", err)
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	if len(ipamResult.IPs) == 0 {
+		return errors.New("IPAM plugin returned missing IP config")
+	}
+
+	portName := userspaceIPAMPortName(netconf.Name, args.ContainerID, args.IfName)
+	if err = ovsBridgeDriver.CreatePort(ovsdb.PortOptions{
+		Name:        portName,
+		ContNetns:   args.Netns,
+		ContIface:   portName,
+		ContNetwork: netconf.Name,
+		ContPodUID:  contPodUid,
+		ContID:      args.ContainerID,
+		VlanTag:     vlanTag,
+		Trunks:      trunks,
+		VlanMode:    portType,
+		QinQEthType: qinqEthType(netconf),
+		IntfType:    "internal",
+	}); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err := removeOvsPort(ovsBridgeDriver, portName); err != nil {
+				log.Printf("Failed best-effort cleanup: %v", err)
+			}
+		}
+	}()
+	link, err := waitForLink(portName, userspaceIPAMPortTimeout)
+	if err != nil {
+		return err
+	}
+	if netconf.MTU != 0 {
+		if err = netif.Default.LinkSetMTU(link, netconf.MTU); err != nil {
+			return fmt.Errorf("failed to set MTU on %s: %v", portName, err)
+		}
+	}
+	if err = netif.Default.LinkSetNs(link, contNetns); err != nil {
+		return fmt.Errorf("failed to move %s to container netns: %v", portName, err)
+	}
+
+	iface := &current.Interface{Name: portName, Sandbox: contNetns.Path()}
+	for _, ipc := range ipamResult.IPs {
+		// All addresses apply to the internal port
+		ipc.Interface = current.Int(0)
+	}
+	ipamResult.Interfaces = []*current.Interface{iface}
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		if err := setInterfaceUp(portName); err != nil {
+			return err
+		}
+		if err := ipam.ConfigureIface(portName, ipamResult); err != nil {
+			return err
+		}
+		contPort, err := net.InterfaceByName(portName)
+		if err != nil {
+			return fmt.Errorf("failed to look up %q: %v", portName, err)
+		}
+		iface.Mac = contPort.HardwareAddr.String()
+		iface.Mtu = contPort.MTU
+		announceIPs(contPort, ipamResult.IPs, netconf.Garp)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	result.Interfaces = append(result.Interfaces, iface)
+	for _, ipc := range ipamResult.IPs {
+		ipc.Interface = current.Int(len(result.Interfaces) - 1)
+	}
+	result.IPs = ipamResult.IPs
+	result.Routes = ipamResult.Routes
+	result.DNS = ipamResult.DNS
+	return nil
+}
+
+// delUserspaceIPAMPort removes the internal port of a userspace VF
+// attachment, its netdevice is removed from the container netns with it
+func delUserspaceIPAMPort(ovsBridgeDriver *ovsdb.OvsBridgeDriver, portName string) error {
+	if _, err := ovsBridgeDriver.GetPortUUID(portName); err != nil {
+		return nil
+	}
+	return removeOvsPort(ovsBridgeDriver, portName)
+}
+
+// delUserspaceIPAM removes the internal port and releases its addresses
+func delUserspaceIPAM(ovsBridgeDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, args *skel.CmdArgs, ipamStdinData []byte) error {
+	if err := delUserspaceIPAMPort(ovsBridgeDriver, userspaceIPAMPortName(netconf.Name, args.ContainerID, args.IfName)); err != nil {
+		return err
+	}
+	ipamPlugins, err := ipamPluginsOf(netconf, ipamStdinData)
+	if err != nil {
+		return err
+	}
+	return execIPAMDel(ipamPlugins)
+}
+
+// waitForLink waits for the netdevice of the given name to appear in the
+// current netns
+func waitForLink(name string, timeout time.Duration) (netlink.Link, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		link, err := netif.Default.LinkByName(name)
+		if err == nil {
+			return link, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("netdevice of internal port %s was not created: %v", name, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
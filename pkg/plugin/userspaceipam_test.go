@@ -0,0 +1,56 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("IPAM of userspace VFs", func() {
+	It("should name the internal port after the attachment", func() {
+		name := userspaceIPAMPortName("mynet", "container1", "net1")
+		Expect(name).To(HavePrefix(userspaceIPAMPortPrefix))
+		Expect(len(name)).To(Equal(15))
+		Expect(userspaceIPAMPortName("mynet", "container1", "net1")).To(Equal(name))
+		Expect(userspaceIPAMPortName("mynet", "container1", "net2")).NotTo(Equal(name))
+		Expect(userspaceIPAMPortName("othernet", "container1", "net1")).NotTo(Equal(name))
+	})
+	It("should find the internal port of cached attachments", func() {
+		cache := &types.CachedNetConf{ContainerID: "container1", IfName: "net1", UserspaceIPAMPort: "ovsm0123456789a"}
+		Expect(cachedUserspaceIPAMPort(cache)).To(Equal("ovsm0123456789a"))
+		// entries of older versions don't record it
+		cache.UserspaceIPAMPort = ""
+		legacy := cachedUserspaceIPAMPort(cache)
+		Expect(legacy).To(HavePrefix(userspaceIPAMPortPrefix))
+		Expect(legacy).NotTo(Equal(userspaceIPAMPortName("mynet", "container1", "net1")))
+	})
+	It("should be used only for userspace VFs with IPAM", func() {
+		netconf := &types.NetConf{NetConf: cnitypes.NetConf{IPAM: cnitypes.IPAM{Type: "static"}}, UserspaceIPAM: true}
+		Expect(hasUserspaceIPAM(netconf, true)).To(BeTrue())
+		Expect(hasUserspaceIPAM(netconf, false)).To(BeFalse())
+		netconf.IPAM.Type = ""
+		Expect(hasUserspaceIPAM(netconf, true)).To(BeFalse())
+	})
+	It("should give up waiting for a missing netdevice", func() {
+		_, err := waitForLink("ovsmmissing", 200*time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("netdevice of internal port ovsmmissing was not created")))
+	})
+})
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"log"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// origVFTuning returns channels and coalescing of the VFs, by their original
+// names, which vf_tuning of the netconf is going to change. The VFs must be
+// in the host namespace.
+func origVFTuning(netconf *types.NetConf, origIfNames []string) (map[string]*types.VFTuning, error) {
+	if netconf.VFTuning == nil || len(origIfNames) == 0 {
+		return nil, nil
+	}
+	orig := make(map[string]*types.VFTuning, len(origIfNames))
+	for _, origIfName := range origIfNames {
+		tuning, err := sriov.OrigVFTuning(origIfName, netconf.VFTuning)
+		if err != nil {
+			return nil, err
+		}
+		orig[origIfName] = tuning
+	}
+	return orig, nil
+}
+
+// restoreVFTuning restores channels and coalescing of the VFs of the cached
+// attachment once they are back in the host namespace. It is best-effort,
+// a VF may not have been released.
+func restoreVFTuning(cache *types.CachedNetConf) {
+	for origIfName, tuning := range cache.OrigVFTuning {
+		if err := sriov.RestoreVFTuning(origIfName, tuning); err != nil {
+			log.Printf("Failed best-effort restore of tuning of VF %s: %v", origIfName, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ethtool"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("VF tuning", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		// veths stand in for VF netdevices of a bonded attachment
+		Expect(testNS.Do(func(ns.NetNS) error {
+			for _, name := range []string{"vf0", "vf1"} {
+				attrs := netlink.LinkAttrs{Name: name, NumRxQueues: 4, NumTxQueues: 4}
+				if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: attrs, PeerName: name + "peer"}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})).To(Succeed())
+	})
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("should not save anything without vf_tuning", func() {
+		orig, err := origVFTuning(&types.NetConf{}, []string{"vf0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(orig).To(BeNil())
+	})
+	It("should restore channels of all VFs saved in the cache", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			before, err := ethtool.GetChannels("vf0")
+			Expect(err).NotTo(HaveOccurred())
+			one := uint32(1)
+			netconf := &types.NetConf{VFTuning: &types.VFTuning{Channels: &types.VFChannels{Tx: &one}}}
+
+			orig, err := origVFTuning(netconf, []string{"vf0", "vf1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orig).To(HaveKey("vf0"))
+			Expect(orig).To(HaveKey("vf1"))
+			for _, name := range []string{"vf0", "vf1"} {
+				Expect(ethtool.SetChannels(name, ethtool.Channels{Tx: &one})).To(Succeed())
+			}
+
+			restoreVFTuning(&types.CachedNetConf{Netconf: netconf, OrigVFTuning: orig})
+			for _, name := range []string{"vf0", "vf1"} {
+				restored, err := ethtool.GetChannels(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restored).To(Equal(before), name)
+			}
+			return nil
+		})).To(Succeed())
+	})
+	It("should keep restoring when a VF is missing", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			before, err := ethtool.GetChannels("vf1")
+			Expect(err).NotTo(HaveOccurred())
+			one := uint32(1)
+			Expect(ethtool.SetChannels("vf1", ethtool.Channels{Rx: &one})).To(Succeed())
+
+			restoreVFTuning(&types.CachedNetConf{OrigVFTuning: map[string]*types.VFTuning{
+				"missing0": {Channels: &types.VFChannels{Rx: before.Rx}},
+				"vf1":      {Channels: &types.VFChannels{Rx: before.Rx}},
+			}})
+			restored, err := ethtool.GetChannels("vf1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored).To(Equal(before))
+			return nil
+		})).To(Succeed())
+	})
+})
@@ -0,0 +1,230 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"golang.org/x/sys/unix"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+const (
+	// vhostUserSocketName is the name of the socket in the attachment directory
+	vhostUserSocketName = "socket"
+	// vhostUserPortPrefix is prepended to names of vhost-user ports
+	vhostUserPortPrefix = "vhu"
+	// selinuxXattr holds SELinux label of a file
+	selinuxXattr = "security.selinux"
+)
+
+// isVhostUserMode returns true when the attachment is a vhost-user socket
+// instead of a veth pair
+func isVhostUserMode(netconf *types.NetConf) bool {
+	return netconf.InterfaceType == config.VhostUserInterfaceType
+}
+
+// vhostUserPortName returns the name of the OVS port of the attachment, it is
+// stable so the port can be found without the netns of the container
+func vhostUserPortName(containerID, ifName string) string {
+	hash := sha256.Sum256([]byte(containerID + "/" + ifName))
+	return vhostUserPortPrefix + hex.EncodeToString(hash[:])[:11]
+}
+
+// vhostUserSocketDir returns the directory holding the socket of the attachment
+func vhostUserSocketDir(netconf *types.NetConf, containerID, ifName string) string {
+	socketDir := config.DefaultVhostUserSocketDir
+	if netconf.VhostUser != nil && netconf.VhostUser.SocketDir != "" {
+		socketDir = netconf.VhostUser.SocketDir
+	}
+	return filepath.Join(socketDir, config.GetNetworkCRef(netconf.Name, containerID, ifName))
+}
+
+// existingVhostUserSocketDir returns the socket directory of an existing
+// attachment, attachments created by older versions have it named without
+// the network
+func existingVhostUserSocketDir(netconf *types.NetConf, containerID, ifName string) string {
+	socketDir := vhostUserSocketDir(netconf, containerID, ifName)
+	if _, err := os.Stat(socketDir); os.IsNotExist(err) {
+		legacyDir := filepath.Join(filepath.Dir(socketDir), config.GetCRef(containerID, ifName))
+		if _, err := os.Stat(legacyDir); err == nil {
+			return legacyDir
+		}
+	}
+	return socketDir
+}
+
+// prepareVhostUserSocketDir creates the socket directory of the attachment
+// owned and labeled as configured, so an unprivileged pod can create the
+// socket in it
+func prepareVhostUserSocketDir(dir string, vhostUser *types.VhostUser) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create vhost-user socket dir: %v", err)
+	}
+	if err := os.Mkdir(dir, 0770); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create vhost-user socket dir: %v", err)
+	}
+	// mode requested by Mkdir is subject to umask
+	if err := os.Chmod(dir, 0770); err != nil {
+		return fmt.Errorf("failed to set mode of vhost-user socket dir: %v", err)
+	}
+	if vhostUser == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if vhostUser.UID != nil {
+		uid = *vhostUser.UID
+	}
+	if vhostUser.GID != nil {
+		gid = *vhostUser.GID
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return fmt.Errorf("failed to set owner of vhost-user socket dir: %v", err)
+		}
+	}
+	if vhostUser.SELinuxContext != "" {
+		if err := unix.Setxattr(dir, selinuxXattr, []byte(vhostUser.SELinuxContext+"\x00"), 0); err != nil {
+			return fmt.Errorf("failed to set SELinux context of vhost-user socket dir: %v", err)
+		}
+	}
+	return nil
+}
+
+// vhostUserQueueOptions returns options of the vhost-user interface setting
+// its queues, only the configured ones are set
+func vhostUserQueueOptions(vhostUser *types.VhostUser) map[string]string {
+	options := map[string]string{}
+	if vhostUser == nil || vhostUser.Queues == nil {
+		return options
+	}
+	for name, value := range map[string]uint{
+		"n_rxq":      vhostUser.Queues.NRxq,
+		"n_txq":      vhostUser.Queues.NTxq,
+		"n_rxq_desc": vhostUser.Queues.NRxqDesc,
+		"n_txq_desc": vhostUser.Queues.NTxqDesc,
+	} {
+		if value != 0 {
+			options[name] = strconv.FormatUint(uint64(value), 10)
+		}
+	}
+	return options
+}
+
+// addVhostUser attaches a vhost-user port to the bridge, OVS connects to the
+// socket created by the pod in the attachment directory
+func addVhostUser(args *skel.CmdArgs, netconf *types.NetConf, envArgs *EnvArgs, ovsBridgeDriver *ovsdb.OvsBridgeDriver, vlanTag uint, trunks []uint, portType, ovnPort, contPodUid string) (err error) {
+	socketDir := vhostUserSocketDir(netconf, args.ContainerID, args.IfName)
+	socketPath := filepath.Join(socketDir, vhostUserSocketName)
+
+	// Cache NetConf for CmdDel
+	if err = utils.SaveCache(config.GetNetworkCRef(netconf.Name, args.ContainerID, args.IfName),
+		&types.CachedNetConf{Netconf: netconf, ContainerID: args.ContainerID, IfName: args.IfName, Netns: args.Netns}); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+
+	if err = prepareVhostUserSocketDir(socketDir, netconf.VhostUser); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err := os.RemoveAll(socketDir); err != nil {
+				log.Printf("Failed best-effort cleanup: %v", err)
+			}
+		}
+	}()
+
+	options := vhostUserQueueOptions(netconf.VhostUser)
+	options["vhost-server-path"] = socketPath
+	portName := vhostUserPortName(args.ContainerID, args.IfName)
+	if err = ovsBridgeDriver.CreatePort(ovsdb.PortOptions{
+		Name:          portName,
+		ContNetns:     args.Netns,
+		ContIface:     args.IfName,
+		ContNetwork:   netconf.Name,
+		ContPodUID:    contPodUid,
+		ContID:        args.ContainerID,
+		OvnPort:       ovnPort,
+		OfportRequest: netconf.OfportRequest,
+		VlanTag:       vlanTag,
+		Trunks:        trunks,
+		VlanMode:      portType,
+		QinQEthType:   qinqEthType(netconf),
+		IntfType:      netconf.InterfaceType,
+		IntfOptions:   options,
+	}); err != nil {
+		return err
+	}
+	if err = setupRateLimit(ovsBridgeDriver, netconf, portName); err != nil {
+		if err := removeOvsPort(ovsBridgeDriver, portName); err != nil {
+			log.Printf("Failed best-effort cleanup: %v", err)
+		}
+		return err
+	}
+
+	result := &current.Result{
+		Interfaces: []*current.Interface{{
+			Name:       args.IfName,
+			Sandbox:    args.Netns,
+			SocketPath: socketPath,
+		}},
+	}
+	status := networkStatus(netconf, args.IfName, vlanTag, result)
+	if err = saveDeviceInfo(netconf, args.ContainerID, args.IfName, status.DeviceInfo); err != nil {
+		if err := removeOvsPort(ovsBridgeDriver, portName); err != nil {
+			log.Printf("Failed best-effort cleanup: %v", err)
+		}
+		return err
+	}
+	publishNetworkStatus(netconf, envArgs, args.IfName, status)
+	return cnitypes.PrintResult(result, netconf.CNIVersion)
+}
+
+// delVhostUser removes the port and the socket directory of the attachment
+func delVhostUser(ovsBridgeDriver *ovsdb.OvsBridgeDriver, containerID, ifName string, netconf *types.NetConf) error {
+	portName := vhostUserPortName(containerID, ifName)
+	if _, err := ovsBridgeDriver.GetPortUUID(portName); err == nil {
+		if err := removeOvsPort(ovsBridgeDriver, portName); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(existingVhostUserSocketDir(netconf, containerID, ifName))
+}
+
+// checkVhostUser verifies the port and the socket directory of the attachment
+func checkVhostUser(ovsBridgeDriver *ovsdb.OvsBridgeDriver, args *skel.CmdArgs, netconf *types.NetConf) error {
+	portName := vhostUserPortName(args.ContainerID, args.IfName)
+	if _, err := ovsBridgeDriver.GetPortUUID(portName); err != nil {
+		return fmt.Errorf("vhost-user port %s is not found in ovs: %v", portName, err)
+	}
+	socketDir := existingVhostUserSocketDir(netconf, args.ContainerID, args.IfName)
+	if _, err := os.Stat(socketDir); err != nil {
+		return fmt.Errorf("vhost-user socket dir of %s is missing: %v", portName, err)
+	}
+	return nil
+}
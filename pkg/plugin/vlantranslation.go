@@ -0,0 +1,226 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/openflow"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// priorities of flows of VLAN translation
+const (
+	vlanTranslationPriorityIngress = 300
+	vlanTranslationPriorityEgress  = 250
+)
+
+// multicastMAC matches broadcast and multicast destinations
+const multicastMAC = "01:00:00:00:00:00/01:00:00:00:00:00"
+
+// translatedPort is a port a bridge VLAN is translated to a container VLAN on
+type translatedPort struct {
+	port          string
+	containerVlan uint
+}
+
+// vlanTranslationCookie returns cookie of the flows of the attachment
+func vlanTranslationCookie(netName, containerID, ifName string) uint64 {
+	hash := sha256.Sum256([]byte("vlan-translation/" + config.GetNetworkCRef(netName, containerID, ifName)))
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+// legacyVlanTranslationCookie returns cookie of the flows of attachments
+// created by older versions, which didn't scope it by the network
+func legacyVlanTranslationCookie(containerID, ifName string) uint64 {
+	hash := sha256.Sum256([]byte("vlan-translation/" + containerID + "/" + ifName))
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+// vlanTranslationFloodCookie returns cookie of the flow flooding the bridge
+// VLAN to translated ports, it is shared by all attachments of the VLAN
+func vlanTranslationFloodCookie(bridgeVlan uint) uint64 {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("vlan-translation-flood/%d", bridgeVlan)))
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+// vlanTranslationFlows returns flows of the attachment: container VLANs sent
+// by the port are rewritten to bridge VLANs and forwarded as if they were
+// received with them, traffic of bridge VLANs to the MAC of the container is
+// rewritten back and sent to the port
+func vlanTranslationFlows(cookie uint64, portName, podMAC string, translations []*types.VlanTranslation) []openflow.Flow {
+	var flows []openflow.Flow
+	for _, translation := range translations {
+		flows = append(flows, openflow.Flow{
+			Cookie:   cookie,
+			Priority: vlanTranslationPriorityIngress,
+			Match:    fmt.Sprintf("in_port=%s,dl_vlan=%d", portName, translation.Container),
+			Actions:  fmt.Sprintf("mod_vlan_vid:%d,resubmit(,0)", translation.Bridge),
+		}, openflow.Flow{
+			Cookie:   cookie,
+			Priority: vlanTranslationPriorityEgress,
+			Match:    fmt.Sprintf("dl_vlan=%d,dl_dst=%s", translation.Bridge, podMAC),
+			Actions:  fmt.Sprintf("mod_vlan_vid:%d,output:%s", translation.Container, portName),
+		})
+	}
+	return flows
+}
+
+// vlanTranslationFloodFlow returns the flow sending broadcast and multicast
+// of the bridge VLAN to each translated port with its container VLAN, before
+// the bridge floods it to other ports. Translated ports are excluded from
+// flooding of the bridge.
+func vlanTranslationFloodFlow(bridgeVlan uint, ports []translatedPort) openflow.Flow {
+	actions := make([]string, 0, len(ports)+1)
+	for _, port := range ports {
+		actions = append(actions, fmt.Sprintf("mod_vlan_vid:%d,output:%s", port.containerVlan, port.port))
+	}
+	actions = append(actions, fmt.Sprintf("mod_vlan_vid:%d,NORMAL", bridgeVlan))
+	return openflow.Flow{
+		Cookie:   vlanTranslationFloodCookie(bridgeVlan),
+		Priority: vlanTranslationPriorityEgress,
+		Match:    fmt.Sprintf("dl_vlan=%d,dl_dst=%s", bridgeVlan, multicastMAC),
+		Actions:  strings.Join(actions, ","),
+	}
+}
+
+// translatedPorts returns ports of cached attachments of the bridge which
+// translate to the bridge VLANs, except for the excluded attachment
+func translatedPorts(bridgeName string, bridgeVlans []uint, exclude *types.CachedNetConf) (map[uint][]translatedPort, error) {
+	keys, err := utils.ListCache()
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[uint]bool{}
+	for _, vlan := range bridgeVlans {
+		wanted[vlan] = true
+	}
+	ports := map[uint][]translatedPort{}
+	for _, cRef := range keys {
+		cache, err := config.LoadConfFromCache(cRef)
+		if err != nil || cache.Netconf == nil || cache.Netconf.BrName != bridgeName || cache.VlanTranslationPort == "" {
+			continue
+		}
+		if exclude != nil && sameAttachment(cache, exclude) {
+			continue
+		}
+		for _, translation := range cache.Netconf.VlanTranslation {
+			if translation != nil && wanted[translation.Bridge] {
+				ports[translation.Bridge] = append(ports[translation.Bridge], translatedPort{port: cache.VlanTranslationPort, containerVlan: translation.Container})
+			}
+		}
+	}
+	for _, vlanPorts := range ports {
+		sort.Slice(vlanPorts, func(i, j int) bool { return vlanPorts[i].port < vlanPorts[j].port })
+	}
+	return ports, nil
+}
+
+// syncVlanTranslationFlood rebuilds flood flows of the bridge VLANs from the
+// cached attachments, the flow of a VLAN without translated ports is removed.
+// It must be called with the bridge locked.
+func syncVlanTranslationFlood(ofClient *openflow.Client, bridgeName string, bridgeVlans []uint, exclude *types.CachedNetConf) error {
+	ports, err := translatedPorts(bridgeName, bridgeVlans, exclude)
+	if err != nil {
+		return err
+	}
+	var flows []openflow.Flow
+	for _, vlan := range bridgeVlans {
+		if len(ports[vlan]) > 0 {
+			flows = append(flows, vlanTranslationFloodFlow(vlan, ports[vlan]))
+			continue
+		}
+		if err := ofClient.DeleteFlows(vlanTranslationFloodCookie(vlan)); err != nil {
+			return err
+		}
+	}
+	return ofClient.AddFlows(flows)
+}
+
+// sameAttachment returns true when both cache entries are of the same
+// attachment of the network
+func sameAttachment(a, b *types.CachedNetConf) bool {
+	return a.ContainerID == b.ContainerID && a.IfName == b.IfName && a.Netconf.Name == b.Netconf.Name
+}
+
+// bridgeVlansOf returns the bridge VLANs of the translations
+func bridgeVlansOf(translations []*types.VlanTranslation) []uint {
+	vlans := make([]uint, 0, len(translations))
+	for _, translation := range translations {
+		vlans = append(vlans, translation.Bridge)
+	}
+	return vlans
+}
+
+// setupVlanTranslation records the port of the attachment in the cache and
+// in external_ids of the port, from which the cache can be rebuilt, and
+// installs its translation flows, the port is excluded from flooding of the
+// bridge as flooded traffic reaches it translated by the flood flows
+func setupVlanTranslation(ovsDriver *ovsdb.OvsBridgeDriver, cRef string, cachedNetConf *types.CachedNetConf, portName, podMAC string) error {
+	netconf := cachedNetConf.Netconf
+	if podMAC == "" {
+		return fmt.Errorf("vlan_translation requires MAC address of the attachment")
+	}
+	cachedNetConf.VlanTranslationPort = portName
+	if err := utils.SaveCache(cRef, cachedNetConf); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+	if err := ovsDriver.SetPortExternalIDs(portName, map[string]string{ovsdb.VlanTranslationKey: "true"}); err != nil {
+		return err
+	}
+
+	lock, err := lockBridge(netconf.BrName)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	ofClient := openflow.NewClient(netconf.BrName)
+	flows := vlanTranslationFlows(vlanTranslationCookie(netconf.Name, cachedNetConf.ContainerID, cachedNetConf.IfName), portName, podMAC, netconf.VlanTranslation)
+	if err := ofClient.AddFlows(flows); err != nil {
+		return err
+	}
+	if err := ofClient.SetPortFlood(portName, false); err != nil {
+		return err
+	}
+	return syncVlanTranslationFlood(ofClient, netconf.BrName, bridgeVlansOf(netconf.VlanTranslation), nil)
+}
+
+// teardownVlanTranslation removes flows of the attachment and its port from
+// flood flows of the bridge VLANs, the attachment may still be cached
+func teardownVlanTranslation(cache *types.CachedNetConf) error {
+	netconf := cache.Netconf
+	lock, err := lockBridge(netconf.BrName)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	ofClient := openflow.NewClient(netconf.BrName)
+	for _, cookie := range []uint64{
+		vlanTranslationCookie(netconf.Name, cache.ContainerID, cache.IfName),
+		legacyVlanTranslationCookie(cache.ContainerID, cache.IfName),
+	} {
+		if err := ofClient.DeleteFlows(cookie); err != nil {
+			return err
+		}
+	}
+	return syncVlanTranslationFlood(ofClient, netconf.BrName, bridgeVlansOf(netconf.VlanTranslation), cache)
+}
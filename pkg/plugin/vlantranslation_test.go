@@ -0,0 +1,37 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("VLAN translation flows", func() {
+	It("should translate container VLANs both ways", func() {
+		flows := vlanTranslationFlows(0x1, "veth1", "0a:58:0a:01:03:05", []*types.VlanTranslation{{Container: 100, Bridge: 1100}})
+		Expect(flows).To(HaveLen(2))
+		Expect(flows[0].String()).To(Equal("cookie=0x1,table=0,priority=300,in_port=veth1,dl_vlan=100,actions=mod_vlan_vid:1100,resubmit(,0)"))
+		Expect(flows[1].String()).To(Equal("cookie=0x1,table=0,priority=250,dl_vlan=1100,dl_dst=0a:58:0a:01:03:05,actions=mod_vlan_vid:100,output:veth1"))
+	})
+	It("should flood the bridge VLAN to all translated ports", func() {
+		flow := vlanTranslationFloodFlow(1100, []translatedPort{{port: "veth1", containerVlan: 100}, {port: "veth2", containerVlan: 200}})
+		Expect(flow.Cookie).To(Equal(vlanTranslationFloodCookie(1100)))
+		Expect(flow.Match).To(Equal("dl_vlan=1100,dl_dst=01:00:00:00:00:00/01:00:00:00:00:00"))
+		Expect(flow.Actions).To(Equal("mod_vlan_vid:100,output:veth1,mod_vlan_vid:200,output:veth2,mod_vlan_vid:1100,NORMAL"))
+	})
+})
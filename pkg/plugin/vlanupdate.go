@@ -0,0 +1,126 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// portVlan returns VLAN ID, trunks and vlan_mode of ports of the validated
+// netconf
+func portVlan(netconf *types.NetConf) (uint, []uint, string) {
+	var vlanTag uint
+	if netconf.VlanTag != nil {
+		vlanTag = *netconf.VlanTag
+	}
+	if netconf.QinQ != nil {
+		vlanTag = netconf.QinQ.SVlan
+	}
+	trunks := make([]uint, 0)
+	if len(netconf.Trunk) > 0 {
+		trunks = append(trunks, splitVlanIds(netconf.Trunk)...)
+	}
+	if len(netconf.VlanTranslation) > 0 {
+		// the port carries bridge VLANs of translated traffic
+		trunks = append(trunks, bridgeVlansOf(netconf.VlanTranslation)...)
+		sort.Slice(trunks, func(i, j int) bool { return trunks[i] < trunks[j] })
+	}
+	return vlanTag, trunks, vlanMode(netconf)
+}
+
+// attachmentPorts returns names of all OVS ports of the cached attachment
+func attachmentPorts(ovsBridgeDriver *ovsdb.OvsBridgeDriver, cache *types.CachedNetConf, containerID, ifName string) ([]string, error) {
+	if isVhostUserMode(cache.Netconf) {
+		return []string{vhostUserPortName(containerID, ifName)}, nil
+	}
+	if cache.Netns == "" {
+		return nil, fmt.Errorf("netns of attachment %s is not cached", config.GetNetworkCRef(cache.Netconf.Name, containerID, ifName))
+	}
+	portName, portFound, err := getOvsPortForContIface(ovsBridgeDriver, ifName, cache.Netns, cache.Netconf.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain OVS port of attachment %s: %v", config.GetNetworkCRef(cache.Netconf.Name, containerID, ifName), err)
+	}
+	if !portFound {
+		return nil, fmt.Errorf("OVS port of attachment %s is not found", config.GetNetworkCRef(cache.Netconf.Name, containerID, ifName))
+	}
+	portNames := []string{portName}
+	if cache.BackupPort != "" {
+		portNames = append(portNames, cache.BackupPort)
+	}
+	if hasUserspaceIPAM(cache.Netconf, cache.UserspaceMode) {
+		portNames = append(portNames, cachedUserspaceIPAMPort(cache))
+	}
+	return portNames, nil
+}
+
+// UpdateAttachmentVlan changes VLAN ID and trunks of a running attachment of
+// the network, so its VLAN can be changed without recreating the pod. Ports
+// of the attachment are updated in a single OVSDB transaction, which is
+// reverted when the cached NetConf can't be updated. A nil vlanTag with no
+// trunks makes the ports trunks of all VLANs.
+func UpdateAttachmentVlan(network, containerID, ifName string, vlanTag *uint, trunk []*types.Trunk) error {
+	cache, cRef, err := config.LoadNetworkConfFromCache(network, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	if cache.Delegated {
+		return fmt.Errorf("attachment %s is not attached to OVS", cRef)
+	}
+
+	updated := *cache.Netconf
+	updated.VlanTag = vlanTag
+	updated.Trunk = trunk
+	if err := config.Validate(&updated); err != nil {
+		return err
+	}
+	oldTag, oldTrunks, oldMode := portVlan(cache.Netconf)
+	newTag, newTrunks, newMode := portVlan(&updated)
+
+	ovsBridgeDriver, err := delBridgeDriver(cache.Netconf, "")
+	if err != nil {
+		return err
+	}
+	lock, err := lockBridge(ovsBridgeDriver.OvsBridgeName)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	portNames, err := attachmentPorts(ovsBridgeDriver, cache, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	if err := ovsBridgeDriver.SetPortsVlan(portNames, newTag, newTrunks, newMode); err != nil {
+		return err
+	}
+	cache.Netconf = &updated
+	cache.VlanUpdated = true
+	if err := utils.SaveCache(cRef, cache); err != nil {
+		if err := ovsBridgeDriver.SetPortsVlan(portNames, oldTag, oldTrunks, oldMode); err != nil {
+			log.Printf("Failed to restore vlan of ports %v: %v", portNames, err)
+		}
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+	forgetCheck(cRef)
+	log.Printf("Changed vlan of ports %v of attachment %s to mode %s, tag %d, trunks %v", portNames, cRef, newMode, newTag, newTrunks)
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sriov
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// bondedVFMiimon is the link monitoring interval of the bond of VFs in
+// milliseconds, failure of a VF or its PF is detected through its carrier
+const bondedVFMiimon = 100
+
+// maxIfNameLen is the longest name of a network interface
+const maxIfNameLen = 15
+
+// BondedVFName returns name of a VF of a bonded attachment in the container
+// namespace, ifName is the name of the bond
+func BondedVFName(ifName string, index int) string {
+	suffix := fmt.Sprintf("v%d", index)
+	if len(ifName)+len(suffix) > maxIfNameLen {
+		ifName = ifName[:maxIfNameLen-len(suffix)]
+	}
+	return ifName + suffix
+}
+
+// SetupBondedSriovInterface configures smartVFs of deviceIDs and enslaves them
+// to an active-backup bond named ifName in the container namespace, so the pod
+// survives failure of one of the VFs or its PF. It returns representors of the
+// VFs as host interfaces, in order of deviceIDs, and the bond as container
+// interface. All VFs get the same MAC address, VFs of untrusted pods can't
+// take over the one of the bond.
+func SetupBondedSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mtu int, deviceIDs []string, representor *types.Representor, tuning *types.VFTuning) ([]*current.Interface, *current.Interface, error) {
+	var hostIfaces, vfIfaces []*current.Interface
+	for i, deviceID := range deviceIDs {
+		hostIface, vfIface, err := SetupSriovInterface(contNetns, containerID, BondedVFName(ifName, i), mac, mtu, deviceID, representor, tuning, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to set up VF %s: %v", deviceID, err)
+		}
+		mac = vfIface.Mac
+		hostIfaces = append(hostIfaces, hostIface)
+		vfIfaces = append(vfIfaces, vfIface)
+	}
+	hwaddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse MAC address %q: %v", mac, err)
+	}
+
+	contIface := &current.Interface{Name: ifName, Mac: mac, Sandbox: contNetns.Path(), PciID: deviceIDs[0]}
+	err = netns.Do(contNetns, func(_ ns.NetNS) error {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = ifName
+		attrs.MTU = mtu
+		attrs.HardwareAddr = hwaddr
+		bond := netlink.NewLinkBond(attrs)
+		bond.Mode = netlink.BOND_MODE_ACTIVE_BACKUP
+		bond.Miimon = bondedVFMiimon
+		if err := netlink.LinkAdd(bond); err != nil {
+			return fmt.Errorf("failed to create bond %s: %v", ifName, err)
+		}
+		bondLink, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup bond %s: %v", ifName, err)
+		}
+		for _, vfIface := range vfIfaces {
+			link, err := netlink.LinkByName(vfIface.Name)
+			if err != nil {
+				return fmt.Errorf("failed to lookup %q: %v", vfIface.Name, err)
+			}
+			// an interface must be down to be enslaved
+			if err := netlink.LinkSetDown(link); err != nil {
+				return fmt.Errorf("failed to set %q down: %v", vfIface.Name, err)
+			}
+			if err := netlink.LinkSetMasterByIndex(link, bondLink.Attrs().Index); err != nil {
+				return fmt.Errorf("failed to enslave %q to bond %s: %v", vfIface.Name, ifName, err)
+			}
+			if err := netlink.LinkSetUp(link); err != nil {
+				return fmt.Errorf("failed to set %q up: %v", vfIface.Name, err)
+			}
+		}
+		if err := netlink.LinkSetUp(bondLink); err != nil {
+			return fmt.Errorf("failed to set bond %s up: %v", ifName, err)
+		}
+		contIface.Mtu = bondLink.Attrs().MTU
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return hostIfaces, contIface, nil
+}
+
+// ReleaseBondedVFs removes the bond of a bonded attachment and releases its
+// VFs into host namespace, origIfNames are original names of the VFs in order
+// of their deviceIDs. All VFs are tried, the first failure is returned.
+func ReleaseBondedVFs(args *skel.CmdArgs, origIfNames []string) error {
+	err := netns.WithPath(args.Netns, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(args.IfName)
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok {
+				return nil
+			}
+			return err
+		}
+		return netlink.LinkDel(link)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove bond %s: %v", args.IfName, err)
+	}
+	var firstErr error
+	for i, origIfName := range origIfNames {
+		vfArgs := *args
+		vfArgs.IfName = BondedVFName(args.IfName, i)
+		if err := ReleaseVF(&vfArgs, origIfName); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to release VF %s: %v", origIfName, err)
+		}
+	}
+	return firstErr
+}
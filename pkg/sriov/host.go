@@ -0,0 +1,171 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sriov
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/sriovnet"
+	"github.com/vishvananda/netlink"
+)
+
+// Host looks up SR-IOV devices of the node and their network devices. All
+// lookups of the package go through it, so it can be replaced to support NIC
+// families laid out differently in sysfs, or by a fake in unit tests.
+type Host interface {
+	// UplinkRepresentor returns the uplink (PF) netdevice of the VF
+	UplinkRepresentor(vfPci string) (string, error)
+	// VFIndex returns index of the VF on its PF
+	VFIndex(vfPci string) (int, error)
+	// PFPci returns PCI address of the PF of the VF
+	PFPci(vfPci string) (string, error)
+	// VFRepresentor returns the representor of the VF of the given index
+	// on the switch of the uplink
+	VFRepresentor(uplink string, vfIndex int) (string, error)
+	// NetDevices returns netdevices of the PCI device
+	NetDevices(pciAddr string) ([]string, error)
+	// Driver returns name of the driver bound to the PCI device
+	Driver(pciAddr string) (string, error)
+	// NetDevAttr returns the sysfs attribute of the netdevice
+	NetDevAttr(name, attr string) (string, error)
+	// NetDevs returns names of all netdevices of the node
+	NetDevs() ([]string, error)
+	// FindNetDev returns an error when the netdevice doesn't exist
+	FindNetDev(name string) error
+	// BondMaster returns the bond the netdevice is enslaved to, empty when
+	// it isn't a bond member
+	BondMaster(name string) (string, error)
+	// BondMembers returns netdevices enslaved to the bond
+	BondMembers(bond string) ([]string, error)
+}
+
+// Devices is the Host SR-IOV devices are looked up on
+var Devices Host = SysfsHost{}
+
+// SysfsHost looks devices up in sysfs and netlink of the node, devlink
+// naming of representors is resolved through sriovnet
+type SysfsHost struct{}
+
+// UplinkRepresentor implements Host
+func (SysfsHost) UplinkRepresentor(vfPci string) (string, error) {
+	return sriovnet.GetUplinkRepresentor(vfPci)
+}
+
+// VFIndex implements Host
+func (SysfsHost) VFIndex(vfPci string) (int, error) {
+	return sriovnet.GetVfIndexByPciAddress(vfPci)
+}
+
+// PFPci implements Host
+func (SysfsHost) PFPci(vfPci string) (string, error) {
+	return sriovnet.GetPfPciFromVfPci(vfPci)
+}
+
+// VFRepresentor implements Host
+func (SysfsHost) VFRepresentor(uplink string, vfIndex int) (string, error) {
+	return sriovnet.GetVfRepresentor(uplink, vfIndex)
+}
+
+// NetDevices implements Host
+func (SysfsHost) NetDevices(pciAddr string) ([]string, error) {
+	netDir := filepath.Join(SysBusPci, pciAddr, "net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read net dir of the device %s: %v", pciAddr, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// Driver implements Host
+func (SysfsHost) Driver(pciAddr string) (string, error) {
+	driverPath, err := filepath.EvalSymlinks(filepath.Join(SysBusPci, pciAddr, "driver"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(driverPath), nil
+}
+
+// NetDevAttr implements Host
+func (SysfsHost) NetDevAttr(name, attr string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(NetSysDir, name, attr))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// NetDevs implements Host
+func (SysfsHost) NetDevs() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(links))
+	for _, link := range links {
+		names = append(names, link.Attrs().Name)
+	}
+	return names, nil
+}
+
+// FindNetDev implements Host
+func (SysfsHost) FindNetDev(name string) error {
+	_, err := netlink.LinkByName(name)
+	return err
+}
+
+// BondMaster implements Host
+func (SysfsHost) BondMaster(name string) (string, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return "", err
+	}
+	if link.Attrs().MasterIndex == 0 {
+		return "", nil
+	}
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		return "", err
+	}
+	if master.Type() != "bond" {
+		return "", nil
+	}
+	return master.Attrs().Name, nil
+}
+
+// BondMembers implements Host
+func (SysfsHost) BondMembers(bond string) ([]string, error) {
+	bondLink, err := netlink.LinkByName(bond)
+	if err != nil {
+		return nil, err
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for _, link := range links {
+		if link.Attrs().MasterIndex == bondLink.Attrs().Index {
+			members = append(members, link.Attrs().Name)
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sriov
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SysfsHost", func() {
+	var host SysfsHost
+
+	BeforeEach(func() {
+		root := GinkgoT().TempDir()
+		sysBusPci, netSysDir := SysBusPci, NetSysDir
+		SysBusPci, NetSysDir = filepath.Join(root, "bus/pci/devices"), filepath.Join(root, "class/net")
+		DeferCleanup(func() { SysBusPci, NetSysDir = sysBusPci, netSysDir })
+
+		drivers := filepath.Join(root, "bus/pci/drivers")
+		vf := filepath.Join(SysBusPci, "0000:03:00.2")
+		Expect(os.MkdirAll(filepath.Join(vf, "net", "eth2"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(drivers, "vfio-pci"), 0755)).To(Succeed())
+		Expect(os.Symlink(filepath.Join(drivers, "vfio-pci"), filepath.Join(vf, "driver"))).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(NetSysDir, "pf0vf0"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(NetSysDir, "pf0vf0", "phys_port_name"), []byte("pf0vf0\n"), 0644)).To(Succeed())
+	})
+
+	It("should read netdevices of the PCI device", func() {
+		Expect(host.NetDevices("0000:03:00.2")).To(Equal([]string{"eth2"}))
+		_, err := host.NetDevices("0000:03:00.3")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read the driver of the PCI device", func() {
+		Expect(host.Driver("0000:03:00.2")).To(Equal("vfio-pci"))
+	})
+
+	It("should read attributes of the netdevice", func() {
+		Expect(host.NetDevAttr("pf0vf0", "phys_port_name")).To(Equal("pf0vf0"))
+		_, err := host.NetDevAttr("pf0vf0", "phys_switch_id")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sriov
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NetSysDir is sysfs directory of network devices
+var NetSysDir = "/sys/class/net"
+
+// expandRepresentorTemplate replaces placeholders of a representor lookup
+// template
+func expandRepresentorTemplate(template, uplink string, vfIndex int) string {
+	return strings.NewReplacer("{uplink}", uplink, "{vf}", strconv.Itoa(vfIndex)).Replace(template)
+}
+
+// DefaultDPURepresentorName is the name of VF representors on a DPU, as
+// named by BlueField
+const DefaultDPURepresentorName = "pf{pf}vf{vf}"
+
+// expandDPURepresentorTemplate replaces placeholders of a DPU representor name
+// template
+func expandDPURepresentorTemplate(template string, pfIndex, vfIndex int) string {
+	return strings.NewReplacer("{pf}", strconv.Itoa(pfIndex), "{vf}", strconv.Itoa(vfIndex)).Replace(template)
+}
+
+// pciFunction returns the function number of the PCI address, e.g. 1 of
+// 0000:03:00.1
+func pciFunction(pciAddr string) (int, error) {
+	i := strings.LastIndex(pciAddr, ".")
+	if i < 0 {
+		return 0, fmt.Errorf("invalid PCI address %s", pciAddr)
+	}
+	function, err := strconv.Atoi(pciAddr[i+1:])
+	if err != nil {
+		return 0, fmt.Errorf("invalid PCI address %s: %v", pciAddr, err)
+	}
+	return function, nil
+}
+
+// findRepresentorByName verifies that the representor of the given name exists
+func findRepresentorByName(name string) (string, error) {
+	if err := Devices.FindNetDev(name); err != nil {
+		return "", fmt.Errorf("failed to find VF representor %s: %v", name, err)
+	}
+	return name, nil
+}
+
+// findRepresentorByPhysPortName returns the network device on the switch of
+// the uplink with the given phys_port_name, whatever its name is
+func findRepresentorByPhysPortName(uplink, physPortName string) (string, error) {
+	switchID, err := Devices.NetDevAttr(uplink, "phys_switch_id")
+	if err != nil || switchID == "" {
+		return "", fmt.Errorf("cant get uplink %s switch id", uplink)
+	}
+	names, err := Devices.NetDevs()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if id, err := Devices.NetDevAttr(name, "phys_switch_id"); err != nil || id != switchID {
+			continue
+		}
+		if portName, err := Devices.NetDevAttr(name, "phys_port_name"); err == nil && portName == physPortName {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find VF representor with phys_port_name %s for uplink %s", physPortName, uplink)
+}
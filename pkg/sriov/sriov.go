@@ -15,43 +15,42 @@ package sriov
 import (
 	"fmt"
 	"net"
-	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
-	"github.com/k8snetworkplumbingwg/sriovnet"
 	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ethtool"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/faults"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/netns"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
 var (
 	// SysBusPci is sysfs pci device directory
-	SysBusPci        = "/sys/bus/pci/devices"
-	UserspaceDrivers = []string{"vfio-pci", "uio_pci_generic", "igb_uio"}
+	SysBusPci = "/sys/bus/pci/devices"
+	// UserspaceDrivers are drivers exposing the device to userspace, e.g. to
+	// DPDK, instead of creating a netdev. vfio-pci is bound in noiommu mode
+	// under the same name.
+	UserspaceDrivers = []string{"vfio-pci", "vfio_pci", "uio_pci_generic", "igb_uio"}
 )
 
+// vfioPciVariantSuffix ends names of vendor variants of vfio-pci, e.g.
+// mlx5_vfio_pci or hisi_acc_vfio_pci
+const vfioPciVariantSuffix = "_vfio_pci"
+
 // GetVFLinkName retrives interface name for given pci address
 func GetVFLinkName(pciAddr string) (string, error) {
-	var names []string
-	vfDir := filepath.Join(SysBusPci, pciAddr, "net")
-	if _, err := os.Lstat(vfDir); err != nil {
-		return "", err
-	}
-
-	fInfos, err := os.ReadDir(vfDir)
+	names, err := Devices.NetDevices(pciAddr)
 	if err != nil {
-		return "", fmt.Errorf("failed to read net dir of the device %s: %v", pciAddr, err)
-	}
-
-	if len(fInfos) == 0 {
-		return "", fmt.Errorf("VF device %s sysfs path (%s) has no entries", pciAddr, vfDir)
+		return "", err
 	}
 
-	names = make([]string, 0)
-	for _, f := range fInfos {
-		names = append(names, f.Name())
+	if len(names) == 0 {
+		return "", fmt.Errorf("VF device %s has no netdevices", pciAddr)
 	}
 
 	return names[0], nil
@@ -64,24 +63,25 @@ func IsOvsHardwareOffloadEnabled(deviceID string) bool {
 }
 
 // HasUserspaceDriver checks if a device is attached to userspace driver
-// This method is copied from https://github.com/k8snetworkplumbingwg/sriov-cni/blob/8af83a33b2cac8e2df0bd6276b76658eb7c790ab/pkg/utils/utils.go#L222
+// This method is based on https://github.com/k8snetworkplumbingwg/sriov-cni/blob/8af83a33b2cac8e2df0bd6276b76658eb7c790ab/pkg/utils/utils.go#L222
 func HasUserspaceDriver(pciAddr string) (bool, error) {
-	driverLink := filepath.Join(SysBusPci, pciAddr, "driver")
-	driverPath, err := filepath.EvalSymlinks(driverLink)
-	if err != nil {
-		return false, err
-	}
-	driverStat, err := os.Stat(driverPath)
+	driverName, err := Devices.Driver(pciAddr)
 	if err != nil {
 		return false, err
 	}
-	driverName := driverStat.Name()
+	return IsUserspaceDriver(driverName), nil
+}
+
+// IsUserspaceDriver returns true when the driver of the given name exposes
+// the device to userspace, one of UserspaceDrivers or a vendor variant of
+// vfio-pci
+func IsUserspaceDriver(driverName string) bool {
 	for _, drv := range UserspaceDrivers {
 		if driverName == drv {
-			return true, nil
+			return true
 		}
 	}
-	return false, nil
+	return strings.HasSuffix(driverName, vfioPciVariantSuffix)
 }
 
 // GetBridgeUplinkNameByDeviceID tries to automatically resolve uplink interface name
@@ -89,21 +89,20 @@ func HasUserspaceDriver(pciAddr string) (bool, error) {
 // VF pci address > PF pci address > Bond (optional, if PF is part of a bond)
 // return list of candidate names
 func GetBridgeUplinkNameByDeviceID(deviceID string) ([]string, error) {
-	pfName, err := sriovnet.GetUplinkRepresentor(deviceID)
+	pfName, err := Devices.UplinkRepresentor(deviceID)
 	if err != nil {
 		return nil, err
 	}
-	pfLink, err := netlink.LinkByName(pfName)
-	if err != nil {
+	if err := Devices.FindNetDev(pfName); err != nil {
 		return nil, fmt.Errorf("failed to get link info for uplink %s: %v", pfName, err)
 	}
-	bond, err := getBondInterface(pfLink)
+	bond, err := Devices.BondMaster(pfName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get parent link for uplink %s: %v", pfName, err)
 	}
-	if bond == nil {
+	if bond == "" {
 		// PF has no parent bond, return only PF name
-		return []string{pfLink.Attrs().Name}, nil
+		return []string{pfName}, nil
 	}
 	// for some OVS datapathes, to use bond configuration it is required to attach primary PF (usually first one) to the ovs instead of the bond interface.
 	// Example:
@@ -113,63 +112,62 @@ func GetBridgeUplinkNameByDeviceID(deviceID string) ([]string, error) {
 	//
 	// to support autobridge selection for VFs from the PF1 (which is part of the bond, but not directly attached to the ovs),
 	// we need to add other interfaces that are part of the bond as candidates, for PF1 candidates list will be: [bond0, PF0, PF1]
-	bondMembers, err := getBondMembers(bond)
+	bondMembers, err := Devices.BondMembers(bond)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve list of bond members for bond %s, uplink %s: %v", bond.Attrs().Name, pfName, err)
+		return nil, fmt.Errorf("failed to retrieve list of bond members for bond %s, uplink %s: %v", bond, pfName, err)
 	}
-	return bondMembers, nil
+	return append([]string{bond}, bondMembers...), nil
 }
 
-// getBondInterface returns a parent bond interface for the link if it exists
-func getBondInterface(link netlink.Link) (netlink.Link, error) {
-	if link.Attrs().MasterIndex == 0 {
-		return nil, nil
+// GetDpdkRepresentorDevargs returns dpdk-devargs of the OVS-DPDK port of the
+// representor of the smart VF, as used by userspace datapath
+func GetDpdkRepresentorDevargs(deviceID string) (string, error) {
+	if err := faults.Inject(faults.SriovRepresentor); err != nil {
+		return "", err
 	}
-	bondLink, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	pfPci, err := Devices.PFPci(deviceID)
 	if err != nil {
-		return nil, err
-	}
-	if bondLink.Type() != "bond" {
-		return nil, nil
+		return "", err
 	}
-	return bondLink, nil
-}
-
-// getBondMembers returns list with name of the bond and all bond members
-func getBondMembers(bond netlink.Link) ([]string, error) {
-	allLinks, err := netlink.LinkList()
+	vfIndex, err := Devices.VFIndex(deviceID)
 	if err != nil {
-		return nil, err
-	}
-	result := []string{bond.Attrs().Name}
-	for _, link := range allLinks {
-		if link.Attrs().MasterIndex == bond.Attrs().Index {
-			result = append(result, link.Attrs().Name)
-		}
+		return "", err
 	}
-	return result, nil
+	return fmt.Sprintf("%s,representor=[%d]", pfPci, vfIndex), nil
 }
 
-// GetNetRepresentor retrieves network representor device for smartvf
-func GetNetRepresentor(deviceID string) (string, error) {
+// GetNetRepresentor retrieves network representor device for smartvf, the
+// representor is looked up as configured by naming, by default by its
+// switch ID and port name
+func GetNetRepresentor(deviceID string, naming *types.Representor) (string, error) {
+	if err := faults.Inject(faults.SriovRepresentor); err != nil {
+		return "", err
+	}
 	// get Uplink netdevice.  The uplink is basically the PF name of the deviceID (smart VF).
 	// The uplink is later used to retrieve the representor for the smart VF.
-	uplink, err := sriovnet.GetUplinkRepresentor(deviceID)
+	uplink, err := Devices.UplinkRepresentor(deviceID)
 	if err != nil {
 		return "", err
 	}
 
 	// get smart VF index from PCI
-	vfIndex, err := sriovnet.GetVfIndexByPciAddress(deviceID)
+	vfIndex, err := Devices.VFIndex(deviceID)
 	if err != nil {
 		return "", err
 	}
 
+	if naming != nil && naming.NameTemplate != "" {
+		return findRepresentorByName(expandRepresentorTemplate(naming.NameTemplate, uplink, vfIndex))
+	}
+	if naming != nil && naming.PhysPortName != "" {
+		return findRepresentorByPhysPortName(uplink, expandRepresentorTemplate(naming.PhysPortName, uplink, vfIndex))
+	}
+
 	// get smart VF representor interface. This is a host net device which represents
 	// smart VF attached inside the container by device plugin. It can be considered
 	// as one end of veth pair whereas other end is smartVF. The VF representor would
 	// get added into ovs bridge for the control plane configuration.
-	rep, err := sriovnet.GetVfRepresentor(uplink, vfIndex)
+	rep, err := Devices.VFRepresentor(uplink, vfIndex)
 	if err != nil {
 		return "", err
 	}
@@ -177,11 +175,112 @@ func GetNetRepresentor(deviceID string) (string, error) {
 	return rep, nil
 }
 
+// GetDPURepresentor returns the name of the representor of the smart VF on the
+// DPU whose OVS switches its traffic. The representor isn't visible on the
+// host, so the name is derived from the PF, by its PCI function, and VF index
+// as configured by naming, pf{pf}vf{vf} by default.
+func GetDPURepresentor(deviceID string, naming *types.Representor) (string, error) {
+	if err := faults.Inject(faults.SriovRepresentor); err != nil {
+		return "", err
+	}
+	pfPci, err := Devices.PFPci(deviceID)
+	if err != nil {
+		return "", err
+	}
+	pfIndex, err := pciFunction(pfPci)
+	if err != nil {
+		return "", err
+	}
+	vfIndex, err := Devices.VFIndex(deviceID)
+	if err != nil {
+		return "", err
+	}
+	template := DefaultDPURepresentorName
+	if naming != nil && naming.DPUName != "" {
+		template = naming.DPUName
+	}
+	return expandDPURepresentorTemplate(template, pfIndex, vfIndex), nil
+}
+
+// tuneVF sets queue counts and interrupt coalescing of the VF netdevice
+func tuneVF(vfNetdevice string, tuning *types.VFTuning) error {
+	if tuning == nil {
+		return nil
+	}
+	if channels := tuning.Channels; channels != nil {
+		if err := ethtool.SetChannels(vfNetdevice, ethtool.Channels{Rx: channels.Rx, Tx: channels.Tx, Combined: channels.Combined}); err != nil {
+			return err
+		}
+	}
+	if coalesce := tuning.Coalesce; coalesce != nil {
+		if err := ethtool.SetCoalesce(vfNetdevice, ethtool.Coalesce{
+			RxUsecs:    coalesce.RxUsecs,
+			RxFrames:   coalesce.RxFrames,
+			TxUsecs:    coalesce.TxUsecs,
+			TxFrames:   coalesce.TxFrames,
+			AdaptiveRx: coalesce.AdaptiveRx,
+			AdaptiveTx: coalesce.AdaptiveTx,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrigVFTuning returns current values of the VF netdevice for the settings
+// tuning changes, so that they can be restored once the VF is released
+func OrigVFTuning(vfNetdevice string, tuning *types.VFTuning) (*types.VFTuning, error) {
+	if tuning == nil {
+		return nil, nil
+	}
+	orig := &types.VFTuning{}
+	if channels := tuning.Channels; channels != nil {
+		current, err := ethtool.GetChannels(vfNetdevice)
+		if err != nil {
+			return nil, err
+		}
+		orig.Channels = &types.VFChannels{
+			Rx:       keepSet(channels.Rx, current.Rx),
+			Tx:       keepSet(channels.Tx, current.Tx),
+			Combined: keepSet(channels.Combined, current.Combined),
+		}
+	}
+	if coalesce := tuning.Coalesce; coalesce != nil {
+		current, err := ethtool.GetCoalesce(vfNetdevice)
+		if err != nil {
+			return nil, err
+		}
+		orig.Coalesce = &types.VFCoalesce{
+			RxUsecs:    keepSet(coalesce.RxUsecs, current.RxUsecs),
+			RxFrames:   keepSet(coalesce.RxFrames, current.RxFrames),
+			TxUsecs:    keepSet(coalesce.TxUsecs, current.TxUsecs),
+			TxFrames:   keepSet(coalesce.TxFrames, current.TxFrames),
+			AdaptiveRx: keepSet(coalesce.AdaptiveRx, current.AdaptiveRx),
+			AdaptiveTx: keepSet(coalesce.AdaptiveTx, current.AdaptiveTx),
+		}
+	}
+	return orig, nil
+}
+
+// keepSet returns current when the setting is set, nil otherwise
+func keepSet[T any](setting, current *T) *T {
+	if setting == nil {
+		return nil
+	}
+	return current
+}
+
+// RestoreVFTuning sets the values returned by OrigVFTuning back on the VF
+// netdevice in the host namespace
+func RestoreVFTuning(vfNetdevice string, orig *types.VFTuning) error {
+	return tuneVF(vfNetdevice, orig)
+}
+
 // setupKernelSriovContIface moves smartVF into container namespace,
 // configures the smartVF and also fills in the contIface fields
-func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface, deviceID string, pfLink netlink.Link, vfIdx int, ifName string, hwaddr net.HardwareAddr, mtu int) error {
+func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface, deviceID string, pfLink netlink.Link, vfIdx int, ifName string, hwaddr net.HardwareAddr, mtu int, tuning *types.VFTuning) error {
 	// get smart VF netdevice from PCI
-	vfNetdevices, err := sriovnet.GetNetDevicesFromPci(deviceID)
+	vfNetdevices, err := Devices.NetDevices(deviceID)
 	if err != nil {
 		return err
 	}
@@ -200,13 +299,18 @@ func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface,
 		}
 	}
 
+	// pods can't tune the VF once it is in the container namespace
+	if err := tuneVF(vfNetdevice, tuning); err != nil {
+		return err
+	}
+
 	// Move smart VF to Container namespace
 	err = moveIfToNetns(vfNetdevice, contNetns)
 	if err != nil {
 		return err
 	}
 
-	err = contNetns.Do(func(hostNS ns.NetNS) error {
+	err = netns.Do(contNetns, func(hostNS ns.NetNS) error {
 		contIface.Name = ifName
 		_, err = renameLink(vfNetdevice, contIface.Name)
 		if err != nil {
@@ -266,12 +370,12 @@ func setupUserspaceSriovContIface(contNetns ns.NetNS, contIface *current.Interfa
 }
 
 // SetupSriovInterface configures smartVF and returns VF's representor device as host interface and VF's netdevice as container interface
-func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mtu int, deviceID string, userspaceMode bool) (*current.Interface, *current.Interface, error) {
+func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mtu int, deviceID string, representor *types.Representor, tuning *types.VFTuning, userspaceMode bool) (*current.Interface, *current.Interface, error) {
 	hostIface := &current.Interface{}
 	contIface := &current.Interface{}
 
 	// network representor device for smartvf
-	rep, err := GetNetRepresentor(deviceID)
+	rep, err := GetNetRepresentor(deviceID, representor)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -285,7 +389,7 @@ func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mt
 	hostIface.Mac = link.Attrs().HardwareAddr.String()
 
 	// get PF netlink and VF index from PCI address
-	pfIface, err := sriovnet.GetUplinkRepresentor(deviceID)
+	pfIface, err := Devices.UplinkRepresentor(deviceID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -293,7 +397,7 @@ func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mt
 	if err != nil {
 		return nil, nil, err
 	}
-	vfIdx, err := sriovnet.GetVfIndexByPciAddress(deviceID)
+	vfIdx, err := Devices.VFIndex(deviceID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -322,7 +426,7 @@ func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mt
 
 	if !userspaceMode {
 		// configure the smart VF netdevice directly in the container namespace
-		if err = setupKernelSriovContIface(contNetns, contIface, deviceID, pfLink, vfIdx, ifName, hwaddr, mtu); err != nil {
+		if err = setupKernelSriovContIface(contNetns, contIface, deviceID, pfLink, vfIdx, ifName, hwaddr, mtu, tuning); err != nil {
 			return nil, nil, err
 		}
 	} else {
@@ -331,18 +435,19 @@ func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mt
 			return nil, nil, err
 		}
 	}
+	contIface.PciID = deviceID
 
 	return hostIface, contIface, nil
 }
 
-func moveIfToNetns(ifname string, netns ns.NetNS) error {
+func moveIfToNetns(ifname string, targetNs ns.NetNS) error {
 	vfDev, err := netlink.LinkByName(ifname)
 	if err != nil {
 		return fmt.Errorf("failed to lookup vf device %v: %q", ifname, err)
 	}
 
 	// move VF device to ns
-	if err = netlink.LinkSetNsFd(vfDev, int(netns.Fd())); err != nil {
+	if err = netlink.LinkSetNsFd(vfDev, int(targetNs.Fd())); err != nil {
 		return fmt.Errorf("failed to move device %+v to netns: %q", ifname, err)
 	}
 
@@ -374,12 +479,12 @@ func ReleaseVF(args *skel.CmdArgs, origIfName string) error {
 	if err != nil {
 		return fmt.Errorf("failed to get host netns: %v", err)
 	}
-	contNetns, err := ns.GetNS(args.Netns)
+	contNetns, err := netns.Get(args.Netns)
 	if err != nil {
 		return fmt.Errorf("failed to open container netns %q: %v", args.Netns, err)
 	}
 
-	return contNetns.Do(func(_ ns.NetNS) error {
+	return netns.Do(contNetns, func(_ ns.NetNS) error {
 		// rename VF device back to its original name
 		linkObj, err := renameLink(args.IfName, origIfName)
 		if err != nil {
@@ -397,7 +502,7 @@ func ReleaseVF(args *skel.CmdArgs, origIfName string) error {
 // ResetVF reset the VF which accidently moved into default network namespace by a container failure
 func ResetVF(args *skel.CmdArgs, deviceID, origIfName string) error {
 	// get smart VF netdevice from PCI
-	vfNetdevices, err := sriovnet.GetNetDevicesFromPci(deviceID)
+	vfNetdevices, err := Devices.NetDevices(deviceID)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,337 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sriov
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ethtool"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// fakeVF is a VF of fakeHost
+type fakeVF struct {
+	pf      string
+	pfPci   string
+	index   int
+	driver  string
+	netdevs []string
+}
+
+// fakeHost is a node with SR-IOV devices described in memory, netdev
+// attributes are keyed by netdev and attribute name
+type fakeHost struct {
+	vfs     map[string]fakeVF
+	attrs   map[string]map[string]string
+	masters map[string]string
+	bonds   map[string]bool
+}
+
+func (h *fakeHost) vf(vfPci string) (fakeVF, error) {
+	vf, found := h.vfs[vfPci]
+	if !found {
+		return fakeVF{}, fmt.Errorf("device %s not found", vfPci)
+	}
+	return vf, nil
+}
+
+func (h *fakeHost) UplinkRepresentor(vfPci string) (string, error) {
+	vf, err := h.vf(vfPci)
+	return vf.pf, err
+}
+
+func (h *fakeHost) VFIndex(vfPci string) (int, error) {
+	vf, err := h.vf(vfPci)
+	return vf.index, err
+}
+
+func (h *fakeHost) PFPci(vfPci string) (string, error) {
+	vf, err := h.vf(vfPci)
+	return vf.pfPci, err
+}
+
+func (h *fakeHost) VFRepresentor(uplink string, vfIndex int) (string, error) {
+	switchID := h.attrs[uplink]["phys_switch_id"]
+	for name, attrs := range h.attrs {
+		if attrs["phys_switch_id"] == switchID && attrs["phys_port_name"] == fmt.Sprintf("pf0vf%d", vfIndex) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find VF representor for uplink %s", uplink)
+}
+
+func (h *fakeHost) NetDevices(pciAddr string) ([]string, error) {
+	vf, err := h.vf(pciAddr)
+	return vf.netdevs, err
+}
+
+func (h *fakeHost) Driver(pciAddr string) (string, error) {
+	vf, err := h.vf(pciAddr)
+	return vf.driver, err
+}
+
+func (h *fakeHost) NetDevAttr(name, attr string) (string, error) {
+	value, found := h.attrs[name][attr]
+	if !found {
+		return "", fmt.Errorf("%s of %s not found", attr, name)
+	}
+	return value, nil
+}
+
+func (h *fakeHost) NetDevs() ([]string, error) {
+	var names []string
+	for name := range h.attrs {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (h *fakeHost) FindNetDev(name string) error {
+	if _, found := h.attrs[name]; !found {
+		return fmt.Errorf("link %s not found", name)
+	}
+	return nil
+}
+
+func (h *fakeHost) BondMaster(name string) (string, error) {
+	if master := h.masters[name]; h.bonds[master] {
+		return master, nil
+	}
+	return "", nil
+}
+
+func (h *fakeHost) BondMembers(bond string) ([]string, error) {
+	var members []string
+	for name, master := range h.masters {
+		if master == bond {
+			members = append(members, name)
+		}
+	}
+	return members, nil
+}
+
+var _ = Describe("Sriov", func() {
+	var host *fakeHost
+
+	BeforeEach(func() {
+		host = &fakeHost{
+			vfs: map[string]fakeVF{
+				"0000:03:00.2": {pf: "p0", pfPci: "0000:03:00.0", index: 0, driver: "mlx5_core", netdevs: []string{"eth2"}},
+				"0000:03:00.3": {pf: "p0", pfPci: "0000:03:00.0", index: 1, driver: "vfio-pci"},
+				"0000:03:08.2": {pf: "p1", pfPci: "0000:03:00.1", index: 0, driver: "mlx5_core", netdevs: []string{"eth3"}},
+			},
+			attrs: map[string]map[string]string{
+				"p0":      {"phys_switch_id": "aa", "phys_port_name": "p0"},
+				"p1":      {"phys_switch_id": "bb", "phys_port_name": "p1"},
+				"pf0vf0":  {"phys_switch_id": "aa", "phys_port_name": "pf0vf0"},
+				"pf0vf1":  {"phys_switch_id": "aa", "phys_port_name": "pf0vf1"},
+				"p0_1":    {"phys_switch_id": "aa", "phys_port_name": "c1pf0vf1"},
+				"bond0":   {},
+				"eth2":    {},
+				"br-pf1":  {},
+				"pf1vf0":  {"phys_switch_id": "bb", "phys_port_name": "pf0vf0"},
+				"p0_tmpl": {},
+			},
+			masters: map[string]string{},
+			bonds:   map[string]bool{"bond0": true},
+		}
+		devices := Devices
+		Devices = host
+		DeferCleanup(func() { Devices = devices })
+	})
+
+	Context("uplink detection", func() {
+		It("should return the PF when it is not in a bond", func() {
+			Expect(GetBridgeUplinkNameByDeviceID("0000:03:00.2")).To(Equal([]string{"p0"}))
+		})
+		It("should return the bond and its members when the PF is in a bond", func() {
+			host.masters = map[string]string{"p0": "bond0", "p1": "bond0"}
+			uplinks, err := GetBridgeUplinkNameByDeviceID("0000:03:08.2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(uplinks[0]).To(Equal("bond0"))
+			Expect(uplinks[1:]).To(ConsistOf("p0", "p1"))
+		})
+		It("should ignore a master which is not a bond", func() {
+			host.masters = map[string]string{"p1": "br-pf1"}
+			Expect(GetBridgeUplinkNameByDeviceID("0000:03:08.2")).To(Equal([]string{"p1"}))
+		})
+		It("should fail for an unknown device", func() {
+			_, err := GetBridgeUplinkNameByDeviceID("0000:04:00.2")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("userspace driver detection", func() {
+		It("should detect a VF bound to a userspace driver", func() {
+			Expect(HasUserspaceDriver("0000:03:00.3")).To(BeTrue())
+		})
+		It("should not detect a VF bound to a kernel driver", func() {
+			Expect(HasUserspaceDriver("0000:03:00.2")).To(BeFalse())
+		})
+		It("should fail for an unknown device", func() {
+			_, err := HasUserspaceDriver("0000:04:00.2")
+			Expect(err).To(HaveOccurred())
+		})
+		DescribeTable("should classify drivers",
+			func(driver string, userspace bool) {
+				Expect(IsUserspaceDriver(driver)).To(Equal(userspace))
+			},
+			Entry("vfio-pci", "vfio-pci", true),
+			Entry("vfio-pci by module name", "vfio_pci", true),
+			Entry("uio_pci_generic", "uio_pci_generic", true),
+			Entry("igb_uio", "igb_uio", true),
+			Entry("vendor variant of vfio-pci", "mlx5_vfio_pci", true),
+			Entry("another vendor variant of vfio-pci", "hisi_acc_vfio_pci", true),
+			Entry("kernel driver", "mlx5_core", false),
+			Entry("kernel VF driver", "iavf", false),
+			Entry("no driver", "", false),
+		)
+	})
+
+	Context("VF netdevice", func() {
+		It("should return the netdevice of the VF", func() {
+			Expect(GetVFLinkName("0000:03:00.2")).To(Equal("eth2"))
+		})
+		It("should fail when the VF has no netdevice", func() {
+			_, err := GetVFLinkName("0000:03:00.3")
+			Expect(err).To(MatchError(ContainSubstring("has no netdevices")))
+		})
+	})
+
+	Context("representor resolution", func() {
+		It("should resolve the representor by switch ID and port name by default", func() {
+			Expect(GetNetRepresentor("0000:03:00.3", nil)).To(Equal("pf0vf1"))
+			Expect(GetNetRepresentor("0000:03:08.2", nil)).To(Equal("pf1vf0"))
+		})
+		It("should resolve the representor by name template", func() {
+			naming := &types.Representor{NameTemplate: "{uplink}_tmpl"}
+			Expect(GetNetRepresentor("0000:03:00.2", naming)).To(Equal("p0_tmpl"))
+		})
+		It("should fail when the templated representor doesn't exist", func() {
+			naming := &types.Representor{NameTemplate: "{uplink}_{vf}_missing"}
+			_, err := GetNetRepresentor("0000:03:00.2", naming)
+			Expect(err).To(MatchError(ContainSubstring("failed to find VF representor p0_0_missing")))
+		})
+		It("should resolve the representor by phys_port_name template", func() {
+			naming := &types.Representor{PhysPortName: "c1pf0vf{vf}"}
+			Expect(GetNetRepresentor("0000:03:00.3", naming)).To(Equal("p0_1"))
+		})
+		It("should not resolve a representor on a switch of another uplink", func() {
+			naming := &types.Representor{PhysPortName: "pf0vf{vf}"}
+			Expect(GetNetRepresentor("0000:03:08.2", naming)).To(Equal("pf1vf0"))
+			host.attrs["pf1vf0"]["phys_switch_id"] = "cc"
+			_, err := GetNetRepresentor("0000:03:08.2", naming)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should name the representor on the DPU after the PF and VF by default", func() {
+			Expect(GetDPURepresentor("0000:03:00.3", nil)).To(Equal("pf0vf1"))
+			Expect(GetDPURepresentor("0000:03:08.2", nil)).To(Equal("pf1vf0"))
+		})
+		It("should name the representor on the DPU by its template", func() {
+			naming := &types.Representor{NameTemplate: "{uplink}_tmpl", DPUName: "c1pf{pf}vf{vf}"}
+			Expect(GetDPURepresentor("0000:03:08.2", naming)).To(Equal("c1pf1vf0"))
+		})
+		It("should fail to name the representor on the DPU of an unknown VF", func() {
+			_, err := GetDPURepresentor("0000:04:00.2", nil)
+			Expect(err).To(MatchError(ContainSubstring("device 0000:04:00.2 not found")))
+		})
+		It("should return dpdk-devargs of the representor", func() {
+			Expect(GetDpdkRepresentorDevargs("0000:03:00.3")).To(Equal("0000:03:00.0,representor=[1]"))
+		})
+	})
+
+	Context("bonded VFs", func() {
+		It("should name VFs after the bond", func() {
+			Expect(BondedVFName("net1", 0)).To(Equal("net1v0"))
+			Expect(BondedVFName("net1", 1)).To(Equal("net1v1"))
+		})
+		It("should keep names of VFs within the limit of the kernel", func() {
+			Expect(BondedVFName("averylongifname", 1)).To(Equal("averylongifnav1"))
+		})
+	})
+})
+
+var _ = Describe("VF tuning", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		// veth stands in for the VF netdevice, it supports channels but not
+		// coalescing
+		Expect(testNS.Do(func(ns.NetNS) error {
+			attrs := netlink.LinkAttrs{Name: "vf0", NumRxQueues: 4, NumTxQueues: 4}
+			return netlink.LinkAdd(&netlink.Veth{LinkAttrs: attrs, PeerName: "peer0"})
+		})).To(Succeed())
+	})
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("should return nothing without tuning", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			orig, err := OrigVFTuning("vf0", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orig).To(BeNil())
+			return nil
+		})).To(Succeed())
+	})
+	It("should restore only the channels which were tuned", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			before, err := ethtool.GetChannels("vf0")
+			Expect(err).NotTo(HaveOccurred())
+			one := uint32(1)
+			Expect(*before.Rx).To(BeNumerically(">", one))
+			tuning := &types.VFTuning{Channels: &types.VFChannels{Rx: &one}}
+
+			orig, err := OrigVFTuning("vf0", tuning)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orig.Coalesce).To(BeNil())
+			Expect(orig.Channels.Rx).To(HaveValue(Equal(*before.Rx)))
+			Expect(orig.Channels.Tx).To(BeNil())
+			Expect(orig.Channels.Combined).To(BeNil())
+
+			Expect(tuneVF("vf0", tuning)).To(Succeed())
+			tuned, err := ethtool.GetChannels("vf0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*tuned.Rx).To(Equal(one))
+
+			Expect(RestoreVFTuning("vf0", orig)).To(Succeed())
+			restored, err := ethtool.GetChannels("vf0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored).To(Equal(before))
+			return nil
+		})).To(Succeed())
+	})
+	It("should fail when the original coalescing can't be read", func() {
+		Expect(testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+			usecs := uint32(50)
+			_, err := OrigVFTuning("vf0", &types.VFTuning{Coalesce: &types.VFCoalesce{RxUsecs: &usecs}})
+			Expect(err).To(MatchError(ContainSubstring("failed to get coalescing of vf0")))
+			return nil
+		})).To(Succeed())
+	})
+})
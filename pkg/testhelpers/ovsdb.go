@@ -0,0 +1,399 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testhelpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/stdr"
+	"github.com/ovn-org/libovsdb/database"
+	"github.com/ovn-org/libovsdb/database/inmemory"
+	"github.com/ovn-org/libovsdb/model"
+	"github.com/ovn-org/libovsdb/ovsdb"
+	"github.com/ovn-org/libovsdb/server"
+)
+
+// fakeOvsSchema is the subset of the Open_vSwitch schema used by ovs-cni,
+// columns have the types of the schema of Open vSwitch. qos of Port doesn't
+// refer to QoS, the in-memory database keeps the reference to the previous
+// QoS when the value of an optional reference column is replaced.
+const fakeOvsSchema = `{
+  "name": "Open_vSwitch",
+  "version": "8.5.0",
+  "tables": {
+    "Open_vSwitch": {
+      "columns": {
+        "bridges": {"type": {"key": {"type": "uuid", "refTable": "Bridge"}, "min": 0, "max": "unlimited"}},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+        "other_config": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      },
+      "isRoot": true,
+      "maxRows": 1
+    },
+    "Bridge": {
+      "columns": {
+        "name": {"type": "string", "mutable": false},
+        "datapath_type": {"type": "string"},
+        "fail_mode": {"type": {"key": {"type": "string", "enum": ["set", ["standalone", "secure"]]}, "min": 0, "max": 1}},
+        "ports": {"type": {"key": {"type": "uuid", "refTable": "Port"}, "min": 0, "max": "unlimited"}},
+        "mirrors": {"type": {"key": {"type": "uuid", "refTable": "Mirror"}, "min": 0, "max": "unlimited"}},
+        "controller": {"type": {"key": {"type": "uuid", "refTable": "Controller"}, "min": 0, "max": "unlimited"}},
+        "protocols": {"type": {"key": "string", "min": 0, "max": "unlimited"}},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+        "other_config": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      },
+      "indexes": [["name"]]
+    },
+    "Port": {
+      "columns": {
+        "name": {"type": "string", "mutable": false},
+        "interfaces": {"type": {"key": {"type": "uuid", "refTable": "Interface"}, "min": 1, "max": "unlimited"}},
+        "tag": {"type": {"key": {"type": "integer", "minInteger": 0, "maxInteger": 4095}, "min": 0, "max": 1}},
+        "trunks": {"type": {"key": {"type": "integer", "minInteger": 0, "maxInteger": 4095}, "min": 0, "max": 4096}},
+        "vlan_mode": {"type": {"key": {"type": "string", "enum": ["set", ["trunk", "access", "native-tagged", "native-untagged", "dot1q-tunnel"]]}, "min": 0, "max": 1}},
+        "qos": {"type": {"key": {"type": "uuid"}, "min": 0, "max": 1}},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+        "other_config": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      },
+      "indexes": [["name"]]
+    },
+    "Interface": {
+      "columns": {
+        "name": {"type": "string", "mutable": false},
+        "type": {"type": "string"},
+        "options": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+        "ofport": {"type": {"key": "integer", "min": 0, "max": 1}},
+        "ofport_request": {"type": {"key": {"type": "integer", "minInteger": 1, "maxInteger": 65279}, "min": 0, "max": 1}},
+        "link_state": {"type": {"key": {"type": "string", "enum": ["set", ["up", "down"]]}, "min": 0, "max": 1}},
+        "mtu": {"type": {"key": "integer", "min": 0, "max": 1}},
+        "mtu_request": {"type": {"key": {"type": "integer", "minInteger": 1}, "min": 0, "max": 1}},
+        "error": {"type": {"key": "string", "min": 0, "max": 1}},
+        "statistics": {"type": {"key": "string", "value": "integer", "min": 0, "max": "unlimited"}},
+        "ingress_policing_rate": {"type": {"key": {"type": "integer", "minInteger": 0}}},
+        "ingress_policing_burst": {"type": {"key": {"type": "integer", "minInteger": 0}}},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+        "other_config": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      },
+      "indexes": [["name"]]
+    },
+    "Mirror": {
+      "columns": {
+        "name": {"type": "string"},
+        "select_src_port": {"type": {"key": {"type": "uuid", "refTable": "Port", "refType": "weak"}, "min": 0, "max": "unlimited"}},
+        "select_dst_port": {"type": {"key": {"type": "uuid", "refTable": "Port", "refType": "weak"}, "min": 0, "max": "unlimited"}},
+        "output_port": {"type": {"key": {"type": "uuid", "refTable": "Port", "refType": "weak"}, "min": 0, "max": 1}},
+        "output_vlan": {"type": {"key": {"type": "integer", "minInteger": 1, "maxInteger": 4095}, "min": 0, "max": 1}},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      }
+    },
+    "QoS": {
+      "columns": {
+        "type": {"type": "string"},
+        "queues": {"type": {"key": {"type": "integer", "minInteger": 0, "maxInteger": 4294967295}, "value": {"type": "uuid", "refTable": "Queue"}, "min": 0, "max": "unlimited"}},
+        "other_config": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      },
+      "isRoot": true
+    },
+    "Queue": {
+      "columns": {
+        "other_config": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      },
+      "isRoot": true
+    },
+    "Controller": {
+      "columns": {
+        "target": {"type": "string"},
+        "is_connected": {"type": "boolean"},
+        "external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+      }
+    }
+  }
+}`
+
+type fakeOpenvSwitch struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Bridges     []string          `ovsdb:"bridges"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+}
+
+type fakeBridge struct {
+	UUID         string            `ovsdb:"_uuid"`
+	Name         string            `ovsdb:"name"`
+	DatapathType string            `ovsdb:"datapath_type"`
+	FailMode     *string           `ovsdb:"fail_mode"`
+	Ports        []string          `ovsdb:"ports"`
+	Mirrors      []string          `ovsdb:"mirrors"`
+	Controller   []string          `ovsdb:"controller"`
+	Protocols    []string          `ovsdb:"protocols"`
+	ExternalIDs  map[string]string `ovsdb:"external_ids"`
+	OtherConfig  map[string]string `ovsdb:"other_config"`
+}
+
+type fakePort struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Name        string            `ovsdb:"name"`
+	Interfaces  []string          `ovsdb:"interfaces"`
+	Tag         *int              `ovsdb:"tag"`
+	Trunks      []int             `ovsdb:"trunks"`
+	VlanMode    *string           `ovsdb:"vlan_mode"`
+	QoS         *string           `ovsdb:"qos"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+}
+
+type fakeInterface struct {
+	UUID                 string            `ovsdb:"_uuid"`
+	Name                 string            `ovsdb:"name"`
+	Type                 string            `ovsdb:"type"`
+	Options              map[string]string `ovsdb:"options"`
+	Ofport               *int              `ovsdb:"ofport"`
+	OfportRequest        *int              `ovsdb:"ofport_request"`
+	LinkState            *string           `ovsdb:"link_state"`
+	MTU                  *int              `ovsdb:"mtu"`
+	MTURequest           *int              `ovsdb:"mtu_request"`
+	Error                *string           `ovsdb:"error"`
+	Statistics           map[string]int    `ovsdb:"statistics"`
+	IngressPolicingRate  int               `ovsdb:"ingress_policing_rate"`
+	IngressPolicingBurst int               `ovsdb:"ingress_policing_burst"`
+	ExternalIDs          map[string]string `ovsdb:"external_ids"`
+	OtherConfig          map[string]string `ovsdb:"other_config"`
+}
+
+type fakeMirror struct {
+	UUID          string            `ovsdb:"_uuid"`
+	Name          string            `ovsdb:"name"`
+	SelectSrcPort []string          `ovsdb:"select_src_port"`
+	SelectDstPort []string          `ovsdb:"select_dst_port"`
+	OutputPort    *string           `ovsdb:"output_port"`
+	OutputVlan    *int              `ovsdb:"output_vlan"`
+	ExternalIDs   map[string]string `ovsdb:"external_ids"`
+}
+
+type fakeQoS struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Type        string            `ovsdb:"type"`
+	Queues      map[int]string    `ovsdb:"queues"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+type fakeQueue struct {
+	UUID        string            `ovsdb:"_uuid"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+type fakeController struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Target      string            `ovsdb:"target"`
+	IsConnected bool              `ovsdb:"is_connected"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// fakeDatabase makes results of select operations of the in-memory database
+// look like the ones of ovsdb-server, which returns the requested columns
+// only, with empty sets and maps as well
+type fakeDatabase struct {
+	database.Database
+	schema ovsdb.DatabaseSchema
+}
+
+func (d *fakeDatabase) NewTransaction(name string) database.Transaction {
+	return &fakeTransaction{Transaction: d.Database.NewTransaction(name), schema: d.schema}
+}
+
+type fakeTransaction struct {
+	database.Transaction
+	schema ovsdb.DatabaseSchema
+}
+
+func (t *fakeTransaction) Transact(operations ...ovsdb.Operation) ([]*ovsdb.OperationResult, database.Update) {
+	results, update := t.Transaction.Transact(operations...)
+	for i, operation := range operations {
+		if operation.Op != ovsdb.OperationSelect || i >= len(results) || results[i] == nil {
+			continue
+		}
+		table := t.schema.Table(operation.Table)
+		for j, row := range results[i].Rows {
+			results[i].Rows[j] = completeRow(table, row, operation.Columns)
+		}
+	}
+	return results, update
+}
+
+// completeRow returns the columns of the row, columns holding the default
+// value are missing in rows of the in-memory database. Like ovsdb-server, a
+// set of one element is returned as the element.
+func completeRow(table *ovsdb.TableSchema, row ovsdb.Row, columns []string) ovsdb.Row {
+	if len(columns) == 0 {
+		columns = []string{"_uuid"}
+		for name := range table.Columns {
+			columns = append(columns, name)
+		}
+	}
+	complete := ovsdb.Row{}
+	for _, name := range columns {
+		if value, found := row[name]; found {
+			if set, isSet := value.(ovsdb.OvsSet); isSet && len(set.GoSet) == 1 {
+				value = set.GoSet[0]
+			}
+			complete[name] = value
+			continue
+		}
+		column := table.Column(name)
+		if column == nil {
+			continue
+		}
+		switch column.Type {
+		case ovsdb.TypeSet:
+			complete[name] = ovsdb.OvsSet{GoSet: []interface{}{}}
+		case ovsdb.TypeMap:
+			complete[name] = ovsdb.OvsMap{GoMap: map[interface{}]interface{}{}}
+		case ovsdb.TypeInteger:
+			complete[name] = 0
+		case ovsdb.TypeReal:
+			complete[name] = 0.0
+		case ovsdb.TypeBoolean:
+			complete[name] = false
+		default:
+			complete[name] = ""
+		}
+	}
+	return complete
+}
+
+// FakeOVSDB is an in-memory OVSDB server with the part of the Open_vSwitch
+// schema used by ovs-cni, so code talking to OVSDB can be tested without
+// Open vSwitch. Nothing plays the role of ovs-vswitchd: ofport, link_state
+// and statistics of interfaces are only set by tests.
+type FakeOVSDB struct {
+	// Endpoint is the endpoint of the server, e.g. the socket_file of a
+	// network configuration
+	Endpoint string
+	// SocketPath is the path of the unix socket of the server
+	SocketPath string
+
+	server *server.OvsdbServer
+}
+
+// NewFakeOVSDB starts a server listening on a unix socket in dir, the
+// database has the Open_vSwitch row and the bridges
+func NewFakeOVSDB(dir string, bridges ...string) (*FakeOVSDB, error) {
+	var schema ovsdb.DatabaseSchema
+	if err := json.Unmarshal([]byte(fakeOvsSchema), &schema); err != nil {
+		return nil, err
+	}
+	clientModel, err := model.NewClientDBModel("Open_vSwitch", map[string]model.Model{
+		"Open_vSwitch": &fakeOpenvSwitch{},
+		"Bridge":       &fakeBridge{},
+		"Port":         &fakePort{},
+		"Interface":    &fakeInterface{},
+		"Mirror":       &fakeMirror{},
+		"QoS":          &fakeQoS{},
+		"Queue":        &fakeQueue{},
+		"Controller":   &fakeController{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	dbModel, errs := model.NewDatabaseModel(schema, clientModel)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid model of fake OVSDB: %v", errors.Join(errs...))
+	}
+	db := &fakeDatabase{Database: inmemory.NewDatabase(map[string]model.ClientDBModel{"Open_vSwitch": clientModel}), schema: schema}
+	ovsdbServer, err := server.NewOvsdbServer(db, dbModel)
+	if err != nil {
+		return nil, err
+	}
+	// the server package raises the verbosity of libovsdb to debug
+	stdr.SetVerbosity(0)
+
+	socketPath := filepath.Join(dir, "db.sock")
+	fake := &FakeOVSDB{Endpoint: "unix:" + socketPath, SocketPath: socketPath, server: ovsdbServer}
+	go func() {
+		_ = ovsdbServer.Serve("unix", socketPath)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); !ovsdbServer.Ready(); {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("fake OVSDB didn't start listening on %s", socketPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	operations := []ovsdb.Operation{{Op: ovsdb.OperationInsert, Table: "Open_vSwitch", Row: ovsdb.Row{}, UUIDName: "ovs"}}
+	for i, bridge := range bridges {
+		uuidName := fmt.Sprintf("bridge%d", i)
+		operations = append(operations,
+			ovsdb.Operation{Op: ovsdb.OperationInsert, Table: "Bridge", Row: ovsdb.Row{"name": bridge}, UUIDName: uuidName},
+			ovsdb.Operation{
+				Op:        ovsdb.OperationMutate,
+				Table:     "Open_vSwitch",
+				Mutations: []ovsdb.Mutation{*ovsdb.NewMutation("bridges", ovsdb.MutateOperationInsert, ovsdb.UUID{GoUUID: uuidName})},
+				Where:     []ovsdb.Condition{},
+			})
+	}
+	if _, err := fake.Transact(operations...); err != nil {
+		fake.Close()
+		return nil, err
+	}
+	return fake, nil
+}
+
+// Transact runs the operations in a transaction, e.g. to set columns
+// ovs-vswitchd would set. It fails when an operation fails.
+func (f *FakeOVSDB) Transact(operations ...ovsdb.Operation) ([]*ovsdb.OperationResult, error) {
+	args := []json.RawMessage{json.RawMessage(`"Open_vSwitch"`)}
+	for _, operation := range operations {
+		arg, err := json.Marshal(operation)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	var results []*ovsdb.OperationResult
+	if err := f.server.Transact(nil, args, &results); err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if result != nil && result.Error != "" {
+			return nil, fmt.Errorf("operation %d failed: %s - %s", i, result.Error, result.Details)
+		}
+	}
+	return results, nil
+}
+
+// Select returns the rows of the table matching the conditions
+func (f *FakeOVSDB) Select(table string, where ...ovsdb.Condition) ([]ovsdb.Row, error) {
+	if where == nil {
+		where = []ovsdb.Condition{}
+	}
+	results, err := f.Transact(ovsdb.Operation{Op: ovsdb.OperationSelect, Table: table, Where: where})
+	if err != nil {
+		return nil, err
+	}
+	return results[0].Rows, nil
+}
+
+// Close stops the server
+func (f *FakeOVSDB) Close() {
+	f.server.Close()
+}
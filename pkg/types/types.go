@@ -17,10 +17,23 @@
 package types
 
 import (
+	"encoding/json"
+
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 )
 
+// Error codes of the plugin next to the ones of the CNI spec, codes from 100
+// are reserved for plugins
+const (
+	// ErrOvsUnavailable is the code of ADD failed fast by the degraded mode
+	// when OVS is not available on the node
+	ErrOvsUnavailable uint = 100
+	// ErrBridgeFull is the code of ADD failed because the bridge already has
+	// as many ports of ovs-cni as allowed by its external_ids
+	ErrBridgeFull uint = 101
+)
+
 // NetConfs can be either NetConf or MirrorNetConf
 type NetConfs interface {
 	NetConf | MirrorNetConf
@@ -29,17 +42,311 @@ type NetConfs interface {
 // NetConf extends types.NetConf for ovs-cni
 type NetConf struct {
 	types.NetConf
-	BrName                 string   `json:"bridge,omitempty"`
-	VlanTag                *uint    `json:"vlan"`
-	MTU                    int      `json:"mtu"`
-	Trunk                  []*Trunk `json:"trunk,omitempty"`
-	DeviceID               string   `json:"deviceID"`       // PCI address of a VF in valid sysfs format
-	OfportRequest          uint     `json:"ofport_request"` // OpenFlow port number in range 1 to 65,279
-	InterfaceType          string   `json:"interface_type"` // The type of interface on ovs.
-	ConfigurationPath      string   `json:"configuration_path"`
-	SocketFile             string   `json:"socket_file"`
-	LinkStateCheckRetries  int      `json:"link_state_check_retries"`
-	LinkStateCheckInterval int      `json:"link_state_check_interval"`
+	BrName                 string             `json:"bridge,omitempty"`
+	VlanTag                *uint              `json:"vlan"`
+	MTU                    int                `json:"mtu"`
+	Trunk                  []*Trunk           `json:"trunk,omitempty"`
+	DeviceID               string             `json:"deviceID"`                    // PCI address of a VF in valid sysfs format
+	DeviceIDs              []string           `json:"deviceIDs,omitempty"`         // PCI addresses of two VFs bonded in the container
+	CNIDeviceInfoFile      string             `json:"CNIDeviceInfoFile,omitempty"` // device info file injected by Multus
+	FeatureGates           map[string]bool    `json:"featureGates,omitempty"`
+	OfportRequest          uint               `json:"ofport_request"` // OpenFlow port number in range 1 to 65,279
+	OfportRange            *OfportRange       `json:"ofport_range,omitempty"`
+	InterfaceType          string             `json:"interface_type"` // The type of interface on ovs.
+	ConfigurationPath      string             `json:"configuration_path"`
+	SocketFile             string             `json:"socket_file"`
+	BridgeSocketFile       string             `json:"bridge_socket_file,omitempty"` // OVSDB holding the bridge, e.g. on a DPU, socket_file by default
+	LinkStateCheckRetries  int                `json:"link_state_check_retries"`
+	LinkStateCheckInterval int                `json:"link_state_check_interval"`
+	LinkStatePolicy        string             `json:"link_state_policy,omitempty"`     // fail (default), warn or retry when the OF port doesn't come up
+	RetainOnDelete         bool               `json:"retainOnDelete,omitempty"`        // keep quarantined host interface and port on DEL
+	RetainOnDeleteTimeout  int                `json:"retainOnDeleteTimeout,omitempty"` // in seconds
+	IPAMEnv                map[string]string  `json:"ipam_env,omitempty"`              // extra environment passed to the IPAM plugin
+	IPAMPath               []string           `json:"ipam_path,omitempty"`             // directories searched for the IPAM plugin before CNI_PATH
+	IPAMV6                 *types.IPAM        `json:"ipam_v6,omitempty"`               // IPAM plugin of IPv6 addresses, ipam assigns IPv4 ones then
+	Offload                *Offload           `json:"offload,omitempty"`
+	OvsDiagnostics         bool               `json:"ovs_diagnostics,omitempty"`           // trace forwarding of the port when CHECK fails
+	DelBridgeRetries       int                `json:"del_bridge_retries,omitempty"`        // retries of DEL while the bridge is missing, before it soft-fails
+	DelBridgeRetryInterval int                `json:"del_bridge_retry_interval,omitempty"` // in milliseconds
+	MACPrefix              string             `json:"mac_prefix,omitempty"`                // prefix of generated MAC addresses, e.g. 0a:58
+	AllowedMACPrefixes     []string           `json:"allowed_mac_prefixes,omitempty"`      // prefixes requested MAC addresses must match
+	MACDerivation          string             `json:"mac_derivation,omitempty"`            // ip-hash, eui64 or pod-uid
+	OvsdbLeastPrivilege    bool               `json:"ovsdb_least_privilege,omitempty"`     // limit OVSDB operations to own ports, for RBAC restricted clients
+	OvsdbKeyPrefix         string             `json:"ovsdb_key_prefix,omitempty"`          // prepended to external_ids keys written by the plugin
+	VhostUser              *VhostUser         `json:"vhost_user,omitempty"`
+	Tap                    *Tap               `json:"tap,omitempty"`
+	StatsFile              string             `json:"stats_file,omitempty"`       // final counters of removed ports are appended to it
+	CheckReportDir         string             `json:"check_report_dir,omitempty"` // JSON reports of CHECK are written to it
+	CheckCacheTTL          int                `json:"check_cache_ttl,omitempty"`  // seconds a passed CHECK is remembered, 0 disables it
+	Mode                   string             `json:"mode,omitempty"`             // bridged (default) or routed
+	UplinkCheck            string             `json:"uplink_check,omitempty"`     // warn or fail ADD when the bridge uplink is down
+	UplinkPorts            []string           `json:"uplink_ports,omitempty"`     // uplink ports checked, detected by default
+	Representor            *Representor       `json:"representor,omitempty"`
+	Capture                *Capture           `json:"capture,omitempty"`
+	Hooks                  *Hooks             `json:"hooks,omitempty"`
+	RateLimit              *RateLimit         `json:"rate_limit,omitempty"`
+	ForcePortRemoval       bool               `json:"force_port_removal,omitempty"` // remove ports without the owner external ID of ovs-cni
+	StablePortNames        bool               `json:"stable_port_names,omitempty"`  // name host veths after the pod instead of randomly
+	PortNameTemplate       string             `json:"port_name_template,omitempty"` // e.g. {podName trunc}-{ifname}
+	OvsUnavailable         *OvsUnavailable    `json:"ovs_unavailable,omitempty"`
+	Backup                 *Backup            `json:"backup,omitempty"`
+	Bond                   *Bond              `json:"bond,omitempty"`
+	VFTuning               *VFTuning          `json:"vf_tuning,omitempty"`
+	Static                 *Static            `json:"static,omitempty"`
+	Probe                  *Probe             `json:"probe,omitempty"`
+	Garp                   *Garp              `json:"garp,omitempty"`
+	IPConflict             *IPConflict        `json:"ip_conflict,omitempty"`
+	InfraNetns             string             `json:"infra_netns,omitempty"` // netns host ends of veths are placed in, e.g. /var/run/netns/cni
+	NetworkStatus          *NetworkStatus     `json:"network_status,omitempty"`
+	UserspaceIPAM          bool               `json:"userspace_ipam,omitempty"`      // configure IPAM addresses of userspace VFs on an internal port
+	MissingPrevResult      string             `json:"missing_prev_result,omitempty"` // fail or warn, by CNI version by default
+	RuntimeConfig          *RuntimeConfig     `json:"runtimeConfig,omitempty"`
+	Args                   *Args              `json:"args,omitempty"`
+	StrictArgs             bool               `json:"strict_args,omitempty"` // reject conflicting args instead of resolving them by precedence
+	MTUCheck               string             `json:"mtu_check,omitempty"`   // warn (default), clamp or off when mtu exceeds MTU of the uplink
+	VlanTranslation        []*VlanTranslation `json:"vlan_translation,omitempty"`
+	PreserveAddresses      bool               `json:"preserve_addresses,omitempty"` // merge IPAM addresses with ones already on the container interface
+	NodeLabelsFile         string             `json:"node_labels_file,omitempty"`   // labels of the node {label:<key>} placeholders of bridge are replaced by
+	AltNames               []string           `json:"altnames,omitempty"`           // alternative names of the container interface
+	IngressRateLimit       *IngressRateLimit  `json:"ingress_rate_limit,omitempty"`
+	AcceptRA               *bool              `json:"accept_ra,omitempty"`     // accept IPv6 router advertisements on the container interface
+	IPv6Autoconf           *bool              `json:"ipv6_autoconf,omitempty"` // autoconfigure IPv6 addresses from prefixes of router advertisements
+	QoS                    *QoS               `json:"qos,omitempty"`
+	QinQ                   *QinQ              `json:"qinq,omitempty"`
+	VlanMode               string             `json:"vlan_mode,omitempty"` // vlan_mode of the port, inferred from vlan, trunk and qinq when not set
+}
+
+// NetworkStatus enables publishing of the attachment to the network-status
+// annotation of the pod by the plugin itself, e.g. when it runs in a thick
+// daemon with access to the API
+type NetworkStatus struct {
+	Kubeconfig string `json:"kubeconfig,omitempty"` // the in-cluster config is used when empty
+}
+
+// Probe of connectivity of a new attachment, the target is pinged from the
+// container after ADD and the result is logged
+type Probe struct {
+	Target  string `json:"target,omitempty"`  // address pinged, the gateway of the result by default
+	Count   int    `json:"count,omitempty"`   // echo requests sent until one is answered, 3 by default
+	Timeout int    `json:"timeout,omitempty"` // of each echo request in milliseconds, 1000 by default
+}
+
+// Garp controls gratuitous ARPs and unsolicited neighbor advertisements
+// announcing addresses of the attachment, without it a single one is sent
+type Garp struct {
+	Disabled   bool `json:"disabled,omitempty"`
+	Count      int  `json:"count,omitempty"`       // announcements of each address, 3 by default
+	Interval   int  `json:"interval,omitempty"`    // between announcements in milliseconds, 200 by default
+	DADTimeout int  `json:"dad_timeout,omitempty"` // seconds to wait for DAD of IPv6 addresses before announcing them
+}
+
+// IPConflict detects addresses returned by IPAM which are used by other hosts
+// of the network before they are configured, and replaces them
+type IPConflict struct {
+	Timeout       int `json:"timeout,omitempty"`       // to wait for replies to ARP probes in milliseconds, 1000 by default
+	Reallocations int `json:"reallocations,omitempty"` // attempts to get other addresses from IPAM, ADD fails without any
+}
+
+// Static addresses of the attachment given inline, they are passed to the
+// static IPAM plugin as if they were configured in its ipam block
+type Static struct {
+	Addresses []StaticAddress `json:"addresses"`
+	Routes    []*types.Route  `json:"routes,omitempty"`
+	DNS       *types.DNS      `json:"dns,omitempty"`
+}
+
+// StaticAddress is an address in CIDR notation with its optional gateway
+type StaticAddress struct {
+	Address string `json:"address"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// VFTuning of queues and interrupt coalescing of the VF given by deviceID,
+// applied before the VF is moved into the container where unprivileged pods
+// can't change them. Values which are not set are left unchanged.
+type VFTuning struct {
+	Channels *VFChannels `json:"channels,omitempty"`
+	Coalesce *VFCoalesce `json:"coalesce,omitempty"`
+}
+
+// VFChannels are queue counts of the VF, as set by ethtool -L
+type VFChannels struct {
+	Rx       *uint32 `json:"rx,omitempty"`
+	Tx       *uint32 `json:"tx,omitempty"`
+	Combined *uint32 `json:"combined,omitempty"`
+}
+
+// VFCoalesce is interrupt coalescing of the VF, as set by ethtool -C
+type VFCoalesce struct {
+	RxUsecs    *uint32 `json:"rx_usecs,omitempty"`
+	RxFrames   *uint32 `json:"rx_frames,omitempty"`
+	TxUsecs    *uint32 `json:"tx_usecs,omitempty"`
+	TxFrames   *uint32 `json:"tx_frames,omitempty"`
+	AdaptiveRx *bool   `json:"adaptive_rx,omitempty"`
+	AdaptiveTx *bool   `json:"adaptive_tx,omitempty"`
+}
+
+// Backup interface of an active/backup attachment, connected to a bridge of
+// a second fabric, for nodes where the fabrics can't be bonded on the host
+type Backup struct {
+	Bridge string `json:"bridge"`              // bridge of the backup fabric
+	IfName string `json:"interface,omitempty"` // name in the container, the attachment name followed by b by default
+}
+
+// Bond in the container the interface of the attachment is enslaved to,
+// attachments of the pod with the same bond name share it
+type Bond struct {
+	Name   string `json:"name"`             // name of the bond in the container
+	Mode   string `json:"mode,omitempty"`   // active-backup (default) or balance-xor
+	Miimon *uint  `json:"miimon,omitempty"` // link monitoring interval in milliseconds, 100 by default
+}
+
+// CachedBond holds interfaces of the attachments enslaved to a bond in the
+// container, the bond is removed with the last of them
+type CachedBond struct {
+	Members []string
+	// addresses configured on the bond by IPAM of a member, by its
+	// interface name, removed from the bond when the member leaves it
+	Addresses map[string][]string `json:",omitempty"`
+}
+
+// CachedCheck remembers an attachment which passed CHECK with the digest of
+// its configuration and netns, until its port changes
+type CachedCheck struct {
+	Digest string
+	Port   string
+}
+
+// OvsUnavailable is the degraded mode used when the OVSDB socket doesn't
+// exist on the node, e.g. on node pools where OVS is not installed
+type OvsUnavailable struct {
+	Action string                 `json:"action"`           // fail or delegate
+	Plugin map[string]interface{} `json:"plugin,omitempty"` // configuration of the fallback plugin ADD is delegated to, e.g. macvlan
+}
+
+// RateLimit of traffic sent by the container, policed on its port
+type RateLimit struct {
+	Rate   uint   `json:"rate"`             // in kbps
+	Burst  uint   `json:"burst,omitempty"`  // in kilobits, 10% of rate by default
+	Method string `json:"method,omitempty"` // ovs (default) or tc, tc polices on the VF representor
+}
+
+// IngressRateLimit shapes traffic received by the container by a QoS of its
+// port, exceeding traffic is queued and dropped when the queue is full
+type IngressRateLimit struct {
+	Rate  uint `json:"rate"`            // in kbps
+	Burst uint `json:"burst,omitempty"` // in kilobits, 10% of rate by default
+}
+
+// QoS of the port shaping traffic received by the container by the default
+// queue of a linux-htb or linux-hfsc QoS
+type QoS struct {
+	Type     string `json:"type,omitempty"`     // linux-htb (default) or linux-hfsc
+	MinRate  uint   `json:"min_rate,omitempty"` // in kbps, min-rate of the queue of this port only
+	MaxRate  uint   `json:"max_rate,omitempty"` // in kbps
+	Burst    uint   `json:"burst,omitempty"`    // in kilobits, linux-htb only
+	Priority *uint  `json:"priority,omitempty"` // linux-htb only, lower is served first
+}
+
+// QinQ puts the port in dot1q-tunnel mode, frames of the container are
+// tagged with the S-VLAN on top of their own C-VLAN tags
+type QinQ struct {
+	SVlan   uint   `json:"s_vlan"`            // outer VLAN ID of the port
+	EthType string `json:"ethtype,omitempty"` // of the outer tag, 802.1ad (default) or 802.1q
+}
+
+// Hooks are executables run for each attachment, e.g. to register it in an
+// external fabric. They are looked up by name in the hooks directory.
+type Hooks struct {
+	PostAdd string `json:"post_add,omitempty"` // run when the attachment is set up, ADD fails when it fails
+	PreDel  string `json:"pre_del,omitempty"`  // run before the attachment is removed, failures are only logged
+	Timeout int    `json:"timeout,omitempty"`  // in seconds, 10 by default
+}
+
+// Capture settings of the temporary mirror of the port of a new attachment,
+// used to debug early traffic like DHCP
+type Capture struct {
+	Port     string `json:"port"`               // port on the bridge receiving the mirrored traffic
+	Duration int    `json:"duration,omitempty"` // in seconds, 60 by default
+}
+
+// Representor lookup of the representor of the VF given by deviceID, for
+// sites which rename representors, e.g. by udev rules. Placeholders {uplink}
+// and {vf} are replaced by the uplink representor name and the VF index.
+type Representor struct {
+	NameTemplate string `json:"name_template,omitempty"`  // name of the representor, e.g. {uplink}_rep{vf}
+	PhysPortName string `json:"phys_port_name,omitempty"` // phys_port_name of the representor, e.g. pf0vf{vf}
+	DPUName      string `json:"dpu_name,omitempty"`       // name of the representor on the DPU, pf{pf}vf{vf} by default
+}
+
+// VhostUser settings of the socket directory created for each attachment
+// with dpdkvhostuserclient interface type
+type VhostUser struct {
+	SocketDir      string           `json:"socket_dir,omitempty"`      // parent of per attachment socket directories
+	UID            *int             `json:"uid,omitempty"`             // owner of the socket directory
+	GID            *int             `json:"gid,omitempty"`             // group of the socket directory
+	SELinuxContext string           `json:"selinux_context,omitempty"` // e.g. system_u:object_r:container_file_t:s0
+	Queues         *VhostUserQueues `json:"queues,omitempty"`
+}
+
+// OfportRange of OpenFlow port numbers, the first one not used by the
+// bridge is requested for the port of the attachment
+type OfportRange struct {
+	Min uint `json:"min"`
+	Max uint `json:"max"`
+}
+
+// Tap device named after the attachment in the container, e.g. consumed by
+// a VM of virt-launcher, connected to the veth of the attachment
+type Tap struct {
+	UID    *int `json:"uid,omitempty"`    // owner of the tap device
+	GID    *int `json:"gid,omitempty"`    // group of the tap device
+	Queues uint `json:"queues,omitempty"` // multi-queue tap device with more than 1, the consumer opens the queues
+}
+
+// VhostUserQueues are set as options of the vhost-user interface, so OVS
+// matches the PMD configuration of the pod. Values which are not set are
+// left to OVS.
+type VhostUserQueues struct {
+	NRxq     uint `json:"n_rxq,omitempty"`
+	NTxq     uint `json:"n_txq,omitempty"`
+	NRxqDesc uint `json:"n_rxq_desc,omitempty"` // power of 2 up to 4096
+	NTxqDesc uint `json:"n_txq_desc,omitempty"` // power of 2 up to 4096
+}
+
+// RuntimeConfig is passed by the runtime for capabilities of the plugin
+type RuntimeConfig struct {
+	VhostUserQueues *VhostUserQueues `json:"vhostUserQueues,omitempty"`
+	Bandwidth       *Bandwidth       `json:"bandwidth,omitempty"`
+	MAC             string           `json:"mac,omitempty"`
+}
+
+// Args of the network configuration, as described by the CNI conventions
+type Args struct {
+	CNI *CNIArgs `json:"cni,omitempty"`
+}
+
+// CNIArgs are args of the network configuration for CNI plugins
+type CNIArgs struct {
+	MAC string `json:"mac,omitempty"`
+}
+
+// Bandwidth of the bandwidth capability of CNI, ingress is traffic received
+// by the container, rates are in bits per second and bursts in bits
+type Bandwidth struct {
+	IngressRate  uint64 `json:"ingressRate,omitempty"`
+	IngressBurst uint64 `json:"ingressBurst,omitempty"`
+	EgressRate   uint64 `json:"egressRate,omitempty"`
+	EgressBurst  uint64 `json:"egressBurst,omitempty"`
+}
+
+// Offload ethtool offload settings applied to both ends of the attachment,
+// settings which are not set are left untouched
+type Offload struct {
+	TSO        *bool `json:"tso,omitempty"`
+	GSO        *bool `json:"gso,omitempty"`
+	RxChecksum *bool `json:"rx_checksum,omitempty"`
+	TxChecksum *bool `json:"tx_checksum,omitempty"`
 }
 
 // MirrorNetConf extends types.NetConf for ovs-mirrors
@@ -54,14 +361,18 @@ type MirrorNetConf struct {
 	BrName            string    `json:"bridge,omitempty"`
 	ConfigurationPath string    `json:"configuration_path"`
 	SocketFile        string    `json:"socket_file"`
+	DeviceID          string    `json:"deviceID,omitempty"` // PCI address of a VF, to mirror its representor port
 	Mirrors           []*Mirror `json:"mirrors"`
+	VerifyTraffic     int       `json:"verify_traffic,omitempty"` // seconds the consumer port is watched for mirrored packets after ADD
+	OvsdbKeyPrefix    string    `json:"ovsdb_key_prefix,omitempty"`
 }
 
 // Mirror configuration
 type Mirror struct {
-	Name    string `json:"name"`
-	Ingress bool   `json:"ingress,omitempty"`
-	Egress  bool   `json:"egress,omitempty"`
+	Name       string `json:"name"`
+	Ingress    bool   `json:"ingress,omitempty"`
+	Egress     bool   `json:"egress,omitempty"`
+	OutputVlan uint   `json:"output_vlan,omitempty"` // consumer only, mirrored traffic is flooded to this VLAN instead of sent to the port
 }
 
 // Trunk containing selective vlan IDs
@@ -71,6 +382,13 @@ type Trunk struct {
 	ID    *uint `json:"id,omitempty"`
 }
 
+// VlanTranslation maps a VLAN of the container to a VLAN of the bridge, so
+// tenants can use overlapping VLAN IDs on one bridge
+type VlanTranslation struct {
+	Container uint `json:"container"`
+	Bridge    uint `json:"bridge"`
+}
+
 // CachedNetConf containing NetConfig, original smartnic vf interface name
 // and kernel/userspace device driver mode of the smartnic vf interface
 // (the last two are set only in case of ovs hareware offload scenario).
@@ -80,6 +398,50 @@ type CachedNetConf struct {
 	Netconf       *NetConf
 	OrigIfName    string
 	UserspaceMode bool
+	// original names of the VFs of a bonded attachment, in order of their
+	// deviceIDs starting with the one of DeviceID
+	OrigIfNames []string `json:",omitempty"`
+	// attachment the cache entry belongs to, used by GC
+	ContainerID string
+	IfName      string
+	Netns       string
+	// addresses of a routed attachment, routed to it from the host
+	RoutedIPs []string
+	// ADD was delegated to the fallback plugin of ovs_unavailable
+	Delegated bool
+	// host interface of the backup interface of an active/backup attachment
+	BackupPort string
+	// how the bridge was discovered when it is not configured
+	BridgeSelection *BridgeSelection `json:",omitempty"`
+	// VLAN settings of the Netconf were changed on the live port, they
+	// take precedence over the ones of the network configuration
+	VlanUpdated bool `json:",omitempty"`
+	// netconf the IPAM plugins got on ADD, they get it on DEL as well
+	IPAMStdinData json.RawMessage `json:",omitempty"`
+	// host port of an attachment with VLAN translation, flows flooding
+	// the bridge VLANs to translated ports are built from the cache
+	VlanTranslationPort string `json:",omitempty"`
+	// internal port carrying IPAM addresses of a userspace VF attachment
+	UserspaceIPAMPort string `json:",omitempty"`
+	// channels and coalescing of VFs before vf_tuning was applied, by
+	// original name of the VF, restored when the VF is released
+	OrigVFTuning map[string]*VFTuning `json:",omitempty"`
+}
+
+// BridgeSelection records how the bridge of an attachment was discovered,
+// so surprising selections can be explained
+type BridgeSelection struct {
+	Reason     string            `json:"reason"` // configured, ovnPort or deviceID
+	DeviceID   string            `json:"deviceID,omitempty"`
+	Candidates []BridgeCandidate `json:"candidates,omitempty"` // uplinks of the VF in the order they were tried
+	Bridge     string            `json:"bridge"`
+}
+
+// BridgeCandidate is an uplink tried during discovery of the bridge
+type BridgeCandidate struct {
+	Uplink string `json:"uplink"`
+	Bridge string `json:"bridge,omitempty"`
+	Error  string `json:"error,omitempty"`
 }
 
 // CachedPrevResultNetConf containing PrevResult.
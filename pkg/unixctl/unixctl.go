@@ -0,0 +1,112 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unixctl implements a client of the control socket of OVS daemons,
+// the same interface ovs-appctl uses.
+package unixctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRunDir is the default OVS run directory
+	DefaultRunDir = "/var/run/openvswitch"
+	// VswitchdTarget is the name of the ovs-vswitchd daemon
+	VswitchdTarget = "ovs-vswitchd"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// Client is a client of an OVS daemon control socket
+type Client struct {
+	path    string
+	timeout time.Duration
+}
+
+type request struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	ID     int      `json:"id"`
+}
+
+type response struct {
+	Result *string          `json:"result"`
+	Error  *json.RawMessage `json:"error"`
+	ID     int              `json:"id"`
+}
+
+// NewClient returns a client of the control socket at the given path
+func NewClient(path string) *Client {
+	return &Client{path: path, timeout: defaultTimeout}
+}
+
+// NewTargetClient returns a client of the control socket of the daemon
+// running in runDir, e.g. ovs-vswitchd. The socket name is derived from the
+// pid file of the daemon, same as ovs-appctl -t does.
+func NewTargetClient(runDir, target string) (*Client, error) {
+	if runDir == "" {
+		runDir = DefaultRunDir
+	}
+	pidFile := filepath.Join(runDir, target+".pid")
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pid file of %s: %v", target, err)
+	}
+	pid := strings.TrimSpace(string(data))
+	if pid == "" {
+		return nil, fmt.Errorf("pid file %s is empty", pidFile)
+	}
+	return NewClient(filepath.Join(runDir, fmt.Sprintf("%s.%s.ctl", target, pid))), nil
+}
+
+// Call runs the command with the given arguments and returns its output
+func (c *Client) Call(command string, args ...string) (string, error) {
+	conn, err := net.DialTimeout("unix", c.path, c.timeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %v", c.path, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+
+	if args == nil {
+		args = []string{}
+	}
+	if err := json.NewEncoder(conn).Encode(request{Method: command, Params: args, ID: 0}); err != nil {
+		return "", fmt.Errorf("failed to send %s: %v", command, err)
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("failed to read reply to %s: %v", command, err)
+	}
+	if resp.Error != nil && string(*resp.Error) != "null" {
+		var msg string
+		if err := json.Unmarshal(*resp.Error, &msg); err != nil {
+			msg = string(*resp.Error)
+		}
+		return "", fmt.Errorf("%s failed: %s", command, strings.TrimSpace(msg))
+	}
+	if resp.Result == nil {
+		return "", nil
+	}
+	return *resp.Result, nil
+}
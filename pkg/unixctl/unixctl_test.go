@@ -0,0 +1,85 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unixctl
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// serve replies to a single request on the socket using reply
+func serve(listener net.Listener, reply func(req request) string) {
+	go func() {
+		defer GinkgoRecover()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req request
+		Expect(json.NewDecoder(conn).Decode(&req)).To(Succeed())
+		_, err = conn.Write([]byte(reply(req)))
+		Expect(err).NotTo(HaveOccurred())
+	}()
+}
+
+var _ = Describe("Unixctl", func() {
+	var (
+		tmpDir   string
+		listener net.Listener
+		err      error
+	)
+	BeforeEach(func() {
+		tmpDir, err = os.MkdirTemp("", "ovs-cni-unixctl-test*")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(tmpDir, "ovs-vswitchd.pid"), []byte("42\n"), 0644)).To(Succeed())
+		listener, err = net.Listen("unix", filepath.Join(tmpDir, "ovs-vswitchd.42.ctl"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+	AfterEach(func() {
+		listener.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+	It("should return the result of the command", func() {
+		serve(listener, func(req request) string {
+			Expect(req.Method).To(Equal("ofproto/trace"))
+			Expect(req.Params).To(Equal([]string{"br1", "in_port=1"}))
+			return `{"id":0,"error":null,"result":"Datapath actions: 2\n"}`
+		})
+		client, err := NewTargetClient(tmpDir, VswitchdTarget)
+		Expect(err).NotTo(HaveOccurred())
+		out, err := client.Call("ofproto/trace", "br1", "in_port=1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("Datapath actions: 2\n"))
+	})
+	It("should return the error of the command", func() {
+		serve(listener, func(_ request) string {
+			return `{"id":0,"error":"no bridge named br1\n","result":null}`
+		})
+		client, err := NewTargetClient(tmpDir, VswitchdTarget)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Call("ofproto/trace", "br1", "in_port=1")
+		Expect(err).To(MatchError("ofproto/trace failed: no bridge named br1"))
+	})
+	It("should fail when the daemon is not running", func() {
+		_, err := NewTargetClient(tmpDir, "ovsdb-server")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,124 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// backoffLockTimeout is how long LoadBackoff waits for other invocations
+// using the same state
+var backoffLockTimeout = 10 * time.Second
+
+type backoffEntry struct {
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+}
+
+// Backoff keeps node-local state of repeated attempts, so that separate
+// plugin invocations don't retry the same operation on every call
+type Backoff struct {
+	key     string
+	base    time.Duration
+	max     time.Duration
+	entries map[string]backoffEntry
+	lock    *Lock
+}
+
+// LoadBackoff loads backoff state saved in the cache dir under the given key,
+// delay between attempts doubles from base up to max. The state is locked
+// until Release is called, so that concurrent invocations don't lose
+// attempts of each other.
+func LoadBackoff(key string, base, max time.Duration) (*Backoff, error) {
+	lock, err := AcquireLock("backoff-"+key, backoffLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backoff{key: key, base: base, max: max, entries: map[string]backoffEntry{}, lock: lock}
+	data, err := readCacheFile(getKeyPath(key))
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+	if data == nil {
+		return b, nil
+	}
+	if err := json.Unmarshal(data, &b.entries); err != nil {
+		// state is only an optimization, start over when it is corrupted
+		b.entries = map[string]backoffEntry{}
+	}
+	return b, nil
+}
+
+// Has returns true when attempts for id are tracked
+func (b *Backoff) Has(id string) bool {
+	_, found := b.entries[id]
+	return found
+}
+
+// Allow returns true when a new attempt for id may be made
+func (b *Backoff) Allow(id string, now time.Time) bool {
+	entry, found := b.entries[id]
+	return !found || !now.Before(entry.Next)
+}
+
+// Record records an attempt for id and returns the number of attempts made so far
+func (b *Backoff) Record(id string, now time.Time) int {
+	entry := b.entries[id]
+	delay := b.base << entry.Attempts
+	if delay > b.max || delay <= 0 {
+		delay = b.max
+	}
+	entry.Attempts++
+	entry.Next = now.Add(delay)
+	b.entries[id] = entry
+	return entry.Attempts
+}
+
+// Retain forgets all ids which are not in the given list and returns how
+// many were forgotten
+func (b *Backoff) Retain(ids []string) int {
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	forgotten := 0
+	for id := range b.entries {
+		if !keep[id] {
+			delete(b.entries, id)
+			forgotten++
+		}
+	}
+	return forgotten
+}
+
+// Save stores backoff state in the cache dir
+func (b *Backoff) Save() error {
+	if len(b.entries) == 0 {
+		return removeCacheFile(getKeyPath(b.key))
+	}
+	if err := SaveCache(b.key, b.entries); err != nil {
+		return fmt.Errorf("failed to save backoff state: %v", err)
+	}
+	return nil
+}
+
+// Release releases the lock of the state, the state must not be used
+// afterwards
+func (b *Backoff) Release() error {
+	return b.lock.Release()
+}
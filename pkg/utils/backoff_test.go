@@ -0,0 +1,96 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backoff", func() {
+	var (
+		tmpDir string
+		now    time.Time
+		err    error
+	)
+	BeforeEach(func() {
+		tmpDir, err = os.MkdirTemp("", "ovs-cni-backoff-test*")
+		Expect(err).NotTo(HaveOccurred())
+		rootDir = tmpDir
+		now = time.Now()
+	})
+	AfterEach(func() {
+		rootDir = ""
+		Expect(os.RemoveAll(tmpDir)).NotTo(HaveOccurred())
+	})
+	It("should double the delay up to the maximum", func() {
+		b, err := LoadBackoff("backoff", time.Second, 3*time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		defer b.Release()
+		Expect(b.Allow("a", now)).To(BeTrue())
+		Expect(b.Record("a", now)).To(Equal(1))
+		Expect(b.Allow("a", now)).To(BeFalse())
+		Expect(b.Allow("a", now.Add(time.Second))).To(BeTrue())
+		Expect(b.Record("a", now)).To(Equal(2))
+		Expect(b.Allow("a", now.Add(time.Second))).To(BeFalse())
+		Expect(b.Allow("a", now.Add(2*time.Second))).To(BeTrue())
+		Expect(b.Record("a", now)).To(Equal(3))
+		Expect(b.Allow("a", now.Add(3*time.Second))).To(BeTrue())
+	})
+	It("should persist state across loads", func() {
+		b, err := LoadBackoff("backoff", time.Minute, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		b.Record("a", now)
+		b.Record("b", now)
+		Expect(b.Retain([]string{"a"})).To(Equal(1))
+		Expect(b.Save()).To(Succeed())
+		Expect(b.Release()).To(Succeed())
+
+		b, err = LoadBackoff("backoff", time.Minute, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		defer b.Release()
+		Expect(b.Has("a")).To(BeTrue())
+		Expect(b.Has("b")).To(BeFalse())
+		Expect(b.Allow("a", now)).To(BeFalse())
+		Expect(b.Allow("b", now)).To(BeTrue())
+	})
+	It("should lock the state until it is released", func() {
+		defer func(timeout time.Duration) { backoffLockTimeout = timeout }(backoffLockTimeout)
+		backoffLockTimeout = 50 * time.Millisecond
+		b, err := LoadBackoff("backoff", time.Minute, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = LoadBackoff("backoff", time.Minute, time.Hour)
+		Expect(err).To(MatchError(ContainSubstring("timed out waiting for lock")))
+		Expect(b.Release()).To(Succeed())
+		b, err = LoadBackoff("backoff", time.Minute, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Release()).To(Succeed())
+	})
+	It("should remove the state file when nothing is tracked", func() {
+		b, err := LoadBackoff("backoff", time.Minute, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		defer b.Release()
+		b.Record("a", now)
+		Expect(b.Save()).To(Succeed())
+		b.Retain(nil)
+		Expect(b.Save()).To(Succeed())
+		_, err = os.Stat(filepath.Join(tmpDir, DefaultCacheDir, "backoff"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
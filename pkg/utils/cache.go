@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -34,11 +35,15 @@ var (
 
 // SaveCache takes in key as string and a json encoded struct Conf and save this Conf in cache dir
 func SaveCache(key string, conf interface{}) error {
+	return writeCacheFile(getKeyPath(key), conf)
+}
+
+// writeCacheFile writes the JSON encoded conf to the file in the provided path
+func writeCacheFile(path string, conf interface{}) error {
 	confBytes, err := json.Marshal(conf)
 	if err != nil {
 		return fmt.Errorf("error serializing delegate conf: %v", err)
 	}
-	path := getKeyPath(key)
 	cacheDir := filepath.Dir(path)
 	// save the rendered conf for cmdDel
 	if err = os.MkdirAll(cacheDir, 0700); err != nil {
@@ -66,7 +71,21 @@ func ReadCache(key string) ([]byte, error) {
 		}
 	}
 	if data == nil {
-		return nil, fmt.Errorf("failed to read container data from old(%q) and current(%q) path: not found", oldPath, path)
+		return nil, fmt.Errorf("failed to read container data from old(%q) and current(%q) path: %w", oldPath, path, ErrNotCached)
+	}
+	return data, nil
+}
+
+// ReadCacheDir reads cached conf for the given key from the cache in dir
+// instead of the default cache directory
+func ReadCacheDir(dir, key string) ([]byte, error) {
+	path := filepath.Join(dir, key)
+	data, err := readCacheFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("failed to read container data from path(%q): %w", path, ErrNotCached)
 	}
 	return data, nil
 }
@@ -79,6 +98,32 @@ func CleanCache(key string) error {
 	return removeCacheFile(getOldKeyPath(key))
 }
 
+// ListCache returns keys of all conf cached on disk
+func ListCache() ([]string, error) {
+	return ListCacheDir(filepath.Join(rootDir, DefaultCacheDir))
+}
+
+// ListCacheDir returns keys of all conf cached in dir instead of the default
+// cache directory
+func ListCacheDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list container data: %v", err)
+	}
+	var keys []string
+	for _, entry := range entries {
+		// skip internal state, e.g. backoff of port cleanup
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		keys = append(keys, entry.Name())
+	}
+	return keys, nil
+}
+
 // read content from the file in the provided path, returns nil, nil
 // if file not found
 func readCacheFile(path string) ([]byte, error) {
@@ -15,8 +15,10 @@
 package utils
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -83,5 +85,93 @@ var _ = Describe("Utils", func() {
 		It("should not return error when clean called for unknown key", func() {
 			Expect(CleanCache("key1")).NotTo(HaveOccurred())
 		})
+		It("should list keys of cached data", func() {
+			keys, err := ListCache()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(BeEmpty())
+			Expect(SaveCache("key1", testConf{Data: "test"})).NotTo(HaveOccurred())
+			Expect(SaveCache("key2", testConf{Data: "test"})).NotTo(HaveOccurred())
+			Expect(SaveCache(".internal", testConf{Data: "test"})).NotTo(HaveOccurred())
+			keys, err = ListCache()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key1", "key2"))
+		})
+		It("should list keys of data cached in another dir", func() {
+			writeToCacheDir(tmpDir, "/host/cache", "key1", []byte(`{"data":"test"}`))
+			keys, err := ListCacheDir(filepath.Join(tmpDir, "/host/cache"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key1"))
+			keys, err = ListCacheDir(filepath.Join(tmpDir, "/missing"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(BeEmpty())
+		})
+		It("should load data saved by the store", func() {
+			store := NewStore[testConf]("", "", 0)
+			Expect(store.Save("key1", &testConf{Data: "test"})).NotTo(HaveOccurred())
+			data, err := ReadCache("key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"data":"test"}`))
+			conf, err := store.Load("key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.Data).To(Equal("test"))
+			Expect(store.Delete("key1")).NotTo(HaveOccurred())
+			_, err = store.Load("key1")
+			Expect(errors.Is(err, ErrNotCached)).To(BeTrue())
+		})
+		It("should load data of the store in another dir", func() {
+			writeToCacheDir(tmpDir, "/host/cache", "key1", []byte(`{"data":"test"}`))
+			store := NewStore[testConf](filepath.Join(tmpDir, "/host/cache"), "", 0)
+			conf, err := store.Load("key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.Data).To(Equal("test"))
+			_, err = NewStore[testConf]("", "", 0).Load("key1")
+			Expect(errors.Is(err, ErrNotCached)).To(BeTrue())
+		})
+		It("should not load, list and should prune expired data of the store", func() {
+			store := NewStore[testConf]("", ".test-", time.Hour)
+			Expect(store.Save("key1", &testConf{Data: "test"})).NotTo(HaveOccurred())
+			Expect(store.Save("key2", &testConf{Data: "test"})).NotTo(HaveOccurred())
+			Expect(SaveCache("key3", testConf{Data: "test"})).NotTo(HaveOccurred())
+			past := time.Now().Add(-2 * time.Hour)
+			Expect(os.Chtimes(getKeyPath(".test-key1"), past, past)).To(Succeed())
+			Expect(os.Chtimes(getKeyPath("key3"), past, past)).To(Succeed())
+
+			_, err := store.Load("key1")
+			Expect(errors.Is(err, ErrNotCached)).To(BeTrue())
+			keys, err := store.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key2"))
+			pruned, err := store.Prune()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pruned).To(ConsistOf("key1"))
+			keys, err = store.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key2"))
+			// entries of other stores are left alone
+			keys, err = ListCache()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key3"))
+		})
+		It("should scope keys of the store to its prefix", func() {
+			store := NewStore[testConf]("", ".test-", 0)
+			Expect(store.Save("key1", &testConf{Data: "test"})).NotTo(HaveOccurred())
+			Expect(SaveCache("key2", testConf{Data: "test"})).NotTo(HaveOccurred())
+			data, err := ReadCache(".test-key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"data":"test"}`))
+			keys, err := store.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key1"))
+			_, err = store.Load("key2")
+			Expect(errors.Is(err, ErrNotCached)).To(BeTrue())
+			Expect(store.Delete("key1")).NotTo(HaveOccurred())
+			keys, err = store.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(BeEmpty())
+		})
+		It("should not prune a store without prefix", func() {
+			_, err := NewStore[testConf]("", "", time.Hour).Prune()
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
@@ -0,0 +1,81 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// DefaultLockDir holds lock files of critical sections shared by
+	// concurrent invocations of the plugin on the node
+	DefaultLockDir = "/var/run/ovs-cni/locks"
+	// lockPollInterval is how often a held lock is tried again
+	lockPollInterval = 10 * time.Millisecond
+)
+
+// Lock is an exclusive lock of a named critical section held by this
+// process. It is backed by flock(2), so a lock of a crashed process is
+// released by the kernel.
+type Lock struct {
+	file *os.File
+}
+
+// AcquireLock waits until the lock of the given name is held, at most for
+// timeout. Names are file names, e.g. "bridge-br1".
+func AcquireLock(name string, timeout time.Duration) (*Lock, error) {
+	dir := filepath.Join(rootDir, DefaultLockDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory %q: %v", dir, err)
+	}
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock %q: %v", path, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return &Lock{file: file}, nil
+		}
+		if !errors.Is(err, unix.EWOULDBLOCK) && !errors.Is(err, unix.EINTR) {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %q: %v", path, err)
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("timed out waiting for lock %q held by another invocation", name)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Release releases the lock, the lock file is kept so other waiters keep
+// locking the same file. Releasing a nil or released lock does nothing.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	BeforeEach(func() {
+		tmpDir, err := os.MkdirTemp("", "ovs-cni-lock-test*")
+		Expect(err).NotTo(HaveOccurred())
+		rootDir = tmpDir
+		DeferCleanup(func() {
+			rootDir = ""
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+	})
+
+	It("should exclude other holders until it is released", func() {
+		lock, err := AcquireLock("bridge-br1", time.Second)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = AcquireLock("bridge-br1", 50*time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("timed out waiting for lock")))
+
+		other, err := AcquireLock("bridge-br2", time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Release()).To(Succeed())
+
+		acquired := make(chan error)
+		go func() {
+			defer GinkgoRecover()
+			second, err := AcquireLock("bridge-br1", 5*time.Second)
+			if err == nil {
+				err = second.Release()
+			}
+			acquired <- err
+		}()
+		Consistently(acquired, 50*time.Millisecond).ShouldNot(Receive())
+		Expect(lock.Release()).To(Succeed())
+		Eventually(acquired).Should(Receive(BeNil()))
+	})
+	It("should ignore release of a released or nil lock", func() {
+		lock, err := AcquireLock("bridge-br1", time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release()).To(Succeed())
+		Expect(lock.Release()).To(Succeed())
+		var none *Lock
+		Expect(none.Release()).To(Succeed())
+	})
+})
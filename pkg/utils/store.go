@@ -0,0 +1,176 @@
+// Copyright (c) 2024 CNI authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotCached is returned when there is no data cached for the key, or it
+// expired
+var ErrNotCached = errors.New("not found")
+
+// errUnscoped is returned by Prune of a store without a key prefix
+var errUnscoped = errors.New("store without key prefix can't be pruned")
+
+// Store is a cache of values of type T on disk, one JSON file per key named
+// by the key prefix of the store and the key. Stores sharing a directory are
+// told apart by their prefixes. Files of the store without prefix are the
+// ones of SaveCache and ReadCache, so entries written by either can be read
+// by the other. With a TTL, entries not saved within it are treated as
+// missing and removed by Prune.
+type Store[T any] struct {
+	dir    string
+	prefix string
+	ttl    time.Duration
+}
+
+// NewStore returns a store of the cache in dir, the default cache directory
+// when it is empty. Its keys are prefixed with prefix on disk, it should
+// start with "." so ListCache skips them. Entries never expire when ttl is 0.
+func NewStore[T any](dir, prefix string, ttl time.Duration) *Store[T] {
+	return &Store[T]{dir: dir, prefix: prefix, ttl: ttl}
+}
+
+// Save caches the value for the key, refreshing its TTL
+func (s *Store[T]) Save(key string, value *T) error {
+	return writeCacheFile(s.path(key), value)
+}
+
+// Load returns the value cached for the key, an error wrapping ErrNotCached
+// when there is none
+func (s *Store[T]) Load(key string) (*T, error) {
+	data, err := s.read(key)
+	if err != nil {
+		return nil, err
+	}
+	value := new(T)
+	if err := json.Unmarshal(data, value); err != nil {
+		return nil, fmt.Errorf("failed to parse container data of %q: %v", key, err)
+	}
+	return value, nil
+}
+
+// Delete removes the value cached for the key, a missing one is ignored
+func (s *Store[T]) Delete(key string) error {
+	if s.dir != "" {
+		return removeCacheFile(s.path(key))
+	}
+	return CleanCache(s.prefix + key)
+}
+
+// List returns keys of all values of the store cached and not expired
+func (s *Store[T]) List() ([]string, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	var live []string
+	for _, key := range keys {
+		if !s.expired(s.path(key)) {
+			live = append(live, key)
+		}
+	}
+	return live, nil
+}
+
+// Prune removes expired values of the store and returns their keys
+func (s *Store[T]) Prune() ([]string, error) {
+	if s.ttl == 0 {
+		return nil, nil
+	}
+	if s.prefix == "" {
+		return nil, errUnscoped
+	}
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, key := range keys {
+		if !s.expired(s.path(key)) {
+			continue
+		}
+		if err := removeCacheFile(s.path(key)); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, key)
+	}
+	return pruned, nil
+}
+
+func (s *Store[T]) read(key string) ([]byte, error) {
+	if s.expired(s.path(key)) {
+		return nil, fmt.Errorf("container data of %q expired: %w", key, ErrNotCached)
+	}
+	if s.dir != "" {
+		return ReadCacheDir(s.dir, s.prefix+key)
+	}
+	return ReadCache(s.prefix + key)
+}
+
+// keys returns keys of all values of the store, without the prefix
+func (s *Store[T]) keys() ([]string, error) {
+	if s.prefix == "" {
+		return ListCacheDir(s.cacheDir())
+	}
+	entries, err := os.ReadDir(s.cacheDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list container data: %v", err)
+	}
+	var keys []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), s.prefix) {
+			keys = append(keys, strings.TrimPrefix(entry.Name(), s.prefix))
+		}
+	}
+	return keys, nil
+}
+
+// expired returns true when the file was last written longer than the TTL
+// ago, a missing file is left to the reader
+func (s *Store[T]) expired(path string) bool {
+	if s.ttl == 0 {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return time.Since(info.ModTime()) > s.ttl
+}
+
+func (s *Store[T]) cacheDir() string {
+	if s.dir != "" {
+		return s.dir
+	}
+	return filepath.Join(rootDir, DefaultCacheDir)
+}
+
+func (s *Store[T]) path(key string) string {
+	if s.dir != "" {
+		return filepath.Join(s.dir, s.prefix+key)
+	}
+	return getKeyPath(s.prefix + key)
+}